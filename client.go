@@ -42,6 +42,9 @@ type client struct {
 
 	srcConnID  protocol.ConnectionID
 	destConnID protocol.ConnectionID
+	// In QUIC v1, the connection IDs used before and in a Retry are authenticated by the server's transport parameters.
+	origDestConnID protocol.ConnectionID
+	retrySrcConnID protocol.ConnectionID
 
 	initialVersion protocol.VersionNumber
 	version        protocol.VersionNumber
@@ -128,6 +131,9 @@ func dialContext(
 	createdPacketConn bool,
 ) (Session, error) {
 	config = populateClientConfig(config, createdPacketConn)
+	if err := validateRecordWriter(config); err != nil {
+		return nil, err
+	}
 	if !createdPacketConn {
 		for _, v := range config.Versions {
 			if v == protocol.Version44 {
@@ -246,6 +252,10 @@ func populateClientConfig(config *Config, createdPacketConn bool) *Config {
 			connIDLen = 0
 		}
 	}
+	clock := config.Clock
+	if clock == nil {
+		clock = utils.DefaultClock
+	}
 
 	return &Config{
 		Versions:                              versions,
@@ -258,6 +268,10 @@ func populateClientConfig(config *Config, createdPacketConn bool) *Config {
 		MaxIncomingStreams:                    maxIncomingStreams,
 		MaxIncomingUniStreams:                 maxIncomingUniStreams,
 		KeepAlive:                             config.KeepAlive,
+		KeyUpdateInterval:                     config.KeyUpdateInterval,
+		UseCryptoTLS:                          config.UseCryptoTLS,
+		Clock:                                 clock,
+		RecordWriter:                          config.RecordWriter,
 	}
 }
 
@@ -279,6 +293,8 @@ func (c *client) generateConnectionIDs() error {
 	}
 	c.srcConnID = srcConnID
 	c.destConnID = destConnID
+	c.origDestConnID = destConnID
+	c.retrySrcConnID = nil
 	if c.version == protocol.Version44 {
 		c.srcConnID = nil
 	}
@@ -318,19 +334,35 @@ func (c *client) dialTLS(ctx context.Context) error {
 		MaxUniStreams:               uint16(c.config.MaxIncomingUniStreams),
 		DisableMigration:            true,
 	}
-	extHandler := handshake.NewExtensionHandlerClient(params, c.initialVersion, c.config.Versions, c.version, c.logger)
-	mintConf, err := tlsToMintConfig(c.tlsConf, protocol.PerspectiveClient)
-	if err != nil {
-		return err
+	var extHandler handshake.TLSExtensionHandler
+	if c.version.UsesV1HeaderFormat() {
+		params.InitialSourceConnectionID = c.srcConnID
+		extHandler = handshake.NewExtensionHandlerClientV1(params, c.origDestConnID, c.retrySrcConnID, c.logger)
+	} else {
+		extHandler = handshake.NewExtensionHandlerClient(params, c.initialVersion, c.config.Versions, c.version, c.logger)
+	}
+	// QUIC v1 is only supported with crypto/tls
+	if c.config.UseCryptoTLS || c.version.UsesCryptoFrames() {
+		tlsConf := &tls.Config{}
+		if c.tlsConf != nil {
+			tlsConf = c.tlsConf.Clone()
+		}
+		tlsConf.ServerName = c.hostname
+		c.tlsConf = tlsConf
+	} else {
+		mintConf, err := tlsToMintConfig(c.tlsConf, protocol.PerspectiveClient)
+		if err != nil {
+			return err
+		}
+		mintConf.ExtensionHandler = extHandler
+		mintConf.ServerName = c.hostname
+		c.mintConf = mintConf
 	}
-	mintConf.ExtensionHandler = extHandler
-	mintConf.ServerName = c.hostname
-	c.mintConf = mintConf
 
-	if err := c.createNewTLSSession(extHandler.GetPeerParams(), c.version); err != nil {
+	if err := c.createNewTLSSession(extHandler, c.version); err != nil {
 		return err
 	}
-	err = c.establishSecureConnection(ctx)
+	err := c.establishSecureConnection(ctx)
 	if err == errCloseSessionForRetry || err == errCloseSessionForNewVersion {
 		return c.dial(ctx)
 	}
@@ -367,6 +399,15 @@ func (c *client) establishSecureConnection(ctx context.Context) error {
 	}
 }
 
+func (c *client) recordDatagram(remoteAddr net.Addr, data []byte) {
+	c.mutex.Lock()
+	sess := c.session
+	c.mutex.Unlock()
+	if r, ok := sess.(datagramRecorder); ok {
+		r.recordDatagram(remoteAddr, data)
+	}
+}
+
 func (c *client) handlePacket(p *receivedPacket) {
 	if err := c.handlePacketImpl(p); err != nil {
 		c.logger.Errorf("error handling packet: %s", err)
@@ -410,9 +451,18 @@ func (c *client) handlePacketImpl(p *receivedPacket) error {
 	if p.header.IsLongHeader {
 		switch p.header.Type {
 		case protocol.PacketTypeRetry:
-			c.handleRetryPacket(p.header)
+			if c.version.UsesV1HeaderFormat() {
+				c.handleRetryPacketV1(p.header)
+			} else {
+				c.handleRetryPacket(p.header)
+			}
 			return nil
 		case protocol.PacketTypeHandshake, protocol.PacketType0RTT:
+		case protocol.PacketTypeInitial:
+			// In QUIC v1, the server sends its first flight in Initial and Handshake packets.
+			if !c.version.UsesPacketNumberSpaces() {
+				return fmt.Errorf("Received unsupported packet type: %s", p.header.Type)
+			}
 		default:
 			return fmt.Errorf("Received unsupported packet type: %s", p.header.Type)
 		}
@@ -503,6 +553,35 @@ func (c *client) handleRetryPacket(hdr *wire.Header) {
 	c.session.destroy(errCloseSessionForRetry)
 }
 
+// handleRetryPacketV1 handles a QUIC v1 Retry packet.
+// Instead of echoing the original destination connection ID, it is authenticated using the Retry Integrity Tag.
+func (c *client) handleRetryPacketV1(hdr *wire.Header) {
+	c.logger.Debugf("<- Received Retry")
+	hdr.Log(c.logger)
+	if c.retrySrcConnID != nil || c.versionNegotiated {
+		c.logger.Debugf("Ignoring Retry, since we already received a Retry or a response from the server.")
+		return
+	}
+	if len(hdr.Token) == 0 {
+		c.logger.Debugf("Ignoring Retry with an empty token.")
+		return
+	}
+	// The parser reads the Retry Integrity Tag, so the header contains the whole packet.
+	if !handshake.ValidateRetryIntegrityTag(hdr.Raw, c.destConnID) {
+		c.logger.Debugf("Ignoring spoofed Retry. Integrity check failed.")
+		return
+	}
+	c.numRetries++
+	if c.numRetries > protocol.MaxRetries {
+		c.session.destroy(qerr.CryptoTooManyRejects)
+		return
+	}
+	c.retrySrcConnID = hdr.SrcConnectionID
+	c.destConnID = hdr.SrcConnectionID
+	c.token = hdr.Token
+	c.session.destroy(errCloseSessionForRetry)
+}
+
 func (c *client) createNewGQUICSession() error {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
@@ -535,7 +614,7 @@ func (c *client) createNewGQUICSession() error {
 }
 
 func (c *client) createNewTLSSession(
-	paramsChan <-chan handshake.TransportParameters,
+	extHandler handshake.TLSExtensionHandler,
 	version protocol.VersionNumber,
 ) error {
 	c.mutex.Lock()
@@ -551,8 +630,9 @@ func (c *client) createNewTLSSession(
 		c.destConnID,
 		c.srcConnID,
 		c.config,
+		c.tlsConf,
 		c.mintConf,
-		paramsChan,
+		extHandler,
 		1,
 		c.logger,
 		c.version,
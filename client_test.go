@@ -412,6 +412,8 @@ var _ = Describe("Client", func() {
 					MaxIncomingUniStreams:       4321,
 					ConnectionIDLength:          13,
 					Versions:                    supportedVersionsWithoutGQUIC44,
+					KeyUpdateInterval:           1000,
+					UseCryptoTLS:                true,
 				}
 				c := populateClientConfig(config, false)
 				Expect(c.HandshakeTimeout).To(Equal(1337 * time.Minute))
@@ -420,6 +422,8 @@ var _ = Describe("Client", func() {
 				Expect(c.MaxIncomingStreams).To(Equal(1234))
 				Expect(c.MaxIncomingUniStreams).To(Equal(4321))
 				Expect(c.ConnectionIDLength).To(Equal(13))
+				Expect(c.KeyUpdateInterval).To(BeEquivalentTo(1000))
+				Expect(c.UseCryptoTLS).To(BeTrue())
 			})
 
 			It("uses a 0 byte connection IDs if gQUIC 44 is supported", func() {
@@ -447,6 +451,11 @@ var _ = Describe("Client", func() {
 				Expect(err).To(MatchError("0x1234 is not a valid QUIC version"))
 			})
 
+			It("errors when the RecordWriter is used with versions other than QUIC v1", func() {
+				_, err := Dial(packetConn, nil, "localhost:1234", &tls.Config{}, &Config{RecordWriter: &bytes.Buffer{}})
+				Expect(err).To(MatchError(ContainSubstring("the RecordWriter can only be used with QUIC v1")))
+			})
+
 			It("disables bidirectional streams", func() {
 				config := &Config{
 					MaxIncomingStreams:    -1,
@@ -479,6 +488,19 @@ var _ = Describe("Client", func() {
 				Expect(c.HandshakeTimeout).To(Equal(protocol.DefaultHandshakeTimeout))
 				Expect(c.IdleTimeout).To(Equal(protocol.DefaultIdleTimeout))
 				Expect(c.RequestConnectionIDOmission).To(BeFalse())
+				Expect(c.Clock).To(Equal(utils.DefaultClock))
+			})
+
+			It("uses the clock from the Config", func() {
+				clock := utils.NewVirtualClock(time.Now())
+				c := populateClientConfig(&Config{Clock: clock}, false)
+				Expect(c.Clock).To(BeIdenticalTo(clock))
+			})
+
+			It("uses the RecordWriter from the Config", func() {
+				buf := &bytes.Buffer{}
+				c := populateClientConfig(&Config{RecordWriter: buf}, false)
+				Expect(c.RecordWriter).To(BeIdenticalTo(buf))
 			})
 		})
 
@@ -532,8 +554,9 @@ var _ = Describe("Client", func() {
 					_ protocol.ConnectionID,
 					_ protocol.ConnectionID,
 					configP *Config,
+					_ *tls.Config,
 					_ *mint.Config,
-					paramsChan <-chan handshake.TransportParameters,
+					_ handshake.TLSExtensionHandler,
 					_ protocol.PacketNumber,
 					_ utils.Logger,
 					versionP protocol.VersionNumber,
@@ -555,6 +578,50 @@ var _ = Describe("Client", func() {
 				Expect(conf.Versions).To(Equal(config.Versions))
 			})
 
+			It("uses crypto/tls, if configured", func() {
+				manager := NewMockPacketHandlerManager(mockCtrl)
+				manager.EXPECT().Add(connID, gomock.Any())
+				mockMultiplexer.EXPECT().AddConn(packetConn, gomock.Any()).Return(manager, nil)
+
+				config := &Config{
+					Versions:     []protocol.VersionNumber{protocol.VersionTLS},
+					UseCryptoTLS: true,
+				}
+				c := make(chan struct{})
+				var tlsConf *tls.Config
+				var mintConf *mint.Config
+				var extHandler handshake.TLSExtensionHandler
+				newTLSClientSession = func(
+					_ connection,
+					_ sessionRunner,
+					_ []byte,
+					_ protocol.ConnectionID,
+					_ protocol.ConnectionID,
+					_ *Config,
+					tlsConfP *tls.Config,
+					mintConfP *mint.Config,
+					extHandlerP handshake.TLSExtensionHandler,
+					_ protocol.PacketNumber,
+					_ utils.Logger,
+					_ protocol.VersionNumber,
+				) (quicSession, error) {
+					tlsConf = tlsConfP
+					mintConf = mintConfP
+					extHandler = extHandlerP
+					close(c)
+					sess := NewMockQuicSession(mockCtrl)
+					sess.EXPECT().run()
+					return sess, nil
+				}
+				_, err := Dial(packetConn, addr, "quic.clemente.io:1337", &tls.Config{NextProtos: []string{"proto"}}, config)
+				Expect(err).ToNot(HaveOccurred())
+				Eventually(c).Should(BeClosed())
+				Expect(mintConf).To(BeNil())
+				Expect(extHandler).ToNot(BeNil())
+				Expect(tlsConf.ServerName).To(Equal("quic.clemente.io"))
+				Expect(tlsConf.NextProtos).To(Equal([]string{"proto"}))
+			})
+
 			It("creates a new session when the server performs a retry", func() {
 				manager := NewMockPacketHandlerManager(mockCtrl)
 				manager.EXPECT().Add(gomock.Any(), gomock.Any()).Do(func(id protocol.ConnectionID, handler packetHandler) {
@@ -593,8 +660,9 @@ var _ = Describe("Client", func() {
 					_ protocol.ConnectionID,
 					_ protocol.ConnectionID,
 					_ *Config,
+					_ *tls.Config,
 					_ *mint.Config,
-					_ <-chan handshake.TransportParameters,
+					_ handshake.TLSExtensionHandler,
 					_ protocol.PacketNumber,
 					_ utils.Logger,
 					_ protocol.VersionNumber,
@@ -647,8 +715,9 @@ var _ = Describe("Client", func() {
 					_ protocol.ConnectionID,
 					_ protocol.ConnectionID,
 					_ *Config,
+					_ *tls.Config,
 					_ *mint.Config,
-					_ <-chan handshake.TransportParameters,
+					_ handshake.TLSExtensionHandler,
 					_ protocol.PacketNumber,
 					_ utils.Logger,
 					_ protocol.VersionNumber,
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"github.com/wheelcomplex/qk/internal/dump"
+)
+
+const usage = `qkdump decodes QUIC packets captured in pcap or pcapng files, or in hex dumps.
+
+It prints the headers and frames of every packet, the gQUIC handshake messages and the TLS messages of the
+IETF QUIC handshake, followed by a summary of every connection. For example:
+
+	tcpdump -i eth0 -w quic.pcap udp port 443
+	qkdump -keylog keys.log quic.pcap
+
+gQUIC packets protected by the NullAEAD and the Initial packets of IETF QUIC are always decrypted.
+The Handshake and 1-RTT packets of QUIC v1 are decrypted using the TLS secrets from a key log in the NSS format,
+as written by crypto/tls when tls.Config.KeyLogWriter is set.
+
+In hex dumps, every line contains one packet. Lines starting with < contain packets sent by the server,
+all other lines packets sent by the client.
+
+Usage: qkdump [flags] [file ...]
+
+If no file is given, the capture is read from stdin.
+
+Flags:
+`
+
+func main() {
+	keyLogFile := flag.String("keylog", os.Getenv("SSLKEYLOGFILE"), "the key log (defaults to $SSLKEYLOGFILE)")
+	forceHex := flag.Bool("hex", false, "read hex dumps, even if a file looks like a pcap file")
+	summaryOnly := flag.Bool("summary", false, "only print the summary of every connection")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	var keyLog *dump.KeyLog
+	if *keyLogFile != "" {
+		f, err := os.Open(*keyLogFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		keyLog, err = dump.ParseKeyLog(f)
+		f.Close()
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var out io.Writer = os.Stdout
+	if *summaryOnly {
+		out = ioutil.Discard
+	}
+	decoder := dump.NewDecoder(out, keyLog)
+
+	files := flag.Args()
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	for _, name := range files {
+		if err := decodeFile(decoder, name, *forceHex); err != nil {
+			log.Fatalf("%s: %s", name, err)
+		}
+	}
+	if !*summaryOnly {
+		fmt.Println()
+	}
+	decoder.WriteSummary(os.Stdout)
+}
+
+func decodeFile(decoder *dump.Decoder, name string, forceHex bool) error {
+	var r io.Reader = os.Stdin
+	if name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	var capture dump.CaptureReader
+	if forceHex {
+		capture = dump.NewHexReader(r)
+	} else {
+		var err error
+		capture, err = dump.NewCaptureReader(r)
+		if err != nil {
+			return err
+		}
+	}
+	for {
+		dg, err := capture.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		decoder.Decode(dg)
+	}
+}
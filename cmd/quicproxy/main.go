@@ -0,0 +1,228 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+
+	quicproxy "github.com/wheelcomplex/qk/integrationtests/tools/proxy"
+	"github.com/wheelcomplex/qk/internal/protocol"
+	"github.com/wheelcomplex/qk/internal/utils"
+)
+
+const usage = `quicproxy forwards UDP packets to a QUIC server, and emulates the network conditions of the link.
+
+It works in user space, and doesn't need root privileges or tc. For example, to emulate a 10 Mbit/s link with
+50ms of delay in every direction, 1% random loss and occasional reordering:
+
+	quicproxy -listen :4433 -remote server.example.com:443 \
+		-rate 10mbit -queue 64kb -delay 50ms -jitter 5ms -loss 1% -reorder 0.5% -reorder-depth 3
+
+All probabilities can be given as a percentage (1%) or as a fraction (0.01).
+Rates are given in bit/s (bit, kbit, mbit, gbit) or in byte/s (bps, kbps, mbps, gbps), sizes in bytes (b, kb, mb).
+Using the same -seed makes the random decisions reproducible.
+
+Flags:
+`
+
+func main() {
+	listenAddr := flag.String("listen", "localhost:4433", "the UDP address that the proxy listens on")
+	remoteAddr := flag.String("remote", "", "the address of the server")
+	dir := flag.String("dir", "both", "the direction that is emulated: incoming (client to server), outgoing (server to client) or both")
+	seed := flag.Int64("seed", 0, "the seed for the random decisions (random if zero)")
+	delay := flag.Duration("delay", 0, "the delay of every packet")
+	jitter := flag.Duration("jitter", 0, "the maximum random variation of the delay")
+	rate := flag.String("rate", "", "the bandwidth, e.g. 10mbit or 1mbps (unlimited if empty)")
+	burst := flag.String("burst", "", "the size of the token bucket, e.g. 32kb (a single packet if empty)")
+	queue := flag.String("queue", "", "the size of the queue before the bandwidth limit, e.g. 64kb (unlimited if empty)")
+	loss := flag.String("loss", "", "the probability that a packet is lost")
+	gemodel := flag.String("loss-gemodel", "", `Gilbert-Elliott burst loss, as "p r [1-h [1-k]]", like netem`)
+	duplicate := flag.String("duplicate", "", "the probability that a packet is duplicated")
+	corrupt := flag.String("corrupt", "", "the probability that a bit of a packet is flipped")
+	reorder := flag.String("reorder", "", "the probability that a packet is reordered")
+	reorderDepth := flag.Int("reorder-depth", 1, "the number of packets that overtake a reordered packet")
+	verbose := flag.Bool("v", false, "verbose")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *verbose {
+		utils.DefaultLogger.SetLogLevel(utils.LogLevelDebug)
+	}
+	if *remoteAddr == "" {
+		log.Fatal("missing -remote")
+	}
+
+	conf := &quicproxy.LinkConfig{
+		Delay:        *delay,
+		Jitter:       *jitter,
+		ReorderDepth: *reorderDepth,
+	}
+	var err error
+	if conf.Bandwidth, err = parseRate(*rate); err != nil {
+		log.Fatalf("invalid -rate: %s", err)
+	}
+	if conf.Burst, err = parseSize(*burst); err != nil {
+		log.Fatalf("invalid -burst: %s", err)
+	}
+	if conf.QueueSize, err = parseSize(*queue); err != nil {
+		log.Fatalf("invalid -queue: %s", err)
+	}
+	if conf.Loss, err = parseProbability(*loss); err != nil {
+		log.Fatalf("invalid -loss: %s", err)
+	}
+	if conf.GilbertElliott, err = parseGilbertElliott(*gemodel); err != nil {
+		log.Fatalf("invalid -loss-gemodel: %s", err)
+	}
+	if conf.Duplicate, err = parseProbability(*duplicate); err != nil {
+		log.Fatalf("invalid -duplicate: %s", err)
+	}
+	if conf.Corrupt, err = parseProbability(*corrupt); err != nil {
+		log.Fatalf("invalid -corrupt: %s", err)
+	}
+	if conf.Reorder, err = parseProbability(*reorder); err != nil {
+		log.Fatalf("invalid -reorder: %s", err)
+	}
+
+	opts := &quicproxy.Opts{RemoteAddr: *remoteAddr, Seed: *seed}
+	switch *dir {
+	case "incoming":
+		opts.Incoming = conf
+	case "outgoing":
+		opts.Outgoing = conf
+	case "both":
+		incoming := *conf
+		outgoing := *conf
+		opts.Incoming = &incoming
+		opts.Outgoing = &outgoing
+	default:
+		log.Fatalf("invalid -dir: %s", *dir)
+	}
+
+	p, err := quicproxy.NewQuicProxy(*listenAddr, protocol.VersionWhatever, opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer p.Close()
+	log.Printf("Proxying %s <-> %s", p.LocalAddr(), *remoteAddr)
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	<-interrupt
+}
+
+// parseProbability parses a probability, given as a percentage (e.g. 1.5%) or as a fraction (e.g. 0.015)
+func parseProbability(s string) (float64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	var p float64
+	var err error
+	if strings.HasSuffix(s, "%") {
+		p, err = strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+		p /= 100
+	} else {
+		p, err = strconv.ParseFloat(s, 64)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if p < 0 || p > 1 {
+		return 0, fmt.Errorf("probability %s not between 0 and 1", s)
+	}
+	return p, nil
+}
+
+// parseGilbertElliott parses the parameters of the Gilbert-Elliott model in the format used by netem:
+// p r [1-h [1-k]], where 1-h is the loss probability in the bad state (100% if omitted),
+// and 1-k is the loss probability in the good state (0% if omitted).
+func parseGilbertElliott(s string) (*quicproxy.GilbertElliott, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	if len(fields) < 2 || len(fields) > 4 {
+		return nil, errors.New(`expected "p r [1-h [1-k]]"`)
+	}
+	probs := []float64{0, 0, 1, 0}
+	for i, f := range fields {
+		p, err := parseProbability(f)
+		if err != nil {
+			return nil, err
+		}
+		probs[i] = p
+	}
+	return &quicproxy.GilbertElliott{P: probs[0], R: probs[1], LossBad: probs[2], LossGood: probs[3]}, nil
+}
+
+// rateUnits maps the units of rates to their value in bytes per second.
+// As in tc, bit is a rate in bits per second, and bps is a rate in bytes per second.
+var rateUnits = map[string]float64{
+	"bit":  1.0 / 8,
+	"kbit": 1e3 / 8,
+	"mbit": 1e6 / 8,
+	"gbit": 1e9 / 8,
+	"bps":  1,
+	"kbps": 1e3,
+	"mbps": 1e6,
+	"gbps": 1e9,
+}
+
+// sizeUnits maps the units of sizes to their value in bytes
+var sizeUnits = map[string]float64{
+	"":   1,
+	"b":  1,
+	"kb": 1 << 10,
+	"mb": 1 << 20,
+}
+
+// parseRate parses a rate, e.g. 10mbit, and returns it in bytes per second
+func parseRate(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	v, err := parseWithUnit(s, rateUnits)
+	if err != nil {
+		return 0, err
+	}
+	if v < 1 {
+		return 0, fmt.Errorf("rate %s too small", s)
+	}
+	return int64(v), nil
+}
+
+// parseSize parses a size, e.g. 64kb, and returns it in bytes
+func parseSize(s string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+	v, err := parseWithUnit(s, sizeUnits)
+	if err != nil {
+		return 0, err
+	}
+	return int(v), nil
+}
+
+func parseWithUnit(s string, units map[string]float64) (float64, error) {
+	s = strings.ToLower(s)
+	i := strings.IndexFunc(s, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+	if i < 0 {
+		i = len(s)
+	}
+	unit, ok := units[s[i:]]
+	if !ok {
+		return 0, fmt.Errorf("unknown unit %q", s[i:])
+	}
+	v, err := strconv.ParseFloat(s[:i], 64)
+	if err != nil {
+		return 0, err
+	}
+	return v * unit, nil
+}
@@ -0,0 +1,191 @@
+package quic
+
+import (
+	"io"
+	"sync"
+
+	"github.com/wheelcomplex/qk/internal/protocol"
+	"github.com/wheelcomplex/qk/internal/wire"
+	"github.com/wheelcomplex/qk/qerr"
+)
+
+// A cryptoStreamV1 carries the handshake data of one encryption level.
+// In QUIC v1, handshake data is sent in CRYPTO frames.
+// These frames are not subject to flow control, and can't be reset.
+type cryptoStreamV1 struct {
+	mutex sync.Mutex
+
+	queue    *frameSorter
+	readBuf  []byte
+	readChan chan struct{}
+
+	writeBuf    []byte
+	writeOffset protocol.ByteCount
+
+	closeErr error
+	// called when new data is available for sending
+	onHasData func()
+}
+
+var _ io.ReadWriter = &cryptoStreamV1{}
+
+func newCryptoStreamV1(onHasData func()) *cryptoStreamV1 {
+	return &cryptoStreamV1{
+		queue:     newFrameSorter(),
+		readChan:  make(chan struct{}, 1),
+		onHasData: onHasData,
+	}
+}
+
+func (s *cryptoStreamV1) handleCryptoFrame(f *wire.CryptoFrame) error {
+	highestOffset := f.Offset + protocol.ByteCount(len(f.Data))
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if highestOffset > s.queue.readPos+protocol.MaxCryptoStreamOffset {
+		return qerr.Error(qerr.FlowControlReceivedTooMuchData, "received too much data on the crypto stream")
+	}
+	if err := s.queue.Push(f.Data, f.Offset, false); err != nil {
+		return err
+	}
+	s.signalRead()
+	return nil
+}
+
+// Read reads handshake data.
+// It blocks until data is available, or the stream is closed.
+func (s *cryptoStreamV1) Read(p []byte) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for {
+		if s.closeErr != nil {
+			return 0, s.closeErr
+		}
+		if len(s.readBuf) == 0 {
+			s.readBuf, _ = s.queue.Pop()
+		}
+		if len(s.readBuf) > 0 {
+			n := copy(p, s.readBuf)
+			s.readBuf = s.readBuf[n:]
+			return n, nil
+		}
+		s.mutex.Unlock()
+		<-s.readChan
+		s.mutex.Lock()
+	}
+}
+
+// Write queues handshake data for sending.
+// It never blocks.
+func (s *cryptoStreamV1) Write(p []byte) (int, error) {
+	s.mutex.Lock()
+	if s.closeErr != nil {
+		s.mutex.Unlock()
+		return 0, s.closeErr
+	}
+	s.writeBuf = append(s.writeBuf, p...)
+	s.mutex.Unlock()
+	s.onHasData()
+	return len(p), nil
+}
+
+func (s *cryptoStreamV1) hasData() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.writeBuf) > 0
+}
+
+// popCryptoFrame returns a CRYPTO frame that is at most maxLen bytes long.
+// It returns nil if there's no data to send, or if the frame wouldn't fit.
+func (s *cryptoStreamV1) popCryptoFrame(maxLen protocol.ByteCount) *wire.CryptoFrame {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	f := &wire.CryptoFrame{Offset: s.writeOffset}
+	n := f.MaxDataLen(maxLen)
+	if n == 0 || len(s.writeBuf) == 0 {
+		return nil
+	}
+	if n > protocol.ByteCount(len(s.writeBuf)) {
+		n = protocol.ByteCount(len(s.writeBuf))
+	}
+	f.Data = make([]byte, n)
+	copy(f.Data, s.writeBuf)
+	s.writeBuf = s.writeBuf[n:]
+	s.writeOffset += n
+	return f
+}
+
+// closeForShutdown makes all pending and future calls to Read and Write return the error
+func (s *cryptoStreamV1) closeForShutdown(err error) {
+	s.mutex.Lock()
+	if s.closeErr == nil {
+		s.closeErr = err
+	}
+	s.mutex.Unlock()
+	s.signalRead()
+}
+
+func (s *cryptoStreamV1) signalRead() {
+	select {
+	case s.readChan <- struct{}{}:
+	default:
+	}
+}
+
+// The cryptoStreamManager manages the crypto streams of the Initial, the Handshake and the 1-RTT encryption level.
+type cryptoStreamManager struct {
+	initialStream   *cryptoStreamV1
+	handshakeStream *cryptoStreamV1
+	oneRTTStream    *cryptoStreamV1
+}
+
+func newCryptoStreamManager(onHasData func()) *cryptoStreamManager {
+	return &cryptoStreamManager{
+		initialStream:   newCryptoStreamV1(onHasData),
+		handshakeStream: newCryptoStreamV1(onHasData),
+		oneRTTStream:    newCryptoStreamV1(onHasData),
+	}
+}
+
+func (m *cryptoStreamManager) getCryptoStream(encLevel protocol.EncryptionLevel) *cryptoStreamV1 {
+	switch encLevel {
+	case protocol.EncryptionInitial:
+		return m.initialStream
+	case protocol.EncryptionHandshake:
+		return m.handshakeStream
+	case protocol.EncryptionForwardSecure:
+		return m.oneRTTStream
+	default:
+		return nil
+	}
+}
+
+func (m *cryptoStreamManager) HandleCryptoFrame(f *wire.CryptoFrame, encLevel protocol.EncryptionLevel) error {
+	str := m.getCryptoStream(encLevel)
+	if str == nil {
+		return qerr.Error(qerr.CryptoEncryptionLevelIncorrect, "received CRYPTO frame with unexpected encryption level")
+	}
+	return str.handleCryptoFrame(f)
+}
+
+func (m *cryptoStreamManager) HasData(encLevel protocol.EncryptionLevel) bool {
+	if str := m.getCryptoStream(encLevel); str != nil {
+		return str.hasData()
+	}
+	return false
+}
+
+func (m *cryptoStreamManager) PopCryptoFrame(encLevel protocol.EncryptionLevel, maxLen protocol.ByteCount) *wire.CryptoFrame {
+	if str := m.getCryptoStream(encLevel); str != nil {
+		return str.popCryptoFrame(maxLen)
+	}
+	return nil
+}
+
+func (m *cryptoStreamManager) closeForShutdown(err error) {
+	m.initialStream.closeForShutdown(err)
+	m.handshakeStream.closeForShutdown(err)
+	m.oneRTTStream.closeForShutdown(err)
+}
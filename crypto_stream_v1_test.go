@@ -0,0 +1,80 @@
+package quic
+
+import (
+	"errors"
+
+	"github.com/wheelcomplex/qk/internal/protocol"
+	"github.com/wheelcomplex/qk/internal/wire"
+	"github.com/wheelcomplex/qk/qerr"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Crypto Stream Manager", func() {
+	var (
+		m          *cryptoStreamManager
+		hasDataCnt int
+	)
+
+	BeforeEach(func() {
+		hasDataCnt = 0
+		m = newCryptoStreamManager(func() { hasDataCnt++ })
+	})
+
+	It("reads data received in CRYPTO frames, in order", func() {
+		Expect(m.HandleCryptoFrame(&wire.CryptoFrame{Offset: 3, Data: []byte("bar")}, protocol.EncryptionHandshake)).To(Succeed())
+		Expect(m.HandleCryptoFrame(&wire.CryptoFrame{Data: []byte("foo")}, protocol.EncryptionHandshake)).To(Succeed())
+		b := make([]byte, 6)
+		n, err := m.handshakeStream.Read(b)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(b[:n]).To(Equal([]byte("foo")))
+		n, err = m.handshakeStream.Read(b)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(b[:n]).To(Equal([]byte("bar")))
+	})
+
+	It("rejects CRYPTO frames at an unexpected encryption level", func() {
+		err := m.HandleCryptoFrame(&wire.CryptoFrame{Data: []byte("foo")}, protocol.EncryptionUnencrypted)
+		Expect(err).To(MatchError(qerr.Error(qerr.CryptoEncryptionLevelIncorrect, "received CRYPTO frame with unexpected encryption level")))
+	})
+
+	It("rejects too much buffered data", func() {
+		err := m.HandleCryptoFrame(&wire.CryptoFrame{Offset: protocol.MaxCryptoStreamOffset, Data: []byte("foo")}, protocol.EncryptionInitial)
+		Expect(err).To(MatchError(qerr.Error(qerr.FlowControlReceivedTooMuchData, "received too much data on the crypto stream")))
+	})
+
+	It("pops CRYPTO frames for the encryption level", func() {
+		_, err := m.initialStream.Write([]byte("foobar"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(hasDataCnt).To(Equal(1))
+		Expect(m.HasData(protocol.EncryptionInitial)).To(BeTrue())
+		Expect(m.HasData(protocol.EncryptionHandshake)).To(BeFalse())
+		f := m.PopCryptoFrame(protocol.EncryptionInitial, 1+1+1+3)
+		Expect(f).ToNot(BeNil())
+		Expect(f.Offset).To(BeZero())
+		Expect(f.Data).To(Equal([]byte("foo")))
+		f = m.PopCryptoFrame(protocol.EncryptionInitial, 100)
+		Expect(f).ToNot(BeNil())
+		Expect(f.Offset).To(Equal(protocol.ByteCount(3)))
+		Expect(f.Data).To(Equal([]byte("bar")))
+		Expect(m.HasData(protocol.EncryptionInitial)).To(BeFalse())
+		Expect(m.PopCryptoFrame(protocol.EncryptionInitial, 100)).To(BeNil())
+	})
+
+	It("unblocks Read when closed", func() {
+		testErr := errors.New("test error")
+		done := make(chan struct{})
+		go func() {
+			defer GinkgoRecover()
+			_, err := m.oneRTTStream.Read(make([]byte, 10))
+			Expect(err).To(MatchError(testErr))
+			close(done)
+		}()
+		Consistently(done).ShouldNot(BeClosed())
+		m.closeForShutdown(testErr)
+		Eventually(done).Should(BeClosed())
+		_, err := m.oneRTTStream.Write([]byte("foo"))
+		Expect(err).To(MatchError(testErr))
+	})
+})
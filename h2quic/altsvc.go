@@ -0,0 +1,159 @@
+package h2quic
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	quic "github.com/wheelcomplex/qk"
+)
+
+// defaultAltSvcMaxAge is the freshness lifetime of an alternative service, if no ma parameter is sent.
+// See RFC 7838, section 3.1.
+const defaultAltSvcMaxAge = 24 * time.Hour
+
+// altSvcProtocolQUIC is the protocol ID that SetQuicHeaders advertises.
+const altSvcProtocolQUIC = "quic"
+
+// An altSvc is an alternative service, as advertised in the Alt-Svc header field (RFC 7838).
+type altSvc struct {
+	protocol string
+	// the host is empty if the alternative service uses the host of the origin
+	host string
+	port string
+	// the versions are sent in the v parameter, as defined by gQUIC
+	versions []string
+	maxAge   time.Duration
+}
+
+// parseAltSvc parses the value of an Alt-Svc header field.
+// It returns clear = true if the value is the special value "clear",
+// which invalidates all alternative services of the origin.
+func parseAltSvc(value string) (alternatives []altSvc, clear bool, err error) {
+	value = strings.TrimSpace(value)
+	if value == "clear" {
+		return nil, true, nil
+	}
+	for _, alt := range splitQuoted(value, ',') {
+		alt = strings.TrimSpace(alt)
+		if alt == "" {
+			continue
+		}
+		params := splitQuoted(alt, ';')
+		protocolID, authority, err := parseAltSvcParam(params[0])
+		if err != nil {
+			return nil, false, err
+		}
+		protocolID, err = url.PathUnescape(protocolID)
+		if err != nil {
+			return nil, false, fmt.Errorf("invalid protocol ID: %s", err)
+		}
+		host, port, err := net.SplitHostPort(authority)
+		if err != nil {
+			return nil, false, fmt.Errorf("invalid alternative authority %q: %s", authority, err)
+		}
+		a := altSvc{
+			protocol: protocolID,
+			host:     host,
+			port:     port,
+			maxAge:   defaultAltSvcMaxAge,
+		}
+		for _, p := range params[1:] {
+			key, val, err := parseAltSvcParam(p)
+			if err != nil {
+				return nil, false, err
+			}
+			switch strings.ToLower(key) {
+			case "ma":
+				ma, err := strconv.ParseUint(val, 10, 32)
+				if err != nil {
+					return nil, false, fmt.Errorf("invalid max-age: %s", val)
+				}
+				a.maxAge = time.Duration(ma) * time.Second
+			case "v":
+				for _, v := range strings.Split(val, ",") {
+					if v = strings.TrimSpace(v); v != "" {
+						a.versions = append(a.versions, v)
+					}
+				}
+			}
+		}
+		alternatives = append(alternatives, a)
+	}
+	return alternatives, false, nil
+}
+
+// parseAltSvcParam parses a key=value pair. The value may be a quoted string.
+func parseAltSvcParam(p string) (string, string, error) {
+	i := strings.IndexByte(p, '=')
+	if i <= 0 {
+		return "", "", fmt.Errorf("invalid Alt-Svc parameter: %q", p)
+	}
+	key := strings.TrimSpace(p[:i])
+	val := strings.TrimSpace(p[i+1:])
+	if strings.HasPrefix(val, `"`) {
+		if len(val) < 2 || !strings.HasSuffix(val, `"`) {
+			return "", "", fmt.Errorf("invalid quoted string: %s", val)
+		}
+		val = strings.Replace(val[1:len(val)-1], `\`, "", -1)
+	}
+	return key, val, nil
+}
+
+// splitQuoted splits s at every occurrence of sep that is not inside a quoted string.
+func splitQuoted(s string, sep byte) []string {
+	var parts []string
+	var inQuotes, escaped bool
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case escaped:
+			escaped = false
+		case inQuotes && s[i] == '\\':
+			escaped = true
+		case s[i] == '"':
+			inQuotes = !inQuotes
+		case s[i] == sep && !inQuotes:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// matchAltSvcVersions returns the versions supported by us and the alternative service.
+// If the alternative service doesn't list any versions, all our versions are returned.
+func matchAltSvcVersions(altVersions []string, supported []quic.VersionNumber) []quic.VersionNumber {
+	if len(altVersions) == 0 {
+		return supported
+	}
+	var versions []quic.VersionNumber
+	for _, v := range supported {
+		for _, av := range altVersions {
+			if v.ToAltSvc() == av {
+				versions = append(versions, v)
+				break
+			}
+		}
+	}
+	return versions
+}
+
+// an altSvcEntry is an alternative service cached for an origin
+type altSvcEntry struct {
+	altSvc
+	versions []quic.VersionNumber
+	expires  time.Time
+}
+
+// brokenAltSvc is used to back off from using an alternative service after a QUIC connection failed
+type brokenAltSvc struct {
+	numFailures int
+	until       time.Time
+}
+
+var errNoAltSvc = errors.New("h2quic: no alternative service for this origin")
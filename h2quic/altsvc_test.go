@@ -0,0 +1,214 @@
+package h2quic
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	quic "github.com/wheelcomplex/qk"
+	"github.com/wheelcomplex/qk/internal/protocol"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type mockTransport struct {
+	requests []*http.Request
+	bodies   []string
+	header   http.Header
+}
+
+func (t *mockTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.requests = append(t.requests, req)
+	if req.Body != nil {
+		b, _ := ioutil.ReadAll(req.Body)
+		t.bodies = append(t.bodies, string(b))
+	}
+	return &http.Response{StatusCode: 200, Header: t.header, Request: req}, nil
+}
+
+var _ = Describe("Alt-Svc", func() {
+	Context("parsing", func() {
+		It("parses the header set by SetQuicHeaders", func() {
+			alts, clear, err := parseAltSvc(`quic=":443"; ma=2592000; v="44,43,39"`)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(clear).To(BeFalse())
+			Expect(alts).To(Equal([]altSvc{{
+				protocol: "quic",
+				port:     "443",
+				versions: []string{"44", "43", "39"},
+				maxAge:   2592000 * time.Second,
+			}}))
+		})
+
+		It("parses multiple alternatives, with a host and the default max-age", func() {
+			alts, _, err := parseAltSvc(`h2="alt.example.com:8000", quic="[::1]:4433"; persist=1`)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(alts).To(HaveLen(2))
+			Expect(alts[0].protocol).To(Equal("h2"))
+			Expect(alts[0].host).To(Equal("alt.example.com"))
+			Expect(alts[0].port).To(Equal("8000"))
+			Expect(alts[1].protocol).To(Equal("quic"))
+			Expect(alts[1].host).To(Equal("::1"))
+			Expect(alts[1].maxAge).To(Equal(defaultAltSvcMaxAge))
+		})
+
+		It("parses percent-encoded protocol IDs", func() {
+			alts, _, err := parseAltSvc(`w%3Dx%3Ay="foo:80"`)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(alts[0].protocol).To(Equal("w=x:y"))
+		})
+
+		It("parses clear", func() {
+			_, clear, err := parseAltSvc(" clear ")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(clear).To(BeTrue())
+		})
+
+		It("errors on invalid values", func() {
+			_, _, err := parseAltSvc(`quic`)
+			Expect(err).To(HaveOccurred())
+			_, _, err = parseAltSvc(`quic=":443`)
+			Expect(err).To(HaveOccurred())
+			_, _, err = parseAltSvc(`quic="443"`)
+			Expect(err).To(HaveOccurred())
+			_, _, err = parseAltSvc(`quic=":443"; ma=foo`)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("matches versions", func() {
+			supported := []quic.VersionNumber{protocol.Version44, protocol.Version39}
+			Expect(matchAltSvcVersions([]string{"43", "39"}, supported)).To(Equal([]quic.VersionNumber{protocol.Version39}))
+			Expect(matchAltSvcVersions(nil, supported)).To(Equal(supported))
+			Expect(matchAltSvcVersions([]string{"42"}, supported)).To(BeEmpty())
+		})
+	})
+
+	Context("RoundTripper", func() {
+		var (
+			rt           *AltSvcRoundTripper
+			transport    *mockTransport
+			origDialAddr = dialAddr
+			dialedAddr   string
+			dialedConf   *quic.Config
+			dialedTLS    *tls.Config
+			dialErr      error
+		)
+
+		BeforeEach(func() {
+			transport = &mockTransport{header: http.Header{}}
+			rt = &AltSvcRoundTripper{Transport: transport}
+			origDialAddr = dialAddr
+			dialedAddr = ""
+			dialErr = errors.New("dial error")
+			dialAddr = func(addr string, tlsConf *tls.Config, config *quic.Config) (quic.Session, error) {
+				dialedAddr = addr
+				dialedTLS = tlsConf
+				dialedConf = config
+				return nil, dialErr
+			}
+		})
+
+		AfterEach(func() {
+			dialAddr = origDialAddr
+		})
+
+		It("uses TCP if no alternative service is known", func() {
+			req, err := http.NewRequest("GET", "https://www.example.org/", nil)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = rt.RoundTrip(req)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(transport.requests).To(HaveLen(1))
+			Expect(dialedAddr).To(BeEmpty())
+		})
+
+		It("caches alternative services, and dials them", func() {
+			transport.header.Set("Alt-Svc", `quic=":4433"; ma=100; v="44,39"`)
+			req, err := http.NewRequest("GET", "https://www.example.org/", nil)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = rt.RoundTrip(req)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(rt.altSvcs).To(HaveKey("www.example.org:443"))
+			_, err = rt.RoundTrip(req)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(dialedAddr).To(Equal("www.example.org:4433"))
+			Expect(dialedTLS.ServerName).To(Equal("www.example.org"))
+			Expect(dialedConf.Versions).To(Equal([]quic.VersionNumber{protocol.Version44, protocol.Version39}))
+		})
+
+		It("ignores alternative services without a common version", func() {
+			transport.header.Set("Alt-Svc", `quic=":4433"; v="42"`)
+			req, err := http.NewRequest("GET", "https://www.example.org/", nil)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = rt.RoundTrip(req)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(rt.altSvcs).To(BeEmpty())
+		})
+
+		It("clears alternative services", func() {
+			transport.header.Set("Alt-Svc", `quic=":4433"`)
+			req, err := http.NewRequest("GET", "https://www.example.org/", nil)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = rt.RoundTrip(req)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(rt.altSvcs).To(HaveLen(1))
+			transport.header.Set("Alt-Svc", "clear")
+			_, err = rt.RoundTrip(req)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(rt.altSvcs).To(BeEmpty())
+		})
+
+		It("doesn't use expired alternative services", func() {
+			transport.header.Set("Alt-Svc", `quic=":4433"; ma=0`)
+			req, err := http.NewRequest("GET", "https://www.example.org/", nil)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = rt.RoundTrip(req)
+			Expect(err).ToNot(HaveOccurred())
+			transport.header.Del("Alt-Svc")
+			_, err = rt.RoundTrip(req)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(dialedAddr).To(BeEmpty())
+			Expect(rt.altSvcs).To(BeEmpty())
+		})
+
+		It("falls back to TCP, and marks the alternative service as broken", func() {
+			transport.header.Set("Alt-Svc", `quic=":4433"`)
+			req, err := http.NewRequest("POST", "https://www.example.org/", bytes.NewReader([]byte("foobar")))
+			Expect(err).ToNot(HaveOccurred())
+			_, err = rt.RoundTrip(req)
+			Expect(err).ToNot(HaveOccurred())
+			req, err = http.NewRequest("POST", "https://www.example.org/", bytes.NewReader([]byte("foobar")))
+			Expect(err).ToNot(HaveOccurred())
+			_, err = rt.RoundTrip(req)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(dialedAddr).To(Equal("www.example.org:4433"))
+			Expect(transport.bodies).To(Equal([]string{"foobar", "foobar"}))
+			Expect(rt.broken).To(HaveKey("www.example.org:443"))
+			Expect(rt.quic.clients).To(BeEmpty())
+			// don't use QUIC while the alternative service is marked as broken
+			dialedAddr = ""
+			_, err = rt.RoundTrip(req)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(dialedAddr).To(BeEmpty())
+		})
+
+		It("doubles the backoff for consecutive failures", func() {
+			rt.BrokenAltSvcBackoff = time.Minute
+			rt.MaxBrokenAltSvcBackoff = 3 * time.Minute
+			rt.initOnce.Do(rt.init)
+			now := time.Now()
+			origin := "www.example.org:443"
+			rt.markBroken(origin, now)
+			Expect(rt.broken[origin].until).To(Equal(now.Add(time.Minute)))
+			rt.markBroken(origin, now)
+			Expect(rt.broken[origin].until).To(Equal(now.Add(2 * time.Minute)))
+			rt.markBroken(origin, now)
+			Expect(rt.broken[origin].until).To(Equal(now.Add(3 * time.Minute)))
+			rt.markWorking(origin)
+			Expect(rt.broken).To(BeEmpty())
+		})
+	})
+})
@@ -1,14 +1,18 @@
 package h2quic
 
 import (
+	"context"
 	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net"
 	"net/http"
+	"net/http/httptrace"
 	"strings"
 	"sync"
+	"time"
 
 	"golang.org/x/net/http2"
 	"golang.org/x/net/http2/hpack"
@@ -21,7 +25,12 @@ import (
 )
 
 type roundTripperOpts struct {
-	DisableCompression bool
+	DisableCompression        bool
+	MaxResponseHeaderBytes    int64
+	MaxDecoderHeaderTableSize uint32
+	MaxEncoderHeaderTableSize uint32
+	IdleConnTimeout           time.Duration
+	PushHandler               func(*http.Request, *http.Response)
 }
 
 var dialAddr = quic.DialAddr
@@ -37,20 +46,43 @@ type client struct {
 	hostname     string
 	handshakeErr error
 	dialOnce     sync.Once
+	dialed       bool // set when dialing completed (successfully or not)
 	dialer       func(network, addr string, tlsCfg *tls.Config, cfg *quic.Config) (quic.Session, error)
 
+	activeRequests int       // requests that were started, and whose response body is still in use
+	idleSince      time.Time // when the last request finished
+	idleTimer      *time.Timer
+	closedIdle     bool // set when the client was closed because it was idle
+
 	session       quic.Session
 	headerStream  quic.Stream
 	headerErr     *qerr.QuicError
 	headerErrored chan struct{} // this channel is closed if an error occurs on the header stream
 	requestWriter *requestWriter
+	settings      *peerSettings // the settings sent by the server
 
 	responses map[protocol.StreamID]chan *http.Response
+	trailers  map[protocol.StreamID]*trailerReceiver
+	pushes    map[protocol.StreamID]*pushPromise // indexed by the promised stream ID
 
 	logger utils.Logger
 }
 
-var _ http.RoundTripper = &client{}
+var _ pooledClient = &client{}
+
+// errStreamLimitReached is returned by roundTripOpt if the peer's stream limit doesn't allow opening a new stream,
+// and roundTripOpt is not allowed to block
+var errStreamLimitReached = errors.New("h2quic: stream limit reached")
+
+var errClientClosedIdle = errors.New("h2quic: client was closed because it was idle")
+
+// A sessionClosedError is returned by roundTripOpt if the session was closed before the request was sent.
+// The request can then safely be retried on a different session.
+type sessionClosedError struct {
+	err error
+}
+
+func (e *sessionClosedError) Error() string { return e.err.Error() }
 
 var defaultQuicConfig = &quic.Config{
 	RequestConnectionIDOmission: true,
@@ -72,23 +104,28 @@ func newClient(
 	return &client{
 		hostname:      authorityAddr("https", hostname),
 		responses:     make(map[protocol.StreamID]chan *http.Response),
+		trailers:      make(map[protocol.StreamID]*trailerReceiver),
+		pushes:        make(map[protocol.StreamID]*pushPromise),
 		tlsConf:       tlsConfig,
 		config:        config,
 		opts:          opts,
 		headerErrored: make(chan struct{}),
 		dialer:        dialer,
+		settings:      newPeerSettings(opts.MaxEncoderHeaderTableSize),
 		logger:        utils.DefaultLogger.WithPrefix("client"),
 	}
 }
 
 // dial dials the connection
-func (c *client) dial() error {
+func (c *client) dial(trace *httptrace.ClientTrace, qtrace *ClientTrace) error {
 	var err error
+	traceHandshakeStart(trace)
 	if c.dialer != nil {
 		c.session, err = c.dialer("udp", c.hostname, c.tlsConf, c.config)
 	} else {
 		c.session, err = dialAddr(c.hostname, c.tlsConf, c.config)
 	}
+	traceHandshakeDone(trace, qtrace, c.session, err)
 	if err != nil {
 		return err
 	}
@@ -99,12 +136,23 @@ func (c *client) dial() error {
 		return err
 	}
 	c.requestWriter = newRequestWriter(c.headerStream, c.logger)
+	c.requestWriter.SetMaxDynamicTableSizeLimit(c.settings.encoderTableSize())
+	var maxHeaderListSizeSetting uint32
+	if c.opts.MaxResponseHeaderBytes > 0 {
+		maxHeaderListSizeSetting = c.maxHeaderListSize()
+	}
+	if settings := localSettings(c.opts.MaxDecoderHeaderTableSize, maxHeaderListSizeSetting, c.opts.PushHandler == nil); len(settings) > 0 {
+		if err := c.requestWriter.WriteSettings(settings); err != nil {
+			return err
+		}
+	}
 	go c.handleHeaderStream()
+	go c.acceptPushStreams()
 	return nil
 }
 
 func (c *client) handleHeaderStream() {
-	decoder := hpack.NewDecoder(4096, func(hf hpack.HeaderField) {})
+	decoder := newHeaderDecoder(c.opts.MaxDecoderHeaderTableSize)
 	h2framer := http2.NewFramer(nil, c.headerStream)
 
 	var err error
@@ -119,25 +167,67 @@ func (c *client) handleHeaderStream() {
 	close(c.headerErrored)
 }
 
+func (c *client) maxHeaderListSize() uint32 {
+	if c.opts.MaxResponseHeaderBytes > math.MaxUint32 {
+		return math.MaxUint32
+	}
+	if c.opts.MaxResponseHeaderBytes > 0 {
+		return uint32(c.opts.MaxResponseHeaderBytes)
+	}
+	return defaultMaxResponseHeaderBytes
+}
+
 func (c *client) readResponse(h2framer *http2.Framer, decoder *hpack.Decoder) error {
 	frame, err := h2framer.ReadFrame()
 	if err != nil {
 		return err
 	}
+	if sframe, ok := frame.(*http2.SettingsFrame); ok {
+		if err := c.settings.handleSettingsFrame(sframe); err != nil {
+			return err
+		}
+		c.requestWriter.SetMaxDynamicTableSizeLimit(c.settings.encoderTableSize())
+		return nil
+	}
+	if ppframe, ok := frame.(*http2.PushPromiseFrame); ok {
+		fields, err := decoder.DecodeFull(ppframe.HeaderBlockFragment())
+		if err != nil {
+			return fmt.Errorf("cannot read header fields: %s", err.Error())
+		}
+		c.handlePushPromise(protocol.StreamID(ppframe.PromiseID), fields)
+		return nil
+	}
 	hframe, ok := frame.(*http2.HeadersFrame)
 	if !ok {
 		return errors.New("not a headers frame")
 	}
 	mhframe := &http2.MetaHeadersFrame{HeadersFrame: hframe}
-	mhframe.Fields, err = decoder.DecodeFull(hframe.HeaderBlockFragment())
+	mhframe.Fields, mhframe.Truncated, err = readHeaderBlock(h2framer, decoder, hframe, c.maxHeaderListSize())
 	if err != nil {
 		return fmt.Errorf("cannot read header fields: %s", err.Error())
 	}
 
+	if isTrailers(hframe, mhframe.Fields) {
+		if mhframe.Truncated {
+			c.logger.Debugf("Dropping trailers on data stream %d that exceed the limit", hframe.StreamID)
+			mhframe.Fields = nil
+		}
+		c.mutex.RLock()
+		trailers, ok := c.trailers[protocol.StreamID(hframe.StreamID)]
+		c.mutex.RUnlock()
+		if ok {
+			trailers.deliver(mhframe.Fields)
+		} else {
+			c.logger.Debugf("Ignoring trailers for data stream %d", hframe.StreamID)
+		}
+		return nil
+	}
+
 	c.mutex.RLock()
 	responseChan, ok := c.responses[protocol.StreamID(hframe.StreamID)]
+	_, isPush := c.pushes[protocol.StreamID(hframe.StreamID)]
 	c.mutex.RUnlock()
-	if !ok {
+	if !ok && !isPush {
 		return fmt.Errorf("response channel for stream %d not found", hframe.StreamID)
 	}
 
@@ -145,12 +235,26 @@ func (c *client) readResponse(h2framer *http2.Framer, decoder *hpack.Decoder) er
 	if err != nil {
 		return err
 	}
+	if isPush {
+		c.handlePushedResponse(protocol.StreamID(hframe.StreamID), rsp)
+		return nil
+	}
 	responseChan <- rsp
 	return nil
 }
 
 // Roundtrip executes a request and returns a response
 func (c *client) RoundTrip(req *http.Request) (*http.Response, error) {
+	rsp, err := c.roundTripOpt(req, true)
+	if cerr, ok := err.(*sessionClosedError); ok {
+		return nil, cerr.err
+	}
+	return rsp, err
+}
+
+// roundTripOpt executes a request and returns a response.
+// If canBlock is false, it returns errStreamLimitReached instead of waiting until a new stream can be opened.
+func (c *client) roundTripOpt(req *http.Request, canBlock bool) (*http.Response, error) {
 	// TODO: add port to address, if it doesn't have one
 	if req.URL.Scheme != "https" {
 		return nil, errors.New("quic http2: unsupported scheme")
@@ -159,43 +263,73 @@ func (c *client) RoundTrip(req *http.Request) (*http.Response, error) {
 		return nil, fmt.Errorf("h2quic Client BUG: RoundTrip called for the wrong client (expected %s, got %s)", c.hostname, req.Host)
 	}
 
-	c.dialOnce.Do(func() {
-		c.handshakeErr = c.dial()
-	})
+	ctx := req.Context()
+	trace := httptrace.ContextClientTrace(ctx)
 
-	if c.handshakeErr != nil {
-		return nil, c.handshakeErr
+	ok, wasIdle, idleTime := c.requestStarted()
+	if !ok {
+		return nil, &sessionClosedError{errClientClosedIdle}
+	}
+	// Once the trailers are registered, the request is finished when they are released.
+	var registered bool
+	defer func() {
+		if !registered {
+			c.requestFinished()
+		}
+	}()
+
+	dialedNow, err := c.ensureDialed(ctx, trace)
+	if err != nil {
+		return nil, err
 	}
 
 	hasBody := (req.Body != nil)
+	// For CONNECT requests, the request body is sent while the response body is read.
+	isConnect := req.Method == "CONNECT"
 
 	responseChan := make(chan *http.Response)
-	dataStream, err := c.session.OpenStreamSync()
+	dataStream, err := c.openStream(ctx, canBlock)
 	if err != nil {
-		_ = c.closeWithError(err)
 		return nil, err
 	}
+	traceGotConn(trace, c.session, !dialedNow, wasIdle, idleTime)
+	// the trailers might be received before the body was read completely, so we need to register them here
+	requestDone := make(chan struct{}) // closed when the request is finished
+	trailers := newTrailerReceiver(c.headerErrored, func() {
+		c.mutex.Lock()
+		delete(c.trailers, dataStream.StreamID())
+		c.mutex.Unlock()
+		c.requestFinished()
+		close(requestDone)
+	})
 	c.mutex.Lock()
 	c.responses[dataStream.StreamID()] = responseChan
+	c.trailers[dataStream.StreamID()] = trailers
 	c.mutex.Unlock()
+	registered = true
 
 	var requestedGzip bool
-	if !c.opts.DisableCompression && req.Header.Get("Accept-Encoding") == "" && req.Header.Get("Range") == "" && req.Method != "HEAD" {
+	if !c.opts.DisableCompression && req.Header.Get("Accept-Encoding") == "" && req.Header.Get("Range") == "" && req.Method != "HEAD" && !isConnect {
 		requestedGzip = true
 	}
-	// TODO: add support for trailers
 	endStream := !hasBody
 	err = c.requestWriter.WriteRequest(req, dataStream.StreamID(), endStream, requestedGzip)
 	if err != nil {
+		trailers.close()
 		_ = c.closeWithError(err)
 		return nil, err
 	}
+	traceWroteHeaders(trace)
 
 	resc := make(chan error, 1)
 	if hasBody {
 		go func() {
-			resc <- c.writeRequestBody(dataStream, req.Body)
+			err := c.writeRequestBody(dataStream, req.Body, req.Trailer)
+			traceWroteRequest(trace, err)
+			resc <- err
 		}()
+	} else {
+		traceWroteRequest(trace, nil)
 	}
 
 	var res *http.Response
@@ -203,14 +337,15 @@ func (c *client) RoundTrip(req *http.Request) (*http.Response, error) {
 	var receivedResponse bool
 	var bodySent bool
 
-	if !hasBody {
+	// don't wait until the body of CONNECT requests was sent, since that only happens when the tunnel is closed
+	if !hasBody || isConnect {
 		bodySent = true
 	}
 
-	ctx := req.Context()
 	for !(bodySent && receivedResponse) {
 		select {
 		case res = <-responseChan:
+			traceGotFirstResponseByte(trace)
 			receivedResponse = true
 			c.mutex.Lock()
 			delete(c.responses, dataStream.StreamID())
@@ -218,6 +353,7 @@ func (c *client) RoundTrip(req *http.Request) (*http.Response, error) {
 		case err := <-resc:
 			bodySent = true
 			if err != nil {
+				trailers.close()
 				return nil, err
 			}
 		case <-ctx.Done():
@@ -227,9 +363,11 @@ func (c *client) RoundTrip(req *http.Request) (*http.Response, error) {
 			c.mutex.Lock()
 			delete(c.responses, dataStream.StreamID())
 			c.mutex.Unlock()
+			trailers.close()
 			return nil, ctx.Err()
 		case <-c.headerErrored:
 			// an error occurred on the header stream
+			trailers.close()
 			_ = c.closeWithError(c.headerErr)
 			return nil, c.headerErr
 		}
@@ -243,8 +381,13 @@ func (c *client) RoundTrip(req *http.Request) (*http.Response, error) {
 
 	if streamEnded || isHead {
 		res.Body = noBody
+		trailers.close()
 	} else {
-		res.Body = dataStream
+		body := newResponseBody(dataStream)
+		body.trailers = trailers
+		body.trailer = &res.Trailer
+		body.ctx = ctx
+		res.Body = body
 		if requestedGzip && res.Header.Get("Content-Encoding") == "gzip" {
 			res.Header.Del("Content-Encoding")
 			res.Header.Del("Content-Length")
@@ -254,11 +397,200 @@ func (c *client) RoundTrip(req *http.Request) (*http.Response, error) {
 		}
 	}
 
+	// cancel the data stream if the request context is cancelled while the response body is read
+	if ctx.Done() != nil {
+		go func() {
+			select {
+			case <-ctx.Done():
+				// error code 6 signals that stream was canceled
+				dataStream.CancelRead(6)
+				dataStream.CancelWrite(6)
+			case <-requestDone:
+			}
+		}()
+	}
+
 	res.Request = req
 	return res, nil
 }
 
-func (c *client) writeRequestBody(dataStream quic.Stream, body io.ReadCloser) (err error) {
+// ensureDialed dials the session, if it wasn't dialed yet.
+// It stops waiting for the handshake when the context is cancelled, but the handshake is continued for subsequent requests.
+// It returns true if the session was dialed for this request.
+func (c *client) ensureDialed(ctx context.Context, trace *httptrace.ClientTrace) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	var dialedNow bool
+	dialed := make(chan struct{})
+	go func() {
+		c.dialOnce.Do(func() {
+			dialedNow = true
+			err := c.dial(trace, ContextClientTrace(ctx))
+			c.mutex.Lock()
+			c.handshakeErr = err
+			c.dialed = true
+			c.mutex.Unlock()
+		})
+		close(dialed)
+	}()
+	select {
+	case <-dialed:
+	case <-ctx.Done():
+		return false, ctx.Err()
+	}
+	return dialedNow, c.handshakeErr
+}
+
+// openStream opens the data stream for a new request
+func (c *client) openStream(ctx context.Context, canBlock bool) (quic.Stream, error) {
+	select {
+	case <-c.headerErrored:
+		_ = c.closeWithError(c.headerErr)
+		return nil, &sessionClosedError{c.headerErr}
+	default:
+	}
+	var str quic.Stream
+	var err error
+	if canBlock {
+		str, err = c.openStreamSync(ctx)
+	} else {
+		str, err = c.session.OpenStream()
+	}
+	if err == nil {
+		return str, nil
+	}
+	if err == qerr.TooManyOpenStreams {
+		return nil, errStreamLimitReached
+	}
+	if err == ctx.Err() {
+		return nil, err
+	}
+	if c.session.Context().Err() != nil {
+		return nil, &sessionClosedError{err}
+	}
+	_ = c.closeWithError(err)
+	return nil, err
+}
+
+// openStreamSync opens a new stream, blocking until the peer's stream limit allows opening it,
+// or until the context is cancelled
+func (c *client) openStreamSync(ctx context.Context) (quic.Stream, error) {
+	if ctx.Done() == nil {
+		return c.session.OpenStreamSync()
+	}
+	type result struct {
+		str quic.Stream
+		err error
+	}
+	resc := make(chan result, 1)
+	go func() {
+		str, err := c.session.OpenStreamSync()
+		resc <- result{str: str, err: err}
+	}()
+	select {
+	case res := <-resc:
+		return res.str, res.err
+	case <-ctx.Done():
+		go func() {
+			// the stream might still be opened, but it's not needed any more
+			if res := <-resc; res.err == nil {
+				// error code 6 signals that stream was canceled
+				res.str.CancelRead(6)
+				res.str.CancelWrite(6)
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
+
+// requestStarted is called when a new request is started.
+// It returns false if the client was already closed because it was idle.
+// If the client was idle before, it also returns for how long.
+func (c *client) requestStarted() (ok, wasIdle bool, idleTime time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.closedIdle {
+		return false, false, 0
+	}
+	if c.activeRequests == 0 && !c.idleSince.IsZero() {
+		wasIdle = true
+		idleTime = time.Since(c.idleSince)
+	}
+	c.activeRequests++
+	if c.idleTimer != nil {
+		c.idleTimer.Stop()
+		c.idleTimer = nil
+	}
+	return true, wasIdle, idleTime
+}
+
+// requestFinished is called when a request is finished, i.e. when the response body was read or closed
+func (c *client) requestFinished() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.activeRequests--
+	if c.activeRequests > 0 {
+		return
+	}
+	c.idleSince = time.Now()
+	if timeout := c.opts.IdleConnTimeout; timeout > 0 {
+		c.idleTimer = time.AfterFunc(timeout, func() {
+			c.mutex.Lock()
+			expired := c.activeRequests == 0 && time.Since(c.idleSince) >= timeout
+			c.mutex.Unlock()
+			if expired {
+				c.logger.Debugf("Closing idle connection to %s", c.hostname)
+				c.closeIfIdle()
+			}
+		})
+	}
+}
+
+// closeIfIdle closes the client if no requests are in flight.
+// It returns true if the client is closed.
+func (c *client) closeIfIdle() bool {
+	c.mutex.Lock()
+	if c.activeRequests > 0 {
+		c.mutex.Unlock()
+		return false
+	}
+	c.closedIdle = true
+	dialed := c.dialed
+	c.mutex.Unlock()
+	if dialed {
+		c.Close()
+	}
+	return true
+}
+
+// isClosed says if the client can't be used for new requests,
+// because the session was closed, or it couldn't be established
+func (c *client) isClosed() bool {
+	c.mutex.RLock()
+	closedIdle := c.closedIdle
+	dialed := c.dialed
+	c.mutex.RUnlock()
+	if closedIdle {
+		return true
+	}
+	if !dialed {
+		return false
+	}
+	if c.handshakeErr != nil {
+		return true
+	}
+	select {
+	case <-c.headerErrored:
+		return true
+	case <-c.session.Context().Done():
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *client) writeRequestBody(dataStream quic.Stream, body io.ReadCloser, trailer http.Header) (err error) {
 	defer func() {
 		cerr := body.Close()
 		if err == nil {
@@ -272,6 +604,12 @@ func (c *client) writeRequestBody(dataStream quic.Stream, body io.ReadCloser) (e
 		// TODO: what to do with dataStream here? Maybe reset it?
 		return err
 	}
+	// the values of the trailers may be set while the body is read
+	if len(trailer) > 0 {
+		if err := c.requestWriter.WriteTrailers(trailer, dataStream.StreamID()); err != nil {
+			return err
+		}
+	}
 	return dataStream.Close()
 }
 
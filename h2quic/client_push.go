@@ -0,0 +1,152 @@
+package h2quic
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"golang.org/x/net/http2/hpack"
+
+	quic "github.com/wheelcomplex/qk"
+	"github.com/wheelcomplex/qk/internal/protocol"
+)
+
+// a pushPromise is a response that the server announced in a PUSH_PROMISE frame.
+// The data stream and the HEADERS frame of the pushed response can arrive in any order.
+type pushPromise struct {
+	req      *http.Request
+	refused  bool
+	stream   quic.Stream
+	response *http.Response
+}
+
+// acceptPushStreams accepts the data streams of pushed responses, until the session is closed
+func (c *client) acceptPushStreams() {
+	for {
+		str, err := c.session.AcceptStream()
+		if err != nil {
+			return
+		}
+		c.handlePushStream(str)
+	}
+}
+
+func (c *client) handlePushStream(str quic.Stream) {
+	if c.opts.PushHandler == nil {
+		refusePushStream(str)
+		return
+	}
+
+	c.mutex.Lock()
+	p := c.getPushPromise(str.StreamID())
+	if p.refused {
+		c.mutex.Unlock()
+		refusePushStream(str)
+		return
+	}
+	p.stream = str
+	c.maybeDeliverPush(str.StreamID(), p)
+	c.mutex.Unlock()
+}
+
+func (c *client) handlePushPromise(id protocol.StreamID, fields []hpack.HeaderField) {
+	req, err := c.promisedRequestFromHeaders(fields)
+	if err != nil {
+		c.logger.Debugf("Refusing push on stream %d: %s", id, err)
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	p := c.getPushPromise(id)
+	p.req = req
+	if err != nil || c.opts.PushHandler == nil {
+		p.refused = true
+		if p.stream != nil {
+			refusePushStream(p.stream)
+			p.stream = nil
+		}
+	}
+}
+
+func (c *client) handlePushedResponse(id protocol.StreamID, rsp *http.Response) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	p := c.pushes[id]
+	if p.refused {
+		// the HEADERS frame is the last frame we'll receive for a refused push
+		delete(c.pushes, id)
+		return
+	}
+	p.response = rsp
+	c.maybeDeliverPush(id, p)
+}
+
+// getPushPromise returns the push promise for a stream, creating it if necessary.
+// It must be called with the mutex held.
+func (c *client) getPushPromise(id protocol.StreamID) *pushPromise {
+	p, ok := c.pushes[id]
+	if !ok {
+		p = &pushPromise{}
+		c.pushes[id] = p
+	}
+	return p
+}
+
+// maybeDeliverPush passes the pushed response to the PushHandler, once both the data stream and the response headers were received.
+// It must be called with the mutex held.
+func (c *client) maybeDeliverPush(id protocol.StreamID, p *pushPromise) {
+	if p.req == nil || p.stream == nil || p.response == nil {
+		return
+	}
+	delete(c.pushes, id)
+
+	isHead := p.req.Method == "HEAD"
+	rsp := setLength(p.response, isHead, false)
+	if isHead {
+		rsp.Body = noBody
+	} else {
+		rsp.Body = newResponseBody(p.stream)
+	}
+	rsp.Request = p.req
+	go c.opts.PushHandler(p.req, rsp)
+}
+
+// promisedRequestFromHeaders creates the request contained in a PUSH_PROMISE frame.
+// The server is only authoritative for pushes to the origin of this connection, and it may only push safe requests.
+func (c *client) promisedRequestFromHeaders(fields []hpack.HeaderField) (*http.Request, error) {
+	var scheme string
+	for _, f := range fields {
+		if f.Name == ":scheme" {
+			scheme = f.Value
+		}
+	}
+	req, err := requestFromHeaders(fields)
+	if err != nil {
+		return nil, err
+	}
+	if scheme != "https" {
+		return nil, fmt.Errorf("h2quic: invalid scheme %q", scheme)
+	}
+	if req.Method != "GET" && req.Method != "HEAD" {
+		return nil, fmt.Errorf("h2quic: invalid method %q", req.Method)
+	}
+	if authorityAddr("https", req.Host) != c.hostname {
+		return nil, fmt.Errorf("h2quic: server is not authoritative for %s", req.Host)
+	}
+	req.URL = &url.URL{
+		Scheme:   scheme,
+		Host:     req.Host,
+		Path:     req.URL.Path,
+		RawPath:  req.URL.RawPath,
+		RawQuery: req.URL.RawQuery,
+	}
+	req.RequestURI = ""
+	req.TLS = nil
+	return req, nil
+}
+
+func refusePushStream(str quic.Stream) {
+	// error code 6 signals that stream was canceled
+	str.CancelRead(6)
+	str.CancelWrite(6)
+}
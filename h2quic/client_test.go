@@ -8,6 +8,9 @@ import (
 	"errors"
 	"io"
 	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"sync"
 
 	"golang.org/x/net/http2"
 	"golang.org/x/net/http2/hpack"
@@ -144,6 +147,69 @@ var _ = Describe("Client", func() {
 		Eventually(done).Should(BeClosed())
 	})
 
+	It("sends a SETTINGS frame after dialing", func() {
+		client = newClient("localhost:1337", nil, &roundTripperOpts{
+			MaxResponseHeaderBytes:    1 << 12,
+			MaxDecoderHeaderTableSize: 1 << 16,
+		}, nil, nil)
+		hstr := newMockStream(3)
+		session.streamsToOpen = []quic.Stream{hstr, newMockStream(5)}
+		dialAddr = func(hostname string, _ *tls.Config, _ *quic.Config) (quic.Session, error) {
+			return session, nil
+		}
+		done := make(chan struct{})
+		go func() {
+			defer GinkgoRecover()
+			_, err := client.RoundTrip(req)
+			Expect(err).ToNot(HaveOccurred())
+			close(done)
+		}()
+		Eventually(func() []byte { return hstr.dataWritten.Bytes() }).ShouldNot(BeEmpty())
+		injectResponse(5, &http.Response{})
+		Eventually(done).Should(BeClosed())
+		framer := http2.NewFramer(nil, bytes.NewReader(hstr.dataWritten.Bytes()))
+		frame, err := framer.ReadFrame()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(frame).To(BeAssignableToTypeOf(&http2.SettingsFrame{}))
+		settingsFrame := frame.(*http2.SettingsFrame)
+		val, ok := settingsFrame.Value(http2.SettingHeaderTableSize)
+		Expect(ok).To(BeTrue())
+		Expect(val).To(BeEquivalentTo(1 << 16))
+		val, ok = settingsFrame.Value(http2.SettingMaxHeaderListSize)
+		Expect(ok).To(BeTrue())
+		Expect(val).To(BeEquivalentTo(1 << 12))
+		// no PushHandler is set, so pushes are disabled
+		val, ok = settingsFrame.Value(http2.SettingEnablePush)
+		Expect(ok).To(BeTrue())
+		Expect(val).To(BeZero())
+		// the SETTINGS frame is followed by the request
+		frame, err = framer.ReadFrame()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(frame).To(BeAssignableToTypeOf(&http2.HeadersFrame{}))
+	})
+
+	It("doesn't send a SETTINGS frame if all settings have their default values", func() {
+		client = newClient("localhost:1337", nil, &roundTripperOpts{}, nil, nil)
+		hstr := newMockStream(3)
+		session.streamsToOpen = []quic.Stream{hstr, newMockStream(5)}
+		dialAddr = func(hostname string, _ *tls.Config, _ *quic.Config) (quic.Session, error) {
+			return session, nil
+		}
+		done := make(chan struct{})
+		go func() {
+			defer GinkgoRecover()
+			_, err := client.RoundTrip(req)
+			Expect(err).ToNot(HaveOccurred())
+			close(done)
+		}()
+		Eventually(func() []byte { return hstr.dataWritten.Bytes() }).ShouldNot(BeEmpty())
+		injectResponse(5, &http.Response{})
+		Eventually(done).Should(BeClosed())
+		frame, err := http2.NewFramer(nil, bytes.NewReader(hstr.dataWritten.Bytes())).ReadFrame()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(frame).To(BeAssignableToTypeOf(&http2.HeadersFrame{}))
+	})
+
 	It("errors if it can't open a stream", func() {
 		testErr := errors.New("you shall not pass")
 		client = newClient("localhost:1337", nil, &roundTripperOpts{}, nil, nil)
@@ -220,7 +286,7 @@ var _ = Describe("Client", func() {
 				rsp, err := client.RoundTrip(request)
 				Expect(err).ToNot(HaveOccurred())
 				Expect(rsp).To(Equal(teapot))
-				Expect(rsp.Body).To(Equal(dataStream))
+				Expect(rsp.Body.(*responseBody).Stream).To(Equal(dataStream))
 				Expect(rsp.ContentLength).To(BeEquivalentTo(-1))
 				Expect(rsp.Request).To(Equal(request))
 				close(done)
@@ -244,6 +310,7 @@ var _ = Describe("Client", func() {
 				close(done)
 			}()
 
+			Eventually(func() []byte { return headerStream.dataWritten.Bytes() }).ShouldNot(BeEmpty())
 			cancel()
 			Eventually(done).Should(BeClosed())
 			Expect(dataStream.reset).To(BeTrue())
@@ -275,22 +342,76 @@ var _ = Describe("Client", func() {
 		It("errors if a request with a body is canceled before the body is sent", func() {
 			done := make(chan struct{})
 			ctx, cancel := context.WithCancel(context.Background())
+			pr, pw := io.Pipe()
 			go func() {
 				defer GinkgoRecover()
 				request = request.WithContext(ctx)
-				request.Body = &mockBody{}
-				cancel()
-				time.Sleep(10 * time.Millisecond)
+				request.Body = pr
 				rsp, err := client.RoundTrip(request)
 				Expect(err).To(MatchError(context.Canceled))
 				Expect(rsp).To(BeNil())
 				close(done)
 			}()
 
+			Eventually(func() []byte { return headerStream.dataWritten.Bytes() }).ShouldNot(BeEmpty())
+			cancel()
 			Eventually(done).Should(BeClosed())
 			Expect(dataStream.reset).To(BeTrue())
 			Expect(dataStream.canceledWrite).To(BeTrue())
 			Expect(client.headerErrored).ToNot(BeClosed())
+			pw.Close()
+		})
+
+		It("errors if a request is canceled before it is sent", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			_, err := client.RoundTrip(request.WithContext(ctx))
+			Expect(err).To(MatchError(context.Canceled))
+			Expect(client.session.(*mockSession).streamsToOpen).To(HaveLen(2))
+			Expect(headerStream.dataWritten.Len()).To(BeZero())
+			Expect(client.closeIfIdle()).To(BeTrue())
+		})
+
+		It("errors if a request is canceled while waiting for a stream", func() {
+			client.dialOnce.Do(func() {})
+			session.streamsToOpen = []quic.Stream{dataStream}
+			session.blockOpenStreamSync = true
+			done := make(chan struct{})
+			ctx, cancel := context.WithCancel(context.Background())
+			go func() {
+				defer GinkgoRecover()
+				_, err := client.RoundTrip(request.WithContext(ctx))
+				Expect(err).To(MatchError(context.Canceled))
+				close(done)
+			}()
+
+			Consistently(done).ShouldNot(BeClosed())
+			cancel()
+			Eventually(done).Should(BeClosed())
+			Expect(session.closed).To(BeFalse())
+			// the stream is canceled once it is opened
+			close(session.blockOpenStreamChan)
+			Eventually(func() bool { return dataStream.reset }).Should(BeTrue())
+			Expect(dataStream.canceledWrite).To(BeTrue())
+		})
+
+		It("cancels the data stream if the request is canceled while the response body is read", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			rspChan := make(chan *http.Response)
+			go func() {
+				defer GinkgoRecover()
+				rsp, err := client.RoundTrip(request.WithContext(ctx))
+				Expect(err).ToNot(HaveOccurred())
+				rspChan <- rsp
+			}()
+			injectResponse(5, &http.Response{})
+			var rsp *http.Response
+			Eventually(rspChan).Should(Receive(&rsp))
+			Expect(dataStream.reset).To(BeFalse())
+			cancel()
+			Eventually(func() bool { return dataStream.reset }).Should(BeTrue())
+			Expect(dataStream.canceledWrite).To(BeTrue())
+			Expect(rsp.Body.(*responseBody).ctx).To(Equal(ctx))
 		})
 
 		It("closes the quic client when encountering an error on the header stream", func() {
@@ -338,6 +459,166 @@ var _ = Describe("Client", func() {
 			Eventually(done).Should(BeClosed())
 		})
 
+		It("returns errStreamLimitReached if the stream limit is reached and it can't block", func() {
+			client.dialOnce.Do(func() {})
+			session.streamOpenErr = qerr.TooManyOpenStreams
+			_, err := client.roundTripOpt(request, false)
+			Expect(err).To(MatchError(errStreamLimitReached))
+			Expect(session.closed).To(BeFalse())
+			Expect(client.closeIfIdle()).To(BeTrue())
+		})
+
+		It("returns a sessionClosedError if the session was closed before the request was sent", func() {
+			testErr := errors.New("session closed")
+			client.dialOnce.Do(func() {})
+			session.streamOpenErr = testErr
+			session.Close()
+			_, err := client.roundTripOpt(request, false)
+			Expect(err).To(Equal(&sessionClosedError{testErr}))
+			_, err = client.RoundTrip(request)
+			Expect(err).To(MatchError(testErr))
+		})
+
+		Context("tracing", func() {
+			var (
+				eventsMutex sync.Mutex
+				events      []string
+			)
+
+			addEvent := func(e string) {
+				eventsMutex.Lock()
+				events = append(events, e)
+				eventsMutex.Unlock()
+			}
+
+			getEvents := func() []string {
+				eventsMutex.Lock()
+				defer eventsMutex.Unlock()
+				return append([]string{}, events...)
+			}
+
+			BeforeEach(func() {
+				events = nil
+			})
+
+			It("calls the trace hooks", func() {
+				session.connectionState = quic.ConnectionState{HandshakeComplete: true, NegotiatedProtocol: "h2"}
+				var gotConn httptrace.GotConnInfo
+				trace := &httptrace.ClientTrace{
+					TLSHandshakeStart: func() { addEvent("TLSHandshakeStart") },
+					TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+						defer GinkgoRecover()
+						Expect(err).ToNot(HaveOccurred())
+						Expect(state.NegotiatedProtocol).To(Equal("h2"))
+						addEvent("TLSHandshakeDone")
+					},
+					GotConn: func(info httptrace.GotConnInfo) {
+						gotConn = info
+						addEvent("GotConn")
+					},
+					WroteHeaders:         func() { addEvent("WroteHeaders") },
+					WroteRequest:         func(httptrace.WroteRequestInfo) { addEvent("WroteRequest") },
+					GotFirstResponseByte: func() { addEvent("GotFirstResponseByte") },
+				}
+				qtrace := &ClientTrace{
+					HandshakeDone: func(state quic.ConnectionState, err error) {
+						defer GinkgoRecover()
+						Expect(err).ToNot(HaveOccurred())
+						Expect(state.HandshakeComplete).To(BeTrue())
+						addEvent("HandshakeDone")
+					},
+				}
+				ctx := WithClientTrace(httptrace.WithClientTrace(context.Background(), trace), qtrace)
+				done := make(chan struct{})
+				go func() {
+					defer GinkgoRecover()
+					_, err := client.RoundTrip(request.WithContext(ctx))
+					Expect(err).ToNot(HaveOccurred())
+					close(done)
+				}()
+				Eventually(getEvents).Should(ContainElement("WroteRequest"))
+				injectResponse(5, &http.Response{})
+				Eventually(done).Should(BeClosed())
+				Expect(getEvents()).To(Equal([]string{
+					"TLSHandshakeStart",
+					"TLSHandshakeDone",
+					"HandshakeDone",
+					"GotConn",
+					"WroteHeaders",
+					"WroteRequest",
+					"GotFirstResponseByte",
+				}))
+				Expect(gotConn.Reused).To(BeFalse())
+				Expect(gotConn.WasIdle).To(BeFalse())
+				Expect(gotConn.Conn.RemoteAddr()).To(Equal(session.RemoteAddr()))
+			})
+
+			It("calls WroteRequest when the request body was sent", func() {
+				client.dialOnce.Do(func() {})
+				session.streamsToOpen = []quic.Stream{dataStream}
+				pr, pw := io.Pipe()
+				request.Body = pr
+				var gotConn httptrace.GotConnInfo
+				trace := &httptrace.ClientTrace{
+					GotConn:      func(info httptrace.GotConnInfo) { gotConn = info },
+					WroteHeaders: func() { addEvent("WroteHeaders") },
+					WroteRequest: func(info httptrace.WroteRequestInfo) {
+						defer GinkgoRecover()
+						Expect(info.Err).ToNot(HaveOccurred())
+						addEvent("WroteRequest")
+					},
+				}
+				go func() {
+					defer GinkgoRecover()
+					client.RoundTrip(request.WithContext(httptrace.WithClientTrace(context.Background(), trace)))
+				}()
+				Eventually(getEvents).Should(Equal([]string{"WroteHeaders"}))
+				Expect(gotConn.Reused).To(BeTrue())
+				Consistently(getEvents).Should(HaveLen(1))
+				pw.Close()
+				Eventually(getEvents).Should(Equal([]string{"WroteHeaders", "WroteRequest"}))
+				injectResponse(5, &http.Response{})
+			})
+		})
+
+		Context("idle connections", func() {
+			doRequest := func() *http.Response {
+				rspChan := make(chan *http.Response)
+				go func() {
+					defer GinkgoRecover()
+					rsp, err := client.RoundTrip(request)
+					Expect(err).ToNot(HaveOccurred())
+					rspChan <- rsp
+				}()
+				injectResponse(5, &http.Response{})
+				var rsp *http.Response
+				Eventually(rspChan).Should(Receive(&rsp))
+				return rsp
+			}
+
+			It("is not closed while the response body is in use", func() {
+				rsp := doRequest()
+				Expect(client.isClosed()).To(BeFalse())
+				Expect(client.closeIfIdle()).To(BeFalse())
+				Expect(session.closed).To(BeFalse())
+				rsp.Body.Close()
+				Expect(client.closeIfIdle()).To(BeTrue())
+				Expect(session.closed).To(BeTrue())
+				Expect(client.isClosed()).To(BeTrue())
+				_, err := client.roundTripOpt(request, false)
+				Expect(err).To(Equal(&sessionClosedError{errClientClosedIdle}))
+			})
+
+			It("closes the session after the IdleConnTimeout", func() {
+				client.opts.IdleConnTimeout = 50 * time.Millisecond
+				rsp := doRequest()
+				Consistently(func() bool { return session.closed }, 100*time.Millisecond).Should(BeFalse())
+				rsp.Body.Close()
+				Eventually(func() bool { return session.closed }).Should(BeTrue())
+				Expect(client.isClosed()).To(BeTrue())
+			})
+		})
+
 		Context("validating the address", func() {
 			It("refuses to do requests for the wrong host", func() {
 				req, err := http.NewRequest("https", "https://quic.clemente.io:1336/foobar.html", nil)
@@ -438,6 +719,47 @@ var _ = Describe("Client", func() {
 				Expect(request.Body.(*mockBody).closed).To(BeTrue())
 			})
 
+			It("returns the response to CONNECT requests before the request body is sent", func() {
+				pr, pw := io.Pipe()
+				request.Method = "CONNECT"
+				request.Body = pr
+				rspChan := make(chan *http.Response)
+				go func() {
+					defer GinkgoRecover()
+					rsp, err := client.RoundTrip(request)
+					Expect(err).ToNot(HaveOccurred())
+					rspChan <- rsp
+				}()
+				injectResponse(5, response)
+				Eventually(rspChan).Should(Receive(Equal(response)))
+				Expect(dataStream.closed).To(BeFalse())
+				pw.Write([]byte("foobar"))
+				pw.Close()
+				Eventually(func() bool { return dataStream.closed }).Should(BeTrue())
+				Expect(dataStream.dataWritten.Bytes()).To(Equal([]byte("foobar")))
+			})
+
+			It("sends the request trailers", func() {
+				request.Trailer = http.Header{"Grpc-Status": []string{"0"}}
+				go func() {
+					defer GinkgoRecover()
+					_, err := client.RoundTrip(request)
+					Expect(err).ToNot(HaveOccurred())
+				}()
+				injectResponse(5, response)
+				Eventually(func() bool { return dataStream.closed }).Should(BeTrue())
+				framer := http2.NewFramer(nil, bytes.NewReader(headerStream.dataWritten.Bytes()))
+				framer.ReadMetaHeaders = hpack.NewDecoder(4096, nil)
+				frame, err := framer.ReadFrame()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(frame.(*http2.MetaHeadersFrame).StreamEnded()).To(BeFalse())
+				Expect(getHeaderFields(frame.(*http2.MetaHeadersFrame))).To(HaveKeyWithValue("trailer", "Grpc-Status"))
+				frame, err = framer.ReadFrame()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(frame.(*http2.MetaHeadersFrame).StreamEnded()).To(BeTrue())
+				Expect(frame.(*http2.MetaHeadersFrame).Fields).To(Equal([]hpack.HeaderField{{Name: "grpc-status", Value: "0"}}))
+			})
+
 			It("returns the error that occurred when reading the body", func() {
 				testErr := errors.New("testErr")
 				request.Body.(*mockBody).readErr = testErr
@@ -600,6 +922,105 @@ var _ = Describe("Client", func() {
 				Expect(rsp.Header).To(HaveKeyWithValue("Cache-Control", []string{"private"}))
 			})
 
+			It("applies SETTINGS frames", func() {
+				err := h2framer.WriteSettings(http2.Setting{ID: http2.SettingHeaderTableSize, Val: 0})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(client.readResponse(http2.NewFramer(nil, headerStream), newHeaderDecoder(0))).To(Succeed())
+				Expect(client.settings.encoderTableSize()).To(BeZero())
+				// the next request starts with a dynamic table size update
+				Expect(client.requestWriter.WriteRequest(req, 5, true, false)).To(Succeed())
+				frame, err := http2.NewFramer(nil, bytes.NewReader(headerStream.dataWritten.Bytes())).ReadFrame()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(frame).To(BeAssignableToTypeOf(&http2.HeadersFrame{}))
+				Expect(frame.(*http2.HeadersFrame).HeaderBlockFragment()[0]).To(Equal(byte(0x20)))
+			})
+
+			It("errors on invalid SETTINGS frames", func() {
+				err := h2framer.WriteSettings(http2.Setting{ID: http2.SettingEnablePush, Val: 2})
+				Expect(err).ToNot(HaveOccurred())
+				client.handleHeaderStream()
+				Eventually(client.headerErrored).Should(BeClosed())
+				Expect(client.headerErr.ErrorCode).To(Equal(qerr.InvalidHeadersStreamData))
+			})
+
+			It("reads responses with CONTINUATION frames", func() {
+				var headers bytes.Buffer
+				enc := hpack.NewEncoder(&headers)
+				enc.WriteField(hpack.HeaderField{Name: ":status", Value: "200"})
+				cookie := strings.Repeat("a", 2*maxHeaderFrameSize)
+				enc.WriteField(hpack.HeaderField{Name: "set-cookie", Value: cookie})
+				err := writeHeaders(h2framer, http2.HeadersFrameParam{
+					StreamID:      23,
+					BlockFragment: headers.Bytes(),
+				})
+				Expect(err).ToNot(HaveOccurred())
+				go client.handleHeaderStream()
+				var rsp *http.Response
+				Eventually(client.responses[23]).Should(Receive(&rsp))
+				Expect(rsp.StatusCode).To(Equal(200))
+				Expect(rsp.Header.Get("Set-Cookie")).To(Equal(cookie))
+			})
+
+			It("errors if the response headers are too large", func() {
+				client.opts.MaxResponseHeaderBytes = 1000
+				var headers bytes.Buffer
+				enc := hpack.NewEncoder(&headers)
+				enc.WriteField(hpack.HeaderField{Name: ":status", Value: "200"})
+				enc.WriteField(hpack.HeaderField{Name: "set-cookie", Value: strings.Repeat("a", 2000)})
+				err := writeHeaders(h2framer, http2.HeadersFrameParam{
+					StreamID:      23,
+					BlockFragment: headers.Bytes(),
+				})
+				Expect(err).ToNot(HaveOccurred())
+				client.handleHeaderStream()
+				Eventually(client.headerErrored).Should(BeClosed())
+				Expect(client.headerErr).To(MatchError(qerr.Error(qerr.InvalidHeadersStreamData, errResponseHeaderListSize.Error())))
+			})
+
+			It("delivers trailers", func() {
+				trailers := newTrailerReceiver(nil, func() {})
+				client.trailers[23] = trailers
+				var headers bytes.Buffer
+				enc := hpack.NewEncoder(&headers)
+				enc.WriteField(hpack.HeaderField{Name: "grpc-status", Value: "0"})
+				err := h2framer.WriteHeaders(http2.HeadersFrameParam{
+					StreamID:      23,
+					EndHeaders:    true,
+					EndStream:     true,
+					BlockFragment: headers.Bytes(),
+				})
+				Expect(err).ToNot(HaveOccurred())
+				go client.handleHeaderStream()
+				Eventually(trailers.received).Should(BeClosed())
+				trailer := http.Header{"Grpc-Status": nil}
+				trailers.readTrailers(&trailer)
+				Expect(trailer).To(Equal(http.Header{"Grpc-Status": []string{"0"}}))
+			})
+
+			It("ignores trailers for unknown streams", func() {
+				var headers bytes.Buffer
+				enc := hpack.NewEncoder(&headers)
+				enc.WriteField(hpack.HeaderField{Name: "grpc-status", Value: "0"})
+				err := h2framer.WriteHeaders(http2.HeadersFrameParam{
+					StreamID:      1337,
+					EndHeaders:    true,
+					EndStream:     true,
+					BlockFragment: headers.Bytes(),
+				})
+				Expect(err).ToNot(HaveOccurred())
+				headers.Reset()
+				enc.WriteField(hpack.HeaderField{Name: ":status", Value: "200"})
+				err = h2framer.WriteHeaders(http2.HeadersFrameParam{
+					StreamID:      23,
+					EndHeaders:    true,
+					BlockFragment: headers.Bytes(),
+				})
+				Expect(err).ToNot(HaveOccurred())
+				go client.handleHeaderStream()
+				Eventually(client.responses[23]).Should(Receive())
+				Expect(client.headerErrored).ToNot(BeClosed())
+			})
+
 			It("errors if the H2 frame is not a HeadersFrame", func() {
 				h2framer.WritePing(true, [8]byte{0, 0, 0, 0, 0, 0, 0, 0})
 				client.handleHeaderStream()
@@ -635,5 +1056,133 @@ var _ = Describe("Client", func() {
 				Expect(client.headerErr.ErrorMessage).To(ContainSubstring("response channel for stream 1337 not found"))
 			})
 		})
+
+		Context("handling pushes", func() {
+			var (
+				h2framer   *http2.Framer
+				pushStream *mockStream
+				pushes     chan *http.Response
+			)
+
+			writePushPromise := func(authority string) {
+				var headers bytes.Buffer
+				enc := hpack.NewEncoder(&headers)
+				enc.WriteField(hpack.HeaderField{Name: ":method", Value: "GET"})
+				enc.WriteField(hpack.HeaderField{Name: ":scheme", Value: "https"})
+				enc.WriteField(hpack.HeaderField{Name: ":authority", Value: authority})
+				enc.WriteField(hpack.HeaderField{Name: ":path", Value: "/style.css"})
+				err := h2framer.WritePushPromise(http2.PushPromiseParam{
+					StreamID:      5,
+					PromiseID:     2,
+					EndHeaders:    true,
+					BlockFragment: headers.Bytes(),
+				})
+				Expect(err).ToNot(HaveOccurred())
+			}
+
+			writePushedResponse := func() {
+				var headers bytes.Buffer
+				enc := hpack.NewEncoder(&headers)
+				enc.WriteField(hpack.HeaderField{Name: ":status", Value: "200"})
+				enc.WriteField(hpack.HeaderField{Name: "content-type", Value: "text/css"})
+				err := h2framer.WriteHeaders(http2.HeadersFrameParam{
+					StreamID:      2,
+					EndHeaders:    true,
+					BlockFragment: headers.Bytes(),
+				})
+				Expect(err).ToNot(HaveOccurred())
+			}
+
+			BeforeEach(func() {
+				h2framer = http2.NewFramer(&headerStream.dataToRead, nil)
+				pushStream = newMockStream(2)
+				pushes = make(chan *http.Response, 1)
+				client.opts.PushHandler = func(req *http.Request, rsp *http.Response) {
+					Expect(rsp.Request).To(Equal(req))
+					pushes <- rsp
+				}
+			})
+
+			It("passes pushed responses to the handler", func() {
+				writePushPromise("quic.clemente.io:1337")
+				writePushedResponse()
+				go client.handleHeaderStream()
+				Eventually(func() int {
+					client.mutex.RLock()
+					defer client.mutex.RUnlock()
+					return len(client.pushes)
+				}).Should(Equal(1))
+				Consistently(pushes).ShouldNot(Receive())
+				client.handlePushStream(pushStream)
+				var rsp *http.Response
+				Eventually(pushes).Should(Receive(&rsp))
+				Expect(rsp.StatusCode).To(Equal(200))
+				Expect(rsp.Header.Get("Content-Type")).To(Equal("text/css"))
+				Expect(rsp.Body).To(Equal(newResponseBody(pushStream)))
+				Expect(rsp.Request.Method).To(Equal("GET"))
+				Expect(rsp.Request.URL.String()).To(Equal("https://quic.clemente.io:1337/style.css"))
+				Expect(client.pushes).To(BeEmpty())
+				Expect(pushStream.reset).To(BeFalse())
+			})
+
+			It("handles data streams that arrive before the PUSH_PROMISE", func() {
+				client.handlePushStream(pushStream)
+				writePushPromise("quic.clemente.io:1337")
+				writePushedResponse()
+				go client.handleHeaderStream()
+				var rsp *http.Response
+				Eventually(pushes).Should(Receive(&rsp))
+				Expect(rsp.Body).To(Equal(newResponseBody(pushStream)))
+			})
+
+			It("refuses pushes if no handler is set", func() {
+				client.opts.PushHandler = nil
+				writePushPromise("quic.clemente.io:1337")
+				writePushedResponse()
+				go client.handleHeaderStream()
+				client.handlePushStream(pushStream)
+				Expect(pushStream.reset).To(BeTrue())
+				Expect(pushStream.canceledWrite).To(BeTrue())
+				Eventually(func() int {
+					client.mutex.RLock()
+					defer client.mutex.RUnlock()
+					return len(client.pushes)
+				}).Should(BeZero())
+				Consistently(client.headerErrored).ShouldNot(BeClosed())
+			})
+
+			It("refuses pushes for a different origin", func() {
+				client.handlePushStream(pushStream)
+				writePushPromise("www.example.com")
+				writePushedResponse()
+				go client.handleHeaderStream()
+				Eventually(func() bool {
+					client.mutex.RLock()
+					defer client.mutex.RUnlock()
+					return pushStream.reset
+				}).Should(BeTrue())
+				Expect(pushStream.canceledWrite).To(BeTrue())
+				Consistently(pushes).ShouldNot(Receive())
+				Expect(client.headerErrored).ToNot(BeClosed())
+			})
+
+			It("accepts pushed streams until the session is closed", func() {
+				session.streamToAccept = pushStream
+				done := make(chan struct{})
+				go func() {
+					client.acceptPushStreams()
+					close(done)
+				}()
+				Eventually(func() bool {
+					client.mutex.RLock()
+					defer client.mutex.RUnlock()
+					_, ok := client.pushes[2]
+					return ok
+				}).Should(BeTrue())
+				Consistently(done).ShouldNot(BeClosed())
+				session.Close()
+				Eventually(done).Should(BeClosed())
+			})
+		})
 	})
 })
@@ -0,0 +1,137 @@
+package h2quic
+
+import (
+	"errors"
+	"net/http"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+// maxHeaderFrameSize is the maximum size of the payload of a HEADERS, PUSH_PROMISE or CONTINUATION frame we send.
+// It is the default value of SETTINGS_MAX_FRAME_SIZE, see RFC 7540, section 6.5.2.
+const maxHeaderFrameSize = 16384
+
+// defaultMaxResponseHeaderBytes is the default limit of the size of response headers.
+// It is the same limit that the net/http2 Transport uses.
+const defaultMaxResponseHeaderBytes = 10 << 20
+
+var errPushPromiseTooLarge = errors.New("h2quic: promised request headers are too large")
+
+// writeHeaders writes the header block in a HEADERS frame, followed by as many CONTINUATION frames as necessary.
+func writeHeaders(framer *http2.Framer, p http2.HeadersFrameParam) error {
+	fragmentSize := maxHeaderFrameSize
+	if !p.Priority.IsZero() {
+		fragmentSize -= 5 // stream dependency and weight
+	}
+	block := p.BlockFragment
+	p.BlockFragment, block = splitHeaderBlock(block, fragmentSize)
+	p.EndHeaders = len(block) == 0
+	if err := framer.WriteHeaders(p); err != nil {
+		return err
+	}
+	return writeContinuations(framer, p.StreamID, block)
+}
+
+// writePushPromise writes the header block in a single PUSH_PROMISE frame.
+// The http2.Framer doesn't accept CONTINUATION frames following a PUSH_PROMISE frame,
+// so we don't push requests with long header blocks.
+func writePushPromise(framer *http2.Framer, p http2.PushPromiseParam) error {
+	if len(p.BlockFragment) > maxHeaderFrameSize-4 { // promised stream ID
+		return errPushPromiseTooLarge
+	}
+	p.EndHeaders = true
+	return framer.WritePushPromise(p)
+}
+
+func writeContinuations(framer *http2.Framer, streamID uint32, block []byte) error {
+	for len(block) > 0 {
+		var fragment []byte
+		fragment, block = splitHeaderBlock(block, maxHeaderFrameSize)
+		if err := framer.WriteContinuation(streamID, len(block) == 0, fragment); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func splitHeaderBlock(block []byte, size int) ([]byte, []byte) {
+	if len(block) <= size {
+		return block, nil
+	}
+	return block[:size], block[size:]
+}
+
+// readHeaderBlock reads the CONTINUATION frames following a HEADERS frame, and decodes the header block.
+// If the decoded header list is larger than maxSize (as defined in RFC 7540, section 6.5.2),
+// the remaining header fields are dropped, and truncated is true.
+// The header block is decoded completely in any case, such that the HPACK decoder stays in sync with the peer's encoder.
+func readHeaderBlock(
+	framer *http2.Framer,
+	decoder *hpack.Decoder,
+	f *http2.HeadersFrame,
+	maxSize uint32,
+) (fields []hpack.HeaderField, truncated bool, err error) {
+	var size uint32
+	decoder.SetEmitFunc(func(hf hpack.HeaderField) {
+		if truncated {
+			return
+		}
+		size += hf.Size()
+		if size > maxSize {
+			truncated = true
+			return
+		}
+		fields = append(fields, hf)
+	})
+	defer decoder.SetEmitFunc(func(hpack.HeaderField) {})
+	// To bound the memory used, strings longer than twice the limit are a decoding error.
+	decoder.SetMaxStringLength(2 * int(maxSize))
+
+	if _, err := decoder.Write(f.HeaderBlockFragment()); err != nil {
+		return nil, false, err
+	}
+	for ended := f.HeadersEnded(); !ended; {
+		frame, err := framer.ReadFrame()
+		if err != nil {
+			return nil, false, err
+		}
+		cf, ok := frame.(*http2.ContinuationFrame)
+		if !ok { // the Framer already checks that HEADERS frames are followed by CONTINUATION frames
+			return nil, false, errors.New("expected a CONTINUATION frame")
+		}
+		if _, err := decoder.Write(cf.HeaderBlockFragment()); err != nil {
+			return nil, false, err
+		}
+		ended = cf.HeadersEnded()
+	}
+	if err := decoder.Close(); err != nil {
+		return nil, false, err
+	}
+	return fields, truncated, nil
+}
+
+// maxHeaderListSize returns the limit of the size of the request headers, as defined in RFC 7540, section 6.5.2.
+// Copied from net/http2/server.go.
+func maxHeaderListSize(s *http.Server) uint32 {
+	n := s.MaxHeaderBytes
+	if n <= 0 {
+		n = http.DefaultMaxHeaderBytes
+	}
+	// http2's count is in a slightly different unit and includes 32 bytes per pair.
+	// So, take the net/http.Server value and pad it up a bit, assuming 10 headers.
+	const perFieldOverhead = 32 // per http2 spec
+	const typicalHeaders = 10   // conservative
+	return uint32(n + typicalHeaders*perFieldOverhead)
+}
+
+// handleHeaderListTooLong responds to requests with headers that exceed the limit.
+// Copied from net/http2/server.go.
+func handleHeaderListTooLong(w http.ResponseWriter) {
+	// 10.5.1 Limits on Header Block Size:
+	// .. "A server that receives a larger header block than it is
+	// willing to handle can send an HTTP 431 (Request Header Fields Too
+	// Large) status code"
+	w.WriteHeader(http.StatusRequestHeaderFieldsTooLarge)
+	w.Write([]byte("<h1>HTTP Error 431</h1><p>Request Header Field(s) Too Large</p>"))
+}
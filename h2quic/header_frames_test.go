@@ -0,0 +1,104 @@
+package h2quic
+
+import (
+	"bytes"
+	"net/http"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Header frames", func() {
+	var (
+		buf    *bytes.Buffer
+		framer *http2.Framer
+	)
+
+	BeforeEach(func() {
+		buf = &bytes.Buffer{}
+		framer = http2.NewFramer(buf, buf)
+	})
+
+	encodeHeaders := func(fields ...hpack.HeaderField) []byte {
+		var b bytes.Buffer
+		enc := hpack.NewEncoder(&b)
+		for _, f := range fields {
+			Expect(enc.WriteField(f)).To(Succeed())
+		}
+		return b.Bytes()
+	}
+
+	It("writes a short header block in a single HEADERS frame", func() {
+		err := writeHeaders(framer, http2.HeadersFrameParam{
+			StreamID:      5,
+			EndStream:     true,
+			BlockFragment: []byte("foobar"),
+		})
+		Expect(err).ToNot(HaveOccurred())
+		frame, err := framer.ReadFrame()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(frame).To(BeAssignableToTypeOf(&http2.HeadersFrame{}))
+		hframe := frame.(*http2.HeadersFrame)
+		Expect(hframe.HeadersEnded()).To(BeTrue())
+		Expect(hframe.StreamEnded()).To(BeTrue())
+		Expect(hframe.HeaderBlockFragment()).To(Equal([]byte("foobar")))
+		Expect(buf.Len()).To(BeZero())
+	})
+
+	It("splits long header blocks into CONTINUATION frames", func() {
+		cookie := string(bytes.Repeat([]byte("a"), 40000))
+		block := encodeHeaders(
+			hpack.HeaderField{Name: ":status", Value: "200"},
+			hpack.HeaderField{Name: "set-cookie", Value: cookie},
+		)
+		err := writeHeaders(framer, http2.HeadersFrameParam{
+			StreamID:      5,
+			BlockFragment: block,
+			Priority:      http2.PriorityParam{Weight: 0xff},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		framer.ReadMetaHeaders = hpack.NewDecoder(4096, nil)
+		framer.SetMaxReadFrameSize(maxHeaderFrameSize)
+		frame, err := framer.ReadFrame()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(frame).To(BeAssignableToTypeOf(&http2.MetaHeadersFrame{}))
+		mhframe := frame.(*http2.MetaHeadersFrame)
+		Expect(mhframe.StreamID).To(BeEquivalentTo(5))
+		Expect(mhframe.PseudoValue("status")).To(Equal("200"))
+		Expect(mhframe.RegularFields()).To(Equal([]hpack.HeaderField{{Name: "set-cookie", Value: cookie}}))
+	})
+
+	It("writes PUSH_PROMISE frames", func() {
+		err := writePushPromise(framer, http2.PushPromiseParam{
+			StreamID:      5,
+			PromiseID:     2,
+			BlockFragment: []byte("foobar"),
+		})
+		Expect(err).ToNot(HaveOccurred())
+		frame, err := framer.ReadFrame()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(frame).To(BeAssignableToTypeOf(&http2.PushPromiseFrame{}))
+		ppframe := frame.(*http2.PushPromiseFrame)
+		Expect(ppframe.PromiseID).To(BeEquivalentTo(2))
+		Expect(ppframe.HeadersEnded()).To(BeTrue())
+		Expect(ppframe.HeaderBlockFragment()).To(Equal([]byte("foobar")))
+	})
+
+	It("refuses to write PUSH_PROMISE frames that would need CONTINUATION frames", func() {
+		err := writePushPromise(framer, http2.PushPromiseParam{
+			StreamID:      5,
+			PromiseID:     2,
+			BlockFragment: bytes.Repeat([]byte("b"), maxHeaderFrameSize),
+		})
+		Expect(err).To(MatchError(errPushPromiseTooLarge))
+		Expect(buf.Len()).To(BeZero())
+	})
+
+	It("calculates the maximum header list size", func() {
+		Expect(maxHeaderListSize(&http.Server{})).To(BeEquivalentTo(http.DefaultMaxHeaderBytes + 320))
+		Expect(maxHeaderListSize(&http.Server{MaxHeaderBytes: 1000})).To(BeEquivalentTo(1320))
+	})
+})
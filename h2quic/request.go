@@ -8,11 +8,12 @@ import (
 	"strconv"
 	"strings"
 
+	"golang.org/x/net/http/httpguts"
 	"golang.org/x/net/http2/hpack"
 )
 
 func requestFromHeaders(headers []hpack.HeaderField) (*http.Request, error) {
-	var path, authority, method, contentLengthStr string
+	var path, authority, method, protocol, contentLengthStr string
 	httpHeaders := http.Header{}
 
 	for _, h := range headers {
@@ -23,6 +24,8 @@ func requestFromHeaders(headers []hpack.HeaderField) (*http.Request, error) {
 			method = h.Value
 		case ":authority":
 			authority = h.Value
+		case ":protocol":
+			protocol = h.Value
 		case "content-length":
 			contentLengthStr = h.Value
 		default:
@@ -32,22 +35,61 @@ func requestFromHeaders(headers []hpack.HeaderField) (*http.Request, error) {
 		}
 	}
 
+	var trailer http.Header
+	for _, v := range httpHeaders["Trailer"] {
+		foreachHeaderElement(v, func(key string) {
+			key = http.CanonicalHeaderKey(key)
+			if !httpguts.ValidTrailerHeader(key) {
+				return
+			}
+			if trailer == nil {
+				trailer = make(http.Header)
+			}
+			trailer[key] = nil
+		})
+	}
+	delete(httpHeaders, "Trailer")
+
 	// concatenate cookie headers, see https://tools.ietf.org/html/rfc6265#section-5.4
 	if len(httpHeaders["Cookie"]) > 0 {
 		httpHeaders.Set("Cookie", strings.Join(httpHeaders["Cookie"], "; "))
 	}
 
-	if len(path) == 0 || len(authority) == 0 || len(method) == 0 {
-		return nil, errors.New(":path, :authority and :method must not be empty")
+	if len(protocol) > 0 && method != http.MethodConnect {
+		return nil, errors.New(":protocol must only be sent with CONNECT requests")
 	}
 
-	u, err := url.Parse(path)
-	if err != nil {
-		return nil, err
+	var u *url.URL
+	requestURI := path
+	proto := "HTTP/2.0"
+	if method == http.MethodConnect && len(protocol) == 0 {
+		// CONNECT requests only carry the authority of the target, see RFC 7540, section 8.3
+		if len(authority) == 0 {
+			return nil, errors.New(":authority must not be empty for CONNECT requests")
+		}
+		if len(path) > 0 {
+			return nil, errors.New(":path must be empty for CONNECT requests")
+		}
+		u = &url.URL{Host: authority}
+		requestURI = authority
+	} else {
+		if len(path) == 0 || len(authority) == 0 || len(method) == 0 {
+			return nil, errors.New(":path, :authority and :method must not be empty")
+		}
+		var err error
+		u, err = url.Parse(path)
+		if err != nil {
+			return nil, err
+		}
+		// for extended CONNECT requests (RFC 8441), the protocol is used as the Proto of the http.Request
+		if len(protocol) > 0 {
+			proto = protocol
+		}
 	}
 
 	var contentLength int64
 	if len(contentLengthStr) > 0 {
+		var err error
 		contentLength, err = strconv.ParseInt(contentLengthStr, 10, 64)
 		if err != nil {
 			return nil, err
@@ -57,14 +99,15 @@ func requestFromHeaders(headers []hpack.HeaderField) (*http.Request, error) {
 	return &http.Request{
 		Method:        method,
 		URL:           u,
-		Proto:         "HTTP/2.0",
+		Proto:         proto,
 		ProtoMajor:    2,
 		ProtoMinor:    0,
 		Header:        httpHeaders,
+		Trailer:       trailer,
 		Body:          nil,
 		ContentLength: contentLength,
 		Host:          authority,
-		RequestURI:    path,
+		RequestURI:    requestURI,
 		TLS:           &tls.ConnectionState{},
 	}, nil
 }
@@ -2,6 +2,7 @@ package h2quic
 
 import (
 	"io"
+	"net/http"
 
 	quic "github.com/wheelcomplex/qk"
 )
@@ -9,6 +10,10 @@ import (
 type requestBody struct {
 	requestRead bool
 	dataStream  quic.Stream
+
+	// trailers is nil if the request doesn't have a body
+	trailers *trailerReceiver
+	trailer  *http.Header // the Trailer of the http.Request
 }
 
 // make sure the requestBody can be used as a http.Request.Body
@@ -20,7 +25,11 @@ func newRequestBody(stream quic.Stream) *requestBody {
 
 func (b *requestBody) Read(p []byte) (int, error) {
 	b.requestRead = true
-	return b.dataStream.Read(p)
+	n, err := b.dataStream.Read(p)
+	if err == io.EOF && b.trailers != nil {
+		b.trailers.readTrailers(b.trailer)
+	}
+	return n, err
 }
 
 func (b *requestBody) Close() error {
@@ -65,6 +65,74 @@ var _ = Describe("Request", func() {
 		}))
 	})
 
+	It("populates the declared trailers", func() {
+		headers := []hpack.HeaderField{
+			{Name: ":path", Value: "/foo"},
+			{Name: ":authority", Value: "quic.clemente.io"},
+			{Name: ":method", Value: "POST"},
+			{Name: "trailer", Value: "grpc-status, grpc-message"},
+			{Name: "trailer", Value: "content-length"},
+		}
+		req, err := requestFromHeaders(headers)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(req.Header).To(BeEmpty())
+		Expect(req.Trailer).To(Equal(http.Header{
+			"Grpc-Status":  nil,
+			"Grpc-Message": nil,
+		}))
+	})
+
+	It("populates CONNECT requests", func() {
+		headers := []hpack.HeaderField{
+			{Name: ":authority", Value: "example.com:22"},
+			{Name: ":method", Value: "CONNECT"},
+		}
+		req, err := requestFromHeaders(headers)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(req.Method).To(Equal("CONNECT"))
+		Expect(req.URL).To(Equal(&url.URL{Host: "example.com:22"}))
+		Expect(req.Host).To(Equal("example.com:22"))
+		Expect(req.RequestURI).To(Equal("example.com:22"))
+		Expect(req.Proto).To(Equal("HTTP/2.0"))
+	})
+
+	It("populates extended CONNECT requests", func() {
+		headers := []hpack.HeaderField{
+			{Name: ":authority", Value: "quic.clemente.io"},
+			{Name: ":method", Value: "CONNECT"},
+			{Name: ":protocol", Value: "websocket"},
+			{Name: ":scheme", Value: "https"},
+			{Name: ":path", Value: "/chat"},
+		}
+		req, err := requestFromHeaders(headers)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(req.Method).To(Equal("CONNECT"))
+		Expect(req.Proto).To(Equal("websocket"))
+		Expect(req.URL.Path).To(Equal("/chat"))
+		Expect(req.RequestURI).To(Equal("/chat"))
+	})
+
+	It("errors with a path in CONNECT requests", func() {
+		headers := []hpack.HeaderField{
+			{Name: ":authority", Value: "example.com:22"},
+			{Name: ":method", Value: "CONNECT"},
+			{Name: ":path", Value: "/foo"},
+		}
+		_, err := requestFromHeaders(headers)
+		Expect(err).To(MatchError(":path must be empty for CONNECT requests"))
+	})
+
+	It("errors with a protocol in requests other than CONNECT", func() {
+		headers := []hpack.HeaderField{
+			{Name: ":path", Value: "/foo"},
+			{Name: ":authority", Value: "quic.clemente.io"},
+			{Name: ":method", Value: "GET"},
+			{Name: ":protocol", Value: "websocket"},
+		}
+		_, err := requestFromHeaders(headers)
+		Expect(err).To(MatchError(":protocol must only be sent with CONNECT requests"))
+	})
+
 	It("errors with missing path", func() {
 		headers := []hpack.HeaderField{
 			{Name: ":authority", Value: "quic.clemente.io"},
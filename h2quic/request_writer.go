@@ -39,16 +39,20 @@ func newRequestWriter(headerStream quic.Stream, logger utils.Logger) *requestWri
 }
 
 func (w *requestWriter) WriteRequest(req *http.Request, dataStreamID protocol.StreamID, endStream, requestGzip bool) error {
-	// TODO: add support for trailers
 	// TODO: add support for gzip compression
-	// TODO: write continuation frames, if the header frame is too long
+	trailers, err := commaSeparatedTrailers(req)
+	if err != nil {
+		return err
+	}
 
 	w.mutex.Lock()
 	defer w.mutex.Unlock()
 
-	w.encodeHeaders(req, requestGzip, "", actualContentLength(req))
+	if _, err := w.encodeHeaders(req, requestGzip, trailers, actualContentLength(req)); err != nil {
+		return err
+	}
 	h2framer := http2.NewFramer(w.headerStream, nil)
-	return h2framer.WriteHeaders(http2.HeadersFrameParam{
+	return writeHeaders(h2framer, http2.HeadersFrameParam{
 		StreamID:      uint32(dataStreamID),
 		EndHeaders:    true,
 		EndStream:     endStream,
@@ -57,6 +61,37 @@ func (w *requestWriter) WriteRequest(req *http.Request, dataStreamID protocol.St
 	})
 }
 
+// WriteTrailers sends the request trailers in a HEADERS frame with the END_STREAM flag set
+func (w *requestWriter) WriteTrailers(trailer http.Header, dataStreamID protocol.StreamID) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.hbuf.Reset()
+	encodeTrailers(w.henc, trailer)
+	h2framer := http2.NewFramer(w.headerStream, nil)
+	return writeHeaders(h2framer, http2.HeadersFrameParam{
+		StreamID:      uint32(dataStreamID),
+		EndHeaders:    true,
+		EndStream:     true,
+		BlockFragment: w.hbuf.Bytes(),
+	})
+}
+
+// WriteSettings sends a SETTINGS frame
+func (w *requestWriter) WriteSettings(settings []http2.Setting) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return http2.NewFramer(w.headerStream, nil).WriteSettings(settings...)
+}
+
+// SetMaxDynamicTableSizeLimit limits the size of the dynamic table of the HPACK encoder.
+// If the current size is larger, the next header block starts with a dynamic table size update.
+func (w *requestWriter) SetMaxDynamicTableSizeLimit(v uint32) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.henc.SetMaxDynamicTableSizeLimit(v)
+}
+
 // the rest of this files is copied from http2.Transport
 func (w *requestWriter) encodeHeaders(req *http.Request, addGzipHeader bool, trailers string, contentLength int64) ([]byte, error) {
 	w.hbuf.Reset()
@@ -70,8 +105,12 @@ func (w *requestWriter) encodeHeaders(req *http.Request, addGzipHeader bool, tra
 		return nil, err
 	}
 
+	// Extended CONNECT requests (RFC 8441) carry the protocol in the :protocol pseudo header field,
+	// and have a :path and :scheme, just like other requests.
+	extendedConnect := isExtendedConnect(req)
+
 	var path string
-	if req.Method != "CONNECT" {
+	if req.Method != "CONNECT" || extendedConnect {
 		path = req.URL.RequestURI()
 		if !validPseudoPath(path) {
 			orig := path
@@ -106,7 +145,10 @@ func (w *requestWriter) encodeHeaders(req *http.Request, addGzipHeader bool, tra
 	// [RFC3986]).
 	w.writeHeader(":authority", host)
 	w.writeHeader(":method", req.Method)
-	if req.Method != "CONNECT" {
+	if extendedConnect {
+		w.writeHeader(":protocol", req.Proto)
+	}
+	if req.Method != "CONNECT" || extendedConnect {
 		w.writeHeader(":path", path)
 		w.writeHeader(":scheme", req.URL.Scheme)
 	}
@@ -185,6 +227,12 @@ func shouldSendReqContentLength(method string, contentLength int64) bool {
 	}
 }
 
+// isExtendedConnect says if a request is an extended CONNECT request.
+// For extended CONNECT requests, the protocol is set in the Proto field of the request, e.g. "websocket".
+func isExtendedConnect(req *http.Request) bool {
+	return req.Method == "CONNECT" && req.Proto != "" && !strings.HasPrefix(req.Proto, "HTTP/")
+}
+
 func validPseudoPath(v string) bool {
 	return (len(v) > 0 && v[0] == '/' && (len(v) == 1 || v[1] != '/')) || v == "*"
 }
@@ -115,4 +115,30 @@ var _ = Describe("Request", func() {
 			HaveKeyWithValue("cookie", `Cookie #1="Value #1"; Cookie #2="Value #2"`),
 		))
 	})
+
+	It("writes a CONNECT request", func() {
+		req, err := http.NewRequest("CONNECT", "https://quic.clemente.io", nil)
+		Expect(err).ToNot(HaveOccurred())
+		req.Host = "example.com:22"
+		Expect(rw.WriteRequest(req, 5, false, false)).To(Succeed())
+		_, headerFields := decode(headerStream.dataWritten.Bytes())
+		Expect(headerFields).To(HaveKeyWithValue(":method", "CONNECT"))
+		Expect(headerFields).To(HaveKeyWithValue(":authority", "example.com:22"))
+		Expect(headerFields).ToNot(HaveKey(":path"))
+		Expect(headerFields).ToNot(HaveKey(":scheme"))
+		Expect(headerFields).ToNot(HaveKey(":protocol"))
+	})
+
+	It("writes an extended CONNECT request", func() {
+		req, err := http.NewRequest("CONNECT", "https://quic.clemente.io/chat", nil)
+		Expect(err).ToNot(HaveOccurred())
+		req.Proto = "websocket"
+		Expect(rw.WriteRequest(req, 5, false, false)).To(Succeed())
+		_, headerFields := decode(headerStream.dataWritten.Bytes())
+		Expect(headerFields).To(HaveKeyWithValue(":method", "CONNECT"))
+		Expect(headerFields).To(HaveKeyWithValue(":protocol", "websocket"))
+		Expect(headerFields).To(HaveKeyWithValue(":authority", "quic.clemente.io"))
+		Expect(headerFields).To(HaveKeyWithValue(":path", "/chat"))
+		Expect(headerFields).To(HaveKeyWithValue(":scheme", "https"))
+	})
 })
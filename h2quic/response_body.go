@@ -0,0 +1,66 @@
+package h2quic
+
+import (
+	"context"
+	"io"
+	"net/http"
+
+	quic "github.com/wheelcomplex/qk"
+)
+
+// responseBody is the body of a response received by the client.
+// If it is closed before it was read completely, the data stream is reset,
+// such that the server notices that the client went away.
+type responseBody struct {
+	quic.Stream
+
+	eof bool
+
+	// ctx is the request context, it is nil for pushed responses
+	ctx context.Context
+
+	// trailers is nil for pushed responses
+	trailers *trailerReceiver
+	trailer  *http.Header // the Trailer of the http.Response
+}
+
+// make sure the responseBody can be used as a http.Response.Body
+var _ io.ReadCloser = &responseBody{}
+
+func newResponseBody(stream quic.Stream) *responseBody {
+	return &responseBody{Stream: stream}
+}
+
+func (b *responseBody) Read(p []byte) (int, error) {
+	n, err := b.Stream.Read(p)
+	if err == io.EOF {
+		b.eof = true
+		if b.trailers != nil {
+			b.trailers.readTrailers(b.trailer)
+		}
+	}
+	// the data stream is canceled when the request context is cancelled
+	if err != nil && err != io.EOF && b.ctx != nil && b.ctx.Err() != nil {
+		err = b.ctx.Err()
+	}
+	return n, err
+}
+
+func (b *responseBody) Close() error {
+	if b.eof {
+		return b.Stream.Close()
+	}
+	if b.trailers != nil {
+		b.trailers.close()
+	}
+	// error code 6 signals that stream was canceled
+	b.Stream.CancelRead(6)
+	// In gQUIC, canceling the read side doesn't send anything.
+	// Resetting the write side sends a RST_STREAM, which cancels the stream in both directions.
+	if err := b.Stream.CancelWrite(6); err != nil {
+		// The write side was already closed after sending the request body.
+		// In gQUIC, closing the stream then sends a RST_STREAM for the canceled read side.
+		return b.Stream.Close()
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package h2quic
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/http2/hpack"
+
+	quic "github.com/wheelcomplex/qk"
+	"github.com/wheelcomplex/qk/internal/testdata"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// a canceledStream is a stream that was canceled
+type canceledStream struct {
+	quic.Stream
+}
+
+func (s *canceledStream) Read([]byte) (int, error) { return 0, errors.New("stream canceled") }
+
+var _ = Describe("Response body", func() {
+	var (
+		stream *mockStream
+		rb     *responseBody
+	)
+
+	BeforeEach(func() {
+		stream = newMockStream(5)
+		stream.dataToRead.Write([]byte("foobar")) // provides data to be read
+		close(stream.unblockRead)
+		rb = newResponseBody(stream)
+	})
+
+	It("closes the stream after the body was read", func() {
+		data, err := ioutil.ReadAll(rb)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(data).To(Equal([]byte("foobar")))
+		Expect(rb.Close()).To(Succeed())
+		Expect(stream.closed).To(BeTrue())
+		Expect(stream.reset).To(BeFalse())
+	})
+
+	It("resets the stream when the body is closed before it was read completely", func() {
+		_, err := rb.Read(make([]byte, 3))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(rb.Close()).To(Succeed())
+		Expect(stream.reset).To(BeTrue())
+		Expect(stream.canceledWrite).To(BeTrue())
+		Expect(stream.closed).To(BeFalse())
+	})
+
+	It("closes the stream when the write side was already closed", func() {
+		stream.cancelWriteErr = errors.New("CancelWrite for closed stream 5")
+		_, err := rb.Read(make([]byte, 3))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(rb.Close()).To(Succeed())
+		Expect(stream.reset).To(BeTrue())
+		Expect(stream.closed).To(BeTrue())
+	})
+
+	It("sends a RST_STREAM when the body is closed before it was read completely", func() {
+		errChan := make(chan error, 1)
+		server := &Server{Server: &http.Server{
+			TLSConfig: testdata.GetTLSConfig(),
+			Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				for {
+					if _, err := w.Write(make([]byte, 1024)); err != nil {
+						errChan <- err
+						return
+					}
+				}
+			}),
+		}}
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		Expect(err).ToNot(HaveOccurred())
+		go server.Serve(conn)
+		defer server.Close()
+
+		rt := &RoundTripper{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+		defer rt.Close()
+		req, err := http.NewRequest("GET", fmt.Sprintf("https://localhost:%d/", conn.LocalAddr().(*net.UDPAddr).Port), nil)
+		Expect(err).ToNot(HaveOccurred())
+		rsp, err := rt.RoundTrip(req)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = rsp.Body.Read(make([]byte, 3))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(rsp.Body.Close()).To(Succeed())
+
+		// the handler only sees the error code if the client sent a RST_STREAM
+		var writeErr error
+		Eventually(errChan, 5*time.Second).Should(Receive(&writeErr))
+		streamErr, ok := writeErr.(quic.StreamError)
+		Expect(ok).To(BeTrue())
+		Expect(streamErr.Canceled()).To(BeTrue())
+		Expect(streamErr.ErrorCode()).To(BeEquivalentTo(6))
+	})
+
+	It("returns the error of the request context when it was cancelled", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		rb = newResponseBody(&canceledStream{Stream: stream})
+		rb.ctx = ctx
+		_, err := rb.Read(make([]byte, 3))
+		Expect(err).To(MatchError("stream canceled"))
+		cancel()
+		_, err = rb.Read(make([]byte, 3))
+		Expect(err).To(MatchError(context.Canceled))
+	})
+
+	Context("trailers", func() {
+		var (
+			trailers *trailerReceiver
+			trailer  http.Header
+			done     chan struct{}
+			released bool
+		)
+
+		BeforeEach(func() {
+			done = make(chan struct{})
+			released = false
+			trailers = newTrailerReceiver(done, func() { released = true })
+			trailer = nil
+			rb.trailers = trailers
+			rb.trailer = &trailer
+		})
+
+		It("adds trailers that were received before the end of the body", func() {
+			trailers.deliver([]hpack.HeaderField{{Name: "grpc-status", Value: "0"}})
+			_, err := ioutil.ReadAll(rb)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(trailer).To(Equal(http.Header{"Grpc-Status": []string{"0"}}))
+			Expect(released).To(BeTrue())
+		})
+
+		It("waits for declared trailers", func() {
+			trailer = http.Header{"Grpc-Status": nil}
+			go func() {
+				defer GinkgoRecover()
+				_, err := ioutil.ReadAll(rb)
+				Expect(err).ToNot(HaveOccurred())
+			}()
+			Consistently(func() bool { return released }).Should(BeFalse())
+			trailers.deliver([]hpack.HeaderField{
+				{Name: "grpc-status", Value: "0"},
+				{Name: "content-length", Value: "42"}, // not allowed in trailers
+			})
+			Eventually(func() bool { return released }).Should(BeTrue())
+			Expect(trailer).To(Equal(http.Header{"Grpc-Status": []string{"0"}}))
+		})
+
+		It("stops waiting for declared trailers when done is closed", func() {
+			trailer = http.Header{"Grpc-Status": nil}
+			close(done)
+			_, err := ioutil.ReadAll(rb)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(trailer).To(Equal(http.Header{"Grpc-Status": nil}))
+			Expect(released).To(BeTrue())
+		})
+
+		It("releases the trailers when the body is closed early", func() {
+			Expect(rb.Close()).To(Succeed())
+			Expect(released).To(BeTrue())
+		})
+	})
+})
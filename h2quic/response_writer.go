@@ -2,6 +2,8 @@ package h2quic
 
 import (
 	"bytes"
+	"context"
+	"fmt"
 	"net/http"
 	"strconv"
 	"strings"
@@ -10,13 +12,26 @@ import (
 	quic "github.com/wheelcomplex/qk"
 	"github.com/wheelcomplex/qk/internal/protocol"
 	"github.com/wheelcomplex/qk/internal/utils"
+	"golang.org/x/net/http/httpguts"
 	"golang.org/x/net/http2"
 	"golang.org/x/net/http2/hpack"
 )
 
+// DataStreamer lets a handler take over the data stream of a request,
+// e.g. to run a tunnel for a CONNECT request, or a bidirectional protocol.
+// The http.ResponseWriter passed to the handlers of the Server implements DataStreamer.
+type DataStreamer interface {
+	// DataStream takes over the data stream of the request.
+	// It can only be called after responding with a 2xx status code. If the header wasn't written yet, a 200 is sent.
+	// After a call to DataStream, the Server doesn't use the stream any more.
+	// The handler is responsible for reading from and writing to the stream, and for closing it.
+	// The stream may have read or write deadlines already set, depending on the configuration of the Server.
+	DataStream() (quic.Stream, error)
+}
+
 type responseWriter struct {
 	dataStreamID protocol.StreamID
-	dataStream   quic.Stream
+	dataStream   quic.Stream // nil after the handler took over the data stream
 
 	headerStream      quic.Stream
 	headerStreamMutex *sync.Mutex
@@ -24,6 +39,18 @@ type responseWriter struct {
 	header        http.Header
 	status        int // status code passed to WriteHeader
 	headerWritten bool
+	trailers      []string // the trailers declared in the Trailer header field
+
+	// push is nil if the response can't push, e.g. because it is a pushed response itself
+	push func(target string, opts *http.PushOptions) error
+
+	// settings are the settings sent by the client. If nil, the default settings are used.
+	settings *peerSettings
+
+	// ctx is the context of the request. If nil, the context of the data stream is used.
+	ctx             context.Context
+	closeNotifyOnce sync.Once
+	closeNotifyChan chan bool
 
 	logger utils.Logger
 }
@@ -57,10 +84,16 @@ func (w *responseWriter) WriteHeader(status int) {
 	w.status = status
 
 	var headers bytes.Buffer
-	enc := hpack.NewEncoder(&headers)
+	enc := w.settings.newEncoder(&headers)
 	enc.WriteField(hpack.HeaderField{Name: ":status", Value: strconv.Itoa(status)})
 
+	for _, v := range w.header["Trailer"] {
+		foreachHeaderElement(v, w.declareTrailer)
+	}
 	for k, v := range w.header {
+		if strings.HasPrefix(k, http.TrailerPrefix) {
+			continue
+		}
 		for index := range v {
 			enc.WriteField(hpack.HeaderField{Name: strings.ToLower(k), Value: v[index]})
 		}
@@ -70,7 +103,7 @@ func (w *responseWriter) WriteHeader(status int) {
 	w.headerStreamMutex.Lock()
 	defer w.headerStreamMutex.Unlock()
 	h2framer := http2.NewFramer(w.headerStream, nil)
-	err := h2framer.WriteHeaders(http2.HeadersFrameParam{
+	err := writeHeaders(h2framer, http2.HeadersFrameParam{
 		StreamID:      uint32(w.dataStreamID),
 		EndHeaders:    true,
 		BlockFragment: headers.Bytes(),
@@ -80,7 +113,56 @@ func (w *responseWriter) WriteHeader(status int) {
 	}
 }
 
+func (w *responseWriter) declareTrailer(k string) {
+	k = http.CanonicalHeaderKey(k)
+	if !httpguts.ValidTrailerHeader(k) {
+		w.logger.Debugf("Ignoring invalid trailer %q", k)
+		return
+	}
+	w.trailers = append(w.trailers, k)
+}
+
+// writeTrailers sends the trailers in a HEADERS frame with the END_STREAM flag set.
+// The trailers are the header fields declared in the Trailer header field,
+// and the header fields whose names are prefixed with http.TrailerPrefix.
+func (w *responseWriter) writeTrailers() {
+	trailer := make(http.Header)
+	for _, k := range w.trailers {
+		if vv, ok := w.header[k]; ok {
+			trailer[k] = vv
+		}
+	}
+	for k, vv := range w.header {
+		if strings.HasPrefix(k, http.TrailerPrefix) {
+			trailer[http.CanonicalHeaderKey(strings.TrimPrefix(k, http.TrailerPrefix))] = vv
+		}
+	}
+	// if trailers were declared, the client waits for them, so we need to send the HEADERS frame, even if it's empty
+	if len(w.trailers) == 0 && len(trailer) == 0 || w.dataStream == nil {
+		return
+	}
+
+	var headers bytes.Buffer
+	encodeTrailers(w.settings.newEncoder(&headers), trailer)
+
+	w.headerStreamMutex.Lock()
+	defer w.headerStreamMutex.Unlock()
+	h2framer := http2.NewFramer(w.headerStream, nil)
+	err := writeHeaders(h2framer, http2.HeadersFrameParam{
+		StreamID:      uint32(w.dataStreamID),
+		EndHeaders:    true,
+		EndStream:     true,
+		BlockFragment: headers.Bytes(),
+	})
+	if err != nil {
+		w.logger.Errorf("could not write h2 trailers: %s", err.Error())
+	}
+}
+
 func (w *responseWriter) Write(p []byte) (int, error) {
+	if w.dataStream == nil {
+		return 0, http.ErrHijacked
+	}
 	if !w.headerWritten {
 		w.WriteHeader(200)
 	}
@@ -90,14 +172,64 @@ func (w *responseWriter) Write(p []byte) (int, error) {
 	return w.dataStream.Write(p)
 }
 
-func (w *responseWriter) Flush() {}
+// Push initiates a server push. See http.Pusher for details.
+func (w *responseWriter) Push(target string, opts *http.PushOptions) error {
+	if w.push == nil {
+		return http2.ErrRecursivePush
+	}
+	return w.push(target, opts)
+}
 
-// This is a NOP. Use http.Request.Context
-func (w *responseWriter) CloseNotify() <-chan bool { return make(<-chan bool) }
+// DataStream takes over the data stream. See DataStreamer for details.
+func (w *responseWriter) DataStream() (quic.Stream, error) {
+	if w.dataStream == nil {
+		return nil, http.ErrHijacked
+	}
+	if !w.headerWritten {
+		w.WriteHeader(200)
+	}
+	if w.status < 200 || w.status > 299 {
+		return nil, fmt.Errorf("h2quic: can't take over the data stream after responding with status %d", w.status)
+	}
+	str := w.dataStream
+	w.dataStream = nil
+	return str, nil
+}
+
+// Flush sends the response header, if it hasn't been sent yet.
+// Data written to the response is not buffered, it is passed to the data stream immediately.
+func (w *responseWriter) Flush() {
+	if !w.headerWritten {
+		w.WriteHeader(200)
+	}
+}
+
+// CloseNotify returns a channel that receives a value when the data stream is reset, or when the session is closed.
+// New code should use the context of the http.Request instead.
+func (w *responseWriter) CloseNotify() <-chan bool {
+	w.closeNotifyOnce.Do(func() {
+		ctx := w.ctx
+		if ctx == nil {
+			ctx = w.dataStream.Context()
+		}
+		w.closeNotifyChan = make(chan bool, 1)
+		go func() {
+			<-ctx.Done()
+			w.closeNotifyChan <- true
+		}()
+	})
+	return w.closeNotifyChan
+}
 
 // test that we implement http.Flusher
 var _ http.Flusher = &responseWriter{}
 
+// test that we implement http.Pusher
+var _ http.Pusher = &responseWriter{}
+
+// test that we implement DataStreamer
+var _ DataStreamer = &responseWriter{}
+
 // copied from http2/http2.go
 // bodyAllowedForStatus reports whether a given response status code
 // permits a body. See RFC 2616, section 4.4.
@@ -26,10 +26,14 @@ type mockStream struct {
 	canceledWrite bool
 	closed        bool
 	remoteClosed  bool
+	readDeadline  time.Time
+	writeDeadline time.Time
 
 	unblockRead chan struct{}
 	ctx         context.Context
 	ctxCancel   context.CancelFunc
+
+	cancelWriteErr error // returned by CancelWrite
 }
 
 var _ quic.Stream = &mockStream{}
@@ -45,13 +49,13 @@ func newMockStream(id protocol.StreamID) *mockStream {
 
 func (s *mockStream) Close() error                          { s.closed = true; s.ctxCancel(); return nil }
 func (s *mockStream) CancelRead(quic.ErrorCode) error       { s.reset = true; return nil }
-func (s *mockStream) CancelWrite(quic.ErrorCode) error      { s.canceledWrite = true; return nil }
+func (s *mockStream) CancelWrite(quic.ErrorCode) error      { s.canceledWrite = true; return s.cancelWriteErr }
 func (s *mockStream) CloseRemote(offset protocol.ByteCount) { s.remoteClosed = true; s.ctxCancel() }
 func (s mockStream) StreamID() protocol.StreamID            { return s.id }
 func (s *mockStream) Context() context.Context              { return s.ctx }
 func (s *mockStream) SetDeadline(time.Time) error           { panic("not implemented") }
-func (s *mockStream) SetReadDeadline(time.Time) error       { panic("not implemented") }
-func (s *mockStream) SetWriteDeadline(time.Time) error      { panic("not implemented") }
+func (s *mockStream) SetReadDeadline(t time.Time) error     { s.readDeadline = t; return nil }
+func (s *mockStream) SetWriteDeadline(t time.Time) error    { s.writeDeadline = t; return nil }
 
 func (s *mockStream) Read(p []byte) (int, error) {
 	n, _ := s.dataToRead.Read(p)
@@ -160,4 +164,118 @@ var _ = Describe("Response Writer", func() {
 		Expect(err).To(MatchError(http.ErrBodyNotAllowed))
 		Expect(dataStream.dataWritten.Bytes()).To(HaveLen(0))
 	})
+
+	It("sends the header when flushing", func() {
+		w.Header().Add("content-type", "text/event-stream")
+		w.Flush()
+		fields := decodeHeaderFields()
+		Expect(fields).To(HaveKeyWithValue(":status", []string{"200"}))
+		Expect(fields).To(HaveKeyWithValue("content-type", []string{"text/event-stream"}))
+		// flushing again doesn't send the header again
+		l := headerStream.dataWritten.Len()
+		w.Flush()
+		Expect(headerStream.dataWritten.Len()).To(Equal(l))
+	})
+
+	Context("taking over the data stream", func() {
+		It("returns the data stream after sending a 200", func() {
+			str, err := w.DataStream()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(str).To(Equal(dataStream))
+			Expect(decodeHeaderFields()).To(HaveKeyWithValue(":status", []string{"200"}))
+			_, err = w.Write([]byte("foobar"))
+			Expect(err).To(MatchError(http.ErrHijacked))
+			_, err = w.DataStream()
+			Expect(err).To(MatchError(http.ErrHijacked))
+		})
+
+		It("doesn't return the data stream after responding with a status other than 2xx", func() {
+			w.WriteHeader(http.StatusNotFound)
+			_, err := w.DataStream()
+			Expect(err).To(MatchError("h2quic: can't take over the data stream after responding with status 404"))
+		})
+
+		It("doesn't send trailers after the data stream was taken over", func() {
+			w.Header().Set("Trailer", "Grpc-Status")
+			_, err := w.DataStream()
+			Expect(err).ToNot(HaveOccurred())
+			l := headerStream.dataWritten.Len()
+			w.writeTrailers()
+			Expect(headerStream.dataWritten.Len()).To(Equal(l))
+		})
+	})
+
+	Context("trailers", func() {
+		readTrailers := func() (*http2.HeadersFrame, map[string][]string) {
+			decoder := hpack.NewDecoder(4096, func(hf hpack.HeaderField) {})
+			h2framer := http2.NewFramer(nil, bytes.NewReader(headerStream.dataWritten.Bytes()))
+			frame, err := h2framer.ReadFrame()
+			Expect(err).ToNot(HaveOccurred())
+			_, err = decoder.DecodeFull(frame.(*http2.HeadersFrame).HeaderBlockFragment())
+			Expect(err).ToNot(HaveOccurred())
+			frame, err = h2framer.ReadFrame()
+			Expect(err).ToNot(HaveOccurred())
+			hframe := frame.(*http2.HeadersFrame)
+			fields := make(map[string][]string)
+			hfs, err := decoder.DecodeFull(hframe.HeaderBlockFragment())
+			Expect(err).ToNot(HaveOccurred())
+			for _, hf := range hfs {
+				fields[hf.Name] = append(fields[hf.Name], hf.Value)
+			}
+			return hframe, fields
+		}
+
+		It("sends declared trailers", func() {
+			w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+			w.WriteHeader(200)
+			w.Header().Set("Grpc-Status", "0")
+			w.writeTrailers()
+			Expect(decodeHeaderFields()).To(HaveKeyWithValue("trailer", []string{"Grpc-Status, Grpc-Message"}))
+			hframe, fields := readTrailers()
+			Expect(hframe.StreamID).To(BeEquivalentTo(5))
+			Expect(hframe.StreamEnded()).To(BeTrue())
+			Expect(fields).To(Equal(map[string][]string{"grpc-status": {"0"}}))
+		})
+
+		It("sends trailers prefixed with http.TrailerPrefix", func() {
+			w.Header().Set(http.TrailerPrefix+"Foo", "bar")
+			w.WriteHeader(200)
+			w.Header().Set(http.TrailerPrefix+"lorem", "ipsum")
+			w.writeTrailers()
+			Expect(decodeHeaderFields()).ToNot(HaveKey(ContainSubstring("foo")))
+			_, fields := readTrailers()
+			Expect(fields).To(Equal(map[string][]string{
+				"foo":   {"bar"},
+				"lorem": {"ipsum"},
+			}))
+		})
+
+		It("doesn't send trailers if there are none", func() {
+			w.WriteHeader(200)
+			l := headerStream.dataWritten.Len()
+			w.writeTrailers()
+			Expect(headerStream.dataWritten.Len()).To(Equal(l))
+		})
+	})
+
+	Context("CloseNotify", func() {
+		It("notifies when the data stream's context is canceled", func() {
+			dataStream = newMockStream(5)
+			w = newResponseWriter(headerStream, &sync.Mutex{}, dataStream, 5, utils.DefaultLogger)
+			closeNotify := w.CloseNotify()
+			Consistently(closeNotify).ShouldNot(Receive())
+			dataStream.ctxCancel()
+			Eventually(closeNotify).Should(Receive(BeTrue()))
+			Expect(w.CloseNotify()).To(Equal(closeNotify))
+		})
+
+		It("uses the request context, if set", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			w.ctx = ctx
+			closeNotify := w.CloseNotify()
+			Consistently(closeNotify).ShouldNot(Receive())
+			cancel()
+			Eventually(closeNotify).Should(Receive(BeTrue()))
+		})
+	})
 })
@@ -6,8 +6,10 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/http/httptrace"
 	"strings"
 	"sync"
+	"time"
 
 	quic "github.com/wheelcomplex/qk"
 
@@ -19,7 +21,38 @@ type roundTripCloser interface {
 	io.Closer
 }
 
+// A pooledClient is a client in the connection pool of the RoundTripper.
+type pooledClient interface {
+	roundTripCloser
+	// roundTripOpt is like RoundTrip.
+	// If canBlock is false, it returns errStreamLimitReached if the peer's stream limit doesn't allow opening a new stream.
+	// If the session was closed before the request was sent, it returns a *sessionClosedError.
+	roundTripOpt(req *http.Request, canBlock bool) (*http.Response, error)
+	// isClosed says if the client can't be used for new requests any more
+	isClosed() bool
+	// closeIfIdle closes the client if no requests are in flight, and says if it was closed
+	closeIfIdle() bool
+}
+
 // RoundTripper implements the http.RoundTripper interface
+//
+// If MaxResponseHeaderBytes or MaxDecoderHeaderTableSize is set, a SETTINGS frame is sent on the headers stream.
+// It also disables server push if PushHandler is nil.
+// SETTINGS frames sent by the server are applied to the HPACK encoder.
+//
+// The request is cancelled when the request context is cancelled, and the data stream is reset.
+// The RoundTripper supports the httptrace.ClientTrace hooks for GetConn, GotConn, TLSHandshakeStart, TLSHandshakeDone,
+// WroteHeaders, WroteRequest and GotFirstResponseByte, and the QUIC specific hooks of the ClientTrace.
+//
+// The RoundTripper maintains a pool of QUIC connections per host.
+// If the peer's stream limit (see quic.Config.MaxIncomingStreams) is reached on all connections to a host,
+// a new connection is dialed, up to MaxConnsPerHost.
+// Closed connections are removed from the pool, and a new connection is dialed for the next request.
+//
+// CONNECT requests are sent to the host of the request URL, and the target of the tunnel is taken from the Host of the request.
+// For extended CONNECT requests (RFC 8441), the protocol (e.g. "websocket") is set in the Proto of the request.
+// For CONNECT requests, RoundTrip returns as soon as the response header is received,
+// and the request body is sent while the response body is read.
 type RoundTripper struct {
 	mutex sync.Mutex
 
@@ -46,7 +79,37 @@ type RoundTripper struct {
 	// If Dial is nil, quic.DialAddr will be used.
 	Dial func(network, addr string, tlsCfg *tls.Config, cfg *quic.Config) (quic.Session, error)
 
-	clients map[string]roundTripCloser
+	// MaxResponseHeaderBytes specifies a limit on how many response bytes are
+	// allowed in the server's response header.
+	// Zero means to use a default limit of 10 MB.
+	MaxResponseHeaderBytes int64
+
+	// MaxDecoderHeaderTableSize optionally specifies the maximum size of the HPACK dynamic table
+	// used to decode response headers. It is sent to the server as SETTINGS_HEADER_TABLE_SIZE.
+	// If zero, the default value of 4096 is used.
+	MaxDecoderHeaderTableSize uint32
+
+	// MaxEncoderHeaderTableSize optionally specifies an upper limit for the size of the HPACK dynamic table
+	// used to encode request headers. The server can lower the size using SETTINGS_HEADER_TABLE_SIZE.
+	// If zero, the default value of 4096 is used.
+	MaxEncoderHeaderTableSize uint32
+
+	// IdleConnTimeout is the maximum amount of time a QUIC connection will remain idle before closing itself.
+	// Zero means no limit.
+	IdleConnTimeout time.Duration
+
+	// MaxConnsPerHost limits the number of QUIC connections per host.
+	// When the limit is reached, requests block until a stream can be opened on one of the connections.
+	// Zero means no limit.
+	MaxConnsPerHost int
+
+	// PushHandler is called for every response pushed by the server.
+	// The request is the promised request, and the handler is responsible for closing the response body.
+	// It is called in its own goroutine.
+	// If PushHandler is nil, all pushes are refused.
+	PushHandler func(req *http.Request, rsp *http.Response)
+
+	clients map[string][]pooledClient
 }
 
 // RoundTripOpt are options for the Transport.RoundTripOpt method.
@@ -100,11 +163,31 @@ func (r *RoundTripper) RoundTripOpt(req *http.Request, opt RoundTripOpt) (*http.
 	}
 
 	hostname := authorityAddr("https", hostnameFromRequest(req))
-	cl, err := r.getClient(hostname, opt.OnlyCachedConn)
-	if err != nil {
-		return nil, err
+	if trace := httptrace.ContextClientTrace(req.Context()); trace != nil && trace.GetConn != nil {
+		trace.GetConn(hostname)
+	}
+	tried := make(map[pooledClient]bool)
+	for {
+		cl, isNew, canBlock, err := r.getClient(hostname, tried, opt.OnlyCachedConn)
+		if err != nil {
+			closeRequestBody(req)
+			return nil, err
+		}
+		tried[cl] = true
+		rsp, err := cl.roundTripOpt(req, canBlock)
+		if err == errStreamLimitReached {
+			continue
+		}
+		if cerr, ok := err.(*sessionClosedError); ok {
+			if isNew {
+				return nil, cerr.err
+			}
+			// Nothing was sent yet, so the request can be sent on another connection.
+			// The closed connection is removed from the pool on the next iteration.
+			continue
+		}
+		return rsp, err
 	}
-	return cl.RoundTrip(req)
 }
 
 // RoundTrip does a round trip.
@@ -112,38 +195,110 @@ func (r *RoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
 	return r.RoundTripOpt(req, RoundTripOpt{})
 }
 
-func (r *RoundTripper) getClient(hostname string, onlyCached bool) (http.RoundTripper, error) {
+// getClient returns the client to use for a request.
+// It returns the first client for the host that wasn't tried yet. The request must not block on this client.
+// If all clients were tried (i.e. their stream limit is reached), it creates a new client, if possible.
+// Otherwise, the request has to block on one of the existing clients.
+func (r *RoundTripper) getClient(hostname string, tried map[pooledClient]bool, onlyCached bool) (cl pooledClient, isNew, canBlock bool, err error) {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
 
+	clients := r.removeClosedClients(hostname)
+	for _, cl := range clients {
+		if !tried[cl] {
+			return cl, false, false, nil
+		}
+	}
+	if len(clients) > 0 && (onlyCached || (r.MaxConnsPerHost > 0 && len(clients) >= r.MaxConnsPerHost)) {
+		return clients[0], false, true, nil
+	}
+	if onlyCached {
+		return nil, false, false, ErrNoCachedConn
+	}
+	cl = r.newClient(hostname)
 	if r.clients == nil {
-		r.clients = make(map[string]roundTripCloser)
+		r.clients = make(map[string][]pooledClient)
+	}
+	r.clients[hostname] = append(clients, cl)
+	return cl, true, true, nil
+}
+
+// removeClosedClients removes the clients for a host that can't be used any more.
+// It must be called with the mutex held.
+func (r *RoundTripper) removeClosedClients(hostname string) []pooledClient {
+	clients := r.clients[hostname]
+	open := make([]pooledClient, 0, len(clients))
+	for _, cl := range clients {
+		if cl.isClosed() {
+			cl.Close()
+			continue
+		}
+		open = append(open, cl)
+	}
+	if len(open) == 0 {
+		delete(r.clients, hostname)
+		return nil
+	}
+	r.clients[hostname] = open
+	return open
+}
+
+func (r *RoundTripper) newClient(hostname string) pooledClient {
+	return newClient(
+		hostname,
+		r.TLSClientConfig,
+		&roundTripperOpts{
+			DisableCompression:        r.DisableCompression,
+			MaxResponseHeaderBytes:    r.MaxResponseHeaderBytes,
+			MaxDecoderHeaderTableSize: r.MaxDecoderHeaderTableSize,
+			MaxEncoderHeaderTableSize: r.MaxEncoderHeaderTableSize,
+			IdleConnTimeout:           r.IdleConnTimeout,
+			PushHandler:               r.PushHandler,
+		},
+		r.QuicConfig,
+		r.Dial,
+	)
+}
+
+// removeClient closes and removes the clients for a host, such that a new connection is dialed for the next request
+func (r *RoundTripper) removeClient(hostname string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, client := range r.clients[hostname] {
+		client.Close()
 	}
+	delete(r.clients, hostname)
+}
 
-	client, ok := r.clients[hostname]
-	if !ok {
-		if onlyCached {
-			return nil, ErrNoCachedConn
+// CloseIdleConnections closes the QUIC connections that don't have any requests in flight.
+// It doesn't interrupt any connections currently in use.
+func (r *RoundTripper) CloseIdleConnections() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for hostname, clients := range r.clients {
+		var inUse []pooledClient
+		for _, cl := range clients {
+			if !cl.closeIfIdle() {
+				inUse = append(inUse, cl)
+			}
+		}
+		if len(inUse) == 0 {
+			delete(r.clients, hostname)
+		} else {
+			r.clients[hostname] = inUse
 		}
-		client = newClient(
-			hostname,
-			r.TLSClientConfig,
-			&roundTripperOpts{DisableCompression: r.DisableCompression},
-			r.QuicConfig,
-			r.Dial,
-		)
-		r.clients[hostname] = client
-	}
-	return client, nil
+	}
 }
 
 // Close closes the QUIC connections that this RoundTripper has used
 func (r *RoundTripper) Close() error {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
-	for _, client := range r.clients {
-		if err := client.Close(); err != nil {
-			return err
+	for _, clients := range r.clients {
+		for _, client := range clients {
+			if err := client.Close(); err != nil {
+				return err
+			}
 		}
 	}
 	r.clients = nil
@@ -0,0 +1,276 @@
+package h2quic
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	quic "github.com/wheelcomplex/qk"
+	"github.com/wheelcomplex/qk/internal/protocol"
+)
+
+const (
+	defaultBrokenAltSvcBackoff    = 5 * time.Minute
+	defaultMaxBrokenAltSvcBackoff = 48 * time.Hour
+)
+
+// AltSvcRoundTripper implements the http.RoundTripper interface.
+// It sends requests over TCP, and discovers QUIC support of the server using the Alt-Svc header field (RFC 7838),
+// as it is set by Server.SetQuicHeaders.
+// Subsequent requests to an origin that advertised QUIC support are sent over QUIC.
+// If QUIC fails, the request is retried over TCP, and the alternative service isn't used for some time.
+type AltSvcRoundTripper struct {
+	// Transport is the RoundTripper used for requests sent over TCP.
+	// If nil, http.DefaultTransport is used.
+	Transport http.RoundTripper
+
+	// DisableCompression, TLSClientConfig, QuicConfig and Dial are used for requests sent over QUIC.
+	// See RoundTripper for their meaning.
+	// Dial is called with the address of the alternative service.
+	DisableCompression bool
+	TLSClientConfig    *tls.Config
+	QuicConfig         *quic.Config
+	Dial               func(network, addr string, tlsCfg *tls.Config, cfg *quic.Config) (quic.Session, error)
+
+	// BrokenAltSvcBackoff is the time an alternative service isn't used after a QUIC request to it failed.
+	// It doubles for every consecutive failure, up to MaxBrokenAltSvcBackoff.
+	// If zero, 5 minutes are used.
+	BrokenAltSvcBackoff time.Duration
+	// MaxBrokenAltSvcBackoff is the maximum backoff. If zero, 48 hours are used.
+	MaxBrokenAltSvcBackoff time.Duration
+
+	initOnce sync.Once
+	quic     *RoundTripper
+
+	mutex   sync.Mutex
+	altSvcs map[string]*altSvcEntry  // indexed by origin
+	broken  map[string]*brokenAltSvc // indexed by origin
+}
+
+var _ roundTripCloser = &AltSvcRoundTripper{}
+
+func (r *AltSvcRoundTripper) init() {
+	r.quic = &RoundTripper{
+		DisableCompression: r.DisableCompression,
+		TLSClientConfig:    r.TLSClientConfig,
+		QuicConfig:         r.QuicConfig,
+		Dial:               r.dial,
+	}
+	r.altSvcs = make(map[string]*altSvcEntry)
+	r.broken = make(map[string]*brokenAltSvc)
+}
+
+// RoundTrip does a round trip.
+// The request is sent over QUIC if the origin advertised a QUIC alternative service.
+func (r *AltSvcRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	r.initOnce.Do(r.init)
+
+	var origin string
+	if req.URL != nil && req.URL.Scheme == "https" {
+		origin = authorityAddr("https", hostnameFromRequest(req))
+		if r.getAltSvc(origin, time.Now()) != nil {
+			rsp, err := r.quic.RoundTrip(req)
+			if err == nil {
+				r.markWorking(origin)
+				r.handleResponse(origin, rsp)
+				return rsp, nil
+			}
+			if req.Context().Err() != nil {
+				return nil, err
+			}
+			r.markBroken(origin, time.Now())
+			r.quic.removeClient(origin)
+			var ok bool
+			if req, ok = rewindBody(req); !ok {
+				return nil, err
+			}
+		}
+	}
+
+	rsp, err := r.transport().RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if origin != "" {
+		r.handleResponse(origin, rsp)
+	}
+	return rsp, nil
+}
+
+// Close closes the QUIC connections, and the idle TCP connections of the Transport.
+func (r *AltSvcRoundTripper) Close() error {
+	r.initOnce.Do(r.init)
+	if t, ok := r.transport().(interface{ CloseIdleConnections() }); ok {
+		t.CloseIdleConnections()
+	}
+	return r.quic.Close()
+}
+
+// CloseIdleConnections closes the idle QUIC connections, and the idle TCP connections of the Transport.
+func (r *AltSvcRoundTripper) CloseIdleConnections() {
+	r.initOnce.Do(r.init)
+	if t, ok := r.transport().(interface{ CloseIdleConnections() }); ok {
+		t.CloseIdleConnections()
+	}
+	r.quic.CloseIdleConnections()
+}
+
+func (r *AltSvcRoundTripper) transport() http.RoundTripper {
+	if r.Transport != nil {
+		return r.Transport
+	}
+	return http.DefaultTransport
+}
+
+func (r *AltSvcRoundTripper) supportedVersions() []quic.VersionNumber {
+	if r.QuicConfig != nil && len(r.QuicConfig.Versions) > 0 {
+		return r.QuicConfig.Versions
+	}
+	return protocol.SupportedVersions
+}
+
+// handleResponse updates the alternative services of the origin from the Alt-Svc header fields.
+// Malformed header fields are ignored.
+func (r *AltSvcRoundTripper) handleResponse(origin string, rsp *http.Response) {
+	values, ok := rsp.Header["Alt-Svc"]
+	if !ok {
+		return
+	}
+	now := time.Now()
+	for _, value := range values {
+		alternatives, clear, err := parseAltSvc(value)
+		if err != nil {
+			continue
+		}
+		if clear {
+			r.mutex.Lock()
+			delete(r.altSvcs, origin)
+			r.mutex.Unlock()
+			continue
+		}
+		for _, alt := range alternatives {
+			if alt.protocol != altSvcProtocolQUIC {
+				continue
+			}
+			versions := matchAltSvcVersions(alt.versions, r.supportedVersions())
+			if len(versions) == 0 {
+				continue
+			}
+			r.mutex.Lock()
+			r.altSvcs[origin] = &altSvcEntry{
+				altSvc:   alt,
+				versions: versions,
+				expires:  now.Add(alt.maxAge),
+			}
+			r.mutex.Unlock()
+			return
+		}
+	}
+}
+
+// getAltSvc returns the alternative service that should be used for requests to the origin.
+// It returns nil if there's no fresh alternative service, or if it is marked as broken.
+func (r *AltSvcRoundTripper) getAltSvc(origin string, now time.Time) *altSvcEntry {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	entry, ok := r.altSvcs[origin]
+	if !ok {
+		return nil
+	}
+	if !now.Before(entry.expires) {
+		delete(r.altSvcs, origin)
+		return nil
+	}
+	if b, ok := r.broken[origin]; ok && now.Before(b.until) {
+		return nil
+	}
+	return entry
+}
+
+func (r *AltSvcRoundTripper) markBroken(origin string, now time.Time) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	b, ok := r.broken[origin]
+	if !ok {
+		b = &brokenAltSvc{}
+		r.broken[origin] = b
+	}
+	backoff := r.BrokenAltSvcBackoff
+	if backoff == 0 {
+		backoff = defaultBrokenAltSvcBackoff
+	}
+	maxBackoff := r.MaxBrokenAltSvcBackoff
+	if maxBackoff == 0 {
+		maxBackoff = defaultMaxBrokenAltSvcBackoff
+	}
+	for i := 0; i < b.numFailures && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+	b.numFailures++
+	b.until = now.Add(backoff)
+}
+
+func (r *AltSvcRoundTripper) markWorking(origin string) {
+	r.mutex.Lock()
+	delete(r.broken, origin)
+	r.mutex.Unlock()
+}
+
+// dial dials the alternative service of the origin.
+// The certificate presented by the alternative service must be valid for the origin.
+func (r *AltSvcRoundTripper) dial(network, origin string, tlsConf *tls.Config, config *quic.Config) (quic.Session, error) {
+	entry := r.getAltSvc(origin, time.Now())
+	if entry == nil {
+		return nil, errNoAltSvc
+	}
+	host, _, err := net.SplitHostPort(origin)
+	if err != nil {
+		return nil, err
+	}
+	altHost := entry.host
+	if altHost == "" {
+		altHost = host
+	}
+	addr := net.JoinHostPort(altHost, entry.port)
+
+	if tlsConf == nil {
+		tlsConf = &tls.Config{}
+	} else {
+		tlsConf = tlsConf.Clone()
+	}
+	if tlsConf.ServerName == "" {
+		tlsConf.ServerName = host
+	}
+	conf := *config
+	conf.Versions = entry.versions
+
+	if r.Dial != nil {
+		return r.Dial(network, addr, tlsConf, &conf)
+	}
+	return dialAddr(addr, tlsConf, &conf)
+}
+
+// rewindBody returns a request that can be sent again.
+// This is only possible if the request doesn't have a body, or if the body can be obtained again.
+func rewindBody(req *http.Request) (*http.Request, bool) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return req, true
+	}
+	if req.GetBody == nil {
+		return nil, false
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, false
+	}
+	newReq := *req
+	newReq.Body = body
+	return &newReq, true
+}
@@ -2,30 +2,60 @@ package h2quic
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"errors"
 	"io"
 	"net/http"
+	"net/http/httptrace"
 	"time"
 
-	quic "github.com/wheelcomplex/qk"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	quic "github.com/wheelcomplex/qk"
 )
 
 type mockClient struct {
 	closed bool
+	inUse  bool
+	// roundTripErrs are returned by subsequent calls to roundTripOpt
+	roundTripErrs []error
+	requests      []*http.Request
+	canBlock      []bool
 }
 
 func (m *mockClient) RoundTrip(req *http.Request) (*http.Response, error) {
+	return m.roundTripOpt(req, true)
+}
+func (m *mockClient) roundTripOpt(req *http.Request, canBlock bool) (*http.Response, error) {
+	m.requests = append(m.requests, req)
+	m.canBlock = append(m.canBlock, canBlock)
+	if len(m.roundTripErrs) > 0 {
+		err := m.roundTripErrs[0]
+		m.roundTripErrs = m.roundTripErrs[1:]
+		if _, ok := err.(*sessionClosedError); ok {
+			m.closed = true
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
 	return &http.Response{Request: req}, nil
 }
+func (m *mockClient) isClosed() bool { return m.closed }
+func (m *mockClient) closeIfIdle() bool {
+	if m.inUse {
+		return false
+	}
+	m.closed = true
+	return true
+}
 func (m *mockClient) Close() error {
 	m.closed = true
 	return nil
 }
 
-var _ roundTripCloser = &mockClient{}
+var _ pooledClient = &mockClient{}
 
 type mockBody struct {
 	reader   bytes.Reader
@@ -75,7 +105,10 @@ var _ = Describe("RoundTripper", func() {
 			dialAddr = func(addr string, tlsConf *tls.Config, config *quic.Config) (quic.Session, error) {
 				// return an error when trying to open a stream
 				// we don't want to test all the dial logic here, just that dialing happens at all
-				return &mockSession{streamOpenErr: streamOpenErr}, nil
+				sess := newMockSession()
+				sess.ctx, sess.ctxCancel = context.WithCancel(context.Background())
+				sess.streamOpenErr = streamOpenErr
+				return sess, nil
 			}
 		})
 
@@ -114,7 +147,15 @@ var _ = Describe("RoundTripper", func() {
 			Expect(dialed).To(BeTrue())
 		})
 
-		It("reuses existing clients", func() {
+		It("redials if dialing failed before", func() {
+			var dialCount int
+			dialAddr = func(addr string, tlsConf *tls.Config, config *quic.Config) (quic.Session, error) {
+				dialCount++
+				sess := newMockSession()
+				sess.ctx, sess.ctxCancel = context.WithCancel(context.Background())
+				sess.streamOpenErr = streamOpenErr
+				return sess, nil
+			}
 			req, err := http.NewRequest("GET", "https://quic.clemente.io/file1.html", nil)
 			Expect(err).ToNot(HaveOccurred())
 			_, err = rt.RoundTrip(req)
@@ -125,6 +166,15 @@ var _ = Describe("RoundTripper", func() {
 			_, err = rt.RoundTrip(req2)
 			Expect(err).To(MatchError(streamOpenErr))
 			Expect(rt.clients).To(HaveLen(1))
+			Expect(rt.clients["quic.clemente.io:443"]).To(HaveLen(1))
+			Expect(dialCount).To(Equal(2))
+		})
+
+		It("closes the request body if RoundTripOpt.OnlyCachedConn is set and no client exists", func() {
+			req1.Body = &mockBody{}
+			_, err := rt.RoundTripOpt(req1, RoundTripOpt{OnlyCachedConn: true})
+			Expect(err).To(MatchError(ErrNoCachedConn))
+			Expect(req1.Body.(*mockBody).closed).To(BeTrue())
 		})
 
 		It("doesn't create new clients if RoundTripOpt.OnlyCachedConn is set", func() {
@@ -197,11 +247,135 @@ var _ = Describe("RoundTripper", func() {
 		})
 	})
 
+	Context("connection pool", func() {
+		const hostname = "www.example.org:443"
+
+		origDialAddr := dialAddr
+
+		BeforeEach(func() {
+			origDialAddr = dialAddr
+		})
+
+		AfterEach(func() {
+			dialAddr = origDialAddr
+		})
+
+		It("reuses existing clients", func() {
+			cl := &mockClient{}
+			rt.clients = map[string][]pooledClient{hostname: {cl}}
+			rsp, err := rt.RoundTrip(req1)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(rsp.Request).To(Equal(req1))
+			Expect(cl.requests).To(Equal([]*http.Request{req1}))
+			Expect(cl.canBlock).To(Equal([]bool{false}))
+		})
+
+		It("calls the GetConn trace hook", func() {
+			var hostPort string
+			trace := &httptrace.ClientTrace{GetConn: func(h string) { hostPort = h }}
+			rt.clients = map[string][]pooledClient{hostname: {&mockClient{}}}
+			_, err := rt.RoundTrip(req1.WithContext(httptrace.WithClientTrace(context.Background(), trace)))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(hostPort).To(Equal(hostname))
+		})
+
+		It("uses the next client if the stream limit is reached", func() {
+			cl1 := &mockClient{roundTripErrs: []error{errStreamLimitReached}}
+			cl2 := &mockClient{}
+			rt.clients = map[string][]pooledClient{hostname: {cl1, cl2}}
+			_, err := rt.RoundTrip(req1)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cl1.requests).To(HaveLen(1))
+			Expect(cl2.requests).To(Equal([]*http.Request{req1}))
+		})
+
+		It("dials a new connection if the stream limit is reached on all connections", func() {
+			var dialed bool
+			dialAddr = func(addr string, tlsConf *tls.Config, config *quic.Config) (quic.Session, error) {
+				dialed = true
+				return nil, errors.New("dial error")
+			}
+			cl := &mockClient{roundTripErrs: []error{errStreamLimitReached}}
+			rt.clients = map[string][]pooledClient{hostname: {cl}}
+			_, err := rt.RoundTrip(req1)
+			Expect(err).To(MatchError("dial error"))
+			Expect(dialed).To(BeTrue())
+			Expect(cl.closed).To(BeFalse())
+			Expect(rt.clients[hostname]).To(HaveLen(2))
+			Expect(rt.clients[hostname][0]).To(Equal(cl))
+			// dialing failed, so the new client is closed
+			Expect(rt.clients[hostname][1].isClosed()).To(BeTrue())
+		})
+
+		It("waits for a stream if MaxConnsPerHost is reached", func() {
+			rt.MaxConnsPerHost = 2
+			cl1 := &mockClient{roundTripErrs: []error{errStreamLimitReached}}
+			cl2 := &mockClient{roundTripErrs: []error{errStreamLimitReached}}
+			rt.clients = map[string][]pooledClient{hostname: {cl1, cl2}}
+			_, err := rt.RoundTrip(req1)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cl1.canBlock).To(Equal([]bool{false, true}))
+			Expect(cl2.canBlock).To(Equal([]bool{false}))
+		})
+
+		It("waits for a stream if RoundTripOpt.OnlyCachedConn is set", func() {
+			cl := &mockClient{roundTripErrs: []error{errStreamLimitReached}}
+			rt.clients = map[string][]pooledClient{hostname: {cl}}
+			_, err := rt.RoundTripOpt(req1, RoundTripOpt{OnlyCachedConn: true})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cl.canBlock).To(Equal([]bool{false, true}))
+		})
+
+		It("retries requests on a different connection if the session was closed before the request was sent", func() {
+			cl1 := &mockClient{roundTripErrs: []error{&sessionClosedError{errors.New("closed")}}}
+			cl2 := &mockClient{}
+			rt.clients = map[string][]pooledClient{hostname: {cl1, cl2}}
+			_, err := rt.RoundTrip(req1)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cl2.requests).To(Equal([]*http.Request{req1}))
+			Expect(rt.clients[hostname]).To(Equal([]pooledClient{cl2}))
+		})
+
+		It("doesn't retry requests that failed after they were sent", func() {
+			testErr := errors.New("request failed")
+			cl1 := &mockClient{roundTripErrs: []error{testErr}}
+			cl2 := &mockClient{}
+			rt.clients = map[string][]pooledClient{hostname: {cl1, cl2}}
+			_, err := rt.RoundTrip(req1)
+			Expect(err).To(MatchError(testErr))
+			Expect(cl2.requests).To(BeEmpty())
+		})
+
+		It("removes closed clients", func() {
+			cl1 := &mockClient{closed: true}
+			cl2 := &mockClient{}
+			rt.clients = map[string][]pooledClient{hostname: {cl1, cl2}}
+			_, err := rt.RoundTrip(req1)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cl1.requests).To(BeEmpty())
+			Expect(rt.clients[hostname]).To(Equal([]pooledClient{cl2}))
+		})
+
+		It("closes idle connections", func() {
+			cl1 := &mockClient{}
+			cl2 := &mockClient{inUse: true}
+			cl3 := &mockClient{}
+			rt.clients = map[string][]pooledClient{
+				hostname:      {cl1, cl2},
+				"foo.bar:443": {cl3},
+			}
+			rt.CloseIdleConnections()
+			Expect(cl1.closed).To(BeTrue())
+			Expect(cl2.closed).To(BeFalse())
+			Expect(cl3.closed).To(BeTrue())
+			Expect(rt.clients).To(Equal(map[string][]pooledClient{hostname: {cl2}}))
+		})
+	})
+
 	Context("closing", func() {
 		It("closes", func() {
-			rt.clients = make(map[string]roundTripCloser)
 			cl := &mockClient{}
-			rt.clients["foo.bar"] = cl
+			rt.clients = map[string][]pooledClient{"foo.bar": {cl}}
 			err := rt.Close()
 			Expect(err).ToNot(HaveOccurred())
 			Expect(len(rt.clients)).To(BeZero())
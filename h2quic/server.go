@@ -1,11 +1,14 @@
 package h2quic
 
 import (
+	"bytes"
+	"context"
 	"crypto/tls"
 	"errors"
 	"fmt"
 	"net"
 	"net/http"
+	"net/url"
 	"runtime"
 	"strings"
 	"sync"
@@ -16,6 +19,7 @@ import (
 	"github.com/wheelcomplex/qk/internal/protocol"
 	"github.com/wheelcomplex/qk/internal/utils"
 	"github.com/wheelcomplex/qk/qerr"
+	"golang.org/x/net/http/httpguts"
 	"golang.org/x/net/http2"
 	"golang.org/x/net/http2/hpack"
 )
@@ -36,6 +40,17 @@ var (
 )
 
 // Server is a HTTP2 server listening for QUIC connections.
+//
+// The following fields of the http.Server are used:
+// ReadTimeout and WriteTimeout set the deadlines of the data stream of every request.
+// A new session is closed if it doesn't send a request within the ReadHeaderTimeout (or the ReadTimeout, if zero).
+// A session is closed when no requests are served for the IdleTimeout (or the ReadTimeout, if zero).
+// ConnState, BaseContext and ConnContext are called with a net.Conn that represents the QUIC session,
+// and a net.Listener that represents the QUIC listener. They can't be used to read or write data.
+// Errors, e.g. from panicking handlers, are logged to the ErrorLog.
+//
+// If MaxHeaderBytes or MaxDecoderHeaderTableSize is set, the Server sends a SETTINGS frame on the headers stream.
+// SETTINGS frames sent by the client are applied to the HPACK encoders, and pushes are not sent if the client disabled them.
 type Server struct {
 	*http.Server
 
@@ -43,6 +58,16 @@ type Server struct {
 	// If nil, it uses reasonable default values.
 	QuicConfig *quic.Config
 
+	// MaxDecoderHeaderTableSize optionally specifies the maximum size of the HPACK dynamic table
+	// used to decode request headers. It is sent to the client as SETTINGS_HEADER_TABLE_SIZE.
+	// If zero, the default value of 4096 is used.
+	MaxDecoderHeaderTableSize uint32
+
+	// MaxEncoderHeaderTableSize optionally specifies an upper limit for the size of the HPACK dynamic table
+	// used to encode response headers. The client can lower the size using SETTINGS_HEADER_TABLE_SIZE.
+	// If zero, the default value of 4096 is used.
+	MaxEncoderHeaderTableSize uint32
+
 	// Private flag for demo, do not use
 	CloseAfterFirstRequest bool
 
@@ -54,9 +79,38 @@ type Server struct {
 
 	supportedVersionsAsString string
 
+	baseCtx context.Context // returned by the BaseContext hook
+
+	requestTrailersMutex sync.Mutex
+	requestTrailers      map[quic.Stream]*trailerReceiver // for requests whose body wasn't received yet
+
 	logger utils.Logger // will be set by Server.serveImpl()
 }
 
+func (s *Server) baseContext() context.Context {
+	if s.baseCtx != nil {
+		return s.baseCtx
+	}
+	return context.Background()
+}
+
+// idleTimeout is the time after which a session without active requests is closed
+func (s *Server) idleTimeout() time.Duration {
+	if s.IdleTimeout != 0 {
+		return s.IdleTimeout
+	}
+	return s.ReadTimeout
+}
+
+// logf logs errors to the ErrorLog of the http.Server, if set
+func (s *Server) logf(format string, args ...interface{}) {
+	if s.ErrorLog != nil {
+		s.ErrorLog.Printf(format, args...)
+	} else {
+		s.logger.Errorf(format, args...)
+	}
+}
+
 // ListenAndServe listens on the UDP address s.Addr and calls s.Handler to handle HTTP/2 requests on incoming connections.
 func (s *Server) ListenAndServe() error {
 	if s.Server == nil {
@@ -115,26 +169,43 @@ func (s *Server) serveImpl(tlsConfig *tls.Config, conn net.PacketConn) error {
 	s.listener = ln
 	s.listenerMutex.Unlock()
 
+	if s.BaseContext != nil {
+		s.baseCtx = s.BaseContext(&netListener{ln: ln})
+		if s.baseCtx == nil {
+			panic("BaseContext returned a nil context")
+		}
+	}
+
 	for {
 		sess, err := ln.Accept()
 		if err != nil {
 			return err
 		}
-		go s.handleHeaderStream(sess.(streamCreator))
+		go s.handleHeaderStream(newServerSession(s, sess.(streamCreator)))
 	}
 }
 
-func (s *Server) handleHeaderStream(session streamCreator) {
+func (s *Server) handleHeaderStream(session *serverSession) {
 	stream, err := session.AcceptStream()
 	if err != nil {
 		session.CloseWithError(quic.ErrorCode(qerr.InvalidHeadersStreamData), err)
 		return
 	}
 
-	hpackDecoder := hpack.NewDecoder(4096, nil)
+	hpackDecoder := newHeaderDecoder(s.MaxDecoderHeaderTableSize)
 	h2framer := http2.NewFramer(nil, stream)
 
 	var headerStreamMutex sync.Mutex // Protects concurrent calls to Write()
+	var maxHeaderListSizeSetting uint32
+	if s.MaxHeaderBytes > 0 {
+		maxHeaderListSizeSetting = maxHeaderListSize(s.Server)
+	}
+	if settings := localSettings(s.MaxDecoderHeaderTableSize, maxHeaderListSizeSetting, false); len(settings) > 0 {
+		if err := http2.NewFramer(stream, nil).WriteSettings(settings...); err != nil {
+			session.CloseWithError(quic.ErrorCode(qerr.InternalError), err)
+			return
+		}
+	}
 	for {
 		if err := s.handleRequest(session, stream, &headerStreamMutex, hpackDecoder, h2framer); err != nil {
 			// QuicErrors must originate from stream.Read() returning an error.
@@ -143,7 +214,7 @@ func (s *Server) handleHeaderStream(session streamCreator) {
 			errorCode := qerr.InternalError
 			if qerr, ok := err.(*qerr.QuicError); ok {
 				errorCode = qerr.ErrorCode
-				s.logger.Errorf("error handling h2 request: %s", err.Error())
+				s.logf("error handling h2 request: %s", err.Error())
 			}
 			session.CloseWithError(quic.ErrorCode(errorCode), err)
 			return
@@ -151,7 +222,7 @@ func (s *Server) handleHeaderStream(session streamCreator) {
 	}
 }
 
-func (s *Server) handleRequest(session streamCreator, headerStream quic.Stream, headerStreamMutex *sync.Mutex, hpackDecoder *hpack.Decoder, h2framer *http2.Framer) error {
+func (s *Server) handleRequest(session *serverSession, headerStream quic.Stream, headerStreamMutex *sync.Mutex, hpackDecoder *hpack.Decoder, h2framer *http2.Framer) error {
 	h2frame, err := h2framer.ReadFrame()
 	if err != nil {
 		return qerr.Error(qerr.HeadersStreamDataDecompressFailure, "cannot read frame")
@@ -162,99 +233,331 @@ func (s *Server) handleRequest(session streamCreator, headerStream quic.Stream,
 		// ignore PRIORITY frames
 		s.logger.Debugf("Ignoring H2 PRIORITY frame: %#v", f)
 		return nil
+	case *http2.SettingsFrame:
+		return session.settings.handleSettingsFrame(f)
 	case *http2.HeadersFrame:
 		h2headersFrame = f
 	default:
 		return qerr.Error(qerr.InvalidHeadersStreamData, "expected a header frame")
 	}
 
-	if !h2headersFrame.HeadersEnded() {
-		return errors.New("http2 header continuation not implemented")
-	}
-	headers, err := hpackDecoder.DecodeFull(h2headersFrame.HeaderBlockFragment())
+	maxHeaderListSize := maxHeaderListSize(s.Server)
+	headers, truncated, err := readHeaderBlock(h2framer, hpackDecoder, h2headersFrame, maxHeaderListSize)
 	if err != nil {
 		s.logger.Errorf("invalid http2 headers encoding: %s", err.Error())
 		return err
 	}
 
-	req, err := requestFromHeaders(headers)
+	dataStreamID := protocol.StreamID(h2headersFrame.StreamID)
+	dataStream, err := session.GetOrOpenStream(dataStreamID)
 	if err != nil {
 		return err
 	}
+	// this can happen if the client immediately closes the data stream after sending the request and the runtime processes the reset before the request
+	if dataStream == nil {
+		return nil
+	}
 
-	if s.logger.Debug() {
-		s.logger.Infof("%s %s%s, on data stream %d", req.Method, req.Host, req.RequestURI, h2headersFrame.StreamID)
-	} else {
-		s.logger.Infof("%s %s%s", req.Method, req.Host, req.RequestURI)
+	if isTrailers(h2headersFrame, headers) {
+		if truncated {
+			s.logger.Infof("Dropping request trailers on data stream %d that exceed the limit of %d bytes", dataStreamID, maxHeaderListSize)
+			headers = nil
+		}
+		s.deliverRequestTrailers(dataStream, headers)
+		return nil
+	}
+
+	if truncated {
+		s.logger.Infof("Request headers on data stream %d exceed the limit of %d bytes", dataStreamID, maxHeaderListSize)
+		go s.rejectRequest(session, headerStream, headerStreamMutex, dataStream, dataStreamID, h2headersFrame.StreamEnded())
+		return nil
 	}
 
-	dataStream, err := session.GetOrOpenStream(protocol.StreamID(h2headersFrame.StreamID))
+	req, err := requestFromHeaders(headers)
 	if err != nil {
 		return err
 	}
-	// this can happen if the client immediately closes the data stream after sending the request and the runtime processes the reset before the request
-	if dataStream == nil {
-		return nil
+
+	if s.logger.Debug() {
+		s.logger.Infof("%s %s%s, on data stream %d", req.Method, req.Host, req.RequestURI, dataStreamID)
+	} else {
+		s.logger.Infof("%s %s%s", req.Method, req.Host, req.RequestURI)
 	}
 
 	// handleRequest should be as non-blocking as possible to minimize
 	// head-of-line blocking. Potentially blocking code is run in a separate
 	// goroutine, enabling handleRequest to return before the code is executed.
-	go func() {
-		streamEnded := h2headersFrame.StreamEnded()
-		if streamEnded {
-			dataStream.(remoteCloser).CloseRemote(0)
-			streamEnded = true
-			_, _ = dataStream.Read([]byte{0}) // read the eof
-		}
+	var trailers *trailerReceiver
+	if !h2headersFrame.StreamEnded() {
+		// The request trailers might arrive before serveRequest runs, so we need to register here.
+		trailers = s.expectRequestTrailers(dataStream)
+	}
+	session.requestStarted()
+	go s.serveRequest(session, headerStream, headerStreamMutex, dataStream, dataStreamID, req, h2headersFrame.StreamEnded(), trailers, false)
 
-		req = req.WithContext(dataStream.Context())
-		reqBody := newRequestBody(dataStream)
-		req.Body = reqBody
+	return nil
+}
 
-		req.RemoteAddr = session.RemoteAddr().String()
+// rejectRequest responds with a 431, for requests whose headers exceed the limit
+func (s *Server) rejectRequest(session *serverSession, headerStream quic.Stream, headerStreamMutex *sync.Mutex, dataStream quic.Stream, dataStreamID protocol.StreamID, streamEnded bool) {
+	if streamEnded {
+		dataStream.(remoteCloser).CloseRemote(0)
+		_, _ = dataStream.Read([]byte{0}) // read the eof
+	} else {
+		// in gQUIC, the error code doesn't matter, so just use 0 here
+		dataStream.CancelRead(0)
+	}
+	responseWriter := newResponseWriter(headerStream, headerStreamMutex, dataStream, dataStreamID, s.logger)
+	responseWriter.settings = session.settings
+	handleHeaderListTooLong(responseWriter)
+	dataStream.Close()
+}
 
-		responseWriter := newResponseWriter(headerStream, headerStreamMutex, dataStream, protocol.StreamID(h2headersFrame.StreamID), s.logger)
+// expectRequestTrailers returns the trailerReceiver for the trailers of the request on the data stream
+func (s *Server) expectRequestTrailers(dataStream quic.Stream) *trailerReceiver {
+	s.requestTrailersMutex.Lock()
+	defer s.requestTrailersMutex.Unlock()
+	if s.requestTrailers == nil {
+		s.requestTrailers = make(map[quic.Stream]*trailerReceiver)
+	}
+	t := newTrailerReceiver(dataStream.Context().Done(), func() {
+		s.requestTrailersMutex.Lock()
+		delete(s.requestTrailers, dataStream)
+		s.requestTrailersMutex.Unlock()
+	})
+	s.requestTrailers[dataStream] = t
+	return t
+}
 
-		handler := s.Handler
-		if handler == nil {
-			handler = http.DefaultServeMux
+func (s *Server) deliverRequestTrailers(dataStream quic.Stream, fields []hpack.HeaderField) {
+	s.requestTrailersMutex.Lock()
+	t, ok := s.requestTrailers[dataStream]
+	s.requestTrailersMutex.Unlock()
+	if !ok {
+		s.logger.Debugf("Ignoring trailers for data stream %d", dataStream.StreamID())
+		return
+	}
+	t.deliver(fields)
+}
+
+// serveRequest runs the handler for a request, and sends the response on the data stream.
+// For pushed requests, the handler isn't allowed to push any further responses.
+func (s *Server) serveRequest(
+	session *serverSession,
+	headerStream quic.Stream,
+	headerStreamMutex *sync.Mutex,
+	dataStream quic.Stream,
+	dataStreamID protocol.StreamID,
+	req *http.Request,
+	streamEnded bool,
+	trailers *trailerReceiver,
+	pushed bool,
+) {
+	if trailers != nil {
+		defer trailers.close()
+	}
+	if s.ReadTimeout > 0 {
+		dataStream.SetReadDeadline(time.Now().Add(s.ReadTimeout))
+	}
+	if s.WriteTimeout > 0 {
+		dataStream.SetWriteDeadline(time.Now().Add(s.WriteTimeout))
+	}
+	if streamEnded {
+		dataStream.(remoteCloser).CloseRemote(0)
+		_, _ = dataStream.Read([]byte{0}) // read the eof
+	}
+
+	// The request context is derived from the context of the session, which carries the values set by the
+	// BaseContext and ConnContext hooks. It is canceled when the peer resets the data stream, or when the session is closed.
+	// We only close the data stream after the handler returned.
+	ctx, cancel := context.WithCancel(session.ctx)
+	defer cancel()
+	if dataStream.Context().Err() != nil {
+		cancel()
+	}
+	go func() {
+		select {
+		case <-dataStream.Context().Done():
+			cancel()
+		// cancel the request as soon as the session is closed, without waiting for the session to close all streams
+		case <-session.Context().Done():
+			cancel()
+		case <-ctx.Done():
 		}
-		panicked := false
-		func() {
-			defer func() {
-				if p := recover(); p != nil {
-					// Copied from net/http/server.go
-					const size = 64 << 10
-					buf := make([]byte, size)
-					buf = buf[:runtime.Stack(buf, false)]
-					s.logger.Errorf("http: panic serving: %v\n%s", p, buf)
-					panicked = true
-				}
-			}()
-			handler.ServeHTTP(responseWriter, req)
-		}()
-		if panicked {
-			responseWriter.WriteHeader(500)
-		} else {
-			responseWriter.WriteHeader(200)
+	}()
+	req = req.WithContext(ctx)
+	reqBody := newRequestBody(dataStream)
+	reqBody.trailers = trailers
+	reqBody.trailer = &req.Trailer
+	req.Body = reqBody
+
+	req.RemoteAddr = session.RemoteAddr().String()
+	req.TLS = tlsConnectionState(session.ConnectionState())
+
+	responseWriter := newResponseWriter(headerStream, headerStreamMutex, dataStream, dataStreamID, s.logger)
+	responseWriter.ctx = ctx
+	responseWriter.settings = session.settings
+	if !pushed {
+		responseWriter.push = func(target string, opts *http.PushOptions) error {
+			return s.push(session, headerStream, headerStreamMutex, dataStreamID, req, target, opts)
 		}
-		if responseWriter.dataStream != nil {
-			if !streamEnded && !reqBody.requestRead {
-				// in gQUIC, the error code doesn't matter, so just use 0 here
-				responseWriter.dataStream.CancelRead(0)
+	}
+
+	handler := s.Handler
+	if handler == nil {
+		handler = http.DefaultServeMux
+	}
+	panicked := false
+	func() {
+		defer func() {
+			if p := recover(); p != nil {
+				// Copied from net/http/server.go
+				const size = 64 << 10
+				buf := make([]byte, size)
+				buf = buf[:runtime.Stack(buf, false)]
+				s.logf("http: panic serving %v: %v\n%s", req.RemoteAddr, p, buf)
+				panicked = true
 			}
-			responseWriter.dataStream.Close()
+		}()
+		handler.ServeHTTP(responseWriter, req)
+	}()
+	if panicked {
+		responseWriter.WriteHeader(500)
+	} else {
+		responseWriter.WriteHeader(200)
+		responseWriter.writeTrailers()
+	}
+	if responseWriter.dataStream != nil {
+		if !streamEnded && !reqBody.requestRead {
+			// in gQUIC, the error code doesn't matter, so just use 0 here
+			responseWriter.dataStream.CancelRead(0)
 		}
-		if s.CloseAfterFirstRequest {
-			time.Sleep(100 * time.Millisecond)
-			session.Close()
+		responseWriter.dataStream.Close()
+		session.requestFinished()
+	} else {
+		// The handler took over the data stream.
+		// The request is active until the handler closes the stream, or until it is reset.
+		go func() {
+			<-dataStream.Context().Done()
+			session.requestFinished()
+		}()
+	}
+	if s.CloseAfterFirstRequest && !pushed {
+		time.Sleep(100 * time.Millisecond)
+		session.Close()
+	}
+}
+
+// push sends a PUSH_PROMISE frame for the target on the header stream,
+// and serves the promised request on a newly opened data stream.
+func (s *Server) push(
+	session *serverSession,
+	headerStream quic.Stream,
+	headerStreamMutex *sync.Mutex,
+	parentStreamID protocol.StreamID,
+	parent *http.Request,
+	target string,
+	opts *http.PushOptions,
+) error {
+	if !session.settings.pushEnabled() {
+		return http.ErrNotSupported
+	}
+	if opts == nil {
+		opts = &http.PushOptions{}
+	}
+	method := opts.Method
+	if method == "" {
+		method = "GET"
+	}
+	// promised requests must be safe and cacheable, see RFC 7540, section 8.2
+	if method != "GET" && method != "HEAD" {
+		return fmt.Errorf("h2quic: method %q must be GET or HEAD", method)
+	}
+	u, err := pushURL(parent, target)
+	if err != nil {
+		return err
+	}
+	fields := []hpack.HeaderField{
+		{Name: ":method", Value: method},
+		{Name: ":scheme", Value: u.Scheme},
+		{Name: ":authority", Value: u.Host},
+		{Name: ":path", Value: u.RequestURI()},
+	}
+	for k, vv := range opts.Header {
+		if !httpguts.ValidHeaderFieldName(k) {
+			return fmt.Errorf("h2quic: promised request contains invalid header field name %q", k)
 		}
-	}()
+		switch strings.ToLower(k) {
+		case "content-length", "content-encoding", "trailer", "te", "expect", "host":
+			return fmt.Errorf("h2quic: promised request headers cannot include %q", k)
+		}
+		for _, v := range vv {
+			fields = append(fields, hpack.HeaderField{Name: strings.ToLower(k), Value: v})
+		}
+	}
+	req, err := requestFromHeaders(fields)
+	if err != nil {
+		return err
+	}
+
+	dataStream, err := session.OpenStream()
+	if err != nil {
+		return err
+	}
 
+	var headers bytes.Buffer
+	enc := session.settings.newEncoder(&headers)
+	for _, f := range fields {
+		enc.WriteField(f)
+	}
+	headerStreamMutex.Lock()
+	err = writePushPromise(http2.NewFramer(headerStream, nil), http2.PushPromiseParam{
+		StreamID:      uint32(parentStreamID),
+		PromiseID:     uint32(dataStream.StreamID()),
+		BlockFragment: headers.Bytes(),
+		EndHeaders:    true,
+	})
+	headerStreamMutex.Unlock()
+	if err != nil {
+		dataStream.CancelWrite(0)
+		return err
+	}
+
+	if s.logger.Debug() {
+		s.logger.Infof("Pushing %s %s%s, on data stream %d", req.Method, req.Host, req.RequestURI, dataStream.StreamID())
+	} else {
+		s.logger.Infof("Pushing %s %s%s", req.Method, req.Host, req.RequestURI)
+	}
+	session.requestStarted()
+	go s.serveRequest(session, headerStream, headerStreamMutex, dataStream, dataStream.StreamID(), req, true, nil, true)
 	return nil
 }
 
+// pushURL resolves the target of a push.
+// The target is either an absolute path, or an absolute URL with the same scheme as the parent request.
+func pushURL(parent *http.Request, target string) (*url.URL, error) {
+	if strings.HasPrefix(target, "/") {
+		u, err := url.Parse(target)
+		if err != nil {
+			return nil, err
+		}
+		u.Scheme = "https"
+		u.Host = parent.Host
+		return u, nil
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "https" {
+		return nil, fmt.Errorf("h2quic: cannot push URL with scheme %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, errors.New("h2quic: URL must have a host")
+	}
+	return u, nil
+}
+
 // Close the server immediately, aborting requests and sending CONNECTION_CLOSE frames to connected clients.
 // Close in combination with ListenAndServe() (instead of Serve()) may race if it is called before a UDP socket is established.
 func (s *Server) Close() error {
@@ -0,0 +1,172 @@
+package h2quic
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	quic "github.com/wheelcomplex/qk"
+)
+
+// contextKey is a value for use with context.WithValue.
+// It's used as a pointer so it fits in an interface{} without allocation.
+type contextKey struct {
+	name string
+}
+
+func (k *contextKey) String() string { return "h2quic context value " + k.name }
+
+// SessionContextKey is a context key.
+// It can be used in HTTP handlers with Context.Value to access the QUIC session serving the request.
+// The associated value is of type quic.Session.
+var SessionContextKey = &contextKey{"quic-session"}
+
+// A serverSession is a session served by the Server.
+// It tracks the requests in flight, reports the state of the session to the ConnState hook,
+// and closes the session when it is idle for too long.
+type serverSession struct {
+	streamCreator
+
+	server   *Server
+	conn     net.Conn        // the net.Conn passed to the hooks of the http.Server
+	ctx      context.Context // the base context of all requests on this session
+	settings *peerSettings   // the settings sent by the client
+
+	mutex          sync.Mutex
+	closed         bool
+	activeRequests int
+	idleTimer      *time.Timer
+}
+
+func newServerSession(s *Server, session streamCreator) *serverSession {
+	sess := &serverSession{
+		streamCreator: session,
+		server:        s,
+		conn:          &sessionConn{session: session},
+		settings:      newPeerSettings(s.MaxEncoderHeaderTableSize),
+	}
+	ctx := s.baseContext()
+	ctx = context.WithValue(ctx, http.ServerContextKey, s.Server)
+	ctx = context.WithValue(ctx, http.LocalAddrContextKey, session.LocalAddr())
+	ctx = context.WithValue(ctx, SessionContextKey, quic.Session(session))
+	if s.ConnContext != nil {
+		ctx = s.ConnContext(ctx, sess.conn)
+		if ctx == nil {
+			panic("ConnContext returned nil")
+		}
+	}
+	sess.ctx = ctx
+
+	sess.mutex.Lock()
+	sess.setState(http.StateNew)
+	// A new session has to send the headers of its first request within the ReadHeaderTimeout.
+	timeout := s.ReadHeaderTimeout
+	if timeout == 0 {
+		timeout = s.ReadTimeout
+	}
+	sess.startIdleTimer(timeout)
+	sess.mutex.Unlock()
+
+	go func() {
+		<-session.Context().Done()
+		sess.mutex.Lock()
+		defer sess.mutex.Unlock()
+		sess.closed = true
+		if sess.idleTimer != nil {
+			sess.idleTimer.Stop()
+		}
+		sess.setState(http.StateClosed)
+	}()
+	return sess
+}
+
+// requestStarted is called when a request (or a pushed request) is started on this session
+func (s *serverSession) requestStarted() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.activeRequests++
+	if s.activeRequests > 1 || s.closed {
+		return
+	}
+	if s.idleTimer != nil {
+		s.idleTimer.Stop()
+		s.idleTimer = nil
+	}
+	s.setState(http.StateActive)
+}
+
+// requestFinished is called when a request (or a pushed request) was served
+func (s *serverSession) requestFinished() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.activeRequests--
+	if s.activeRequests > 0 || s.closed {
+		return
+	}
+	s.setState(http.StateIdle)
+	s.startIdleTimer(s.server.idleTimeout())
+}
+
+// startIdleTimer closes the session if no request is started within the timeout.
+// It must be called with the mutex held.
+func (s *serverSession) startIdleTimer(timeout time.Duration) {
+	if timeout <= 0 {
+		return
+	}
+	s.idleTimer = time.AfterFunc(timeout, func() {
+		s.mutex.Lock()
+		idle := s.activeRequests == 0 && !s.closed
+		s.mutex.Unlock()
+		if idle {
+			s.server.logger.Debugf("Closing idle session with %s", s.RemoteAddr())
+			s.Close()
+		}
+	})
+}
+
+// setState calls the ConnState hook. It must be called with the mutex held.
+func (s *serverSession) setState(state http.ConnState) {
+	if hook := s.server.ConnState; hook != nil {
+		hook(s.conn, state)
+	}
+}
+
+var errSessionConnNotSupported = errors.New("h2quic: reading from and writing to a QUIC session is not supported")
+
+// A sessionConn is the net.Conn that represents a QUIC session in the hooks of the http.Server.
+// Closing it closes the session. Data can't be read from or written to it.
+type sessionConn struct {
+	session quic.Session
+}
+
+var _ net.Conn = &sessionConn{}
+
+func (c *sessionConn) Read([]byte) (int, error)         { return 0, errSessionConnNotSupported }
+func (c *sessionConn) Write([]byte) (int, error)        { return 0, errSessionConnNotSupported }
+func (c *sessionConn) Close() error                     { return c.session.Close() }
+func (c *sessionConn) LocalAddr() net.Addr              { return c.session.LocalAddr() }
+func (c *sessionConn) RemoteAddr() net.Addr             { return c.session.RemoteAddr() }
+func (c *sessionConn) SetDeadline(time.Time) error      { return nil }
+func (c *sessionConn) SetReadDeadline(time.Time) error  { return nil }
+func (c *sessionConn) SetWriteDeadline(time.Time) error { return nil }
+
+// A netListener is the net.Listener that represents a QUIC listener in the BaseContext hook of the http.Server.
+type netListener struct {
+	ln quic.Listener
+}
+
+var _ net.Listener = &netListener{}
+
+func (l *netListener) Accept() (net.Conn, error) {
+	sess, err := l.ln.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &sessionConn{session: sess}, nil
+}
+
+func (l *netListener) Close() error   { return l.ln.Close() }
+func (l *netListener) Addr() net.Addr { return l.ln.Addr() }
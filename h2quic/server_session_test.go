@@ -0,0 +1,187 @@
+package h2quic
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	quic "github.com/wheelcomplex/qk"
+	"github.com/wheelcomplex/qk/internal/utils"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type mockListener struct {
+	sessionToAccept quic.Session
+}
+
+func (l *mockListener) Close() error   { return nil }
+func (l *mockListener) Addr() net.Addr { return &net.UDPAddr{IP: []byte{127, 0, 0, 1}, Port: 443} }
+func (l *mockListener) Accept() (quic.Session, error) {
+	if l.sessionToAccept == nil {
+		return nil, errors.New("listener closed")
+	}
+	return l.sessionToAccept, nil
+}
+
+var _ = Describe("Server session", func() {
+	var (
+		s       *Server
+		session *mockSession
+	)
+
+	BeforeEach(func() {
+		s = &Server{
+			Server: &http.Server{},
+			logger: utils.DefaultLogger,
+		}
+		session = newMockSession()
+		session.ctx, session.ctxCancel = context.WithCancel(context.Background())
+	})
+
+	AfterEach(func() {
+		session.Close()
+	})
+
+	Context("ConnState", func() {
+		var (
+			mutex  sync.Mutex
+			states []http.ConnState
+			conns  []net.Conn
+		)
+
+		getStates := func() []http.ConnState {
+			mutex.Lock()
+			defer mutex.Unlock()
+			return append([]http.ConnState{}, states...)
+		}
+
+		BeforeEach(func() {
+			states = nil
+			conns = nil
+			s.ConnState = func(conn net.Conn, state http.ConnState) {
+				mutex.Lock()
+				defer mutex.Unlock()
+				states = append(states, state)
+				conns = append(conns, conn)
+			}
+		})
+
+		It("reports the state of the session", func() {
+			sess := newServerSession(s, session)
+			Expect(getStates()).To(Equal([]http.ConnState{http.StateNew}))
+			sess.requestStarted()
+			sess.requestStarted()
+			Expect(getStates()).To(Equal([]http.ConnState{http.StateNew, http.StateActive}))
+			sess.requestFinished()
+			Expect(getStates()).To(HaveLen(2))
+			sess.requestFinished()
+			Expect(getStates()).To(Equal([]http.ConnState{http.StateNew, http.StateActive, http.StateIdle}))
+			session.Close()
+			Eventually(getStates).Should(Equal([]http.ConnState{http.StateNew, http.StateActive, http.StateIdle, http.StateClosed}))
+			mutex.Lock()
+			defer mutex.Unlock()
+			for _, conn := range conns {
+				Expect(conn).To(Equal(sess.conn))
+			}
+			Expect(sess.conn.RemoteAddr()).To(Equal(session.RemoteAddr()))
+		})
+
+		It("doesn't report any state after the session was closed", func() {
+			sess := newServerSession(s, session)
+			session.Close()
+			Eventually(getStates).Should(Equal([]http.ConnState{http.StateNew, http.StateClosed}))
+			sess.requestStarted()
+			sess.requestFinished()
+			Consistently(getStates).Should(HaveLen(2))
+		})
+	})
+
+	Context("timeouts", func() {
+		It("closes new sessions that don't send a request within the ReadHeaderTimeout", func() {
+			s.ReadHeaderTimeout = 50 * time.Millisecond
+			newServerSession(s, session)
+			Eventually(session.ctx.Done()).Should(BeClosed())
+		})
+
+		It("closes sessions that are idle for the IdleTimeout", func() {
+			s.IdleTimeout = 50 * time.Millisecond
+			sess := newServerSession(s, session)
+			sess.requestStarted()
+			Consistently(session.ctx.Done(), 150*time.Millisecond).ShouldNot(BeClosed())
+			sess.requestFinished()
+			Eventually(session.ctx.Done()).Should(BeClosed())
+		})
+
+		It("uses the ReadTimeout if the IdleTimeout is not set", func() {
+			s.ReadTimeout = 50 * time.Millisecond
+			sess := newServerSession(s, session)
+			sess.requestStarted()
+			sess.requestFinished()
+			Eventually(session.ctx.Done()).Should(BeClosed())
+		})
+
+		It("doesn't close sessions if no timeout is set", func() {
+			sess := newServerSession(s, session)
+			sess.requestStarted()
+			sess.requestFinished()
+			Consistently(session.ctx.Done()).ShouldNot(BeClosed())
+		})
+	})
+
+	Context("contexts", func() {
+		type ctxKey struct{}
+
+		It("sets the values of the request context", func() {
+			sess := newServerSession(s, session)
+			Expect(sess.ctx.Value(SessionContextKey)).To(Equal(session))
+			Expect(sess.ctx.Value(http.ServerContextKey)).To(Equal(s.Server))
+			Expect(sess.ctx.Value(http.LocalAddrContextKey)).To(Equal(session.LocalAddr()))
+		})
+
+		It("uses the context returned by ConnContext", func() {
+			var conn net.Conn
+			s.ConnContext = func(ctx context.Context, c net.Conn) context.Context {
+				conn = c
+				return context.WithValue(ctx, ctxKey{}, "foobar")
+			}
+			sess := newServerSession(s, session)
+			Expect(conn).To(Equal(sess.conn))
+			Expect(sess.ctx.Value(ctxKey{})).To(Equal("foobar"))
+			Expect(sess.ctx.Value(SessionContextKey)).To(Equal(session))
+		})
+
+		It("uses the context returned by BaseContext", func() {
+			var ln net.Listener
+			s.BaseContext = func(l net.Listener) context.Context {
+				ln = l
+				return context.WithValue(context.Background(), ctxKey{}, "foobar")
+			}
+			origQuicListenAddr := quicListenAddr
+			defer func() { quicListenAddr = origQuicListenAddr }()
+			quicListenAddr = func(string, *tls.Config, *quic.Config) (quic.Listener, error) {
+				return &mockListener{}, nil
+			}
+			Expect(s.ListenAndServe()).To(MatchError("listener closed"))
+			Expect(ln.Addr()).To(Equal(&net.UDPAddr{IP: []byte{127, 0, 0, 1}, Port: 443}))
+			sess := newServerSession(s, session)
+			Expect(sess.ctx.Value(ctxKey{})).To(Equal("foobar"))
+		})
+	})
+
+	It("returns the session as a net.Conn from the net.Listener", func() {
+		ln := &netListener{ln: &mockListener{sessionToAccept: session}}
+		conn, err := ln.Accept()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(conn.RemoteAddr()).To(Equal(session.RemoteAddr()))
+		_, err = conn.Read(make([]byte, 1))
+		Expect(err).To(MatchError(errSessionConnNotSupported))
+		Expect(conn.Close()).To(Succeed())
+		Expect(session.closed).To(BeTrue())
+	})
+})
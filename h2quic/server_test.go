@@ -7,6 +7,8 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"log"
 	"net"
 	"net/http"
 	"strings"
@@ -37,6 +39,7 @@ type mockSession struct {
 	streamOpenErr       error
 	ctx                 context.Context
 	ctxCancel           context.CancelFunc
+	connectionState     quic.ConnectionState
 }
 
 func newMockSession() *mockSession {
@@ -46,7 +49,14 @@ func newMockSession() *mockSession {
 func (s *mockSession) GetOrOpenStream(id protocol.StreamID) (quic.Stream, error) {
 	return s.dataStream, nil
 }
-func (s *mockSession) AcceptStream() (quic.Stream, error) { return s.streamToAccept, nil }
+func (s *mockSession) AcceptStream() (quic.Stream, error) {
+	if str := s.streamToAccept; str != nil {
+		s.streamToAccept = nil
+		return str, nil
+	}
+	<-s.ctx.Done()
+	return nil, errors.New("session closed")
+}
 func (s *mockSession) OpenStream() (quic.Stream, error) {
 	if s.streamOpenErr != nil {
 		return nil, s.streamOpenErr
@@ -74,7 +84,7 @@ func (s *mockSession) CloseWithError(_ quic.ErrorCode, e error) error {
 	return s.Close()
 }
 func (s *mockSession) LocalAddr() net.Addr {
-	panic("not implemented")
+	return &net.UDPAddr{IP: []byte{127, 0, 0, 1}, Port: 443}
 }
 func (s *mockSession) RemoteAddr() net.Addr {
 	return &net.UDPAddr{IP: []byte{127, 0, 0, 1}, Port: 42}
@@ -82,15 +92,17 @@ func (s *mockSession) RemoteAddr() net.Addr {
 func (s *mockSession) Context() context.Context {
 	return s.ctx
 }
-func (s *mockSession) ConnectionState() quic.ConnectionState        { panic("not implemented") }
+func (s *mockSession) ConnectionState() quic.ConnectionState        { return s.connectionState }
 func (s *mockSession) AcceptUniStream() (quic.ReceiveStream, error) { panic("not implemented") }
 func (s *mockSession) OpenUniStream() (quic.SendStream, error)      { panic("not implemented") }
 func (s *mockSession) OpenUniStreamSync() (quic.SendStream, error)  { panic("not implemented") }
+func (s *mockSession) InitiateKeyUpdate() error                     { panic("not implemented") }
 
 var _ = Describe("H2 server", func() {
 	var (
 		s                  *Server
 		session            *mockSession
+		serverSess         *serverSession
 		dataStream         *mockStream
 		origQuicListenAddr = quicListenAddr
 	)
@@ -107,6 +119,7 @@ var _ = Describe("H2 server", func() {
 		session = newMockSession()
 		session.dataStream = dataStream
 		session.ctx, session.ctxCancel = context.WithCancel(context.Background())
+		serverSess = newServerSession(s, session)
 		origQuicListenAddr = quicListenAddr
 	})
 
@@ -127,6 +140,24 @@ var _ = Describe("H2 server", func() {
 			h2framer = http2.NewFramer(nil, headerStream)
 		})
 
+		writeRequestHeaders := func(endStream bool, fields ...hpack.HeaderField) {
+			var headers bytes.Buffer
+			enc := hpack.NewEncoder(&headers)
+			enc.WriteField(hpack.HeaderField{Name: ":method", Value: "GET"})
+			enc.WriteField(hpack.HeaderField{Name: ":scheme", Value: "https"})
+			enc.WriteField(hpack.HeaderField{Name: ":authority", Value: "www.example.com"})
+			enc.WriteField(hpack.HeaderField{Name: ":path", Value: "/"})
+			for _, f := range fields {
+				enc.WriteField(f)
+			}
+			err := writeHeaders(http2.NewFramer(&headerStream.dataToRead, nil), http2.HeadersFrameParam{
+				StreamID:      5,
+				EndStream:     endStream,
+				BlockFragment: headers.Bytes(),
+			})
+			Expect(err).ToNot(HaveOccurred())
+		}
+
 		It("handles a sample GET request", func() {
 			var handlerCalled bool
 			s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -140,7 +171,7 @@ var _ = Describe("H2 server", func() {
 				// Taken from https://http2.github.io/http2-spec/compression.html#request.examples.with.huffman.coding
 				0x82, 0x86, 0x84, 0x41, 0x8c, 0xf1, 0xe3, 0xc2, 0xe5, 0xf2, 0x3a, 0x6b, 0xa0, 0xab, 0x90, 0xf4, 0xff,
 			})
-			err := s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, h2framer)
+			err := s.handleRequest(serverSess, headerStream, &sync.Mutex{}, hpackDecoder, h2framer)
 			Expect(err).NotTo(HaveOccurred())
 			Eventually(func() bool { return handlerCalled }).Should(BeTrue())
 			Expect(dataStream.remoteClosed).To(BeTrue())
@@ -154,7 +185,7 @@ var _ = Describe("H2 server", func() {
 				// Taken from https://http2.github.io/http2-spec/compression.html#request.examples.with.huffman.coding
 				0x82, 0x86, 0x84, 0x41, 0x8c, 0xf1, 0xe3, 0xc2, 0xe5, 0xf2, 0x3a, 0x6b, 0xa0, 0xab, 0x90, 0xf4, 0xff,
 			})
-			err := s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, h2framer)
+			err := s.handleRequest(serverSess, headerStream, &sync.Mutex{}, hpackDecoder, h2framer)
 			Expect(err).NotTo(HaveOccurred())
 			Eventually(func() []byte {
 				return headerStream.dataWritten.Bytes()
@@ -170,13 +201,110 @@ var _ = Describe("H2 server", func() {
 				// Taken from https://http2.github.io/http2-spec/compression.html#request.examples.with.huffman.coding
 				0x82, 0x86, 0x84, 0x41, 0x8c, 0xf1, 0xe3, 0xc2, 0xe5, 0xf2, 0x3a, 0x6b, 0xa0, 0xab, 0x90, 0xf4, 0xff,
 			})
-			err := s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, h2framer)
+			err := s.handleRequest(serverSess, headerStream, &sync.Mutex{}, hpackDecoder, h2framer)
 			Expect(err).NotTo(HaveOccurred())
 			Eventually(func() []byte {
 				return headerStream.dataWritten.Bytes()
 			}).Should(Equal([]byte{0x0, 0x0, 0x1, 0x1, 0x4, 0x0, 0x0, 0x0, 0x5, 0x8e})) // 0x82 is 500
 		})
 
+		It("sets the deadlines of the data stream", func() {
+			s.ReadTimeout = time.Minute
+			s.WriteTimeout = time.Hour
+			handlerCalled := make(chan struct{})
+			s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				close(handlerCalled)
+			})
+			writeRequestHeaders(true)
+			err := s.handleRequest(serverSess, headerStream, &sync.Mutex{}, hpackDecoder, h2framer)
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(handlerCalled).Should(BeClosed())
+			Expect(dataStream.readDeadline).To(BeTemporally("~", time.Now().Add(time.Minute), time.Second))
+			Expect(dataStream.writeDeadline).To(BeTemporally("~", time.Now().Add(time.Hour), time.Second))
+		})
+
+		It("makes the session and its connection state available to the handler", func() {
+			session.connectionState = quic.ConnectionState{
+				HandshakeComplete:  true,
+				ServerName:         "www.example.com",
+				NegotiatedProtocol: "h2",
+			}
+			handlerCalled := make(chan struct{})
+			s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				defer GinkgoRecover()
+				Expect(r.Context().Value(SessionContextKey)).To(Equal(session))
+				Expect(r.Context().Value(http.ServerContextKey)).To(Equal(s.Server))
+				Expect(r.TLS.HandshakeComplete).To(BeTrue())
+				Expect(r.TLS.ServerName).To(Equal("www.example.com"))
+				Expect(r.TLS.NegotiatedProtocol).To(Equal("h2"))
+				close(handlerCalled)
+			})
+			writeRequestHeaders(true)
+			err := s.handleRequest(serverSess, headerStream, &sync.Mutex{}, hpackDecoder, h2framer)
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(handlerCalled).Should(BeClosed())
+		})
+
+		It("lets the handler take over the data stream", func() {
+			dataStream = newMockStream(5)
+			session.dataStream = dataStream
+			var states []http.ConnState
+			var statesMutex sync.Mutex
+			s.ConnState = func(_ net.Conn, state http.ConnState) {
+				statesMutex.Lock()
+				states = append(states, state)
+				statesMutex.Unlock()
+			}
+			getStates := func() []http.ConnState {
+				statesMutex.Lock()
+				defer statesMutex.Unlock()
+				return append([]http.ConnState{}, states...)
+			}
+			serverSess = newServerSession(s, session)
+			strChan := make(chan quic.Stream, 1)
+			s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				defer GinkgoRecover()
+				Expect(r.Method).To(Equal("CONNECT"))
+				Expect(r.Host).To(Equal("example.com:22"))
+				str, err := w.(DataStreamer).DataStream()
+				Expect(err).ToNot(HaveOccurred())
+				strChan <- str
+			})
+			var headers bytes.Buffer
+			enc := hpack.NewEncoder(&headers)
+			enc.WriteField(hpack.HeaderField{Name: ":method", Value: "CONNECT"})
+			enc.WriteField(hpack.HeaderField{Name: ":authority", Value: "example.com:22"})
+			err := writeHeaders(http2.NewFramer(&headerStream.dataToRead, nil), http2.HeadersFrameParam{
+				StreamID:      5,
+				BlockFragment: headers.Bytes(),
+			})
+			Expect(err).ToNot(HaveOccurred())
+			err = s.handleRequest(serverSess, headerStream, &sync.Mutex{}, hpackDecoder, h2framer)
+			Expect(err).NotTo(HaveOccurred())
+			var str quic.Stream
+			Eventually(strChan).Should(Receive(&str))
+			Expect(str).To(Equal(dataStream))
+			Eventually(func() []byte { return headerStream.dataWritten.Bytes() }).ShouldNot(BeEmpty())
+			// the server doesn't close or reset the data stream, and the request is still active
+			Consistently(func() bool { return dataStream.closed || dataStream.reset }).Should(BeFalse())
+			Expect(getStates()).To(Equal([]http.ConnState{http.StateNew, http.StateActive}))
+			str.Close()
+			Eventually(getStates).Should(Equal([]http.ConnState{http.StateNew, http.StateActive, http.StateIdle}))
+		})
+
+		It("logs panics to the ErrorLog", func() {
+			var logged bytes.Buffer
+			s.ErrorLog = log.New(&logged, "", 0)
+			s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				panic("foobar")
+			})
+			writeRequestHeaders(true)
+			err := s.handleRequest(serverSess, headerStream, &sync.Mutex{}, hpackDecoder, h2framer)
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(func() bool { return dataStream.closed }).Should(BeTrue())
+			Expect(logged.String()).To(ContainSubstring("http: panic serving 127.0.0.1:42: foobar"))
+		})
+
 		It("resets the dataStream when client sends a body in GET request", func() {
 			var handlerCalled bool
 			s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -188,7 +316,7 @@ var _ = Describe("H2 server", func() {
 				// Taken from https://http2.github.io/http2-spec/compression.html#request.examples.with.huffman.coding
 				0x82, 0x86, 0x84, 0x41, 0x8c, 0xf1, 0xe3, 0xc2, 0xe5, 0xf2, 0x3a, 0x6b, 0xa0, 0xab, 0x90, 0xf4, 0xff,
 			})
-			err := s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, h2framer)
+			err := s.handleRequest(serverSess, headerStream, &sync.Mutex{}, hpackDecoder, h2framer)
 			Expect(err).NotTo(HaveOccurred())
 			Eventually(func() bool { return handlerCalled }).Should(BeTrue())
 			Eventually(func() bool { return dataStream.reset }).Should(BeTrue())
@@ -203,7 +331,7 @@ var _ = Describe("H2 server", func() {
 				handlerCalled = true
 			})
 			headerStream.dataToRead.Write([]byte{0x0, 0x0, 0x20, 0x1, 0x24, 0x0, 0x0, 0x0, 0x5, 0x0, 0x0, 0x0, 0x0, 0xff, 0x41, 0x8c, 0xf1, 0xe3, 0xc2, 0xe5, 0xf2, 0x3a, 0x6b, 0xa0, 0xab, 0x90, 0xf4, 0xff, 0x83, 0x84, 0x87, 0x5c, 0x1, 0x37, 0x7a, 0x85, 0xed, 0x69, 0x88, 0xb4, 0xc7})
-			err := s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, h2framer)
+			err := s.handleRequest(serverSess, headerStream, &sync.Mutex{}, hpackDecoder, h2framer)
 			Expect(err).NotTo(HaveOccurred())
 			Eventually(func() bool { return dataStream.reset }).Should(BeTrue())
 			Consistently(func() bool { return dataStream.remoteClosed }).Should(BeFalse())
@@ -221,7 +349,7 @@ var _ = Describe("H2 server", func() {
 				// Taken from https://http2.github.io/http2-spec/compression.html#request.examples.with.huffman.coding
 				0x82, 0x86, 0x84, 0x41, 0x8c, 0xf1, 0xe3, 0xc2, 0xe5, 0xf2, 0x3a, 0x6b, 0xa0, 0xab, 0x90, 0xf4, 0xff,
 			})
-			err := s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, h2framer)
+			err := s.handleRequest(serverSess, headerStream, &sync.Mutex{}, hpackDecoder, h2framer)
 			Expect(err).NotTo(HaveOccurred())
 			Consistently(func() bool { return handlerCalled }).Should(BeFalse())
 		})
@@ -236,7 +364,7 @@ var _ = Describe("H2 server", func() {
 				handlerCalled = true
 			})
 			headerStream.dataToRead.Write([]byte{0x0, 0x0, 0x20, 0x1, 0x24, 0x0, 0x0, 0x0, 0x5, 0x0, 0x0, 0x0, 0x0, 0xff, 0x41, 0x8c, 0xf1, 0xe3, 0xc2, 0xe5, 0xf2, 0x3a, 0x6b, 0xa0, 0xab, 0x90, 0xf4, 0xff, 0x83, 0x84, 0x87, 0x5c, 0x1, 0x37, 0x7a, 0x85, 0xed, 0x69, 0x88, 0xb4, 0xc7})
-			err := s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, h2framer)
+			err := s.handleRequest(serverSess, headerStream, &sync.Mutex{}, hpackDecoder, h2framer)
 			Expect(err).NotTo(HaveOccurred())
 			Eventually(func() bool { return dataStream.reset }).Should(BeTrue())
 			Consistently(func() bool { return dataStream.remoteClosed }).Should(BeFalse())
@@ -256,7 +384,7 @@ var _ = Describe("H2 server", func() {
 			})
 			headerStream.dataToRead.Write([]byte{0x0, 0x0, 0x20, 0x1, 0x24, 0x0, 0x0, 0x0, 0x5, 0x0, 0x0, 0x0, 0x0, 0xff, 0x41, 0x8c, 0xf1, 0xe3, 0xc2, 0xe5, 0xf2, 0x3a, 0x6b, 0xa0, 0xab, 0x90, 0xf4, 0xff, 0x83, 0x84, 0x87, 0x5c, 0x1, 0x37, 0x7a, 0x85, 0xed, 0x69, 0x88, 0xb4, 0xc7})
 			dataStream.dataToRead.Write([]byte("foo=bar"))
-			err := s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, h2framer)
+			err := s.handleRequest(serverSess, headerStream, &sync.Mutex{}, hpackDecoder, h2framer)
 			Expect(err).NotTo(HaveOccurred())
 			Eventually(func() bool { return handlerCalled }).Should(BeTrue())
 			Expect(dataStream.reset).To(BeFalse())
@@ -273,19 +401,45 @@ var _ = Describe("H2 server", func() {
 			Expect(err).ToNot(HaveOccurred())
 			Expect(buf.Bytes()).ToNot(BeEmpty())
 			headerStream.dataToRead.Write(buf.Bytes())
-			err = s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, h2framer)
+			err = s.handleRequest(serverSess, headerStream, &sync.Mutex{}, hpackDecoder, h2framer)
 			Expect(err).ToNot(HaveOccurred())
 			Consistently(handlerCalled).ShouldNot(BeClosed())
 			Expect(dataStream.reset).To(BeFalse())
 			Expect(dataStream.closed).To(BeFalse())
 		})
 
+		It("applies SETTINGS frames", func() {
+			buf := &bytes.Buffer{}
+			framer := http2.NewFramer(buf, nil)
+			err := framer.WriteSettings(
+				http2.Setting{ID: http2.SettingHeaderTableSize, Val: 100},
+				http2.Setting{ID: http2.SettingEnablePush, Val: 0},
+			)
+			Expect(err).ToNot(HaveOccurred())
+			headerStream.dataToRead.Write(buf.Bytes())
+			err = s.handleRequest(serverSess, headerStream, &sync.Mutex{}, hpackDecoder, h2framer)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(serverSess.settings.encoderTableSize()).To(BeEquivalentTo(100))
+			Expect(serverSess.settings.pushEnabled()).To(BeFalse())
+		})
+
+		It("errors on invalid SETTINGS frames", func() {
+			buf := &bytes.Buffer{}
+			framer := http2.NewFramer(buf, nil)
+			err := framer.WriteSettings(http2.Setting{ID: http2.SettingEnablePush, Val: 2})
+			Expect(err).ToNot(HaveOccurred())
+			headerStream.dataToRead.Write(buf.Bytes())
+			err = s.handleRequest(serverSess, headerStream, &sync.Mutex{}, hpackDecoder, h2framer)
+			Expect(err).To(HaveOccurred())
+			Expect(err.(*qerr.QuicError).ErrorCode).To(Equal(qerr.InvalidHeadersStreamData))
+		})
+
 		It("errors when non-header frames are received", func() {
 			headerStream.dataToRead.Write([]byte{
 				0x0, 0x0, 0x06, 0x0, 0x0, 0x0, 0x0, 0x0, 0x5,
 				'f', 'o', 'o', 'b', 'a', 'r',
 			})
-			err := s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, h2framer)
+			err := s.handleRequest(serverSess, headerStream, &sync.Mutex{}, hpackDecoder, h2framer)
 			Expect(err).To(MatchError("InvalidHeadersStreamData: expected a header frame"))
 		})
 
@@ -304,12 +458,264 @@ var _ = Describe("H2 server", func() {
 				0x82, 0x86, 0x84, 0x41, 0x8c, 0xf1, 0xe3, 0xc2, 0xe5, 0xf2, 0x3a, 0x6b, 0xa0, 0xab, 0x90, 0xf4, 0xff,
 			})
 			dataStream.Close()
-			err := s.handleRequest(session, headerStream, &sync.Mutex{}, hpackDecoder, h2framer)
+			err := s.handleRequest(serverSess, headerStream, &sync.Mutex{}, hpackDecoder, h2framer)
 			Expect(err).NotTo(HaveOccurred())
 			Eventually(func() bool { return handlerCalled }).Should(BeTrue())
 			Expect(dataStream.remoteClosed).To(BeTrue())
 			Expect(dataStream.reset).To(BeFalse())
 		})
+
+		Context("header blocks", func() {
+			It("handles requests with CONTINUATION frames", func() {
+				cookie := strings.Repeat("a", 3*maxHeaderFrameSize)
+				var handlerCalled bool
+				s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					defer GinkgoRecover()
+					Expect(r.Host).To(Equal("www.example.com"))
+					Expect(r.Header.Get("Cookie")).To(Equal(cookie))
+					handlerCalled = true
+				})
+				writeRequestHeaders(true, hpack.HeaderField{Name: "cookie", Value: cookie})
+				Expect(headerStream.dataToRead.Len()).To(BeNumerically(">", maxHeaderFrameSize))
+				err := s.handleRequest(serverSess, headerStream, &sync.Mutex{}, hpackDecoder, h2framer)
+				Expect(err).NotTo(HaveOccurred())
+				Eventually(func() bool { return handlerCalled }).Should(BeTrue())
+			})
+
+			It("responds with 431 if the request headers are too large", func() {
+				var handlerCalled bool
+				s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					handlerCalled = true
+				})
+				s.Server.MaxHeaderBytes = 1000
+				writeRequestHeaders(false, hpack.HeaderField{Name: "cookie", Value: strings.Repeat("a", 2000)})
+				err := s.handleRequest(serverSess, headerStream, &sync.Mutex{}, hpackDecoder, h2framer)
+				Expect(err).NotTo(HaveOccurred())
+				Eventually(func() bool { return dataStream.closed }).Should(BeTrue())
+				Expect(dataStream.reset).To(BeTrue())
+				Expect(handlerCalled).To(BeFalse())
+				Expect(dataStream.dataWritten.String()).To(ContainSubstring("Request Header Field(s) Too Large"))
+				framer := http2.NewFramer(nil, bytes.NewReader(headerStream.dataWritten.Bytes()))
+				framer.ReadMetaHeaders = hpack.NewDecoder(4096, nil)
+				frame, err := framer.ReadFrame()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(frame.(*http2.MetaHeadersFrame).PseudoValue("status")).To(Equal("431"))
+			})
+		})
+
+		Context("trailers", func() {
+			writeTrailers := func(fields ...hpack.HeaderField) {
+				var headers bytes.Buffer
+				enc := hpack.NewEncoder(&headers)
+				for _, f := range fields {
+					enc.WriteField(f)
+				}
+				err := writeHeaders(http2.NewFramer(&headerStream.dataToRead, nil), http2.HeadersFrameParam{
+					StreamID:      5,
+					EndStream:     true,
+					BlockFragment: headers.Bytes(),
+				})
+				Expect(err).ToNot(HaveOccurred())
+			}
+
+			It("reads the request trailers after the body", func() {
+				dataStream.dataToRead.Write([]byte("foobar"))
+				handlerCalled := make(chan struct{})
+				s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					defer GinkgoRecover()
+					Expect(r.Header).ToNot(HaveKey("Trailer"))
+					Expect(r.Trailer).To(Equal(http.Header{"Grpc-Status": nil}))
+					body, err := ioutil.ReadAll(r.Body)
+					Expect(err).ToNot(HaveOccurred())
+					Expect(body).To(Equal([]byte("foobar")))
+					Expect(r.Trailer).To(Equal(http.Header{"Grpc-Status": []string{"0"}}))
+					close(handlerCalled)
+				})
+				writeRequestHeaders(false, hpack.HeaderField{Name: "trailer", Value: "grpc-status"})
+				writeTrailers(hpack.HeaderField{Name: "grpc-status", Value: "0"})
+				err := s.handleRequest(serverSess, headerStream, &sync.Mutex{}, hpackDecoder, h2framer)
+				Expect(err).NotTo(HaveOccurred())
+				err = s.handleRequest(serverSess, headerStream, &sync.Mutex{}, hpackDecoder, h2framer)
+				Expect(err).NotTo(HaveOccurred())
+				Eventually(handlerCalled).Should(BeClosed())
+				Eventually(func() bool { return dataStream.closed }).Should(BeTrue())
+				s.requestTrailersMutex.Lock()
+				defer s.requestTrailersMutex.Unlock()
+				Expect(s.requestTrailers).To(BeEmpty())
+			})
+
+			It("ignores trailers for unknown requests", func() {
+				writeTrailers(hpack.HeaderField{Name: "grpc-status", Value: "0"})
+				err := s.handleRequest(serverSess, headerStream, &sync.Mutex{}, hpackDecoder, h2framer)
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			It("sends the response trailers", func() {
+				s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.Header().Set("Trailer", "Grpc-Status")
+					w.Write([]byte("foobar"))
+					w.Header().Set("Grpc-Status", "0")
+				})
+				writeRequestHeaders(true)
+				err := s.handleRequest(serverSess, headerStream, &sync.Mutex{}, hpackDecoder, h2framer)
+				Expect(err).NotTo(HaveOccurred())
+				Eventually(func() bool { return dataStream.closed }).Should(BeTrue())
+				framer := http2.NewFramer(nil, bytes.NewReader(headerStream.dataWritten.Bytes()))
+				framer.ReadMetaHeaders = hpack.NewDecoder(4096, nil)
+				frame, err := framer.ReadFrame()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(frame.(*http2.MetaHeadersFrame).PseudoValue("status")).To(Equal("200"))
+				frame, err = framer.ReadFrame()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(frame.(*http2.MetaHeadersFrame).StreamEnded()).To(BeTrue())
+				Expect(frame.(*http2.MetaHeadersFrame).Fields).To(Equal([]hpack.HeaderField{{Name: "grpc-status", Value: "0"}}))
+			})
+		})
+
+		It("cancels the request context when the session is closed", func() {
+			handlerCalled := make(chan struct{})
+			s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				defer GinkgoRecover()
+				close(handlerCalled)
+				Consistently(r.Context().Done()).ShouldNot(BeClosed())
+				session.Close()
+				Eventually(r.Context().Done()).Should(BeClosed())
+				Eventually(w.(http.CloseNotifier).CloseNotify()).Should(Receive())
+			})
+			headerStream.dataToRead.Write([]byte{
+				0x0, 0x0, 0x11, 0x1, 0x4, 0x0, 0x0, 0x0, 0x5,
+				// Taken from https://http2.github.io/http2-spec/compression.html#request.examples.with.huffman.coding
+				0x82, 0x86, 0x84, 0x41, 0x8c, 0xf1, 0xe3, 0xc2, 0xe5, 0xf2, 0x3a, 0x6b, 0xa0, 0xab, 0x90, 0xf4, 0xff,
+			})
+			err := s.handleRequest(serverSess, headerStream, &sync.Mutex{}, hpackDecoder, h2framer)
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(handlerCalled).Should(BeClosed())
+			Eventually(func() bool { return dataStream.closed }).Should(BeTrue())
+		})
+
+		Context("pushing", func() {
+			var pushStream *mockStream
+
+			// a GET request for https://www.example.com/ on stream 5
+			getRequest := []byte{
+				0x0, 0x0, 0x11, 0x1, 0x5, 0x0, 0x0, 0x0, 0x5,
+				// Taken from https://http2.github.io/http2-spec/compression.html#request.examples.with.huffman.coding
+				0x82, 0x86, 0x84, 0x41, 0x8c, 0xf1, 0xe3, 0xc2, 0xe5, 0xf2, 0x3a, 0x6b, 0xa0, 0xab, 0x90, 0xf4, 0xff,
+			}
+
+			BeforeEach(func() {
+				pushStream = newMockStream(2)
+				close(pushStream.unblockRead)
+				session.streamsToOpen = []quic.Stream{pushStream}
+			})
+
+			It("sends a PUSH_PROMISE, and serves the pushed request", func() {
+				pushedRequest := make(chan *http.Request, 1)
+				s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					defer GinkgoRecover()
+					if r.URL.Path == "/style.css" {
+						Expect(w.(http.Pusher).Push("/foo.js", nil)).To(MatchError(http2.ErrRecursivePush))
+						pushedRequest <- r
+						w.Write([]byte("body {}"))
+						return
+					}
+					err := w.(http.Pusher).Push("/style.css", &http.PushOptions{
+						Header: http.Header{"Accept-Language": []string{"en"}},
+					})
+					Expect(err).ToNot(HaveOccurred())
+				})
+				headerStream.dataToRead.Write(getRequest)
+				err := s.handleRequest(serverSess, headerStream, &sync.Mutex{}, hpackDecoder, h2framer)
+				Expect(err).NotTo(HaveOccurred())
+				var req *http.Request
+				Eventually(pushedRequest).Should(Receive(&req))
+				Expect(req.Method).To(Equal("GET"))
+				Expect(req.Host).To(Equal("www.example.com"))
+				Expect(req.RequestURI).To(Equal("/style.css"))
+				Expect(req.Header.Get("Accept-Language")).To(Equal("en"))
+				Expect(req.RemoteAddr).To(Equal("127.0.0.1:42"))
+				Eventually(func() bool { return pushStream.closed }).Should(BeTrue())
+				Expect(pushStream.remoteClosed).To(BeTrue())
+				Expect(pushStream.reset).To(BeFalse())
+				Expect(pushStream.dataWritten.Bytes()).To(Equal([]byte("body {}")))
+
+				// the PUSH_PROMISE is followed by the HEADERS frames of the two responses
+				framer := http2.NewFramer(nil, bytes.NewReader(headerStream.dataWritten.Bytes()))
+				frame, err := framer.ReadFrame()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(frame).To(BeAssignableToTypeOf(&http2.PushPromiseFrame{}))
+				ppframe := frame.(*http2.PushPromiseFrame)
+				Expect(ppframe.StreamID).To(BeEquivalentTo(5))
+				Expect(ppframe.PromiseID).To(BeEquivalentTo(2))
+				fields, err := hpack.NewDecoder(4096, nil).DecodeFull(ppframe.HeaderBlockFragment())
+				Expect(err).ToNot(HaveOccurred())
+				Expect(fields).To(ContainElement(hpack.HeaderField{Name: ":method", Value: "GET"}))
+				Expect(fields).To(ContainElement(hpack.HeaderField{Name: ":scheme", Value: "https"}))
+				Expect(fields).To(ContainElement(hpack.HeaderField{Name: ":authority", Value: "www.example.com"}))
+				Expect(fields).To(ContainElement(hpack.HeaderField{Name: ":path", Value: "/style.css"}))
+				Expect(fields).To(ContainElement(hpack.HeaderField{Name: "accept-language", Value: "en"}))
+				streamIDs := make(map[uint32]bool)
+				for i := 0; i < 2; i++ {
+					frame, err = framer.ReadFrame()
+					Expect(err).ToNot(HaveOccurred())
+					Expect(frame).To(BeAssignableToTypeOf(&http2.HeadersFrame{}))
+					streamIDs[frame.Header().StreamID] = true
+				}
+				Expect(streamIDs).To(Equal(map[uint32]bool{2: true, 5: true}))
+			})
+
+			It("pushes absolute URLs", func() {
+				u, err := pushURL(&http.Request{Host: "www.example.com"}, "https://www.example.com/style.css?v=2")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(u.Host).To(Equal("www.example.com"))
+				Expect(u.RequestURI()).To(Equal("/style.css?v=2"))
+			})
+
+			It("refuses to push invalid URLs", func() {
+				parent := &http.Request{Host: "www.example.com"}
+				_, err := pushURL(parent, "style.css")
+				Expect(err).To(MatchError(`h2quic: cannot push URL with scheme ""`))
+				_, err = pushURL(parent, "http://www.example.com/style.css")
+				Expect(err).To(MatchError(`h2quic: cannot push URL with scheme "http"`))
+				_, err = pushURL(parent, "https:///style.css")
+				Expect(err).To(MatchError("h2quic: URL must have a host"))
+			})
+
+			It("refuses to push unsafe methods", func() {
+				req := &http.Request{Host: "www.example.com"}
+				err := s.push(serverSess, headerStream, &sync.Mutex{}, 5, req, "/foo", &http.PushOptions{Method: "POST"})
+				Expect(err).To(MatchError(`h2quic: method "POST" must be GET or HEAD`))
+				Expect(session.streamsToOpen).To(HaveLen(1))
+			})
+
+			It("refuses to push requests with invalid header fields", func() {
+				req := &http.Request{Host: "www.example.com"}
+				err := s.push(serverSess, headerStream, &sync.Mutex{}, 5, req, "/foo", &http.PushOptions{
+					Header: http.Header{"Content-Length": []string{"42"}},
+				})
+				Expect(err).To(MatchError(`h2quic: promised request headers cannot include "Content-Length"`))
+				Expect(session.streamsToOpen).To(HaveLen(1))
+			})
+
+			It("doesn't push if the client disabled push", func() {
+				err := serverSess.settings.handleSettingsFrame(readSettingsFrame(http2.Setting{ID: http2.SettingEnablePush, Val: 0}))
+				Expect(err).ToNot(HaveOccurred())
+				req := &http.Request{Host: "www.example.com"}
+				err = s.push(serverSess, headerStream, &sync.Mutex{}, 5, req, "/foo", nil)
+				Expect(err).To(MatchError(http.ErrNotSupported))
+				Expect(session.streamsToOpen).To(HaveLen(1))
+				Expect(headerStream.dataWritten.Len()).To(BeZero())
+			})
+
+			It("returns the error when opening the stream fails", func() {
+				testErr := errors.New("too many open streams")
+				session.streamOpenErr = testErr
+				req := &http.Request{Host: "www.example.com"}
+				err := s.push(serverSess, headerStream, &sync.Mutex{}, 5, req, "/foo", nil)
+				Expect(err).To(MatchError(testErr))
+				Expect(headerStream.dataWritten.Len()).To(BeZero())
+			})
+		})
 	})
 
 	It("handles the header stream", func() {
@@ -325,10 +731,39 @@ var _ = Describe("H2 server", func() {
 			0x82, 0x86, 0x84, 0x41, 0x8c, 0xf1, 0xe3, 0xc2, 0xe5, 0xf2, 0x3a, 0x6b, 0xa0, 0xab, 0x90, 0xf4, 0xff,
 		})
 		session.streamToAccept = headerStream
-		go s.handleHeaderStream(session)
+		go s.handleHeaderStream(serverSess)
 		Eventually(func() bool { return handlerCalled }).Should(BeTrue())
 	})
 
+	It("sends a SETTINGS frame on the header stream", func() {
+		s.MaxHeaderBytes = 1 << 10
+		s.MaxDecoderHeaderTableSize = 1 << 16
+		headerStream := &mockStream{id: 3}
+		session.streamToAccept = headerStream
+		go s.handleHeaderStream(serverSess)
+		Eventually(func() int { return headerStream.dataWritten.Len() }).ShouldNot(BeZero())
+		frame, err := http2.NewFramer(nil, bytes.NewReader(headerStream.dataWritten.Bytes())).ReadFrame()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(frame).To(BeAssignableToTypeOf(&http2.SettingsFrame{}))
+		settingsFrame := frame.(*http2.SettingsFrame)
+		Expect(settingsFrame.StreamID).To(BeZero())
+		val, ok := settingsFrame.Value(http2.SettingHeaderTableSize)
+		Expect(ok).To(BeTrue())
+		Expect(val).To(BeEquivalentTo(1 << 16))
+		val, ok = settingsFrame.Value(http2.SettingMaxHeaderListSize)
+		Expect(ok).To(BeTrue())
+		Expect(val).To(BeEquivalentTo(maxHeaderListSize(s.Server)))
+		_, ok = settingsFrame.Value(http2.SettingEnablePush)
+		Expect(ok).To(BeFalse())
+	})
+
+	It("doesn't send a SETTINGS frame if all settings have their default values", func() {
+		headerStream := &mockStream{id: 3}
+		session.streamToAccept = headerStream
+		go s.handleHeaderStream(serverSess)
+		Consistently(func() int { return headerStream.dataWritten.Len() }).Should(BeZero())
+	})
+
 	It("closes the connection if it encounters an error on the header stream", func() {
 		var handlerCalled bool
 		s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -337,7 +772,7 @@ var _ = Describe("H2 server", func() {
 		headerStream := &mockStream{id: 3}
 		headerStream.dataToRead.Write(bytes.Repeat([]byte{0}, 100))
 		session.streamToAccept = headerStream
-		go s.handleHeaderStream(session)
+		go s.handleHeaderStream(serverSess)
 		Consistently(func() bool { return handlerCalled }).Should(BeFalse())
 		Eventually(func() bool { return session.closed }).Should(BeTrue())
 		Expect(session.closedWithError).To(MatchError(qerr.Error(qerr.HeadersStreamDataDecompressFailure, "cannot read frame")))
@@ -354,7 +789,7 @@ var _ = Describe("H2 server", func() {
 		})
 		session.streamToAccept = headerStream
 		Expect(session.closed).To(BeFalse())
-		go s.handleHeaderStream(session)
+		go s.handleHeaderStream(serverSess)
 		Eventually(func() bool { return session.closed }).Should(BeTrue())
 	})
 
@@ -371,7 +806,7 @@ var _ = Describe("H2 server", func() {
 			0x82, 0x86, 0x84, 0x41, 0x8c, 0xf1, 0xe3, 0xc2, 0xe5, 0xf2, 0x3a, 0x6b, 0xa0, 0xab, 0x90, 0xf4, 0xff,
 		})
 		session.streamToAccept = headerStream
-		go s.handleHeaderStream(session)
+		go s.handleHeaderStream(serverSess)
 		Eventually(func() bool { return handlerCalled }).Should(BeTrue())
 	})
 
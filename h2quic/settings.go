@@ -0,0 +1,131 @@
+package h2quic
+
+import (
+	"io"
+	"sync"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+
+	"github.com/wheelcomplex/qk/qerr"
+)
+
+// defaultHeaderTableSize is the initial size of the HPACK dynamic table, see RFC 7540, section 6.5.2.
+const defaultHeaderTableSize = 4096
+
+// localSettings returns the settings that are sent in a SETTINGS frame at the beginning of the headers stream.
+// To stay compatible with peers that don't expect SETTINGS frames on the headers stream,
+// it returns nil if the dynamic table size and the maximum header list size have their default values.
+// SETTINGS_ENABLE_PUSH is only sent if pushes are disabled, and if there are other settings to send.
+func localSettings(headerTableSize, maxHeaderListSize uint32, disablePush bool) []http2.Setting {
+	var settings []http2.Setting
+	if headerTableSize != 0 && headerTableSize != defaultHeaderTableSize {
+		settings = append(settings, http2.Setting{ID: http2.SettingHeaderTableSize, Val: headerTableSize})
+	}
+	if maxHeaderListSize != 0 {
+		settings = append(settings, http2.Setting{ID: http2.SettingMaxHeaderListSize, Val: maxHeaderListSize})
+	}
+	if len(settings) > 0 && disablePush {
+		settings = append(settings, http2.Setting{ID: http2.SettingEnablePush, Val: 0})
+	}
+	return settings
+}
+
+// newHeaderDecoder creates the HPACK decoder for the headers stream.
+// The dynamic table has the default size, until the peer's encoder applies our SETTINGS_HEADER_TABLE_SIZE,
+// and sends a dynamic table size update.
+func newHeaderDecoder(maxDecoderHeaderTableSize uint32) *hpack.Decoder {
+	decoder := hpack.NewDecoder(defaultHeaderTableSize, func(hpack.HeaderField) {})
+	if maxDecoderHeaderTableSize != 0 {
+		decoder.SetAllowedMaxDynamicTableSize(maxDecoderHeaderTableSize)
+	}
+	return decoder
+}
+
+// peerSettings are the settings received from the peer in SETTINGS frames on the headers stream.
+// In QUIC, SETTINGS frames are not acknowledged, since the headers stream is reliable and ordered.
+type peerSettings struct {
+	mutex sync.RWMutex
+
+	// maxEncoderTableSize is our limit of the dynamic table size of the encoder
+	maxEncoderTableSize uint32
+
+	headerTableSize    uint32
+	headerTableSizeSet bool
+	pushDisabled       bool
+}
+
+func newPeerSettings(maxEncoderHeaderTableSize uint32) *peerSettings {
+	if maxEncoderHeaderTableSize == 0 {
+		maxEncoderHeaderTableSize = defaultHeaderTableSize
+	}
+	return &peerSettings{maxEncoderTableSize: maxEncoderHeaderTableSize}
+}
+
+// handleSettingsFrame applies the settings of a SETTINGS frame.
+// SETTINGS_MAX_HEADER_LIST_SIZE is advisory, and we don't limit the size of the header lists we send.
+// Settings that only apply to HTTP/2 over TCP (like flow control windows) are ignored.
+func (s *peerSettings) handleSettingsFrame(f *http2.SettingsFrame) error {
+	if f.IsAck() {
+		return nil
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return f.ForeachSetting(func(setting http2.Setting) error {
+		if err := setting.Valid(); err != nil {
+			return qerr.Error(qerr.InvalidHeadersStreamData, err.Error())
+		}
+		switch setting.ID {
+		case http2.SettingHeaderTableSize:
+			s.headerTableSize = setting.Val
+			s.headerTableSizeSet = true
+		case http2.SettingEnablePush:
+			s.pushDisabled = setting.Val == 0
+		}
+		return nil
+	})
+}
+
+// encoderTableSize returns the dynamic table size to use for our encoder
+func (s *peerSettings) encoderTableSize() uint32 {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	size := uint32(defaultHeaderTableSize)
+	if s.headerTableSizeSet {
+		size = s.headerTableSize
+	}
+	if size > s.maxEncoderTableSize {
+		size = s.maxEncoderTableSize
+	}
+	return size
+}
+
+// newEncoder creates an HPACK encoder for a single header block.
+// If the dynamic table size differs from the default, the header block starts with a dynamic table size update,
+// such that the peer's decoder uses the same table size as the encoder.
+// If s is nil, the default settings are used.
+func (s *peerSettings) newEncoder(w io.Writer) *hpack.Encoder {
+	enc := hpack.NewEncoder(w)
+	if s == nil {
+		return enc
+	}
+	s.mutex.RLock()
+	sizeChanged := s.headerTableSizeSet || s.maxEncoderTableSize != defaultHeaderTableSize
+	s.mutex.RUnlock()
+	if sizeChanged {
+		size := s.encoderTableSize()
+		enc.SetMaxDynamicTableSizeLimit(size)
+		enc.SetMaxDynamicTableSize(size)
+	}
+	return enc
+}
+
+// pushEnabled says if the peer allows server push
+func (s *peerSettings) pushEnabled() bool {
+	if s == nil {
+		return true
+	}
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return !s.pushDisabled
+}
@@ -0,0 +1,146 @@
+package h2quic
+
+import (
+	"bytes"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+
+	"github.com/wheelcomplex/qk/qerr"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// readSettingsFrame writes the settings into a SETTINGS frame, and parses it again
+func readSettingsFrame(settings ...http2.Setting) *http2.SettingsFrame {
+	buf := &bytes.Buffer{}
+	ExpectWithOffset(1, http2.NewFramer(buf, nil).WriteSettings(settings...)).To(Succeed())
+	frame, err := http2.NewFramer(nil, buf).ReadFrame()
+	ExpectWithOffset(1, err).ToNot(HaveOccurred())
+	return frame.(*http2.SettingsFrame)
+}
+
+var _ = Describe("Settings", func() {
+	Context("local settings", func() {
+		It("doesn't send any settings if all values are the default", func() {
+			Expect(localSettings(0, 0, true)).To(BeEmpty())
+			Expect(localSettings(defaultHeaderTableSize, 0, true)).To(BeEmpty())
+		})
+
+		It("sends the header table size", func() {
+			Expect(localSettings(1024, 0, false)).To(Equal([]http2.Setting{
+				{ID: http2.SettingHeaderTableSize, Val: 1024},
+			}))
+		})
+
+		It("sends the maximum header list size", func() {
+			Expect(localSettings(0, 1337, false)).To(Equal([]http2.Setting{
+				{ID: http2.SettingMaxHeaderListSize, Val: 1337},
+			}))
+		})
+
+		It("disables push, if other settings are sent", func() {
+			Expect(localSettings(1024, 1337, true)).To(Equal([]http2.Setting{
+				{ID: http2.SettingHeaderTableSize, Val: 1024},
+				{ID: http2.SettingMaxHeaderListSize, Val: 1337},
+				{ID: http2.SettingEnablePush, Val: 0},
+			}))
+		})
+	})
+
+	Context("peer settings", func() {
+		var settings *peerSettings
+
+		BeforeEach(func() {
+			settings = newPeerSettings(0)
+		})
+
+		It("uses the default values", func() {
+			Expect(settings.encoderTableSize()).To(BeEquivalentTo(defaultHeaderTableSize))
+			Expect(settings.pushEnabled()).To(BeTrue())
+		})
+
+		It("applies the header table size", func() {
+			err := settings.handleSettingsFrame(readSettingsFrame(http2.Setting{ID: http2.SettingHeaderTableSize, Val: 100}))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(settings.encoderTableSize()).To(BeEquivalentTo(100))
+		})
+
+		It("limits the header table size", func() {
+			settings = newPeerSettings(1000)
+			Expect(settings.encoderTableSize()).To(BeEquivalentTo(1000))
+			err := settings.handleSettingsFrame(readSettingsFrame(http2.Setting{ID: http2.SettingHeaderTableSize, Val: 1 << 20}))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(settings.encoderTableSize()).To(BeEquivalentTo(1000))
+		})
+
+		It("disables push", func() {
+			err := settings.handleSettingsFrame(readSettingsFrame(http2.Setting{ID: http2.SettingEnablePush, Val: 0}))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(settings.pushEnabled()).To(BeFalse())
+		})
+
+		It("ignores unknown settings", func() {
+			err := settings.handleSettingsFrame(readSettingsFrame(
+				http2.Setting{ID: http2.SettingInitialWindowSize, Val: 1 << 20},
+				http2.Setting{ID: 0x1337, Val: 42},
+			))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(settings.encoderTableSize()).To(BeEquivalentTo(defaultHeaderTableSize))
+		})
+
+		It("ignores SETTINGS ACKs", func() {
+			buf := &bytes.Buffer{}
+			Expect(http2.NewFramer(buf, nil).WriteSettingsAck()).To(Succeed())
+			frame, err := http2.NewFramer(nil, buf).ReadFrame()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(settings.handleSettingsFrame(frame.(*http2.SettingsFrame))).To(Succeed())
+		})
+
+		It("errors on invalid settings", func() {
+			err := settings.handleSettingsFrame(readSettingsFrame(http2.Setting{ID: http2.SettingEnablePush, Val: 2}))
+			Expect(err).To(HaveOccurred())
+			Expect(err.(*qerr.QuicError).ErrorCode).To(Equal(qerr.InvalidHeadersStreamData))
+			Expect(settings.pushEnabled()).To(BeTrue())
+		})
+
+		Context("encoding", func() {
+			encode := func(settings *peerSettings) []byte {
+				buf := &bytes.Buffer{}
+				enc := settings.newEncoder(buf)
+				Expect(enc.WriteField(hpack.HeaderField{Name: "foo", Value: "bar"})).To(Succeed())
+				return buf.Bytes()
+			}
+
+			It("doesn't send a dynamic table size update for the default settings", func() {
+				data := encode(settings)
+				Expect(data[0] & 0xe0).ToNot(Equal(byte(0x20)))
+				Expect(encode(nil)).To(Equal(data))
+			})
+
+			It("sends a dynamic table size update", func() {
+				err := settings.handleSettingsFrame(readSettingsFrame(http2.Setting{ID: http2.SettingHeaderTableSize, Val: 0}))
+				Expect(err).ToNot(HaveOccurred())
+				data := encode(settings)
+				Expect(data[0]).To(Equal(byte(0x20))) // a dynamic table size update to 0
+				decoder := newHeaderDecoder(0)
+				fields, err := decoder.DecodeFull(data)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(fields).To(Equal([]hpack.HeaderField{{Name: "foo", Value: "bar"}}))
+			})
+
+			It("rejects header blocks that exceed the table size allowed for the decoder", func() {
+				settings = newPeerSettings(8192)
+				err := settings.handleSettingsFrame(readSettingsFrame(http2.Setting{ID: http2.SettingHeaderTableSize, Val: 8192}))
+				Expect(err).ToNot(HaveOccurred())
+				data := encode(settings)
+				_, err = newHeaderDecoder(0).DecodeFull(data)
+				Expect(err).To(HaveOccurred())
+				fields, err := newHeaderDecoder(8192).DecodeFull(data)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(fields).To(HaveLen(1))
+			})
+		})
+	})
+})
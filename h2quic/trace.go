@@ -0,0 +1,98 @@
+package h2quic
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http/httptrace"
+	"time"
+
+	quic "github.com/wheelcomplex/qk"
+)
+
+// ClientTrace is a set of QUIC specific hooks to run at various stages of a request sent by the RoundTripper.
+// It is used in addition to the httptrace.ClientTrace of the request context,
+// which the RoundTripper supports for GetConn, GotConn, TLSHandshakeStart, TLSHandshakeDone,
+// WroteHeaders, WroteRequest and GotFirstResponseByte.
+// Any particular hook may be nil. Functions may be called concurrently from different goroutines.
+type ClientTrace struct {
+	// HandshakeDone is called after the QUIC handshake of a new connection completed, or failed.
+	HandshakeDone func(quic.ConnectionState, error)
+}
+
+type clientTraceContextKey struct{}
+
+// WithClientTrace returns a new context based on the provided parent ctx.
+// Requests made with the returned context will use the provided trace hooks.
+func WithClientTrace(ctx context.Context, trace *ClientTrace) context.Context {
+	if trace == nil {
+		panic("nil trace")
+	}
+	return context.WithValue(ctx, clientTraceContextKey{}, trace)
+}
+
+// ContextClientTrace returns the ClientTrace associated with the provided context.
+// If none, it returns nil.
+func ContextClientTrace(ctx context.Context) *ClientTrace {
+	trace, _ := ctx.Value(clientTraceContextKey{}).(*ClientTrace)
+	return trace
+}
+
+// tlsConnectionState converts the connection state of a QUIC session to a tls.ConnectionState
+func tlsConnectionState(state quic.ConnectionState) *tls.ConnectionState {
+	return &tls.ConnectionState{
+		HandshakeComplete:  state.HandshakeComplete,
+		ServerName:         state.ServerName,
+		PeerCertificates:   state.PeerCertificates,
+		NegotiatedProtocol: state.NegotiatedProtocol,
+	}
+}
+
+// traceHandshakeStart calls the hooks that are run before the handshake of a new connection
+func traceHandshakeStart(trace *httptrace.ClientTrace) {
+	if trace != nil && trace.TLSHandshakeStart != nil {
+		trace.TLSHandshakeStart()
+	}
+}
+
+// traceHandshakeDone calls the hooks that are run after the handshake of a new connection
+func traceHandshakeDone(trace *httptrace.ClientTrace, qtrace *ClientTrace, sess quic.Session, err error) {
+	var state quic.ConnectionState
+	if err == nil {
+		state = sess.ConnectionState()
+	}
+	if trace != nil && trace.TLSHandshakeDone != nil {
+		trace.TLSHandshakeDone(*tlsConnectionState(state), err)
+	}
+	if qtrace != nil && qtrace.HandshakeDone != nil {
+		qtrace.HandshakeDone(state, err)
+	}
+}
+
+func traceGotConn(trace *httptrace.ClientTrace, sess quic.Session, reused, wasIdle bool, idleTime time.Duration) {
+	if trace != nil && trace.GotConn != nil {
+		trace.GotConn(httptrace.GotConnInfo{
+			Conn:     &sessionConn{session: sess},
+			Reused:   reused,
+			WasIdle:  wasIdle,
+			IdleTime: idleTime,
+		})
+	}
+}
+
+func traceWroteHeaders(trace *httptrace.ClientTrace) {
+	if trace != nil && trace.WroteHeaders != nil {
+		trace.WroteHeaders()
+	}
+}
+
+func traceWroteRequest(trace *httptrace.ClientTrace, err error) {
+	if trace != nil && trace.WroteRequest != nil {
+		trace.WroteRequest(httptrace.WroteRequestInfo{Err: err})
+	}
+}
+
+func traceGotFirstResponseByte(trace *httptrace.ClientTrace) {
+	if trace != nil && trace.GotFirstResponseByte != nil {
+		trace.GotFirstResponseByte()
+	}
+}
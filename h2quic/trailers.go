@@ -0,0 +1,133 @@
+package h2quic
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/http/httpguts"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+// A trailerReceiver receives the trailers of a request or a response.
+// The body is sent on the data stream, but the trailers are sent in a HEADERS frame on the header stream,
+// so they can be received before or after the end of the body.
+type trailerReceiver struct {
+	deliverOnce sync.Once
+	received    chan struct{} // closed when the trailers were received
+	fields      []hpack.HeaderField
+	read        bool // set when readTrailers was called
+
+	// done is closed when we should stop waiting for the trailers, e.g. because the session was closed
+	done        <-chan struct{}
+	release     func() // called once the trailers are not needed any more
+	releaseOnce sync.Once
+}
+
+func newTrailerReceiver(done <-chan struct{}, release func()) *trailerReceiver {
+	return &trailerReceiver{
+		received: make(chan struct{}),
+		done:     done,
+		release:  release,
+	}
+}
+
+// deliver is called when the trailing HEADERS frame was received
+func (r *trailerReceiver) deliver(fields []hpack.HeaderField) {
+	r.deliverOnce.Do(func() {
+		r.fields = fields
+		close(r.received)
+	})
+}
+
+// readTrailers is called when the end of the body was reached. It adds the trailers to trailer.
+// If trailers were declared in the Trailer header field, it waits until they are received.
+// Trailers that were not declared are only added if they were already received.
+func (r *trailerReceiver) readTrailers(trailer *http.Header) {
+	if r.read {
+		return
+	}
+	r.read = true
+	defer r.close()
+
+	if len(*trailer) > 0 {
+		select {
+		case <-r.received:
+		case <-r.done:
+			return
+		}
+	}
+	select {
+	case <-r.received:
+	default:
+		return
+	}
+	for _, hf := range r.fields {
+		key := http.CanonicalHeaderKey(hf.Name)
+		if hf.IsPseudo() || !httpguts.ValidTrailerHeader(key) {
+			continue
+		}
+		if *trailer == nil {
+			*trailer = make(http.Header)
+		}
+		(*trailer)[key] = append((*trailer)[key], hf.Value)
+	}
+}
+
+// close is called when the trailers are not needed any more
+func (r *trailerReceiver) close() {
+	r.releaseOnce.Do(r.release)
+}
+
+// isTrailers says if a header block contains trailers.
+// Trailers are sent in a HEADERS frame with the END_STREAM flag set, and don't contain pseudo header fields.
+func isTrailers(f *http2.HeadersFrame, fields []hpack.HeaderField) bool {
+	if !f.StreamEnded() {
+		return false
+	}
+	for _, hf := range fields {
+		if hf.IsPseudo() {
+			return false
+		}
+	}
+	return true
+}
+
+// encodeTrailers encodes the trailers, skipping header fields that are not allowed in trailers
+func encodeTrailers(enc *hpack.Encoder, trailer http.Header) {
+	for k, vv := range trailer {
+		if !httpguts.ValidTrailerHeader(k) {
+			continue
+		}
+		for _, v := range vv {
+			enc.WriteField(hpack.HeaderField{Name: strings.ToLower(k), Value: v})
+		}
+	}
+}
+
+// copied from net/http2/transport.go
+func commaSeparatedTrailers(req *http.Request) (string, error) {
+	keys := make([]string, 0, len(req.Trailer))
+	for k := range req.Trailer {
+		k = http.CanonicalHeaderKey(k)
+		switch k {
+		case "Transfer-Encoding", "Trailer", "Content-Length":
+			return "", &badStringError{"invalid Trailer key", k}
+		}
+		keys = append(keys, k)
+	}
+	if len(keys) > 0 {
+		sort.Strings(keys)
+		return strings.Join(keys, ","), nil
+	}
+	return "", nil
+}
+
+type badStringError struct {
+	what string
+	str  string
+}
+
+func (e *badStringError) Error() string { return e.what + " " + e.str }
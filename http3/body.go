@@ -0,0 +1,148 @@
+package http3
+
+import (
+	"io"
+	"net/http"
+
+	"golang.org/x/net/http/httpguts"
+
+	quic "github.com/wheelcomplex/qk"
+)
+
+// A body reads the DATA frames of a request or response stream.
+// A HEADERS frame following the DATA frames contains the trailers.
+type body struct {
+	str quic.Stream
+	r   *byteReader
+
+	// session is closed if the peer violates the framing rules
+	session quic.Session
+
+	bytesRemainingInFrame uint64
+	readErr               error // sticky
+
+	// trailer is filled with the trailers, if the peer sends any
+	trailer       *http.Header
+	maxHeaderSize uint64
+}
+
+var _ io.ReadCloser = &body{}
+
+func newBody(str quic.Stream, session quic.Session, trailer *http.Header, maxHeaderSize uint64) *body {
+	return &body{
+		str:           str,
+		r:             &byteReader{Reader: str},
+		session:       session,
+		trailer:       trailer,
+		maxHeaderSize: maxHeaderSize,
+	}
+}
+
+func (b *body) Read(p []byte) (int, error) {
+	if b.readErr != nil {
+		return 0, b.readErr
+	}
+	n, err := b.readImpl(p)
+	if err != nil {
+		b.readErr = err
+		if cerr, ok := err.(*connectionError); ok && b.session != nil {
+			b.session.CloseWithError(quic.ErrorCode(cerr.code), cerr)
+		}
+	}
+	return n, err
+}
+
+func (b *body) readImpl(p []byte) (int, error) {
+	for b.bytesRemainingInFrame == 0 {
+		f, err := parseNextFrame(b.r)
+		if err != nil {
+			return 0, err
+		}
+		switch f := f.(type) {
+		case *dataFrame:
+			b.bytesRemainingInFrame = f.Length
+		case *headersFrame:
+			if err := b.readTrailers(f); err != nil {
+				return 0, err
+			}
+			return 0, io.EOF
+		default:
+			return 0, newConnectionError(errorFrameUnexpected, "unexpected frame on a request stream")
+		}
+	}
+	if uint64(len(p)) > b.bytesRemainingInFrame {
+		p = p[:b.bytesRemainingInFrame]
+	}
+	n, err := b.r.Read(p)
+	b.bytesRemainingInFrame -= uint64(n)
+	if err == io.EOF && b.bytesRemainingInFrame > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// readTrailers reads the trailers. No frames are allowed to follow the trailers.
+func (b *body) readTrailers(f *headersFrame) error {
+	if f.Length > b.maxHeaderSize {
+		return newConnectionError(errorExcessiveLoad, "trailers too large: %d bytes", f.Length)
+	}
+	fields, err := readHeadersFrame(b.r, f)
+	if err != nil {
+		return err
+	}
+	if _, err := parseNextFrame(b.r); err != io.EOF {
+		if err == nil {
+			return newConnectionError(errorFrameUnexpected, "frame after the trailers")
+		}
+		return err
+	}
+	if b.trailer == nil {
+		return nil
+	}
+	for _, hf := range fields {
+		if hf.IsPseudo() {
+			return newConnectionError(errorMessageError, "pseudo header field in trailers")
+		}
+		key := http.CanonicalHeaderKey(hf.Name)
+		if !httpguts.ValidTrailerHeader(key) {
+			continue
+		}
+		if *b.trailer == nil {
+			*b.trailer = make(http.Header)
+		}
+		(*b.trailer)[key] = append((*b.trailer)[key], hf.Value)
+	}
+	return nil
+}
+
+// Close is a no-op. The server and the client cancel reading, if the body wasn't read completely.
+func (b *body) Close() error { return nil }
+
+// requestRead says if the body was read until the end
+func (b *body) requestRead() bool {
+	return b.readErr == io.EOF
+}
+
+// a responseBody is the body of a response received by the client
+type responseBody struct {
+	*body
+	done func() // called when the body was read completely, or closed
+}
+
+func (r *responseBody) Read(p []byte) (int, error) {
+	n, err := r.body.Read(p)
+	if err != nil {
+		r.done()
+	}
+	return n, err
+}
+
+// Close stops reading the response body.
+// If the body wasn't read completely, the server is asked to stop sending.
+func (r *responseBody) Close() error {
+	if r.readErr != io.EOF {
+		r.str.CancelRead(quic.ErrorCode(errorRequestCanceled))
+	}
+	r.done()
+	return nil
+}
@@ -0,0 +1,167 @@
+package http3
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/golang/mock/gomock"
+	quic "github.com/wheelcomplex/qk"
+	"github.com/wheelcomplex/qk/http3/qpack"
+	mockquic "github.com/wheelcomplex/qk/internal/mocks/quic"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Body", func() {
+	var (
+		str     *mockStream
+		session *mockquic.MockSession
+		trailer http.Header
+		b       *body
+	)
+
+	writeData := func(data []byte) {
+		(&dataFrame{Length: uint64(len(data))}).Write(&str.dataToRead)
+		str.dataToRead.Write(data)
+	}
+
+	BeforeEach(func() {
+		str = newMockStream(0)
+		session, _ = newMockSession()
+		trailer = nil
+		b = newBody(str, session, &trailer, 1000)
+	})
+
+	It("reads DATA frames", func() {
+		writeData([]byte("foo"))
+		writeData([]byte("bar"))
+		data, err := ioutil.ReadAll(b)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(data).To(Equal([]byte("foobar")))
+		Expect(b.requestRead()).To(BeTrue())
+	})
+
+	It("reads empty DATA frames", func() {
+		writeData(nil)
+		writeData([]byte("foo"))
+		data, err := ioutil.ReadAll(b)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(data).To(Equal([]byte("foo")))
+	})
+
+	It("doesn't read beyond the current DATA frame", func() {
+		writeData([]byte("foo"))
+		writeData([]byte("bar"))
+		p := make([]byte, 6)
+		n, err := b.Read(p)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(p[:n]).To(Equal([]byte("foo")))
+		Expect(b.requestRead()).To(BeFalse())
+	})
+
+	It("errors if the stream ends within a DATA frame", func() {
+		(&dataFrame{Length: 10}).Write(&str.dataToRead)
+		str.dataToRead.Write([]byte("foo"))
+		_, err := ioutil.ReadAll(b)
+		Expect(err).To(Equal(io.ErrUnexpectedEOF))
+		Expect(b.requestRead()).To(BeFalse())
+	})
+
+	It("reads the trailers", func() {
+		writeData([]byte("foo"))
+		Expect(writeHeadersFrame(&str.dataToRead, func(enc *qpack.Encoder) {
+			enc.WriteField(qpack.HeaderField{Name: "x-foo", Value: "bar"})
+			enc.WriteField(qpack.HeaderField{Name: "content-length", Value: "3"})
+		})).To(Succeed())
+		data, err := ioutil.ReadAll(b)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(data).To(Equal([]byte("foo")))
+		Expect(trailer).To(Equal(http.Header{"X-Foo": {"bar"}}))
+	})
+
+	It("closes the session if a frame follows the trailers", func() {
+		Expect(writeHeadersFrame(&str.dataToRead, func(enc *qpack.Encoder) {
+			enc.WriteField(qpack.HeaderField{Name: "x-foo", Value: "bar"})
+		})).To(Succeed())
+		writeData([]byte("foo"))
+		session.EXPECT().CloseWithError(quic.ErrorCode(errorFrameUnexpected), gomock.Any())
+		_, err := ioutil.ReadAll(b)
+		Expect(err).To(MatchError("H3_FRAME_UNEXPECTED: frame after the trailers"))
+	})
+
+	It("closes the session on pseudo header fields in the trailers", func() {
+		Expect(writeHeadersFrame(&str.dataToRead, func(enc *qpack.Encoder) {
+			enc.WriteField(qpack.HeaderField{Name: ":status", Value: "200"})
+		})).To(Succeed())
+		session.EXPECT().CloseWithError(quic.ErrorCode(errorMessageError), gomock.Any())
+		_, err := ioutil.ReadAll(b)
+		Expect(err).To(MatchError("H3_MESSAGE_ERROR: pseudo header field in trailers"))
+	})
+
+	It("closes the session if the trailers are too large", func() {
+		(&headersFrame{Length: 1001}).Write(&str.dataToRead)
+		session.EXPECT().CloseWithError(quic.ErrorCode(errorExcessiveLoad), gomock.Any())
+		_, err := ioutil.ReadAll(b)
+		Expect(err).To(MatchError("H3_EXCESSIVE_LOAD: trailers too large: 1001 bytes"))
+	})
+
+	It("closes the session on unexpected frames", func() {
+		(&settingsFrame{}).Write(&str.dataToRead)
+		session.EXPECT().CloseWithError(quic.ErrorCode(errorFrameUnexpected), gomock.Any())
+		_, err := ioutil.ReadAll(b)
+		Expect(err).To(MatchError("H3_FRAME_UNEXPECTED: unexpected frame on a request stream"))
+	})
+
+	It("returns the same error on subsequent reads", func() {
+		(&settingsFrame{}).Write(&str.dataToRead)
+		session.EXPECT().CloseWithError(quic.ErrorCode(errorFrameUnexpected), gomock.Any())
+		_, err := b.Read(make([]byte, 10))
+		Expect(err).To(HaveOccurred())
+		writeData([]byte("foo"))
+		_, err2 := b.Read(make([]byte, 10))
+		Expect(err2).To(Equal(err))
+	})
+
+	Context("response bodies", func() {
+		var (
+			rb     *responseBody
+			called int
+		)
+
+		BeforeEach(func() {
+			called = 0
+			rb = &responseBody{body: b, done: func() { called++ }}
+		})
+
+		It("calls done when the body was read", func() {
+			writeData([]byte("foo"))
+			_, err := ioutil.ReadAll(rb)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(called).ToNot(BeZero())
+			Expect(rb.Close()).To(Succeed())
+			Expect(str.canceledRead).To(BeFalse())
+		})
+
+		It("cancels reading when closed before the body was read", func() {
+			writeData([]byte("foo"))
+			Expect(rb.Close()).To(Succeed())
+			Expect(called).To(Equal(1))
+			Expect(str.canceledRead).To(BeTrue())
+			Expect(str.cancelReadErr).To(BeEquivalentTo(errorRequestCanceled))
+		})
+	})
+
+	It("reads a body written by the responseWriter", func() {
+		w := newResponseWriter(str, nil)
+		w.headerWritten = true
+		w.status = 200
+		_, err := w.Write([]byte("foobar"))
+		Expect(err).ToNot(HaveOccurred())
+		str.dataToRead.Write(str.dataWritten.Bytes())
+		data, err := ioutil.ReadAll(b)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(data).To(Equal([]byte("foobar")))
+	})
+})
@@ -0,0 +1,404 @@
+package http3
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/idna"
+
+	quic "github.com/wheelcomplex/qk"
+	"github.com/wheelcomplex/qk/internal/utils"
+)
+
+type roundTripperOpts struct {
+	DisableCompression     bool
+	MaxResponseHeaderBytes int64
+}
+
+var dialAddr = quic.DialAddr
+
+// defaultMaxResponseHeaderBytes is the default limit of the size of the response header
+const defaultMaxResponseHeaderBytes = 10 << 20 // 10 MB
+
+// bodyCopyBufferSize is the size of the buffer used to send the request body in DATA frames
+const bodyCopyBufferSize = 8 * 1024
+
+var (
+	// errGoAway is returned if the server sent a GOAWAY frame before the request could be sent
+	errGoAway = errors.New("http3: server is going away")
+	// errRequestRejected is returned if the server rejected the request without processing it
+	errRequestRejected = errors.New("http3: request rejected by the server")
+)
+
+// client is a HTTP/3 client doing requests on a single QUIC connection
+type client struct {
+	mutex sync.Mutex
+
+	tlsConf *tls.Config
+	config  *quic.Config
+	opts    *roundTripperOpts
+
+	hostname     string
+	handshakeErr error
+	dialOnce     sync.Once
+	dialer       func(network, addr string, tlsCfg *tls.Config, cfg *quic.Config) (quic.Session, error)
+
+	conn           *connection // set when dialing succeeded
+	controlStr     quic.SendStream
+	receivedGoAway bool
+	goAwayStreamID uint64 // the ID of the first request stream that the server won't process
+	activeRequests int    // the number of requests whose response body wasn't read or closed yet
+
+	requestWriter *requestWriter
+
+	logger utils.Logger
+}
+
+var _ roundTripCloser = &client{}
+
+var defaultQuicConfig = &quic.Config{
+	KeepAlive: true,
+}
+
+// newClient creates a new client
+func newClient(
+	hostname string,
+	tlsConfig *tls.Config,
+	opts *roundTripperOpts,
+	quicConfig *quic.Config,
+	dialer func(network, addr string, tlsCfg *tls.Config, cfg *quic.Config) (quic.Session, error),
+) *client {
+	config := defaultQuicConfig
+	if quicConfig != nil {
+		config = quicConfig
+	}
+	logger := utils.DefaultLogger.WithPrefix("http3 client")
+	return &client{
+		hostname:      authorityAddr("https", hostname),
+		tlsConf:       tlsConfig,
+		config:        config,
+		opts:          opts,
+		dialer:        dialer,
+		requestWriter: newRequestWriter(logger),
+		logger:        logger,
+	}
+}
+
+// dial dials the QUIC connection, and opens the control stream
+func (c *client) dial() error {
+	quicConf, err := quicConfig(c.config)
+	if err != nil {
+		return err
+	}
+	tlsConf := tlsConfigWithALPN(c.tlsConf)
+	var session quic.Session
+	if c.dialer != nil {
+		session, err = c.dialer("udp", c.hostname, tlsConf, quicConf)
+	} else {
+		session, err = dialAddr(c.hostname, tlsConf, quicConf)
+	}
+	if err != nil {
+		return err
+	}
+	if proto := session.ConnectionState().NegotiatedProtocol; proto != nextProtoH3 {
+		err := fmt.Errorf("http3: server didn't negotiate HTTP/3 (ALPN: %q)", proto)
+		session.CloseWithError(quic.ErrorCode(errorVersionFallback), err)
+		return err
+	}
+
+	conn := newConnection(session, false, c.logger)
+	conn.onGoAway = c.handleGoAway
+	controlStr, err := conn.openControlStream(map[uint64]uint64{settingMaxFieldSectionSize: c.maxHeaderBytes()})
+	if err != nil {
+		session.CloseWithError(quic.ErrorCode(errorInternalError), err)
+		return err
+	}
+	c.mutex.Lock()
+	c.conn = conn
+	c.controlStr = controlStr
+	c.mutex.Unlock()
+	go conn.handleUnidirectionalStreams()
+	return nil
+}
+
+func (c *client) maxHeaderBytes() uint64 {
+	if c.opts.MaxResponseHeaderBytes <= 0 {
+		return defaultMaxResponseHeaderBytes
+	}
+	return uint64(c.opts.MaxResponseHeaderBytes)
+}
+
+// handleGoAway is called when the server sent a GOAWAY frame
+func (c *client) handleGoAway(streamID uint64) error {
+	// the GOAWAY frame sent by the server contains the ID of a client-initiated bidirectional stream
+	if streamID%4 != 0 {
+		return newConnectionError(errorIDError, "invalid stream ID in GOAWAY frame: %d", streamID)
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.receivedGoAway && streamID > c.goAwayStreamID {
+		return newConnectionError(errorIDError, "stream ID in GOAWAY frame increased from %d to %d", c.goAwayStreamID, streamID)
+	}
+	c.logger.Debugf("Server sent GOAWAY (stream ID %d)", streamID)
+	c.receivedGoAway = true
+	c.goAwayStreamID = streamID
+	if c.activeRequests == 0 {
+		go c.Close()
+	}
+	return nil
+}
+
+// startRequest registers a new request, if the client is still usable
+func (c *client) startRequest() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.receivedGoAway || c.conn.session.Context().Err() != nil {
+		return false
+	}
+	c.activeRequests++
+	return true
+}
+
+// requestDone is called when a request completed.
+// After the server sent a GOAWAY frame, the session is closed as soon as the last request completed.
+func (c *client) requestDone() {
+	c.mutex.Lock()
+	c.activeRequests--
+	closeSession := c.receivedGoAway && c.activeRequests == 0
+	c.mutex.Unlock()
+	if closeSession {
+		c.Close()
+	}
+}
+
+// isUsable says if new requests can be sent using this client.
+// A client can't be used any more if dialing failed, after the server sent a GOAWAY frame, or when the QUIC session was closed.
+func (c *client) isUsable() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.receivedGoAway || c.handshakeErr != nil {
+		return false
+	}
+	return c.conn == nil || c.conn.session.Context().Err() == nil
+}
+
+// Close closes the QUIC session, if it was established
+func (c *client) Close() error {
+	c.mutex.Lock()
+	conn := c.conn
+	c.mutex.Unlock()
+	if conn == nil {
+		return nil
+	}
+	return conn.session.CloseWithError(quic.ErrorCode(errorNoError), errors.New("client closing"))
+}
+
+// RoundTrip executes a request and returns a response
+func (c *client) RoundTrip(req *http.Request) (*http.Response, error) {
+	if authorityAddr("https", hostnameFromRequest(req)) != c.hostname {
+		return nil, fmt.Errorf("http3 client BUG: RoundTrip called for the wrong client (expected %s, got %s)", c.hostname, req.Host)
+	}
+
+	c.dialOnce.Do(func() {
+		err := c.dial()
+		c.mutex.Lock()
+		c.handshakeErr = err
+		c.mutex.Unlock()
+	})
+	c.mutex.Lock()
+	handshakeErr := c.handshakeErr
+	c.mutex.Unlock()
+	if handshakeErr != nil {
+		return nil, handshakeErr
+	}
+	if !c.startRequest() {
+		return nil, errGoAway
+	}
+
+	ctx := req.Context()
+	str, err := c.conn.session.OpenStreamSync()
+	if err != nil {
+		c.requestDone()
+		return nil, err
+	}
+
+	// Request gzip only, not deflate. Deflate is ambiguous and
+	// not as universally supported anyway.
+	// See: http://www.gzip.org/zlib/zlib_faq.html#faq38
+	//
+	// Note that we don't request this for HEAD requests,
+	// due to a bug in nginx:
+	//   http://trac.nginx.org/nginx/ticket/358
+	//   https://golang.org/issue/5522
+	//
+	// We don't request gzip if the request is for a range, since
+	// auto-decoding a portion of a gzipped document will just fail
+	// anyway. See https://golang.org/issue/8923
+	var requestGzip bool
+	if !c.opts.DisableCompression && req.Header.Get("Accept-Encoding") == "" && req.Header.Get("Range") == "" && req.Method != "HEAD" {
+		requestGzip = true
+	}
+
+	// The stream is reset when the request is canceled, until the response body was read or closed.
+	reqDone := make(chan struct{})
+	var reqDoneOnce sync.Once
+	done := func() {
+		reqDoneOnce.Do(func() {
+			close(reqDone)
+			c.requestDone()
+		})
+	}
+	go func() {
+		select {
+		case <-ctx.Done():
+			str.CancelWrite(quic.ErrorCode(errorRequestCanceled))
+			str.CancelRead(quic.ErrorCode(errorRequestCanceled))
+		case <-reqDone:
+		}
+	}()
+
+	if err := c.requestWriter.WriteRequestHeader(str, req, requestGzip); err != nil {
+		done()
+		str.CancelWrite(quic.ErrorCode(errorRequestCanceled))
+		str.CancelRead(quic.ErrorCode(errorRequestCanceled))
+		return nil, err
+	}
+	if req.Body == nil && len(req.Trailer) == 0 {
+		str.Close()
+	} else {
+		go c.writeRequestBody(str, req)
+	}
+
+	res, err := c.readResponse(str, req)
+	if err != nil {
+		done()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if serr, ok := err.(quic.StreamError); ok && serr.ErrorCode() == quic.ErrorCode(errorRequestRejected) {
+			return nil, errRequestRejected
+		}
+		if cerr, ok := err.(*connectionError); ok {
+			c.conn.closeWithError(cerr)
+		} else {
+			str.CancelWrite(quic.ErrorCode(errorRequestCanceled))
+			str.CancelRead(quic.ErrorCode(errorRequestCanceled))
+		}
+		return nil, err
+	}
+
+	body := newBody(str, c.conn.session, &res.Trailer, c.maxHeaderBytes())
+	res.Body = &responseBody{body: body, done: done}
+	if requestGzip && res.Header.Get("Content-Encoding") == "gzip" {
+		res.Header.Del("Content-Encoding")
+		res.Header.Del("Content-Length")
+		res.ContentLength = -1
+		res.Body = &gzipReader{body: res.Body}
+		res.Uncompressed = true
+	}
+	res.Request = req
+	res.TLS = tlsConnectionState(c.conn.session.ConnectionState())
+	return res, nil
+}
+
+// readResponse reads the response header.
+// Interim responses (1xx) are skipped.
+func (c *client) readResponse(str quic.Stream, req *http.Request) (*http.Response, error) {
+	br := &byteReader{Reader: str}
+	for {
+		f, err := parseNextFrame(br)
+		if err != nil {
+			return nil, err
+		}
+		hf, ok := f.(*headersFrame)
+		if !ok {
+			return nil, newConnectionError(errorFrameUnexpected, "expected first frame to be a HEADERS frame")
+		}
+		if hf.Length > c.maxHeaderBytes() {
+			return nil, errResponseHeaderListSize
+		}
+		fields, err := readHeadersFrame(br, hf)
+		if err != nil {
+			return nil, err
+		}
+		res, err := responseFromHeaders(fields)
+		if err != nil {
+			return nil, err
+		}
+		if res.StatusCode >= 100 && res.StatusCode < 200 && res.StatusCode != http.StatusSwitchingProtocols {
+			continue
+		}
+		return res, nil
+	}
+}
+
+// writeRequestBody sends the request body in DATA frames, followed by the trailers
+func (c *client) writeRequestBody(str quic.Stream, req *http.Request) {
+	if err := c.writeRequestBodyImpl(str, req); err != nil {
+		c.logger.Debugf("Error writing request body: %s", err)
+		str.CancelWrite(quic.ErrorCode(errorRequestCanceled))
+		return
+	}
+	str.Close()
+}
+
+func (c *client) writeRequestBodyImpl(str quic.Stream, req *http.Request) error {
+	if req.Body != nil {
+		defer req.Body.Close()
+		b := make([]byte, bodyCopyBufferSize)
+		var hdr bytes.Buffer
+		for {
+			n, rerr := req.Body.Read(b)
+			if n > 0 {
+				hdr.Reset()
+				(&dataFrame{Length: uint64(n)}).Write(&hdr)
+				if _, err := str.Write(hdr.Bytes()); err != nil {
+					return err
+				}
+				if _, err := str.Write(b[:n]); err != nil {
+					return err
+				}
+			}
+			if rerr == io.EOF {
+				break
+			}
+			if rerr != nil {
+				return rerr
+			}
+		}
+	}
+	if len(req.Trailer) > 0 {
+		return c.requestWriter.WriteTrailers(str, req.Trailer)
+	}
+	return nil
+}
+
+// copied from net/transport.go
+
+// authorityAddr returns a given authority (a host/IP, or host:port / ip:port)
+// and returns a host:port. The port 443 is added if needed.
+func authorityAddr(scheme string, authority string) (addr string) {
+	host, port, err := net.SplitHostPort(authority)
+	if err != nil { // authority didn't have a port
+		port = "443"
+		if scheme == "http" {
+			port = "80"
+		}
+		host = authority
+	}
+	if a, err := idna.ToASCII(host); err == nil {
+		host = a
+	}
+	// IPv6 address literal, without a port:
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		return host + ":" + port
+	}
+	return net.JoinHostPort(host, port)
+}
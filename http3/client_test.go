@@ -0,0 +1,111 @@
+package http3
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/golang/mock/gomock"
+	quic "github.com/wheelcomplex/qk"
+	mockquic "github.com/wheelcomplex/qk/internal/mocks/quic"
+	"github.com/wheelcomplex/qk/internal/utils"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Client", func() {
+	var (
+		cl           *client
+		session      *mockquic.MockSession
+		closeSession context.CancelFunc
+	)
+
+	BeforeEach(func() {
+		cl = newClient("quic.clemente.io:1337", nil, &roundTripperOpts{}, nil, nil)
+		session, closeSession = newMockSession()
+		cl.conn = newConnection(session, false, utils.DefaultLogger)
+	})
+
+	It("adds the port to the hostname", func() {
+		cl = newClient("quic.clemente.io", nil, &roundTripperOpts{}, nil, nil)
+		Expect(cl.hostname).To(Equal("quic.clemente.io:443"))
+	})
+
+	It("errors if it is used for the wrong host", func() {
+		req, err := http.NewRequest("GET", "https://quic.clemente.io:1336/foo", nil)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = cl.RoundTrip(req)
+		Expect(err).To(MatchError("http3 client BUG: RoundTrip called for the wrong client (expected quic.clemente.io:1337, got quic.clemente.io:1336)"))
+	})
+
+	It("uses the default limit for the response header", func() {
+		Expect(cl.maxHeaderBytes()).To(BeEquivalentTo(defaultMaxResponseHeaderBytes))
+		cl.opts.MaxResponseHeaderBytes = 1337
+		Expect(cl.maxHeaderBytes()).To(BeEquivalentTo(1337))
+	})
+
+	Context("GOAWAY", func() {
+		It("isn't usable any more after receiving a GOAWAY frame", func() {
+			Expect(cl.isUsable()).To(BeTrue())
+			cl.activeRequests = 1
+			Expect(cl.handleGoAway(8)).To(Succeed())
+			Expect(cl.isUsable()).To(BeFalse())
+			Expect(cl.startRequest()).To(BeFalse())
+		})
+
+		It("closes the session when the last request completed", func() {
+			Expect(cl.startRequest()).To(BeTrue())
+			Expect(cl.startRequest()).To(BeTrue())
+			Expect(cl.handleGoAway(8)).To(Succeed())
+			cl.requestDone()
+			session.EXPECT().CloseWithError(quic.ErrorCode(errorNoError), gomock.Any())
+			cl.requestDone()
+		})
+
+		It("closes the session if there are no active requests", func() {
+			closed := make(chan struct{})
+			session.EXPECT().CloseWithError(quic.ErrorCode(errorNoError), gomock.Any()).Do(func(quic.ErrorCode, error) {
+				close(closed)
+			})
+			Expect(cl.handleGoAway(8)).To(Succeed())
+			Eventually(closed).Should(BeClosed())
+		})
+
+		It("accepts GOAWAY frames with a decreasing stream ID", func() {
+			cl.activeRequests = 1
+			Expect(cl.handleGoAway(8)).To(Succeed())
+			Expect(cl.handleGoAway(4)).To(Succeed())
+			Expect(cl.goAwayStreamID).To(BeEquivalentTo(4))
+		})
+
+		It("errors if the stream ID increases", func() {
+			cl.activeRequests = 1
+			Expect(cl.handleGoAway(4)).To(Succeed())
+			Expect(cl.handleGoAway(8)).To(MatchError("H3_ID_ERROR: stream ID in GOAWAY frame increased from 4 to 8"))
+		})
+
+		It("errors if the stream ID is not a client-initiated bidirectional stream", func() {
+			Expect(cl.handleGoAway(3)).To(MatchError("H3_ID_ERROR: invalid stream ID in GOAWAY frame: 3"))
+		})
+	})
+
+	It("isn't usable any more after the session was closed", func() {
+		closeSession()
+		Expect(cl.isUsable()).To(BeFalse())
+	})
+
+	Context("authorityAddr", func() {
+		It("adds the default port", func() {
+			Expect(authorityAddr("https", "quic.clemente.io")).To(Equal("quic.clemente.io:443"))
+			Expect(authorityAddr("http", "quic.clemente.io")).To(Equal("quic.clemente.io:80"))
+		})
+
+		It("keeps the port", func() {
+			Expect(authorityAddr("https", "quic.clemente.io:1337")).To(Equal("quic.clemente.io:1337"))
+		})
+
+		It("handles IPv6 addresses", func() {
+			Expect(authorityAddr("https", "[::1]")).To(Equal("[::1]:443"))
+		})
+	})
+})
@@ -0,0 +1,55 @@
+package http3
+
+import (
+	"crypto/tls"
+	"errors"
+
+	quic "github.com/wheelcomplex/qk"
+)
+
+// quicConfig returns the QUIC configuration used for HTTP/3.
+// HTTP/3 requires a QUIC version that uses TLS, and ALPN, which is only supported by crypto/tls.
+// gQUIC versions are removed from the versions of conf. If no versions are configured, QUIC v1 is used.
+func quicConfig(conf *quic.Config) (*quic.Config, error) {
+	var c quic.Config
+	if conf != nil {
+		c = *conf
+	}
+	c.UseCryptoTLS = true
+	if len(c.Versions) == 0 {
+		c.Versions = []quic.VersionNumber{quic.VersionQUIC1}
+		return &c, nil
+	}
+	var versions []quic.VersionNumber
+	for _, v := range c.Versions {
+		if v.UsesTLS() {
+			versions = append(versions, v)
+		}
+	}
+	if len(versions) == 0 {
+		return nil, errors.New("http3: no IETF QUIC version configured")
+	}
+	c.Versions = versions
+	return &c, nil
+}
+
+// tlsConfigWithALPN returns a copy of conf that offers h3 as the ALPN protocol
+func tlsConfigWithALPN(conf *tls.Config) *tls.Config {
+	if conf == nil {
+		conf = &tls.Config{}
+	} else {
+		conf = conf.Clone()
+	}
+	conf.NextProtos = []string{nextProtoH3}
+	return conf
+}
+
+// tlsConnectionState converts the connection state of a QUIC session to a tls.ConnectionState
+func tlsConnectionState(state quic.ConnectionState) *tls.ConnectionState {
+	return &tls.ConnectionState{
+		HandshakeComplete:  state.HandshakeComplete,
+		ServerName:         state.ServerName,
+		PeerCertificates:   state.PeerCertificates,
+		NegotiatedProtocol: state.NegotiatedProtocol,
+	}
+}
@@ -0,0 +1,171 @@
+package http3
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	quic "github.com/wheelcomplex/qk"
+	"github.com/wheelcomplex/qk/internal/utils"
+)
+
+// A connection holds the state of an HTTP/3 connection that is used by both the client and the server:
+// the control streams and the QPACK streams.
+// Since we don't use the dynamic table, the QPACK streams are not used, and we don't open them.
+type connection struct {
+	session  quic.Session
+	isServer bool
+
+	mutex            sync.Mutex
+	peerStreams      map[uint64]bool // the types of the critical unidirectional streams opened by the peer
+	peerSettings     map[uint64]uint64
+	settingsReceived chan struct{} // closed when the SETTINGS frame was received
+
+	// onGoAway is called when the server sent a GOAWAY frame. Only used by the client.
+	onGoAway func(streamID uint64) error
+
+	logger utils.Logger
+}
+
+func newConnection(session quic.Session, isServer bool, logger utils.Logger) *connection {
+	return &connection{
+		session:          session,
+		isServer:         isServer,
+		peerStreams:      make(map[uint64]bool),
+		settingsReceived: make(chan struct{}),
+		logger:           logger,
+	}
+}
+
+// openControlStream opens the control stream, and sends the SETTINGS frame
+func (c *connection) openControlStream(settings map[uint64]uint64) (quic.SendStream, error) {
+	str, err := c.session.OpenUniStream()
+	if err != nil {
+		return nil, err
+	}
+	buf := &bytes.Buffer{}
+	utils.WriteVarInt(buf, streamTypeControl)
+	(&settingsFrame{settings: settings}).Write(buf)
+	if _, err := str.Write(buf.Bytes()); err != nil {
+		return nil, err
+	}
+	return str, nil
+}
+
+// closeWithError closes the QUIC session. It is safe to call it multiple times.
+func (c *connection) closeWithError(err *connectionError) {
+	c.logger.Debugf("Closing HTTP/3 connection: %s", err)
+	c.session.CloseWithError(quic.ErrorCode(err.code), err)
+}
+
+// handleUnidirectionalStreams accepts the unidirectional streams opened by the peer, until the session is closed
+func (c *connection) handleUnidirectionalStreams() {
+	for {
+		str, err := c.session.AcceptUniStream()
+		if err != nil {
+			return
+		}
+		go func() {
+			if err := c.handleUnidirectionalStream(str); err != nil {
+				if cerr, ok := err.(*connectionError); ok {
+					c.closeWithError(cerr)
+				}
+			}
+		}()
+	}
+}
+
+func (c *connection) handleUnidirectionalStream(str quic.ReceiveStream) error {
+	br := &byteReader{Reader: str}
+	streamType, err := br.readVarInt(false)
+	if err != nil {
+		return err
+	}
+	switch streamType {
+	case streamTypeControl, streamTypeQPACKEncoder, streamTypeQPACKDecoder:
+		c.mutex.Lock()
+		opened := c.peerStreams[streamType]
+		c.peerStreams[streamType] = true
+		c.mutex.Unlock()
+		if opened {
+			return newConnectionError(errorStreamCreationError, "duplicate stream of type %#x", streamType)
+		}
+	case streamTypePush:
+		if c.isServer {
+			return newConnectionError(errorStreamCreationError, "client opened a push stream")
+		}
+		// we never send a MAX_PUSH_ID frame, so the server isn't allowed to push
+		return newConnectionError(errorIDError, "server opened a push stream")
+	default:
+		// reserved and unknown stream types, see RFC 9114, section 6.2.3
+		str.CancelRead(quic.ErrorCode(errorStreamCreationError))
+		return nil
+	}
+
+	if streamType == streamTypeControl {
+		err = c.handleControlStream(br)
+	} else {
+		// Since we announced a dynamic table capacity of 0, the peer's encoder can't insert any entries,
+		// and our encoder never references the dynamic table. There's nothing to do for the QPACK instructions.
+		_, err = io.Copy(ioutil.Discard, br)
+	}
+	if c.session.Context().Err() != nil {
+		return nil
+	}
+	if err == nil || err == io.EOF || err == io.ErrUnexpectedEOF {
+		return newConnectionError(errorClosedCriticalStream, "peer closed stream of type %#x", streamType)
+	}
+	return err
+}
+
+func (c *connection) handleControlStream(br *byteReader) error {
+	f, err := parseNextFrame(br)
+	if err != nil {
+		return err
+	}
+	settings, ok := f.(*settingsFrame)
+	if !ok {
+		return newConnectionError(errorMissingSettings, "first frame on the control stream must be a SETTINGS frame")
+	}
+	c.mutex.Lock()
+	c.peerSettings = settings.settings
+	c.mutex.Unlock()
+	close(c.settingsReceived)
+
+	for {
+		f, err := parseNextFrame(br)
+		if err != nil {
+			return err
+		}
+		switch f := f.(type) {
+		case *goAwayFrame:
+			if c.isServer {
+				// the client sends the push ID in the GOAWAY frame, but we never push
+				continue
+			}
+			if err := c.onGoAway(f.StreamID); err != nil {
+				return err
+			}
+		case *pushFrame:
+			if c.isServer && f.Type == frameTypeMaxPushID {
+				// we never push
+				continue
+			}
+			if f.Type == frameTypeCancelPush {
+				return newConnectionError(errorIDError, "CANCEL_PUSH for a push that was never promised")
+			}
+			return newConnectionError(errorFrameUnexpected, "unexpected frame type %#x on the control stream", f.Type)
+		default:
+			return newConnectionError(errorFrameUnexpected, "unexpected %T on the control stream", f)
+		}
+	}
+}
+
+// peerSetting returns the value of a setting sent by the peer
+func (c *connection) peerSetting(id uint64) (uint64, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	val, ok := c.peerSettings[id]
+	return val, ok
+}
@@ -0,0 +1,166 @@
+package http3
+
+import (
+	"bytes"
+	"context"
+	"errors"
+
+	"github.com/golang/mock/gomock"
+	quic "github.com/wheelcomplex/qk"
+	mockquic "github.com/wheelcomplex/qk/internal/mocks/quic"
+	"github.com/wheelcomplex/qk/internal/utils"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// newMockSession creates a MockSession that is not closed yet.
+// Calling the cancel function closes its context.
+func newMockSession() (*mockquic.MockSession, context.CancelFunc) {
+	session := mockquic.NewMockSession(mockCtrl)
+	ctx, cancel := context.WithCancel(context.Background())
+	session.EXPECT().Context().Return(ctx).AnyTimes()
+	return session, cancel
+}
+
+var _ = Describe("Connection", func() {
+	var (
+		session      *mockquic.MockSession
+		closeSession context.CancelFunc
+		conn         *connection
+	)
+
+	BeforeEach(func() {
+		session, closeSession = newMockSession()
+		conn = newConnection(session, true, utils.DefaultLogger)
+	})
+
+	// controlStream creates a control stream containing the frames
+	controlStream := func(frames ...interface{ Write(*bytes.Buffer) }) *mockStream {
+		str := newMockStream(3)
+		utils.WriteVarInt(&str.dataToRead, streamTypeControl)
+		for _, f := range frames {
+			f.Write(&str.dataToRead)
+		}
+		return str
+	}
+
+	expectConnectionError := func(err error, code errorCode) {
+		ExpectWithOffset(1, err).To(BeAssignableToTypeOf(&connectionError{}))
+		ExpectWithOffset(1, err.(*connectionError).code).To(Equal(code))
+	}
+
+	It("opens the control stream", func() {
+		controlStr := newMockStream(3)
+		session.EXPECT().OpenUniStream().Return(controlStr, nil)
+		str, err := conn.openControlStream(map[uint64]uint64{settingMaxFieldSectionSize: 1337})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(str).To(Equal(controlStr))
+		br := &byteReader{Reader: &controlStr.dataWritten}
+		streamType, err := br.readVarInt(false)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(streamType).To(BeEquivalentTo(streamTypeControl))
+		f, err := parseNextFrame(br)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(f).To(Equal(&settingsFrame{settings: map[uint64]uint64{settingMaxFieldSectionSize: 1337}}))
+	})
+
+	It("reads the SETTINGS frame", func() {
+		str := controlStream(&settingsFrame{settings: map[uint64]uint64{settingMaxFieldSectionSize: 1337}})
+		err := conn.handleUnidirectionalStream(str)
+		expectConnectionError(err, errorClosedCriticalStream)
+		Expect(conn.settingsReceived).To(BeClosed())
+		val, ok := conn.peerSetting(settingMaxFieldSectionSize)
+		Expect(ok).To(BeTrue())
+		Expect(val).To(BeEquivalentTo(1337))
+		_, ok = conn.peerSetting(settingQPACKMaxTableCapacity)
+		Expect(ok).To(BeFalse())
+	})
+
+	It("errors if the first frame on the control stream is not a SETTINGS frame", func() {
+		str := controlStream(&goAwayFrame{StreamID: 4})
+		expectConnectionError(conn.handleUnidirectionalStream(str), errorMissingSettings)
+	})
+
+	It("errors on a second control stream", func() {
+		closeSession() // make sure that the first control stream isn't treated as closed
+		Expect(conn.handleUnidirectionalStream(controlStream(&settingsFrame{}))).To(Succeed())
+		expectConnectionError(conn.handleUnidirectionalStream(controlStream(&settingsFrame{})), errorStreamCreationError)
+	})
+
+	It("errors on a second SETTINGS frame", func() {
+		str := controlStream(&settingsFrame{}, &settingsFrame{})
+		expectConnectionError(conn.handleUnidirectionalStream(str), errorFrameUnexpected)
+	})
+
+	It("errors on DATA frames on the control stream", func() {
+		str := controlStream(&settingsFrame{}, &dataFrame{Length: 0})
+		expectConnectionError(conn.handleUnidirectionalStream(str), errorFrameUnexpected)
+	})
+
+	It("doesn't treat a closed control stream as an error when the session is closed", func() {
+		str := controlStream(&settingsFrame{})
+		closeSession()
+		Expect(conn.handleUnidirectionalStream(str)).To(Succeed())
+	})
+
+	It("cancels unknown streams", func() {
+		str := newMockStream(3)
+		utils.WriteVarInt(&str.dataToRead, 0x21)
+		Expect(conn.handleUnidirectionalStream(str)).To(Succeed())
+		Expect(str.canceledRead).To(BeTrue())
+		Expect(str.cancelReadErr).To(BeEquivalentTo(errorStreamCreationError))
+	})
+
+	It("discards the QPACK encoder stream", func() {
+		str := newMockStream(3)
+		utils.WriteVarInt(&str.dataToRead, streamTypeQPACKEncoder)
+		str.dataToRead.Write([]byte("foobar"))
+		expectConnectionError(conn.handleUnidirectionalStream(str), errorClosedCriticalStream)
+		Expect(str.dataToRead.Len()).To(BeZero())
+	})
+
+	It("errors on push streams opened by the client", func() {
+		str := newMockStream(3)
+		utils.WriteVarInt(&str.dataToRead, streamTypePush)
+		expectConnectionError(conn.handleUnidirectionalStream(str), errorStreamCreationError)
+	})
+
+	It("errors on push streams opened by the server", func() {
+		conn.isServer = false
+		str := newMockStream(3)
+		utils.WriteVarInt(&str.dataToRead, streamTypePush)
+		expectConnectionError(conn.handleUnidirectionalStream(str), errorIDError)
+	})
+
+	It("ignores MAX_PUSH_ID frames on the server side", func() {
+		str := controlStream(&settingsFrame{})
+		utils.WriteVarInt(&str.dataToRead, frameTypeMaxPushID)
+		utils.WriteVarInt(&str.dataToRead, 1)
+		utils.WriteVarInt(&str.dataToRead, 10)
+		expectConnectionError(conn.handleUnidirectionalStream(str), errorClosedCriticalStream)
+	})
+
+	It("passes GOAWAY frames to the client", func() {
+		conn.isServer = false
+		var streamIDs []uint64
+		conn.onGoAway = func(id uint64) error {
+			streamIDs = append(streamIDs, id)
+			return nil
+		}
+		str := controlStream(&settingsFrame{}, &goAwayFrame{StreamID: 8}, &goAwayFrame{StreamID: 4})
+		expectConnectionError(conn.handleUnidirectionalStream(str), errorClosedCriticalStream)
+		Expect(streamIDs).To(Equal([]uint64{8, 4}))
+	})
+
+	It("closes the session when handling a unidirectional stream fails", func() {
+		closed := make(chan struct{})
+		session.EXPECT().AcceptUniStream().Return(controlStream(&goAwayFrame{StreamID: 4}), nil)
+		session.EXPECT().AcceptUniStream().Return(nil, errors.New("session closed"))
+		session.EXPECT().CloseWithError(quic.ErrorCode(errorMissingSettings), gomock.Any()).Do(func(quic.ErrorCode, error) {
+			close(closed)
+		})
+		conn.handleUnidirectionalStreams()
+		Eventually(closed).Should(BeClosed())
+	})
+})
@@ -0,0 +1,228 @@
+package http3
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+
+	"github.com/wheelcomplex/qk/internal/utils"
+)
+
+// The frame types, see RFC 9114, section 7.2
+const (
+	frameTypeData        = 0x0
+	frameTypeHeaders     = 0x1
+	frameTypeCancelPush  = 0x3
+	frameTypeSettings    = 0x4
+	frameTypePushPromise = 0x5
+	frameTypeGoAway      = 0x7
+	frameTypeMaxPushID   = 0xd
+)
+
+// The unidirectional stream types, see RFC 9114, section 6.2, and RFC 9204, section 4.2
+const (
+	streamTypeControl      = 0x0
+	streamTypePush         = 0x1
+	streamTypeQPACKEncoder = 0x2
+	streamTypeQPACKDecoder = 0x3
+)
+
+// maxControlFramePayload is the maximum payload size of the frames on the control stream that we parse.
+// It prevents the peer from making us allocate large buffers.
+const maxControlFramePayload = 1 << 14
+
+type frame interface{}
+
+// byteReader implements io.ByteReader for a stream, without reading ahead.
+type byteReader struct {
+	io.Reader
+	buf   [1]byte
+	count uint64 // number of bytes read using ReadByte
+}
+
+func (r *byteReader) ReadByte() (byte, error) {
+	n, err := r.Reader.Read(r.buf[:])
+	if n == 1 {
+		r.count++
+		return r.buf[0], nil
+	}
+	if err == nil {
+		err = io.ErrNoProgress
+	}
+	return 0, err
+}
+
+// readVarInt reads a QUIC varint.
+// If allowEOF is set, it returns io.EOF if the stream ended before the first byte of the varint.
+// In all other cases, it returns io.ErrUnexpectedEOF if the stream ended.
+func (r *byteReader) readVarInt(allowEOF bool) (uint64, error) {
+	start := r.count
+	v, err := utils.ReadVarInt(r)
+	if err == io.EOF && (!allowEOF || r.count > start) {
+		return 0, io.ErrUnexpectedEOF
+	}
+	return v, err
+}
+
+// parseNextFrame parses the next frame from r.
+// For DATA and HEADERS frames, only the frame header is consumed, and the caller has to read the payload.
+// Frames of unknown types are skipped, see RFC 9114, section 9.
+// It returns io.EOF if the stream ended before the first byte of the frame.
+func parseNextFrame(r io.Reader) (frame, error) {
+	br, ok := r.(*byteReader)
+	if !ok {
+		br = &byteReader{Reader: r}
+	}
+	for {
+		t, err := br.readVarInt(true)
+		if err != nil {
+			return nil, err
+		}
+		l, err := br.readVarInt(false)
+		if err != nil {
+			return nil, err
+		}
+
+		switch t {
+		case frameTypeData:
+			return &dataFrame{Length: l}, nil
+		case frameTypeHeaders:
+			return &headersFrame{Length: l}, nil
+		case frameTypeSettings:
+			return parseSettingsFrame(br, l)
+		case frameTypeGoAway:
+			return parseGoAwayFrame(br, l)
+		case frameTypeCancelPush, frameTypePushPromise, frameTypeMaxPushID:
+			// Server push is not supported, so the payload of these frames is never needed.
+			if _, err := readPayload(br, l); err != nil {
+				return nil, err
+			}
+			return &pushFrame{Type: t}, nil
+		case 0x2, 0x6, 0x8, 0x9:
+			// frame types that are reserved, since they're used in HTTP/2, see RFC 9114, section 11.2.1
+			return nil, newConnectionError(errorFrameUnexpected, "reserved frame type %#x", t)
+		default:
+			if _, err := io.CopyN(ioutil.Discard, br, int64(l)); err != nil {
+				if err == io.EOF {
+					return nil, io.ErrUnexpectedEOF
+				}
+				return nil, err
+			}
+		}
+	}
+}
+
+// readPayload reads the payload of a frame on the control stream
+func readPayload(r io.Reader, l uint64) ([]byte, error) {
+	if l > maxControlFramePayload {
+		return nil, newConnectionError(errorExcessiveLoad, "frame too large: %d bytes", l)
+	}
+	payload := make([]byte, l)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return payload, nil
+}
+
+// A pushFrame is a CANCEL_PUSH, PUSH_PROMISE or MAX_PUSH_ID frame.
+// We never allow the server to push (we don't send a MAX_PUSH_ID frame), and we never push.
+type pushFrame struct {
+	Type uint64
+}
+
+type dataFrame struct {
+	Length uint64
+}
+
+func (f *dataFrame) Write(b *bytes.Buffer) {
+	utils.WriteVarInt(b, frameTypeData)
+	utils.WriteVarInt(b, f.Length)
+}
+
+type headersFrame struct {
+	Length uint64
+}
+
+func (f *headersFrame) Write(b *bytes.Buffer) {
+	utils.WriteVarInt(b, frameTypeHeaders)
+	utils.WriteVarInt(b, f.Length)
+}
+
+// The settings, see RFC 9114, section 7.2.4.1, and RFC 9204, section 5
+const (
+	settingQPACKMaxTableCapacity = 0x1
+	settingMaxFieldSectionSize   = 0x6
+	settingQPACKBlockedStreams   = 0x7
+)
+
+type settingsFrame struct {
+	settings map[uint64]uint64
+}
+
+func parseSettingsFrame(r io.Reader, l uint64) (*settingsFrame, error) {
+	payload, err := readPayload(r, l)
+	if err != nil {
+		return nil, err
+	}
+	br := &byteReader{Reader: bytes.NewReader(payload)}
+	f := &settingsFrame{settings: make(map[uint64]uint64)}
+	for br.count < l {
+		id, err := br.readVarInt(false)
+		if err != nil {
+			return nil, newConnectionError(errorFrameError, "invalid SETTINGS frame")
+		}
+		val, err := br.readVarInt(false)
+		if err != nil {
+			return nil, newConnectionError(errorFrameError, "invalid SETTINGS frame")
+		}
+		switch id {
+		case 0x2, 0x3, 0x4, 0x5:
+			// settings that are reserved, since they're used in HTTP/2, see RFC 9114, section 7.2.4.1
+			return nil, newConnectionError(errorSettingsError, "reserved setting %#x", id)
+		}
+		if _, ok := f.settings[id]; ok {
+			return nil, newConnectionError(errorSettingsError, "duplicate setting %#x", id)
+		}
+		f.settings[id] = val
+	}
+	return f, nil
+}
+
+func (f *settingsFrame) Write(b *bytes.Buffer) {
+	var payload bytes.Buffer
+	for id, val := range f.settings {
+		utils.WriteVarInt(&payload, id)
+		utils.WriteVarInt(&payload, val)
+	}
+	utils.WriteVarInt(b, frameTypeSettings)
+	utils.WriteVarInt(b, uint64(payload.Len()))
+	b.Write(payload.Bytes())
+}
+
+// A goAwayFrame contains the ID of the first request stream that the server won't process.
+// Sent by the client, it would contain a push ID.
+type goAwayFrame struct {
+	StreamID uint64
+}
+
+func parseGoAwayFrame(r io.Reader, l uint64) (*goAwayFrame, error) {
+	payload, err := readPayload(r, l)
+	if err != nil {
+		return nil, err
+	}
+	br := &byteReader{Reader: bytes.NewReader(payload)}
+	id, err := br.readVarInt(false)
+	if err != nil || br.count != l {
+		return nil, newConnectionError(errorFrameError, "invalid GOAWAY frame")
+	}
+	return &goAwayFrame{StreamID: id}, nil
+}
+
+func (f *goAwayFrame) Write(b *bytes.Buffer) {
+	utils.WriteVarInt(b, frameTypeGoAway)
+	utils.WriteVarInt(b, uint64(utils.VarIntLen(f.StreamID)))
+	utils.WriteVarInt(b, f.StreamID)
+}
@@ -0,0 +1,167 @@
+package http3
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/wheelcomplex/qk/internal/utils"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Frames", func() {
+	appendVarInt := func(b []byte, i uint64) []byte {
+		buf := &bytes.Buffer{}
+		utils.WriteVarInt(buf, i)
+		return append(b, buf.Bytes()...)
+	}
+
+	It("returns io.EOF if the stream ended before the first frame", func() {
+		_, err := parseNextFrame(&bytes.Buffer{})
+		Expect(err).To(Equal(io.EOF))
+	})
+
+	It("returns io.ErrUnexpectedEOF if the stream ended within the frame header", func() {
+		_, err := parseNextFrame(bytes.NewReader([]byte{frameTypeData}))
+		Expect(err).To(Equal(io.ErrUnexpectedEOF))
+	})
+
+	It("skips unknown frames", func() {
+		data := appendVarInt(nil, 0xdead)
+		data = appendVarInt(data, 3)
+		data = append(data, []byte("foo")...)
+		buf := bytes.NewBuffer(data)
+		(&dataFrame{Length: 0x42}).Write(buf)
+		f, err := parseNextFrame(buf)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(f).To(Equal(&dataFrame{Length: 0x42}))
+		Expect(buf.Len()).To(BeZero())
+	})
+
+	It("errors on frame types that are reserved for HTTP/2", func() {
+		for _, t := range []uint64{0x2, 0x6, 0x8, 0x9} {
+			data := appendVarInt(nil, t)
+			data = appendVarInt(data, 0)
+			_, err := parseNextFrame(bytes.NewReader(data))
+			Expect(err).To(BeAssignableToTypeOf(&connectionError{}))
+			Expect(err.(*connectionError).code).To(Equal(errorFrameUnexpected))
+		}
+	})
+
+	It("parses push frames", func() {
+		data := appendVarInt(nil, frameTypeMaxPushID)
+		data = appendVarInt(data, 1)
+		data = appendVarInt(data, 10)
+		f, err := parseNextFrame(bytes.NewReader(data))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(f).To(Equal(&pushFrame{Type: frameTypeMaxPushID}))
+	})
+
+	Context("DATA frames", func() {
+		It("writes and parses the frame header", func() {
+			buf := &bytes.Buffer{}
+			(&dataFrame{Length: 0x1337}).Write(buf)
+			buf.Write([]byte("payload"))
+			f, err := parseNextFrame(buf)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(f).To(Equal(&dataFrame{Length: 0x1337}))
+			Expect(buf.String()).To(Equal("payload"))
+		})
+	})
+
+	Context("HEADERS frames", func() {
+		It("writes and parses the frame header", func() {
+			buf := &bytes.Buffer{}
+			(&headersFrame{Length: 0x42}).Write(buf)
+			f, err := parseNextFrame(buf)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(f).To(Equal(&headersFrame{Length: 0x42}))
+		})
+	})
+
+	Context("SETTINGS frames", func() {
+		It("writes and parses", func() {
+			sf := &settingsFrame{settings: map[uint64]uint64{
+				settingMaxFieldSectionSize: 1 << 20,
+				0xdead:                     0xbeef,
+			}}
+			buf := &bytes.Buffer{}
+			sf.Write(buf)
+			f, err := parseNextFrame(buf)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(f).To(Equal(sf))
+			Expect(buf.Len()).To(BeZero())
+		})
+
+		It("parses an empty frame", func() {
+			buf := &bytes.Buffer{}
+			(&settingsFrame{}).Write(buf)
+			f, err := parseNextFrame(buf)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(f.(*settingsFrame).settings).To(BeEmpty())
+		})
+
+		It("errors on duplicate settings", func() {
+			payload := appendVarInt(nil, settingMaxFieldSectionSize)
+			payload = appendVarInt(payload, 1)
+			payload = appendVarInt(payload, settingMaxFieldSectionSize)
+			payload = appendVarInt(payload, 2)
+			data := appendVarInt(nil, frameTypeSettings)
+			data = appendVarInt(data, uint64(len(payload)))
+			_, err := parseNextFrame(bytes.NewReader(append(data, payload...)))
+			Expect(err).To(MatchError("H3_SETTINGS_ERROR: duplicate setting 0x6"))
+		})
+
+		It("errors on settings that are reserved for HTTP/2", func() {
+			payload := appendVarInt(nil, 0x2)
+			payload = appendVarInt(payload, 1)
+			data := appendVarInt(nil, frameTypeSettings)
+			data = appendVarInt(data, uint64(len(payload)))
+			_, err := parseNextFrame(bytes.NewReader(append(data, payload...)))
+			Expect(err).To(MatchError("H3_SETTINGS_ERROR: reserved setting 0x2"))
+		})
+
+		It("errors on truncated settings", func() {
+			payload := appendVarInt(nil, settingMaxFieldSectionSize)
+			data := appendVarInt(nil, frameTypeSettings)
+			data = appendVarInt(data, uint64(len(payload)))
+			_, err := parseNextFrame(bytes.NewReader(append(data, payload...)))
+			Expect(err).To(MatchError("H3_FRAME_ERROR: invalid SETTINGS frame"))
+		})
+
+		It("errors on frames that are too large", func() {
+			data := appendVarInt(nil, frameTypeSettings)
+			data = appendVarInt(data, maxControlFramePayload+1)
+			_, err := parseNextFrame(bytes.NewReader(data))
+			Expect(err).To(BeAssignableToTypeOf(&connectionError{}))
+			Expect(err.(*connectionError).code).To(Equal(errorExcessiveLoad))
+		})
+
+		It("returns io.ErrUnexpectedEOF if the payload is incomplete", func() {
+			buf := &bytes.Buffer{}
+			(&settingsFrame{settings: map[uint64]uint64{settingMaxFieldSectionSize: 1000}}).Write(buf)
+			_, err := parseNextFrame(bytes.NewReader(buf.Bytes()[:buf.Len()-1]))
+			Expect(err).To(Equal(io.ErrUnexpectedEOF))
+		})
+	})
+
+	Context("GOAWAY frames", func() {
+		It("writes and parses", func() {
+			buf := &bytes.Buffer{}
+			(&goAwayFrame{StreamID: 0x1234}).Write(buf)
+			f, err := parseNextFrame(buf)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(f).To(Equal(&goAwayFrame{StreamID: 0x1234}))
+		})
+
+		It("errors if the payload has the wrong length", func() {
+			data := appendVarInt(nil, frameTypeGoAway)
+			data = appendVarInt(data, 2)
+			data = appendVarInt(data, 4)
+			data = append(data, 0)
+			_, err := parseNextFrame(bytes.NewReader(data))
+			Expect(err).To(MatchError("H3_FRAME_ERROR: invalid GOAWAY frame"))
+		})
+	})
+})
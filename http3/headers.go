@@ -0,0 +1,81 @@
+package http3
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/http/httpguts"
+
+	"github.com/wheelcomplex/qk/http3/qpack"
+)
+
+// writeHeadersFrame writes a HEADERS frame containing the field section encoded by encode.
+// The frame is written using a single call to w.Write.
+func writeHeadersFrame(w io.Writer, encode func(enc *qpack.Encoder)) error {
+	var fields bytes.Buffer
+	enc := qpack.NewEncoder(&fields)
+	encode(enc)
+	enc.Close()
+
+	buf := &bytes.Buffer{}
+	(&headersFrame{Length: uint64(fields.Len())}).Write(buf)
+	buf.Write(fields.Bytes())
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// readHeadersFrame reads the payload of a HEADERS frame, and decodes the field section
+func readHeadersFrame(r io.Reader, f *headersFrame) ([]qpack.HeaderField, error) {
+	payload := make([]byte, f.Length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	fields, err := qpack.NewDecoder().DecodeFull(payload)
+	if err != nil {
+		return nil, newConnectionError(errorQPACKDecompressionFailed, "%s", err)
+	}
+	return fields, nil
+}
+
+// encodeTrailers encodes the trailers, skipping header fields that are not allowed in trailers
+func encodeTrailers(enc *qpack.Encoder, trailer http.Header) {
+	for k, vv := range trailer {
+		if !httpguts.ValidTrailerHeader(k) {
+			continue
+		}
+		for _, v := range vv {
+			enc.WriteField(qpack.HeaderField{Name: strings.ToLower(k), Value: v})
+		}
+	}
+}
+
+// copied from net/http2/transport.go
+func commaSeparatedTrailers(req *http.Request) (string, error) {
+	keys := make([]string, 0, len(req.Trailer))
+	for k := range req.Trailer {
+		k = http.CanonicalHeaderKey(k)
+		switch k {
+		case "Transfer-Encoding", "Trailer", "Content-Length":
+			return "", &badStringError{"invalid Trailer key", k}
+		}
+		keys = append(keys, k)
+	}
+	if len(keys) > 0 {
+		sort.Strings(keys)
+		return strings.Join(keys, ","), nil
+	}
+	return "", nil
+}
+
+type badStringError struct {
+	what string
+	str  string
+}
+
+func (e *badStringError) Error() string { return e.what + " " + e.str }
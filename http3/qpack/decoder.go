@@ -0,0 +1,150 @@
+package qpack
+
+import (
+	"errors"
+	"fmt"
+
+	"golang.org/x/net/http2/hpack"
+)
+
+// ErrDynamicTable is returned when a field section references the dynamic table.
+// The Decoder doesn't support the dynamic table, and announces a table capacity of 0.
+// Encoders must not reference the dynamic table in this case, see RFC 9204, section 3.2.3.
+var ErrDynamicTable = errors.New("qpack: dynamic table not supported")
+
+// A DecodingError is something the spec defines as a decoding error.
+type DecodingError struct {
+	Err error
+}
+
+func (de DecodingError) Error() string {
+	return fmt.Sprintf("decoding error: %v", de.Err)
+}
+
+// An InvalidIndexError is returned when an encoder references a table
+// entry before the static table or after the end of the dynamic table.
+type InvalidIndexError int
+
+func (e InvalidIndexError) Error() string {
+	return fmt.Sprintf("invalid indexed representation index %d", int(e))
+}
+
+// A Decoder decodes field sections.
+type Decoder struct {
+	// maxStringLength is the maximum length of a single name or value
+	maxStringLength int
+}
+
+// NewDecoder returns a new decoder.
+func NewDecoder() *Decoder {
+	return &Decoder{}
+}
+
+// SetMaxStringLength sets the maximum size of a HeaderField name or
+// value string. If a string exceeds this length, DecodeFull will return
+// an error. A value of 0 means unlimited.
+func (d *Decoder) SetMaxStringLength(n int) {
+	d.maxStringLength = n
+}
+
+// DecodeFull decodes an entire field section.
+func (d *Decoder) DecodeFull(p []byte) ([]HeaderField, error) {
+	requiredInsertCount, p, err := readVarInt(8, p)
+	if err != nil {
+		return nil, DecodingError{err}
+	}
+	if requiredInsertCount != 0 {
+		return nil, DecodingError{ErrDynamicTable}
+	}
+	// Without the dynamic table, the Base is not used, and we just skip the Delta Base.
+	if _, p, err = readVarInt(7, p); err != nil {
+		return nil, DecodingError{err}
+	}
+
+	var fields []HeaderField
+	for len(p) > 0 {
+		var hf HeaderField
+		switch b := p[0]; {
+		case b&0x80 != 0: // Indexed Field Line
+			if b&0x40 == 0 {
+				return nil, DecodingError{ErrDynamicTable}
+			}
+			var idx uint64
+			idx, p, err = readVarInt(6, p)
+			if err != nil {
+				return nil, DecodingError{err}
+			}
+			hf, err = staticTableEntry(idx)
+			if err != nil {
+				return nil, DecodingError{err}
+			}
+		case b&0xc0 == 0x40: // Literal Field Line with Name Reference
+			if b&0x10 == 0 {
+				return nil, DecodingError{ErrDynamicTable}
+			}
+			var idx uint64
+			idx, p, err = readVarInt(4, p)
+			if err != nil {
+				return nil, DecodingError{err}
+			}
+			hf, err = staticTableEntry(idx)
+			if err != nil {
+				return nil, DecodingError{err}
+			}
+			hf.Value, p, err = d.readString(7, p)
+			if err != nil {
+				return nil, DecodingError{err}
+			}
+		case b&0xe0 == 0x20: // Literal Field Line with Literal Name
+			hf.Name, p, err = d.readString(3, p)
+			if err != nil {
+				return nil, DecodingError{err}
+			}
+			hf.Value, p, err = d.readString(7, p)
+			if err != nil {
+				return nil, DecodingError{err}
+			}
+		default: // Indexed Field Line with Post-Base Index, and Literal Field Line with Post-Base Name Reference
+			return nil, DecodingError{ErrDynamicTable}
+		}
+		fields = append(fields, hf)
+	}
+	return fields, nil
+}
+
+func staticTableEntry(idx uint64) (HeaderField, error) {
+	if idx >= uint64(len(staticTable)) {
+		return HeaderField{}, InvalidIndexError(idx)
+	}
+	return staticTable[idx], nil
+}
+
+// readString reads a string literal, using an n bit prefix for the length.
+// The bit before the prefix is the Huffman flag.
+func (d *Decoder) readString(n byte, p []byte) (string, []byte, error) {
+	if len(p) == 0 {
+		return "", p, errNeedMore
+	}
+	isHuffman := p[0]&(1<<n) != 0
+	strLen, p, err := readVarInt(n, p)
+	if err != nil {
+		return "", p, err
+	}
+	if d.maxStringLength > 0 && strLen > uint64(d.maxStringLength) {
+		return "", nil, errStringTooLong
+	}
+	if uint64(len(p)) < strLen {
+		return "", p, errNeedMore
+	}
+	if !isHuffman {
+		return string(p[:strLen]), p[strLen:], nil
+	}
+	s, err := hpack.HuffmanDecodeToString(p[:strLen])
+	if err != nil {
+		return "", nil, errInvalidHuffman
+	}
+	if d.maxStringLength > 0 && len(s) > d.maxStringLength {
+		return "", nil, errStringTooLong
+	}
+	return s, p[strLen:], nil
+}
@@ -0,0 +1,97 @@
+package qpack
+
+import (
+	"bytes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Decoder", func() {
+	var decoder *Decoder
+
+	BeforeEach(func() {
+		decoder = NewDecoder()
+	})
+
+	It("decodes the example from RFC 9204, appendix B.1", func() {
+		data := []byte{
+			0x0, 0x0, // Required Insert Count = 0, Base = 0
+			0x51, 0x0b, '/', 'i', 'n', 'd', 'e', 'x', '.', 'h', 't', 'm', 'l',
+		}
+		fields, err := decoder.DecodeFull(data)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(fields).To(Equal([]HeaderField{{Name: ":path", Value: "/index.html"}}))
+	})
+
+	It("decodes what the encoder encoded", func() {
+		in := []HeaderField{
+			{Name: ":status", Value: "200"},
+			{Name: ":status", Value: "418"},
+			{Name: "content-type", Value: "text/plain"},
+			{Name: "x-custom", Value: "foobar"},
+			{Name: "empty", Value: ""},
+		}
+		buf := &bytes.Buffer{}
+		encoder := NewEncoder(buf)
+		for _, hf := range in {
+			Expect(encoder.WriteField(hf)).To(Succeed())
+		}
+		Expect(encoder.Close()).To(Succeed())
+		fields, err := decoder.DecodeFull(buf.Bytes())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(fields).To(Equal(in))
+	})
+
+	It("decodes empty field sections", func() {
+		fields, err := decoder.DecodeFull([]byte{0x0, 0x0})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(fields).To(BeEmpty())
+	})
+
+	It("errors on an incomplete prefix", func() {
+		_, err := decoder.DecodeFull([]byte{0x0})
+		Expect(err).To(MatchError(DecodingError{errNeedMore}))
+	})
+
+	It("errors if the Required Insert Count is not 0", func() {
+		_, err := decoder.DecodeFull([]byte{0x2, 0x0, 0xc0 | 17})
+		Expect(err).To(MatchError(DecodingError{ErrDynamicTable}))
+	})
+
+	It("errors on references to the dynamic table", func() {
+		for _, b := range []byte{
+			0x80, // Indexed Field Line, dynamic table
+			0x40, // Literal Field Line with Name Reference, dynamic table
+			0x10, // Indexed Field Line with Post-Base Index
+			0x00, // Literal Field Line with Post-Base Name Reference
+		} {
+			_, err := decoder.DecodeFull([]byte{0x0, 0x0, b, 0x0})
+			Expect(err).To(MatchError(DecodingError{ErrDynamicTable}))
+		}
+	})
+
+	It("errors on invalid static table indices", func() {
+		data := appendIndexedField([]byte{0x0, 0x0}, 99)
+		_, err := decoder.DecodeFull(data)
+		Expect(err).To(MatchError(DecodingError{InvalidIndexError(99)}))
+	})
+
+	It("errors on incomplete strings", func() {
+		_, err := decoder.DecodeFull([]byte{0x0, 0x0, 0x21, 'x', 0x5, 'y'})
+		Expect(err).To(MatchError(DecodingError{errNeedMore}))
+	})
+
+	It("errors on invalid Huffman data", func() {
+		_, err := decoder.DecodeFull([]byte{0x0, 0x0, 0x51, 0x81, 0xff})
+		Expect(err).To(MatchError(DecodingError{errInvalidHuffman}))
+	})
+
+	It("limits the length of strings", func() {
+		decoder.SetMaxStringLength(10)
+		buf := &bytes.Buffer{}
+		Expect(NewEncoder(buf).WriteField(HeaderField{Name: "foo", Value: "0123456789a"})).To(Succeed())
+		_, err := decoder.DecodeFull(buf.Bytes())
+		Expect(err).To(MatchError(DecodingError{errStringTooLong}))
+	})
+})
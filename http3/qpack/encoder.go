@@ -0,0 +1,102 @@
+package qpack
+
+import (
+	"io"
+
+	"golang.org/x/net/http2/hpack"
+)
+
+// An Encoder encodes field sections.
+// It only references the static table, and never inserts entries into the dynamic table.
+// Therefore, field sections encoded by the Encoder can be decoded by every decoder,
+// independent of the value of SETTINGS_QPACK_MAX_TABLE_CAPACITY it announced.
+type Encoder struct {
+	w   io.Writer
+	buf []byte
+
+	wrotePrefix bool
+}
+
+// NewEncoder returns a new Encoder which performs QPACK encoding.
+// An encoded data is written to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// WriteField encodes f into a single Write to e's underlying Writer.
+// The first call to WriteField of a field section also writes the field section prefix.
+// The field name must be lower case.
+func (e *Encoder) WriteField(f HeaderField) error {
+	e.buf = e.buf[:0]
+	if !e.wrotePrefix {
+		e.buf = appendPrefix(e.buf)
+		e.wrotePrefix = true
+	}
+	if idx, ok := staticTableFields[f]; ok {
+		e.buf = appendIndexedField(e.buf, idx)
+	} else if idx, ok := staticTableNames[f.Name]; ok {
+		e.buf = appendLiteralFieldWithNameReference(e.buf, idx, f.Value)
+	} else {
+		e.buf = appendLiteralField(e.buf, f)
+	}
+	_, err := e.w.Write(e.buf)
+	return err
+}
+
+// Close finishes the current field section.
+// If no field was written, it writes the prefix of an empty field section.
+// The next call to WriteField starts a new field section.
+func (e *Encoder) Close() error {
+	defer func() { e.wrotePrefix = false }()
+	if e.wrotePrefix {
+		return nil
+	}
+	_, err := e.w.Write(appendPrefix(nil))
+	return err
+}
+
+// appendPrefix appends the field section prefix, see RFC 9204, section 4.5.1.
+// Since the dynamic table is not used, both the Required Insert Count and the Delta Base are 0.
+func appendPrefix(dst []byte) []byte {
+	return append(dst, 0x0, 0x0)
+}
+
+// appendIndexedField appends an Indexed Field Line referencing the static table, see RFC 9204, section 4.5.2.
+func appendIndexedField(dst []byte, idx uint64) []byte {
+	first := len(dst)
+	dst = appendVarInt(dst, 6, idx)
+	dst[first] |= 0xc0
+	return dst
+}
+
+// appendLiteralFieldWithNameReference appends a Literal Field Line with Name Reference
+// referencing the static table, see RFC 9204, section 4.5.4.
+func appendLiteralFieldWithNameReference(dst []byte, idx uint64, value string) []byte {
+	first := len(dst)
+	dst = appendVarInt(dst, 4, idx)
+	dst[first] |= 0x50
+	return appendString(dst, 7, value)
+}
+
+// appendLiteralField appends a Literal Field Line with Literal Name, see RFC 9204, section 4.5.6.
+func appendLiteralField(dst []byte, f HeaderField) []byte {
+	first := len(dst)
+	dst = appendString(dst, 3, f.Name)
+	dst[first] |= 0x20
+	return appendString(dst, 7, f.Value)
+}
+
+// appendString appends s, as encoded in the string literal representation of RFC 7541, section 5.2,
+// using an n bit prefix for the length.
+// The Huffman encoding is used if it is shorter than the raw string.
+func appendString(dst []byte, n byte, s string) []byte {
+	first := len(dst)
+	huffmanLength := hpack.HuffmanEncodeLength(s)
+	if huffmanLength < uint64(len(s)) {
+		dst = appendVarInt(dst, n, huffmanLength)
+		dst[first] |= 1 << n
+		return hpack.AppendHuffmanString(dst, s)
+	}
+	dst = appendVarInt(dst, n, uint64(len(s)))
+	return append(dst, s...)
+}
@@ -0,0 +1,66 @@
+package qpack
+
+import (
+	"bytes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Encoder", func() {
+	var (
+		encoder *Encoder
+		output  *bytes.Buffer
+	)
+
+	BeforeEach(func() {
+		output = &bytes.Buffer{}
+		encoder = NewEncoder(output)
+	})
+
+	It("encodes a field that is contained in the static table", func() {
+		Expect(encoder.WriteField(HeaderField{Name: ":method", Value: "GET"})).To(Succeed())
+		Expect(encoder.Close()).To(Succeed())
+		Expect(output.Bytes()).To(Equal([]byte{0x0, 0x0, 0xc0 | 17}))
+	})
+
+	It("references the name in the static table", func() {
+		Expect(encoder.WriteField(HeaderField{Name: ":path", Value: "/"})).To(Succeed())
+		Expect(encoder.WriteField(HeaderField{Name: "age", Value: "42"})).To(Succeed())
+		Expect(output.Bytes()).To(Equal([]byte{0x0, 0x0, 0xc0 | 1, 0x50 | 2, 0x2, '4', '2'}))
+	})
+
+	It("encodes literal field names", func() {
+		Expect(encoder.WriteField(HeaderField{Name: "x", Value: "y"})).To(Succeed())
+		Expect(output.Bytes()).To(Equal([]byte{0x0, 0x0, 0x20 | 1, 'x', 0x1, 'y'}))
+	})
+
+	It("uses the Huffman encoding, if it is shorter", func() {
+		Expect(encoder.WriteField(HeaderField{Name: "custom-key", Value: "custom-value"})).To(Succeed())
+		data := output.Bytes()
+		Expect(data[2] & 0xe8).To(Equal(byte(0x28))) // the name is Huffman encoded
+		// the length of the Huffman encoded name (8 bytes) doesn't fit into the 3 bit prefix
+		Expect(data).To(HaveLen(2 + 2 + 8 + 1 + 9))
+	})
+
+	It("writes the prefix of an empty field section", func() {
+		Expect(encoder.Close()).To(Succeed())
+		Expect(output.Bytes()).To(Equal([]byte{0x0, 0x0}))
+	})
+
+	It("starts a new field section after Close", func() {
+		Expect(encoder.WriteField(HeaderField{Name: ":method", Value: "GET"})).To(Succeed())
+		Expect(encoder.Close()).To(Succeed())
+		Expect(encoder.WriteField(HeaderField{Name: ":method", Value: "GET"})).To(Succeed())
+		Expect(encoder.Close()).To(Succeed())
+		Expect(output.Bytes()).To(Equal([]byte{0x0, 0x0, 0xc0 | 17, 0x0, 0x0, 0xc0 | 17}))
+	})
+
+	It("encodes long strings", func() {
+		value := string(bytes.Repeat([]byte{0xff}, 1000)) // doesn't compress with Huffman
+		Expect(encoder.WriteField(HeaderField{Name: "foo", Value: value})).To(Succeed())
+		fields, err := NewDecoder().DecodeFull(output.Bytes())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(fields).To(Equal([]HeaderField{{Name: "foo", Value: value}}))
+	})
+})
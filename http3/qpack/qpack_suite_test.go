@@ -0,0 +1,13 @@
+package qpack
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestQpack(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "QPACK Suite")
+}
@@ -0,0 +1,24 @@
+package qpack
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Static Table", func() {
+	It("has 99 entries", func() {
+		Expect(staticTable).To(HaveLen(99))
+		Expect(staticTable[0]).To(Equal(HeaderField{Name: ":authority"}))
+		Expect(staticTable[98]).To(Equal(HeaderField{Name: "x-frame-options", Value: "sameorigin"}))
+	})
+
+	It("looks up header fields", func() {
+		Expect(staticTableFields[HeaderField{Name: ":method", Value: "GET"}]).To(BeEquivalentTo(17))
+		Expect(staticTableFields[HeaderField{Name: ":status", Value: "500"}]).To(BeEquivalentTo(71))
+	})
+
+	It("looks up the first entry with a name", func() {
+		Expect(staticTableNames[":status"]).To(BeEquivalentTo(24))
+		Expect(staticTableNames["user-agent"]).To(BeEquivalentTo(95))
+	})
+})
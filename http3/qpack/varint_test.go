@@ -0,0 +1,40 @@
+package qpack
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Prefixed integers", func() {
+	// examples from RFC 7541, appendix C.1
+	It("encodes 10 using a 5-bit prefix", func() {
+		Expect(appendVarInt(nil, 5, 10)).To(Equal([]byte{0xa}))
+	})
+
+	It("encodes 1337 using a 5-bit prefix", func() {
+		Expect(appendVarInt(nil, 5, 1337)).To(Equal([]byte{0x1f, 0x9a, 0xa}))
+	})
+
+	It("encodes 42 using an 8-bit prefix", func() {
+		Expect(appendVarInt(nil, 8, 42)).To(Equal([]byte{0x2a}))
+	})
+
+	It("decodes integers, ignoring the bits before the prefix", func() {
+		i, remain, err := readVarInt(5, []byte{0xff, 0x9a, 0xa, 0x42})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(i).To(BeEquivalentTo(1337))
+		Expect(remain).To(Equal([]byte{0x42}))
+	})
+
+	It("errors on incomplete integers", func() {
+		_, _, err := readVarInt(5, []byte{0x1f, 0x9a})
+		Expect(err).To(MatchError(errNeedMore))
+		_, _, err = readVarInt(5, nil)
+		Expect(err).To(MatchError(errNeedMore))
+	})
+
+	It("errors on overflows", func() {
+		_, _, err := readVarInt(5, []byte{0x1f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x1})
+		Expect(err).To(MatchError(errVarintOverflow))
+	})
+})
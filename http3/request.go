@@ -0,0 +1,122 @@
+package http3
+
+import (
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/http/httpguts"
+
+	"github.com/wheelcomplex/qk/http3/qpack"
+)
+
+func requestFromHeaders(headers []qpack.HeaderField) (*http.Request, error) {
+	var path, authority, method, scheme, contentLengthStr string
+	httpHeaders := http.Header{}
+
+	var readFirstRegularHeader bool
+	for _, h := range headers {
+		if h.IsPseudo() {
+			// pseudo header fields must appear before all regular fields, see RFC 9114, section 4.3
+			if readFirstRegularHeader {
+				return nil, errors.New("pseudo header field after a regular header field")
+			}
+			switch h.Name {
+			case ":path":
+				path = h.Value
+			case ":method":
+				method = h.Value
+			case ":authority":
+				authority = h.Value
+			case ":scheme":
+				scheme = h.Value
+			default:
+				return nil, errors.New("unknown pseudo header field: " + h.Name)
+			}
+			continue
+		}
+		readFirstRegularHeader = true
+		if h.Name == "content-length" {
+			contentLengthStr = h.Value
+			continue
+		}
+		httpHeaders.Add(h.Name, h.Value)
+	}
+
+	var trailer http.Header
+	for _, v := range httpHeaders["Trailer"] {
+		foreachHeaderElement(v, func(key string) {
+			key = http.CanonicalHeaderKey(key)
+			if !httpguts.ValidTrailerHeader(key) {
+				return
+			}
+			if trailer == nil {
+				trailer = make(http.Header)
+			}
+			trailer[key] = nil
+		})
+	}
+	delete(httpHeaders, "Trailer")
+
+	// concatenate cookie headers, see https://tools.ietf.org/html/rfc6265#section-5.4
+	if len(httpHeaders["Cookie"]) > 0 {
+		httpHeaders.Set("Cookie", strings.Join(httpHeaders["Cookie"], "; "))
+	}
+
+	var u *url.URL
+	requestURI := path
+	if method == http.MethodConnect {
+		// CONNECT requests only carry the authority of the target, see RFC 9114, section 4.4
+		if len(authority) == 0 {
+			return nil, errors.New(":authority must not be empty for CONNECT requests")
+		}
+		if len(path) > 0 || len(scheme) > 0 {
+			return nil, errors.New(":path and :scheme must be empty for CONNECT requests")
+		}
+		u = &url.URL{Host: authority}
+		requestURI = authority
+	} else {
+		if len(path) == 0 || len(authority) == 0 || len(method) == 0 || len(scheme) == 0 {
+			return nil, errors.New(":path, :authority, :method and :scheme must not be empty")
+		}
+		var err error
+		u, err = url.ParseRequestURI(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	contentLength := int64(-1)
+	if len(contentLengthStr) > 0 {
+		var err error
+		contentLength, err = strconv.ParseInt(contentLengthStr, 10, 64)
+		if err != nil || contentLength < 0 {
+			return nil, errors.New("invalid content-length: " + contentLengthStr)
+		}
+	}
+
+	return &http.Request{
+		Method:        method,
+		URL:           u,
+		Proto:         "HTTP/3.0",
+		ProtoMajor:    3,
+		ProtoMinor:    0,
+		Header:        httpHeaders,
+		Trailer:       trailer,
+		Body:          nil,
+		ContentLength: contentLength,
+		Host:          authority,
+		RequestURI:    requestURI,
+		TLS:           &tls.ConnectionState{},
+	}, nil
+}
+
+func hostnameFromRequest(req *http.Request) string {
+	if req.URL != nil {
+		return req.URL.Host
+	}
+	return ""
+}
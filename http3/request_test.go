@@ -0,0 +1,205 @@
+package http3
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/wheelcomplex/qk/http3/qpack"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Request", func() {
+	It("populates request", func() {
+		headers := []qpack.HeaderField{
+			{Name: ":path", Value: "/foo"},
+			{Name: ":authority", Value: "quic.clemente.io"},
+			{Name: ":method", Value: "GET"},
+			{Name: ":scheme", Value: "https"},
+			{Name: "content-length", Value: "42"},
+		}
+		req, err := requestFromHeaders(headers)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(req.Method).To(Equal("GET"))
+		Expect(req.URL.Path).To(Equal("/foo"))
+		Expect(req.Proto).To(Equal("HTTP/3.0"))
+		Expect(req.ProtoMajor).To(Equal(3))
+		Expect(req.ProtoMinor).To(Equal(0))
+		Expect(req.ContentLength).To(Equal(int64(42)))
+		Expect(req.Header).To(BeEmpty())
+		Expect(req.Body).To(BeNil())
+		Expect(req.Host).To(Equal("quic.clemente.io"))
+		Expect(req.RequestURI).To(Equal("/foo"))
+		Expect(req.TLS).ToNot(BeNil())
+	})
+
+	It("sets the content length to -1 if it's unknown", func() {
+		headers := []qpack.HeaderField{
+			{Name: ":path", Value: "/foo"},
+			{Name: ":authority", Value: "quic.clemente.io"},
+			{Name: ":method", Value: "POST"},
+			{Name: ":scheme", Value: "https"},
+		}
+		req, err := requestFromHeaders(headers)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(req.ContentLength).To(Equal(int64(-1)))
+	})
+
+	It("errors on an invalid content length", func() {
+		headers := []qpack.HeaderField{
+			{Name: ":path", Value: "/foo"},
+			{Name: ":authority", Value: "quic.clemente.io"},
+			{Name: ":method", Value: "POST"},
+			{Name: ":scheme", Value: "https"},
+			{Name: "content-length", Value: "-1"},
+		}
+		_, err := requestFromHeaders(headers)
+		Expect(err).To(MatchError("invalid content-length: -1"))
+	})
+
+	It("concatenates the cookie headers", func() {
+		headers := []qpack.HeaderField{
+			{Name: ":path", Value: "/foo"},
+			{Name: ":authority", Value: "quic.clemente.io"},
+			{Name: ":method", Value: "GET"},
+			{Name: ":scheme", Value: "https"},
+			{Name: "cookie", Value: "cookie1=foobar1"},
+			{Name: "cookie", Value: "cookie2=foobar2"},
+		}
+		req, err := requestFromHeaders(headers)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(req.Header).To(Equal(http.Header{
+			"Cookie": []string{"cookie1=foobar1; cookie2=foobar2"},
+		}))
+	})
+
+	It("handles other headers", func() {
+		headers := []qpack.HeaderField{
+			{Name: ":path", Value: "/foo"},
+			{Name: ":authority", Value: "quic.clemente.io"},
+			{Name: ":method", Value: "GET"},
+			{Name: ":scheme", Value: "https"},
+			{Name: "cache-control", Value: "max-age=0"},
+			{Name: "duplicate-header", Value: "1"},
+			{Name: "duplicate-header", Value: "2"},
+		}
+		req, err := requestFromHeaders(headers)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(req.Header).To(Equal(http.Header{
+			"Cache-Control":    []string{"max-age=0"},
+			"Duplicate-Header": []string{"1", "2"},
+		}))
+	})
+
+	It("populates the declared trailers", func() {
+		headers := []qpack.HeaderField{
+			{Name: ":path", Value: "/foo"},
+			{Name: ":authority", Value: "quic.clemente.io"},
+			{Name: ":method", Value: "POST"},
+			{Name: ":scheme", Value: "https"},
+			{Name: "trailer", Value: "grpc-status, grpc-message"},
+			{Name: "trailer", Value: "content-length"},
+		}
+		req, err := requestFromHeaders(headers)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(req.Header).To(BeEmpty())
+		Expect(req.Trailer).To(Equal(http.Header{
+			"Grpc-Status":  nil,
+			"Grpc-Message": nil,
+		}))
+	})
+
+	It("populates CONNECT requests", func() {
+		headers := []qpack.HeaderField{
+			{Name: ":authority", Value: "example.com:22"},
+			{Name: ":method", Value: "CONNECT"},
+		}
+		req, err := requestFromHeaders(headers)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(req.Method).To(Equal("CONNECT"))
+		Expect(req.URL).To(Equal(&url.URL{Host: "example.com:22"}))
+		Expect(req.Host).To(Equal("example.com:22"))
+		Expect(req.RequestURI).To(Equal("example.com:22"))
+		Expect(req.Proto).To(Equal("HTTP/3.0"))
+	})
+
+	It("errors with a path in CONNECT requests", func() {
+		headers := []qpack.HeaderField{
+			{Name: ":authority", Value: "example.com:22"},
+			{Name: ":method", Value: "CONNECT"},
+			{Name: ":path", Value: "/foo"},
+		}
+		_, err := requestFromHeaders(headers)
+		Expect(err).To(MatchError(":path and :scheme must be empty for CONNECT requests"))
+	})
+
+	It("errors with missing authority in CONNECT requests", func() {
+		headers := []qpack.HeaderField{{Name: ":method", Value: "CONNECT"}}
+		_, err := requestFromHeaders(headers)
+		Expect(err).To(MatchError(":authority must not be empty for CONNECT requests"))
+	})
+
+	It("errors with missing scheme", func() {
+		headers := []qpack.HeaderField{
+			{Name: ":path", Value: "/foo"},
+			{Name: ":authority", Value: "quic.clemente.io"},
+			{Name: ":method", Value: "GET"},
+		}
+		_, err := requestFromHeaders(headers)
+		Expect(err).To(MatchError(":path, :authority, :method and :scheme must not be empty"))
+	})
+
+	It("errors with missing path", func() {
+		headers := []qpack.HeaderField{
+			{Name: ":authority", Value: "quic.clemente.io"},
+			{Name: ":method", Value: "GET"},
+			{Name: ":scheme", Value: "https"},
+		}
+		_, err := requestFromHeaders(headers)
+		Expect(err).To(MatchError(":path, :authority, :method and :scheme must not be empty"))
+	})
+
+	It("errors on unknown pseudo header fields", func() {
+		headers := []qpack.HeaderField{
+			{Name: ":path", Value: "/foo"},
+			{Name: ":authority", Value: "quic.clemente.io"},
+			{Name: ":method", Value: "GET"},
+			{Name: ":scheme", Value: "https"},
+			{Name: ":protocol", Value: "websocket"},
+		}
+		_, err := requestFromHeaders(headers)
+		Expect(err).To(MatchError("unknown pseudo header field: :protocol"))
+	})
+
+	It("errors on pseudo header fields after regular header fields", func() {
+		headers := []qpack.HeaderField{
+			{Name: ":path", Value: "/foo"},
+			{Name: ":authority", Value: "quic.clemente.io"},
+			{Name: "cache-control", Value: "max-age=0"},
+			{Name: ":method", Value: "GET"},
+			{Name: ":scheme", Value: "https"},
+		}
+		_, err := requestFromHeaders(headers)
+		Expect(err).To(MatchError("pseudo header field after a regular header field"))
+	})
+
+	Context("extracting the hostname from a request", func() {
+		var url *url.URL
+
+		BeforeEach(func() {
+			var err error
+			url, err = url.Parse("https://quic.clemente.io:1337")
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("uses req.URL.Host", func() {
+			req := &http.Request{URL: url}
+			Expect(hostnameFromRequest(req)).To(Equal("quic.clemente.io:1337"))
+		})
+
+		It("returns an empty hostname if nothing is set", func() {
+			Expect(hostnameFromRequest(&http.Request{})).To(BeEmpty())
+		})
+	})
+})
@@ -0,0 +1,188 @@
+package http3
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/http/httpguts"
+
+	"github.com/wheelcomplex/qk/http3/qpack"
+	"github.com/wheelcomplex/qk/internal/utils"
+)
+
+const defaultUserAgent = "quic-go HTTP/3"
+
+// The requestWriter encodes the header fields of requests.
+// Since the Encoder doesn't use the dynamic table, every request is encoded independently.
+type requestWriter struct {
+	logger utils.Logger
+}
+
+func newRequestWriter(logger utils.Logger) *requestWriter {
+	return &requestWriter{logger: logger}
+}
+
+// WriteRequestHeader sends the request header in a HEADERS frame on the request stream
+func (w *requestWriter) WriteRequestHeader(str io.Writer, req *http.Request, requestGzip bool) error {
+	trailers, err := commaSeparatedTrailers(req)
+	if err != nil {
+		return err
+	}
+	fields, err := w.encodeHeaders(req, requestGzip, trailers, actualContentLength(req))
+	if err != nil {
+		return err
+	}
+	return writeHeadersFrame(str, func(enc *qpack.Encoder) {
+		for _, hf := range fields {
+			enc.WriteField(hf)
+		}
+	})
+}
+
+// WriteTrailers sends the request trailers in a HEADERS frame after the body
+func (w *requestWriter) WriteTrailers(str io.Writer, trailer http.Header) error {
+	return writeHeadersFrame(str, func(enc *qpack.Encoder) {
+		encodeTrailers(enc, trailer)
+	})
+}
+
+// the rest of this files is copied from http2.Transport
+func (w *requestWriter) encodeHeaders(req *http.Request, addGzipHeader bool, trailers string, contentLength int64) ([]qpack.HeaderField, error) {
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	host, err := httpguts.PunycodeHostPort(host)
+	if err != nil {
+		return nil, err
+	}
+
+	var path string
+	if req.Method != http.MethodConnect {
+		path = req.URL.RequestURI()
+		if !validPseudoPath(path) {
+			orig := path
+			path = strings.TrimPrefix(path, req.URL.Scheme+"://"+host)
+			if !validPseudoPath(path) {
+				if req.URL.Opaque != "" {
+					return nil, fmt.Errorf("invalid request :path %q from URL.Opaque = %q", orig, req.URL.Opaque)
+				}
+				return nil, fmt.Errorf("invalid request :path %q", orig)
+			}
+		}
+	}
+
+	// Check for any invalid headers and return an error before we write anything to the stream.
+	for k, vv := range req.Header {
+		if !httpguts.ValidHeaderFieldName(k) {
+			return nil, fmt.Errorf("invalid HTTP header name %q", k)
+		}
+		for _, v := range vv {
+			if !httpguts.ValidHeaderFieldValue(v) {
+				return nil, fmt.Errorf("invalid HTTP header value %q for header %q", v, k)
+			}
+		}
+	}
+
+	var fields []qpack.HeaderField
+	writeHeader := func(name, value string) {
+		w.logger.Debugf("http3: Transport encoding header %q = %q", name, value)
+		fields = append(fields, qpack.HeaderField{Name: name, Value: value})
+	}
+
+	// The :path pseudo-header field includes the path and query parts of the
+	// target URI. CONNECT requests only carry the :authority, see RFC 9114, section 4.4.
+	writeHeader(":authority", host)
+	writeHeader(":method", req.Method)
+	if req.Method != http.MethodConnect {
+		writeHeader(":path", path)
+		writeHeader(":scheme", req.URL.Scheme)
+	}
+	if trailers != "" {
+		writeHeader("trailer", trailers)
+	}
+
+	var didUA bool
+	for k, vv := range req.Header {
+		lowKey := strings.ToLower(k)
+		switch lowKey {
+		case "host", "content-length":
+			// Host is :authority, already sent.
+			// Content-Length is automatic, set below.
+			continue
+		case "connection", "proxy-connection", "transfer-encoding", "upgrade", "keep-alive":
+			// Per RFC 9114, section 4.2, don't send connection-specific
+			// fields. We have already checked if any
+			// are error-worthy so just ignore the rest.
+			continue
+		case "user-agent":
+			// Match Go's http1 behavior: at most one
+			// User-Agent. If set to nil or empty string,
+			// then omit it. Otherwise if not mentioned,
+			// include the default (below).
+			didUA = true
+			if len(vv) < 1 {
+				continue
+			}
+			vv = vv[:1]
+			if vv[0] == "" {
+				continue
+			}
+		}
+		for _, v := range vv {
+			writeHeader(lowKey, v)
+		}
+	}
+	if shouldSendReqContentLength(req.Method, contentLength) {
+		writeHeader("content-length", strconv.FormatInt(contentLength, 10))
+	}
+	if addGzipHeader {
+		writeHeader("accept-encoding", "gzip")
+	}
+	if !didUA {
+		writeHeader("user-agent", defaultUserAgent)
+	}
+	return fields, nil
+}
+
+// shouldSendReqContentLength reports whether the Transport should send
+// a "content-length" request header. This logic is basically a copy of the net/http
+// transferWriter.shouldSendContentLength.
+// The contentLength is the corrected contentLength (so 0 means actually 0, not unknown).
+// -1 means unknown.
+func shouldSendReqContentLength(method string, contentLength int64) bool {
+	if contentLength > 0 {
+		return true
+	}
+	if contentLength < 0 {
+		return false
+	}
+	// For zero bodies, whether we send a content-length depends on the method.
+	// It also kinda doesn't matter for HTTP/3 either way, since the end of the stream ends the body.
+	switch method {
+	case "POST", "PUT", "PATCH":
+		return true
+	default:
+		return false
+	}
+}
+
+func validPseudoPath(v string) bool {
+	return (len(v) > 0 && v[0] == '/' && (len(v) == 1 || v[1] != '/')) || v == "*"
+}
+
+// actualContentLength returns a sanitized version of
+// req.ContentLength, where 0 actually means zero (not unknown) and -1
+// means unknown.
+func actualContentLength(req *http.Request) int64 {
+	if req.Body == nil || req.Body == http.NoBody {
+		return 0
+	}
+	if req.ContentLength != 0 {
+		return req.ContentLength
+	}
+	return -1
+}
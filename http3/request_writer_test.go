@@ -0,0 +1,141 @@
+package http3
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+
+	"github.com/wheelcomplex/qk/internal/utils"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Request Writer", func() {
+	var (
+		rw  *requestWriter
+		str *bytes.Buffer
+	)
+
+	BeforeEach(func() {
+		rw = newRequestWriter(utils.DefaultLogger)
+		str = &bytes.Buffer{}
+	})
+
+	It("writes a GET request", func() {
+		req, err := http.NewRequest("GET", "https://quic.clemente.io/index.html?foo=bar", nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(rw.WriteRequestHeader(str, req, false)).To(Succeed())
+		fields := readHeaders(str)
+		Expect(fields).To(HaveKeyWithValue(":authority", []string{"quic.clemente.io"}))
+		Expect(fields).To(HaveKeyWithValue(":method", []string{"GET"}))
+		Expect(fields).To(HaveKeyWithValue(":path", []string{"/index.html?foo=bar"}))
+		Expect(fields).To(HaveKeyWithValue(":scheme", []string{"https"}))
+		Expect(fields).To(HaveKeyWithValue("user-agent", []string{defaultUserAgent}))
+		Expect(fields).ToNot(HaveKey("accept-encoding"))
+		Expect(fields).ToNot(HaveKey("content-length"))
+		Expect(str.Len()).To(BeZero())
+	})
+
+	It("writes a POST request", func() {
+		req, err := http.NewRequest("POST", "https://quic.clemente.io/upload.html", strings.NewReader("foo=bar"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(rw.WriteRequestHeader(str, req, false)).To(Succeed())
+		fields := readHeaders(str)
+		Expect(fields).To(HaveKeyWithValue(":method", []string{"POST"}))
+		Expect(fields).To(HaveKeyWithValue("content-length", []string{"7"}))
+	})
+
+	It("sends a content length of 0 for POST requests without a body", func() {
+		req, err := http.NewRequest("POST", "https://quic.clemente.io/upload.html", nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(rw.WriteRequestHeader(str, req, false)).To(Succeed())
+		Expect(readHeaders(str)).To(HaveKeyWithValue("content-length", []string{"0"}))
+	})
+
+	It("sends the Host header as :authority", func() {
+		req, err := http.NewRequest("GET", "https://quic.clemente.io/", nil)
+		Expect(err).ToNot(HaveOccurred())
+		req.Host = "example.com"
+		Expect(rw.WriteRequestHeader(str, req, false)).To(Succeed())
+		fields := readHeaders(str)
+		Expect(fields).To(HaveKeyWithValue(":authority", []string{"example.com"}))
+		Expect(fields).ToNot(HaveKey("host"))
+	})
+
+	It("sends headers", func() {
+		req, err := http.NewRequest("GET", "https://quic.clemente.io/", nil)
+		Expect(err).ToNot(HaveOccurred())
+		req.Header.Add("X-Foo", "bar")
+		req.Header.Add("X-Foo", "baz")
+		req.Header.Set("User-Agent", "foo")
+		Expect(rw.WriteRequestHeader(str, req, false)).To(Succeed())
+		fields := readHeaders(str)
+		Expect(fields).To(HaveKeyWithValue("x-foo", []string{"bar", "baz"}))
+		Expect(fields).To(HaveKeyWithValue("user-agent", []string{"foo"}))
+	})
+
+	It("doesn't send connection-specific header fields", func() {
+		req, err := http.NewRequest("GET", "https://quic.clemente.io/", nil)
+		Expect(err).ToNot(HaveOccurred())
+		req.Header.Set("Connection", "keep-alive")
+		req.Header.Set("Keep-Alive", "timeout=5")
+		req.Header.Set("Transfer-Encoding", "chunked")
+		Expect(rw.WriteRequestHeader(str, req, false)).To(Succeed())
+		fields := readHeaders(str)
+		Expect(fields).ToNot(HaveKey("connection"))
+		Expect(fields).ToNot(HaveKey("keep-alive"))
+		Expect(fields).ToNot(HaveKey("transfer-encoding"))
+	})
+
+	It("requests gzip", func() {
+		req, err := http.NewRequest("GET", "https://quic.clemente.io/", nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(rw.WriteRequestHeader(str, req, true)).To(Succeed())
+		Expect(readHeaders(str)).To(HaveKeyWithValue("accept-encoding", []string{"gzip"}))
+	})
+
+	It("writes a CONNECT request", func() {
+		req, err := http.NewRequest("CONNECT", "https://example.com:22", nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(rw.WriteRequestHeader(str, req, false)).To(Succeed())
+		fields := readHeaders(str)
+		Expect(fields).To(HaveKeyWithValue(":authority", []string{"example.com:22"}))
+		Expect(fields).To(HaveKeyWithValue(":method", []string{"CONNECT"}))
+		Expect(fields).ToNot(HaveKey(":path"))
+		Expect(fields).ToNot(HaveKey(":scheme"))
+	})
+
+	It("errors on invalid header field names", func() {
+		req, err := http.NewRequest("GET", "https://quic.clemente.io/", nil)
+		Expect(err).ToNot(HaveOccurred())
+		req.Header.Set("X Foo", "bar")
+		Expect(rw.WriteRequestHeader(str, req, false)).To(MatchError(`invalid HTTP header name "X Foo"`))
+		Expect(str.Len()).To(BeZero())
+	})
+
+	Context("trailers", func() {
+		It("declares the trailers", func() {
+			req, err := http.NewRequest("POST", "https://quic.clemente.io/", strings.NewReader("foobar"))
+			Expect(err).ToNot(HaveOccurred())
+			req.Trailer = http.Header{"X-Foo": nil, "X-Bar": nil}
+			Expect(rw.WriteRequestHeader(str, req, false)).To(Succeed())
+			Expect(readHeaders(str)).To(HaveKeyWithValue("trailer", []string{"X-Bar,X-Foo"}))
+		})
+
+		It("errors on invalid trailers", func() {
+			req, err := http.NewRequest("POST", "https://quic.clemente.io/", strings.NewReader("foobar"))
+			Expect(err).ToNot(HaveOccurred())
+			req.Trailer = http.Header{"Content-Length": nil}
+			Expect(rw.WriteRequestHeader(str, req, false)).To(MatchError("invalid Trailer key Content-Length"))
+		})
+
+		It("writes the trailers", func() {
+			Expect(rw.WriteTrailers(str, http.Header{
+				"X-Foo":          {"bar"},
+				"Content-Length": {"42"},
+			})).To(Succeed())
+			Expect(readHeaders(str)).To(Equal(map[string][]string{"x-foo": {"bar"}}))
+		})
+	})
+})
@@ -0,0 +1,146 @@
+package http3
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/http/httpguts"
+
+	quic "github.com/wheelcomplex/qk"
+	"github.com/wheelcomplex/qk/http3/qpack"
+	"github.com/wheelcomplex/qk/internal/utils"
+)
+
+type responseWriter struct {
+	str quic.Stream
+
+	header        http.Header
+	status        int // status code passed to WriteHeader
+	headerWritten bool
+	trailers      []string // the trailers declared in the Trailer header field
+
+	buf bytes.Buffer // used to write the DATA frame headers
+
+	logger utils.Logger
+}
+
+func newResponseWriter(str quic.Stream, logger utils.Logger) *responseWriter {
+	return &responseWriter{
+		header: http.Header{},
+		str:    str,
+		logger: logger,
+	}
+}
+
+func (w *responseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *responseWriter) WriteHeader(status int) {
+	if w.headerWritten {
+		return
+	}
+	w.headerWritten = true
+	w.status = status
+
+	for _, v := range w.header["Trailer"] {
+		foreachHeaderElement(v, w.declareTrailer)
+	}
+
+	w.logger.Infof("Responding with %d", status)
+	err := writeHeadersFrame(w.str, func(enc *qpack.Encoder) {
+		enc.WriteField(qpack.HeaderField{Name: ":status", Value: strconv.Itoa(status)})
+		for k, v := range w.header {
+			if strings.HasPrefix(k, http.TrailerPrefix) {
+				continue
+			}
+			for index := range v {
+				enc.WriteField(qpack.HeaderField{Name: strings.ToLower(k), Value: v[index]})
+			}
+		}
+	})
+	if err != nil {
+		w.logger.Errorf("could not write HTTP/3 header: %s", err.Error())
+	}
+}
+
+func (w *responseWriter) declareTrailer(k string) {
+	k = http.CanonicalHeaderKey(k)
+	if !httpguts.ValidTrailerHeader(k) {
+		w.logger.Debugf("Ignoring invalid trailer %q", k)
+		return
+	}
+	w.trailers = append(w.trailers, k)
+}
+
+// writeTrailers sends the trailers in a HEADERS frame after the body.
+// The trailers are the header fields declared in the Trailer header field,
+// and the header fields whose names are prefixed with http.TrailerPrefix.
+func (w *responseWriter) writeTrailers() {
+	trailer := make(http.Header)
+	for _, k := range w.trailers {
+		if vv, ok := w.header[k]; ok {
+			trailer[k] = vv
+		}
+	}
+	for k, vv := range w.header {
+		if strings.HasPrefix(k, http.TrailerPrefix) {
+			trailer[http.CanonicalHeaderKey(strings.TrimPrefix(k, http.TrailerPrefix))] = vv
+		}
+	}
+	if len(trailer) == 0 {
+		return
+	}
+	err := writeHeadersFrame(w.str, func(enc *qpack.Encoder) {
+		encodeTrailers(enc, trailer)
+	})
+	if err != nil {
+		w.logger.Errorf("could not write HTTP/3 trailers: %s", err.Error())
+	}
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	if !w.headerWritten {
+		w.WriteHeader(200)
+	}
+	if !bodyAllowedForStatus(w.status) {
+		return 0, http.ErrBodyNotAllowed
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	w.buf.Reset()
+	(&dataFrame{Length: uint64(len(p))}).Write(&w.buf)
+	if _, err := w.str.Write(w.buf.Bytes()); err != nil {
+		return 0, err
+	}
+	return w.str.Write(p)
+}
+
+// Flush sends the response header, if it hasn't been sent yet.
+// Data written to the response is not buffered, it is passed to the stream immediately.
+func (w *responseWriter) Flush() {
+	if !w.headerWritten {
+		w.WriteHeader(200)
+	}
+}
+
+// test that we implement http.Flusher
+var _ http.Flusher = &responseWriter{}
+
+// copied from http2/http2.go
+// bodyAllowedForStatus reports whether a given response status code
+// permits a body. See RFC 2616, section 4.4.
+func bodyAllowedForStatus(status int) bool {
+	switch {
+	case status >= 100 && status <= 199:
+		return false
+	case status == 204:
+		return false
+	case status == 304:
+		return false
+	}
+	return true
+}
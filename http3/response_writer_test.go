@@ -0,0 +1,236 @@
+package http3
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"time"
+
+	quic "github.com/wheelcomplex/qk"
+	"github.com/wheelcomplex/qk/http3/qpack"
+	"github.com/wheelcomplex/qk/internal/protocol"
+	"github.com/wheelcomplex/qk/internal/utils"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type mockStream struct {
+	id             protocol.StreamID
+	dataToRead     bytes.Buffer
+	dataWritten    bytes.Buffer
+	canceledRead   bool
+	canceledWrite  bool
+	cancelReadErr  quic.ErrorCode
+	cancelWriteErr quic.ErrorCode
+	closed         bool
+	readDeadline   time.Time
+	writeDeadline  time.Time
+
+	ctx       context.Context
+	ctxCancel context.CancelFunc
+}
+
+var _ quic.Stream = &mockStream{}
+
+func newMockStream(id protocol.StreamID) *mockStream {
+	s := &mockStream{id: id}
+	s.ctx, s.ctxCancel = context.WithCancel(context.Background())
+	return s
+}
+
+func (s *mockStream) Close() error { s.closed = true; s.ctxCancel(); return nil }
+func (s *mockStream) CancelRead(code quic.ErrorCode) error {
+	s.canceledRead = true
+	s.cancelReadErr = code
+	return nil
+}
+func (s *mockStream) CancelWrite(code quic.ErrorCode) error {
+	s.canceledWrite = true
+	s.cancelWriteErr = code
+	s.ctxCancel()
+	return nil
+}
+func (s *mockStream) StreamID() protocol.StreamID        { return s.id }
+func (s *mockStream) Context() context.Context           { return s.ctx }
+func (s *mockStream) SetDeadline(time.Time) error        { panic("not implemented") }
+func (s *mockStream) SetReadDeadline(t time.Time) error  { s.readDeadline = t; return nil }
+func (s *mockStream) SetWriteDeadline(t time.Time) error { s.writeDeadline = t; return nil }
+func (s *mockStream) Read(p []byte) (int, error)         { return s.dataToRead.Read(p) }
+func (s *mockStream) Write(p []byte) (int, error)        { return s.dataWritten.Write(p) }
+
+// readHeaders reads a HEADERS frame from r, and decodes the header fields
+func readHeaders(r io.Reader) map[string][]string {
+	br := &byteReader{Reader: r}
+	f, err := parseNextFrame(br)
+	ExpectWithOffset(1, err).ToNot(HaveOccurred())
+	ExpectWithOffset(1, f).To(BeAssignableToTypeOf(&headersFrame{}))
+	fields, err := readHeadersFrame(br, f.(*headersFrame))
+	ExpectWithOffset(1, err).ToNot(HaveOccurred())
+	headers := make(map[string][]string)
+	for _, hf := range fields {
+		headers[hf.Name] = append(headers[hf.Name], hf.Value)
+	}
+	return headers
+}
+
+// readData reads a DATA frame from r
+func readData(r io.Reader) []byte {
+	br := &byteReader{Reader: r}
+	f, err := parseNextFrame(br)
+	ExpectWithOffset(1, err).ToNot(HaveOccurred())
+	ExpectWithOffset(1, f).To(BeAssignableToTypeOf(&dataFrame{}))
+	data := make([]byte, f.(*dataFrame).Length)
+	_, err = io.ReadFull(br, data)
+	ExpectWithOffset(1, err).ToNot(HaveOccurred())
+	return data
+}
+
+var _ = Describe("Response Writer", func() {
+	var (
+		w   *responseWriter
+		str *mockStream
+	)
+
+	BeforeEach(func() {
+		str = newMockStream(4)
+		w = newResponseWriter(str, utils.DefaultLogger)
+	})
+
+	It("writes status", func() {
+		w.WriteHeader(http.StatusTeapot)
+		fields := readHeaders(&str.dataWritten)
+		Expect(fields).To(HaveLen(1))
+		Expect(fields).To(HaveKeyWithValue(":status", []string{"418"}))
+	})
+
+	It("writes headers", func() {
+		w.Header().Add("content-length", "42")
+		w.Header().Add("X-Foo", "bar")
+		w.Header().Add("X-Foo", "baz")
+		w.WriteHeader(http.StatusTeapot)
+		fields := readHeaders(&str.dataWritten)
+		Expect(fields).To(HaveLen(3))
+		Expect(fields).To(HaveKeyWithValue("content-length", []string{"42"}))
+		Expect(fields).To(HaveKeyWithValue("x-foo", []string{"bar", "baz"}))
+	})
+
+	It("only writes the header once", func() {
+		w.WriteHeader(http.StatusTeapot)
+		w.WriteHeader(http.StatusOK)
+		fields := readHeaders(&str.dataWritten)
+		Expect(fields).To(HaveKeyWithValue(":status", []string{"418"}))
+		Expect(str.dataWritten.Len()).To(BeZero())
+	})
+
+	It("writes data in DATA frames", func() {
+		n, err := w.Write([]byte("foobar"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(n).To(Equal(6))
+		fields := readHeaders(&str.dataWritten)
+		Expect(fields).To(HaveKeyWithValue(":status", []string{"200"}))
+		Expect(readData(&str.dataWritten)).To(Equal([]byte("foobar")))
+	})
+
+	It("doesn't write empty DATA frames", func() {
+		n, err := w.Write(nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(n).To(BeZero())
+		readHeaders(&str.dataWritten)
+		Expect(str.dataWritten.Len()).To(BeZero())
+	})
+
+	It("doesn't allow writes if the status code doesn't allow a body", func() {
+		w.WriteHeader(http.StatusNotModified)
+		n, err := w.Write([]byte("foobar"))
+		Expect(n).To(BeZero())
+		Expect(err).To(MatchError(http.ErrBodyNotAllowed))
+	})
+
+	It("sends the header when flushing", func() {
+		w.Flush()
+		fields := readHeaders(&str.dataWritten)
+		Expect(fields).To(HaveKeyWithValue(":status", []string{"200"}))
+	})
+
+	Context("trailers", func() {
+		It("writes declared trailers", func() {
+			w.Header().Set("Trailer", "X-Sum, Content-Length")
+			w.WriteHeader(http.StatusOK)
+			w.Header().Set("X-Sum", "42")
+			w.Header().Set("Content-Length", "1337")
+			w.writeTrailers()
+			fields := readHeaders(&str.dataWritten)
+			Expect(fields).To(HaveKeyWithValue("trailer", []string{"X-Sum, Content-Length"}))
+			trailers := readHeaders(&str.dataWritten)
+			Expect(trailers).To(Equal(map[string][]string{"x-sum": {"42"}}))
+		})
+
+		It("writes trailers using the TrailerPrefix", func() {
+			w.WriteHeader(http.StatusOK)
+			w.Header().Set(http.TrailerPrefix+"X-Foo", "bar")
+			w.writeTrailers()
+			readHeaders(&str.dataWritten)
+			trailers := readHeaders(&str.dataWritten)
+			Expect(trailers).To(Equal(map[string][]string{"x-foo": {"bar"}}))
+		})
+
+		It("doesn't send the TrailerPrefix header fields in the header", func() {
+			w.Header().Set(http.TrailerPrefix+"X-Foo", "bar")
+			w.WriteHeader(http.StatusOK)
+			fields := readHeaders(&str.dataWritten)
+			Expect(fields).To(HaveLen(1))
+		})
+
+		It("doesn't write a HEADERS frame if there are no trailers", func() {
+			w.WriteHeader(http.StatusOK)
+			w.writeTrailers()
+			readHeaders(&str.dataWritten)
+			Expect(str.dataWritten.Len()).To(BeZero())
+		})
+	})
+})
+
+var _ = Describe("Response", func() {
+	It("parses a response", func() {
+		res, err := responseFromHeaders([]qpack.HeaderField{
+			{Name: ":status", Value: "200"},
+			{Name: "content-length", Value: "42"},
+			{Name: "trailer", Value: "X-Foo, x-bar"},
+			{Name: "x-foo", Value: "foo"},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(res.StatusCode).To(Equal(200))
+		Expect(res.Status).To(Equal("200 OK"))
+		Expect(res.Proto).To(Equal("HTTP/3.0"))
+		Expect(res.ProtoMajor).To(Equal(3))
+		Expect(res.ContentLength).To(BeEquivalentTo(42))
+		Expect(res.Header).To(Equal(http.Header{"Content-Length": {"42"}, "X-Foo": {"foo"}}))
+		Expect(res.Trailer).To(Equal(http.Header{"X-Foo": nil, "X-Bar": nil}))
+	})
+
+	It("sets the content length to -1 if it's unknown", func() {
+		res, err := responseFromHeaders([]qpack.HeaderField{{Name: ":status", Value: "404"}})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(res.ContentLength).To(BeEquivalentTo(-1))
+	})
+
+	It("errors if the status is missing", func() {
+		_, err := responseFromHeaders([]qpack.HeaderField{{Name: "x-foo", Value: "bar"}})
+		Expect(err).To(MatchError("missing status pseudo header"))
+	})
+
+	It("errors on a non-numeric status", func() {
+		_, err := responseFromHeaders([]qpack.HeaderField{{Name: ":status", Value: "foo"}})
+		Expect(err).To(MatchError("malformed non-numeric status pseudo header"))
+	})
+
+	It("errors on unknown pseudo header fields", func() {
+		_, err := responseFromHeaders([]qpack.HeaderField{
+			{Name: ":status", Value: "200"},
+			{Name: ":path", Value: "/"},
+		})
+		Expect(err).To(MatchError("unknown pseudo header field: :path"))
+	})
+})
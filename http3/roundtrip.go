@@ -0,0 +1,245 @@
+package http3
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/http/httpguts"
+
+	quic "github.com/wheelcomplex/qk"
+)
+
+type roundTripCloser interface {
+	http.RoundTripper
+	io.Closer
+}
+
+// maxRetries is the number of times a request is retried on a new connection,
+// if the server closed the connection before processing it
+const maxRetries = 3
+
+// RoundTripper implements the http.RoundTripper interface for HTTP/3.
+// It dials a QUIC connection for every host, using the IETF QUIC versions of the QuicConfig.
+// If no versions are configured, QUIC v1 is used.
+// Once the server sent a GOAWAY frame, new requests are sent on a new connection.
+// Requests that the server rejected without processing them are retried on a new connection.
+type RoundTripper struct {
+	mutex sync.Mutex
+
+	// DisableCompression, if true, prevents the Transport from
+	// requesting compression with an "Accept-Encoding: gzip"
+	// request header when the Request contains no existing
+	// Accept-Encoding value. If the Transport requests gzip on
+	// its own and gets a gzipped response, it's transparently
+	// decoded in the Response.Body. However, if the user
+	// explicitly requested gzip it is not automatically
+	// uncompressed.
+	DisableCompression bool
+
+	// TLSClientConfig specifies the TLS configuration to use with
+	// tls.Client. If nil, the default configuration is used.
+	// The NextProtos are always set to h3.
+	TLSClientConfig *tls.Config
+
+	// QuicConfig is the quic.Config used for dialing new connections.
+	// If nil, reasonable default values will be used.
+	QuicConfig *quic.Config
+
+	// Dial specifies an optional dial function for creating QUIC
+	// connections for requests.
+	// If Dial is nil, quic.DialAddr will be used.
+	Dial func(network, addr string, tlsCfg *tls.Config, cfg *quic.Config) (quic.Session, error)
+
+	// MaxResponseHeaderBytes specifies a limit on how many
+	// response bytes are allowed in the server's response
+	// header. It is sent to the server as SETTINGS_MAX_FIELD_SECTION_SIZE.
+	// Zero means to use a default limit of 10 MB.
+	MaxResponseHeaderBytes int64
+
+	clients map[string]*client
+}
+
+// RoundTripOpt are options for the Transport.RoundTripOpt method.
+type RoundTripOpt struct {
+	// OnlyCachedConn controls whether the RoundTripper may
+	// create a new QUIC connection. If set true and
+	// no cached connection is available, RoundTrip
+	// will return ErrNoCachedConn.
+	OnlyCachedConn bool
+}
+
+var _ roundTripCloser = &RoundTripper{}
+
+// ErrNoCachedConn is returned when RoundTripper.OnlyCachedConn is set
+var ErrNoCachedConn = errors.New("http3: no cached connection was available")
+
+// RoundTripOpt is like RoundTrip, but takes options.
+func (r *RoundTripper) RoundTripOpt(req *http.Request, opt RoundTripOpt) (*http.Response, error) {
+	if req.URL == nil {
+		closeRequestBody(req)
+		return nil, errors.New("http3: nil Request.URL")
+	}
+	if req.URL.Host == "" {
+		closeRequestBody(req)
+		return nil, errors.New("http3: no Host in request URL")
+	}
+	if req.Header == nil {
+		closeRequestBody(req)
+		return nil, errors.New("http3: nil Request.Header")
+	}
+
+	if req.URL.Scheme == "https" {
+		for k, vv := range req.Header {
+			if !httpguts.ValidHeaderFieldName(k) {
+				return nil, fmt.Errorf("http3: invalid http header field name %q", k)
+			}
+			for _, v := range vv {
+				if !httpguts.ValidHeaderFieldValue(v) {
+					return nil, fmt.Errorf("http3: invalid http header field value %q for key %v", v, k)
+				}
+			}
+		}
+	} else {
+		closeRequestBody(req)
+		return nil, fmt.Errorf("http3: unsupported protocol scheme: %s", req.URL.Scheme)
+	}
+
+	if req.Method != "" && !validMethod(req.Method) {
+		closeRequestBody(req)
+		return nil, fmt.Errorf("http3: invalid method %q", req.Method)
+	}
+
+	hostname := authorityAddr("https", hostnameFromRequest(req))
+	for retries := 0; ; retries++ {
+		cl, err := r.getClient(hostname, opt.OnlyCachedConn)
+		if err != nil {
+			closeRequestBody(req)
+			return nil, err
+		}
+		rsp, err := cl.RoundTrip(req)
+		if err == nil {
+			return rsp, nil
+		}
+		if !cl.isUsable() {
+			r.removeClient(hostname, cl)
+		}
+		if (err != errGoAway && err != errRequestRejected) || retries == maxRetries {
+			return nil, err
+		}
+		if err == errRequestRejected {
+			r.removeClient(hostname, cl)
+			// the request body might already have been consumed
+			if req, err = rewindBody(req); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// RoundTrip does a round trip.
+func (r *RoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	return r.RoundTripOpt(req, RoundTripOpt{})
+}
+
+// getClient returns the client for the host.
+// A new client is created if there's no client yet, or if the existing client can't be used any more.
+func (r *RoundTripper) getClient(hostname string, onlyCached bool) (*client, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.clients == nil {
+		r.clients = make(map[string]*client)
+	}
+
+	cl, ok := r.clients[hostname]
+	if !ok || !cl.isUsable() {
+		if onlyCached {
+			return nil, ErrNoCachedConn
+		}
+		cl = newClient(
+			hostname,
+			r.TLSClientConfig,
+			&roundTripperOpts{
+				DisableCompression:     r.DisableCompression,
+				MaxResponseHeaderBytes: r.MaxResponseHeaderBytes,
+			},
+			r.QuicConfig,
+			r.Dial,
+		)
+		r.clients[hostname] = cl
+	}
+	return cl, nil
+}
+
+// removeClient removes the client for the host, if it wasn't replaced yet.
+// The QUIC session is not closed, such that requests that are still running can complete.
+func (r *RoundTripper) removeClient(hostname string, cl *client) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.clients[hostname] == cl {
+		delete(r.clients, hostname)
+	}
+}
+
+// Close closes the QUIC connections that this RoundTripper has used
+func (r *RoundTripper) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, client := range r.clients {
+		if err := client.Close(); err != nil {
+			return err
+		}
+	}
+	r.clients = nil
+	return nil
+}
+
+// rewindBody returns a request with a fresh body, so that the request can be retried
+func rewindBody(req *http.Request) (*http.Request, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return req, nil
+	}
+	if req.GetBody == nil {
+		return nil, errRequestRejected
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	newReq := *req
+	newReq.Body = body
+	return &newReq, nil
+}
+
+func closeRequestBody(req *http.Request) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+}
+
+func validMethod(method string) bool {
+	/*
+				     Method         = "OPTIONS"                ; Section 9.2
+		   		                    | "GET"                    ; Section 9.3
+		   		                    | "HEAD"                   ; Section 9.4
+		   		                    | "POST"                   ; Section 9.5
+		   		                    | "PUT"                    ; Section 9.6
+		   		                    | "DELETE"                 ; Section 9.7
+		   		                    | "TRACE"                  ; Section 9.8
+		   		                    | "CONNECT"                ; Section 9.9
+		   		                    | extension-method
+		   		   extension-method = token
+		   		     token          = 1*<any CHAR except CTLs or separators>
+	*/
+	return len(method) > 0 && strings.IndexFunc(method, isNotToken) == -1
+}
+
+// copied from net/http/http.go
+func isNotToken(r rune) bool {
+	return !httpguts.IsTokenRune(r)
+}
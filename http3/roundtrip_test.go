@@ -0,0 +1,260 @@
+package http3
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	quic "github.com/wheelcomplex/qk"
+	"github.com/wheelcomplex/qk/internal/testdata"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type mockBody struct {
+	io.Reader
+	closed bool
+}
+
+func (m *mockBody) Close() error {
+	m.closed = true
+	return nil
+}
+
+var _ = Describe("RoundTripper", func() {
+	var (
+		rt  *RoundTripper
+		req *http.Request
+	)
+
+	BeforeEach(func() {
+		rt = &RoundTripper{}
+		var err error
+		req, err = http.NewRequest("GET", "https://www.example.org/file1.html", nil)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	Context("validating the request", func() {
+		It("rejects plain HTTP requests", func() {
+			req.URL.Scheme = "http"
+			body := &mockBody{Reader: strings.NewReader("foo")}
+			req.Body = body
+			_, err := rt.RoundTrip(req)
+			Expect(err).To(MatchError("http3: unsupported protocol scheme: http"))
+			Expect(body.closed).To(BeTrue())
+		})
+
+		It("rejects requests without a URL", func() {
+			req.URL = nil
+			_, err := rt.RoundTrip(req)
+			Expect(err).To(MatchError("http3: nil Request.URL"))
+		})
+
+		It("rejects requests without a Host", func() {
+			req.URL.Host = ""
+			_, err := rt.RoundTrip(req)
+			Expect(err).To(MatchError("http3: no Host in request URL"))
+		})
+
+		It("rejects requests without a header", func() {
+			req.Header = nil
+			_, err := rt.RoundTrip(req)
+			Expect(err).To(MatchError("http3: nil Request.Header"))
+		})
+
+		It("rejects requests with invalid header name fields", func() {
+			req.Header.Add("foobär", "value")
+			_, err := rt.RoundTrip(req)
+			Expect(err).To(MatchError(`http3: invalid http header field name "foobär"`))
+		})
+
+		It("rejects requests with invalid methods", func() {
+			req.Method = "foobär"
+			_, err := rt.RoundTrip(req)
+			Expect(err).To(MatchError(`http3: invalid method "foobär"`))
+		})
+	})
+
+	It("only uses cached connections if requested", func() {
+		_, err := rt.RoundTripOpt(req, RoundTripOpt{OnlyCachedConn: true})
+		Expect(err).To(MatchError(ErrNoCachedConn))
+	})
+
+	It("returns the error if dialing fails", func() {
+		testErr := errors.New("dial error")
+		rt.Dial = func(string, string, *tls.Config, *quic.Config) (quic.Session, error) {
+			return nil, testErr
+		}
+		_, err := rt.RoundTrip(req)
+		Expect(err).To(MatchError(testErr))
+		// the next request dials a new connection
+		_, err = rt.RoundTrip(req)
+		Expect(err).To(MatchError(testErr))
+	})
+
+	It("errors if no IETF QUIC version is configured", func() {
+		rt.QuicConfig = &quic.Config{Versions: []quic.VersionNumber{quic.VersionGQUIC43}}
+		_, err := rt.RoundTrip(req)
+		Expect(err).To(MatchError("http3: no IETF QUIC version configured"))
+	})
+
+	Context("rewinding the body", func() {
+		It("doesn't need to rewind requests without a body", func() {
+			r, err := rewindBody(req)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(r).To(Equal(req))
+		})
+
+		It("uses GetBody", func() {
+			req, err := http.NewRequest("POST", "https://www.example.org/", strings.NewReader("foobar"))
+			Expect(err).ToNot(HaveOccurred())
+			ioutil.ReadAll(req.Body)
+			r, err := rewindBody(req)
+			Expect(err).ToNot(HaveOccurred())
+			body, err := ioutil.ReadAll(r.Body)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(body).To(Equal([]byte("foobar")))
+		})
+
+		It("errors if the body can't be rewound", func() {
+			req.Body = ioutil.NopCloser(strings.NewReader("foobar"))
+			_, err := rewindBody(req)
+			Expect(err).To(MatchError(errRequestRejected))
+		})
+	})
+
+	Context("with a server", func() {
+		var (
+			server *Server
+			mux    *http.ServeMux
+			conn   net.PacketConn
+			client *http.Client
+			base   string
+		)
+
+		BeforeEach(func() {
+			mux = http.NewServeMux()
+			server = &Server{Server: &http.Server{Handler: mux, TLSConfig: testdata.GetTLSConfig()}}
+			var err error
+			conn, err = net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+			Expect(err).ToNot(HaveOccurred())
+			go server.Serve(conn)
+			rt.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+			client = &http.Client{Transport: rt}
+			base = fmt.Sprintf("https://localhost:%d", conn.LocalAddr().(*net.UDPAddr).Port)
+		})
+
+		AfterEach(func() {
+			Expect(rt.Close()).To(Succeed())
+			Expect(server.Close()).To(Succeed())
+		})
+
+		It("does a GET request", func() {
+			mux.HandleFunc("/hello", func(w http.ResponseWriter, r *http.Request) {
+				Expect(r.Proto).To(Equal("HTTP/3.0"))
+				w.Header().Set("X-Foo", "bar")
+				w.Write([]byte("hello " + r.Header.Get("X-Name")))
+			})
+			req, err := http.NewRequest("GET", base+"/hello", nil)
+			Expect(err).ToNot(HaveOccurred())
+			req.Header.Set("X-Name", "world")
+			rsp, err := client.Do(req)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(rsp.StatusCode).To(Equal(200))
+			Expect(rsp.Proto).To(Equal("HTTP/3.0"))
+			Expect(rsp.Header.Get("X-Foo")).To(Equal("bar"))
+			Expect(rsp.TLS.NegotiatedProtocol).To(Equal("h3"))
+			body, err := ioutil.ReadAll(rsp.Body)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(body)).To(Equal("hello world"))
+		})
+
+		It("sends the request body and trailers", func() {
+			mux.HandleFunc("/echo", func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Trailer", "X-Sum")
+				n, err := io.Copy(w, r.Body)
+				Expect(err).ToNot(HaveOccurred())
+				w.Header().Set("X-Sum", fmt.Sprintf("%d %s", n, r.Trailer.Get("X-Req")))
+			})
+			data := bytes.Repeat([]byte("foobar"), 20000)
+			req, err := http.NewRequest("POST", base+"/echo", bytes.NewReader(data))
+			Expect(err).ToNot(HaveOccurred())
+			req.Trailer = http.Header{"X-Req": {"foo"}}
+			rsp, err := client.Do(req)
+			Expect(err).ToNot(HaveOccurred())
+			body, err := ioutil.ReadAll(rsp.Body)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(body).To(Equal(data))
+			Expect(rsp.Trailer.Get("X-Sum")).To(Equal(fmt.Sprintf("%d foo", len(data))))
+		})
+
+		It("decompresses gzipped responses", func() {
+			mux.HandleFunc("/gzip", func(w http.ResponseWriter, r *http.Request) {
+				Expect(r.Header.Get("Accept-Encoding")).To(Equal("gzip"))
+				w.Header().Set("Content-Encoding", "gzip")
+				gw := gzip.NewWriter(w)
+				gw.Write([]byte("foobar"))
+				gw.Close()
+			})
+			rsp, err := client.Get(base + "/gzip")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(rsp.Uncompressed).To(BeTrue())
+			body, err := ioutil.ReadAll(rsp.Body)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(body)).To(Equal("foobar"))
+		})
+
+		It("reuses the connection", func() {
+			var remoteAddrs []string
+			mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+				remoteAddrs = append(remoteAddrs, r.RemoteAddr)
+			})
+			for i := 0; i < 2; i++ {
+				rsp, err := client.Get(base + "/")
+				Expect(err).ToNot(HaveOccurred())
+				ioutil.ReadAll(rsp.Body)
+			}
+			Expect(remoteAddrs).To(HaveLen(2))
+			Expect(remoteAddrs[0]).To(Equal(remoteAddrs[1]))
+		})
+
+		It("completes running requests when the server closes gracefully", func() {
+			started := make(chan struct{}, 3)
+			mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+				started <- struct{}{}
+				time.Sleep(100 * time.Millisecond)
+				w.Write([]byte("slow"))
+			})
+			var wg sync.WaitGroup
+			for i := 0; i < 3; i++ {
+				wg.Add(1)
+				go func() {
+					defer GinkgoRecover()
+					defer wg.Done()
+					rsp, err := client.Get(base + "/slow")
+					Expect(err).ToNot(HaveOccurred())
+					body, err := ioutil.ReadAll(rsp.Body)
+					Expect(err).ToNot(HaveOccurred())
+					Expect(string(body)).To(Equal("slow"))
+				}()
+			}
+			for i := 0; i < 3; i++ {
+				Eventually(started).Should(Receive())
+			}
+			start := time.Now()
+			Expect(server.CloseGracefully(5 * time.Second)).To(Succeed())
+			Expect(time.Since(start)).To(BeNumerically("<", 2*time.Second))
+			wg.Wait()
+		})
+	})
+})
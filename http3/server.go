@@ -0,0 +1,493 @@
+package http3
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	quic "github.com/wheelcomplex/qk"
+	"github.com/wheelcomplex/qk/internal/utils"
+)
+
+// allows mocking of quic.Listen and quic.ListenAddr
+var (
+	quicListen     = quic.Listen
+	quicListenAddr = quic.ListenAddr
+)
+
+// nextProtoH3 is the ALPN protocol identifier of HTTP/3, see RFC 9114, section 3.1
+const nextProtoH3 = "h3"
+
+// Server is a HTTP/3 server listening for QUIC connections.
+// It only accepts the IETF QUIC versions of the QuicConfig. If no versions are configured, QUIC v1 is used.
+//
+// The following fields of the http.Server are used:
+// Handler, MaxHeaderBytes (which is sent to the client as SETTINGS_MAX_FIELD_SECTION_SIZE), ErrorLog,
+// and ReadTimeout and WriteTimeout, which set the deadlines of the stream of every request.
+type Server struct {
+	*http.Server
+
+	// By providing a quic.Config, it is possible to set parameters of the QUIC connection.
+	// If nil, it uses reasonable default values.
+	QuicConfig *quic.Config
+
+	port uint32 // used atomically
+
+	mutex    sync.Mutex
+	listener quic.Listener
+	closed   bool
+	closing  bool // set when CloseGracefully is called
+	sessions map[*serverSession]struct{}
+	// sessionsWG and requests are used by CloseGracefully to wait for running sessions and requests
+	sessionsWG sync.WaitGroup
+	requests   sync.WaitGroup
+
+	logger utils.Logger // will be set by Server.serveImpl()
+}
+
+// A serverSession is a session served by the Server
+type serverSession struct {
+	*connection
+
+	mutex          sync.Mutex
+	controlStr     quic.SendStream
+	nextStreamID   uint64 // the ID of the next request stream
+	sentGoAway     bool
+	goAwayStreamID uint64 // the ID sent in the GOAWAY frame
+}
+
+// logf logs errors to the ErrorLog of the http.Server, if set
+func (s *Server) logf(format string, args ...interface{}) {
+	if s.ErrorLog != nil {
+		s.ErrorLog.Printf(format, args...)
+	} else {
+		s.logger.Errorf(format, args...)
+	}
+}
+
+// ListenAndServe listens on the UDP address s.Addr and calls s.Handler to handle HTTP/3 requests on incoming connections.
+func (s *Server) ListenAndServe() error {
+	if s.Server == nil {
+		return errors.New("use of http3.Server without http.Server")
+	}
+	return s.serveImpl(s.TLSConfig, nil)
+}
+
+// ListenAndServeTLS listens on the UDP address s.Addr and calls s.Handler to handle HTTP/3 requests on incoming connections.
+func (s *Server) ListenAndServeTLS(certFile, keyFile string) error {
+	var err error
+	certs := make([]tls.Certificate, 1)
+	certs[0], err = tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+	// We currently only use the cert-related stuff from tls.Config,
+	// so we don't need to make a full copy.
+	config := &tls.Config{
+		Certificates: certs,
+	}
+	return s.serveImpl(config, nil)
+}
+
+// Serve an existing UDP connection.
+func (s *Server) Serve(conn net.PacketConn) error {
+	return s.serveImpl(s.TLSConfig, conn)
+}
+
+func (s *Server) serveImpl(tlsConfig *tls.Config, conn net.PacketConn) error {
+	if s.Server == nil {
+		return errors.New("use of http3.Server without http.Server")
+	}
+	if tlsConfig == nil {
+		return errors.New("http3: no tls.Config")
+	}
+	quicConf, err := quicConfig(s.QuicConfig)
+	if err != nil {
+		return err
+	}
+	s.logger = utils.DefaultLogger.WithPrefix("http3 server")
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return errors.New("Server is already closed")
+	}
+	if s.listener != nil {
+		s.mutex.Unlock()
+		return errors.New("ListenAndServe may only be called once")
+	}
+
+	var ln quic.Listener
+	if conn == nil {
+		ln, err = quicListenAddr(s.Addr, tlsConfigWithALPN(tlsConfig), quicConf)
+	} else {
+		ln, err = quicListen(conn, tlsConfigWithALPN(tlsConfig), quicConf)
+	}
+	if err != nil {
+		s.mutex.Unlock()
+		return err
+	}
+	s.listener = ln
+	s.sessions = make(map[*serverSession]struct{})
+	s.mutex.Unlock()
+
+	for {
+		sess, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go s.handleSession(sess)
+	}
+}
+
+func (s *Server) maxHeaderBytes() uint64 {
+	if s.MaxHeaderBytes <= 0 {
+		return http.DefaultMaxHeaderBytes
+	}
+	return uint64(s.MaxHeaderBytes)
+}
+
+func (s *Server) handleSession(session quic.Session) {
+	if proto := session.ConnectionState().NegotiatedProtocol; proto != nextProtoH3 {
+		s.logger.Debugf("Closing session, client didn't negotiate HTTP/3 (ALPN: %q)", proto)
+		session.CloseWithError(quic.ErrorCode(errorVersionFallback), errors.New("ALPN h3 not negotiated"))
+		return
+	}
+	sess := &serverSession{connection: newConnection(session, true, s.logger)}
+	controlStr, err := sess.openControlStream(map[uint64]uint64{settingMaxFieldSectionSize: s.maxHeaderBytes()})
+	if err != nil {
+		s.logger.Debugf("Opening the control stream failed: %s", err)
+		session.CloseWithError(quic.ErrorCode(errorInternalError), err)
+		return
+	}
+	sess.controlStr = controlStr
+
+	s.mutex.Lock()
+	if s.closing || s.closed {
+		s.mutex.Unlock()
+		session.CloseWithError(quic.ErrorCode(errorNoError), errors.New("server closing"))
+		return
+	}
+	s.sessions[sess] = struct{}{}
+	s.sessionsWG.Add(1)
+	s.mutex.Unlock()
+	defer func() {
+		s.mutex.Lock()
+		delete(s.sessions, sess)
+		s.mutex.Unlock()
+		s.sessionsWG.Done()
+	}()
+
+	go sess.handleUnidirectionalStreams()
+
+	for {
+		str, err := session.AcceptStream()
+		if err != nil {
+			return
+		}
+		id := uint64(str.StreamID())
+		sess.mutex.Lock()
+		if sess.sentGoAway && id >= sess.goAwayStreamID {
+			sess.mutex.Unlock()
+			// the client can retry requests that were rejected, see RFC 9114, section 5.2
+			str.CancelRead(quic.ErrorCode(errorRequestRejected))
+			str.CancelWrite(quic.ErrorCode(errorRequestRejected))
+			continue
+		}
+		if id >= sess.nextStreamID {
+			sess.nextStreamID = id + 4
+		}
+		s.requests.Add(1)
+		sess.mutex.Unlock()
+		go func() {
+			defer s.requests.Done()
+			if err := s.handleRequest(sess, str); err != nil {
+				s.logger.Debugf("Handling request failed: %s", err)
+				if cerr, ok := err.(*connectionError); ok {
+					sess.closeWithError(cerr)
+					return
+				}
+				str.CancelRead(quic.ErrorCode(errorRequestIncomplete))
+				str.CancelWrite(quic.ErrorCode(errorRequestIncomplete))
+			}
+		}()
+	}
+}
+
+// sendGoAway sends a GOAWAY frame. Requests on streams that the client opens afterwards are rejected.
+func (s *serverSession) sendGoAway() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.sentGoAway {
+		return nil
+	}
+	s.sentGoAway = true
+	s.goAwayStreamID = s.nextStreamID
+	buf := &bytes.Buffer{}
+	(&goAwayFrame{StreamID: s.goAwayStreamID}).Write(buf)
+	_, err := s.controlStr.Write(buf.Bytes())
+	return err
+}
+
+// handleRequest reads the request on the stream, and runs the handler.
+// It returns a connectionError if the QUIC session has to be closed,
+// and other errors if the stream should be reset.
+func (s *Server) handleRequest(sess *serverSession, str quic.Stream) error {
+	br := &byteReader{Reader: str}
+	f, err := parseNextFrame(br)
+	if err != nil {
+		return err
+	}
+	hf, ok := f.(*headersFrame)
+	if !ok {
+		return newConnectionError(errorFrameUnexpected, "expected first frame to be a HEADERS frame")
+	}
+	if hf.Length > s.maxHeaderBytes() {
+		// We don't read the request, but we can still send a response, see RFC 9114, section 4.2.2.
+		str.CancelRead(quic.ErrorCode(errorNoError))
+		w := newResponseWriter(str, s.logger)
+		w.WriteHeader(http.StatusRequestHeaderFieldsTooLarge)
+		return str.Close()
+	}
+	fields, err := readHeadersFrame(br, hf)
+	if err != nil {
+		return err
+	}
+	req, err := requestFromHeaders(fields)
+	if err != nil {
+		s.logger.Debugf("Malformed request: %s", err)
+		// a malformed request is a stream error of type H3_MESSAGE_ERROR, see RFC 9114, section 4.1.2
+		str.CancelRead(quic.ErrorCode(errorMessageError))
+		str.CancelWrite(quic.ErrorCode(errorMessageError))
+		return nil
+	}
+	s.logger.Infof("%s %s%s", req.Method, req.Host, req.RequestURI)
+
+	if s.ReadTimeout > 0 {
+		str.SetReadDeadline(time.Now().Add(s.ReadTimeout))
+	}
+	if s.WriteTimeout > 0 {
+		str.SetWriteDeadline(time.Now().Add(s.WriteTimeout))
+	}
+
+	// The request context is canceled when the stream is reset, or when the session is closed.
+	ctx, cancel := context.WithCancel(sess.session.Context())
+	defer cancel()
+	go func(done <-chan struct{}) {
+		select {
+		case <-str.Context().Done():
+			cancel()
+		case <-done:
+		}
+	}(ctx.Done())
+	ctx = context.WithValue(ctx, http.ServerContextKey, s.Server)
+	ctx = context.WithValue(ctx, http.LocalAddrContextKey, sess.session.LocalAddr())
+	req = req.WithContext(ctx)
+	reqBody := newBody(str, sess.session, &req.Trailer, s.maxHeaderBytes())
+	req.Body = reqBody
+	req.RemoteAddr = sess.session.RemoteAddr().String()
+	req.TLS = tlsConnectionState(sess.session.ConnectionState())
+
+	responseWriter := newResponseWriter(str, s.logger)
+	handler := s.Handler
+	if handler == nil {
+		handler = http.DefaultServeMux
+	}
+	panicked := false
+	func() {
+		defer func() {
+			if p := recover(); p != nil {
+				// Copied from net/http/server.go
+				const size = 64 << 10
+				buf := make([]byte, size)
+				buf = buf[:runtime.Stack(buf, false)]
+				s.logf("http: panic serving %v: %v\n%s", req.RemoteAddr, p, buf)
+				panicked = true
+			}
+		}()
+		handler.ServeHTTP(responseWriter, req)
+	}()
+
+	if panicked {
+		if responseWriter.headerWritten {
+			// the response can't be completed any more
+			str.CancelWrite(quic.ErrorCode(errorInternalError))
+			str.CancelRead(quic.ErrorCode(errorNoError))
+			return nil
+		}
+		responseWriter.WriteHeader(http.StatusInternalServerError)
+	} else {
+		responseWriter.WriteHeader(http.StatusOK)
+		responseWriter.writeTrailers()
+	}
+	if !reqBody.requestRead() {
+		// the handler didn't read the request body until the end
+		str.CancelRead(quic.ErrorCode(errorNoError))
+	}
+	return str.Close()
+}
+
+// Close the server immediately, aborting requests and sending CONNECTION_CLOSE frames to connected clients.
+// Close in combination with ListenAndServe() (instead of Serve()) may race if it is called before a UDP socket is established.
+func (s *Server) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.closed = true
+	if s.listener != nil {
+		err := s.listener.Close()
+		s.listener = nil
+		return err
+	}
+	return nil
+}
+
+// CloseGracefully shuts down the server gracefully. The server sends a GOAWAY frame first, then waits for either timeout to trigger,
+// or for all running requests to complete and for the clients to close their sessions.
+// Closing the sessions is left to the clients, since closing a QUIC session discards stream data that wasn't delivered yet.
+// CloseGracefully in combination with ListenAndServe() (instead of Serve()) may race if it is called before a UDP socket is established.
+func (s *Server) CloseGracefully(timeout time.Duration) error {
+	s.mutex.Lock()
+	s.closing = true
+	for sess := range s.sessions {
+		if err := sess.sendGoAway(); err != nil {
+			s.logger.Debugf("Sending GOAWAY failed: %s", err)
+		}
+	}
+	s.mutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.requests.Wait()
+		s.sessionsWG.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+	}
+	return s.Close()
+}
+
+// SetQuicHeaders can be used to set the proper headers that announce that this server supports HTTP/3.
+// The values that are set depend on the port information from s.Server.Addr, and currently look like this (if Addr has port 443):
+//
+//	Alt-Svc: h3=":443"; ma=2592000
+func (s *Server) SetQuicHeaders(hdr http.Header) error {
+	port := atomic.LoadUint32(&s.port)
+
+	if port == 0 {
+		// Extract port from s.Server.Addr
+		_, portStr, err := net.SplitHostPort(s.Server.Addr)
+		if err != nil {
+			return err
+		}
+		portInt, err := net.LookupPort("tcp", portStr)
+		if err != nil {
+			return err
+		}
+		port = uint32(portInt)
+		atomic.StoreUint32(&s.port, port)
+	}
+
+	hdr.Add("Alt-Svc", fmt.Sprintf(`%s=":%d"; ma=2592000`, nextProtoH3, port))
+	return nil
+}
+
+// ListenAndServeQUIC listens on the UDP network address addr and calls the
+// handler for HTTP/3 requests on incoming connections. http.DefaultServeMux is
+// used when handler is nil.
+func ListenAndServeQUIC(addr, certFile, keyFile string, handler http.Handler) error {
+	server := &Server{
+		Server: &http.Server{
+			Addr:    addr,
+			Handler: handler,
+		},
+	}
+	return server.ListenAndServeTLS(certFile, keyFile)
+}
+
+// ListenAndServe listens on the given network address for both, TLS and QUIC
+// connections in parallel. It returns if one of the two returns an error.
+// http.DefaultServeMux is used when handler is nil.
+// The correct Alt-Svc headers for HTTP/3 are set.
+func ListenAndServe(addr, certFile, keyFile string, handler http.Handler) error {
+	// Load certs
+	var err error
+	certs := make([]tls.Certificate, 1)
+	certs[0], err = tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+	// We currently only use the cert-related stuff from tls.Config,
+	// so we don't need to make a full copy.
+	config := &tls.Config{
+		Certificates: certs,
+	}
+
+	// Open the listeners
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return err
+	}
+	udpConn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return err
+	}
+	defer udpConn.Close()
+
+	tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
+	if err != nil {
+		return err
+	}
+	tcpConn, err := net.ListenTCP("tcp", tcpAddr)
+	if err != nil {
+		return err
+	}
+	defer tcpConn.Close()
+
+	tlsConn := tls.NewListener(tcpConn, config)
+	defer tlsConn.Close()
+
+	// Start the servers
+	httpServer := &http.Server{
+		Addr:      addr,
+		TLSConfig: config,
+	}
+
+	quicServer := &Server{
+		Server: httpServer,
+	}
+
+	if handler == nil {
+		handler = http.DefaultServeMux
+	}
+	httpServer.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		quicServer.SetQuicHeaders(w.Header())
+		handler.ServeHTTP(w, r)
+	})
+
+	hErr := make(chan error)
+	qErr := make(chan error)
+	go func() {
+		hErr <- httpServer.Serve(tlsConn)
+	}()
+	go func() {
+		qErr <- quicServer.Serve(udpConn)
+	}()
+
+	select {
+	case err := <-hErr:
+		quicServer.Close()
+		return err
+	case err := <-qErr:
+		// Cannot close the HTTP server or wait for requests to complete properly :/
+		return err
+	}
+}
@@ -0,0 +1,263 @@
+package http3
+
+import (
+	"crypto/tls"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+
+	quic "github.com/wheelcomplex/qk"
+	"github.com/wheelcomplex/qk/http3/qpack"
+	mockquic "github.com/wheelcomplex/qk/internal/mocks/quic"
+	"github.com/wheelcomplex/qk/internal/testdata"
+	"github.com/wheelcomplex/qk/internal/utils"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Server", func() {
+	var (
+		s       *Server
+		session *mockquic.MockSession
+		sess    *serverSession
+		str     *mockStream
+	)
+
+	BeforeEach(func() {
+		s = &Server{
+			Server: &http.Server{TLSConfig: testdata.GetTLSConfig()},
+			logger: utils.DefaultLogger,
+		}
+		session, _ = newMockSession()
+		session.EXPECT().LocalAddr().Return(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 443}).AnyTimes()
+		session.EXPECT().RemoteAddr().Return(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 42}).AnyTimes()
+		session.EXPECT().ConnectionState().AnyTimes()
+		sess = &serverSession{connection: newConnection(session, true, utils.DefaultLogger)}
+		str = newMockStream(4)
+	})
+
+	writeRequest := func(fields ...qpack.HeaderField) {
+		ExpectWithOffset(1, writeHeadersFrame(&str.dataToRead, func(enc *qpack.Encoder) {
+			for _, hf := range fields {
+				enc.WriteField(hf)
+			}
+		})).To(Succeed())
+	}
+
+	writeGetRequest := func(path string) {
+		writeRequest(
+			qpack.HeaderField{Name: ":authority", Value: "quic.clemente.io"},
+			qpack.HeaderField{Name: ":method", Value: "GET"},
+			qpack.HeaderField{Name: ":path", Value: path},
+			qpack.HeaderField{Name: ":scheme", Value: "https"},
+		)
+	}
+
+	Context("handling requests", func() {
+		It("handles a request", func() {
+			var req *http.Request
+			s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				req = r
+				w.Header().Set("X-Foo", "bar")
+				w.Write([]byte("foobar"))
+			})
+			writeGetRequest("/foo")
+			Expect(s.handleRequest(sess, str)).To(Succeed())
+			Expect(req.Method).To(Equal("GET"))
+			Expect(req.URL.Path).To(Equal("/foo"))
+			Expect(req.RemoteAddr).To(Equal("127.0.0.1:42"))
+			Expect(req.Context().Value(http.ServerContextKey)).To(Equal(s.Server))
+			Expect(req.Context().Value(http.LocalAddrContextKey)).To(Equal(session.LocalAddr()))
+			Expect(str.closed).To(BeTrue())
+			fields := readHeaders(&str.dataWritten)
+			Expect(fields).To(HaveKeyWithValue(":status", []string{"200"}))
+			Expect(fields).To(HaveKeyWithValue("x-foo", []string{"bar"}))
+			Expect(readData(&str.dataWritten)).To(Equal([]byte("foobar")))
+		})
+
+		It("passes the request body and the trailers to the handler", func() {
+			var body []byte
+			var trailer http.Header
+			s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var err error
+				body, err = ioutil.ReadAll(r.Body)
+				Expect(err).ToNot(HaveOccurred())
+				trailer = r.Trailer
+			})
+			writeRequest(
+				qpack.HeaderField{Name: ":authority", Value: "quic.clemente.io"},
+				qpack.HeaderField{Name: ":method", Value: "POST"},
+				qpack.HeaderField{Name: ":path", Value: "/"},
+				qpack.HeaderField{Name: ":scheme", Value: "https"},
+				qpack.HeaderField{Name: "trailer", Value: "X-Sum"},
+			)
+			(&dataFrame{Length: 6}).Write(&str.dataToRead)
+			str.dataToRead.Write([]byte("foobar"))
+			writeRequest(qpack.HeaderField{Name: "x-sum", Value: "42"})
+			Expect(s.handleRequest(sess, str)).To(Succeed())
+			Expect(body).To(Equal([]byte("foobar")))
+			Expect(trailer).To(Equal(http.Header{"X-Sum": {"42"}}))
+			Expect(str.canceledRead).To(BeFalse())
+		})
+
+		It("sends the response trailers", func() {
+			s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Trailer", "X-Sum")
+				w.Write([]byte("foo"))
+				w.Header().Set("X-Sum", "42")
+			})
+			writeGetRequest("/")
+			Expect(s.handleRequest(sess, str)).To(Succeed())
+			readHeaders(&str.dataWritten)
+			readData(&str.dataWritten)
+			Expect(readHeaders(&str.dataWritten)).To(Equal(map[string][]string{"x-sum": {"42"}}))
+		})
+
+		It("cancels reading if the handler didn't read the request body", func() {
+			s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+			writeGetRequest("/")
+			(&dataFrame{Length: 6}).Write(&str.dataToRead)
+			str.dataToRead.Write([]byte("foobar"))
+			Expect(s.handleRequest(sess, str)).To(Succeed())
+			Expect(str.canceledRead).To(BeTrue())
+			Expect(str.cancelReadErr).To(BeEquivalentTo(errorNoError))
+		})
+
+		It("sets the stream deadlines", func() {
+			s.ReadTimeout = time.Hour
+			s.WriteTimeout = 2 * time.Hour
+			s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+			writeGetRequest("/")
+			Expect(s.handleRequest(sess, str)).To(Succeed())
+			Expect(str.readDeadline).To(BeTemporally("~", time.Now().Add(time.Hour), time.Minute))
+			Expect(str.writeDeadline).To(BeTemporally("~", time.Now().Add(2*time.Hour), time.Minute))
+		})
+
+		It("responds with 500 if the handler panics", func() {
+			s.ErrorLog = nil
+			s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				panic("foobar")
+			})
+			writeGetRequest("/")
+			Expect(s.handleRequest(sess, str)).To(Succeed())
+			Expect(readHeaders(&str.dataWritten)).To(HaveKeyWithValue(":status", []string{"500"}))
+		})
+
+		It("resets the stream if the handler panics after writing the header", func() {
+			s.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+				panic("foobar")
+			})
+			writeGetRequest("/")
+			Expect(s.handleRequest(sess, str)).To(Succeed())
+			Expect(str.canceledWrite).To(BeTrue())
+			Expect(str.cancelWriteErr).To(BeEquivalentTo(errorInternalError))
+			Expect(str.closed).To(BeFalse())
+		})
+
+		It("resets the stream on malformed requests", func() {
+			writeRequest(qpack.HeaderField{Name: ":method", Value: "GET"})
+			Expect(s.handleRequest(sess, str)).To(Succeed())
+			Expect(str.canceledRead).To(BeTrue())
+			Expect(str.canceledWrite).To(BeTrue())
+			Expect(str.cancelWriteErr).To(BeEquivalentTo(errorMessageError))
+		})
+
+		It("responds with 431 if the request header is too large", func() {
+			s.MaxHeaderBytes = 10
+			writeGetRequest("/")
+			Expect(s.handleRequest(sess, str)).To(Succeed())
+			Expect(readHeaders(&str.dataWritten)).To(HaveKeyWithValue(":status", []string{"431"}))
+			Expect(str.canceledRead).To(BeTrue())
+			Expect(str.closed).To(BeTrue())
+		})
+
+		It("errors if the first frame is not a HEADERS frame", func() {
+			(&dataFrame{Length: 0}).Write(&str.dataToRead)
+			err := s.handleRequest(sess, str)
+			Expect(err).To(MatchError("H3_FRAME_UNEXPECTED: expected first frame to be a HEADERS frame"))
+		})
+
+		It("errors if the field section can't be decoded", func() {
+			(&headersFrame{Length: 2}).Write(&str.dataToRead)
+			str.dataToRead.Write([]byte{0x01, 0x00}) // references the dynamic table
+			err := s.handleRequest(sess, str)
+			Expect(err).To(BeAssignableToTypeOf(&connectionError{}))
+			Expect(err.(*connectionError).code).To(Equal(errorQPACKDecompressionFailed))
+		})
+	})
+
+	It("sends a GOAWAY frame", func() {
+		uniStr := newMockStream(3)
+		session.EXPECT().OpenUniStream().Return(uniStr, nil)
+		controlStr, err := sess.openControlStream(nil)
+		Expect(err).ToNot(HaveOccurred())
+		sess.controlStr = controlStr
+		sess.nextStreamID = 8
+		Expect(sess.sendGoAway()).To(Succeed())
+		Expect(sess.sendGoAway()).To(Succeed()) // only sent once
+		br := &byteReader{Reader: &uniStr.dataWritten}
+		_, err = br.readVarInt(false)
+		Expect(err).ToNot(HaveOccurred())
+		f, err := parseNextFrame(br)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(f).To(BeAssignableToTypeOf(&settingsFrame{}))
+		f, err = parseNextFrame(br)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(f).To(Equal(&goAwayFrame{StreamID: 8}))
+		_, err = parseNextFrame(br)
+		Expect(err).To(Equal(io.EOF))
+	})
+
+	It("errors when serving without a tls.Config", func() {
+		s.TLSConfig = nil
+		Expect(s.ListenAndServe()).To(MatchError("http3: no tls.Config"))
+	})
+
+	It("errors if no IETF QUIC version is configured", func() {
+		s.QuicConfig = &quic.Config{Versions: []quic.VersionNumber{quic.VersionGQUIC43}}
+		Expect(s.ListenAndServe()).To(MatchError("http3: no IETF QUIC version configured"))
+	})
+
+	Context("setting the Alt-Svc header", func() {
+		It("sets the header", func() {
+			s.Addr = "localhost:4433"
+			hdr := http.Header{}
+			Expect(s.SetQuicHeaders(hdr)).To(Succeed())
+			Expect(hdr).To(Equal(http.Header{"Alt-Svc": {`h3=":4433"; ma=2592000`}}))
+		})
+
+		It("errors on an invalid address", func() {
+			s.Addr = "localhost"
+			Expect(s.SetQuicHeaders(http.Header{})).ToNot(Succeed())
+		})
+	})
+})
+
+var _ = Describe("Config", func() {
+	It("uses QUIC v1 by default", func() {
+		conf, err := quicConfig(nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(conf.Versions).To(Equal([]quic.VersionNumber{quic.VersionQUIC1}))
+		Expect(conf.UseCryptoTLS).To(BeTrue())
+	})
+
+	It("only uses the IETF QUIC versions", func() {
+		orig := &quic.Config{Versions: []quic.VersionNumber{quic.VersionGQUIC43, quic.VersionQUIC1}}
+		conf, err := quicConfig(orig)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(conf.Versions).To(Equal([]quic.VersionNumber{quic.VersionQUIC1}))
+		Expect(orig.Versions).To(HaveLen(2))
+	})
+
+	It("sets the ALPN without modifying the original tls.Config", func() {
+		orig := &tls.Config{NextProtos: []string{"foo"}, ServerName: "bar"}
+		conf := tlsConfigWithALPN(orig)
+		Expect(conf.NextProtos).To(Equal([]string{"h3"}))
+		Expect(conf.ServerName).To(Equal("bar"))
+		Expect(orig.NextProtos).To(Equal([]string{"foo"}))
+	})
+})
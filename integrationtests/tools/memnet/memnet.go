@@ -0,0 +1,193 @@
+// Package memnet provides an in-memory network for end-to-end tests, driven by a virtual clock.
+//
+// A Pipe is a pair of connected net.PacketConns, which can be used with quic.Listen and quic.Dial.
+// Packets are delayed and lost as configured, using the time of a utils.VirtualClock.
+// When the sessions use the same clock (using the Clock option of the quic.Config),
+// transfers and timeouts that take seconds can be run in milliseconds, independent of the speed of the machine:
+//
+//	clock := utils.NewVirtualClock(time.Now())
+//	defer clock.AutoAdvance(memnet.DefaultIdleTime)()
+//	clientConn, serverConn := memnet.NewPipe(clock, &memnet.Opts{Delay: 50 * time.Millisecond, Loss: 0.01, Seed: 1})
+//	ln, err := quic.Listen(serverConn, tlsConf, &quic.Config{Clock: clock})
+//	sess, err := quic.Dial(clientConn, serverConn.LocalAddr(), "localhost:4321", tlsConf, &quic.Config{Clock: clock})
+package memnet
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/wheelcomplex/qk/internal/utils"
+)
+
+// DefaultIdleTime is an idle time that can be used with VirtualClock.AutoAdvance.
+// The clock is only advanced when no session used it for this (real) time.
+const DefaultIdleTime = 50 * time.Microsecond
+
+// queueSize is the number of packets that can be queued for reading.
+// Packets arriving while the queue is full are dropped, like packets arriving at a full UDP receive buffer.
+const queueSize = 1024
+
+var (
+	errClosed              = errors.New("memnet: use of closed connection")
+	errDeadlineUnsupported = errors.New("memnet: deadlines are not supported")
+)
+
+// Opts configures a Pipe.
+// The options apply to both directions.
+type Opts struct {
+	// Delay is the one-way delay of every packet.
+	Delay time.Duration
+	// Loss is the probability that a packet is lost, between 0 and 1.
+	Loss float64
+	// DropPacket determines whether a packet gets dropped, in addition to the random loss.
+	// It is called with the number of packets that were sent in the same direction, including this packet, starting at 1.
+	DropPacket func(from net.Addr, packetCount uint64) bool
+	// Seed seeds the random loss, which makes it reproducible.
+	// Both directions use their own random number generator, seeded from Seed.
+	Seed int64
+}
+
+// A PacketConn is one end of a Pipe
+type PacketConn struct {
+	clock *utils.VirtualClock
+	opts  Opts
+
+	localAddr *net.UDPAddr
+	peer      *PacketConn
+
+	mutex       sync.Mutex
+	rand        *rand.Rand
+	packetCount uint64
+
+	packetsSent     uint64
+	packetsLost     uint64
+	packetsReceived uint64
+
+	queue     chan []byte
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+var _ net.PacketConn = &PacketConn{}
+
+// NewPipe creates a pair of connected PacketConns.
+// Packets are only delivered to the address of the other end, packets sent to any other address are discarded.
+func NewPipe(clock *utils.VirtualClock, opts *Opts) (*PacketConn, *PacketConn) {
+	if opts == nil {
+		opts = &Opts{}
+	}
+	a := newPacketConn(clock, opts, &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1234}, opts.Seed)
+	b := newPacketConn(clock, opts, &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 4321}, opts.Seed+1)
+	a.peer = b
+	b.peer = a
+	return a, b
+}
+
+func newPacketConn(clock *utils.VirtualClock, opts *Opts, addr *net.UDPAddr, seed int64) *PacketConn {
+	return &PacketConn{
+		clock:     clock,
+		opts:      *opts,
+		localAddr: addr,
+		rand:      rand.New(rand.NewSource(seed)),
+		queue:     make(chan []byte, queueSize),
+		closed:    make(chan struct{}),
+	}
+}
+
+// ReadFrom reads the next packet sent by the other end.
+func (c *PacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	select {
+	case data := <-c.queue:
+		atomic.AddUint64(&c.packetsReceived, 1)
+		return copy(p, data), c.peer.localAddr, nil
+	case <-c.closed:
+		return 0, nil, errClosed
+	}
+}
+
+// WriteTo sends a packet to the other end.
+// The packet arrives after the configured delay, unless it is lost.
+func (c *PacketConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	select {
+	case <-c.closed:
+		return 0, errClosed
+	default:
+	}
+	if addr.String() != c.peer.localAddr.String() {
+		return len(p), nil
+	}
+	atomic.AddUint64(&c.packetsSent, 1)
+
+	c.mutex.Lock()
+	c.packetCount++
+	lost := (c.opts.Loss > 0 && c.rand.Float64() < c.opts.Loss) ||
+		(c.opts.DropPacket != nil && c.opts.DropPacket(c.localAddr, c.packetCount))
+	c.mutex.Unlock()
+	if lost {
+		atomic.AddUint64(&c.packetsLost, 1)
+		return len(p), nil
+	}
+
+	data := make([]byte, len(p))
+	copy(data, p)
+	if c.opts.Delay > 0 {
+		c.clock.AfterFunc(c.opts.Delay, func() { c.peer.deliver(data) })
+	} else {
+		c.peer.deliver(data)
+	}
+	return len(p), nil
+}
+
+func (c *PacketConn) deliver(data []byte) {
+	select {
+	case <-c.closed:
+		return
+	default:
+	}
+	select {
+	case c.queue <- data:
+	default:
+	}
+}
+
+// Close closes the connection.
+// Packets that are still in flight to this end are discarded.
+func (c *PacketConn) Close() error {
+	c.closeOnce.Do(func() { close(c.closed) })
+	return nil
+}
+
+// LocalAddr returns the address of this end
+func (c *PacketConn) LocalAddr() net.Addr { return c.localAddr }
+
+// SetDeadline is not supported
+func (c *PacketConn) SetDeadline(time.Time) error { return errDeadlineUnsupported }
+
+// SetReadDeadline is not supported
+func (c *PacketConn) SetReadDeadline(time.Time) error { return errDeadlineUnsupported }
+
+// SetWriteDeadline is not supported
+func (c *PacketConn) SetWriteDeadline(time.Time) error { return errDeadlineUnsupported }
+
+// Stats are the statistics of a PacketConn
+type Stats struct {
+	// PacketsSent is the number of packets sent to the other end, including packets that were lost
+	PacketsSent uint64
+	// PacketsLost is the number of packets sent to the other end that were lost
+	PacketsLost uint64
+	// PacketsReceived is the number of packets read from the other end
+	PacketsReceived uint64
+}
+
+// Stats returns the statistics
+func (c *PacketConn) Stats() Stats {
+	return Stats{
+		PacketsSent:     atomic.LoadUint64(&c.packetsSent),
+		PacketsLost:     atomic.LoadUint64(&c.packetsLost),
+		PacketsReceived: atomic.LoadUint64(&c.packetsReceived),
+	}
+}
@@ -0,0 +1,13 @@
+package memnet
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestMemnet(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "In-memory Network")
+}
@@ -0,0 +1,286 @@
+package memnet
+
+import (
+	"bytes"
+	"crypto/tls"
+	"io/ioutil"
+	"net"
+	"sync"
+	"time"
+
+	quic "github.com/wheelcomplex/qk"
+	"github.com/wheelcomplex/qk/internal/testdata"
+	"github.com/wheelcomplex/qk/internal/utils"
+	"github.com/wheelcomplex/qk/qerr"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("In-memory Network", func() {
+	var clock *utils.VirtualClock
+
+	BeforeEach(func() {
+		clock = utils.NewVirtualClock(time.Now())
+	})
+
+	read := func(conn net.PacketConn) ([]byte, net.Addr) {
+		b := make([]byte, 100)
+		n, addr, err := conn.ReadFrom(b)
+		Expect(err).ToNot(HaveOccurred())
+		return b[:n], addr
+	}
+
+	It("sends packets", func() {
+		a, b := NewPipe(clock, nil)
+		_, err := a.WriteTo([]byte("foo"), b.LocalAddr())
+		Expect(err).ToNot(HaveOccurred())
+		data, addr := read(b)
+		Expect(data).To(Equal([]byte("foo")))
+		Expect(addr).To(Equal(a.LocalAddr()))
+		_, err = b.WriteTo([]byte("bar"), addr)
+		Expect(err).ToNot(HaveOccurred())
+		data, addr = read(a)
+		Expect(data).To(Equal([]byte("bar")))
+		Expect(addr).To(Equal(b.LocalAddr()))
+	})
+
+	It("discards packets sent to other addresses", func() {
+		a, b := NewPipe(clock, nil)
+		_, err := a.WriteTo([]byte("foo"), &net.UDPAddr{IP: net.IPv4(1, 2, 3, 4), Port: 1234})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(b.queue).To(BeEmpty())
+	})
+
+	It("delays packets in virtual time", func() {
+		a, b := NewPipe(clock, &Opts{Delay: time.Second})
+		_, err := a.WriteTo([]byte("foo"), b.LocalAddr())
+		Expect(err).ToNot(HaveOccurred())
+		clock.Advance(time.Second - 1)
+		Expect(b.queue).To(BeEmpty())
+		clock.Advance(1)
+		data, _ := read(b)
+		Expect(data).To(Equal([]byte("foo")))
+	})
+
+	It("loses packets reproducibly", func() {
+		lost := func(seed int64) []int {
+			a, b := NewPipe(clock, &Opts{Loss: 0.5, Seed: seed})
+			var lost []int
+			for i := 0; i < 100; i++ {
+				_, err := a.WriteTo([]byte{byte(i)}, b.LocalAddr())
+				Expect(err).ToNot(HaveOccurred())
+				if len(b.queue) == 0 {
+					lost = append(lost, i)
+				} else {
+					read(b)
+				}
+			}
+			Expect(a.Stats().PacketsLost).To(BeEquivalentTo(len(lost)))
+			return lost
+		}
+		lost1 := lost(42)
+		Expect(len(lost1)).To(BeNumerically("~", 50, 20))
+		Expect(lost(42)).To(Equal(lost1))
+		Expect(lost(43)).ToNot(Equal(lost1))
+	})
+
+	It("drops packets", func() {
+		a, b := NewPipe(clock, &Opts{
+			DropPacket: func(from net.Addr, packetCount uint64) bool { return packetCount == 2 },
+		})
+		for i := 1; i <= 3; i++ {
+			_, err := a.WriteTo([]byte{byte(i)}, b.LocalAddr())
+			Expect(err).ToNot(HaveOccurred())
+		}
+		data, _ := read(b)
+		Expect(data).To(Equal([]byte{1}))
+		data, _ = read(b)
+		Expect(data).To(Equal([]byte{3}))
+		Expect(a.Stats()).To(Equal(Stats{PacketsSent: 3, PacketsLost: 1}))
+		Expect(b.Stats()).To(Equal(Stats{PacketsReceived: 2}))
+	})
+
+	It("unblocks ReadFrom when closed", func() {
+		a, _ := NewPipe(clock, nil)
+		done := make(chan struct{})
+		go func() {
+			defer GinkgoRecover()
+			_, _, err := a.ReadFrom(make([]byte, 100))
+			Expect(err).To(MatchError(errClosed))
+			close(done)
+		}()
+		Consistently(done).ShouldNot(BeClosed())
+		Expect(a.Close()).To(Succeed())
+		Eventually(done).Should(BeClosed())
+	})
+
+	Context("running QUIC", func() {
+		const rtt = 200 * time.Millisecond
+
+		var (
+			clientConn, serverConn *PacketConn
+			stopClock              func()
+			config                 *quic.Config
+		)
+
+		BeforeEach(func() {
+			stopClock = clock.AutoAdvance(DefaultIdleTime)
+			config = &quic.Config{Clock: clock}
+		})
+
+		AfterEach(func() {
+			stopClock()
+		})
+
+		// runServer runs a server that sends data on the first stream
+		runServer := func(data []byte) quic.Listener {
+			ln, err := quic.Listen(serverConn, testdata.GetTLSConfig(), config)
+			Expect(err).ToNot(HaveOccurred())
+			go func() {
+				defer GinkgoRecover()
+				sess, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				str, err := sess.OpenStream()
+				Expect(err).ToNot(HaveOccurred())
+				_, err = str.Write(data)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(str.Close()).To(Succeed())
+			}()
+			return ln
+		}
+
+		dial := func() quic.Session {
+			sess, err := quic.Dial(clientConn, serverConn.LocalAddr(), "localhost:4321", &tls.Config{InsecureSkipVerify: true}, config)
+			Expect(err).ToNot(HaveOccurred())
+			return sess
+		}
+
+		for _, v := range []quic.VersionNumber{quic.VersionGQUIC43, quic.VersionQUIC1} {
+			version := v
+
+			Context(version.String(), func() {
+				BeforeEach(func() {
+					config.Versions = []quic.VersionNumber{version}
+					config.UseCryptoTLS = true
+				})
+
+				It("transfers data in virtual time", func() {
+					clientConn, serverConn = NewPipe(clock, &Opts{Delay: rtt / 2, Loss: 0.02, Seed: 1})
+					data := bytes.Repeat([]byte("foobar"), 100000) // 600 KB
+					ln := runServer(data)
+					defer ln.Close()
+
+					start := clock.Now()
+					realStart := time.Now()
+					sess := dial()
+					str, err := sess.AcceptStream()
+					Expect(err).ToNot(HaveOccurred())
+					received, err := ioutil.ReadAll(str)
+					Expect(err).ToNot(HaveOccurred())
+					Expect(received).To(Equal(data))
+					// The transfer takes many RTTs in virtual time, but doesn't have to wait for them in real time.
+					// Slow start alone needs 5 RTTs for 600 KB. The lost packets are retransmitted quickly,
+					// so the transfer mustn't stall for retransmission timeouts.
+					Expect(clock.Now().Sub(start)).To(And(
+						BeNumerically(">", 5*rtt),
+						BeNumerically("<", 20*rtt),
+					))
+					Expect(time.Since(realStart)).To(BeNumerically("<", clock.Now().Sub(start)))
+					Expect(clientConn.Stats().PacketsLost + serverConn.Stats().PacketsLost).ToNot(BeZero())
+					Expect(sess.Close()).To(Succeed())
+				})
+
+				It("times out idle sessions in virtual time", func() {
+					clientConn, serverConn = NewPipe(clock, &Opts{Delay: rtt / 2})
+					config.IdleTimeout = time.Hour
+					ln := runServer([]byte("foobar"))
+					defer ln.Close()
+
+					realStart := time.Now()
+					sess := dial()
+					_, err := sess.AcceptStream()
+					Expect(err).ToNot(HaveOccurred())
+					idleStart := clock.Now()
+					Eventually(sess.Context().Done(), 5*time.Second).Should(BeClosed())
+					Expect(clock.Now().Sub(idleStart)).To(BeNumerically("~", time.Hour, 5*rtt))
+					Expect(time.Since(realStart)).To(BeNumerically("<", 5*time.Second))
+				})
+			})
+		}
+
+		It("replays a recorded QUIC v1 session", func() {
+			config.Versions = []quic.VersionNumber{quic.VersionQUIC1}
+			config.UseCryptoTLS = true
+			clientConn, serverConn = NewPipe(clock, &Opts{Delay: rtt / 2, Loss: 0.02, Seed: 1})
+			data := bytes.Repeat([]byte("foobar"), 10000)
+
+			recording := &lockedBuffer{}
+			serverConfig := *config
+			serverConfig.RecordWriter = recording
+			ln, err := quic.Listen(serverConn, testdata.GetTLSConfig(), &serverConfig)
+			Expect(err).ToNot(HaveOccurred())
+			defer ln.Close()
+			serverSessChan := make(chan quic.Session, 1)
+			go func() {
+				defer GinkgoRecover()
+				sess, err := ln.Accept()
+				Expect(err).ToNot(HaveOccurred())
+				serverSessChan <- sess
+				str, err := sess.OpenStream()
+				Expect(err).ToNot(HaveOccurred())
+				_, err = str.Write(data)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(str.Close()).To(Succeed())
+			}()
+
+			sess := dial()
+			str, err := sess.AcceptStream()
+			Expect(err).ToNot(HaveOccurred())
+			received, err := ioutil.ReadAll(str)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(received).To(Equal(data))
+			Expect(sess.Close()).To(Succeed())
+			var serverSess quic.Session
+			Eventually(serverSessChan).Should(Receive(&serverSess))
+			Eventually(serverSess.Context().Done()).Should(BeClosed())
+
+			replay, err := quic.NewReplay(bytes.NewReader(recording.Bytes()), 0, config)
+			Expect(err).ToNot(HaveOccurred())
+			errChan := make(chan error, 1)
+			go func() { errChan <- replay.Run() }()
+			replayedStr, err := replay.Session().OpenStreamSync()
+			Expect(err).ToNot(HaveOccurred())
+			_, err = replayedStr.Write(data)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(replayedStr.Close()).To(Succeed())
+			var replayErr error
+			Eventually(errChan, 5*time.Second).Should(Receive(&replayErr))
+			// The replayed session derived the same keys, and processed the client's CONNECTION_CLOSE.
+			Expect(replayErr).To(HaveOccurred())
+			Expect(qerr.ToQuicError(replayErr).ErrorCode).To(Equal(qerr.PeerGoingAway))
+			Expect(replay.Sent()).ToNot(BeEmpty())
+			Expect(replay.Sent()[0].Data).To(Equal(replay.RecordedSent()[0].Data))
+		})
+	})
+})
+
+// A lockedBuffer is a bytes.Buffer that can be written to concurrently
+type lockedBuffer struct {
+	mutex sync.Mutex
+	buf   bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) Bytes() []byte {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return append([]byte{}, b.buf.Bytes()...)
+}
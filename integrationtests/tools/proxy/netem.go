@@ -0,0 +1,324 @@
+package quicproxy
+
+import (
+	"container/heap"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// maxReorderHoldTime is the maximum time that a reordered packet is held back,
+// if not enough packets are sent after it.
+const maxReorderHoldTime = 100 * time.Millisecond
+
+// GilbertElliott configures the Gilbert-Elliott loss model.
+// It is a Markov chain with a good and a bad state, which produces bursts of packet loss.
+// Before every packet, the state changes with the probability P (in the good state) or R (in the bad state).
+// The packet is then lost with the loss probability of the current state.
+// All probabilities are between 0 and 1.
+type GilbertElliott struct {
+	// P is the probability of a transition from the good to the bad state
+	P float64
+	// R is the probability of a transition from the bad to the good state
+	R float64
+	// LossGood is the loss probability in the good state
+	LossGood float64
+	// LossBad is the loss probability in the bad state
+	LossBad float64
+}
+
+// LinkConfig configures the emulation of a network link in one direction.
+// The stages are applied to every packet in the order of the fields:
+// a packet that isn't lost might be corrupted and duplicated,
+// and every copy then passes the bandwidth limit, the delay and the reordering.
+// All probabilities are between 0 and 1.
+type LinkConfig struct {
+	// Loss is the probability that a packet is lost
+	Loss float64
+	// GilbertElliott configures burst loss, in addition to Loss
+	GilbertElliott *GilbertElliott
+	// Corrupt is the probability that a single random bit of a packet is flipped
+	Corrupt float64
+	// Duplicate is the probability that a packet is sent twice
+	Duplicate float64
+
+	// Bandwidth is the bandwidth of the link in bytes per second, enforced by a token bucket.
+	// If zero, the bandwidth is not limited.
+	Bandwidth int64
+	// Burst is the size of the token bucket in bytes, i.e. the amount of data that can be sent at once on an idle link.
+	// If zero, a burst of a single packet is allowed.
+	Burst int
+	// QueueSize is the number of bytes that can be queued while waiting for the token bucket.
+	// Packets that don't fit into the queue are dropped.
+	// If zero, the queue is not limited.
+	QueueSize int
+
+	// Delay is the delay of every packet, in addition to the delay returned by the DelayPacket callback
+	Delay time.Duration
+	// Jitter is the maximum random variation of the delay.
+	// The delay is uniformly distributed between Delay-Jitter and Delay+Jitter, so packets might be reordered.
+	Jitter time.Duration
+
+	// Reorder is the probability that a packet is held back, and sent after the next ReorderDepth packets.
+	// If fewer packets are sent within 100ms, the packet is released anyway.
+	Reorder float64
+	// ReorderDepth is the number of packets that overtake a reordered packet.
+	// If zero, a depth of 1 is used.
+	ReorderDepth int
+}
+
+// A link emulates a network link in one direction.
+// Packets are sent in the order of their delivery times, by a single goroutine.
+type link struct {
+	conf LinkConfig
+	send func([]byte)
+
+	mutex     sync.Mutex
+	rand      *rand.Rand
+	badState  bool      // the state of the Gilbert-Elliott model
+	tat       time.Time // the theoretical arrival time of the token bucket
+	held      []*heldPacket
+	queue     packetQueue
+	seq       uint64
+	wakeup    chan struct{}
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// A heldPacket is a packet that was selected for reordering
+type heldPacket struct {
+	data         []byte
+	deliveryTime time.Time // the delivery time if the packet hadn't been held back
+	remaining    int       // the number of packets that still have to overtake it
+	released     bool
+}
+
+func newLink(conf *LinkConfig, seed int64, send func([]byte)) *link {
+	l := &link{
+		conf:   *conf,
+		send:   send,
+		rand:   rand.New(rand.NewSource(seed)),
+		wakeup: make(chan struct{}, 1),
+		closed: make(chan struct{}),
+	}
+	go l.run()
+	return l
+}
+
+// Send passes a packet through the link.
+// The delay is added to the delay of the link.
+func (l *link) Send(data []byte, delay time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.isLost() {
+		return
+	}
+	if l.conf.Corrupt > 0 && l.rand.Float64() < l.conf.Corrupt {
+		corrupted := make([]byte, len(data))
+		copy(corrupted, data)
+		if len(corrupted) > 0 {
+			bit := l.rand.Intn(8 * len(corrupted))
+			corrupted[bit/8] ^= 1 << uint(bit%8)
+		}
+		data = corrupted
+	}
+	copies := 1
+	if l.conf.Duplicate > 0 && l.rand.Float64() < l.conf.Duplicate {
+		copies = 2
+	}
+	for i := 0; i < copies; i++ {
+		l.schedule(data, delay)
+	}
+}
+
+// isLost decides if a packet is lost, and advances the Gilbert-Elliott model
+func (l *link) isLost() bool {
+	if ge := l.conf.GilbertElliott; ge != nil {
+		if l.badState {
+			if l.rand.Float64() < ge.R {
+				l.badState = false
+			}
+		} else if l.rand.Float64() < ge.P {
+			l.badState = true
+		}
+		lossProb := ge.LossGood
+		if l.badState {
+			lossProb = ge.LossBad
+		}
+		if lossProb > 0 && l.rand.Float64() < lossProb {
+			return true
+		}
+	}
+	return l.conf.Loss > 0 && l.rand.Float64() < l.conf.Loss
+}
+
+// schedule passes a packet through the token bucket, applies the delay and the reordering,
+// and queues it for sending.
+func (l *link) schedule(data []byte, delay time.Duration) {
+	now := time.Now()
+	departure := now
+	if l.conf.Bandwidth > 0 {
+		// The token bucket is implemented as a virtual scheduling algorithm (GCRA).
+		// tat is the time at which the bucket will be full again.
+		interval := time.Duration(float64(len(data)) / float64(l.conf.Bandwidth) * float64(time.Second))
+		burst := l.conf.Burst
+		if burst < len(data) {
+			burst = len(data)
+		}
+		tolerance := time.Duration(float64(burst) / float64(l.conf.Bandwidth) * float64(time.Second))
+		tat := l.tat
+		if tat.Before(now) {
+			tat = now
+		}
+		tat = tat.Add(interval)
+		if d := tat.Add(-tolerance); d.After(now) {
+			departure = d
+		}
+		if l.conf.QueueSize > 0 {
+			queued := float64(departure.Sub(now)) / float64(time.Second) * float64(l.conf.Bandwidth)
+			if int(queued) > l.conf.QueueSize {
+				return
+			}
+		}
+		l.tat = tat
+	}
+
+	delay += l.conf.Delay
+	if l.conf.Jitter > 0 {
+		delay += time.Duration(l.rand.Int63n(int64(2*l.conf.Jitter)+1)) - l.conf.Jitter
+	}
+	if delay < 0 {
+		delay = 0
+	}
+	deliveryTime := departure.Add(delay)
+
+	if l.conf.Reorder > 0 && l.rand.Float64() < l.conf.Reorder {
+		depth := l.conf.ReorderDepth
+		if depth <= 0 {
+			depth = 1
+		}
+		hp := &heldPacket{data: data, deliveryTime: deliveryTime, remaining: depth}
+		l.held = append(l.held, hp)
+		time.AfterFunc(deliveryTime.Add(maxReorderHoldTime).Sub(now), func() { l.releaseHeld(hp) })
+		return
+	}
+	l.enqueue(data, deliveryTime)
+
+	// The packet overtook all held packets.
+	held := l.held[:0]
+	for _, hp := range l.held {
+		hp.remaining--
+		if hp.remaining > 0 {
+			held = append(held, hp)
+			continue
+		}
+		hp.released = true
+		if deliveryTime.After(hp.deliveryTime) {
+			hp.deliveryTime = deliveryTime
+		}
+		l.enqueue(hp.data, hp.deliveryTime)
+	}
+	l.held = held
+}
+
+// releaseHeld queues a held packet for sending, if it wasn't released yet
+func (l *link) releaseHeld(hp *heldPacket) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if hp.released {
+		return
+	}
+	hp.released = true
+	for i, p := range l.held {
+		if p == hp {
+			l.held = append(l.held[:i], l.held[i+1:]...)
+			break
+		}
+	}
+	l.enqueue(hp.data, time.Now())
+}
+
+func (l *link) enqueue(data []byte, deliveryTime time.Time) {
+	l.seq++
+	heap.Push(&l.queue, &queuedPacket{data: data, deliveryTime: deliveryTime, seq: l.seq})
+	select {
+	case l.wakeup <- struct{}{}:
+	default:
+	}
+}
+
+func (l *link) run() {
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+	for {
+		l.mutex.Lock()
+		var due [][]byte
+		now := time.Now()
+		for len(l.queue) > 0 && !l.queue[0].deliveryTime.After(now) {
+			due = append(due, heap.Pop(&l.queue).(*queuedPacket).data)
+		}
+		next := time.Hour
+		if len(l.queue) > 0 {
+			next = l.queue[0].deliveryTime.Sub(now)
+		}
+		l.mutex.Unlock()
+
+		for _, data := range due {
+			l.send(data)
+		}
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(next)
+		select {
+		case <-timer.C:
+		case <-l.wakeup:
+		case <-l.closed:
+			return
+		}
+	}
+}
+
+// Close stops sending packets.
+// Packets that are still queued are discarded.
+func (l *link) Close() {
+	l.closeOnce.Do(func() { close(l.closed) })
+}
+
+// A queuedPacket is a packet that is waiting for its delivery time
+type queuedPacket struct {
+	data         []byte
+	deliveryTime time.Time
+	seq          uint64 // packets with the same delivery time are sent in the order they were queued
+}
+
+// packetQueue is a priority queue of packets, ordered by their delivery time
+type packetQueue []*queuedPacket
+
+var _ heap.Interface = &packetQueue{}
+
+func (q packetQueue) Len() int { return len(q) }
+
+func (q packetQueue) Less(i, j int) bool {
+	if q[i].deliveryTime.Equal(q[j].deliveryTime) {
+		return q[i].seq < q[j].seq
+	}
+	return q[i].deliveryTime.Before(q[j].deliveryTime)
+}
+
+func (q packetQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *packetQueue) Push(x interface{}) { *q = append(*q, x.(*queuedPacket)) }
+
+func (q *packetQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	p := old[n-1]
+	old[n-1] = nil
+	*q = old[:n-1]
+	return p
+}
@@ -0,0 +1,241 @@
+package quicproxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"time"
+
+	"github.com/wheelcomplex/qk/internal/protocol"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Network emulation", func() {
+	var (
+		received chan []byte
+		l        *link
+	)
+
+	startLink := func(conf *LinkConfig, seed int64) {
+		l = newLink(conf, seed, func(data []byte) { received <- data })
+	}
+
+	packet := func(i int) []byte {
+		b := make([]byte, 100)
+		binary.BigEndian.PutUint32(b, uint32(i))
+		return b
+	}
+
+	// sendPackets sends n packets through the link, and returns the numbers of the packets that were received
+	sendPackets := func(n int) []int {
+		for i := 0; i < n; i++ {
+			l.Send(packet(i), 0)
+		}
+		var nums []int
+		for {
+			select {
+			case data := <-received:
+				nums = append(nums, int(binary.BigEndian.Uint32(data)))
+			case <-time.After(2 * maxReorderHoldTime):
+				return nums
+			}
+		}
+	}
+
+	BeforeEach(func() {
+		received = make(chan []byte, 10000)
+	})
+
+	AfterEach(func() {
+		l.Close()
+	})
+
+	It("forwards packets", func() {
+		startLink(&LinkConfig{}, 1)
+		Expect(sendPackets(3)).To(Equal([]int{0, 1, 2}))
+	})
+
+	It("loses packets", func() {
+		startLink(&LinkConfig{Loss: 0.3}, 1)
+		nums := sendPackets(1000)
+		Expect(len(nums)).To(BeNumerically("~", 700, 50))
+	})
+
+	It("makes reproducible decisions for the same seed", func() {
+		startLink(&LinkConfig{Loss: 0.5}, 42)
+		nums1 := sendPackets(100)
+		l.Close()
+		startLink(&LinkConfig{Loss: 0.5}, 42)
+		nums2 := sendPackets(100)
+		l.Close()
+		startLink(&LinkConfig{Loss: 0.5}, 43)
+		nums3 := sendPackets(100)
+		Expect(nums1).To(Equal(nums2))
+		Expect(nums1).ToNot(Equal(nums3))
+	})
+
+	It("loses packets in bursts, using the Gilbert-Elliott model", func() {
+		startLink(&LinkConfig{GilbertElliott: &GilbertElliott{P: 0.05, R: 0.25, LossBad: 1}}, 1)
+		const num = 5000
+		nums := sendPackets(num)
+		// In the steady state, the model is in the bad state with a probability of P/(P+R).
+		Expect(float64(num-len(nums)) / num).To(BeNumerically("~", 0.05/0.3, 0.04))
+		// The average length of a burst is 1/R.
+		var bursts, lost int
+		next := 0
+		for _, n := range nums {
+			if n > next {
+				bursts++
+				lost += n - next
+			}
+			next = n + 1
+		}
+		Expect(float64(lost) / float64(bursts)).To(BeNumerically("~", 4, 1))
+	})
+
+	It("corrupts packets", func() {
+		startLink(&LinkConfig{Corrupt: 1}, 1)
+		p := packet(0)
+		orig := make([]byte, len(p))
+		copy(orig, p)
+		l.Send(p, 0)
+		var data []byte
+		Eventually(received).Should(Receive(&data))
+		Expect(p).To(Equal(orig))
+		var flipped int
+		for i := range data {
+			for x := data[i] ^ orig[i]; x != 0; x &= x - 1 {
+				flipped++
+			}
+		}
+		Expect(flipped).To(Equal(1))
+	})
+
+	It("duplicates packets", func() {
+		startLink(&LinkConfig{Duplicate: 1}, 1)
+		Expect(sendPackets(3)).To(Equal([]int{0, 0, 1, 1, 2, 2}))
+	})
+
+	It("reorders packets", func() {
+		startLink(&LinkConfig{Reorder: 0.2, ReorderDepth: 3}, 1)
+		nums := sendPackets(100)
+		Expect(nums).To(HaveLen(100))
+		Expect(nums).To(ConsistOf(sequence(100)))
+		var reordered int
+		for i := 1; i < len(nums); i++ {
+			if nums[i] < nums[i-1] {
+				reordered++
+			}
+		}
+		Expect(reordered).ToNot(BeZero())
+	})
+
+	It("sends a reordered packet after the packets that overtake it", func() {
+		startLink(&LinkConfig{Reorder: 1, ReorderDepth: 2}, 1)
+		l.Send(packet(0), 0)
+		l.mutex.Lock()
+		l.conf.Reorder = 0
+		l.mutex.Unlock()
+		Consistently(received, maxReorderHoldTime/2).ShouldNot(Receive())
+		l.Send(packet(1), 0)
+		l.Send(packet(2), 0)
+		l.Send(packet(3), 0)
+		Expect(sendPackets(0)).To(Equal([]int{1, 2, 0, 3}))
+	})
+
+	It("delays packets", func() {
+		startLink(&LinkConfig{Delay: 100 * time.Millisecond}, 1)
+		start := time.Now()
+		l.Send(packet(0), 50*time.Millisecond)
+		Eventually(received).Should(Receive())
+		Expect(time.Since(start)).To(BeNumerically(">=", 150*time.Millisecond))
+	})
+
+	It("adds jitter to the delay", func() {
+		startLink(&LinkConfig{Delay: 50 * time.Millisecond, Jitter: 40 * time.Millisecond}, 1)
+		start := time.Now()
+		for i := 0; i < 20; i++ {
+			l.Send(packet(i), 0)
+		}
+		for i := 0; i < 20; i++ {
+			Eventually(received).Should(Receive())
+			Expect(time.Since(start)).To(BeNumerically(">=", 10*time.Millisecond))
+		}
+		Expect(time.Since(start)).To(BeNumerically("<", 150*time.Millisecond))
+	})
+
+	It("limits the bandwidth", func() {
+		// 10 packets of 100 bytes at 10 kB/s take 100ms.
+		startLink(&LinkConfig{Bandwidth: 10000}, 1)
+		start := time.Now()
+		Expect(sendPackets(10)).To(HaveLen(10))
+		Expect(time.Since(start) - 2*maxReorderHoldTime).To(BeNumerically("~", 90*time.Millisecond, 30*time.Millisecond))
+	})
+
+	It("allows bursts", func() {
+		// After a burst of 5 packets, 10 packets per second can be sent.
+		startLink(&LinkConfig{Bandwidth: 1000, Burst: 500}, 1)
+		for i := 0; i < 10; i++ {
+			l.Send(packet(i), 0)
+		}
+		Eventually(func() int { return len(received) }).Should(Equal(5))
+		Consistently(func() int { return len(received) }, 50*time.Millisecond).Should(Equal(5))
+		Eventually(func() int { return len(received) }, time.Second).Should(Equal(10))
+	})
+
+	It("drops packets when the queue is full", func() {
+		startLink(&LinkConfig{Bandwidth: 10000, QueueSize: 300}, 1)
+		Expect(sendPackets(10)).To(Equal([]int{0, 1, 2, 3}))
+	})
+
+	It("emulates the network in a QuicProxy", func() {
+		serverConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		Expect(err).ToNot(HaveOccurred())
+		defer serverConn.Close()
+		proxy, err := NewQuicProxy("localhost:0", protocol.VersionWhatever, &Opts{
+			RemoteAddr: serverConn.LocalAddr().String(),
+			Incoming:   &LinkConfig{Duplicate: 1},
+			Outgoing:   &LinkConfig{Delay: 100 * time.Millisecond},
+			Seed:       1,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		defer proxy.Close()
+		// set l, so that the AfterEach doesn't panic
+		startLink(&LinkConfig{}, 1)
+
+		clientConn, err := net.DialUDP("udp", nil, proxy.LocalAddr().(*net.UDPAddr))
+		Expect(err).ToNot(HaveOccurred())
+		defer clientConn.Close()
+		_, err = clientConn.Write([]byte("foobar"))
+		Expect(err).ToNot(HaveOccurred())
+
+		b := make([]byte, 100)
+		for i := 0; i < 2; i++ {
+			serverConn.SetReadDeadline(time.Now().Add(time.Second))
+			n, addr, err := serverConn.ReadFromUDP(b)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(b[:n]).To(Equal([]byte("foobar")))
+			if i == 0 {
+				_, err = serverConn.WriteToUDP([]byte("response"), addr)
+				Expect(err).ToNot(HaveOccurred())
+			}
+		}
+		start := time.Now()
+		clientConn.SetReadDeadline(time.Now().Add(time.Second))
+		n, err := clientConn.Read(b)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(bytes.Equal(b[:n], []byte("response"))).To(BeTrue())
+		Expect(time.Since(start)).To(BeNumerically(">", 50*time.Millisecond))
+	})
+})
+
+// sequence returns the numbers from 0 to n-1
+func sequence(n int) []int {
+	nums := make([]int, n)
+	for i := range nums {
+		nums[i] = i
+	}
+	return nums
+}
@@ -17,6 +17,9 @@ type connection struct {
 
 	incomingPacketCounter uint64
 	outgoingPacketCounter uint64
+
+	incomingLink *link // nil if the incoming direction is not emulated
+	outgoingLink *link // nil if the outgoing direction is not emulated
 }
 
 // Direction is the direction a packet is sent.
@@ -79,9 +82,19 @@ type Opts struct {
 	// simulating a connection with non-zero RTTs.
 	// Note that the RTT is the sum of the delay for the incoming and the outgoing packet.
 	DelayPacket DelayCallback
+	// Incoming emulates the network from the client to the server.
+	// It is applied to packets that were not dropped by DropPacket.
+	Incoming *LinkConfig
+	// Outgoing emulates the network from the server to the client.
+	// It is applied to packets that were not dropped by DropPacket.
+	Outgoing *LinkConfig
+	// Seed seeds the random decisions of the network emulation, which makes them reproducible.
+	// Every direction of every connection uses its own random number generator, seeded from Seed.
+	// If zero, a random seed is used.
+	Seed int64
 }
 
-// QuicProxy is a QUIC proxy that can drop and delay packets.
+// QuicProxy is a QUIC proxy that can drop and delay packets, and emulate network conditions.
 type QuicProxy struct {
 	mutex sync.Mutex
 
@@ -93,6 +106,11 @@ type QuicProxy struct {
 	dropPacket  DropCallback
 	delayPacket DelayCallback
 
+	incoming *LinkConfig
+	outgoing *LinkConfig
+	seed     int64
+	numConns int64 // the number of connections, used to derive the seeds
+
 	// Mapping from client addresses (as host:port) to connection
 	clientDict map[string]*connection
 
@@ -127,12 +145,20 @@ func NewQuicProxy(local string, version protocol.VersionNumber, opts *Opts) (*Qu
 		packetDelayer = opts.DelayPacket
 	}
 
+	seed := opts.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+
 	p := QuicProxy{
 		clientDict:  make(map[string]*connection),
 		conn:        conn,
 		serverAddr:  raddr,
 		dropPacket:  packetDropper,
 		delayPacket: packetDelayer,
+		incoming:    opts.Incoming,
+		outgoing:    opts.Outgoing,
+		seed:        seed,
 		version:     version,
 		logger:      utils.DefaultLogger.WithPrefix("proxy"),
 	}
@@ -147,6 +173,7 @@ func (p *QuicProxy) Close() error {
 	p.mutex.Lock()
 	defer p.mutex.Unlock()
 	for _, c := range p.clientDict {
+		c.closeLinks()
 		if err := c.ServerConn.Close(); err != nil {
 			return err
 		}
@@ -169,10 +196,35 @@ func (p *QuicProxy) newConnection(cliAddr *net.UDPAddr) (*connection, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &connection{
+	conn := &connection{
 		ClientAddr: cliAddr,
 		ServerConn: srvudp,
-	}, nil
+	}
+	// Every connection uses two random number generators, derived from the seed of the proxy.
+	seed := p.seed + 2*p.numConns
+	p.numConns++
+	if p.incoming != nil {
+		conn.incomingLink = newLink(p.incoming, seed, func(raw []byte) {
+			// TODO: handle error
+			_, _ = conn.ServerConn.Write(raw)
+		})
+	}
+	if p.outgoing != nil {
+		conn.outgoingLink = newLink(p.outgoing, seed+1, func(raw []byte) {
+			// TODO: handle error
+			_, _ = p.conn.WriteToUDP(raw, conn.ClientAddr)
+		})
+	}
+	return conn, nil
+}
+
+func (c *connection) closeLinks() {
+	if c.incomingLink != nil {
+		c.incomingLink.Close()
+	}
+	if c.outgoingLink != nil {
+		c.outgoingLink.Close()
+	}
 }
 
 // runProxy listens on the proxy address and handles incoming packets.
@@ -211,7 +263,9 @@ func (p *QuicProxy) runProxy() error {
 
 		// Send the packet to the server
 		delay := p.delayPacket(DirectionIncoming, packetCount)
-		if delay != 0 {
+		if conn.incomingLink != nil {
+			conn.incomingLink.Send(raw, delay)
+		} else if delay != 0 {
 			if p.logger.Debug() {
 				p.logger.Debugf("delaying incoming packet %d (%d bytes) to %s by %s", packetCount, n, conn.ServerConn.RemoteAddr(), delay)
 			}
@@ -250,7 +304,9 @@ func (p *QuicProxy) runConnection(conn *connection) error {
 		}
 
 		delay := p.delayPacket(DirectionOutgoing, packetCount)
-		if delay != 0 {
+		if conn.outgoingLink != nil {
+			conn.outgoingLink.Send(raw, delay)
+		} else if delay != 0 {
 			if p.logger.Debug() {
 				p.logger.Debugf("delaying outgoing packet %d (%d bytes) to %s by %s", packetCount, n, conn.ClientAddr, delay)
 			}
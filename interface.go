@@ -8,6 +8,7 @@ import (
 
 	"github.com/wheelcomplex/qk/internal/handshake"
 	"github.com/wheelcomplex/qk/internal/protocol"
+	"github.com/wheelcomplex/qk/internal/utils"
 )
 
 // The StreamID is the ID of a QUIC stream.
@@ -23,6 +24,9 @@ const (
 	VersionGQUIC43 = protocol.Version43
 	// VersionGQUIC44 is gQUIC version 44.
 	VersionGQUIC44 = protocol.Version44
+	// VersionQUIC1 is QUIC version 1, as defined in RFC 9000.
+	// It requires crypto/tls (Go 1.21+).
+	VersionQUIC1 = protocol.Version1
 )
 
 // A Cookie can be used to verify the ownership of the client address.
@@ -34,6 +38,10 @@ type ConnectionState = handshake.ConnectionState
 // An ErrorCode is an application-defined error code.
 type ErrorCode = protocol.ApplicationErrorCode
 
+// A Clock provides the current time, and timers.
+// It is used to run sessions in virtual time in tests.
+type Clock = utils.Clock
+
 // Stream is the interface implemented by QUIC streams
 type Stream interface {
 	// StreamID returns the stream ID.
@@ -156,6 +164,11 @@ type Session interface {
 	// ConnectionState returns basic details about the QUIC connection.
 	// Warning: This API should not be considered stable and might change soon.
 	ConnectionState() ConnectionState
+	// InitiateKeyUpdate switches to the next generation of 1-RTT keys.
+	// It can only be called after the handshake completed, and only for IETF QUIC.
+	// The peer has to acknowledge the current keys by sending a packet before the keys can be updated again.
+	// Warning: This API should not be considered stable and might change soon.
+	InitiateKeyUpdate() error
 }
 
 // Config contains all configuration data needed for a QUIC server or client.
@@ -208,6 +221,28 @@ type Config struct {
 	MaxIncomingUniStreams int
 	// KeepAlive defines whether this peer will periodically send PING frames to keep the connection alive.
 	KeepAlive bool
+	// KeyUpdateInterval is the number of packets after which the 1-RTT keys are updated.
+	// This value only has an effect in IETF QUIC.
+	// If not set, keys are only updated when Session.InitiateKeyUpdate is called, or when the peer updates its keys.
+	KeyUpdateInterval uint64
+	// UseCryptoTLS makes IETF QUIC use the TLS 1.3 implementation of crypto/tls for the handshake, instead of mint.
+	// This requires Go 1.21 or newer. Both endpoints need to use the same TLS stack.
+	// This value doesn't have any effect in Google QUIC.
+	UseCryptoTLS bool
+	// Clock is the clock used by the session for all of its timing:
+	// RTT measurements, loss detection, pacing, ACK delays, and the handshake and idle timeouts.
+	// Stream deadlines and the TLS handshake still use the system time.
+	// If not set, the system time is used.
+	Clock Clock
+	// RecordWriter, if set, receives a recording of every session: all datagrams sent and received,
+	// with their timestamps and remote addresses, and all random choices of the session,
+	// i.e. the connection IDs, the skipped packet numbers, and the output of the TLS stack.
+	// Received datagrams are recorded as a whole, including coalesced packets and packets the session rejects.
+	// Recording is only supported for QUIC v1, so Versions must not contain any other version.
+	// A recorded session can be replayed using NewReplay.
+	// The records of different sessions are interleaved.
+	// The recording contains the TLS secrets, so it must be protected like a TLS key log.
+	RecordWriter io.Writer
 }
 
 // A Listener for incoming QUIC connections
@@ -14,6 +14,9 @@ type SentPacketHandler interface {
 	SentPacketsAsRetransmission(packets []*Packet, retransmissionOf protocol.PacketNumber)
 	ReceivedAck(ackFrame *wire.AckFrame, withPacketNumber protocol.PacketNumber, encLevel protocol.EncryptionLevel, recvTime time.Time) error
 	SetHandshakeComplete()
+	// DropPackets drops all packets of an encryption level.
+	// It is only used in QUIC v1.
+	DropPackets(protocol.EncryptionLevel)
 
 	// The SendMode determines if and what kind of packets can be sent.
 	SendMode() SendMode
@@ -31,7 +34,7 @@ type SentPacketHandler interface {
 	GetLowestPacketNotConfirmedAcked() protocol.PacketNumber
 	DequeuePacketForRetransmission() *Packet
 	DequeueProbePacket() (*Packet, error)
-	GetPacketNumberLen(protocol.PacketNumber) protocol.PacketNumberLen
+	GetPacketNumberLen(protocol.PacketNumber, protocol.EncryptionLevel) protocol.PacketNumberLen
 
 	GetAlarmTimeout() time.Time
 	OnAlarm() error
@@ -45,3 +48,15 @@ type ReceivedPacketHandler interface {
 	GetAlarmTimeout() time.Time
 	GetAckFrame() *wire.AckFrame
 }
+
+// ReceivedPacketHandlerV1 handles ACKs needed to send for incoming packets in QUIC v1.
+// QUIC v1 uses separate packet number spaces for Initial, Handshake and 1-RTT packets.
+// The methods of the ReceivedPacketHandler apply to the 1-RTT packet number space.
+type ReceivedPacketHandlerV1 interface {
+	ReceivedPacketHandler
+
+	ReceivedPacketWithEncryptionLevel(packetNumber protocol.PacketNumber, encLevel protocol.EncryptionLevel, rcvTime time.Time, shouldInstigateAck bool) error
+	GetAckFrameWithEncryptionLevel(protocol.EncryptionLevel) *wire.AckFrame
+	// DropPackets drops the packet number space of an encryption level.
+	DropPackets(protocol.EncryptionLevel)
+}
@@ -0,0 +1,59 @@
+package ackhandler
+
+import (
+	"time"
+
+	"github.com/wheelcomplex/qk/internal/protocol"
+	"github.com/wheelcomplex/qk/internal/wire"
+)
+
+// A packetNumberSpace holds the state of the packets sent in one packet number space.
+// QUIC v1 uses separate packet number spaces for Initial, Handshake and 1-RTT packets.
+// All other versions only use a single packet number space.
+type packetNumberSpace struct {
+	history *sentPacketHistory
+
+	lastSentPacketNumber         protocol.PacketNumber
+	largestAcked                 protocol.PacketNumber
+	largestReceivedPacketWithAck protocol.PacketNumber
+	// lowestPacketNotConfirmedAcked is the lowest packet number that we sent an ACK for, but haven't received confirmation, that this ACK actually arrived
+	// example: we send an ACK for packets 90-100 with packet number 20
+	// once we receive an ACK from the peer for packet 20, the lowestPacketNotConfirmedAcked is 101
+	lowestPacketNotConfirmedAcked protocol.PacketNumber
+
+	skippedPackets []protocol.PacketNumber
+
+	// The time at which the next packet will be considered lost based on early transmit or exceeding the reordering window in time.
+	lossTime time.Time
+}
+
+func newPacketNumberSpace() *packetNumberSpace {
+	return &packetNumberSpace{history: newSentPacketHistory()}
+}
+
+func (s *packetNumberSpace) lowestUnacked() protocol.PacketNumber {
+	if p := s.history.FirstOutstanding(); p != nil {
+		return p.PacketNumber
+	}
+	return s.largestAcked + 1
+}
+
+func (s *packetNumberSpace) skippedPacketsAcked(ackFrame *wire.AckFrame) bool {
+	for _, p := range s.skippedPackets {
+		if ackFrame.AcksPacket(p) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *packetNumberSpace) garbageCollectSkippedPackets() {
+	lowestUnacked := s.lowestUnacked()
+	deleteIndex := 0
+	for i, p := range s.skippedPackets {
+		if p < lowestUnacked {
+			deleteIndex = i + 1
+		}
+	}
+	s.skippedPackets = s.skippedPackets[deleteIndex:]
+}
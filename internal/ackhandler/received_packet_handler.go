@@ -18,12 +18,15 @@ type receivedPacketHandler struct {
 
 	ackSendDelay time.Duration
 	rttStats     *congestion.RTTStats
+	clock        congestion.Clock
 
 	packetsReceivedSinceLastAck                int
 	retransmittablePacketsReceivedSinceLastAck int
 	ackQueued                                  bool
 	ackAlarm                                   time.Time
 	lastAck                                    *wire.AckFrame
+	// if set, every retransmittable packet is acknowledged immediately
+	ackEveryPacket bool
 
 	logger utils.Logger
 
@@ -56,6 +59,7 @@ const (
 // NewReceivedPacketHandler creates a new receivedPacketHandler
 func NewReceivedPacketHandler(
 	rttStats *congestion.RTTStats,
+	clock congestion.Clock,
 	logger utils.Logger,
 	version protocol.VersionNumber,
 ) ReceivedPacketHandler {
@@ -63,6 +67,7 @@ func NewReceivedPacketHandler(
 		packetHistory: newReceivedPacketHistory(),
 		ackSendDelay:  ackSendDelay,
 		rttStats:      rttStats,
+		clock:         clock,
 		logger:        logger,
 		version:       version,
 	}
@@ -127,6 +132,12 @@ func (h *receivedPacketHandler) maybeQueueAck(packetNumber protocol.PacketNumber
 		h.ackQueued = true
 		return
 	}
+	if h.ackEveryPacket && shouldInstigateAck {
+		h.logger.Debugf("\tQueueing ACK because every retransmittable packet should be acknowledged.")
+		h.ackQueued = true
+		h.ackAlarm = time.Time{}
+		return
+	}
 
 	// Send an ACK if this packet was reported missing in an ACK sent before.
 	// Ack decimation with reordering relies on the timer to send an ACK, but if
@@ -153,7 +164,7 @@ func (h *receivedPacketHandler) maybeQueueAck(packetNumber protocol.PacketNumber
 				ackDelay := utils.MinDuration(ackSendDelay, time.Duration(float64(h.rttStats.MinRTT())*float64(ackDecimationDelay)))
 				h.ackAlarm = rcvTime.Add(ackDelay)
 				if h.logger.Debug() {
-					h.logger.Debugf("\tSetting ACK timer to min(1/4 min-RTT, max ack delay): %s (%s from now)", ackDelay, time.Until(h.ackAlarm))
+					h.logger.Debugf("\tSetting ACK timer to min(1/4 min-RTT, max ack delay): %s (%s from now)", ackDelay, h.ackAlarm.Sub(h.clock.Now()))
 				}
 			}
 		} else {
@@ -178,7 +189,7 @@ func (h *receivedPacketHandler) maybeQueueAck(packetNumber protocol.PacketNumber
 			if h.ackAlarm.IsZero() || h.ackAlarm.After(ackTime) {
 				h.ackAlarm = ackTime
 				if h.logger.Debug() {
-					h.logger.Debugf("\tSetting ACK timer to 1/8 min-RTT: %s (%s from now)", ackDelay, time.Until(h.ackAlarm))
+					h.logger.Debugf("\tSetting ACK timer to 1/8 min-RTT: %s (%s from now)", ackDelay, h.ackAlarm.Sub(h.clock.Now()))
 				}
 			}
 		}
@@ -191,7 +202,7 @@ func (h *receivedPacketHandler) maybeQueueAck(packetNumber protocol.PacketNumber
 }
 
 func (h *receivedPacketHandler) GetAckFrame() *wire.AckFrame {
-	now := time.Now()
+	now := h.clock.Now()
 	if !h.ackQueued && (h.ackAlarm.IsZero() || h.ackAlarm.After(now)) {
 		return nil
 	}
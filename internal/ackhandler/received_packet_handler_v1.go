@@ -0,0 +1,114 @@
+package ackhandler
+
+import (
+	"time"
+
+	"github.com/wheelcomplex/qk/internal/congestion"
+	"github.com/wheelcomplex/qk/internal/protocol"
+	"github.com/wheelcomplex/qk/internal/utils"
+	"github.com/wheelcomplex/qk/internal/wire"
+)
+
+// The receivedPacketHandlerV1 tracks the packets received in the 3 packet number spaces used by QUIC v1.
+// Initial and Handshake packets are acknowledged immediately,
+// since they carry the handshake messages, and the peer can't make progress without them.
+type receivedPacketHandlerV1 struct {
+	// The Initial and the Handshake packet number space are set to nil when they are dropped.
+	initialPackets   *receivedPacketHandler
+	handshakePackets *receivedPacketHandler
+	appDataPackets   *receivedPacketHandler
+
+	logger utils.Logger
+}
+
+var _ ReceivedPacketHandlerV1 = &receivedPacketHandlerV1{}
+
+// NewReceivedPacketHandlerV1 creates a new ReceivedPacketHandlerV1
+func NewReceivedPacketHandlerV1(
+	rttStats *congestion.RTTStats,
+	clock congestion.Clock,
+	logger utils.Logger,
+	version protocol.VersionNumber,
+) ReceivedPacketHandlerV1 {
+	initialPackets := NewReceivedPacketHandler(rttStats, clock, logger, version).(*receivedPacketHandler)
+	initialPackets.ackEveryPacket = true
+	handshakePackets := NewReceivedPacketHandler(rttStats, clock, logger, version).(*receivedPacketHandler)
+	handshakePackets.ackEveryPacket = true
+	return &receivedPacketHandlerV1{
+		initialPackets:   initialPackets,
+		handshakePackets: handshakePackets,
+		appDataPackets:   NewReceivedPacketHandler(rttStats, clock, logger, version).(*receivedPacketHandler),
+		logger:           logger,
+	}
+}
+
+// getPacketNumberSpace returns the packet number space for an encryption level.
+// It returns nil if the packet number space was already dropped.
+func (h *receivedPacketHandlerV1) getPacketNumberSpace(encLevel protocol.EncryptionLevel) *receivedPacketHandler {
+	switch encLevel {
+	case protocol.EncryptionInitial:
+		return h.initialPackets
+	case protocol.EncryptionHandshake:
+		return h.handshakePackets
+	default:
+		return h.appDataPackets
+	}
+}
+
+func (h *receivedPacketHandlerV1) ReceivedPacket(packetNumber protocol.PacketNumber, rcvTime time.Time, shouldInstigateAck bool) error {
+	return h.appDataPackets.ReceivedPacket(packetNumber, rcvTime, shouldInstigateAck)
+}
+
+func (h *receivedPacketHandlerV1) ReceivedPacketWithEncryptionLevel(
+	packetNumber protocol.PacketNumber,
+	encLevel protocol.EncryptionLevel,
+	rcvTime time.Time,
+	shouldInstigateAck bool,
+) error {
+	space := h.getPacketNumberSpace(encLevel)
+	if space == nil {
+		h.logger.Debugf("Ignoring packet %#x for dropped packet number space (%s).", packetNumber, encLevel)
+		return nil
+	}
+	return space.ReceivedPacket(packetNumber, rcvTime, shouldInstigateAck)
+}
+
+// IgnoreBelow sets a lower limit for acking 1-RTT packets.
+func (h *receivedPacketHandlerV1) IgnoreBelow(p protocol.PacketNumber) {
+	h.appDataPackets.IgnoreBelow(p)
+}
+
+func (h *receivedPacketHandlerV1) DropPackets(encLevel protocol.EncryptionLevel) {
+	switch encLevel {
+	case protocol.EncryptionInitial:
+		h.initialPackets = nil
+	case protocol.EncryptionHandshake:
+		h.handshakePackets = nil
+	}
+}
+
+// GetAlarmTimeout returns the earliest ACK alarm of all packet number spaces
+func (h *receivedPacketHandlerV1) GetAlarmTimeout() time.Time {
+	var alarm time.Time
+	for _, space := range []*receivedPacketHandler{h.initialPackets, h.handshakePackets, h.appDataPackets} {
+		if space == nil {
+			continue
+		}
+		if t := space.GetAlarmTimeout(); !t.IsZero() && (alarm.IsZero() || t.Before(alarm)) {
+			alarm = t
+		}
+	}
+	return alarm
+}
+
+func (h *receivedPacketHandlerV1) GetAckFrame() *wire.AckFrame {
+	return h.appDataPackets.GetAckFrame()
+}
+
+func (h *receivedPacketHandlerV1) GetAckFrameWithEncryptionLevel(encLevel protocol.EncryptionLevel) *wire.AckFrame {
+	space := h.getPacketNumberSpace(encLevel)
+	if space == nil {
+		return nil
+	}
+	return space.GetAckFrame()
+}
@@ -0,0 +1,66 @@
+package ackhandler
+
+import (
+	"time"
+
+	"github.com/wheelcomplex/qk/internal/congestion"
+	"github.com/wheelcomplex/qk/internal/protocol"
+	"github.com/wheelcomplex/qk/internal/utils"
+	"github.com/wheelcomplex/qk/internal/wire"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("receivedPacketHandler for QUIC v1", func() {
+	var handler ReceivedPacketHandlerV1
+
+	BeforeEach(func() {
+		handler = NewReceivedPacketHandlerV1(&congestion.RTTStats{}, congestion.DefaultClock{}, utils.DefaultLogger, protocol.Version1)
+	})
+
+	It("uses separate packet number spaces", func() {
+		now := time.Now()
+		Expect(handler.ReceivedPacketWithEncryptionLevel(0, protocol.EncryptionInitial, now, true)).To(Succeed())
+		Expect(handler.ReceivedPacketWithEncryptionLevel(0, protocol.EncryptionHandshake, now, true)).To(Succeed())
+		Expect(handler.ReceivedPacketWithEncryptionLevel(0, protocol.EncryptionForwardSecure, now, true)).To(Succeed())
+		Expect(handler.ReceivedPacketWithEncryptionLevel(1, protocol.EncryptionForwardSecure, now, true)).To(Succeed())
+		ack := handler.GetAckFrameWithEncryptionLevel(protocol.EncryptionInitial)
+		Expect(ack).ToNot(BeNil())
+		Expect(ack.AckRanges).To(Equal([]wire.AckRange{{Smallest: 0, Largest: 0}}))
+		ack = handler.GetAckFrameWithEncryptionLevel(protocol.EncryptionHandshake)
+		Expect(ack).ToNot(BeNil())
+		Expect(ack.AckRanges).To(Equal([]wire.AckRange{{Smallest: 0, Largest: 0}}))
+		ack = handler.GetAckFrame()
+		Expect(ack).ToNot(BeNil())
+		Expect(ack.AckRanges).To(Equal([]wire.AckRange{{Smallest: 0, Largest: 1}}))
+	})
+
+	It("acknowledges every retransmittable Handshake packet immediately", func() {
+		now := time.Now()
+		Expect(handler.ReceivedPacketWithEncryptionLevel(0, protocol.EncryptionHandshake, now, true)).To(Succeed())
+		Expect(handler.GetAckFrameWithEncryptionLevel(protocol.EncryptionHandshake)).ToNot(BeNil())
+		Expect(handler.ReceivedPacketWithEncryptionLevel(1, protocol.EncryptionHandshake, now, true)).To(Succeed())
+		Expect(handler.GetAlarmTimeout()).To(BeZero())
+		ack := handler.GetAckFrameWithEncryptionLevel(protocol.EncryptionHandshake)
+		Expect(ack).ToNot(BeNil())
+		Expect(ack.LargestAcked()).To(Equal(protocol.PacketNumber(1)))
+	})
+
+	It("delays ACKs for 1-RTT packets", func() {
+		now := time.Now()
+		Expect(handler.ReceivedPacket(0, now, true)).To(Succeed())
+		Expect(handler.GetAckFrame()).ToNot(BeNil())
+		Expect(handler.ReceivedPacket(1, now, true)).To(Succeed())
+		Expect(handler.GetAlarmTimeout()).ToNot(BeZero())
+	})
+
+	It("drops packet number spaces", func() {
+		now := time.Now()
+		Expect(handler.ReceivedPacketWithEncryptionLevel(0, protocol.EncryptionInitial, now, true)).To(Succeed())
+		handler.DropPackets(protocol.EncryptionInitial)
+		Expect(handler.GetAckFrameWithEncryptionLevel(protocol.EncryptionInitial)).To(BeNil())
+		Expect(handler.ReceivedPacketWithEncryptionLevel(1, protocol.EncryptionInitial, now, true)).To(Succeed())
+		Expect(handler.GetAckFrameWithEncryptionLevel(protocol.EncryptionInitial)).To(BeNil())
+	})
+})
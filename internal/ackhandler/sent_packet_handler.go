@@ -30,22 +30,19 @@ const (
 )
 
 type sentPacketHandler struct {
-	lastSentPacketNumber              protocol.PacketNumber
 	lastSentRetransmittablePacketTime time.Time
 	lastSentHandshakePacketTime       time.Time
 
 	nextPacketSendTime time.Time
-	skippedPackets     []protocol.PacketNumber
 
-	largestAcked                 protocol.PacketNumber
-	largestReceivedPacketWithAck protocol.PacketNumber
-	// lowestPacketNotConfirmedAcked is the lowest packet number that we sent an ACK for, but haven't received confirmation, that this ACK actually arrived
-	// example: we send an ACK for packets 90-100 with packet number 20
-	// once we receive an ACK from the peer for packet 20, the lowestPacketNotConfirmedAcked is 101
-	lowestPacketNotConfirmedAcked protocol.PacketNumber
-	largestSentBeforeRTO          protocol.PacketNumber
+	largestSentBeforeRTO protocol.PacketNumber
+
+	// The Initial and the Handshake packet number space are only used in QUIC v1.
+	// They are set to nil when the keys for the respective encryption level are dropped.
+	initialPackets   *packetNumberSpace
+	handshakePackets *packetNumberSpace
+	appDataPackets   *packetNumberSpace
 
-	packetHistory      *sentPacketHistory
 	stopWaitingManager stopWaitingManager
 
 	retransmissionQueue []*Packet
@@ -54,6 +51,7 @@ type sentPacketHandler struct {
 
 	congestion congestion.SendAlgorithm
 	rttStats   *congestion.RTTStats
+	clock      congestion.Clock
 
 	handshakeComplete bool
 	// The number of times the handshake packets have been retransmitted without receiving an ack.
@@ -68,9 +66,6 @@ type sentPacketHandler struct {
 	// The number of RTO probe packets that should be sent.
 	numRTOs int
 
-	// The time at which the next packet will be considered lost based on early transmit or exceeding the reordering window in time.
-	lossTime time.Time
-
 	// The alarm timeout
 	alarm time.Time
 
@@ -80,30 +75,86 @@ type sentPacketHandler struct {
 }
 
 // NewSentPacketHandler creates a new sentPacketHandler
-func NewSentPacketHandler(rttStats *congestion.RTTStats, logger utils.Logger, version protocol.VersionNumber) SentPacketHandler {
+func NewSentPacketHandler(rttStats *congestion.RTTStats, clock congestion.Clock, logger utils.Logger, version protocol.VersionNumber) SentPacketHandler {
 	congestion := congestion.NewCubicSender(
-		congestion.DefaultClock{},
+		clock,
 		rttStats,
 		false, /* don't use reno since chromium doesn't (why?) */
 		protocol.InitialCongestionWindow,
 		protocol.DefaultMaxCongestionWindow,
 	)
 
-	return &sentPacketHandler{
-		packetHistory:      newSentPacketHistory(),
+	h := &sentPacketHandler{
+		appDataPackets:     newPacketNumberSpace(),
 		stopWaitingManager: stopWaitingManager{},
 		rttStats:           rttStats,
+		clock:              clock,
 		congestion:         congestion,
 		logger:             logger,
 		version:            version,
 	}
+	if version.UsesPacketNumberSpaces() {
+		h.initialPackets = newPacketNumberSpace()
+		h.handshakePackets = newPacketNumberSpace()
+	}
+	return h
 }
 
-func (h *sentPacketHandler) lowestUnacked() protocol.PacketNumber {
-	if p := h.packetHistory.FirstOutstanding(); p != nil {
-		return p.PacketNumber
+// getPacketNumberSpace returns the packet number space used for packets sent with this encryption level.
+// It returns nil if the packet number space was already dropped.
+func (h *sentPacketHandler) getPacketNumberSpace(encLevel protocol.EncryptionLevel) *packetNumberSpace {
+	if !h.version.UsesPacketNumberSpaces() {
+		return h.appDataPackets
+	}
+	switch encLevel {
+	case protocol.EncryptionInitial:
+		return h.initialPackets
+	case protocol.EncryptionHandshake:
+		return h.handshakePackets
+	default:
+		return h.appDataPackets
 	}
-	return h.largestAcked + 1
+}
+
+// packetNumberSpaces returns all packet number spaces that haven't been dropped yet
+func (h *sentPacketHandler) packetNumberSpaces() []*packetNumberSpace {
+	spaces := make([]*packetNumberSpace, 0, 3)
+	if h.initialPackets != nil {
+		spaces = append(spaces, h.initialPackets)
+	}
+	if h.handshakePackets != nil {
+		spaces = append(spaces, h.handshakePackets)
+	}
+	return append(spaces, h.appDataPackets)
+}
+
+func (h *sentPacketHandler) hasOutstandingPackets() bool {
+	for _, space := range h.packetNumberSpaces() {
+		if space.history.HasOutstandingPackets() {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *sentPacketHandler) hasOutstandingHandshakePackets() bool {
+	for _, space := range h.packetNumberSpaces() {
+		if space.history.HasOutstandingHandshakePackets() {
+			return true
+		}
+	}
+	return false
+}
+
+// lossTime returns the earliest loss time of all packet number spaces
+func (h *sentPacketHandler) lossTime() time.Time {
+	var lossTime time.Time
+	for _, space := range h.packetNumberSpaces() {
+		if !space.lossTime.IsZero() && (lossTime.IsZero() || space.lossTime.Before(lossTime)) {
+			lossTime = space.lossTime
+		}
+	}
+	return lossTime
 }
 
 func (h *sentPacketHandler) SetHandshakeComplete() {
@@ -114,47 +165,95 @@ func (h *sentPacketHandler) SetHandshakeComplete() {
 			queue = append(queue, packet)
 		}
 	}
-	var handshakePackets []*Packet
-	h.packetHistory.Iterate(func(p *Packet) (bool, error) {
-		if p.EncryptionLevel != protocol.EncryptionForwardSecure {
-			handshakePackets = append(handshakePackets, p)
+	for _, space := range h.packetNumberSpaces() {
+		var handshakePackets []*Packet
+		space.history.Iterate(func(p *Packet) (bool, error) {
+			if p.EncryptionLevel != protocol.EncryptionForwardSecure {
+				handshakePackets = append(handshakePackets, p)
+			}
+			return true, nil
+		})
+		for _, p := range handshakePackets {
+			space.history.Remove(p.PacketNumber)
 		}
-		return true, nil
-	})
-	for _, p := range handshakePackets {
-		h.packetHistory.Remove(p.PacketNumber)
 	}
 	h.retransmissionQueue = queue
 	h.handshakeComplete = true
 }
 
+// DropPackets drops all packets sent with this encryption level, as well as the packet number space they were sent in.
+// It is used in QUIC v1, when the keys for the Initial and the Handshake encryption level are discarded.
+// Dropped packets are neither retransmitted nor declared lost, but they are removed from the bytes in flight.
+func (h *sentPacketHandler) DropPackets(encLevel protocol.EncryptionLevel) {
+	if !h.version.UsesPacketNumberSpaces() || encLevel == protocol.EncryptionForwardSecure {
+		return
+	}
+	space := h.getPacketNumberSpace(encLevel)
+	if space == nil {
+		return
+	}
+	h.logger.Debugf("Dropping all %s packets.", encLevel)
+	var queue []*Packet
+	for _, packet := range h.retransmissionQueue {
+		if packet.EncryptionLevel != encLevel {
+			queue = append(queue, packet)
+		}
+	}
+	h.retransmissionQueue = queue
+	space.history.Iterate(func(p *Packet) (bool, error) {
+		if p.includedInBytesInFlight {
+			h.bytesInFlight -= p.Length
+		}
+		return true, nil
+	})
+	switch encLevel {
+	case protocol.EncryptionInitial:
+		h.initialPackets = nil
+	case protocol.EncryptionHandshake:
+		h.handshakePackets = nil
+	}
+	h.updateLossDetectionAlarm()
+}
+
 func (h *sentPacketHandler) SentPacket(packet *Packet) {
-	if isRetransmittable := h.sentPacketImpl(packet); isRetransmittable {
-		h.packetHistory.SentPacket(packet)
+	space := h.getPacketNumberSpace(packet.EncryptionLevel)
+	if space == nil {
+		return
+	}
+	if isRetransmittable := h.sentPacketImpl(packet, space); isRetransmittable {
+		space.history.SentPacket(packet)
 		h.updateLossDetectionAlarm()
 	}
 }
 
 func (h *sentPacketHandler) SentPacketsAsRetransmission(packets []*Packet, retransmissionOf protocol.PacketNumber) {
+	if len(packets) == 0 {
+		return
+	}
+	// retransmissions are sent with the encryption level of the original packet
+	space := h.getPacketNumberSpace(packets[0].EncryptionLevel)
+	if space == nil {
+		return
+	}
 	var p []*Packet
 	for _, packet := range packets {
-		if isRetransmittable := h.sentPacketImpl(packet); isRetransmittable {
+		if isRetransmittable := h.sentPacketImpl(packet, space); isRetransmittable {
 			p = append(p, packet)
 		}
 	}
-	h.packetHistory.SentPacketsAsRetransmission(p, retransmissionOf)
+	space.history.SentPacketsAsRetransmission(p, retransmissionOf)
 	h.updateLossDetectionAlarm()
 }
 
-func (h *sentPacketHandler) sentPacketImpl(packet *Packet) bool /* isRetransmittable */ {
-	for p := h.lastSentPacketNumber + 1; p < packet.PacketNumber; p++ {
-		h.skippedPackets = append(h.skippedPackets, p)
-		if len(h.skippedPackets) > protocol.MaxTrackedSkippedPackets {
-			h.skippedPackets = h.skippedPackets[1:]
+func (h *sentPacketHandler) sentPacketImpl(packet *Packet, space *packetNumberSpace) bool /* isRetransmittable */ {
+	for p := space.lastSentPacketNumber + 1; p < packet.PacketNumber; p++ {
+		space.skippedPackets = append(space.skippedPackets, p)
+		if len(space.skippedPackets) > protocol.MaxTrackedSkippedPackets {
+			space.skippedPackets = space.skippedPackets[1:]
 		}
 	}
 
-	h.lastSentPacketNumber = packet.PacketNumber
+	space.lastSentPacketNumber = packet.PacketNumber
 
 	if len(packet.Frames) > 0 {
 		if ackFrame, ok := packet.Frames[0].(*wire.AckFrame); ok {
@@ -185,28 +284,33 @@ func (h *sentPacketHandler) sentPacketImpl(packet *Packet) bool /* isRetransmitt
 }
 
 func (h *sentPacketHandler) ReceivedAck(ackFrame *wire.AckFrame, withPacketNumber protocol.PacketNumber, encLevel protocol.EncryptionLevel, rcvTime time.Time) error {
+	space := h.getPacketNumberSpace(encLevel)
+	if space == nil {
+		h.logger.Debugf("Ignoring ACK frame for dropped packet number space (%s).", encLevel)
+		return nil
+	}
 	largestAcked := ackFrame.LargestAcked()
-	if largestAcked > h.lastSentPacketNumber {
+	if largestAcked > space.lastSentPacketNumber {
 		return qerr.Error(qerr.InvalidAckData, "Received ACK for an unsent package")
 	}
 
 	// duplicate or out of order ACK
-	if withPacketNumber != 0 && withPacketNumber <= h.largestReceivedPacketWithAck {
+	if withPacketNumber != 0 && withPacketNumber <= space.largestReceivedPacketWithAck {
 		h.logger.Debugf("Ignoring ACK frame (duplicate or out of order).")
 		return nil
 	}
-	h.largestReceivedPacketWithAck = withPacketNumber
-	h.largestAcked = utils.MaxPacketNumber(h.largestAcked, largestAcked)
+	space.largestReceivedPacketWithAck = withPacketNumber
+	space.largestAcked = utils.MaxPacketNumber(space.largestAcked, largestAcked)
 
-	if h.skippedPacketsAcked(ackFrame) {
+	if space.skippedPacketsAcked(ackFrame) {
 		return qerr.Error(qerr.InvalidAckData, "Received an ACK for a skipped packet number")
 	}
 
-	if rttUpdated := h.maybeUpdateRTT(largestAcked, ackFrame.DelayTime, rcvTime); rttUpdated {
+	if rttUpdated := h.maybeUpdateRTT(largestAcked, ackFrame.DelayTime, rcvTime, space); rttUpdated {
 		h.congestion.MaybeExitSlowStart()
 	}
 
-	ackedPackets, err := h.determineNewlyAckedPackets(ackFrame)
+	ackedPackets, err := h.determineNewlyAckedPackets(ackFrame, space)
 	if err != nil {
 		return err
 	}
@@ -220,9 +324,9 @@ func (h *sentPacketHandler) ReceivedAck(ackFrame *wire.AckFrame, withPacketNumbe
 		// It is safe to ignore the corner case of packets that just acked packet 0, because
 		// the lowestPacketNotConfirmedAcked is only used to limit the number of ACK ranges we will send.
 		if p.largestAcked != 0 {
-			h.lowestPacketNotConfirmedAcked = utils.MaxPacketNumber(h.lowestPacketNotConfirmedAcked, p.largestAcked+1)
+			space.lowestPacketNotConfirmedAcked = utils.MaxPacketNumber(space.lowestPacketNotConfirmedAcked, p.largestAcked+1)
 		}
-		if err := h.onPacketAcked(p, rcvTime); err != nil {
+		if err := h.onPacketAcked(p, space); err != nil {
 			return err
 		}
 		if p.includedInBytesInFlight {
@@ -230,27 +334,29 @@ func (h *sentPacketHandler) ReceivedAck(ackFrame *wire.AckFrame, withPacketNumbe
 		}
 	}
 
-	if err := h.detectLostPackets(rcvTime, priorInFlight); err != nil {
+	if err := h.detectLostPackets(rcvTime, priorInFlight, space); err != nil {
 		return err
 	}
 	h.updateLossDetectionAlarm()
 
-	h.garbageCollectSkippedPackets()
+	space.garbageCollectSkippedPackets()
 	h.stopWaitingManager.ReceivedAck(ackFrame)
 
 	return nil
 }
 
+// GetLowestPacketNotConfirmedAcked returns the lowest packet number of the 1-RTT packet number space
+// that we sent an ACK for, but haven't received confirmation for.
 func (h *sentPacketHandler) GetLowestPacketNotConfirmedAcked() protocol.PacketNumber {
-	return h.lowestPacketNotConfirmedAcked
+	return h.appDataPackets.lowestPacketNotConfirmedAcked
 }
 
-func (h *sentPacketHandler) determineNewlyAckedPackets(ackFrame *wire.AckFrame) ([]*Packet, error) {
+func (h *sentPacketHandler) determineNewlyAckedPackets(ackFrame *wire.AckFrame, space *packetNumberSpace) ([]*Packet, error) {
 	var ackedPackets []*Packet
 	ackRangeIndex := 0
 	lowestAcked := ackFrame.LowestAcked()
 	largestAcked := ackFrame.LargestAcked()
-	err := h.packetHistory.Iterate(func(p *Packet) (bool, error) {
+	err := space.history.Iterate(func(p *Packet) (bool, error) {
 		// Ignore packets below the lowest acked
 		if p.PacketNumber < lowestAcked {
 			return true, nil
@@ -289,8 +395,8 @@ func (h *sentPacketHandler) determineNewlyAckedPackets(ackFrame *wire.AckFrame)
 	return ackedPackets, err
 }
 
-func (h *sentPacketHandler) maybeUpdateRTT(largestAcked protocol.PacketNumber, ackDelay time.Duration, rcvTime time.Time) bool {
-	if p := h.packetHistory.GetPacket(largestAcked); p != nil {
+func (h *sentPacketHandler) maybeUpdateRTT(largestAcked protocol.PacketNumber, ackDelay time.Duration, rcvTime time.Time, space *packetNumberSpace) bool {
+	if p := space.history.GetPacket(largestAcked); p != nil {
 		h.rttStats.UpdateRTT(rcvTime.Sub(p.SendTime), ackDelay, rcvTime)
 		if h.logger.Debug() {
 			h.logger.Debugf("\tupdated RTT: %s (σ: %s)", h.rttStats.SmoothedRTT(), h.rttStats.MeanDeviation())
@@ -302,16 +408,16 @@ func (h *sentPacketHandler) maybeUpdateRTT(largestAcked protocol.PacketNumber, a
 
 func (h *sentPacketHandler) updateLossDetectionAlarm() {
 	// Cancel the alarm if no packets are outstanding
-	if !h.packetHistory.HasOutstandingPackets() {
+	if !h.hasOutstandingPackets() {
 		h.alarm = time.Time{}
 		return
 	}
 
-	if h.packetHistory.HasOutstandingHandshakePackets() {
+	if h.hasOutstandingHandshakePackets() {
 		h.alarm = h.lastSentHandshakePacketTime.Add(h.computeHandshakeTimeout())
-	} else if !h.lossTime.IsZero() {
+	} else if lossTime := h.lossTime(); !lossTime.IsZero() {
 		// Early retransmit timer or time loss detection.
-		h.alarm = h.lossTime
+		h.alarm = lossTime
 	} else {
 		// RTO or TLP alarm
 		alarmDuration := h.computeRTOTimeout()
@@ -324,27 +430,27 @@ func (h *sentPacketHandler) updateLossDetectionAlarm() {
 	}
 }
 
-func (h *sentPacketHandler) detectLostPackets(now time.Time, priorInFlight protocol.ByteCount) error {
-	h.lossTime = time.Time{}
+func (h *sentPacketHandler) detectLostPackets(now time.Time, priorInFlight protocol.ByteCount, space *packetNumberSpace) error {
+	space.lossTime = time.Time{}
 
 	maxRTT := float64(utils.MaxDuration(h.rttStats.LatestRTT(), h.rttStats.SmoothedRTT()))
 	delayUntilLost := time.Duration((1.0 + timeReorderingFraction) * maxRTT)
 
 	var lostPackets []*Packet
-	h.packetHistory.Iterate(func(packet *Packet) (bool, error) {
-		if packet.PacketNumber > h.largestAcked {
+	space.history.Iterate(func(packet *Packet) (bool, error) {
+		if packet.PacketNumber > space.largestAcked {
 			return false, nil
 		}
 
 		timeSinceSent := now.Sub(packet.SendTime)
-		if timeSinceSent > delayUntilLost {
+		if timeSinceSent >= delayUntilLost {
 			lostPackets = append(lostPackets, packet)
-		} else if h.lossTime.IsZero() {
+		} else if space.lossTime.IsZero() {
 			if h.logger.Debug() {
 				h.logger.Debugf("\tsetting loss timer for packet %#x to %s (in %s)", packet.PacketNumber, delayUntilLost, delayUntilLost-timeSinceSent)
 			}
 			// Note: This conditional is only entered once per call
-			h.lossTime = now.Add(delayUntilLost - timeSinceSent)
+			space.lossTime = now.Add(delayUntilLost - timeSinceSent)
 		}
 		return true, nil
 	})
@@ -364,11 +470,11 @@ func (h *sentPacketHandler) detectLostPackets(now time.Time, priorInFlight proto
 		}
 		if p.canBeRetransmitted {
 			// queue the packet for retransmission, and report the loss to the congestion controller
-			if err := h.queuePacketForRetransmission(p); err != nil {
+			if err := h.queuePacketForRetransmission(p, space); err != nil {
 				return err
 			}
 		}
-		h.packetHistory.Remove(p.PacketNumber)
+		space.history.Remove(p.PacketNumber)
 	}
 	return nil
 }
@@ -378,7 +484,7 @@ func (h *sentPacketHandler) OnAlarm() error {
 	// updateLossDetectionAlarm. This doesn't reset the timer in the session though.
 	// When OnAlarm is called, we therefore need to make sure that there are
 	// actually packets outstanding.
-	if h.packetHistory.HasOutstandingPackets() {
+	if h.hasOutstandingPackets() {
 		if err := h.onVerifiedAlarm(); err != nil {
 			return err
 		}
@@ -389,18 +495,26 @@ func (h *sentPacketHandler) OnAlarm() error {
 
 func (h *sentPacketHandler) onVerifiedAlarm() error {
 	var err error
-	if h.packetHistory.HasOutstandingHandshakePackets() {
+	if h.hasOutstandingHandshakePackets() {
 		if h.logger.Debug() {
 			h.logger.Debugf("Loss detection alarm fired in handshake mode. Handshake count: %d", h.handshakeCount)
 		}
 		h.handshakeCount++
 		err = h.queueHandshakePacketsForRetransmission()
-	} else if !h.lossTime.IsZero() {
+	} else if lossTime := h.lossTime(); !lossTime.IsZero() {
 		if h.logger.Debug() {
-			h.logger.Debugf("Loss detection alarm fired in loss timer mode. Loss time: %s", h.lossTime)
+			h.logger.Debugf("Loss detection alarm fired in loss timer mode. Loss time: %s", lossTime)
 		}
 		// Early retransmit or time loss detection
-		err = h.detectLostPackets(time.Now(), h.bytesInFlight)
+		priorInFlight := h.bytesInFlight
+		for _, space := range h.packetNumberSpaces() {
+			if space.lossTime.IsZero() {
+				continue
+			}
+			if err = h.detectLostPackets(h.clock.Now(), priorInFlight, space); err != nil {
+				break
+			}
+		}
 	} else if h.tlpCount < maxTLPs { // TLP
 		if h.logger.Debug() {
 			h.logger.Debugf("Loss detection alarm fired in TLP mode. TLP count: %d", h.tlpCount)
@@ -412,7 +526,7 @@ func (h *sentPacketHandler) onVerifiedAlarm() error {
 			h.logger.Debugf("Loss detection alarm fired in RTO mode. RTO count: %d", h.rtoCount)
 		}
 		if h.rtoCount == 0 {
-			h.largestSentBeforeRTO = h.lastSentPacketNumber
+			h.largestSentBeforeRTO = h.appDataPackets.lastSentPacketNumber
 		}
 		h.rtoCount++
 		h.numRTOs += 2
@@ -424,11 +538,11 @@ func (h *sentPacketHandler) GetAlarmTimeout() time.Time {
 	return h.alarm
 }
 
-func (h *sentPacketHandler) onPacketAcked(p *Packet, rcvTime time.Time) error {
+func (h *sentPacketHandler) onPacketAcked(p *Packet, space *packetNumberSpace) error {
 	// This happens if a packet and its retransmissions is acked in the same ACK.
 	// As soon as we process the first one, this will remove all the retransmissions,
 	// so we won't find the retransmitted packet number later.
-	if packet := h.packetHistory.GetPacket(p.PacketNumber); packet == nil {
+	if packet := space.history.GetPacket(p.PacketNumber); packet == nil {
 		return nil
 	}
 
@@ -437,7 +551,7 @@ func (h *sentPacketHandler) onPacketAcked(p *Packet, rcvTime time.Time) error {
 	// * this packet wasn't retransmitted yet
 	if p.isRetransmission {
 		// that the parent doesn't exist is expected to happen every time the original packet was already acked
-		if parent := h.packetHistory.GetPacket(p.retransmissionOf); parent != nil {
+		if parent := space.history.GetPacket(p.retransmissionOf); parent != nil {
 			if len(parent.retransmittedAs) == 1 {
 				parent.retransmittedAs = nil
 			} else {
@@ -459,25 +573,25 @@ func (h *sentPacketHandler) onPacketAcked(p *Packet, rcvTime time.Time) error {
 	if h.rtoCount > 0 {
 		h.verifyRTO(p.PacketNumber)
 	}
-	if err := h.stopRetransmissionsFor(p); err != nil {
+	if err := h.stopRetransmissionsFor(p, space); err != nil {
 		return err
 	}
 	h.rtoCount = 0
 	h.tlpCount = 0
 	h.handshakeCount = 0
-	return h.packetHistory.Remove(p.PacketNumber)
+	return space.history.Remove(p.PacketNumber)
 }
 
-func (h *sentPacketHandler) stopRetransmissionsFor(p *Packet) error {
-	if err := h.packetHistory.MarkCannotBeRetransmitted(p.PacketNumber); err != nil {
+func (h *sentPacketHandler) stopRetransmissionsFor(p *Packet, space *packetNumberSpace) error {
+	if err := space.history.MarkCannotBeRetransmitted(p.PacketNumber); err != nil {
 		return err
 	}
 	for _, r := range p.retransmittedAs {
-		packet := h.packetHistory.GetPacket(r)
+		packet := space.history.GetPacket(r)
 		if packet == nil {
 			return fmt.Errorf("sent packet handler BUG: marking packet as not retransmittable %d (retransmission of %d) not found in history", r, p.PacketNumber)
 		}
-		h.stopRetransmissionsFor(packet)
+		h.stopRetransmissionsFor(packet, space)
 	}
 	return nil
 }
@@ -508,19 +622,35 @@ func (h *sentPacketHandler) DequeuePacketForRetransmission() *Packet {
 
 func (h *sentPacketHandler) DequeueProbePacket() (*Packet, error) {
 	if len(h.retransmissionQueue) == 0 {
-		p := h.packetHistory.FirstOutstanding()
+		var p *Packet
+		var space *packetNumberSpace
+		for _, space = range h.packetNumberSpaces() {
+			if p = space.history.FirstOutstanding(); p != nil {
+				break
+			}
+		}
 		if p == nil {
 			return nil, errors.New("cannot dequeue a probe packet. No outstanding packets")
 		}
-		if err := h.queuePacketForRetransmission(p); err != nil {
+		if err := h.queuePacketForRetransmission(p, space); err != nil {
 			return nil, err
 		}
 	}
 	return h.DequeuePacketForRetransmission(), nil
 }
 
-func (h *sentPacketHandler) GetPacketNumberLen(p protocol.PacketNumber) protocol.PacketNumberLen {
-	return protocol.GetPacketNumberLengthForHeader(p, h.lowestUnacked(), h.version)
+func (h *sentPacketHandler) GetPacketNumberLen(p protocol.PacketNumber, encLevel protocol.EncryptionLevel) protocol.PacketNumberLen {
+	space := h.getPacketNumberSpace(encLevel)
+	if space == nil {
+		return protocol.PacketNumberLen4
+	}
+	lowestUnacked := space.lowestUnacked()
+	// In QUIC v1, packet numbers start at 0.
+	// The first packet sent in a packet number space is smaller than the lowest unacked returned for an empty space.
+	if p < lowestUnacked {
+		lowestUnacked = p
+	}
+	return protocol.GetPacketNumberLengthForHeader(p, lowestUnacked, h.version)
 }
 
 func (h *sentPacketHandler) GetStopWaitingFrame(force bool) *wire.StopWaitingFrame {
@@ -528,7 +658,10 @@ func (h *sentPacketHandler) GetStopWaitingFrame(force bool) *wire.StopWaitingFra
 }
 
 func (h *sentPacketHandler) SendMode() SendMode {
-	numTrackedPackets := len(h.retransmissionQueue) + h.packetHistory.Len()
+	numTrackedPackets := len(h.retransmissionQueue)
+	for _, space := range h.packetNumberSpaces() {
+		numTrackedPackets += space.history.Len()
+	}
 
 	// Don't send any packets if we're keeping track of the maximum number of packets.
 	// Note that since MaxOutstandingSentPackets is smaller than MaxTrackedSentPackets,
@@ -583,27 +716,29 @@ func (h *sentPacketHandler) ShouldSendNumPackets() int {
 }
 
 func (h *sentPacketHandler) queueHandshakePacketsForRetransmission() error {
-	var handshakePackets []*Packet
-	h.packetHistory.Iterate(func(p *Packet) (bool, error) {
-		if p.canBeRetransmitted && p.EncryptionLevel < protocol.EncryptionForwardSecure {
-			handshakePackets = append(handshakePackets, p)
-		}
-		return true, nil
-	})
-	for _, p := range handshakePackets {
-		h.logger.Debugf("Queueing packet %#x as a handshake retransmission", p.PacketNumber)
-		if err := h.queuePacketForRetransmission(p); err != nil {
-			return err
+	for _, space := range h.packetNumberSpaces() {
+		var handshakePackets []*Packet
+		space.history.Iterate(func(p *Packet) (bool, error) {
+			if p.canBeRetransmitted && p.EncryptionLevel < protocol.EncryptionForwardSecure {
+				handshakePackets = append(handshakePackets, p)
+			}
+			return true, nil
+		})
+		for _, p := range handshakePackets {
+			h.logger.Debugf("Queueing packet %#x as a handshake retransmission", p.PacketNumber)
+			if err := h.queuePacketForRetransmission(p, space); err != nil {
+				return err
+			}
 		}
 	}
 	return nil
 }
 
-func (h *sentPacketHandler) queuePacketForRetransmission(p *Packet) error {
+func (h *sentPacketHandler) queuePacketForRetransmission(p *Packet, space *packetNumberSpace) error {
 	if !p.canBeRetransmitted {
 		return fmt.Errorf("sent packet handler BUG: packet %d already queued for retransmission", p.PacketNumber)
 	}
-	if err := h.packetHistory.MarkCannotBeRetransmitted(p.PacketNumber); err != nil {
+	if err := space.history.MarkCannotBeRetransmitted(p.PacketNumber); err != nil {
 		return err
 	}
 	h.retransmissionQueue = append(h.retransmissionQueue, p)
@@ -636,23 +771,3 @@ func (h *sentPacketHandler) computeRTOTimeout() time.Duration {
 	rto = rto << h.rtoCount
 	return utils.MinDuration(rto, maxRTOTimeout)
 }
-
-func (h *sentPacketHandler) skippedPacketsAcked(ackFrame *wire.AckFrame) bool {
-	for _, p := range h.skippedPackets {
-		if ackFrame.AcksPacket(p) {
-			return true
-		}
-	}
-	return false
-}
-
-func (h *sentPacketHandler) garbageCollectSkippedPackets() {
-	lowestUnacked := h.lowestUnacked()
-	deleteIndex := 0
-	for i, p := range h.skippedPackets {
-		if p < lowestUnacked {
-			deleteIndex = i + 1
-		}
-	}
-	h.skippedPackets = h.skippedPackets[deleteIndex:]
-}
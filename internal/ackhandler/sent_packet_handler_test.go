@@ -49,7 +49,7 @@ var _ = Describe("SentPacketHandler", func() {
 
 	BeforeEach(func() {
 		rttStats := &congestion.RTTStats{}
-		handler = NewSentPacketHandler(rttStats, utils.DefaultLogger, protocol.VersionWhatever).(*sentPacketHandler)
+		handler = NewSentPacketHandler(rttStats, congestion.DefaultClock{}, utils.DefaultLogger, protocol.VersionWhatever).(*sentPacketHandler)
 		handler.SetHandshakeComplete()
 		streamFrame = wire.StreamFrame{
 			StreamID: 5,
@@ -58,7 +58,7 @@ var _ = Describe("SentPacketHandler", func() {
 	})
 
 	getPacket := func(pn protocol.PacketNumber) *Packet {
-		if el, ok := handler.packetHistory.packetMap[pn]; ok {
+		if el, ok := handler.appDataPackets.history.packetMap[pn]; ok {
 			return &el.Value
 		}
 		return nil
@@ -67,7 +67,7 @@ var _ = Describe("SentPacketHandler", func() {
 	losePacket := func(pn protocol.PacketNumber) {
 		p := getPacket(pn)
 		ExpectWithOffset(1, p).ToNot(BeNil())
-		handler.queuePacketForRetransmission(p)
+		handler.queuePacketForRetransmission(p, handler.appDataPackets)
 		if p.includedInBytesInFlight {
 			p.includedInBytesInFlight = false
 			handler.bytesInFlight -= p.Length
@@ -78,9 +78,9 @@ var _ = Describe("SentPacketHandler", func() {
 	}
 
 	expectInPacketHistory := func(expected []protocol.PacketNumber) {
-		ExpectWithOffset(1, handler.packetHistory.Len()).To(Equal(len(expected)))
+		ExpectWithOffset(1, handler.appDataPackets.history.Len()).To(Equal(len(expected)))
 		for _, p := range expected {
-			ExpectWithOffset(1, handler.packetHistory.packetMap).To(HaveKey(p))
+			ExpectWithOffset(1, handler.appDataPackets.history.packetMap).To(HaveKey(p))
 		}
 	}
 
@@ -90,29 +90,29 @@ var _ = Describe("SentPacketHandler", func() {
 	}
 
 	It("determines the packet number length", func() {
-		handler.largestAcked = 0x1337
-		Expect(handler.GetPacketNumberLen(0x1338)).To(Equal(protocol.PacketNumberLen2))
-		Expect(handler.GetPacketNumberLen(0xfffffff)).To(Equal(protocol.PacketNumberLen4))
+		handler.appDataPackets.largestAcked = 0x1337
+		Expect(handler.GetPacketNumberLen(0x1338, protocol.EncryptionForwardSecure)).To(Equal(protocol.PacketNumberLen2))
+		Expect(handler.GetPacketNumberLen(0xfffffff, protocol.EncryptionForwardSecure)).To(Equal(protocol.PacketNumberLen4))
 	})
 
 	Context("registering sent packets", func() {
 		It("accepts two consecutive packets", func() {
 			handler.SentPacket(retransmittablePacket(&Packet{PacketNumber: 1}))
 			handler.SentPacket(retransmittablePacket(&Packet{PacketNumber: 2}))
-			Expect(handler.lastSentPacketNumber).To(Equal(protocol.PacketNumber(2)))
+			Expect(handler.appDataPackets.lastSentPacketNumber).To(Equal(protocol.PacketNumber(2)))
 			expectInPacketHistory([]protocol.PacketNumber{1, 2})
 			Expect(handler.bytesInFlight).To(Equal(protocol.ByteCount(2)))
-			Expect(handler.skippedPackets).To(BeEmpty())
+			Expect(handler.appDataPackets.skippedPackets).To(BeEmpty())
 		})
 
 		It("accepts packet number 0", func() {
 			handler.SentPacket(retransmittablePacket(&Packet{PacketNumber: 0}))
-			Expect(handler.lastSentPacketNumber).To(BeZero())
+			Expect(handler.appDataPackets.lastSentPacketNumber).To(BeZero())
 			handler.SentPacket(retransmittablePacket(&Packet{PacketNumber: 1}))
-			Expect(handler.lastSentPacketNumber).To(Equal(protocol.PacketNumber(1)))
+			Expect(handler.appDataPackets.lastSentPacketNumber).To(Equal(protocol.PacketNumber(1)))
 			expectInPacketHistory([]protocol.PacketNumber{0, 1})
 			Expect(handler.bytesInFlight).To(Equal(protocol.ByteCount(2)))
-			Expect(handler.skippedPackets).To(BeEmpty())
+			Expect(handler.appDataPackets.skippedPackets).To(BeEmpty())
 		})
 
 		It("stores the sent time", func() {
@@ -130,7 +130,7 @@ var _ = Describe("SentPacketHandler", func() {
 
 		It("does not store non-retransmittable packets", func() {
 			handler.SentPacket(nonRetransmittablePacket(&Packet{PacketNumber: 1}))
-			Expect(handler.packetHistory.Len()).To(BeZero())
+			Expect(handler.appDataPackets.history.Len()).To(BeZero())
 			Expect(handler.lastSentRetransmittablePacketTime).To(BeZero())
 			Expect(handler.bytesInFlight).To(BeZero())
 		})
@@ -139,59 +139,59 @@ var _ = Describe("SentPacketHandler", func() {
 			It("works with non-consecutive packet numbers", func() {
 				handler.SentPacket(retransmittablePacket(&Packet{PacketNumber: 1}))
 				handler.SentPacket(retransmittablePacket(&Packet{PacketNumber: 3}))
-				Expect(handler.lastSentPacketNumber).To(Equal(protocol.PacketNumber(3)))
+				Expect(handler.appDataPackets.lastSentPacketNumber).To(Equal(protocol.PacketNumber(3)))
 				expectInPacketHistory([]protocol.PacketNumber{1, 3})
-				Expect(handler.skippedPackets).To(Equal([]protocol.PacketNumber{2}))
+				Expect(handler.appDataPackets.skippedPackets).To(Equal([]protocol.PacketNumber{2}))
 			})
 
 			It("works with non-retransmittable packets", func() {
 				handler.SentPacket(nonRetransmittablePacket(&Packet{PacketNumber: 1}))
 				handler.SentPacket(nonRetransmittablePacket(&Packet{PacketNumber: 3}))
-				Expect(handler.skippedPackets).To(Equal([]protocol.PacketNumber{2}))
+				Expect(handler.appDataPackets.skippedPackets).To(Equal([]protocol.PacketNumber{2}))
 			})
 
 			It("recognizes multiple skipped packets", func() {
 				handler.SentPacket(retransmittablePacket(&Packet{PacketNumber: 1}))
 				handler.SentPacket(retransmittablePacket(&Packet{PacketNumber: 3}))
 				handler.SentPacket(retransmittablePacket(&Packet{PacketNumber: 5}))
-				Expect(handler.skippedPackets).To(Equal([]protocol.PacketNumber{2, 4}))
+				Expect(handler.appDataPackets.skippedPackets).To(Equal([]protocol.PacketNumber{2, 4}))
 			})
 
 			It("recognizes multiple consecutive skipped packets", func() {
 				handler.SentPacket(retransmittablePacket(&Packet{PacketNumber: 1}))
 				handler.SentPacket(retransmittablePacket(&Packet{PacketNumber: 4}))
-				Expect(handler.skippedPackets).To(Equal([]protocol.PacketNumber{2, 3}))
+				Expect(handler.appDataPackets.skippedPackets).To(Equal([]protocol.PacketNumber{2, 3}))
 			})
 
 			It("limits the lengths of the skipped packet slice", func() {
 				for i := protocol.PacketNumber(0); i < protocol.MaxTrackedSkippedPackets+5; i++ {
 					handler.SentPacket(retransmittablePacket(&Packet{PacketNumber: 2*i + 1}))
 				}
-				Expect(handler.skippedPackets).To(HaveLen(protocol.MaxUndecryptablePackets))
-				Expect(handler.skippedPackets[0]).To(Equal(protocol.PacketNumber(10)))
-				Expect(handler.skippedPackets[protocol.MaxTrackedSkippedPackets-1]).To(Equal(protocol.PacketNumber(10 + 2*(protocol.MaxTrackedSkippedPackets-1))))
+				Expect(handler.appDataPackets.skippedPackets).To(HaveLen(protocol.MaxUndecryptablePackets))
+				Expect(handler.appDataPackets.skippedPackets[0]).To(Equal(protocol.PacketNumber(10)))
+				Expect(handler.appDataPackets.skippedPackets[protocol.MaxTrackedSkippedPackets-1]).To(Equal(protocol.PacketNumber(10 + 2*(protocol.MaxTrackedSkippedPackets-1))))
 			})
 
 			Context("garbage collection", func() {
 				It("keeps all packet numbers above the LargestAcked", func() {
-					handler.skippedPackets = []protocol.PacketNumber{2, 5, 8, 10}
-					handler.largestAcked = 1
-					handler.garbageCollectSkippedPackets()
-					Expect(handler.skippedPackets).To(Equal([]protocol.PacketNumber{2, 5, 8, 10}))
+					handler.appDataPackets.skippedPackets = []protocol.PacketNumber{2, 5, 8, 10}
+					handler.appDataPackets.largestAcked = 1
+					handler.appDataPackets.garbageCollectSkippedPackets()
+					Expect(handler.appDataPackets.skippedPackets).To(Equal([]protocol.PacketNumber{2, 5, 8, 10}))
 				})
 
 				It("doesn't keep packet numbers below the LargestAcked", func() {
-					handler.skippedPackets = []protocol.PacketNumber{1, 5, 8, 10}
-					handler.largestAcked = 5
-					handler.garbageCollectSkippedPackets()
-					Expect(handler.skippedPackets).To(Equal([]protocol.PacketNumber{8, 10}))
+					handler.appDataPackets.skippedPackets = []protocol.PacketNumber{1, 5, 8, 10}
+					handler.appDataPackets.largestAcked = 5
+					handler.appDataPackets.garbageCollectSkippedPackets()
+					Expect(handler.appDataPackets.skippedPackets).To(Equal([]protocol.PacketNumber{8, 10}))
 				})
 
 				It("deletes all packet numbers if LargestAcked is sufficiently high", func() {
-					handler.skippedPackets = []protocol.PacketNumber{1, 5, 10}
-					handler.largestAcked = 15
-					handler.garbageCollectSkippedPackets()
-					Expect(handler.skippedPackets).To(BeEmpty())
+					handler.appDataPackets.skippedPackets = []protocol.PacketNumber{1, 5, 10}
+					handler.appDataPackets.largestAcked = 15
+					handler.appDataPackets.garbageCollectSkippedPackets()
+					Expect(handler.appDataPackets.skippedPackets).To(BeEmpty())
 				})
 			})
 
@@ -218,7 +218,7 @@ var _ = Describe("SentPacketHandler", func() {
 					}
 					err := handler.ReceivedAck(ack, 1337, protocol.EncryptionForwardSecure, time.Now())
 					Expect(err).ToNot(HaveOccurred())
-					Expect(handler.largestAcked).ToNot(BeZero())
+					Expect(handler.appDataPackets.largestAcked).ToNot(BeZero())
 				})
 			})
 		})
@@ -239,7 +239,7 @@ var _ = Describe("SentPacketHandler", func() {
 				ack := &wire.AckFrame{AckRanges: []wire.AckRange{{Smallest: 0, Largest: 5}}}
 				err := handler.ReceivedAck(ack, 0, protocol.EncryptionForwardSecure, time.Now())
 				Expect(err).ToNot(HaveOccurred())
-				Expect(handler.largestAcked).To(Equal(protocol.PacketNumber(5)))
+				Expect(handler.appDataPackets.largestAcked).To(Equal(protocol.PacketNumber(5)))
 			})
 
 			It("rejects duplicate ACKs", func() {
@@ -247,12 +247,12 @@ var _ = Describe("SentPacketHandler", func() {
 				ack2 := &wire.AckFrame{AckRanges: []wire.AckRange{{Smallest: 0, Largest: 4}}}
 				err := handler.ReceivedAck(ack1, 1337, protocol.EncryptionForwardSecure, time.Now())
 				Expect(err).ToNot(HaveOccurred())
-				Expect(handler.largestAcked).To(Equal(protocol.PacketNumber(3)))
+				Expect(handler.appDataPackets.largestAcked).To(Equal(protocol.PacketNumber(3)))
 				// this wouldn't happen in practice
 				// for testing purposes, we pretend send a different ACK frame in a duplicated packet, to be able to verify that it actually doesn't get processed
 				err = handler.ReceivedAck(ack2, 1337, protocol.EncryptionForwardSecure, time.Now())
 				Expect(err).ToNot(HaveOccurred())
-				Expect(handler.largestAcked).To(Equal(protocol.PacketNumber(3)))
+				Expect(handler.appDataPackets.largestAcked).To(Equal(protocol.PacketNumber(3)))
 			})
 
 			It("rejects out of order ACKs", func() {
@@ -265,7 +265,7 @@ var _ = Describe("SentPacketHandler", func() {
 				// a receiver wouldn't send an ACK for a lower largest acked in a packet sent later
 				err = handler.ReceivedAck(ack2, 1337-1, protocol.EncryptionForwardSecure, time.Now())
 				Expect(err).ToNot(HaveOccurred())
-				Expect(handler.largestAcked).To(Equal(protocol.PacketNumber(3)))
+				Expect(handler.appDataPackets.largestAcked).To(Equal(protocol.PacketNumber(3)))
 			})
 
 			It("rejects ACKs with a too high LargestAcked packet number", func() {
@@ -282,7 +282,7 @@ var _ = Describe("SentPacketHandler", func() {
 				Expect(handler.bytesInFlight).To(Equal(protocol.ByteCount(7)))
 				err = handler.ReceivedAck(ack, 1337+1, protocol.EncryptionForwardSecure, time.Now())
 				Expect(err).ToNot(HaveOccurred())
-				Expect(handler.largestAcked).To(Equal(protocol.PacketNumber(3)))
+				Expect(handler.appDataPackets.largestAcked).To(Equal(protocol.PacketNumber(3)))
 				Expect(handler.bytesInFlight).To(Equal(protocol.ByteCount(7)))
 			})
 		})
@@ -292,7 +292,7 @@ var _ = Describe("SentPacketHandler", func() {
 				ack := &wire.AckFrame{AckRanges: []wire.AckRange{{Smallest: 0, Largest: 5}}}
 				err := handler.ReceivedAck(ack, 1, protocol.EncryptionForwardSecure, time.Now())
 				Expect(err).ToNot(HaveOccurred())
-				Expect(handler.largestAcked).To(Equal(protocol.PacketNumber(5)))
+				Expect(handler.appDataPackets.largestAcked).To(Equal(protocol.PacketNumber(5)))
 				expectInPacketHistory([]protocol.PacketNumber{6, 7, 8, 9})
 				Expect(handler.bytesInFlight).To(Equal(protocol.ByteCount(4)))
 			})
@@ -512,7 +512,7 @@ var _ = Describe("SentPacketHandler", func() {
 			}
 			err := handler.ReceivedAck(ack, 1, protocol.EncryptionForwardSecure, time.Now())
 			Expect(err).ToNot(HaveOccurred())
-			Expect(handler.packetHistory.Len()).To(BeZero())
+			Expect(handler.appDataPackets.history.Len()).To(BeZero())
 			Expect(handler.bytesInFlight).To(BeZero())
 		})
 	})
@@ -536,7 +536,7 @@ var _ = Describe("SentPacketHandler", func() {
 
 			It("gets a STOP_WAITING frame after queueing a retransmission", func() {
 				handler.SentPacket(retransmittablePacket(&Packet{PacketNumber: 5}))
-				handler.queuePacketForRetransmission(getPacket(5))
+				handler.queuePacketForRetransmission(getPacket(5), handler.appDataPackets)
 				Expect(handler.GetStopWaitingFrame(false)).To(Equal(&wire.StopWaitingFrame{LeastUnacked: 6}))
 			})
 		})
@@ -870,7 +870,7 @@ var _ = Describe("SentPacketHandler", func() {
 			handler.SentPacket(retransmittablePacket(&Packet{PacketNumber: 2}))
 
 			updateRTT(time.Hour)
-			Expect(handler.lossTime.IsZero()).To(BeTrue())
+			Expect(handler.appDataPackets.lossTime.IsZero()).To(BeTrue())
 			Expect(time.Until(handler.GetAlarmTimeout())).To(BeNumerically("~", handler.computeRTOTimeout(), time.Minute))
 
 			handler.OnAlarm() // TLP
@@ -908,7 +908,7 @@ var _ = Describe("SentPacketHandler", func() {
 			ack := &wire.AckFrame{AckRanges: []wire.AckRange{{Smallest: 3, Largest: 3}}}
 			err = handler.ReceivedAck(ack, 1, protocol.EncryptionForwardSecure, time.Now())
 			Expect(err).ToNot(HaveOccurred())
-			Expect(handler.packetHistory.Len()).To(BeZero())
+			Expect(handler.appDataPackets.history.Len()).To(BeZero())
 			Expect(handler.bytesInFlight).To(BeZero())
 			Expect(handler.retransmissionQueue).To(BeEmpty()) // 1 and 2 were already sent as probe packets
 		})
@@ -947,7 +947,7 @@ var _ = Describe("SentPacketHandler", func() {
 			ack := &wire.AckFrame{AckRanges: []wire.AckRange{{Smallest: 6, Largest: 6}}}
 			err = handler.ReceivedAck(ack, 1, protocol.EncryptionForwardSecure, time.Now())
 			Expect(err).ToNot(HaveOccurred())
-			Expect(handler.packetHistory.Len()).To(BeZero())
+			Expect(handler.appDataPackets.history.Len()).To(BeZero())
 			Expect(handler.bytesInFlight).To(BeZero())
 			Expect(handler.retransmissionQueue).To(HaveLen(3)) // packets 3, 4, 5
 		})
@@ -981,7 +981,7 @@ var _ = Describe("SentPacketHandler", func() {
 			now := time.Now()
 			handler.SentPacket(retransmittablePacket(&Packet{PacketNumber: 1, SendTime: now.Add(-time.Hour)}))
 			handler.SentPacket(retransmittablePacket(&Packet{PacketNumber: 2, SendTime: now.Add(-time.Second)}))
-			Expect(handler.lossTime.IsZero()).To(BeTrue())
+			Expect(handler.appDataPackets.lossTime.IsZero()).To(BeTrue())
 
 			ack := &wire.AckFrame{AckRanges: []wire.AckRange{{Smallest: 2, Largest: 2}}}
 			err := handler.ReceivedAck(ack, 1, protocol.EncryptionForwardSecure, now)
@@ -989,7 +989,7 @@ var _ = Describe("SentPacketHandler", func() {
 			Expect(handler.DequeuePacketForRetransmission()).ToNot(BeNil())
 			Expect(handler.DequeuePacketForRetransmission()).To(BeNil())
 			// no need to set an alarm, since packet 1 was already declared lost
-			Expect(handler.lossTime.IsZero()).To(BeTrue())
+			Expect(handler.appDataPackets.lossTime.IsZero()).To(BeTrue())
 			Expect(handler.bytesInFlight).To(BeZero())
 		})
 
@@ -998,7 +998,7 @@ var _ = Describe("SentPacketHandler", func() {
 			handler.SentPacket(retransmittablePacket(&Packet{PacketNumber: 1, SendTime: now.Add(-2 * time.Second)}))
 			handler.SentPacket(retransmittablePacket(&Packet{PacketNumber: 2, SendTime: now.Add(-2 * time.Second)}))
 			handler.SentPacket(retransmittablePacket(&Packet{PacketNumber: 3, SendTime: now.Add(-time.Second)}))
-			Expect(handler.lossTime.IsZero()).To(BeTrue())
+			Expect(handler.appDataPackets.lossTime.IsZero()).To(BeTrue())
 
 			ack := &wire.AckFrame{AckRanges: []wire.AckRange{{Smallest: 2, Largest: 2}}}
 			err := handler.ReceivedAck(ack, 1, protocol.EncryptionForwardSecure, now.Add(-time.Second))
@@ -1006,8 +1006,8 @@ var _ = Describe("SentPacketHandler", func() {
 			Expect(handler.rttStats.SmoothedRTT()).To(Equal(time.Second))
 
 			// Packet 1 should be considered lost (1+1/8) RTTs after it was sent.
-			Expect(handler.lossTime.IsZero()).To(BeFalse())
-			Expect(handler.lossTime.Sub(getPacket(1).SendTime)).To(Equal(time.Second * 9 / 8))
+			Expect(handler.appDataPackets.lossTime.IsZero()).To(BeFalse())
+			Expect(handler.appDataPackets.lossTime.Sub(getPacket(1).SendTime)).To(Equal(time.Second * 9 / 8))
 
 			err = handler.OnAlarm()
 			Expect(err).ToNot(HaveOccurred())
@@ -1015,6 +1015,28 @@ var _ = Describe("SentPacketHandler", func() {
 			// make sure this is not an RTO: only packet 1 is retransmissted
 			Expect(handler.DequeuePacketForRetransmission()).To(BeNil())
 		})
+
+		It("detects packets as lost when the early retransmit alarm fires exactly at the loss time", func() {
+			clock := utils.NewVirtualClock(time.Now())
+			handler.clock = clock
+			now := clock.Now()
+			handler.SentPacket(retransmittablePacket(&Packet{PacketNumber: 1, SendTime: now}))
+			handler.SentPacket(retransmittablePacket(&Packet{PacketNumber: 2, SendTime: now}))
+			handler.SentPacket(retransmittablePacket(&Packet{PacketNumber: 3, SendTime: now.Add(time.Second)}))
+			ack := &wire.AckFrame{AckRanges: []wire.AckRange{{Smallest: 2, Largest: 2}}}
+			err := handler.ReceivedAck(ack, 1, protocol.EncryptionForwardSecure, now.Add(time.Second))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(handler.rttStats.SmoothedRTT()).To(Equal(time.Second))
+			Expect(handler.GetAlarmTimeout()).To(Equal(now.Add(time.Second * 9 / 8)))
+
+			clock.Advance(time.Second * 9 / 8)
+			Expect(clock.Now()).To(Equal(handler.GetAlarmTimeout()))
+			Expect(handler.OnAlarm()).To(Succeed())
+			p := handler.DequeuePacketForRetransmission()
+			Expect(p).ToNot(BeNil())
+			Expect(p.PacketNumber).To(Equal(protocol.PacketNumber(1)))
+			Expect(handler.appDataPackets.lossTime.IsZero()).To(BeTrue())
+		})
 	})
 
 	Context("handshake packets", func() {
@@ -1037,7 +1059,7 @@ var _ = Describe("SentPacketHandler", func() {
 			// RTT is now 1 minute
 			Expect(handler.rttStats.SmoothedRTT()).To(Equal(time.Minute))
 			Expect(err).NotTo(HaveOccurred())
-			Expect(handler.lossTime.IsZero()).To(BeTrue())
+			Expect(handler.appDataPackets.lossTime.IsZero()).To(BeTrue())
 			Expect(handler.GetAlarmTimeout().Sub(sendTime)).To(Equal(2 * time.Minute))
 
 			err = handler.OnAlarm()
@@ -1069,12 +1091,93 @@ var _ = Describe("SentPacketHandler", func() {
 				p.EncryptionLevel = protocol.EncryptionSecure
 				handler.SentPacket(p)
 			}
-			handler.queuePacketForRetransmission(getPacket(1))
-			handler.queuePacketForRetransmission(getPacket(3))
+			handler.queuePacketForRetransmission(getPacket(1), handler.appDataPackets)
+			handler.queuePacketForRetransmission(getPacket(3), handler.appDataPackets)
 			handler.SetHandshakeComplete()
-			Expect(handler.packetHistory.Len()).To(BeZero())
+			Expect(handler.appDataPackets.history.Len()).To(BeZero())
 			packet := handler.DequeuePacketForRetransmission()
 			Expect(packet).To(BeNil())
 		})
 	})
+
+	Context("with packet number spaces", func() {
+		BeforeEach(func() {
+			handler = NewSentPacketHandler(&congestion.RTTStats{}, congestion.DefaultClock{}, utils.DefaultLogger, protocol.Version1).(*sentPacketHandler)
+		})
+
+		packetWithLevel := func(pn protocol.PacketNumber, encLevel protocol.EncryptionLevel) *Packet {
+			p := retransmittablePacket(&Packet{PacketNumber: pn})
+			p.EncryptionLevel = encLevel
+			return p
+		}
+
+		It("uses separate packet number spaces", func() {
+			handler.SentPacket(packetWithLevel(0, protocol.EncryptionInitial))
+			handler.SentPacket(packetWithLevel(0, protocol.EncryptionHandshake))
+			handler.SentPacket(packetWithLevel(0, protocol.EncryptionForwardSecure))
+			Expect(handler.initialPackets.history.Len()).To(Equal(1))
+			Expect(handler.handshakePackets.history.Len()).To(Equal(1))
+			Expect(handler.appDataPackets.history.Len()).To(Equal(1))
+			Expect(handler.bytesInFlight).To(Equal(protocol.ByteCount(3)))
+			ack := &wire.AckFrame{AckRanges: []wire.AckRange{{Smallest: 0, Largest: 0}}}
+			Expect(handler.ReceivedAck(ack, 0, protocol.EncryptionHandshake, time.Now())).To(Succeed())
+			Expect(handler.initialPackets.history.Len()).To(Equal(1))
+			Expect(handler.handshakePackets.history.Len()).To(BeZero())
+			Expect(handler.appDataPackets.history.Len()).To(Equal(1))
+			Expect(handler.bytesInFlight).To(Equal(protocol.ByteCount(2)))
+		})
+
+		It("rejects ACKs for packets that were only sent in a different packet number space", func() {
+			handler.SentPacket(packetWithLevel(0, protocol.EncryptionInitial))
+			handler.SentPacket(packetWithLevel(1, protocol.EncryptionInitial))
+			handler.SentPacket(packetWithLevel(0, protocol.EncryptionHandshake))
+			ack := &wire.AckFrame{AckRanges: []wire.AckRange{{Smallest: 0, Largest: 1}}}
+			err := handler.ReceivedAck(ack, 0, protocol.EncryptionHandshake, time.Now())
+			Expect(err).To(MatchError("InvalidAckData: Received ACK for an unsent package"))
+		})
+
+		It("uses a short packet number for the first packet in a packet number space", func() {
+			Expect(handler.GetPacketNumberLen(0, protocol.EncryptionInitial)).To(Equal(protocol.PacketNumberLen2))
+			Expect(handler.GetPacketNumberLen(0, protocol.EncryptionHandshake)).To(Equal(protocol.PacketNumberLen2))
+			Expect(handler.GetPacketNumberLen(0, protocol.EncryptionForwardSecure)).To(Equal(protocol.PacketNumberLen2))
+		})
+
+		It("drops packets", func() {
+			handler.SentPacket(packetWithLevel(0, protocol.EncryptionInitial))
+			handler.SentPacket(packetWithLevel(1, protocol.EncryptionInitial))
+			handler.SentPacket(packetWithLevel(0, protocol.EncryptionHandshake))
+			handler.queuePacketForRetransmission(handler.initialPackets.history.GetPacket(1), handler.initialPackets)
+			Expect(handler.bytesInFlight).To(Equal(protocol.ByteCount(3)))
+			handler.DropPackets(protocol.EncryptionInitial)
+			Expect(handler.initialPackets).To(BeNil())
+			Expect(handler.bytesInFlight).To(Equal(protocol.ByteCount(1)))
+			Expect(handler.DequeuePacketForRetransmission()).To(BeNil())
+			// ACKs for the dropped packet number space are ignored
+			ack := &wire.AckFrame{AckRanges: []wire.AckRange{{Smallest: 0, Largest: 1}}}
+			Expect(handler.ReceivedAck(ack, 0, protocol.EncryptionInitial, time.Now())).To(Succeed())
+			// packets sent in a dropped packet number space are ignored
+			handler.SentPacket(packetWithLevel(2, protocol.EncryptionInitial))
+			Expect(handler.bytesInFlight).To(Equal(protocol.ByteCount(1)))
+		})
+
+		It("sends probe packets for the lowest encryption level first", func() {
+			handler.SentPacket(packetWithLevel(0, protocol.EncryptionForwardSecure))
+			handler.SentPacket(packetWithLevel(0, protocol.EncryptionHandshake))
+			p, err := handler.DequeueProbePacket()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(p.EncryptionLevel).To(Equal(protocol.EncryptionHandshake))
+		})
+
+		It("arms the handshake alarm for Initial and Handshake packets", func() {
+			sendTime := time.Now().Add(-time.Hour)
+			p := packetWithLevel(0, protocol.EncryptionHandshake)
+			p.SendTime = sendTime
+			handler.SentPacket(p)
+			Expect(handler.GetAlarmTimeout()).To(Equal(sendTime.Add(handler.computeHandshakeTimeout())))
+			Expect(handler.OnAlarm()).To(Succeed())
+			p = handler.DequeuePacketForRetransmission()
+			Expect(p).ToNot(BeNil())
+			Expect(p.EncryptionLevel).To(Equal(protocol.EncryptionHandshake))
+		})
+	})
 })
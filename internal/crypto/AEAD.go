@@ -8,3 +8,17 @@ type AEAD interface {
 	Seal(dst, src []byte, packetNumber protocol.PacketNumber, associatedData []byte) []byte
 	Overhead() int
 }
+
+// An UpdatableAEAD is an AEAD that can derive the AEAD used after a key update
+type UpdatableAEAD interface {
+	AEAD
+	// Next derives the AEAD for the next key phase.
+	Next() (UpdatableAEAD, error)
+}
+
+// A HeaderProtectingAEAD is an AEAD that is used together with header protection
+type HeaderProtectingAEAD interface {
+	AEAD
+	// HeaderProtector returns the HeaderProtector used for packets protected by this AEAD.
+	HeaderProtector() HeaderProtector
+}
@@ -0,0 +1,63 @@
+package crypto
+
+import (
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+
+	"golang.org/x/crypto/chacha20poly1305"
+
+	"github.com/wheelcomplex/qk/internal/protocol"
+)
+
+type aeadChaCha20Poly1305 struct {
+	otherIV   []byte
+	myIV      []byte
+	encrypter cipher.AEAD
+	decrypter cipher.AEAD
+}
+
+var _ AEAD = &aeadChaCha20Poly1305{}
+
+// NewAEADChaCha20Poly1305 creates a AEAD using ChaCha20-Poly1305, as used by IETF QUIC.
+// The nonce is constructed in the same way as for AES-GCM.
+func NewAEADChaCha20Poly1305(otherKey []byte, myKey []byte, otherIV []byte, myIV []byte) (AEAD, error) {
+	if len(otherIV) != ivLen || len(myIV) != ivLen {
+		return nil, errors.New("ChaCha20-Poly1305: expected 12 byte IVs")
+	}
+	encrypter, err := chacha20poly1305.New(myKey)
+	if err != nil {
+		return nil, err
+	}
+	decrypter, err := chacha20poly1305.New(otherKey)
+	if err != nil {
+		return nil, err
+	}
+	return &aeadChaCha20Poly1305{
+		otherIV:   otherIV,
+		myIV:      myIV,
+		encrypter: encrypter,
+		decrypter: decrypter,
+	}, nil
+}
+
+func (aead *aeadChaCha20Poly1305) Open(dst, src []byte, packetNumber protocol.PacketNumber, associatedData []byte) ([]byte, error) {
+	return aead.decrypter.Open(dst, aead.makeNonce(aead.otherIV, packetNumber), src, associatedData)
+}
+
+func (aead *aeadChaCha20Poly1305) Seal(dst, src []byte, packetNumber protocol.PacketNumber, associatedData []byte) []byte {
+	return aead.encrypter.Seal(dst, aead.makeNonce(aead.myIV, packetNumber), src, associatedData)
+}
+
+func (aead *aeadChaCha20Poly1305) makeNonce(iv []byte, packetNumber protocol.PacketNumber) []byte {
+	nonce := make([]byte, ivLen)
+	binary.BigEndian.PutUint64(nonce[ivLen-8:], uint64(packetNumber))
+	for i := 0; i < ivLen; i++ {
+		nonce[i] ^= iv[i]
+	}
+	return nonce
+}
+
+func (aead *aeadChaCha20Poly1305) Overhead() int {
+	return aead.encrypter.Overhead()
+}
@@ -0,0 +1,76 @@
+package crypto
+
+import (
+	"crypto/rand"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ChaCha20-Poly1305 AEAD", func() {
+	var alice, bob AEAD
+
+	BeforeEach(func() {
+		keyAlice := make([]byte, 32)
+		keyBob := make([]byte, 32)
+		ivAlice := make([]byte, 12)
+		ivBob := make([]byte, 12)
+		rand.Read(keyAlice)
+		rand.Read(keyBob)
+		rand.Read(ivAlice)
+		rand.Read(ivBob)
+		var err error
+		alice, err = NewAEADChaCha20Poly1305(keyBob, keyAlice, ivBob, ivAlice)
+		Expect(err).ToNot(HaveOccurred())
+		bob, err = NewAEADChaCha20Poly1305(keyAlice, keyBob, ivAlice, ivBob)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("seals and opens", func() {
+		b := alice.Seal(nil, []byte("foobar"), 42, []byte("aad"))
+		Expect(b).To(HaveLen(6 + alice.Overhead()))
+		text, err := bob.Open(nil, b, 42, []byte("aad"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(text).To(Equal([]byte("foobar")))
+	})
+
+	It("seals a packet of RFC 9001, Appendix A.5", func() {
+		key := decodeHex("c6d98ff3441c3fe1b2182094f69caa2ed4b716b65488960a7a984979fb23e1c8")
+		iv := decodeHex("e0459b3474bdd0e44a41c144")
+		aead, err := NewAEADChaCha20Poly1305(key, key, iv, iv)
+		Expect(err).ToNot(HaveOccurred())
+		sealed := aead.Seal(nil, []byte{0x01}, 654360564, decodeHex("4200bff4"))
+		Expect(sealed).To(Equal(decodeHex("655e5cd55c41f69080575d7999c25a5bfb")))
+		opened, err := aead.Open(nil, sealed, 654360564, decodeHex("4200bff4"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(opened).To(Equal([]byte{0x01}))
+	})
+
+	It("fails to open a message if the associated data is not the same", func() {
+		b := alice.Seal(nil, []byte("foobar"), 42, []byte("aad"))
+		_, err := bob.Open(nil, b, 42, []byte("aad2"))
+		Expect(err).To(MatchError("chacha20poly1305: message authentication failed"))
+	})
+
+	It("uses the packet number in the nonce", func() {
+		b := alice.Seal(nil, []byte("foobar"), 42, []byte("aad"))
+		_, err := bob.Open(nil, b, 43, []byte("aad"))
+		Expect(err).To(MatchError("chacha20poly1305: message authentication failed"))
+	})
+
+	It("uses different keys for both directions", func() {
+		b := alice.Seal(nil, []byte("foobar"), 42, []byte("aad"))
+		_, err := alice.Open(nil, b, 42, []byte("aad"))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("errors when the key has the wrong length", func() {
+		_, err := NewAEADChaCha20Poly1305(make([]byte, 16), make([]byte, 32), make([]byte, 12), make([]byte, 12))
+		Expect(err).To(MatchError("chacha20poly1305: bad key length"))
+	})
+
+	It("errors when the IV has the wrong length", func() {
+		_, err := NewAEADChaCha20Poly1305(make([]byte, 32), make([]byte, 32), make([]byte, 8), make([]byte, 12))
+		Expect(err).To(MatchError("ChaCha20-Poly1305: expected 12 byte IVs"))
+	})
+})
@@ -0,0 +1,148 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+
+	"golang.org/x/crypto/chacha20"
+)
+
+const (
+	// HeaderProtectionSampleOffset is the offset of the sample, counted from the beginning of the packet number
+	HeaderProtectionSampleOffset = 4
+	// HeaderProtectionSampleLen is the length of the sample of the ciphertext used to compute the mask
+	HeaderProtectionSampleLen = 16
+)
+
+// A HeaderProtector protects the packet number and the key phase bit of a packet.
+// The mask is computed from a sample of the ciphertext of the packet.
+type HeaderProtector interface {
+	// EncryptHeader applies header protection, using our key
+	EncryptHeader(sample []byte, firstByte *byte, pnBytes []byte)
+	// DecryptHeader removes header protection, using the key of the peer
+	DecryptHeader(sample []byte, firstByte *byte, pnBytes []byte)
+}
+
+type maskFunc func(sample []byte) []byte
+
+type headerProtector struct {
+	myMask    maskFunc
+	otherMask maskFunc
+	// QUIC v1 protects the packet number length and uses a different key phase bit, see RFC 9001, section 5.4.1
+	isV1 bool
+}
+
+var _ HeaderProtector = &headerProtector{}
+
+// NewAESHeaderProtector creates a HeaderProtector using AES-ECB
+func NewAESHeaderProtector(otherKey, myKey []byte) (HeaderProtector, error) {
+	myBlock, err := aes.NewCipher(myKey)
+	if err != nil {
+		return nil, err
+	}
+	otherBlock, err := aes.NewCipher(otherKey)
+	if err != nil {
+		return nil, err
+	}
+	return &headerProtector{
+		myMask:    aesMask(myBlock),
+		otherMask: aesMask(otherBlock),
+	}, nil
+}
+
+// NewChaChaHeaderProtector creates a HeaderProtector using ChaCha20
+func NewChaChaHeaderProtector(otherKey, myKey []byte) (HeaderProtector, error) {
+	if len(myKey) != 32 || len(otherKey) != 32 {
+		return nil, errors.New("chacha20: expected 32-byte keys")
+	}
+	return &headerProtector{
+		myMask:    chachaMask(myKey),
+		otherMask: chachaMask(otherKey),
+	}, nil
+}
+
+func aesMask(block cipher.Block) maskFunc {
+	return func(sample []byte) []byte {
+		mask := make([]byte, block.BlockSize())
+		block.Encrypt(mask, sample)
+		return mask
+	}
+}
+
+func chachaMask(key []byte) maskFunc {
+	return func(sample []byte) []byte {
+		// the first 4 bytes of the sample are the block counter, the remaining 12 bytes the nonce
+		c, err := chacha20.NewUnauthenticatedCipher(key, sample[4:16])
+		if err != nil {
+			// the length of the key is checked when creating the HeaderProtector
+			panic(err)
+		}
+		c.SetCounter(binary.LittleEndian.Uint32(sample[:4]))
+		mask := make([]byte, 5)
+		c.XORKeyStream(mask, mask)
+		return mask
+	}
+}
+
+func (h *headerProtector) EncryptHeader(sample []byte, firstByte *byte, pnBytes []byte) {
+	if h.isV1 {
+		// the packet number length is read from the unprotected first byte
+		mask := h.myMask(sample)
+		pnLen := int(*firstByte&0x3) + 1
+		applyHeaderProtectionMaskV1(mask, firstByte)
+		xorPacketNumber(mask, pnBytes[:pnLen])
+		return
+	}
+	applyHeaderProtectionMask(h.myMask(sample), firstByte, pnBytes)
+}
+
+// DecryptHeader removes header protection.
+// For QUIC v1, the length of the packet number is only known after unprotecting the first byte.
+// pnBytes then needs to contain 4 bytes, and only the bytes of the packet number are modified.
+func (h *headerProtector) DecryptHeader(sample []byte, firstByte *byte, pnBytes []byte) {
+	if h.isV1 {
+		mask := h.otherMask(sample)
+		applyHeaderProtectionMaskV1(mask, firstByte)
+		pnLen := int(*firstByte&0x3) + 1
+		xorPacketNumber(mask, pnBytes[:pnLen])
+		return
+	}
+	applyHeaderProtectionMask(h.otherMask(sample), firstByte, pnBytes)
+}
+
+func applyHeaderProtectionMask(mask []byte, firstByte *byte, pnBytes []byte) {
+	// For the Short Header, the key phase bit is protected.
+	// The first byte of the Long Header is not protected.
+	if *firstByte&0x80 == 0 {
+		*firstByte ^= mask[0] & 0x40
+	}
+	// The first bits of the packet number encode its length.
+	// They are not protected, so that the header can be parsed before removing header protection.
+	if pnBytes[0]&0x80 == 0 {
+		pnBytes[0] ^= mask[1] & 0x7f
+	} else {
+		pnBytes[0] ^= mask[1] & 0x3f
+	}
+	for i := 1; i < len(pnBytes); i++ {
+		pnBytes[i] ^= mask[i+1]
+	}
+}
+
+// applyHeaderProtectionMaskV1 protects the first byte of a QUIC v1 packet.
+// For the Long Header, the reserved bits and the packet number length are protected.
+// For the Short Header, the reserved bits, the key phase bit and the packet number length are protected.
+func applyHeaderProtectionMaskV1(mask []byte, firstByte *byte) {
+	if *firstByte&0x80 > 0 {
+		*firstByte ^= mask[0] & 0xf
+	} else {
+		*firstByte ^= mask[0] & 0x1f
+	}
+}
+
+func xorPacketNumber(mask []byte, pnBytes []byte) {
+	for i := range pnBytes {
+		pnBytes[i] ^= mask[i+1]
+	}
+}
@@ -0,0 +1,114 @@
+package crypto
+
+import (
+	"encoding/hex"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func decodeHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	Expect(err).ToNot(HaveOccurred())
+	return b
+}
+
+var _ = Describe("Header Protection", func() {
+	Context("using AES", func() {
+		It("computes the mask", func() {
+			// test vector from RFC 9001, Appendix A.2
+			key := decodeHex("9f50449e04a0e810283a1e9933adedd2")
+			hp, err := NewAESHeaderProtector(key, key)
+			Expect(err).ToNot(HaveOccurred())
+			mask := hp.(*headerProtector).myMask(decodeHex("d1b1c98dd7689fb8ec11d242b123dc9b"))
+			Expect(mask[:5]).To(Equal(decodeHex("437b9aec36")))
+		})
+
+		It("errors when the key has the wrong length", func() {
+			_, err := NewAESHeaderProtector(make([]byte, 16), make([]byte, 15))
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("using ChaCha20", func() {
+		It("uses the sample as block counter and nonce", func() {
+			// test vector from RFC 7539, section 2.3.2: block counter 1, followed by the nonce
+			key := decodeHex("000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f")
+			hp, err := NewChaChaHeaderProtector(key, key)
+			Expect(err).ToNot(HaveOccurred())
+			mask := hp.(*headerProtector).myMask(decodeHex("01000000000000090000004a00000000"))
+			Expect(mask).To(Equal(decodeHex("10f1e7e4d1")))
+		})
+
+		It("computes the mask", func() {
+			// test vector from RFC 9001, Appendix A.5
+			key := decodeHex("25a282b9e82f06f21f488917a4fc8f1b73573685608597d0efcb076b0ab7a7a4")
+			hp, err := NewChaChaHeaderProtector(key, key)
+			Expect(err).ToNot(HaveOccurred())
+			mask := hp.(*headerProtector).myMask(decodeHex("5e5cd55c41f69080575d7999c25a5bfb"))
+			Expect(mask).To(Equal(decodeHex("aefefe7d03")))
+		})
+
+		It("errors when the key has the wrong length", func() {
+			_, err := NewChaChaHeaderProtector(make([]byte, 32), make([]byte, 16))
+			Expect(err).To(MatchError("chacha20: expected 32-byte keys"))
+		})
+	})
+
+	Context("protecting headers", func() {
+		var client, server HeaderProtector
+		sample := decodeHex("d1b1c98dd7689fb8ec11d242b123dc9b")
+
+		BeforeEach(func() {
+			clientKey := decodeHex("9f50449e04a0e810283a1e9933adedd2")
+			serverKey := decodeHex("c206b8d9b9f0f37644430b490eeaa314")
+			var err error
+			client, err = NewAESHeaderProtector(serverKey, clientKey)
+			Expect(err).ToNot(HaveOccurred())
+			server, err = NewAESHeaderProtector(clientKey, serverKey)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("protects the key phase bit of the Short Header", func() {
+			// the first byte of the mask is 0x43
+			firstByte := byte(0x30)
+			pn := []byte{0x12}
+			client.EncryptHeader(sample, &firstByte, pn)
+			Expect(firstByte).To(Equal(byte(0x70)))
+			server.DecryptHeader(sample, &firstByte, pn)
+			Expect(firstByte).To(Equal(byte(0x30)))
+			Expect(pn).To(Equal([]byte{0x12}))
+		})
+
+		It("doesn't protect the first byte of the Long Header", func() {
+			firstByte := byte(0xff)
+			client.EncryptHeader(sample, &firstByte, []byte{0x12})
+			Expect(firstByte).To(Equal(byte(0xff)))
+		})
+
+		It("protects packet numbers, without changing their length", func() {
+			for _, pn := range [][]byte{{0x12}, {0x81, 0x23}, {0xc1, 0x23, 0x45, 0x67}} {
+				lenBits := byte(0xc0)
+				if len(pn) == 1 {
+					lenBits = 0x80
+				}
+				firstByte := byte(0x30)
+				protected := make([]byte, len(pn))
+				copy(protected, pn)
+				client.EncryptHeader(sample, &firstByte, protected)
+				Expect(protected).ToNot(Equal(pn))
+				Expect(protected[0] & lenBits).To(Equal(pn[0] & lenBits))
+				server.DecryptHeader(sample, &firstByte, protected)
+				Expect(protected).To(Equal(pn))
+			}
+		})
+
+		It("uses different keys for both directions", func() {
+			firstByte := byte(0x30)
+			pn := []byte{0xc1, 0x23, 0x45, 0x67}
+			client.EncryptHeader(sample, &firstByte, pn)
+			client.DecryptHeader(sample, &firstByte, pn)
+			Expect(pn).ToNot(Equal([]byte{0xc1, 0x23, 0x45, 0x67}))
+		})
+	})
+})
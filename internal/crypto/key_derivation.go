@@ -2,6 +2,7 @@ package crypto
 
 import (
 	"crypto"
+	"crypto/tls"
 	"encoding/binary"
 
 	"github.com/bifurcation/mint"
@@ -11,6 +12,9 @@ import (
 const (
 	clientExporterLabel = "EXPORTER-QUIC client 1rtt"
 	serverExporterLabel = "EXPORTER-QUIC server 1rtt"
+
+	keyUpdateLabel        = "update"
+	headerProtectionLabel = "hp"
 )
 
 // A TLSExporter gets the negotiated ciphersuite and computes exporter
@@ -20,14 +24,24 @@ type TLSExporter interface {
 }
 
 func qhkdfExpand(secret []byte, label string, length int) []byte {
+	return qhkdfExpandHash(crypto.SHA256, secret, label, length)
+}
+
+// qhkdfExpandHash is qhkdfExpand using the hash function of the cipher suite
+func qhkdfExpandHash(hash crypto.Hash, secret []byte, label string, length int) []byte {
 	qlabel := make([]byte, 2+1+5+len(label))
 	binary.BigEndian.PutUint16(qlabel[0:2], uint16(length))
 	qlabel[2] = uint8(5 + len(label))
 	copy(qlabel[3:], []byte("QUIC "+label))
-	return mint.HkdfExpand(crypto.SHA256, secret, qlabel, length)
+	return mint.HkdfExpand(hash, secret, qlabel, length)
+}
+
+func computeHeaderProtectionKey(secret []byte, keyLen int) []byte {
+	return qhkdfExpand(secret, headerProtectionLabel, keyLen)
 }
 
-// DeriveAESKeys derives the AES keys and creates a matching AES-GCM AEAD instance
+// DeriveAESKeys derives the AES keys and creates a matching AES-GCM AEAD instance.
+// The AEAD returned implements UpdatableAEAD.
 func DeriveAESKeys(tls TLSExporter, pers protocol.Perspective) (AEAD, error) {
 	var myLabel, otherLabel string
 	if pers == protocol.PerspectiveClient {
@@ -37,24 +51,118 @@ func DeriveAESKeys(tls TLSExporter, pers protocol.Perspective) (AEAD, error) {
 		myLabel = serverExporterLabel
 		otherLabel = clientExporterLabel
 	}
-	myKey, myIV, err := computeKeyAndIV(tls, myLabel)
+	cs := tls.ConnectionState().CipherSuite
+	mySecret, err := tls.ComputeExporter(myLabel, nil, cs.Hash.Size())
 	if err != nil {
 		return nil, err
 	}
-	otherKey, otherIV, err := computeKeyAndIV(tls, otherLabel)
+	otherSecret, err := tls.ComputeExporter(otherLabel, nil, cs.Hash.Size())
 	if err != nil {
 		return nil, err
 	}
-	return NewAEADAESGCM(otherKey, myKey, otherIV, myIV)
+	hp, err := newHeaderProtector(uint16(cs.Suite), crypto.SHA256, otherSecret, mySecret, cs.KeyLen)
+	if err != nil {
+		return nil, err
+	}
+	return newUpdatableAEADAESGCM(otherSecret, mySecret, cs.KeyLen, cs.IvLen, hp)
 }
 
-func computeKeyAndIV(tls TLSExporter, label string) (key, iv []byte, err error) {
-	cs := tls.ConnectionState().CipherSuite
-	secret, err := tls.ComputeExporter(label, nil, cs.Hash.Size())
+// DeriveAESKeysFromSecrets creates the AEAD for the cipher suite from the 1-RTT traffic secrets of both endpoints.
+// This is used with TLS stacks that export the traffic secrets, instead of an exporter.
+// The keys are expanded using the hash function of the cipher suite,
+// and the AEAD and the header protection use AES-GCM or ChaCha20-Poly1305, as determined by the cipher suite.
+// The AEAD returned implements UpdatableAEAD.
+func DeriveAESKeysFromSecrets(suiteID uint16, otherSecret, mySecret []byte) (AEAD, error) {
+	suite, err := getCipherSuiteV1(suiteID)
+	if err != nil {
+		return nil, err
+	}
+	hp, err := newHeaderProtector(suite.ID, suite.Hash, otherSecret, mySecret, suite.KeyLen)
+	if err != nil {
+		return nil, err
+	}
+	return newUpdatableAEAD(suite.Hash, suite.newAEADFromKeys, otherSecret, mySecret, suite.KeyLen, ivLen, hp)
+}
+
+// newHeaderProtector creates the HeaderProtector matching the cipher suite
+func newHeaderProtector(suite uint16, hash crypto.Hash, otherSecret, mySecret []byte, keyLen int) (HeaderProtector, error) {
+	otherKey := qhkdfExpandHash(hash, otherSecret, headerProtectionLabel, keyLen)
+	myKey := qhkdfExpandHash(hash, mySecret, headerProtectionLabel, keyLen)
+	if suite == tls.TLS_CHACHA20_POLY1305_SHA256 {
+		return NewChaChaHeaderProtector(otherKey, myKey)
+	}
+	return NewAESHeaderProtector(otherKey, myKey)
+}
+
+// An aeadConstructor creates an AEAD from the packet protection keys and IVs
+type aeadConstructor func(otherKey, myKey, otherIV, myIV []byte) (AEAD, error)
+
+type aeadUpdatable struct {
+	AEAD
+
+	hash        crypto.Hash
+	newAEAD     aeadConstructor
+	otherSecret []byte
+	mySecret    []byte
+	keyLen      int
+	ivLen       int
+	// the header protection keys are not changed by key updates
+	hp HeaderProtector
+}
+
+var _ UpdatableAEAD = &aeadUpdatable{}
+var _ HeaderProtectingAEAD = &aeadUpdatable{}
+
+// NewUpdatableAEADAESGCM creates an AES-GCM AEAD from the 1-RTT secrets of both endpoints.
+// The keys for the next key phase are derived from these secrets.
+// Header protection uses AES.
+func NewUpdatableAEADAESGCM(otherSecret, mySecret []byte, keyLen, ivLen int) (UpdatableAEAD, error) {
+	hp, err := NewAESHeaderProtector(computeHeaderProtectionKey(otherSecret, keyLen), computeHeaderProtectionKey(mySecret, keyLen))
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
-	key = qhkdfExpand(secret, "key", cs.KeyLen)
-	iv = qhkdfExpand(secret, "iv", cs.IvLen)
-	return key, iv, nil
+	return newUpdatableAEADAESGCM(otherSecret, mySecret, keyLen, ivLen, hp)
+}
+
+func newUpdatableAEADAESGCM(otherSecret, mySecret []byte, keyLen, ivLen int, hp HeaderProtector) (UpdatableAEAD, error) {
+	return newUpdatableAEAD(crypto.SHA256, NewAEADAESGCM, otherSecret, mySecret, keyLen, ivLen, hp)
+}
+
+func newUpdatableAEAD(hash crypto.Hash, newAEAD aeadConstructor, otherSecret, mySecret []byte, keyLen, ivLen int, hp HeaderProtector) (UpdatableAEAD, error) {
+	aead, err := newAEAD(
+		qhkdfExpandHash(hash, otherSecret, "key", keyLen),
+		qhkdfExpandHash(hash, mySecret, "key", keyLen),
+		qhkdfExpandHash(hash, otherSecret, "iv", ivLen),
+		qhkdfExpandHash(hash, mySecret, "iv", ivLen),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &aeadUpdatable{
+		AEAD:        aead,
+		hash:        hash,
+		newAEAD:     newAEAD,
+		otherSecret: otherSecret,
+		mySecret:    mySecret,
+		keyLen:      keyLen,
+		ivLen:       ivLen,
+		hp:          hp,
+	}, nil
+}
+
+// Next derives the next generation of the secrets, and creates a new AEAD from them.
+func (a *aeadUpdatable) Next() (UpdatableAEAD, error) {
+	return newUpdatableAEAD(
+		a.hash,
+		a.newAEAD,
+		qhkdfExpandHash(a.hash, a.otherSecret, keyUpdateLabel, len(a.otherSecret)),
+		qhkdfExpandHash(a.hash, a.mySecret, keyUpdateLabel, len(a.mySecret)),
+		a.keyLen,
+		a.ivLen,
+		a.hp,
+	)
+}
+
+func (a *aeadUpdatable) HeaderProtector() HeaderProtector {
+	return a.hp
 }
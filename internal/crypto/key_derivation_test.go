@@ -1,8 +1,11 @@
 package crypto
 
 import (
+	"bytes"
 	"crypto"
+	"crypto/tls"
 	"errors"
+	"fmt"
 
 	"github.com/bifurcation/mint"
 	"github.com/wheelcomplex/qk/internal/protocol"
@@ -12,6 +15,7 @@ import (
 
 type mockTLSExporter struct {
 	hash          crypto.Hash
+	suite         mint.CipherSuite
 	computerError error
 }
 
@@ -22,6 +26,7 @@ func (c *mockTLSExporter) Handshake() mint.Alert { panic("not implemented") }
 func (c *mockTLSExporter) ConnectionState() mint.ConnectionState {
 	return mint.ConnectionState{
 		CipherSuite: mint.CipherSuiteParams{
+			Suite:  c.suite,
 			Hash:   c.hash,
 			KeyLen: 32,
 			IvLen:  12,
@@ -53,4 +58,227 @@ var _ = Describe("Key Derivation", func() {
 		_, err := DeriveAESKeys(&mockTLSExporter{hash: crypto.SHA256, computerError: testErr}, protocol.PerspectiveClient)
 		Expect(err).To(MatchError(testErr))
 	})
+
+	Context("deriving keys from traffic secrets", func() {
+		clientSecret := bytes.Repeat([]byte{'c'}, 32)
+		serverSecret := bytes.Repeat([]byte{'s'}, 32)
+
+		for _, s := range []uint16{tls.TLS_AES_128_GCM_SHA256, tls.TLS_AES_256_GCM_SHA384, tls.TLS_CHACHA20_POLY1305_SHA256} {
+			suite := s
+
+			It(fmt.Sprintf("derives keys for cipher suite %#x", suite), func() {
+				clientAEAD, err := DeriveAESKeysFromSecrets(suite, serverSecret, clientSecret)
+				Expect(err).ToNot(HaveOccurred())
+				serverAEAD, err := DeriveAESKeysFromSecrets(suite, clientSecret, serverSecret)
+				Expect(err).ToNot(HaveOccurred())
+				ciphertext := clientAEAD.Seal(nil, []byte("foobar"), 0, []byte("aad"))
+				data, err := serverAEAD.Open(nil, ciphertext, 0, []byte("aad"))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(data).To(Equal([]byte("foobar")))
+				Expect(clientAEAD).To(BeAssignableToTypeOf(&aeadUpdatable{}))
+				Expect(clientAEAD.(HeaderProtectingAEAD).HeaderProtector()).ToNot(BeNil())
+			})
+		}
+
+		for _, s := range []struct {
+			suite   uint16
+			hash    crypto.Hash
+			keyLen  int
+			newAEAD aeadConstructor
+			newHP   func(otherKey, myKey []byte) (HeaderProtector, error)
+		}{
+			{tls.TLS_AES_128_GCM_SHA256, crypto.SHA256, 16, NewAEADAESGCM, NewAESHeaderProtector},
+			{tls.TLS_AES_256_GCM_SHA384, crypto.SHA384, 32, NewAEADAESGCM, NewAESHeaderProtector},
+			{tls.TLS_CHACHA20_POLY1305_SHA256, crypto.SHA256, 32, NewAEADChaCha20Poly1305, NewChaChaHeaderProtector},
+		} {
+			s := s
+
+			It(fmt.Sprintf("uses the AEAD and the hash function of cipher suite %#x", s.suite), func() {
+				clientAEAD, err := DeriveAESKeysFromSecrets(s.suite, serverSecret, clientSecret)
+				Expect(err).ToNot(HaveOccurred())
+				serverAEAD, err := s.newAEAD(
+					qhkdfExpandHash(s.hash, clientSecret, "key", s.keyLen),
+					qhkdfExpandHash(s.hash, serverSecret, "key", s.keyLen),
+					qhkdfExpandHash(s.hash, clientSecret, "iv", 12),
+					qhkdfExpandHash(s.hash, serverSecret, "iv", 12),
+				)
+				Expect(err).ToNot(HaveOccurred())
+				ciphertext := clientAEAD.Seal(nil, []byte("foobar"), 42, []byte("aad"))
+				data, err := serverAEAD.Open(nil, ciphertext, 42, []byte("aad"))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(data).To(Equal([]byte("foobar")))
+
+				serverHP, err := s.newHP(
+					qhkdfExpandHash(s.hash, clientSecret, headerProtectionLabel, s.keyLen),
+					qhkdfExpandHash(s.hash, serverSecret, headerProtectionLabel, s.keyLen),
+				)
+				Expect(err).ToNot(HaveOccurred())
+				sample := []byte("0123456789abcdef")
+				firstByte := byte(0x30)
+				pn := []byte{0xc1, 0x23, 0x45, 0x67}
+				clientAEAD.(HeaderProtectingAEAD).HeaderProtector().EncryptHeader(sample, &firstByte, pn)
+				serverHP.DecryptHeader(sample, &firstByte, pn)
+				Expect(firstByte).To(Equal(byte(0x30)))
+				Expect(pn).To(Equal([]byte{0xc1, 0x23, 0x45, 0x67}))
+			})
+		}
+
+		It("uses SHA-384 for TLS_AES_256_GCM_SHA384", func() {
+			clientAEAD, err := DeriveAESKeysFromSecrets(tls.TLS_AES_256_GCM_SHA384, serverSecret, clientSecret)
+			Expect(err).ToNot(HaveOccurred())
+			serverAEAD, err := NewAEADAESGCM(
+				qhkdfExpand(clientSecret, "key", 32),
+				qhkdfExpand(serverSecret, "key", 32),
+				qhkdfExpand(clientSecret, "iv", 12),
+				qhkdfExpand(serverSecret, "iv", 12),
+			)
+			Expect(err).ToNot(HaveOccurred())
+			ciphertext := clientAEAD.Seal(nil, []byte("foobar"), 42, []byte("aad"))
+			_, err = serverAEAD.Open(nil, ciphertext, 42, []byte("aad"))
+			Expect(err).To(MatchError("cipher: message authentication failed"))
+		})
+
+		It("uses the hash function of the cipher suite for key updates", func() {
+			clientAEAD, err := DeriveAESKeysFromSecrets(tls.TLS_AES_256_GCM_SHA384, serverSecret, clientSecret)
+			Expect(err).ToNot(HaveOccurred())
+			nextClientAEAD, err := clientAEAD.(UpdatableAEAD).Next()
+			Expect(err).ToNot(HaveOccurred())
+			nextServerAEAD, err := DeriveAESKeysFromSecrets(
+				tls.TLS_AES_256_GCM_SHA384,
+				qhkdfExpandHash(crypto.SHA384, clientSecret, keyUpdateLabel, len(clientSecret)),
+				qhkdfExpandHash(crypto.SHA384, serverSecret, keyUpdateLabel, len(serverSecret)),
+			)
+			Expect(err).ToNot(HaveOccurred())
+			ciphertext := nextClientAEAD.Seal(nil, []byte("foobar"), 42, []byte("aad"))
+			data, err := nextServerAEAD.Open(nil, ciphertext, 42, []byte("aad"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(data).To(Equal([]byte("foobar")))
+		})
+
+		Context("cross-checking the AEADs against RFC 9001, Appendix A", func() {
+			It("uses AES-128-GCM for TLS_AES_128_GCM_SHA256", func() {
+				// the Retry integrity tag of Appendix A.4 is computed using AES-128-GCM with an empty plaintext
+				suite, err := getCipherSuiteV1(tls.TLS_AES_128_GCM_SHA256)
+				Expect(err).ToNot(HaveOccurred())
+				key := decodeHex("be0c690b9f66575a1d766b54e368c84e")
+				iv := decodeHex("461599d35d632bf2239825bb")
+				aead, err := suite.newAEADFromKeys(key, key, iv, iv)
+				Expect(err).ToNot(HaveOccurred())
+				pseudoPacket := decodeHex("088394c8f03e515708ff000000010008f067a5502a4262b5746f6b656e")
+				Expect(aead.Seal(nil, nil, 0, pseudoPacket)).To(Equal(decodeHex("04a265ba2eff4d829058fb3f0f2496ba")))
+			})
+
+			It("uses ChaCha20-Poly1305 for TLS_CHACHA20_POLY1305_SHA256", func() {
+				// the short header packet of Appendix A.5
+				suite, err := getCipherSuiteV1(tls.TLS_CHACHA20_POLY1305_SHA256)
+				Expect(err).ToNot(HaveOccurred())
+				key := decodeHex("c6d98ff3441c3fe1b2182094f69caa2ed4b716b65488960a7a984979fb23e1c8")
+				iv := decodeHex("e0459b3474bdd0e44a41c144")
+				aead, err := suite.newAEADFromKeys(key, key, iv, iv)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(aead.Seal(nil, []byte{0x01}, 654360564, decodeHex("4200bff4"))).To(Equal(decodeHex("655e5cd55c41f69080575d7999c25a5bfb")))
+			})
+		})
+
+		It("uses different keys for the two directions", func() {
+			clientAEAD, err := DeriveAESKeysFromSecrets(tls.TLS_AES_128_GCM_SHA256, serverSecret, clientSecret)
+			Expect(err).ToNot(HaveOccurred())
+			ciphertext := clientAEAD.Seal(nil, []byte("foobar"), 0, []byte("aad"))
+			_, err = clientAEAD.Open(nil, ciphertext, 0, []byte("aad"))
+			Expect(err).To(MatchError("cipher: message authentication failed"))
+		})
+
+		It("errors for unknown cipher suites", func() {
+			_, err := DeriveAESKeysFromSecrets(0x1337, serverSecret, clientSecret)
+			Expect(err).To(MatchError("unknown cipher suite: 0x1337"))
+		})
+	})
+
+	Context("header protection", func() {
+		protectHeader := func(client, server AEAD) []byte {
+			sample := []byte("0123456789abcdef")
+			firstByte := byte(0x30)
+			pn := []byte{0xc1, 0x23, 0x45, 0x67}
+			client.(HeaderProtectingAEAD).HeaderProtector().EncryptHeader(sample, &firstByte, pn)
+			server.(HeaderProtectingAEAD).HeaderProtector().DecryptHeader(sample, &firstByte, pn)
+			Expect(firstByte).To(Equal(byte(0x30)))
+			return pn
+		}
+
+		It("derives the header protection keys", func() {
+			clientAEAD, err := DeriveAESKeys(&mockTLSExporter{hash: crypto.SHA256}, protocol.PerspectiveClient)
+			Expect(err).ToNot(HaveOccurred())
+			serverAEAD, err := DeriveAESKeys(&mockTLSExporter{hash: crypto.SHA256}, protocol.PerspectiveServer)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(protectHeader(clientAEAD, serverAEAD)).To(Equal([]byte{0xc1, 0x23, 0x45, 0x67}))
+		})
+
+		It("uses ChaCha20 for ChaCha20 cipher suites", func() {
+			clientAEAD, err := DeriveAESKeys(&mockTLSExporter{hash: crypto.SHA256, suite: mint.TLS_CHACHA20_POLY1305_SHA256}, protocol.PerspectiveClient)
+			Expect(err).ToNot(HaveOccurred())
+			serverAEAD, err := DeriveAESKeys(&mockTLSExporter{hash: crypto.SHA256, suite: mint.TLS_CHACHA20_POLY1305_SHA256}, protocol.PerspectiveServer)
+			Expect(err).ToNot(HaveOccurred())
+			hp := clientAEAD.(HeaderProtectingAEAD).HeaderProtector().(*headerProtector)
+			Expect(hp.myMask(make([]byte, 16))).To(HaveLen(5))
+			Expect(protectHeader(clientAEAD, serverAEAD)).To(Equal([]byte{0xc1, 0x23, 0x45, 0x67}))
+		})
+
+		It("doesn't change the header protection keys on key updates", func() {
+			clientAEAD, err := DeriveAESKeys(&mockTLSExporter{hash: crypto.SHA256}, protocol.PerspectiveClient)
+			Expect(err).ToNot(HaveOccurred())
+			serverAEAD, err := DeriveAESKeys(&mockTLSExporter{hash: crypto.SHA256}, protocol.PerspectiveServer)
+			Expect(err).ToNot(HaveOccurred())
+			nextClientAEAD, err := clientAEAD.(UpdatableAEAD).Next()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(protectHeader(nextClientAEAD, serverAEAD)).To(Equal([]byte{0xc1, 0x23, 0x45, 0x67}))
+		})
+	})
+
+	Context("key updates", func() {
+		var clientAEAD, serverAEAD UpdatableAEAD
+
+		BeforeEach(func() {
+			c, err := DeriveAESKeys(&mockTLSExporter{hash: crypto.SHA256}, protocol.PerspectiveClient)
+			Expect(err).ToNot(HaveOccurred())
+			s, err := DeriveAESKeys(&mockTLSExporter{hash: crypto.SHA256}, protocol.PerspectiveServer)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(c).To(BeAssignableToTypeOf(&aeadUpdatable{}))
+			Expect(s).To(BeAssignableToTypeOf(&aeadUpdatable{}))
+			clientAEAD = c.(UpdatableAEAD)
+			serverAEAD = s.(UpdatableAEAD)
+		})
+
+		It("derives the keys for the next key phase", func() {
+			nextClientAEAD, err := clientAEAD.Next()
+			Expect(err).ToNot(HaveOccurred())
+			nextServerAEAD, err := serverAEAD.Next()
+			Expect(err).ToNot(HaveOccurred())
+			ciphertext := nextServerAEAD.Seal(nil, []byte("foobar"), 42, []byte("aad"))
+			data, err := nextClientAEAD.Open(nil, ciphertext, 42, []byte("aad"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(data).To(Equal([]byte("foobar")))
+		})
+
+		It("uses different keys after a key update", func() {
+			nextClientAEAD, err := clientAEAD.Next()
+			Expect(err).ToNot(HaveOccurred())
+			ciphertext := nextClientAEAD.Seal(nil, []byte("foobar"), 42, []byte("aad"))
+			_, err = serverAEAD.Open(nil, ciphertext, 42, []byte("aad"))
+			Expect(err).To(MatchError("cipher: message authentication failed"))
+		})
+
+		It("derives the same keys for multiple generations", func() {
+			for i := 0; i < 3; i++ {
+				var err error
+				clientAEAD, err = clientAEAD.Next()
+				Expect(err).ToNot(HaveOccurred())
+				serverAEAD, err = serverAEAD.Next()
+				Expect(err).ToNot(HaveOccurred())
+			}
+			ciphertext := clientAEAD.Seal(nil, []byte("foobar"), 1337, []byte("aad"))
+			data, err := serverAEAD.Open(nil, ciphertext, 1337, []byte("aad"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(data).To(Equal([]byte("foobar")))
+		})
+	})
 })
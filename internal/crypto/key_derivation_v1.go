@@ -0,0 +1,151 @@
+package crypto
+
+import (
+	"crypto"
+	"crypto/tls"
+	"fmt"
+
+	"github.com/bifurcation/mint"
+	"github.com/wheelcomplex/qk/internal/protocol"
+)
+
+// quicV1InitialSalt is the salt used to derive the Initial secrets, see RFC 9001, section 5.2
+var quicV1InitialSalt = []byte{0x38, 0x76, 0x2c, 0xf7, 0xf5, 0x59, 0x34, 0xb3, 0x4d, 0x17, 0x9a, 0xe6, 0xa4, 0xc8, 0x0c, 0xad, 0xcc, 0xbb, 0x7f, 0x0a}
+
+const (
+	keyLabelV1              = "quic key"
+	ivLabelV1               = "quic iv"
+	headerProtectionLabelV1 = "quic hp"
+	keyUpdateLabelV1        = "quic ku"
+)
+
+// hkdfExpandLabel implements HKDF-Expand-Label from TLS 1.3, with an empty context
+func hkdfExpandLabel(hash crypto.Hash, secret []byte, label string, length int) []byte {
+	return mint.HkdfExpandLabel(hash, secret, label, nil, length)
+}
+
+// cipherSuiteV1 describes the parameters of a TLS 1.3 cipher suite needed by QUIC v1
+type cipherSuiteV1 struct {
+	ID     uint16
+	Hash   crypto.Hash
+	KeyLen int
+}
+
+func getCipherSuiteV1(id uint16) (*cipherSuiteV1, error) {
+	switch id {
+	case tls.TLS_AES_128_GCM_SHA256:
+		return &cipherSuiteV1{ID: id, Hash: crypto.SHA256, KeyLen: 16}, nil
+	case tls.TLS_AES_256_GCM_SHA384:
+		return &cipherSuiteV1{ID: id, Hash: crypto.SHA384, KeyLen: 32}, nil
+	case tls.TLS_CHACHA20_POLY1305_SHA256:
+		return &cipherSuiteV1{ID: id, Hash: crypto.SHA256, KeyLen: 32}, nil
+	default:
+		return nil, fmt.Errorf("unknown cipher suite: %#x", id)
+	}
+}
+
+func (s *cipherSuiteV1) newAEAD(otherSecret, mySecret []byte) (AEAD, error) {
+	otherKey := hkdfExpandLabel(s.Hash, otherSecret, keyLabelV1, s.KeyLen)
+	myKey := hkdfExpandLabel(s.Hash, mySecret, keyLabelV1, s.KeyLen)
+	otherIV := hkdfExpandLabel(s.Hash, otherSecret, ivLabelV1, ivLen)
+	myIV := hkdfExpandLabel(s.Hash, mySecret, ivLabelV1, ivLen)
+	return s.newAEADFromKeys(otherKey, myKey, otherIV, myIV)
+}
+
+// newAEADFromKeys creates the AEAD of the cipher suite from the packet protection keys and IVs
+func (s *cipherSuiteV1) newAEADFromKeys(otherKey, myKey, otherIV, myIV []byte) (AEAD, error) {
+	if s.ID == tls.TLS_CHACHA20_POLY1305_SHA256 {
+		return NewAEADChaCha20Poly1305(otherKey, myKey, otherIV, myIV)
+	}
+	return NewAEADAESGCM(otherKey, myKey, otherIV, myIV)
+}
+
+func (s *cipherSuiteV1) newHeaderProtector(otherSecret, mySecret []byte) (HeaderProtector, error) {
+	otherKey := hkdfExpandLabel(s.Hash, otherSecret, headerProtectionLabelV1, s.KeyLen)
+	myKey := hkdfExpandLabel(s.Hash, mySecret, headerProtectionLabelV1, s.KeyLen)
+	var hp HeaderProtector
+	var err error
+	if s.ID == tls.TLS_CHACHA20_POLY1305_SHA256 {
+		hp, err = NewChaChaHeaderProtector(otherKey, myKey)
+	} else {
+		hp, err = NewAESHeaderProtector(otherKey, myKey)
+	}
+	if err != nil {
+		return nil, err
+	}
+	hp.(*headerProtector).isV1 = true
+	return hp, nil
+}
+
+type aeadV1 struct {
+	AEAD
+
+	suite       *cipherSuiteV1
+	otherSecret []byte
+	mySecret    []byte
+	// the header protection keys are not changed by key updates
+	hp HeaderProtector
+}
+
+var _ UpdatableAEAD = &aeadV1{}
+var _ HeaderProtectingAEAD = &aeadV1{}
+
+// NewInitialAEADV1 creates the AEAD used for QUIC v1 Initial packets.
+// The keys are derived from the Destination Connection ID of the first Initial packet sent by the client.
+func NewInitialAEADV1(connID protocol.ConnectionID, pers protocol.Perspective) (AEAD, error) {
+	initialSecret := mint.HkdfExtract(crypto.SHA256, quicV1InitialSalt, connID)
+	clientSecret := hkdfExpandLabel(crypto.SHA256, initialSecret, "client in", crypto.SHA256.Size())
+	serverSecret := hkdfExpandLabel(crypto.SHA256, initialSecret, "server in", crypto.SHA256.Size())
+	suite, _ := getCipherSuiteV1(tls.TLS_AES_128_GCM_SHA256)
+	if pers == protocol.PerspectiveClient {
+		return newAEADV1(suite, serverSecret, clientSecret)
+	}
+	return newAEADV1(suite, clientSecret, serverSecret)
+}
+
+// NewAEADV1 creates an AEAD for QUIC v1 from the traffic secrets of both endpoints, as exported by TLS.
+// It is used for Handshake and 1-RTT packets.
+// The AEAD returned implements UpdatableAEAD, using the key update mechanism of RFC 9001, section 6.
+func NewAEADV1(suiteID uint16, otherSecret, mySecret []byte) (AEAD, error) {
+	suite, err := getCipherSuiteV1(suiteID)
+	if err != nil {
+		return nil, err
+	}
+	return newAEADV1(suite, otherSecret, mySecret)
+}
+
+func newAEADV1(suite *cipherSuiteV1, otherSecret, mySecret []byte) (UpdatableAEAD, error) {
+	hp, err := suite.newHeaderProtector(otherSecret, mySecret)
+	if err != nil {
+		return nil, err
+	}
+	return newAEADV1WithHeaderProtector(suite, otherSecret, mySecret, hp)
+}
+
+func newAEADV1WithHeaderProtector(suite *cipherSuiteV1, otherSecret, mySecret []byte, hp HeaderProtector) (UpdatableAEAD, error) {
+	aead, err := suite.newAEAD(otherSecret, mySecret)
+	if err != nil {
+		return nil, err
+	}
+	return &aeadV1{
+		AEAD:        aead,
+		suite:       suite,
+		otherSecret: otherSecret,
+		mySecret:    mySecret,
+		hp:          hp,
+	}, nil
+}
+
+// Next derives the secrets of the next key phase, and creates a new AEAD from them.
+func (a *aeadV1) Next() (UpdatableAEAD, error) {
+	return newAEADV1WithHeaderProtector(
+		a.suite,
+		hkdfExpandLabel(a.suite.Hash, a.otherSecret, keyUpdateLabelV1, len(a.otherSecret)),
+		hkdfExpandLabel(a.suite.Hash, a.mySecret, keyUpdateLabelV1, len(a.mySecret)),
+		a.hp,
+	)
+}
+
+func (a *aeadV1) HeaderProtector() HeaderProtector {
+	return a.hp
+}
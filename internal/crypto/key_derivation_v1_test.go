@@ -0,0 +1,117 @@
+package crypto
+
+import (
+	"crypto"
+	"crypto/tls"
+
+	"github.com/bifurcation/mint"
+	"github.com/wheelcomplex/qk/internal/protocol"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("QUIC v1 Key Derivation", func() {
+	// test vectors from RFC 9001, Appendix A.1
+	connID := protocol.ConnectionID(decodeHex("8394c8f03e515708"))
+
+	It("derives the Initial secrets", func() {
+		initialSecret := mint.HkdfExtract(crypto.SHA256, quicV1InitialSalt, connID)
+		clientSecret := hkdfExpandLabel(crypto.SHA256, initialSecret, "client in", 32)
+		Expect(clientSecret).To(Equal(decodeHex("c00cf151ca5be075ed0ebfb5c80323c42d6b7db67881289af4008f1f6c357aea")))
+		Expect(hkdfExpandLabel(crypto.SHA256, clientSecret, keyLabelV1, 16)).To(Equal(decodeHex("1f369613dd76d5467730efcbe3b1a22d")))
+		Expect(hkdfExpandLabel(crypto.SHA256, clientSecret, ivLabelV1, 12)).To(Equal(decodeHex("fa044b2f42a3fd3b46fb255c")))
+		Expect(hkdfExpandLabel(crypto.SHA256, clientSecret, headerProtectionLabelV1, 16)).To(Equal(decodeHex("9f50449e04a0e810283a1e9933adedd2")))
+		serverSecret := hkdfExpandLabel(crypto.SHA256, initialSecret, "server in", 32)
+		Expect(hkdfExpandLabel(crypto.SHA256, serverSecret, keyLabelV1, 16)).To(Equal(decodeHex("cf3a5331653c364c88f0f379b6067e37")))
+		Expect(hkdfExpandLabel(crypto.SHA256, serverSecret, ivLabelV1, 12)).To(Equal(decodeHex("0ac1493ca1905853b0bba03e")))
+		Expect(hkdfExpandLabel(crypto.SHA256, serverSecret, headerProtectionLabelV1, 16)).To(Equal(decodeHex("c206b8d9b9f0f37644430b490eeaa314")))
+	})
+
+	It("creates the Initial AEADs", func() {
+		client, err := NewInitialAEADV1(connID, protocol.PerspectiveClient)
+		Expect(err).ToNot(HaveOccurred())
+		server, err := NewInitialAEADV1(connID, protocol.PerspectiveServer)
+		Expect(err).ToNot(HaveOccurred())
+		sealed := client.Seal(nil, []byte("foobar"), 2, []byte("aad"))
+		opened, err := server.Open(nil, sealed, 2, []byte("aad"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(opened).To(Equal([]byte("foobar")))
+		sealed = server.Seal(nil, []byte("raboof"), 1, []byte("aad"))
+		opened, err = client.Open(nil, sealed, 1, []byte("aad"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(opened).To(Equal([]byte("raboof")))
+		// a different connection ID results in different keys
+		other, err := NewInitialAEADV1(protocol.ConnectionID{1, 2, 3, 4, 5, 6, 7, 8}, protocol.PerspectiveServer)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = other.Open(nil, client.Seal(nil, []byte("foobar"), 2, []byte("aad")), 2, []byte("aad"))
+		Expect(err).To(HaveOccurred())
+	})
+
+	Context("using ChaCha20-Poly1305", func() {
+		// test vectors from RFC 9001, Appendix A.5
+		secret := decodeHex("9ac312a7f877468ebe69422748ad00a15443f18203a07d6060f688f30f21632b")
+
+		It("protects a short header packet", func() {
+			aead, err := NewAEADV1(tls.TLS_CHACHA20_POLY1305_SHA256, secret, secret)
+			Expect(err).ToNot(HaveOccurred())
+			hdr := decodeHex("4200bff4")
+			sealed := aead.Seal(nil, []byte{0x01}, 654360564, hdr)
+			Expect(sealed).To(Equal(decodeHex("655e5cd55c41f69080575d7999c25a5bfb")))
+			hp := aead.(HeaderProtectingAEAD).HeaderProtector()
+			// the sample starts 4 bytes after the start of the packet number
+			hp.EncryptHeader(sealed[1:1+HeaderProtectionSampleLen], &hdr[0], hdr[1:])
+			Expect(append(hdr, sealed...)).To(Equal(decodeHex("4cfe4189655e5cd55c41f69080575d7999c25a5bfb")))
+
+			firstByte := byte(0x4c)
+			pn := decodeHex("fe418965") // DecryptHeader needs to be passed 4 bytes
+			hp.DecryptHeader(sealed[1:1+HeaderProtectionSampleLen], &firstByte, pn)
+			Expect(firstByte).To(Equal(byte(0x42)))
+			// only the 3 bytes of the packet number are unprotected
+			Expect(pn).To(Equal(decodeHex("00bff465")))
+		})
+
+		It("derives the keys for the next key phase", func() {
+			Expect(hkdfExpandLabel(crypto.SHA256, secret, keyUpdateLabelV1, 32)).To(Equal(decodeHex("1223504755036d556342ee9361d253421a826c9ecdf3c7148684b36b714881f9")))
+		})
+	})
+
+	Context("key updates", func() {
+		var client, server UpdatableAEAD
+
+		BeforeEach(func() {
+			clientSecret := decodeHex("c00cf151ca5be075ed0ebfb5c80323c42d6b7db67881289af4008f1f6c357aea")
+			serverSecret := decodeHex("3c199828fd139efd216c155ad844cc81fb82fa8d7446fa7d78be803acdda951b")
+			c, err := NewAEADV1(tls.TLS_AES_128_GCM_SHA256, serverSecret, clientSecret)
+			Expect(err).ToNot(HaveOccurred())
+			s, err := NewAEADV1(tls.TLS_AES_128_GCM_SHA256, clientSecret, serverSecret)
+			Expect(err).ToNot(HaveOccurred())
+			client = c.(UpdatableAEAD)
+			server = s.(UpdatableAEAD)
+		})
+
+		It("derives the same keys on both sides", func() {
+			nextClient, err := client.Next()
+			Expect(err).ToNot(HaveOccurred())
+			nextServer, err := server.Next()
+			Expect(err).ToNot(HaveOccurred())
+			sealed := nextClient.Seal(nil, []byte("foobar"), 10, []byte("aad"))
+			opened, err := nextServer.Open(nil, sealed, 10, []byte("aad"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(opened).To(Equal([]byte("foobar")))
+			_, err = server.Open(nil, sealed, 10, []byte("aad"))
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("doesn't change the header protection keys", func() {
+			next, err := client.Next()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(next.(HeaderProtectingAEAD).HeaderProtector()).To(BeIdenticalTo(client.(HeaderProtectingAEAD).HeaderProtector()))
+		})
+	})
+
+	It("errors for unknown cipher suites", func() {
+		_, err := NewAEADV1(0x1337, make([]byte, 32), make([]byte, 32))
+		Expect(err).To(MatchError("unknown cipher suite: 0x1337"))
+	})
+})
@@ -9,6 +9,17 @@ import (
 
 var quicVersion1Salt = []byte{0x9c, 0x10, 0x8f, 0x98, 0x52, 0x0a, 0x5c, 0x5c, 0x32, 0x96, 0x8e, 0x95, 0x0e, 0x8a, 0x2c, 0x5f, 0xe0, 0x6d, 0x6c, 0x38}
 
+type nullAEADAESGCM struct {
+	AEAD
+	hp HeaderProtector
+}
+
+var _ HeaderProtectingAEAD = &nullAEADAESGCM{}
+
+func (a *nullAEADAESGCM) HeaderProtector() HeaderProtector {
+	return a.hp
+}
+
 func newNullAEADAESGCM(connectionID protocol.ConnectionID, pers protocol.Perspective) (AEAD, error) {
 	clientSecret, serverSecret := computeSecrets(connectionID)
 
@@ -24,7 +35,15 @@ func newNullAEADAESGCM(connectionID protocol.ConnectionID, pers protocol.Perspec
 	myKey, myIV := computeNullAEADKeyAndIV(mySecret)
 	otherKey, otherIV := computeNullAEADKeyAndIV(otherSecret)
 
-	return NewAEADAESGCM(otherKey, myKey, otherIV, myIV)
+	aead, err := NewAEADAESGCM(otherKey, myKey, otherIV, myIV)
+	if err != nil {
+		return nil, err
+	}
+	hp, err := NewAESHeaderProtector(computeHeaderProtectionKey(otherSecret, 16), computeHeaderProtectionKey(mySecret, 16))
+	if err != nil {
+		return nil, err
+	}
+	return &nullAEADAESGCM{AEAD: aead, hp: hp}, nil
 }
 
 func computeSecrets(connID protocol.ConnectionID) (clientSecret, serverSecret []byte) {
@@ -71,6 +71,22 @@ var _ = Describe("NullAEAD using AES-GCM", func() {
 		Expect(m).To(Equal([]byte("raboof")))
 	})
 
+	It("protects headers", func() {
+		connectionID := protocol.ConnectionID([]byte{0x12, 0x34, 0x56, 0x78, 0x90, 0xab, 0xcd, 0xef})
+		clientAEAD, err := newNullAEADAESGCM(connectionID, protocol.PerspectiveClient)
+		Expect(err).ToNot(HaveOccurred())
+		serverAEAD, err := newNullAEADAESGCM(connectionID, protocol.PerspectiveServer)
+		Expect(err).ToNot(HaveOccurred())
+
+		sample := []byte("0123456789abcdef")
+		firstByte := byte(0xff)
+		pn := []byte{0x81, 0x23}
+		clientAEAD.(HeaderProtectingAEAD).HeaderProtector().EncryptHeader(sample, &firstByte, pn)
+		Expect(pn).ToNot(Equal([]byte{0x81, 0x23}))
+		serverAEAD.(HeaderProtectingAEAD).HeaderProtector().DecryptHeader(sample, &firstByte, pn)
+		Expect(pn).To(Equal([]byte{0x81, 0x23}))
+	})
+
 	It("doesn't work if initialized with different connection IDs", func() {
 		c1 := protocol.ConnectionID([]byte{0, 0, 0, 0, 0, 0, 0, 1})
 		c2 := protocol.ConnectionID([]byte{0, 0, 0, 0, 0, 0, 0, 2})
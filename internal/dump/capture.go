@@ -0,0 +1,452 @@
+package dump
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A Datagram is the payload of a UDP packet read from a capture.
+type Datagram struct {
+	// Time is the time the packet was captured.
+	// It is zero for packets read from hex dumps.
+	Time time.Time
+	// Src and Dst are the addresses of the sender and the receiver.
+	Src, Dst string
+	Data     []byte
+}
+
+// A CaptureReader reads the UDP payloads of a capture.
+type CaptureReader interface {
+	// Next returns the next datagram.
+	// It returns io.EOF when there are no more datagrams.
+	Next() (*Datagram, error)
+}
+
+const (
+	pcapMagic           = 0xa1b2c3d4
+	pcapMagicNanosecond = 0xa1b23c4d
+	pcapngBlockTypeSHB  = 0x0a0d0d0a
+)
+
+// NewCaptureReader creates a CaptureReader.
+// The format is detected from the first bytes: pcap, pcapng or a hex dump.
+// Packets that are not UDP, or that can't be parsed, are skipped.
+func NewCaptureReader(r io.Reader) (CaptureReader, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(4)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if len(magic) == 4 {
+		switch {
+		case binary.BigEndian.Uint32(magic) == pcapngBlockTypeSHB:
+			return &pcapngReader{r: br}, nil
+		case binary.LittleEndian.Uint32(magic) == pcapMagic || binary.BigEndian.Uint32(magic) == pcapMagic ||
+			binary.LittleEndian.Uint32(magic) == pcapMagicNanosecond || binary.BigEndian.Uint32(magic) == pcapMagicNanosecond:
+			return newPcapReader(br)
+		}
+	}
+	return NewHexReader(br), nil
+}
+
+// NewHexReader creates a CaptureReader for hex dumps.
+// Every line contains one datagram, encoded as hex. Whitespace within the hex string is ignored.
+// A line starting with > contains a datagram sent by the client, a line starting with < one sent by the server.
+// Lines without a marker are assumed to be sent by the client.
+// Empty lines and lines starting with # are skipped.
+func NewHexReader(r io.Reader) CaptureReader {
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64*1024), 1<<20)
+	return &hexReader{s: s}
+}
+
+// The addresses used for datagrams read from hex dumps
+const (
+	HexClientAddr = "client"
+	HexServerAddr = "server"
+)
+
+type hexReader struct {
+	s    *bufio.Scanner
+	line int
+}
+
+func (r *hexReader) Next() (*Datagram, error) {
+	for r.s.Scan() {
+		r.line++
+		line := strings.TrimSpace(r.s.Text())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		dg := &Datagram{Src: HexClientAddr, Dst: HexServerAddr}
+		switch line[0] {
+		case '<':
+			dg.Src, dg.Dst = HexServerAddr, HexClientAddr
+			line = line[1:]
+		case '>':
+			line = line[1:]
+		}
+		line = strings.Join(strings.Fields(line), "")
+		line = strings.TrimPrefix(line, "0x")
+		data, err := hex.DecodeString(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", r.line, err)
+		}
+		dg.Data = data
+		return dg, nil
+	}
+	if err := r.s.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+// link types, see http://www.tcpdump.org/linktypes.html
+const (
+	linkTypeNull      = 0
+	linkTypeEthernet  = 1
+	linkTypeRaw       = 101
+	linkTypeLoop      = 108
+	linkTypeLinuxSLL  = 113
+	linkTypeIPv4      = 228
+	linkTypeIPv6      = 229
+	linkTypeLinuxSLL2 = 276
+)
+
+type pcapReader struct {
+	r         io.Reader
+	byteOrder binary.ByteOrder
+	nanos     bool
+	linkType  uint32
+}
+
+func newPcapReader(r io.Reader) (*pcapReader, error) {
+	hdr := make([]byte, 24)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, err
+	}
+	p := &pcapReader{r: r}
+	magic := binary.LittleEndian.Uint32(hdr)
+	if magic == pcapMagic || magic == pcapMagicNanosecond {
+		p.byteOrder = binary.LittleEndian
+	} else {
+		p.byteOrder = binary.BigEndian
+		magic = binary.BigEndian.Uint32(hdr)
+	}
+	p.nanos = magic == pcapMagicNanosecond
+	p.linkType = p.byteOrder.Uint32(hdr[20:]) & 0xffff
+	return p, nil
+}
+
+func (p *pcapReader) Next() (*Datagram, error) {
+	hdr := make([]byte, 16)
+	for {
+		if _, err := io.ReadFull(p.r, hdr); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				return nil, errors.New("pcap: truncated packet header")
+			}
+			return nil, err
+		}
+		sec := p.byteOrder.Uint32(hdr)
+		frac := p.byteOrder.Uint32(hdr[4:])
+		capLen := p.byteOrder.Uint32(hdr[8:])
+		if capLen > 1<<20 {
+			return nil, fmt.Errorf("pcap: packet too large (%d bytes)", capLen)
+		}
+		data := make([]byte, capLen)
+		if _, err := io.ReadFull(p.r, data); err != nil {
+			return nil, errors.New("pcap: truncated packet")
+		}
+		if !p.nanos {
+			frac *= 1000
+		}
+		dg, err := parseLinkLayer(p.linkType, data)
+		if err != nil {
+			continue
+		}
+		dg.Time = time.Unix(int64(sec), int64(frac))
+		return dg, nil
+	}
+}
+
+// pcapng block types, see https://www.ietf.org/archive/id/draft-ietf-opsawg-pcapng-02.html
+const (
+	pcapngBlockTypeIDB = 1
+	pcapngBlockTypeOPB = 2
+	pcapngBlockTypeSPB = 3
+	pcapngBlockTypeEPB = 6
+)
+
+const pcapngByteOrderMagic uint32 = 0x1a2b3c4d
+
+type pcapngInterface struct {
+	linkType uint16
+	// the resolution of the timestamps, in units per second
+	tsResolution uint64
+}
+
+type pcapngReader struct {
+	r io.Reader
+	// The byte order is set by the Section Header Block, and can change with every new section.
+	byteOrder  binary.ByteOrder
+	interfaces []pcapngInterface
+}
+
+func (p *pcapngReader) readBlock() (uint32, []byte, error) {
+	hdr := make([]byte, 8)
+	if _, err := io.ReadFull(p.r, hdr); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return 0, nil, errors.New("pcapng: truncated block header")
+		}
+		return 0, nil, err
+	}
+	if binary.BigEndian.Uint32(hdr) == pcapngBlockTypeSHB {
+		// The block length can only be read after reading the byte order magic.
+		bom := make([]byte, 4)
+		if _, err := io.ReadFull(p.r, bom); err != nil {
+			return 0, nil, errors.New("pcapng: truncated Section Header Block")
+		}
+		switch pcapngByteOrderMagic {
+		case binary.LittleEndian.Uint32(bom):
+			p.byteOrder = binary.LittleEndian
+		case binary.BigEndian.Uint32(bom):
+			p.byteOrder = binary.BigEndian
+		default:
+			return 0, nil, errors.New("pcapng: invalid byte order magic")
+		}
+		p.interfaces = nil
+		blockLen := p.byteOrder.Uint32(hdr[4:])
+		if blockLen < 16 || blockLen > 1<<20 {
+			return 0, nil, fmt.Errorf("pcapng: invalid block length %d", blockLen)
+		}
+		if _, err := io.CopyN(ioutil.Discard, p.r, int64(blockLen-12)); err != nil {
+			return 0, nil, errors.New("pcapng: truncated Section Header Block")
+		}
+		return pcapngBlockTypeSHB, nil, nil
+	}
+	if p.byteOrder == nil {
+		return 0, nil, errors.New("pcapng: missing Section Header Block")
+	}
+	blockType := p.byteOrder.Uint32(hdr)
+	blockLen := p.byteOrder.Uint32(hdr[4:])
+	if blockLen < 12 || blockLen%4 != 0 || blockLen > 1<<20 {
+		return 0, nil, fmt.Errorf("pcapng: invalid block length %d", blockLen)
+	}
+	body := make([]byte, blockLen-8)
+	if _, err := io.ReadFull(p.r, body); err != nil {
+		return 0, nil, errors.New("pcapng: truncated block")
+	}
+	// strip the trailing block length
+	return blockType, body[:len(body)-4], nil
+}
+
+func (p *pcapngReader) Next() (*Datagram, error) {
+	for {
+		blockType, body, err := p.readBlock()
+		if err != nil {
+			return nil, err
+		}
+		switch blockType {
+		case pcapngBlockTypeIDB:
+			if len(body) < 8 {
+				return nil, errors.New("pcapng: Interface Description Block too short")
+			}
+			p.interfaces = append(p.interfaces, pcapngInterface{
+				linkType:     p.byteOrder.Uint16(body),
+				tsResolution: p.parseTSResolution(body[8:]),
+			})
+		case pcapngBlockTypeEPB, pcapngBlockTypeOPB:
+			if len(body) < 20 {
+				return nil, errors.New("pcapng: packet block too short")
+			}
+			var ifaceID uint32
+			if blockType == pcapngBlockTypeEPB {
+				ifaceID = p.byteOrder.Uint32(body)
+			} else {
+				ifaceID = uint32(p.byteOrder.Uint16(body))
+			}
+			if int(ifaceID) >= len(p.interfaces) {
+				return nil, fmt.Errorf("pcapng: unknown interface %d", ifaceID)
+			}
+			iface := p.interfaces[ifaceID]
+			ts := uint64(p.byteOrder.Uint32(body[4:]))<<32 | uint64(p.byteOrder.Uint32(body[8:]))
+			capLen := p.byteOrder.Uint32(body[12:])
+			if int(capLen) > len(body)-20 {
+				return nil, errors.New("pcapng: invalid captured length")
+			}
+			dg, err := parseLinkLayer(uint32(iface.linkType), body[20:20+capLen])
+			if err != nil {
+				continue
+			}
+			sec := ts / iface.tsResolution
+			nsec := (ts % iface.tsResolution) * uint64(time.Second) / iface.tsResolution
+			dg.Time = time.Unix(int64(sec), int64(nsec))
+			return dg, nil
+		case pcapngBlockTypeSPB:
+			// The Simple Packet Block doesn't contain a timestamp.
+			if len(body) < 4 || len(p.interfaces) == 0 {
+				continue
+			}
+			dg, err := parseLinkLayer(uint32(p.interfaces[0].linkType), body[4:])
+			if err != nil {
+				continue
+			}
+			return dg, nil
+		}
+	}
+}
+
+// parseTSResolution parses the if_tsresol option of an Interface Description Block
+func (p *pcapngReader) parseTSResolution(opts []byte) uint64 {
+	const optEndOfOpt = 0
+	const optTSResol = 9
+	for len(opts) >= 4 {
+		code := p.byteOrder.Uint16(opts)
+		l := int(p.byteOrder.Uint16(opts[2:]))
+		if code == optEndOfOpt || len(opts) < 4+l {
+			break
+		}
+		if code == optTSResol && l >= 1 {
+			v := opts[4]
+			var res uint64 = 1
+			if v&0x80 > 0 { // negative power of 2
+				return res << (v & 0x7f)
+			}
+			for i := 0; i < int(v); i++ {
+				res *= 10
+			}
+			return res
+		}
+		// options are padded to 32 bits
+		opts = opts[4+(l+3)/4*4:]
+	}
+	return 1000000 // microseconds
+}
+
+var errNotUDP = errors.New("not a UDP packet")
+
+func parseLinkLayer(linkType uint32, data []byte) (*Datagram, error) {
+	switch linkType {
+	case linkTypeEthernet:
+		if len(data) < 14 {
+			return nil, errNotUDP
+		}
+		etherType := binary.BigEndian.Uint16(data[12:])
+		data = data[14:]
+		// skip VLAN tags
+		for (etherType == 0x8100 || etherType == 0x88a8) && len(data) >= 4 {
+			etherType = binary.BigEndian.Uint16(data[2:])
+			data = data[4:]
+		}
+		return parseEtherType(etherType, data)
+	case linkTypeNull, linkTypeLoop:
+		// The address family is encoded in the host byte order of the capturing machine (linkTypeNull),
+		// or in network byte order (linkTypeLoop). The IP version can be read from the packet itself.
+		if len(data) < 4 {
+			return nil, errNotUDP
+		}
+		return parseIP(data[4:])
+	case linkTypeRaw, linkTypeIPv4, linkTypeIPv6:
+		return parseIP(data)
+	case linkTypeLinuxSLL:
+		if len(data) < 16 {
+			return nil, errNotUDP
+		}
+		return parseEtherType(binary.BigEndian.Uint16(data[14:]), data[16:])
+	case linkTypeLinuxSLL2:
+		if len(data) < 20 {
+			return nil, errNotUDP
+		}
+		return parseEtherType(binary.BigEndian.Uint16(data), data[20:])
+	default:
+		return nil, fmt.Errorf("unsupported link type %d", linkType)
+	}
+}
+
+func parseEtherType(etherType uint16, data []byte) (*Datagram, error) {
+	switch etherType {
+	case 0x0800, 0x86dd:
+		return parseIP(data)
+	default:
+		return nil, errNotUDP
+	}
+}
+
+func parseIP(data []byte) (*Datagram, error) {
+	if len(data) == 0 {
+		return nil, errNotUDP
+	}
+	switch data[0] >> 4 {
+	case 4:
+		if len(data) < 20 {
+			return nil, errNotUDP
+		}
+		ihl := int(data[0]&0xf) * 4
+		totalLen := int(binary.BigEndian.Uint16(data[2:]))
+		// fragments are not reassembled
+		if flagsAndOffset := binary.BigEndian.Uint16(data[6:]); flagsAndOffset&0x3fff != 0 {
+			return nil, errNotUDP
+		}
+		if data[9] != 17 || ihl < 20 || totalLen < ihl || totalLen > len(data) {
+			return nil, errNotUDP
+		}
+		return parseUDP(net.IP(data[12:16]), net.IP(data[16:20]), data[ihl:totalLen])
+	case 6:
+		if len(data) < 40 {
+			return nil, errNotUDP
+		}
+		payloadLen := int(binary.BigEndian.Uint16(data[4:]))
+		if 40+payloadLen > len(data) {
+			return nil, errNotUDP
+		}
+		src, dst := net.IP(data[8:24]), net.IP(data[24:40])
+		next := data[6]
+		payload := data[40 : 40+payloadLen]
+		// skip extension headers
+		for next == 0 || next == 43 || next == 60 {
+			if len(payload) < 8 {
+				return nil, errNotUDP
+			}
+			l := (int(payload[1]) + 1) * 8
+			if len(payload) < l {
+				return nil, errNotUDP
+			}
+			next = payload[0]
+			payload = payload[l:]
+		}
+		if next != 17 {
+			return nil, errNotUDP
+		}
+		return parseUDP(src, dst, payload)
+	default:
+		return nil, errNotUDP
+	}
+}
+
+func parseUDP(src, dst net.IP, data []byte) (*Datagram, error) {
+	if len(data) < 8 {
+		return nil, errNotUDP
+	}
+	srcPort := binary.BigEndian.Uint16(data)
+	dstPort := binary.BigEndian.Uint16(data[2:])
+	l := int(binary.BigEndian.Uint16(data[4:]))
+	if l < 8 || l > len(data) {
+		return nil, errNotUDP
+	}
+	payload := make([]byte, l-8)
+	copy(payload, data[8:l])
+	return &Datagram{
+		Src:  net.JoinHostPort(src.String(), strconv.Itoa(int(srcPort))),
+		Dst:  net.JoinHostPort(dst.String(), strconv.Itoa(int(dstPort))),
+		Data: payload,
+	}, nil
+}
@@ -0,0 +1,290 @@
+package dump
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// buildUDPv4 builds an IPv4 packet containing a UDP datagram
+func buildUDPv4(src, dst string, srcPort, dstPort uint16, payload []byte) []byte {
+	udp := make([]byte, 8, 8+len(payload))
+	binary.BigEndian.PutUint16(udp, srcPort)
+	binary.BigEndian.PutUint16(udp[2:], dstPort)
+	binary.BigEndian.PutUint16(udp[4:], uint16(8+len(payload)))
+	udp = append(udp, payload...)
+	ip := make([]byte, 20, 20+len(udp))
+	ip[0] = 0x45
+	binary.BigEndian.PutUint16(ip[2:], uint16(20+len(udp)))
+	ip[8] = 64 // TTL
+	ip[9] = 17 // UDP
+	copy(ip[12:], net.ParseIP(src).To4())
+	copy(ip[16:], net.ParseIP(dst).To4())
+	return append(ip, udp...)
+}
+
+// buildUDPv6 builds an IPv6 packet containing a UDP datagram
+func buildUDPv6(src, dst string, srcPort, dstPort uint16, payload []byte) []byte {
+	udp := make([]byte, 8, 8+len(payload))
+	binary.BigEndian.PutUint16(udp, srcPort)
+	binary.BigEndian.PutUint16(udp[2:], dstPort)
+	binary.BigEndian.PutUint16(udp[4:], uint16(8+len(payload)))
+	udp = append(udp, payload...)
+	ip := make([]byte, 40, 40+len(udp))
+	ip[0] = 0x60
+	binary.BigEndian.PutUint16(ip[4:], uint16(len(udp)))
+	ip[6] = 17 // UDP
+	ip[7] = 64 // hop limit
+	copy(ip[8:], net.ParseIP(src).To16())
+	copy(ip[24:], net.ParseIP(dst).To16())
+	return append(ip, udp...)
+}
+
+func ethernetFrame(etherType uint16, payload []byte) []byte {
+	frame := make([]byte, 14, 14+len(payload))
+	binary.BigEndian.PutUint16(frame[12:], etherType)
+	return append(frame, payload...)
+}
+
+func pcapFile(byteOrder binary.ByteOrder, magic uint32, linkType uint32, ts []time.Time, packets [][]byte) []byte {
+	b := make([]byte, 24)
+	byteOrder.PutUint32(b, magic)
+	byteOrder.PutUint16(b[4:], 2)
+	byteOrder.PutUint16(b[6:], 4)
+	byteOrder.PutUint32(b[16:], 65535)
+	byteOrder.PutUint32(b[20:], linkType)
+	for i, p := range packets {
+		hdr := make([]byte, 16)
+		byteOrder.PutUint32(hdr, uint32(ts[i].Unix()))
+		if magic == pcapMagicNanosecond {
+			byteOrder.PutUint32(hdr[4:], uint32(ts[i].Nanosecond()))
+		} else {
+			byteOrder.PutUint32(hdr[4:], uint32(ts[i].Nanosecond()/1000))
+		}
+		byteOrder.PutUint32(hdr[8:], uint32(len(p)))
+		byteOrder.PutUint32(hdr[12:], uint32(len(p)))
+		b = append(b, hdr...)
+		b = append(b, p...)
+	}
+	return b
+}
+
+func pcapngBlock(byteOrder binary.ByteOrder, blockType uint32, body []byte) []byte {
+	for len(body)%4 != 0 {
+		body = append(body, 0)
+	}
+	b := make([]byte, 8, 12+len(body))
+	byteOrder.PutUint32(b, blockType)
+	byteOrder.PutUint32(b[4:], uint32(12+len(body)))
+	b = append(b, body...)
+	l := make([]byte, 4)
+	byteOrder.PutUint32(l, uint32(12+len(body)))
+	return append(b, l...)
+}
+
+func pcapngSHB(byteOrder binary.ByteOrder) []byte {
+	body := make([]byte, 16)
+	byteOrder.PutUint32(body, pcapngByteOrderMagic)
+	byteOrder.PutUint16(body[4:], 1)
+	binary.BigEndian.PutUint64(body[8:], 0xffffffffffffffff) // section length not specified
+	return pcapngBlock(byteOrder, pcapngBlockTypeSHB, body)
+}
+
+func pcapngIDB(byteOrder binary.ByteOrder, linkType uint16, tsResol byte) []byte {
+	body := make([]byte, 8)
+	byteOrder.PutUint16(body, linkType)
+	if tsResol != 0 {
+		opt := make([]byte, 8)
+		byteOrder.PutUint16(opt, 9) // if_tsresol
+		byteOrder.PutUint16(opt[2:], 1)
+		opt[4] = tsResol
+		body = append(body, opt...)
+		body = append(body, 0, 0, 0, 0) // opt_endofopt
+	}
+	return pcapngBlock(byteOrder, pcapngBlockTypeIDB, body)
+}
+
+func pcapngEPB(byteOrder binary.ByteOrder, ifaceID uint32, ts uint64, packet []byte) []byte {
+	body := make([]byte, 20, 20+len(packet))
+	byteOrder.PutUint32(body, ifaceID)
+	byteOrder.PutUint32(body[4:], uint32(ts>>32))
+	byteOrder.PutUint32(body[8:], uint32(ts))
+	byteOrder.PutUint32(body[12:], uint32(len(packet)))
+	byteOrder.PutUint32(body[16:], uint32(len(packet)))
+	return pcapngBlock(byteOrder, pcapngBlockTypeEPB, append(body, packet...))
+}
+
+func readAll(r CaptureReader) []*Datagram {
+	var dgs []*Datagram
+	for {
+		dg, err := r.Next()
+		if err == io.EOF {
+			return dgs
+		}
+		Expect(err).ToNot(HaveOccurred())
+		dgs = append(dgs, dg)
+	}
+}
+
+var _ = Describe("Capture Reader", func() {
+	t1 := time.Unix(1500000000, 123456000)
+	t2 := time.Unix(1500000001, 987654000)
+
+	Context("hex dumps", func() {
+		It("reads datagrams", func() {
+			r, err := NewCaptureReader(strings.NewReader("# a comment\n\ndeadbeef\n< 0xca fe\n> 13 37\n"))
+			Expect(err).ToNot(HaveOccurred())
+			dgs := readAll(r)
+			Expect(dgs).To(Equal([]*Datagram{
+				{Src: HexClientAddr, Dst: HexServerAddr, Data: []byte{0xde, 0xad, 0xbe, 0xef}},
+				{Src: HexServerAddr, Dst: HexClientAddr, Data: []byte{0xca, 0xfe}},
+				{Src: HexClientAddr, Dst: HexServerAddr, Data: []byte{0x13, 0x37}},
+			}))
+		})
+
+		It("errors on invalid hex strings", func() {
+			r := NewHexReader(strings.NewReader("deadbeef\nfoobar\n"))
+			_, err := r.Next()
+			Expect(err).ToNot(HaveOccurred())
+			_, err = r.Next()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(HavePrefix("line 2: "))
+		})
+	})
+
+	Context("pcap", func() {
+		It("reads UDP packets from Ethernet frames", func() {
+			packets := [][]byte{
+				ethernetFrame(0x0800, buildUDPv4("10.0.0.1", "10.0.0.2", 1234, 443, []byte("foo"))),
+				ethernetFrame(0x86dd, buildUDPv6("::1", "::2", 443, 1234, []byte("bar"))),
+			}
+			data := pcapFile(binary.LittleEndian, pcapMagic, linkTypeEthernet, []time.Time{t1, t2}, packets)
+			r, err := NewCaptureReader(bytes.NewReader(data))
+			Expect(err).ToNot(HaveOccurred())
+			dgs := readAll(r)
+			Expect(dgs).To(HaveLen(2))
+			Expect(dgs[0].Src).To(Equal("10.0.0.1:1234"))
+			Expect(dgs[0].Dst).To(Equal("10.0.0.2:443"))
+			Expect(dgs[0].Data).To(Equal([]byte("foo")))
+			Expect(dgs[0].Time).To(Equal(t1))
+			Expect(dgs[1].Src).To(Equal("[::1]:443"))
+			Expect(dgs[1].Dst).To(Equal("[::2]:1234"))
+			Expect(dgs[1].Data).To(Equal([]byte("bar")))
+			Expect(dgs[1].Time).To(Equal(t2))
+		})
+
+		It("reads big endian files with nanosecond timestamps", func() {
+			ts := time.Unix(1500000000, 123456789)
+			packets := [][]byte{buildUDPv4("10.0.0.1", "10.0.0.2", 1234, 443, []byte("foo"))}
+			data := pcapFile(binary.BigEndian, pcapMagicNanosecond, linkTypeRaw, []time.Time{ts}, packets)
+			r, err := NewCaptureReader(bytes.NewReader(data))
+			Expect(err).ToNot(HaveOccurred())
+			dgs := readAll(r)
+			Expect(dgs).To(HaveLen(1))
+			Expect(dgs[0].Time).To(Equal(ts))
+			Expect(dgs[0].Data).To(Equal([]byte("foo")))
+		})
+
+		It("skips packets that are not UDP", func() {
+			tcp := buildUDPv4("10.0.0.1", "10.0.0.2", 1234, 443, []byte("foo"))
+			tcp[9] = 6
+			fragment := buildUDPv4("10.0.0.1", "10.0.0.2", 1234, 443, []byte("foo"))
+			fragment[6] = 0x20 // more fragments
+			packets := [][]byte{
+				ethernetFrame(0x0806, []byte("ARP")),
+				ethernetFrame(0x0800, tcp),
+				ethernetFrame(0x0800, fragment),
+				ethernetFrame(0x0800, buildUDPv4("10.0.0.1", "10.0.0.2", 1234, 443, []byte("bar"))),
+			}
+			data := pcapFile(binary.LittleEndian, pcapMagic, linkTypeEthernet, []time.Time{t1, t1, t1, t2}, packets)
+			r, err := NewCaptureReader(bytes.NewReader(data))
+			Expect(err).ToNot(HaveOccurred())
+			dgs := readAll(r)
+			Expect(dgs).To(HaveLen(1))
+			Expect(dgs[0].Data).To(Equal([]byte("bar")))
+		})
+
+		It("skips VLAN tags", func() {
+			frame := make([]byte, 18)
+			binary.BigEndian.PutUint16(frame[12:], 0x8100)
+			binary.BigEndian.PutUint16(frame[16:], 0x0800)
+			frame = append(frame, buildUDPv4("10.0.0.1", "10.0.0.2", 1234, 443, []byte("foo"))...)
+			data := pcapFile(binary.LittleEndian, pcapMagic, linkTypeEthernet, []time.Time{t1}, [][]byte{frame})
+			r, err := NewCaptureReader(bytes.NewReader(data))
+			Expect(err).ToNot(HaveOccurred())
+			dgs := readAll(r)
+			Expect(dgs).To(HaveLen(1))
+			Expect(dgs[0].Data).To(Equal([]byte("foo")))
+		})
+
+		It("reads packets captured on the loopback interface", func() {
+			packet := append([]byte{2, 0, 0, 0}, buildUDPv4("127.0.0.1", "127.0.0.1", 1234, 443, []byte("foo"))...)
+			data := pcapFile(binary.LittleEndian, pcapMagic, linkTypeNull, []time.Time{t1}, [][]byte{packet})
+			r, err := NewCaptureReader(bytes.NewReader(data))
+			Expect(err).ToNot(HaveOccurred())
+			dgs := readAll(r)
+			Expect(dgs).To(HaveLen(1))
+			Expect(dgs[0].Src).To(Equal("127.0.0.1:1234"))
+		})
+
+		It("errors on truncated files", func() {
+			packets := [][]byte{ethernetFrame(0x0800, buildUDPv4("10.0.0.1", "10.0.0.2", 1234, 443, []byte("foo")))}
+			data := pcapFile(binary.LittleEndian, pcapMagic, linkTypeEthernet, []time.Time{t1}, packets)
+			r, err := NewCaptureReader(bytes.NewReader(data[:len(data)-1]))
+			Expect(err).ToNot(HaveOccurred())
+			_, err = r.Next()
+			Expect(err).To(MatchError("pcap: truncated packet"))
+		})
+	})
+
+	Context("pcapng", func() {
+		for _, bo := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+			byteOrder := bo
+
+			It("reads UDP packets, using "+byteOrder.String(), func() {
+				var data []byte
+				data = append(data, pcapngSHB(byteOrder)...)
+				data = append(data, pcapngIDB(byteOrder, linkTypeEthernet, 0)...)
+				data = append(data, pcapngIDB(byteOrder, linkTypeRaw, 9)...) // nanoseconds
+				data = append(data, pcapngEPB(byteOrder, 0, uint64(t1.UnixNano()/1000), ethernetFrame(0x0800, buildUDPv4("10.0.0.1", "10.0.0.2", 1234, 443, []byte("foo"))))...)
+				data = append(data, pcapngEPB(byteOrder, 1, uint64(t2.UnixNano()), buildUDPv4("10.0.0.2", "10.0.0.1", 443, 1234, []byte("bar")))...)
+				r, err := NewCaptureReader(bytes.NewReader(data))
+				Expect(err).ToNot(HaveOccurred())
+				dgs := readAll(r)
+				Expect(dgs).To(HaveLen(2))
+				Expect(dgs[0].Src).To(Equal("10.0.0.1:1234"))
+				Expect(dgs[0].Data).To(Equal([]byte("foo")))
+				Expect(dgs[0].Time).To(Equal(t1))
+				Expect(dgs[1].Src).To(Equal("10.0.0.2:443"))
+				Expect(dgs[1].Data).To(Equal([]byte("bar")))
+				Expect(dgs[1].Time).To(Equal(t2))
+			})
+		}
+
+		It("errors on packets for unknown interfaces", func() {
+			var data []byte
+			data = append(data, pcapngSHB(binary.LittleEndian)...)
+			data = append(data, pcapngEPB(binary.LittleEndian, 0, 0, buildUDPv4("10.0.0.1", "10.0.0.2", 1234, 443, []byte("foo")))...)
+			r, err := NewCaptureReader(bytes.NewReader(data))
+			Expect(err).ToNot(HaveOccurred())
+			_, err = r.Next()
+			Expect(err).To(MatchError("pcapng: unknown interface 0"))
+		})
+
+		It("errors on truncated blocks", func() {
+			var data []byte
+			data = append(data, pcapngSHB(binary.LittleEndian)...)
+			data = append(data, pcapngIDB(binary.LittleEndian, linkTypeRaw, 0)...)
+			r, err := NewCaptureReader(bytes.NewReader(data[:len(data)-2]))
+			Expect(err).ToNot(HaveOccurred())
+			_, err = r.Next()
+			Expect(err).To(MatchError("pcapng: truncated block"))
+		})
+	})
+})
@@ -0,0 +1,246 @@
+package dump
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/wheelcomplex/qk/wire"
+)
+
+// maxCryptoStreamLen is the maximum amount of data buffered for a crypto stream.
+// Data beyond this limit is ignored.
+const maxCryptoStreamLen = 1 << 20
+
+// A cryptoStream reassembles the data of a crypto stream, and splits it into handshake messages.
+// It is used for the CRYPTO frames of QUIC v1 and the STREAM frames of the gQUIC crypto stream.
+type cryptoStream struct {
+	data    []byte
+	pending map[uint64][]byte
+	// parsed is the offset up to which the data was split into messages
+	parsed int
+}
+
+// write adds the data of a frame
+func (s *cryptoStream) write(offset uint64, data []byte) {
+	if offset+uint64(len(data)) > maxCryptoStreamLen {
+		return
+	}
+	if offset > uint64(len(s.data)) {
+		if s.pending == nil {
+			s.pending = make(map[uint64][]byte)
+		}
+		s.pending[offset] = append([]byte(nil), data...)
+		return
+	}
+	s.append(offset, data)
+	for {
+		var found bool
+		for off, d := range s.pending {
+			if off <= uint64(len(s.data)) {
+				delete(s.pending, off)
+				s.append(off, d)
+				found = true
+			}
+		}
+		if !found {
+			return
+		}
+	}
+}
+
+func (s *cryptoStream) append(offset uint64, data []byte) {
+	if end := offset + uint64(len(data)); end > uint64(len(s.data)) {
+		s.data = append(s.data, data[uint64(len(s.data))-offset:]...)
+	}
+}
+
+// nextTLSMessage returns the next complete TLS handshake message, including the 4 byte message header.
+// It returns nil if no complete message was received yet.
+func (s *cryptoStream) nextTLSMessage() []byte {
+	data := s.data[s.parsed:]
+	if len(data) < 4 {
+		return nil
+	}
+	l := 4 + (int(data[1])<<16 | int(data[2])<<8 | int(data[3]))
+	if len(data) < l {
+		return nil
+	}
+	s.parsed += l
+	return data[:l]
+}
+
+// nextHandshakeMessage returns the next complete gQUIC handshake message.
+// It returns false if no complete message was received yet.
+func (s *cryptoStream) nextHandshakeMessage() (wire.HandshakeMessage, bool, error) {
+	r := bytes.NewReader(s.data[s.parsed:])
+	msg, err := wire.ParseHandshakeMessage(r)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return wire.HandshakeMessage{}, false, nil
+	}
+	if err != nil {
+		// the rest of the stream can't be parsed
+		s.parsed = len(s.data)
+		return wire.HandshakeMessage{}, false, err
+	}
+	s.parsed = len(s.data) - r.Len()
+	return msg, true, nil
+}
+
+// TLS handshake message types, see RFC 8446, section 4
+const (
+	tlsClientHello         = 1
+	tlsServerHello         = 2
+	tlsNewSessionTicket    = 4
+	tlsEndOfEarlyData      = 5
+	tlsEncryptedExtensions = 8
+	tlsCertificate         = 11
+	tlsCertificateRequest  = 13
+	tlsCertificateVerify   = 15
+	tlsFinished            = 20
+	tlsKeyUpdate           = 24
+)
+
+func tlsMessageName(typ byte) string {
+	switch typ {
+	case tlsClientHello:
+		return "ClientHello"
+	case tlsServerHello:
+		return "ServerHello"
+	case tlsNewSessionTicket:
+		return "NewSessionTicket"
+	case tlsEndOfEarlyData:
+		return "EndOfEarlyData"
+	case tlsEncryptedExtensions:
+		return "EncryptedExtensions"
+	case tlsCertificate:
+		return "Certificate"
+	case tlsCertificateRequest:
+		return "CertificateRequest"
+	case tlsCertificateVerify:
+		return "CertificateVerify"
+	case tlsFinished:
+		return "Finished"
+	case tlsKeyUpdate:
+		return "KeyUpdate"
+	default:
+		return fmt.Sprintf("unknown message type %d", typ)
+	}
+}
+
+// A clientHello contains the fields of a ClientHello that are needed to decrypt and summarize a connection
+type clientHello struct {
+	random     []byte
+	serverName string
+	alpn       []string
+}
+
+// TLS extensions, see RFC 8446, section 4.2
+const (
+	tlsExtensionServerName = 0
+	tlsExtensionALPN       = 16
+)
+
+// parseClientHello parses a ClientHello, including the 4 byte message header
+func parseClientHello(msg []byte) (*clientHello, error) {
+	r := &tlsReader{b: msg[4:]}
+	r.skip(2) // legacy_version
+	ch := &clientHello{random: r.read(32)}
+	r.readVector(1) // legacy_session_id
+	r.readVector(2) // cipher_suites
+	r.readVector(1) // legacy_compression_methods
+	exts := &tlsReader{b: r.readVector(2)}
+	if r.err != nil {
+		return nil, r.err
+	}
+	for len(exts.b) > 0 {
+		typ := exts.readUint16()
+		ext := &tlsReader{b: exts.readVector(2)}
+		if exts.err != nil {
+			return nil, exts.err
+		}
+		switch typ {
+		case tlsExtensionServerName:
+			names := &tlsReader{b: ext.readVector(2)}
+			for len(names.b) > 0 {
+				nameType := names.read(1)
+				name := names.readVector(2)
+				if names.err == nil && nameType[0] == 0 { // host_name
+					ch.serverName = string(name)
+				}
+			}
+		case tlsExtensionALPN:
+			protos := &tlsReader{b: ext.readVector(2)}
+			for len(protos.b) > 0 {
+				proto := protos.readVector(1)
+				if protos.err != nil {
+					break
+				}
+				ch.alpn = append(ch.alpn, string(proto))
+			}
+		}
+	}
+	return ch, nil
+}
+
+// parseServerHello parses a ServerHello, including the 4 byte message header, and returns the cipher suite
+func parseServerHello(msg []byte) (uint16, error) {
+	r := &tlsReader{b: msg[4:]}
+	r.skip(2)       // legacy_version
+	r.skip(32)      // random
+	r.readVector(1) // legacy_session_id_echo
+	suite := r.readUint16()
+	return suite, r.err
+}
+
+func (ch *clientHello) String() string {
+	var fields []string
+	if ch.serverName != "" {
+		fields = append(fields, "SNI: "+ch.serverName)
+	}
+	if len(ch.alpn) > 0 {
+		fields = append(fields, "ALPN: "+strings.Join(ch.alpn, ","))
+	}
+	return strings.Join(fields, ", ")
+}
+
+// A tlsReader reads the fields of a TLS message.
+// After the first error, all reads return empty values.
+type tlsReader struct {
+	b   []byte
+	err error
+}
+
+func (r *tlsReader) read(n int) []byte {
+	if r.err != nil {
+		return make([]byte, n)
+	}
+	if len(r.b) < n {
+		r.err = io.ErrUnexpectedEOF
+		r.b = nil
+		return make([]byte, n)
+	}
+	b := r.b[:n]
+	r.b = r.b[n:]
+	return b
+}
+
+func (r *tlsReader) skip(n int) { r.read(n) }
+
+func (r *tlsReader) readUint16() uint16 {
+	return binary.BigEndian.Uint16(r.read(2))
+}
+
+// readVector reads a vector with a length field of lenLen bytes
+func (r *tlsReader) readVector(lenLen int) []byte {
+	var l int
+	for _, b := range r.read(lenLen) {
+		l = l<<8 | int(b)
+	}
+	if r.err != nil {
+		return nil
+	}
+	return r.read(l)
+}
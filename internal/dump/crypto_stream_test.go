@@ -0,0 +1,177 @@
+package dump
+
+import (
+	"bytes"
+	"crypto/tls"
+
+	"github.com/wheelcomplex/qk/internal/handshake"
+	"github.com/wheelcomplex/qk/wire"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func tlsMessage(typ byte, body []byte) []byte {
+	l := len(body)
+	return append([]byte{typ, byte(l >> 16), byte(l >> 8), byte(l)}, body...)
+}
+
+func tlsVector(lenLen int, data []byte) []byte {
+	b := make([]byte, lenLen)
+	for i := range b {
+		b[i] = byte(len(data) >> (8 * uint(lenLen-1-i)))
+	}
+	return append(b, data...)
+}
+
+func tlsExtension(typ uint16, data []byte) []byte {
+	return append([]byte{byte(typ >> 8), byte(typ)}, tlsVector(2, data)...)
+}
+
+// buildClientHello builds a ClientHello, containing only the fields and extensions used by the decoder
+func buildClientHello(random []byte, serverName string, alpn ...string) []byte {
+	body := []byte{0x3, 0x3}
+	body = append(body, random...)
+	body = append(body, tlsVector(1, nil)...)
+	body = append(body, tlsVector(2, []byte{0x13, 0x1})...)
+	body = append(body, tlsVector(1, []byte{0})...)
+	var exts []byte
+	if serverName != "" {
+		name := append([]byte{0}, tlsVector(2, []byte(serverName))...)
+		exts = append(exts, tlsExtension(tlsExtensionServerName, tlsVector(2, name))...)
+	}
+	if len(alpn) > 0 {
+		var protos []byte
+		for _, p := range alpn {
+			protos = append(protos, tlsVector(1, []byte(p))...)
+		}
+		exts = append(exts, tlsExtension(tlsExtensionALPN, tlsVector(2, protos))...)
+	}
+	exts = append(exts, tlsExtension(0x2b, []byte{0x2, 0x3, 0x4})...) // supported_versions
+	body = append(body, tlsVector(2, exts)...)
+	return tlsMessage(tlsClientHello, body)
+}
+
+func buildServerHello(suite uint16) []byte {
+	body := []byte{0x3, 0x3}
+	body = append(body, bytes.Repeat([]byte{0x13}, 32)...)
+	body = append(body, tlsVector(1, nil)...)
+	body = append(body, byte(suite>>8), byte(suite))
+	body = append(body, 0)                    // legacy_compression_method
+	body = append(body, tlsVector(2, nil)...) // extensions
+	return tlsMessage(tlsServerHello, body)
+}
+
+var _ = Describe("Crypto Stream", func() {
+	var str *cryptoStream
+
+	BeforeEach(func() {
+		str = &cryptoStream{}
+	})
+
+	Context("TLS messages", func() {
+		It("splits the stream into messages", func() {
+			msg1 := tlsMessage(tlsEncryptedExtensions, []byte("foo"))
+			msg2 := tlsMessage(tlsFinished, []byte("foobar"))
+			str.write(0, append(msg1, msg2[:3]...))
+			Expect(str.nextTLSMessage()).To(Equal(msg1))
+			Expect(str.nextTLSMessage()).To(BeNil())
+			str.write(uint64(len(msg1)+3), msg2[3:])
+			Expect(str.nextTLSMessage()).To(Equal(msg2))
+			Expect(str.nextTLSMessage()).To(BeNil())
+		})
+
+		It("reassembles data received out of order", func() {
+			msg := tlsMessage(tlsCertificate, []byte("foobar"))
+			str.write(6, msg[6:])
+			Expect(str.nextTLSMessage()).To(BeNil())
+			str.write(3, msg[3:6])
+			Expect(str.nextTLSMessage()).To(BeNil())
+			str.write(0, msg[:4]) // overlaps with the data received before
+			Expect(str.nextTLSMessage()).To(Equal(msg))
+		})
+
+		It("ignores retransmissions", func() {
+			msg := tlsMessage(tlsFinished, []byte("foobar"))
+			str.write(0, msg)
+			Expect(str.nextTLSMessage()).To(Equal(msg))
+			str.write(0, msg)
+			Expect(str.nextTLSMessage()).To(BeNil())
+		})
+
+		It("ignores data beyond the maximum stream length", func() {
+			str.write(maxCryptoStreamLen-2, []byte("foo"))
+			Expect(str.pending).To(BeEmpty())
+		})
+	})
+
+	Context("gQUIC handshake messages", func() {
+		It("splits the stream into messages", func() {
+			msg := wire.HandshakeMessage{
+				Tag:  handshake.TagCHLO,
+				Data: map[handshake.Tag][]byte{handshake.TagSNI: []byte("quic.clemente.io")},
+			}
+			b := &bytes.Buffer{}
+			msg.Write(b)
+			str.write(0, b.Bytes()[:10])
+			_, ok, err := str.nextHandshakeMessage()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ok).To(BeFalse())
+			str.write(10, b.Bytes()[10:])
+			parsed, ok, err := str.nextHandshakeMessage()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ok).To(BeTrue())
+			Expect(parsed).To(Equal(msg))
+			_, ok, err = str.nextHandshakeMessage()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ok).To(BeFalse())
+		})
+
+		It("errors on invalid messages", func() {
+			str.write(0, []byte("CHLO\xff\xff\x00\x00"))
+			_, ok, err := str.nextHandshakeMessage()
+			Expect(err).To(HaveOccurred())
+			Expect(ok).To(BeFalse())
+			_, _, err = str.nextHandshakeMessage()
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+
+	Context("parsing TLS messages", func() {
+		It("parses a ClientHello", func() {
+			random := bytes.Repeat([]byte{0x42}, 32)
+			ch, err := parseClientHello(buildClientHello(random, "quic.clemente.io", "h3", "hq-interop"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ch.random).To(Equal(random))
+			Expect(ch.serverName).To(Equal("quic.clemente.io"))
+			Expect(ch.alpn).To(Equal([]string{"h3", "hq-interop"}))
+			Expect(ch.String()).To(Equal("SNI: quic.clemente.io, ALPN: h3,hq-interop"))
+		})
+
+		It("parses a ClientHello without SNI and ALPN", func() {
+			ch, err := parseClientHello(buildClientHello(make([]byte, 32), ""))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ch.serverName).To(BeEmpty())
+			Expect(ch.alpn).To(BeEmpty())
+			Expect(ch.String()).To(BeEmpty())
+		})
+
+		It("errors on truncated ClientHellos", func() {
+			msg := buildClientHello(make([]byte, 32), "quic.clemente.io")
+			_, err := parseClientHello(msg[:len(msg)-5])
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("parses a ServerHello", func() {
+			suite, err := parseServerHello(buildServerHello(tls.TLS_AES_256_GCM_SHA384))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(suite).To(Equal(tls.TLS_AES_256_GCM_SHA384))
+		})
+
+		It("names TLS messages", func() {
+			Expect(tlsMessageName(tlsClientHello)).To(Equal("ClientHello"))
+			Expect(tlsMessageName(tlsFinished)).To(Equal("Finished"))
+			Expect(tlsMessageName(42)).To(Equal("unknown message type 42"))
+		})
+	})
+})
@@ -0,0 +1,626 @@
+// Package dump decodes captured QUIC packets.
+//
+// It reads the UDP payloads from pcap and pcapng files or hex dumps, groups them into connections,
+// and decodes the packets of all versions supported by qk.
+// Packets that are not encrypted, or whose keys can be derived from the packet itself, are always decrypted:
+// gQUIC packets protected by the NullAEAD, and the Initial packets of IETF QUIC.
+// The Handshake and 1-RTT packets of QUIC v1 are decrypted if the TLS secrets of the connection are contained in a key log.
+package dump
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/wheelcomplex/qk/internal/crypto"
+	"github.com/wheelcomplex/qk/internal/handshake"
+	"github.com/wheelcomplex/qk/internal/protocol"
+	"github.com/wheelcomplex/qk/internal/utils"
+	"github.com/wheelcomplex/qk/wire"
+)
+
+var errNoKeys = errors.New("no keys")
+
+// the packet number spaces of QUIC v1.
+// gQUIC and the IETF draft version use a single packet number space, spaceAppData.
+type packetNumberSpace int
+
+const (
+	spaceInitial packetNumberSpace = iota
+	spaceHandshake
+	spaceAppData
+	numPacketNumberSpaces
+)
+
+// A direction holds the state of the packets sent by one endpoint of a connection
+type direction struct {
+	sentBy protocol.Perspective
+
+	packets       int
+	bytes         int
+	decrypted     int
+	undecryptable int
+
+	largestPacketNumber [numPacketNumberSpaces]protocol.PacketNumber
+	// The crypto streams of QUIC v1 are indexed by the packet number space.
+	// gQUIC uses the stream of spaceAppData.
+	cryptoStreams [numPacketNumberSpaces]cryptoStream
+
+	initialAEAD   crypto.AEAD
+	handshakeAEAD crypto.AEAD
+	oneRTTAEAD    crypto.UpdatableAEAD
+	keyPhase      int
+	// The keys of the previous key phase are kept to open reordered packets,
+	// i.e. packets with a packet number below the first packet of the current key phase.
+	prevOneRTTAEAD        crypto.AEAD
+	firstPacketInKeyPhase protocol.PacketNumber
+}
+
+type connection struct {
+	client, server string
+	version        protocol.VersionNumber
+
+	// the connection IDs chosen by the endpoints, i.e. the Source Connection IDs of their Long Header packets
+	clientConnID, serverConnID protocol.ConnectionID
+	knowsClientConnID          bool
+	knowsServerConnID          bool
+	// the connection ID used to derive the Initial keys
+	initialConnID protocol.ConnectionID
+
+	firstPacket, lastPacket time.Time
+
+	fromClient, fromServer direction
+
+	clientHello *clientHello
+	cipherSuite uint16
+	serverName  string // the SNI sent in the gQUIC CHLO
+
+	handshakeMessages []string
+	frames            map[string]int
+	closeReason       string
+}
+
+func (c *connection) direction(sentBy protocol.Perspective) *direction {
+	if sentBy == protocol.PerspectiveClient {
+		return &c.fromClient
+	}
+	return &c.fromServer
+}
+
+// shortHeaderConnIDLen returns the length of the connection ID of Short Header packets sent to an endpoint
+func (c *connection) shortHeaderConnIDLen(receiver protocol.Perspective) int {
+	if receiver == protocol.PerspectiveClient {
+		if c.knowsClientConnID {
+			return c.clientConnID.Len()
+		}
+		return 0
+	}
+	if c.knowsServerConnID {
+		return c.serverConnID.Len()
+	}
+	return 8
+}
+
+func (c *connection) setConnID(sentBy protocol.Perspective, connID protocol.ConnectionID) {
+	if sentBy == protocol.PerspectiveClient {
+		c.clientConnID = connID
+		c.knowsClientConnID = true
+	} else {
+		c.serverConnID = connID
+		c.knowsServerConnID = true
+	}
+}
+
+// A Decoder decodes datagrams, and writes the packets, frames and handshake messages they contain.
+// Datagrams are grouped into connections by their addresses.
+// The first endpoint of a connection that sends a datagram is assumed to be the client.
+type Decoder struct {
+	out    io.Writer
+	keyLog *KeyLog
+
+	numDatagrams int
+	start        time.Time
+
+	conns       []*connection
+	connsByAddr map[string]*connection
+}
+
+// NewDecoder creates a new Decoder.
+// keyLog may be nil.
+func NewDecoder(out io.Writer, keyLog *KeyLog) *Decoder {
+	return &Decoder{
+		out:         out,
+		keyLog:      keyLog,
+		connsByAddr: make(map[string]*connection),
+	}
+}
+
+// Decode decodes a datagram
+func (d *Decoder) Decode(dg *Datagram) {
+	d.numDatagrams++
+	if d.start.IsZero() {
+		d.start = dg.Time
+	}
+	conn, sentBy := d.getConnection(dg)
+	if conn.firstPacket.IsZero() {
+		conn.firstPacket = dg.Time
+	}
+	conn.lastPacket = dg.Time
+
+	var ts string
+	if !dg.Time.IsZero() {
+		ts = fmt.Sprintf(" %.6fs", dg.Time.Sub(d.start).Seconds())
+	}
+	fmt.Fprintf(d.out, "#%d%s %s -> %s (%d bytes)\n", d.numDatagrams, ts, dg.Src, dg.Dst, len(dg.Data))
+
+	data := dg.Data
+	for len(data) > 0 {
+		var err error
+		data, err = d.decodePacket(conn, sentBy, data)
+		if err != nil {
+			fmt.Fprintf(d.out, "\terror: %s\n", err)
+			return
+		}
+	}
+}
+
+func (d *Decoder) getConnection(dg *Datagram) (*connection, protocol.Perspective) {
+	key := dg.Src + " " + dg.Dst
+	if dg.Dst < dg.Src {
+		key = dg.Dst + " " + dg.Src
+	}
+	conn, ok := d.connsByAddr[key]
+	if !ok {
+		conn = &connection{
+			client: dg.Src,
+			server: dg.Dst,
+			frames: make(map[string]int),
+		}
+		// for hex dumps, the direction is known
+		if dg.Src == HexServerAddr && dg.Dst == HexClientAddr {
+			conn.client, conn.server = dg.Dst, dg.Src
+		}
+		conn.fromClient.sentBy = protocol.PerspectiveClient
+		conn.fromServer.sentBy = protocol.PerspectiveServer
+		d.conns = append(d.conns, conn)
+		d.connsByAddr[key] = conn
+	}
+	if dg.Src == conn.client {
+		return conn, protocol.PerspectiveClient
+	}
+	return conn, protocol.PerspectiveServer
+}
+
+// decodePacket decodes the packet at the beginning of data.
+// It returns the remaining data, which contains the coalesced packets that follow.
+func (d *Decoder) decodePacket(conn *connection, sentBy protocol.Perspective, data []byte) ([]byte, error) {
+	dir := conn.direction(sentBy)
+	dir.packets++
+
+	r := bytes.NewReader(data)
+	iHdr, err := wire.ParseInvariantHeader(r, conn.shortHeaderConnIDLen(sentBy.Opposite()))
+	if err != nil {
+		dir.bytes += len(data)
+		return nil, fmt.Errorf("parsing invariant header failed: %s", err)
+	}
+	if iHdr.IsLongHeader && iHdr.Version != 0 {
+		conn.version = iHdr.Version
+	}
+	hdr, err := iHdr.Parse(r, sentBy, conn.version)
+	if err != nil {
+		dir.bytes += len(data)
+		return nil, fmt.Errorf("parsing header failed: %s", err)
+	}
+	hdr.Raw = data[:len(data)-r.Len()]
+	if hdr.IsPublicHeader && hdr.VersionFlag && sentBy == protocol.PerspectiveClient {
+		conn.version = hdr.Version
+	}
+	payload := data[len(hdr.Raw):]
+	var rest []byte
+	if hdr.IsLongHeader && !hdr.IsVersionNegotiation && hdr.Type != protocol.PacketTypeRetry && conn.version.UsesLengthInHeader() {
+		if protocol.ByteCount(len(payload)) < hdr.PayloadLen {
+			dir.bytes += len(data)
+			fmt.Fprintf(d.out, "\t%s\n", wire.FormatHeader(hdr))
+			return nil, fmt.Errorf("packet payload (%d bytes) is smaller than the expected payload length (%d bytes)", len(payload), hdr.PayloadLen)
+		}
+		rest = payload[hdr.PayloadLen:]
+		payload = payload[:hdr.PayloadLen]
+	}
+	dir.bytes += len(hdr.Raw) + len(payload)
+
+	if hdr.IsLongHeader && !hdr.IsVersionNegotiation {
+		conn.setConnID(sentBy, hdr.SrcConnectionID)
+		if sentBy == protocol.PerspectiveClient && conn.initialConnID == nil {
+			conn.initialConnID = hdr.DestConnectionID
+		}
+	}
+
+	switch {
+	case hdr.IsVersionNegotiation:
+		fmt.Fprintf(d.out, "\tVersion Negotiation: %s\n", hdr.SupportedVersions)
+		return rest, nil
+	case hdr.ResetFlag:
+		fmt.Fprintf(d.out, "\tPublic Reset\n")
+		return rest, nil
+	case hdr.IsLongHeader && hdr.Type == protocol.PacketTypeRetry:
+		fmt.Fprintf(d.out, "\t%s\n", wire.FormatHeader(hdr))
+		if conn.version.UsesV1HeaderFormat() {
+			// the Initial keys are derived from the connection ID chosen by the server
+			conn.initialConnID = hdr.SrcConnectionID
+			conn.fromClient.initialAEAD = nil
+			conn.fromServer.initialAEAD = nil
+		}
+		return rest, nil
+	case hdr.IsLongHeader && !protocol.IsValidVersion(conn.version):
+		fmt.Fprintf(d.out, "\tLong Header{DestConnectionID: %s, SrcConnectionID: %s, Version: %s}\n", hdr.DestConnectionID, hdr.SrcConnectionID, conn.version)
+		return nil, fmt.Errorf("unsupported version %s", conn.version)
+	}
+
+	var decrypted []byte
+	var encLevel protocol.EncryptionLevel
+	switch {
+	case conn.version.UsesV1HeaderFormat():
+		decrypted, encLevel, err = d.openV1(conn, dir, hdr, payload)
+	case hdr.IsPublicHeader || !conn.version.UsesTLS():
+		decrypted, encLevel, err = d.openGQUIC(conn, dir, hdr, payload)
+	default:
+		decrypted, encLevel, err = d.openTLS(conn, dir, hdr, payload)
+	}
+	if err != nil {
+		dir.undecryptable++
+		if hdr.PacketNumberLen == 0 {
+			// For versions using header protection, the packet number was not read.
+			fmt.Fprintf(d.out, "\t%s\n", strings.Replace(wire.FormatHeader(hdr), "PacketNumber: 0x0, PacketNumberLen: 0", "PacketNumber: (protected)", 1))
+		} else {
+			fmt.Fprintf(d.out, "\t%s\n", wire.FormatHeader(hdr))
+		}
+		fmt.Fprintf(d.out, "\tundecryptable payload (%d bytes): %s\n", len(payload), err)
+		return rest, nil
+	}
+	dir.decrypted++
+	fmt.Fprintf(d.out, "\t%s\n", wire.FormatHeader(hdr))
+	fmt.Fprintf(d.out, "\tencryption level: %s, payload: %d bytes\n", encLevel, len(decrypted))
+
+	frames, err := wire.ParseFrames(decrypted, hdr, conn.version)
+	for _, f := range frames {
+		fmt.Fprintf(d.out, "\t%s\n", wire.FormatFrame(f, sentBy == protocol.PerspectiveClient))
+		d.handleFrame(conn, dir, f, encLevel)
+	}
+	if err != nil {
+		fmt.Fprintf(d.out, "\terror parsing frames: %s\n", err)
+	}
+	return rest, nil
+}
+
+// openGQUIC opens gQUIC packets.
+// Only packets protected by the NullAEAD can be opened.
+func (d *Decoder) openGQUIC(conn *connection, dir *direction, hdr *wire.Header, payload []byte) ([]byte, protocol.EncryptionLevel, error) {
+	hdr.PacketNumber = protocol.InferPacketNumber(hdr.PacketNumberLen, dir.largestPacketNumber[spaceAppData], hdr.PacketNumber, conn.version)
+	// The header is not protected, so the packet number can be used to infer the packet number of the next packets,
+	// even if the packet can't be decrypted.
+	dir.largestPacketNumber[spaceAppData] = utils.MaxPacketNumber(dir.largestPacketNumber[spaceAppData], hdr.PacketNumber)
+	aead, err := crypto.NewNullAEAD(dir.sentBy.Opposite(), hdr.DestConnectionID, conn.version)
+	if err != nil {
+		return nil, 0, err
+	}
+	decrypted, err := aead.Open(nil, payload, hdr.PacketNumber, hdr.Raw)
+	if err != nil {
+		// The packet is encrypted with keys derived from the gQUIC handshake.
+		return nil, 0, errNoKeys
+	}
+	return decrypted, protocol.EncryptionUnencrypted, nil
+}
+
+// openTLS opens packets of the IETF draft version.
+// Only Long Header packets can be opened, since their keys are derived from the connection ID.
+func (d *Decoder) openTLS(conn *connection, dir *direction, hdr *wire.Header, payload []byte) ([]byte, protocol.EncryptionLevel, error) {
+	if !hdr.IsLongHeader || conn.initialConnID == nil {
+		return nil, 0, errNoKeys
+	}
+	aead, err := crypto.NewNullAEAD(dir.sentBy.Opposite(), conn.initialConnID, conn.version)
+	if err != nil {
+		return nil, 0, err
+	}
+	pnLen := int(hdr.PacketNumberLen)
+	sampleOffset := crypto.HeaderProtectionSampleOffset - pnLen
+	if len(payload) < sampleOffset+crypto.HeaderProtectionSampleLen {
+		return nil, 0, errors.New("packet too small to sample the ciphertext")
+	}
+	header := make([]byte, len(hdr.Raw))
+	copy(header, hdr.Raw)
+	pnOffset := len(header) - pnLen
+	aead.(crypto.HeaderProtectingAEAD).HeaderProtector().DecryptHeader(
+		payload[sampleOffset:sampleOffset+crypto.HeaderProtectionSampleLen],
+		&header[0],
+		header[pnOffset:],
+	)
+	pn, _, err := utils.ReadVarIntPacketNumber(bytes.NewReader(header[pnOffset:]))
+	if err != nil {
+		return nil, 0, err
+	}
+	hdr.PacketNumber = protocol.InferPacketNumber(hdr.PacketNumberLen, dir.largestPacketNumber[spaceAppData], pn, conn.version)
+	decrypted, err := aead.Open(nil, payload, hdr.PacketNumber, header)
+	if err != nil {
+		return nil, 0, err
+	}
+	dir.largestPacketNumber[spaceAppData] = utils.MaxPacketNumber(dir.largestPacketNumber[spaceAppData], hdr.PacketNumber)
+	return decrypted, protocol.EncryptionUnencrypted, nil
+}
+
+// openV1 opens QUIC v1 packets.
+func (d *Decoder) openV1(conn *connection, dir *direction, hdr *wire.Header, payload []byte) ([]byte, protocol.EncryptionLevel, error) {
+	var space packetNumberSpace
+	var encLevel protocol.EncryptionLevel
+	switch {
+	case !hdr.IsLongHeader:
+		space, encLevel = spaceAppData, protocol.EncryptionForwardSecure
+	case hdr.Type == protocol.PacketTypeInitial:
+		space, encLevel = spaceInitial, protocol.EncryptionInitial
+	case hdr.Type == protocol.PacketTypeHandshake:
+		space, encLevel = spaceHandshake, protocol.EncryptionHandshake
+	default:
+		// decrypting 0-RTT packets would require the early traffic secret, and the cipher suite of the resumed session
+		return nil, 0, errNoKeys
+	}
+	aead := d.getAEADV1(conn, dir, encLevel)
+	if aead == nil {
+		return nil, 0, errNoKeys
+	}
+
+	// remove header protection, see packetUnpackerV1.removeHeaderProtection
+	if len(payload) < crypto.HeaderProtectionSampleOffset+crypto.HeaderProtectionSampleLen {
+		return nil, 0, errors.New("packet too small to sample the ciphertext")
+	}
+	sample := payload[crypto.HeaderProtectionSampleOffset : crypto.HeaderProtectionSampleOffset+crypto.HeaderProtectionSampleLen]
+	pnOffset := len(hdr.Raw)
+	header := make([]byte, pnOffset+4)
+	copy(header, hdr.Raw)
+	copy(header[pnOffset:], payload[:4])
+	aead.(crypto.HeaderProtectingAEAD).HeaderProtector().DecryptHeader(sample, &header[0], header[pnOffset:])
+	pnLen := protocol.PacketNumberLen(header[0]&0x3) + 1
+	header = header[:pnOffset+int(pnLen)]
+	var pn protocol.PacketNumber
+	for _, b := range header[pnOffset:] {
+		pn = pn<<8 | protocol.PacketNumber(b)
+	}
+	hdr.PacketNumberLen = pnLen
+	hdr.PacketNumber = protocol.InferPacketNumber(pnLen, dir.largestPacketNumber[space], pn, conn.version)
+	ciphertext := payload[pnLen:]
+
+	var decrypted []byte
+	var err error
+	if hdr.IsLongHeader {
+		decrypted, err = aead.Open(nil, ciphertext, hdr.PacketNumber, header)
+	} else {
+		hdr.KeyPhase = int(header[0]&0x4) >> 2
+		decrypted, err = d.open1RTT(dir, hdr, ciphertext, header)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	dir.largestPacketNumber[space] = utils.MaxPacketNumber(dir.largestPacketNumber[space], hdr.PacketNumber)
+	return decrypted, encLevel, nil
+}
+
+// open1RTT opens a 1-RTT packet, and follows key updates, see cryptoSetupTLS.Open1RTT
+func (d *Decoder) open1RTT(dir *direction, hdr *wire.Header, ciphertext, header []byte) ([]byte, error) {
+	if hdr.KeyPhase == dir.keyPhase {
+		decrypted, err := dir.oneRTTAEAD.Open(nil, ciphertext, hdr.PacketNumber, header)
+		if err != nil {
+			return nil, err
+		}
+		if hdr.PacketNumber < dir.firstPacketInKeyPhase {
+			dir.firstPacketInKeyPhase = hdr.PacketNumber
+		}
+		return decrypted, nil
+	}
+	// A packet sent before the first packet of the current key phase was sent with the keys of the previous key phase.
+	if dir.prevOneRTTAEAD != nil && hdr.PacketNumber < dir.firstPacketInKeyPhase {
+		return dir.prevOneRTTAEAD.Open(nil, ciphertext, hdr.PacketNumber, header)
+	}
+	next, err := dir.oneRTTAEAD.Next()
+	if err != nil {
+		return nil, err
+	}
+	decrypted, err := next.Open(nil, ciphertext, hdr.PacketNumber, header)
+	if err != nil {
+		return nil, err
+	}
+	dir.prevOneRTTAEAD = dir.oneRTTAEAD
+	dir.oneRTTAEAD = next
+	dir.keyPhase = hdr.KeyPhase
+	dir.firstPacketInKeyPhase = hdr.PacketNumber
+	return decrypted, nil
+}
+
+// getAEADV1 returns the AEAD used to open the packets sent in a direction.
+// It returns nil if the keys are not known.
+func (d *Decoder) getAEADV1(conn *connection, dir *direction, encLevel protocol.EncryptionLevel) crypto.AEAD {
+	switch encLevel {
+	case protocol.EncryptionInitial:
+		if dir.initialAEAD == nil && conn.initialConnID != nil {
+			dir.initialAEAD, _ = crypto.NewInitialAEADV1(conn.initialConnID, dir.sentBy.Opposite())
+		}
+		return dir.initialAEAD
+	case protocol.EncryptionHandshake:
+		if dir.handshakeAEAD == nil {
+			dir.handshakeAEAD = d.newAEADV1(conn, dir, keyLogClientHandshakeSecret, keyLogServerHandshakeSecret)
+		}
+		return dir.handshakeAEAD
+	default:
+		if dir.oneRTTAEAD == nil {
+			if aead := d.newAEADV1(conn, dir, keyLogClientTrafficSecret, keyLogServerTrafficSecret); aead != nil {
+				dir.oneRTTAEAD = aead.(crypto.UpdatableAEAD)
+				dir.firstPacketInKeyPhase = protocol.MaxPacketNumber
+			}
+		}
+		if dir.oneRTTAEAD == nil {
+			return nil
+		}
+		return dir.oneRTTAEAD
+	}
+}
+
+func (d *Decoder) newAEADV1(conn *connection, dir *direction, clientLabel, serverLabel string) crypto.AEAD {
+	if conn.clientHello == nil || conn.cipherSuite == 0 {
+		return nil
+	}
+	clientSecret, serverSecret := d.keyLog.getSecrets(conn.clientHello.random, clientLabel, serverLabel)
+	if clientSecret == nil {
+		return nil
+	}
+	var aead crypto.AEAD
+	var err error
+	if dir.sentBy == protocol.PerspectiveClient {
+		aead, err = crypto.NewAEADV1(conn.cipherSuite, clientSecret, serverSecret)
+	} else {
+		aead, err = crypto.NewAEADV1(conn.cipherSuite, serverSecret, clientSecret)
+	}
+	if err != nil {
+		return nil
+	}
+	return aead
+}
+
+func (d *Decoder) handleFrame(conn *connection, dir *direction, f wire.Frame, encLevel protocol.EncryptionLevel) {
+	conn.frames[frameName(f)]++
+	switch frame := f.(type) {
+	case *wire.CryptoFrame:
+		var space packetNumberSpace
+		switch encLevel {
+		case protocol.EncryptionInitial:
+			space = spaceInitial
+		case protocol.EncryptionHandshake:
+			space = spaceHandshake
+		default:
+			space = spaceAppData
+		}
+		str := &dir.cryptoStreams[space]
+		str.write(uint64(frame.Offset), frame.Data)
+		for msg := str.nextTLSMessage(); msg != nil; msg = str.nextTLSMessage() {
+			d.handleTLSMessage(conn, msg)
+		}
+	case *wire.StreamFrame:
+		// The IETF draft version sends TLS records on the crypto stream.
+		// They are not decoded, since most of them are encrypted.
+		if conn.version.UsesTLS() || frame.StreamID != conn.version.CryptoStreamID() {
+			return
+		}
+		str := &dir.cryptoStreams[spaceAppData]
+		str.write(uint64(frame.Offset), frame.Data)
+		for {
+			msg, ok, err := str.nextHandshakeMessage()
+			if err != nil {
+				fmt.Fprintf(d.out, "\t\terror parsing handshake message: %s\n", err)
+			}
+			if !ok {
+				return
+			}
+			d.handleHandshakeMessage(conn, msg)
+		}
+	case *wire.ConnectionCloseFrame:
+		conn.closeReason = frame.ErrorCode.String()
+		if frame.IsApplicationError {
+			conn.closeReason = fmt.Sprintf("application error %#x", uint64(frame.ErrorCode))
+		}
+		if frame.ReasonPhrase != "" {
+			conn.closeReason += fmt.Sprintf(" (%s)", frame.ReasonPhrase)
+		}
+	}
+}
+
+func (d *Decoder) handleTLSMessage(conn *connection, msg []byte) {
+	name := tlsMessageName(msg[0])
+	conn.handshakeMessages = append(conn.handshakeMessages, name)
+	switch msg[0] {
+	case tlsClientHello:
+		ch, err := parseClientHello(msg)
+		if err != nil {
+			fmt.Fprintf(d.out, "\t\tTLS %s (%d bytes): %s\n", name, len(msg), err)
+			return
+		}
+		conn.clientHello = ch
+		if s := ch.String(); s != "" {
+			fmt.Fprintf(d.out, "\t\tTLS %s (%d bytes): %s\n", name, len(msg), s)
+			return
+		}
+	case tlsServerHello:
+		suite, err := parseServerHello(msg)
+		if err != nil {
+			fmt.Fprintf(d.out, "\t\tTLS %s (%d bytes): %s\n", name, len(msg), err)
+			return
+		}
+		conn.cipherSuite = suite
+		fmt.Fprintf(d.out, "\t\tTLS %s (%d bytes): cipher suite %#x\n", name, len(msg), suite)
+		return
+	}
+	fmt.Fprintf(d.out, "\t\tTLS %s (%d bytes)\n", name, len(msg))
+}
+
+func (d *Decoder) handleHandshakeMessage(conn *connection, msg wire.HandshakeMessage) {
+	lines := strings.Split(strings.TrimSuffix(msg.String(), "\n"), "\n")
+	conn.handshakeMessages = append(conn.handshakeMessages, strings.TrimSpace(strings.TrimSuffix(lines[0], ":")))
+	for _, l := range lines {
+		fmt.Fprintf(d.out, "\t\t%s\n", l)
+	}
+	if sni, ok := msg.Data[handshake.TagSNI]; ok {
+		conn.serverName = string(sni)
+	}
+}
+
+// frameName returns the name of the type of a frame, e.g. StreamFrame
+func frameName(f wire.Frame) string {
+	name := fmt.Sprintf("%T", f)
+	return name[strings.LastIndex(name, ".")+1:]
+}
+
+// WriteSummary writes a summary of every connection
+func (d *Decoder) WriteSummary(w io.Writer) {
+	fmt.Fprintf(w, "%d datagrams, %d connections\n", d.numDatagrams, len(d.conns))
+	for _, conn := range d.conns {
+		fmt.Fprintf(w, "\nConnection %s <-> %s\n", conn.client, conn.server)
+		fmt.Fprintf(w, "\tVersion: %s\n", conn.version)
+		if conn.knowsClientConnID || conn.knowsServerConnID {
+			fmt.Fprintf(w, "\tConnection IDs: client %s, server %s\n", conn.clientConnID, conn.serverConnID)
+		}
+		if !conn.firstPacket.IsZero() {
+			fmt.Fprintf(w, "\tDuration: %s\n", conn.lastPacket.Sub(conn.firstPacket))
+		}
+		if conn.clientHello != nil {
+			if s := conn.clientHello.String(); s != "" {
+				fmt.Fprintf(w, "\t%s\n", s)
+			}
+		}
+		if conn.serverName != "" {
+			fmt.Fprintf(w, "\tSNI: %s\n", conn.serverName)
+		}
+		if conn.cipherSuite != 0 {
+			fmt.Fprintf(w, "\tCipher suite: %#x\n", conn.cipherSuite)
+		}
+		for _, dir := range []*direction{&conn.fromClient, &conn.fromServer} {
+			fmt.Fprintf(w, "\t%s: %d packets (%d bytes), %d decrypted, %d undecryptable\n", dir.sentBy, dir.packets, dir.bytes, dir.decrypted, dir.undecryptable)
+		}
+		if len(conn.handshakeMessages) > 0 {
+			fmt.Fprintf(w, "\tHandshake: %s\n", strings.Join(conn.handshakeMessages, ", "))
+		}
+		if len(conn.frames) > 0 {
+			names := make([]string, 0, len(conn.frames))
+			for name := range conn.frames {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			counts := make([]string, len(names))
+			for i, name := range names {
+				counts[i] = fmt.Sprintf("%s: %d", name, conn.frames[name])
+			}
+			fmt.Fprintf(w, "\tFrames: %s\n", strings.Join(counts, ", "))
+		}
+		if conn.closeReason != "" {
+			fmt.Fprintf(w, "\tClosed: %s\n", conn.closeReason)
+		}
+	}
+}
@@ -0,0 +1,369 @@
+package dump
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/wheelcomplex/qk/internal/crypto"
+	"github.com/wheelcomplex/qk/internal/handshake"
+	"github.com/wheelcomplex/qk/internal/protocol"
+	"github.com/wheelcomplex/qk/qerr"
+	"github.com/wheelcomplex/qk/wire"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// sealPacket writes the header and the frames, and seals the packet, see packetPacker.writeAndSealPacket
+func sealPacket(hdr *wire.Header, frames []wire.Frame, aead crypto.AEAD, v protocol.VersionNumber) []byte {
+	payload := &bytes.Buffer{}
+	for _, f := range frames {
+		Expect(f.Write(payload, v)).To(Succeed())
+	}
+	hpAEAD, usesHeaderProtection := aead.(crypto.HeaderProtectingAEAD)
+	if usesHeaderProtection {
+		// pad the packet, such that the ciphertext can be sampled
+		for payload.Len() < crypto.HeaderProtectionSampleOffset+crypto.HeaderProtectionSampleLen {
+			payload.WriteByte(0)
+		}
+	}
+	if hdr.IsLongHeader {
+		hdr.PayloadLen = protocol.ByteCount(payload.Len() + aead.Overhead())
+		if v.UsesV1HeaderFormat() {
+			hdr.PayloadLen += protocol.ByteCount(hdr.PacketNumberLen)
+		}
+	}
+	b := &bytes.Buffer{}
+	Expect(hdr.Write(b, protocol.PerspectiveClient, v)).To(Succeed())
+	payloadStart := b.Len()
+	raw := append(b.Bytes(), aead.Seal(nil, payload.Bytes(), hdr.PacketNumber, b.Bytes())...)
+	if usesHeaderProtection {
+		pnOffset := payloadStart - int(hdr.PacketNumberLen)
+		sampleOffset := pnOffset + crypto.HeaderProtectionSampleOffset
+		hpAEAD.HeaderProtector().EncryptHeader(raw[sampleOffset:sampleOffset+crypto.HeaderProtectionSampleLen], &raw[0], raw[pnOffset:payloadStart])
+	}
+	return raw
+}
+
+var _ = Describe("Decoder", func() {
+	var (
+		out     *bytes.Buffer
+		summary *bytes.Buffer
+		start   time.Time
+	)
+
+	BeforeEach(func() {
+		out = &bytes.Buffer{}
+		summary = &bytes.Buffer{}
+		start = time.Unix(1500000000, 0)
+	})
+
+	Context("gQUIC", func() {
+		connID := protocol.ConnectionID{1, 2, 3, 4, 5, 6, 7, 8}
+
+		It("decodes a CHLO", func() {
+			chlo := &bytes.Buffer{}
+			wire.HandshakeMessage{
+				Tag:  handshake.TagCHLO,
+				Data: map[handshake.Tag][]byte{handshake.TagSNI: []byte("quic.clemente.io")},
+			}.Write(chlo)
+			aead, err := crypto.NewNullAEAD(protocol.PerspectiveClient, connID, protocol.Version43)
+			Expect(err).ToNot(HaveOccurred())
+			hdr := &wire.Header{
+				IsPublicHeader:   true,
+				VersionFlag:      true,
+				Version:          protocol.Version43,
+				DestConnectionID: connID,
+				PacketNumber:     1,
+				PacketNumberLen:  protocol.PacketNumberLen1,
+			}
+			frames := []wire.Frame{&wire.StreamFrame{StreamID: 1, Data: chlo.Bytes()}}
+			data := sealPacket(hdr, frames, aead, protocol.Version43)
+
+			d := NewDecoder(out, nil)
+			d.Decode(&Datagram{Time: start, Src: "10.0.0.1:1234", Dst: "10.0.0.2:443", Data: data})
+			Expect(out.String()).To(ContainSubstring("#1 0.000000s 10.0.0.1:1234 -> 10.0.0.2:443"))
+			Expect(out.String()).To(ContainSubstring("Public Header{ConnectionID: 0x0102030405060708, PacketNumber: 0x1"))
+			Expect(out.String()).To(ContainSubstring("encryption level: unencrypted"))
+			Expect(out.String()).To(ContainSubstring("-> &wire.StreamFrame{StreamID: 1"))
+			Expect(out.String()).To(ContainSubstring("CHLO"))
+			Expect(out.String()).To(ContainSubstring("quic.clemente.io"))
+
+			d.WriteSummary(summary)
+			Expect(summary.String()).To(ContainSubstring("1 datagrams, 1 connections"))
+			Expect(summary.String()).To(ContainSubstring("Connection 10.0.0.1:1234 <-> 10.0.0.2:443"))
+			Expect(summary.String()).To(ContainSubstring("Version: gQUIC 43"))
+			Expect(summary.String()).To(ContainSubstring("SNI: quic.clemente.io"))
+			Expect(summary.String()).To(ContainSubstring("Handshake: CHLO"))
+			Expect(summary.String()).To(ContainSubstring("Frames: StreamFrame: 1"))
+		})
+
+		It("reports packets that are not protected by the NullAEAD as undecryptable", func() {
+			hdr := &wire.Header{
+				IsPublicHeader:   true,
+				DestConnectionID: connID,
+				PacketNumber:     2,
+				PacketNumberLen:  protocol.PacketNumberLen1,
+			}
+			b := &bytes.Buffer{}
+			Expect(hdr.Write(b, protocol.PerspectiveClient, protocol.Version43)).To(Succeed())
+			b.Write(bytes.Repeat([]byte{0x42}, 30))
+
+			d := NewDecoder(out, nil)
+			d.Decode(&Datagram{Src: "10.0.0.1:1234", Dst: "10.0.0.2:443", Data: b.Bytes()})
+			Expect(out.String()).To(ContainSubstring("undecryptable payload (30 bytes): no keys"))
+			d.WriteSummary(summary)
+			Expect(summary.String()).To(ContainSubstring("Client: 1 packets (%d bytes), 0 decrypted, 1 undecryptable", b.Len()))
+		})
+	})
+
+	Context("QUIC v1", func() {
+		var (
+			origDestConnID = protocol.ConnectionID{0xde, 0xad, 0xbe, 0xef, 0xca, 0xfe, 0x13, 0x37}
+			clientConnID   = protocol.ConnectionID{1, 2, 3, 4}
+			serverConnID   = protocol.ConnectionID{5, 6, 7, 8, 9, 10}
+
+			clientRandom                                 = bytes.Repeat([]byte{0x42}, 32)
+			clientHandshakeSecret, serverHandshakeSecret = bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 32)
+			clientTrafficSecret, serverTrafficSecret     = bytes.Repeat([]byte{3}, 32), bytes.Repeat([]byte{4}, 32)
+		)
+
+		newKeyLog := func() *KeyLog {
+			var lines []string
+			for label, secret := range map[string][]byte{
+				keyLogClientHandshakeSecret: clientHandshakeSecret,
+				keyLogServerHandshakeSecret: serverHandshakeSecret,
+				keyLogClientTrafficSecret:   clientTrafficSecret,
+				keyLogServerTrafficSecret:   serverTrafficSecret,
+			} {
+				lines = append(lines, fmt.Sprintf("%s %x %x", label, clientRandom, secret))
+			}
+			keyLog, err := ParseKeyLog(strings.NewReader(strings.Join(lines, "\n")))
+			Expect(err).ToNot(HaveOccurred())
+			return keyLog
+		}
+
+		// handshakePackets returns the datagrams of a handshake.
+		// The server's 1-RTT packet is sent after a key update.
+		handshakePackets := func() []*Datagram {
+			clientInitialAEAD, err := crypto.NewInitialAEADV1(origDestConnID, protocol.PerspectiveClient)
+			Expect(err).ToNot(HaveOccurred())
+			serverInitialAEAD, err := crypto.NewInitialAEADV1(origDestConnID, protocol.PerspectiveServer)
+			Expect(err).ToNot(HaveOccurred())
+			serverHandshakeAEAD, err := crypto.NewAEADV1(tls.TLS_AES_128_GCM_SHA256, clientHandshakeSecret, serverHandshakeSecret)
+			Expect(err).ToNot(HaveOccurred())
+			client1RTTAEAD, err := crypto.NewAEADV1(tls.TLS_AES_128_GCM_SHA256, serverTrafficSecret, clientTrafficSecret)
+			Expect(err).ToNot(HaveOccurred())
+			server1RTTAEAD, err := crypto.NewAEADV1(tls.TLS_AES_128_GCM_SHA256, clientTrafficSecret, serverTrafficSecret)
+			Expect(err).ToNot(HaveOccurred())
+			serverNext1RTTAEAD, err := server1RTTAEAD.(crypto.UpdatableAEAD).Next()
+			Expect(err).ToNot(HaveOccurred())
+
+			clientInitial := sealPacket(
+				&wire.Header{
+					IsLongHeader:     true,
+					Type:             protocol.PacketTypeInitial,
+					Version:          protocol.Version1,
+					DestConnectionID: origDestConnID,
+					SrcConnectionID:  clientConnID,
+					PacketNumberLen:  protocol.PacketNumberLen2,
+				},
+				[]wire.Frame{&wire.CryptoFrame{Data: buildClientHello(clientRandom, "quic.clemente.io", "h3")}},
+				clientInitialAEAD,
+				protocol.Version1,
+			)
+			serverInitial := sealPacket(
+				&wire.Header{
+					IsLongHeader:     true,
+					Type:             protocol.PacketTypeInitial,
+					Version:          protocol.Version1,
+					DestConnectionID: clientConnID,
+					SrcConnectionID:  serverConnID,
+					PacketNumberLen:  protocol.PacketNumberLen2,
+				},
+				[]wire.Frame{
+					&wire.AckFrame{AckRanges: []wire.AckRange{{Smallest: 0, Largest: 0}}},
+					&wire.CryptoFrame{Data: buildServerHello(tls.TLS_AES_128_GCM_SHA256)},
+				},
+				serverInitialAEAD,
+				protocol.Version1,
+			)
+			serverHandshake := sealPacket(
+				&wire.Header{
+					IsLongHeader:     true,
+					Type:             protocol.PacketTypeHandshake,
+					Version:          protocol.Version1,
+					DestConnectionID: clientConnID,
+					SrcConnectionID:  serverConnID,
+					PacketNumberLen:  protocol.PacketNumberLen2,
+				},
+				[]wire.Frame{&wire.CryptoFrame{Data: tlsMessage(tlsEncryptedExtensions, []byte("foobar"))}},
+				serverHandshakeAEAD,
+				protocol.Version1,
+			)
+			client1RTT := sealPacket(
+				&wire.Header{
+					DestConnectionID: serverConnID,
+					PacketNumberLen:  protocol.PacketNumberLen2,
+				},
+				[]wire.Frame{&wire.PingFrame{}},
+				client1RTTAEAD,
+				protocol.Version1,
+			)
+			server1RTT := sealPacket(
+				&wire.Header{
+					DestConnectionID: clientConnID,
+					PacketNumber:     1,
+					PacketNumberLen:  protocol.PacketNumberLen2,
+					KeyPhase:         1,
+				},
+				[]wire.Frame{&wire.ConnectionCloseFrame{
+					IsApplicationError: true,
+					ErrorCode:          qerr.ErrorCode(0x42),
+					ReasonPhrase:       "bye",
+				}},
+				serverNext1RTTAEAD,
+				protocol.Version1,
+			)
+			return []*Datagram{
+				{Time: start, Src: "10.0.0.1:1234", Dst: "10.0.0.2:443", Data: clientInitial},
+				{Time: start.Add(10 * time.Millisecond), Src: "10.0.0.2:443", Dst: "10.0.0.1:1234", Data: append(serverInitial, serverHandshake...)},
+				{Time: start.Add(20 * time.Millisecond), Src: "10.0.0.1:1234", Dst: "10.0.0.2:443", Data: client1RTT},
+				{Time: start.Add(30 * time.Millisecond), Src: "10.0.0.2:443", Dst: "10.0.0.1:1234", Data: server1RTT},
+			}
+		}
+
+		It("decrypts all packets using the key log", func() {
+			d := NewDecoder(out, newKeyLog())
+			for _, dg := range handshakePackets() {
+				d.Decode(dg)
+			}
+			Expect(out.String()).To(ContainSubstring("encryption level: Initial"))
+			Expect(out.String()).To(ContainSubstring("TLS ClientHello"))
+			Expect(out.String()).To(ContainSubstring("SNI: quic.clemente.io, ALPN: h3"))
+			Expect(out.String()).To(ContainSubstring("TLS ServerHello"))
+			Expect(out.String()).To(ContainSubstring("cipher suite 0x1301"))
+			Expect(out.String()).To(ContainSubstring("encryption level: Handshake"))
+			Expect(out.String()).To(ContainSubstring("TLS EncryptedExtensions"))
+			Expect(out.String()).To(ContainSubstring("-> &wire.PingFrame{}"))
+			Expect(out.String()).To(ContainSubstring("<- &wire.ConnectionCloseFrame{"))
+			Expect(out.String()).ToNot(ContainSubstring("undecryptable"))
+			Expect(out.String()).ToNot(ContainSubstring("error"))
+
+			d.WriteSummary(summary)
+			Expect(summary.String()).To(ContainSubstring("4 datagrams, 1 connections"))
+			Expect(summary.String()).To(ContainSubstring("Version: QUIC v1"))
+			Expect(summary.String()).To(ContainSubstring("Connection IDs: client 0x01020304, server 0x05060708090a"))
+			Expect(summary.String()).To(ContainSubstring("Duration: 30ms"))
+			Expect(summary.String()).To(ContainSubstring("SNI: quic.clemente.io, ALPN: h3"))
+			Expect(summary.String()).To(ContainSubstring("Cipher suite: 0x1301"))
+			Expect(summary.String()).To(ContainSubstring("Client: 2 packets"))
+			Expect(summary.String()).To(ContainSubstring("Server: 3 packets"))
+			Expect(summary.String()).To(ContainSubstring("Handshake: ClientHello, ServerHello, EncryptedExtensions"))
+			Expect(summary.String()).To(ContainSubstring("Frames: AckFrame: 1, ConnectionCloseFrame: 1, CryptoFrame: 3, PingFrame: 1"))
+			Expect(summary.String()).To(ContainSubstring("Closed: application error 0x42 (bye)"))
+		})
+
+		It("decrypts packets that were sent before a key update, and received after it", func() {
+			server1RTTAEAD, err := crypto.NewAEADV1(tls.TLS_AES_128_GCM_SHA256, clientTrafficSecret, serverTrafficSecret)
+			Expect(err).ToNot(HaveOccurred())
+			reordered := sealPacket(
+				&wire.Header{
+					DestConnectionID: clientConnID,
+					PacketNumber:     0,
+					PacketNumberLen:  protocol.PacketNumberLen2,
+				},
+				[]wire.Frame{&wire.PingFrame{}},
+				server1RTTAEAD,
+				protocol.Version1,
+			)
+			d := NewDecoder(out, newKeyLog())
+			for _, dg := range handshakePackets() {
+				d.Decode(dg)
+			}
+			d.Decode(&Datagram{Time: start.Add(40 * time.Millisecond), Src: "10.0.0.2:443", Dst: "10.0.0.1:1234", Data: reordered})
+			Expect(out.String()).To(ContainSubstring("<- &wire.PingFrame{}"))
+			Expect(out.String()).ToNot(ContainSubstring("undecryptable"))
+			d.WriteSummary(summary)
+			Expect(summary.String()).To(ContainSubstring("Server: 4 packets"))
+			Expect(summary.String()).To(ContainSubstring("4 decrypted, 0 undecryptable"))
+		})
+
+		It("only decrypts the Initial packets without a key log", func() {
+			d := NewDecoder(out, nil)
+			for _, dg := range handshakePackets() {
+				d.Decode(dg)
+			}
+			Expect(out.String()).To(ContainSubstring("TLS ClientHello"))
+			Expect(out.String()).To(ContainSubstring("TLS ServerHello"))
+			Expect(out.String()).To(ContainSubstring("PacketNumber: (protected)"))
+			Expect(out.String()).To(ContainSubstring("undecryptable payload"))
+			d.WriteSummary(summary)
+			Expect(summary.String()).To(ContainSubstring("Client: 2 packets"))
+			Expect(summary.String()).To(ContainSubstring("1 decrypted, 1 undecryptable"))
+			Expect(summary.String()).To(ContainSubstring("1 decrypted, 2 undecryptable"))
+		})
+
+		It("reads the direction from hex dumps", func() {
+			var dump string
+			for i, dg := range handshakePackets() {
+				// start with a packet sent by the server
+				if i == 0 {
+					continue
+				}
+				if dg.Src == "10.0.0.2:443" {
+					dump += "< "
+				}
+				dump += hex.EncodeToString(dg.Data) + "\n"
+			}
+			r := NewHexReader(strings.NewReader(dump))
+			d := NewDecoder(out, nil)
+			for _, dg := range readAll(r) {
+				d.Decode(dg)
+			}
+			d.WriteSummary(summary)
+			Expect(summary.String()).To(ContainSubstring("Connection client <-> server"))
+			Expect(summary.String()).To(ContainSubstring("Client: 1 packets"))
+			Expect(summary.String()).To(ContainSubstring("Server: 3 packets"))
+		})
+
+		It("reports Version Negotiation packets", func() {
+			b := &bytes.Buffer{}
+			Expect((&wire.Header{
+				IsLongHeader:     true,
+				Type:             protocol.PacketTypeInitial,
+				Version:          0x1a2a3a4a,
+				DestConnectionID: origDestConnID,
+				SrcConnectionID:  clientConnID,
+				PacketNumberLen:  protocol.PacketNumberLen2,
+				PayloadLen:       22,
+			}).Write(b, protocol.PerspectiveClient, protocol.Version1)).To(Succeed())
+			b.Write(make([]byte, 20))
+			vn := []byte{0x80, 0, 0, 0, 0, 4, 1, 2, 3, 4, 8}
+			vn = append(vn, origDestConnID...)
+			vn = append(vn, 0, 0, 0, 1) // QUIC v1
+
+			d := NewDecoder(out, nil)
+			d.Decode(&Datagram{Src: "10.0.0.1:1234", Dst: "10.0.0.2:443", Data: b.Bytes()})
+			Expect(out.String()).To(ContainSubstring("error: unsupported version 0x1a2a3a4a"))
+			d.Decode(&Datagram{Src: "10.0.0.2:443", Dst: "10.0.0.1:1234", Data: vn})
+			Expect(out.String()).To(ContainSubstring("Version Negotiation: [QUIC v1"))
+		})
+	})
+
+	It("groups datagrams into connections", func() {
+		d := NewDecoder(out, nil)
+		d.Decode(&Datagram{Src: "10.0.0.1:1234", Dst: "10.0.0.2:443", Data: []byte{0x40}})
+		d.Decode(&Datagram{Src: "10.0.0.2:443", Dst: "10.0.0.1:1234", Data: []byte{0x40}})
+		d.Decode(&Datagram{Src: "10.0.0.3:1234", Dst: "10.0.0.2:443", Data: []byte{0x40}})
+		d.WriteSummary(summary)
+		Expect(summary.String()).To(ContainSubstring("3 datagrams, 2 connections"))
+		Expect(summary.String()).To(ContainSubstring("Connection 10.0.0.1:1234 <-> 10.0.0.2:443"))
+		Expect(summary.String()).To(ContainSubstring("Connection 10.0.0.3:1234 <-> 10.0.0.2:443"))
+		Expect(out.String()).To(ContainSubstring("error: parsing invariant header failed"))
+	})
+})
@@ -0,0 +1,13 @@
+package dump
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestDump(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Dump Suite")
+}
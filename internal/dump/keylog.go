@@ -0,0 +1,95 @@
+package dump
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// The labels of the TLS 1.3 secrets in the key log that are needed to decrypt QUIC v1 packets
+const (
+	keyLogClientHandshakeSecret = "CLIENT_HANDSHAKE_TRAFFIC_SECRET"
+	keyLogServerHandshakeSecret = "SERVER_HANDSHAKE_TRAFFIC_SECRET"
+	keyLogClientTrafficSecret   = "CLIENT_TRAFFIC_SECRET_0"
+	keyLogServerTrafficSecret   = "SERVER_TRAFFIC_SECRET_0"
+)
+
+// A KeyLog contains the secrets exported by TLS, indexed by the random of the ClientHello.
+// It is read from the NSS key log format written by crypto/tls when tls.Config.KeyLogWriter is set,
+// and by most other TLS stacks when the SSLKEYLOGFILE environment variable is set.
+type KeyLog struct {
+	secrets map[string]map[string][]byte
+}
+
+// ParseKeyLog parses a key log.
+// Comments and labels that are not needed to decrypt QUIC packets are ignored.
+func ParseKeyLog(r io.Reader) (*KeyLog, error) {
+	k := &KeyLog{secrets: make(map[string]map[string][]byte)}
+	s := bufio.NewScanner(r)
+	var lineNum int
+	for s.Scan() {
+		lineNum++
+		line := strings.TrimSpace(s.Text())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("key log: line %d: expected 3 fields, got %d", lineNum, len(fields))
+		}
+		switch fields[0] {
+		case keyLogClientHandshakeSecret, keyLogServerHandshakeSecret, keyLogClientTrafficSecret, keyLogServerTrafficSecret:
+		default:
+			continue
+		}
+		clientRandom, err := hex.DecodeString(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("key log: line %d: invalid client random: %s", lineNum, err)
+		}
+		secret, err := hex.DecodeString(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("key log: line %d: invalid secret: %s", lineNum, err)
+		}
+		k.add(clientRandom, fields[0], secret)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+func (k *KeyLog) add(clientRandom []byte, label string, secret []byte) {
+	secrets, ok := k.secrets[string(clientRandom)]
+	if !ok {
+		secrets = make(map[string][]byte)
+		k.secrets[string(clientRandom)] = secrets
+	}
+	secrets[label] = secret
+}
+
+// Len returns the number of TLS connections that secrets were logged for
+func (k *KeyLog) Len() int {
+	if k == nil {
+		return 0
+	}
+	return len(k.secrets)
+}
+
+// getSecrets returns the client's and the server's secret
+// It returns nil secrets if at least one of the secrets is not known.
+func (k *KeyLog) getSecrets(clientRandom []byte, clientLabel, serverLabel string) (clientSecret, serverSecret []byte) {
+	if k == nil {
+		return nil, nil
+	}
+	secrets, ok := k.secrets[string(clientRandom)]
+	if !ok {
+		return nil, nil
+	}
+	clientSecret, serverSecret = secrets[clientLabel], secrets[serverLabel]
+	if clientSecret == nil || serverSecret == nil {
+		return nil, nil
+	}
+	return clientSecret, serverSecret
+}
@@ -0,0 +1,66 @@
+package dump
+
+import (
+	"bytes"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Key Log", func() {
+	clientRandom := bytes.Repeat([]byte{0x42}, 32)
+	clientRandomHex := strings.Repeat("42", 32)
+
+	It("parses a key log", func() {
+		keyLog, err := ParseKeyLog(strings.NewReader(`# a comment
+CLIENT_HANDSHAKE_TRAFFIC_SECRET ` + clientRandomHex + ` 0102
+SERVER_HANDSHAKE_TRAFFIC_SECRET ` + clientRandomHex + ` 0304
+CLIENT_TRAFFIC_SECRET_0 ` + clientRandomHex + ` 0506
+SERVER_TRAFFIC_SECRET_0 ` + clientRandomHex + ` 0708
+EXPORTER_SECRET ` + clientRandomHex + ` 090a
+`))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(keyLog.Len()).To(Equal(1))
+		clientSecret, serverSecret := keyLog.getSecrets(clientRandom, keyLogClientHandshakeSecret, keyLogServerHandshakeSecret)
+		Expect(clientSecret).To(Equal([]byte{1, 2}))
+		Expect(serverSecret).To(Equal([]byte{3, 4}))
+		clientSecret, serverSecret = keyLog.getSecrets(clientRandom, keyLogClientTrafficSecret, keyLogServerTrafficSecret)
+		Expect(clientSecret).To(Equal([]byte{5, 6}))
+		Expect(serverSecret).To(Equal([]byte{7, 8}))
+	})
+
+	It("only returns secrets if both secrets are known", func() {
+		keyLog, err := ParseKeyLog(strings.NewReader("CLIENT_HANDSHAKE_TRAFFIC_SECRET " + clientRandomHex + " 0102\n"))
+		Expect(err).ToNot(HaveOccurred())
+		clientSecret, serverSecret := keyLog.getSecrets(clientRandom, keyLogClientHandshakeSecret, keyLogServerHandshakeSecret)
+		Expect(clientSecret).To(BeNil())
+		Expect(serverSecret).To(BeNil())
+	})
+
+	It("doesn't return secrets for unknown connections", func() {
+		keyLog, err := ParseKeyLog(strings.NewReader(""))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(keyLog.Len()).To(BeZero())
+		clientSecret, _ := keyLog.getSecrets(clientRandom, keyLogClientHandshakeSecret, keyLogServerHandshakeSecret)
+		Expect(clientSecret).To(BeNil())
+	})
+
+	It("handles a nil key log", func() {
+		var keyLog *KeyLog
+		Expect(keyLog.Len()).To(BeZero())
+		clientSecret, _ := keyLog.getSecrets(clientRandom, keyLogClientHandshakeSecret, keyLogServerHandshakeSecret)
+		Expect(clientSecret).To(BeNil())
+	})
+
+	It("errors on lines with the wrong number of fields", func() {
+		_, err := ParseKeyLog(strings.NewReader("# comment\nCLIENT_TRAFFIC_SECRET_0 " + clientRandomHex + "\n"))
+		Expect(err).To(MatchError("key log: line 2: expected 3 fields, got 2"))
+	})
+
+	It("errors on invalid secrets", func() {
+		_, err := ParseKeyLog(strings.NewReader("CLIENT_TRAFFIC_SECRET_0 " + clientRandomHex + " foobar\n"))
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(HavePrefix("key log: line 1: invalid secret"))
+	})
+})
@@ -26,6 +26,7 @@ type baseFlowController struct {
 	epochStartTime   time.Time
 	epochStartOffset protocol.ByteCount
 	rttStats         *congestion.RTTStats
+	clock            congestion.Clock
 
 	logger utils.Logger
 }
@@ -105,7 +106,7 @@ func (c *baseFlowController) maybeAdjustWindowSize() {
 	}
 
 	fraction := float64(bytesReadInEpoch) / float64(c.receiveWindowSize)
-	if time.Since(c.epochStartTime) < time.Duration(4*fraction*float64(rtt)) {
+	if c.clock.Now().Sub(c.epochStartTime) < time.Duration(4*fraction*float64(rtt)) {
 		// window is consumed too fast, try to increase the window size
 		c.receiveWindowSize = utils.MinByteCount(2*c.receiveWindowSize, c.maxReceiveWindowSize)
 	}
@@ -113,7 +114,7 @@ func (c *baseFlowController) maybeAdjustWindowSize() {
 }
 
 func (c *baseFlowController) startNewAutoTuningEpoch() {
-	c.epochStartTime = time.Now()
+	c.epochStartTime = c.clock.Now()
 	c.epochStartOffset = c.bytesRead
 }
 
@@ -27,6 +27,7 @@ var _ = Describe("Base Flow controller", func() {
 	BeforeEach(func() {
 		controller = &baseFlowController{}
 		controller.rttStats = &congestion.RTTStats{}
+		controller.clock = congestion.DefaultClock{}
 	})
 
 	Context("send flow control", func() {
@@ -24,11 +24,13 @@ func NewConnectionFlowController(
 	maxReceiveWindow protocol.ByteCount,
 	queueWindowUpdate func(),
 	rttStats *congestion.RTTStats,
+	clock congestion.Clock,
 	logger utils.Logger,
 ) ConnectionFlowController {
 	return &connectionFlowController{
 		baseFlowController: baseFlowController{
 			rttStats:             rttStats,
+			clock:                clock,
 			receiveWindow:        receiveWindow,
 			receiveWindowSize:    receiveWindow,
 			maxReceiveWindowSize: maxReceiveWindow,
@@ -34,6 +34,7 @@ func NewStreamFlowController(
 	initialSendWindow protocol.ByteCount,
 	queueWindowUpdate func(protocol.StreamID),
 	rttStats *congestion.RTTStats,
+	clock congestion.Clock,
 	logger utils.Logger,
 ) StreamFlowController {
 	return &streamFlowController{
@@ -43,6 +44,7 @@ func NewStreamFlowController(
 		queueWindowUpdate:       func() { queueWindowUpdate(streamID) },
 		baseFlowController: baseFlowController{
 			rttStats:             rttStats,
+			clock:                clock,
 			receiveWindow:        receiveWindow,
 			receiveWindowSize:    receiveWindow,
 			maxReceiveWindowSize: maxReceiveWindow,
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"net"
 	"time"
+
+	"github.com/wheelcomplex/qk/internal/protocol"
 )
 
 const (
@@ -17,12 +19,15 @@ type Cookie struct {
 	RemoteAddr string
 	// The time that the STK was issued (resolution 1 second)
 	SentTime time.Time
+	// The original destination connection ID, only set for tokens sent in a QUIC v1 Retry packet
+	OriginalDestConnectionID protocol.ConnectionID
 }
 
 // token is the struct that is used for ASN1 serialization and deserialization
 type token struct {
-	Data      []byte
-	Timestamp int64
+	Data                     []byte
+	Timestamp                int64
+	OriginalDestConnectionID []byte `asn1:"optional"`
 }
 
 // A CookieGenerator generates Cookies
@@ -53,6 +58,21 @@ func (g *CookieGenerator) NewToken(raddr net.Addr) ([]byte, error) {
 	return g.cookieProtector.NewToken(data)
 }
 
+// NewRetryToken generates a new Cookie for a QUIC v1 Retry packet.
+// In addition to the source address, it encodes the original destination connection ID,
+// such that the server can send it in its transport parameters.
+func (g *CookieGenerator) NewRetryToken(raddr net.Addr, origDestConnID protocol.ConnectionID) ([]byte, error) {
+	data, err := asn1.Marshal(token{
+		Data:                     encodeRemoteAddr(raddr),
+		Timestamp:                time.Now().Unix(),
+		OriginalDestConnectionID: origDestConnID.Bytes(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return g.cookieProtector.NewToken(data)
+}
+
 // DecodeToken decodes a Cookie
 func (g *CookieGenerator) DecodeToken(encrypted []byte) (*Cookie, error) {
 	// if the client didn't send any Cookie, DecodeToken will be called with a nil-slice
@@ -75,6 +95,8 @@ func (g *CookieGenerator) DecodeToken(encrypted []byte) (*Cookie, error) {
 	return &Cookie{
 		RemoteAddr: decodeRemoteAddr(t.Data),
 		SentTime:   time.Unix(t.Timestamp, 0),
+		// tokens generated by NewToken don't contain an original destination connection ID
+		OriginalDestConnectionID: protocol.ConnectionID(t.OriginalDestConnectionID),
 	}, nil
 }
 
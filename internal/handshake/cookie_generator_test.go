@@ -5,6 +5,8 @@ import (
 	"net"
 	"time"
 
+	"github.com/wheelcomplex/qk/internal/protocol"
+
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 )
@@ -43,6 +45,23 @@ var _ = Describe("Cookie Generator", func() {
 		Expect(cookie.SentTime).To(BeTemporally("~", time.Now(), 2*time.Second))
 	})
 
+	It("encodes the original destination connection ID in Retry tokens", func() {
+		token, err := cookieGen.NewRetryToken(&net.UDPAddr{IP: net.IPv4(192, 168, 0, 1), Port: 1337}, protocol.ConnectionID{0xde, 0xad, 0xbe, 0xef})
+		Expect(err).ToNot(HaveOccurred())
+		cookie, err := cookieGen.DecodeToken(token)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(cookie.RemoteAddr).To(Equal("192.168.0.1"))
+		Expect(cookie.OriginalDestConnectionID).To(Equal(protocol.ConnectionID{0xde, 0xad, 0xbe, 0xef}))
+	})
+
+	It("doesn't set an original destination connection ID for other tokens", func() {
+		token, err := cookieGen.NewToken(&net.UDPAddr{IP: net.IPv4(192, 168, 0, 1), Port: 1337})
+		Expect(err).ToNot(HaveOccurred())
+		cookie, err := cookieGen.DecodeToken(token)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(cookie.OriginalDestConnectionID).To(BeEmpty())
+	})
+
 	It("rejects invalid tokens", func() {
 		_, err := cookieGen.DecodeToken([]byte("invalid token"))
 		Expect(err).To(HaveOccurred())
@@ -0,0 +1,244 @@
+//go:build go1.21
+// +build go1.21
+
+package handshake
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/wheelcomplex/qk/internal/crypto"
+	"github.com/wheelcomplex/qk/internal/protocol"
+)
+
+// maxHandshakeMessageSize is the maximum size of a TLS handshake message that we accept.
+// This is the same limit that crypto/tls uses.
+const maxHandshakeMessageSize = 1 << 16
+
+// cryptoSetupQUICTLS is a CryptoSetupTLS that uses crypto/tls's QUIC API for the handshake.
+// It shares the handling of the keys (including key updates and header protection) with the mint based crypto setup.
+// All handshake messages are sent on the crypto stream, regardless of the TLS encryption level.
+type cryptoSetupQUICTLS struct {
+	*cryptoSetupTLS
+
+	qconn        *tls.QUICConn
+	cryptoStream io.ReadWriter
+	extHandler   TLSExtensionHandler
+
+	// the encryption level at which crypto/tls expects the next handshake message
+	readLevel tls.QUICEncryptionLevel
+	suite     uint16
+	// the 1-RTT traffic secrets
+	readSecret, writeSecret []byte
+
+	// set when crypto/tls signaled that the handshake completed
+	handshakeDoneRcvd bool
+	handshakeComplete bool
+	connState         ConnectionState
+}
+
+var _ CryptoSetupTLS = &cryptoSetupQUICTLS{}
+
+// NewCryptoSetupQUICTLSServer creates a new TLS CryptoSetup instance for a server, using crypto/tls
+func NewCryptoSetupQUICTLSServer(
+	cryptoStream io.ReadWriter,
+	connID protocol.ConnectionID,
+	tlsConf *tls.Config,
+	extHandler TLSExtensionHandler,
+	handshakeEvent chan<- struct{},
+	keyUpdateInterval uint64,
+	version protocol.VersionNumber,
+) (CryptoSetupTLS, error) {
+	return newCryptoSetupQUICTLS(cryptoStream, connID, tlsConf, extHandler, handshakeEvent, keyUpdateInterval, protocol.PerspectiveServer, version)
+}
+
+// NewCryptoSetupQUICTLSClient creates a new TLS CryptoSetup instance for a client, using crypto/tls
+func NewCryptoSetupQUICTLSClient(
+	cryptoStream io.ReadWriter,
+	connID protocol.ConnectionID,
+	tlsConf *tls.Config,
+	extHandler TLSExtensionHandler,
+	handshakeEvent chan<- struct{},
+	keyUpdateInterval uint64,
+	version protocol.VersionNumber,
+) (CryptoSetupTLS, error) {
+	return newCryptoSetupQUICTLS(cryptoStream, connID, tlsConf, extHandler, handshakeEvent, keyUpdateInterval, protocol.PerspectiveClient, version)
+}
+
+func newCryptoSetupQUICTLS(
+	cryptoStream io.ReadWriter,
+	connID protocol.ConnectionID,
+	tlsConf *tls.Config,
+	extHandler TLSExtensionHandler,
+	handshakeEvent chan<- struct{},
+	keyUpdateInterval uint64,
+	perspective protocol.Perspective,
+	version protocol.VersionNumber,
+) (CryptoSetupTLS, error) {
+	if tlsConf == nil {
+		return nil, errors.New("CryptoSetup: no tls.Config")
+	}
+	nullAEAD, err := crypto.NewNullAEAD(perspective, connID, version)
+	if err != nil {
+		return nil, err
+	}
+	// QUIC requires TLS 1.3
+	conf := tlsConf.Clone()
+	conf.MinVersion = tls.VersionTLS13
+	var qconn *tls.QUICConn
+	if perspective == protocol.PerspectiveServer {
+		qconn = tls.QUICServer(&tls.QUICConfig{TLSConfig: conf})
+	} else {
+		qconn = tls.QUICClient(&tls.QUICConfig{TLSConfig: conf})
+	}
+	return &cryptoSetupQUICTLS{
+		cryptoSetupTLS: &cryptoSetupTLS{
+			nullAEAD:                 nullAEAD,
+			handshakeHeaderProtector: getHeaderProtector(nullAEAD),
+			perspective:              perspective,
+			handshakeEvent:           handshakeEvent,
+			numPacketsSealed:         new(uint64),
+			keyUpdateInterval:        keyUpdateInterval,
+		},
+		qconn:        qconn,
+		cryptoStream: cryptoStream,
+		extHandler:   extHandler,
+		readLevel:    tls.QUICEncryptionLevelInitial,
+	}, nil
+}
+
+func (h *cryptoSetupQUICTLS) HandleCryptoStream() error {
+	defer h.qconn.Close()
+
+	h.qconn.SetTransportParameters(h.extHandler.TransportParameters())
+	if err := h.qconn.Start(context.Background()); err != nil {
+		return h.wrapError(err)
+	}
+	if err := h.processEvents(); err != nil {
+		return err
+	}
+	// Keep reading from the crypto stream after the handshake completed,
+	// to process post-handshake messages, e.g. session tickets.
+	// This only returns when the crypto stream is closed.
+	for {
+		msg, err := readHandshakeMessage(h.cryptoStream)
+		if err != nil {
+			return err
+		}
+		if err := h.qconn.HandleData(h.readLevel, msg); err != nil {
+			return h.wrapError(err)
+		}
+		if err := h.processEvents(); err != nil {
+			return err
+		}
+	}
+}
+
+// processEvents handles all events that crypto/tls generated since the last call.
+func (h *cryptoSetupQUICTLS) processEvents() error {
+	for {
+		ev := h.qconn.NextEvent()
+		switch ev.Kind {
+		case tls.QUICNoEvent:
+			// crypto/tls might provide the 1-RTT read secret after signaling completion of the handshake
+			if h.handshakeDoneRcvd && !h.handshakeComplete {
+				if err := h.handshakeDone(); err != nil {
+					return err
+				}
+				// process the events generated when sending the session ticket
+				continue
+			}
+			return nil
+		case tls.QUICSetReadSecret:
+			h.readLevel = ev.Level
+			if ev.Level == tls.QUICEncryptionLevelApplication {
+				h.suite = ev.Suite
+				h.readSecret = append([]byte{}, ev.Data...)
+			}
+		case tls.QUICSetWriteSecret:
+			if ev.Level == tls.QUICEncryptionLevelApplication {
+				h.writeSecret = append([]byte{}, ev.Data...)
+			}
+		case tls.QUICWriteData:
+			if _, err := h.cryptoStream.Write(ev.Data); err != nil {
+				return err
+			}
+		case tls.QUICTransportParametersRequired:
+			h.qconn.SetTransportParameters(h.extHandler.TransportParameters())
+		case tls.QUICTransportParameters:
+			if err := h.extHandler.ReceivedTransportParameters(ev.Data); err != nil {
+				return err
+			}
+		case tls.QUICHandshakeDone:
+			h.handshakeDoneRcvd = true
+		}
+	}
+}
+
+func (h *cryptoSetupQUICTLS) handshakeDone() error {
+	if h.readSecret == nil || h.writeSecret == nil {
+		return errors.New("CryptoSetup: handshake completed without 1-RTT secrets")
+	}
+	aead, err := crypto.DeriveAESKeysFromSecrets(h.suite, h.readSecret, h.writeSecret)
+	if err != nil {
+		return err
+	}
+	tlsState := h.qconn.ConnectionState()
+	h.mutex.Lock()
+	h.handshakeComplete = true
+	h.connState = ConnectionState{
+		HandshakeComplete:  true,
+		ServerName:         tlsState.ServerName,
+		PeerCertificates:   tlsState.PeerCertificates,
+		NegotiatedProtocol: tlsState.NegotiatedProtocol,
+	}
+	h.mutex.Unlock()
+	h.set1RTTAEAD(aead)
+
+	if h.perspective == protocol.PerspectiveServer {
+		// The ticket is sent in a WriteData event.
+		// Errors are ignored: crypto/tls returns an error if session tickets are disabled.
+		_ = sendSessionTicket(h.qconn)
+	}
+	return nil
+}
+
+func (h *cryptoSetupQUICTLS) wrapError(err error) error {
+	var alert tls.AlertError
+	if errors.As(err, &alert) {
+		return fmt.Errorf("TLS handshake error: %s (Alert %d)", alert.Error(), uint8(alert))
+	}
+	return fmt.Errorf("TLS handshake error: %s", err.Error())
+}
+
+// readHandshakeMessage reads one TLS handshake message.
+// crypto/tls expects complete messages, consisting of a 1 byte type, a 3 byte length, and the message body.
+func readHandshakeMessage(r io.Reader) ([]byte, error) {
+	hdr := make([]byte, 4)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, err
+	}
+	length := int(hdr[1])<<16 | int(hdr[2])<<8 | int(hdr[3])
+	if length > maxHandshakeMessageSize {
+		return nil, fmt.Errorf("CryptoSetup: handshake message too large (%d bytes)", length)
+	}
+	msg := make([]byte, 4+length)
+	copy(msg, hdr)
+	if _, err := io.ReadFull(r, msg[4:]); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func (h *cryptoSetupQUICTLS) ConnectionState() ConnectionState {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	// crypto/tls blocks ConnectionState calls while the handshake is running
+	if !h.handshakeComplete {
+		return ConnectionState{}
+	}
+	return h.connState
+}
@@ -0,0 +1,280 @@
+//go:build go1.21
+// +build go1.21
+
+package handshake
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/wheelcomplex/qk/internal/protocol"
+	"github.com/wheelcomplex/qk/internal/utils"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// bufferedPipe is one direction of an in-memory crypto stream.
+// Contrary to io.Pipe, writes never block.
+type bufferedPipe struct {
+	mutex  sync.Mutex
+	cond   *sync.Cond
+	data   []byte
+	closed bool
+}
+
+func newBufferedPipe() *bufferedPipe {
+	p := &bufferedPipe{}
+	p.cond = sync.NewCond(&p.mutex)
+	return p
+}
+
+func (p *bufferedPipe) Write(b []byte) (int, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.data = append(p.data, b...)
+	p.cond.Broadcast()
+	return len(b), nil
+}
+
+func (p *bufferedPipe) Read(b []byte) (int, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for len(p.data) == 0 && !p.closed {
+		p.cond.Wait()
+	}
+	if len(p.data) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(b, p.data)
+	p.data = p.data[n:]
+	return n, nil
+}
+
+func (p *bufferedPipe) Close() {
+	p.mutex.Lock()
+	p.closed = true
+	p.cond.Broadcast()
+	p.mutex.Unlock()
+}
+
+type cryptoStreamEndpoint struct {
+	io.Reader
+	io.Writer
+}
+
+func generateTestCertificate(serverName string) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).ToNot(HaveOccurred())
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: serverName},
+		DNSNames:              []string{serverName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	Expect(err).ToNot(HaveOccurred())
+	cert, err := x509.ParseCertificate(certDER)
+	Expect(err).ToNot(HaveOccurred())
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return tls.Certificate{Certificate: [][]byte{certDER}, PrivateKey: key}, pool
+}
+
+var _ = Describe("crypto/tls Crypto Setup", func() {
+	var (
+		clientConf, serverConf       *tls.Config
+		clientStream, serverStream   *bufferedPipe
+		clientEvent, serverEvent     chan struct{}
+		clientParams, serverParams   *TransportParameters
+		clientHandler, serverHandler TLSExtensionHandler
+	)
+
+	BeforeEach(func() {
+		cert, pool := generateTestCertificate("quic.clemente.io")
+		serverConf = &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			NextProtos:   []string{"proto"},
+		}
+		clientConf = &tls.Config{
+			ServerName: "quic.clemente.io",
+			RootCAs:    pool,
+			NextProtos: []string{"proto"},
+		}
+		clientStream = newBufferedPipe()
+		serverStream = newBufferedPipe()
+		clientEvent = make(chan struct{}, 1)
+		serverEvent = make(chan struct{}, 1)
+		clientParams = &TransportParameters{IdleTimeout: 42 * time.Second}
+		serverParams = &TransportParameters{
+			IdleTimeout:         1337 * time.Second,
+			StatelessResetToken: make([]byte, 16),
+		}
+		versions := []protocol.VersionNumber{protocol.VersionTLS}
+		clientHandler = NewExtensionHandlerClient(clientParams, protocol.VersionTLS, versions, protocol.VersionTLS, utils.DefaultLogger)
+		serverHandler = NewExtensionHandlerServer(serverParams, versions, protocol.VersionTLS, utils.DefaultLogger)
+	})
+
+	AfterEach(func() {
+		clientStream.Close()
+		serverStream.Close()
+	})
+
+	newClient := func() CryptoSetupTLS {
+		cs, err := NewCryptoSetupQUICTLSClient(
+			&cryptoStreamEndpoint{Reader: serverStream, Writer: clientStream},
+			protocol.ConnectionID{1, 2, 3, 4, 5, 6, 7, 8},
+			clientConf,
+			clientHandler,
+			clientEvent,
+			0,
+			protocol.VersionTLS,
+		)
+		Expect(err).ToNot(HaveOccurred())
+		return cs
+	}
+
+	newServer := func() CryptoSetupTLS {
+		cs, err := NewCryptoSetupQUICTLSServer(
+			&cryptoStreamEndpoint{Reader: clientStream, Writer: serverStream},
+			protocol.ConnectionID{1, 2, 3, 4, 5, 6, 7, 8},
+			serverConf,
+			serverHandler,
+			serverEvent,
+			0,
+			protocol.VersionTLS,
+		)
+		Expect(err).ToNot(HaveOccurred())
+		return cs
+	}
+
+	It("errors without a tls.Config", func() {
+		_, err := NewCryptoSetupQUICTLSClient(nil, protocol.ConnectionID{}, nil, clientHandler, clientEvent, 0, protocol.VersionTLS)
+		Expect(err).To(MatchError("CryptoSetup: no tls.Config"))
+	})
+
+	It("handshakes and exchanges transport parameters", func() {
+		client := newClient()
+		server := newServer()
+		Expect(client.ConnectionState().HandshakeComplete).To(BeFalse())
+		clientErrChan := make(chan error, 1)
+		serverErrChan := make(chan error, 1)
+		go func() {
+			defer GinkgoRecover()
+			clientErrChan <- client.HandleCryptoStream()
+		}()
+		go func() {
+			defer GinkgoRecover()
+			serverErrChan <- server.HandleCryptoStream()
+		}()
+
+		var paramsRcvdByClient TransportParameters
+		Eventually(clientHandler.GetPeerParams()).Should(Receive(&paramsRcvdByClient))
+		Expect(paramsRcvdByClient.IdleTimeout).To(Equal(1337 * time.Second))
+		var paramsRcvdByServer TransportParameters
+		Eventually(serverHandler.GetPeerParams()).Should(Receive(&paramsRcvdByServer))
+		Expect(paramsRcvdByServer.IdleTimeout).To(Equal(42 * time.Second))
+
+		Eventually(clientEvent).Should(Receive())
+		Eventually(serverEvent).Should(Receive())
+		Expect(clientEvent).To(BeClosed())
+		Expect(serverEvent).To(BeClosed())
+
+		clientState := client.ConnectionState()
+		Expect(clientState.HandshakeComplete).To(BeTrue())
+		Expect(clientState.NegotiatedProtocol).To(Equal("proto"))
+		Expect(clientState.PeerCertificates).To(HaveLen(1))
+		serverState := server.ConnectionState()
+		Expect(serverState.HandshakeComplete).To(BeTrue())
+		Expect(serverState.ServerName).To(Equal("quic.clemente.io"))
+
+		// the server can open packets sealed by the client, and vice versa
+		encLevel, sealer := client.GetSealer()
+		Expect(encLevel).To(Equal(protocol.EncryptionForwardSecure))
+		sealed := sealer.Seal(nil, []byte("foobar"), 10, []byte("aad"))
+		opened, err := server.Open1RTT(nil, sealed, 10, 0, []byte("aad"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(opened).To(Equal([]byte("foobar")))
+		_, sealer = server.GetSealer()
+		sealed = sealer.Seal(nil, []byte("raboof"), 20, []byte("aad"))
+		opened, err = client.Open1RTT(nil, sealed, 20, 0, []byte("aad"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(opened).To(Equal([]byte("raboof")))
+		_, err = server.GetHeaderProtector(protocol.EncryptionForwardSecure)
+		Expect(err).ToNot(HaveOccurred())
+
+		// HandleCryptoStream only returns when the crypto stream is closed
+		Consistently(clientErrChan).ShouldNot(Receive())
+		serverStream.Close()
+		Eventually(clientErrChan).Should(Receive(Equal(io.EOF)))
+		clientStream.Close()
+		Eventually(serverErrChan).Should(Receive(Equal(io.EOF)))
+	})
+
+	It("errors if the certificate can't be verified", func() {
+		clientConf.RootCAs = nil
+		client := newClient()
+		server := newServer()
+		clientErrChan := make(chan error, 1)
+		go func() {
+			defer GinkgoRecover()
+			clientErrChan <- client.HandleCryptoStream()
+		}()
+		go func() {
+			defer GinkgoRecover()
+			server.HandleCryptoStream()
+		}()
+		// the client blocks until the transport parameters are read
+		Eventually(clientHandler.GetPeerParams()).Should(Receive())
+		var err error
+		Eventually(clientErrChan).Should(Receive(&err))
+		Expect(err.Error()).To(ContainSubstring("TLS handshake error"))
+		Expect(clientEvent).ToNot(Receive())
+	})
+
+	It("errors if the peer doesn't send valid transport parameters", func() {
+		serverParams.StatelessResetToken = nil
+		client := newClient()
+		server := newServer()
+		clientErrChan := make(chan error, 1)
+		go func() {
+			defer GinkgoRecover()
+			clientErrChan <- client.HandleCryptoStream()
+		}()
+		go func() {
+			defer GinkgoRecover()
+			server.HandleCryptoStream()
+		}()
+		Eventually(clientErrChan).Should(Receive(MatchError("server didn't sent stateless_reset_token")))
+	})
+})
+
+var _ = Describe("reading handshake messages", func() {
+	It("reads a message", func() {
+		r := newBufferedPipe()
+		r.Write([]byte{1, 0, 0, 3, 'f', 'o', 'o', 2})
+		msg, err := readHandshakeMessage(r)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(msg).To(Equal([]byte{1, 0, 0, 3, 'f', 'o', 'o'}))
+	})
+
+	It("errors on messages that are too large", func() {
+		r := newBufferedPipe()
+		r.Write([]byte{1, 0xff, 0, 0})
+		_, err := readHandshakeMessage(r)
+		Expect(err).To(MatchError("CryptoSetup: handshake message too large (16711680 bytes)"))
+	})
+})
@@ -0,0 +1,10 @@
+//go:build go1.22
+// +build go1.22
+
+package handshake
+
+import "crypto/tls"
+
+func sendSessionTicket(qconn *tls.QUICConn) error {
+	return qconn.SendSessionTicket(tls.QUICSessionTicketOptions{})
+}
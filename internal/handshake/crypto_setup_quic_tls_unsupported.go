@@ -0,0 +1,76 @@
+//go:build !go1.21
+// +build !go1.21
+
+package handshake
+
+import (
+	"crypto/tls"
+	"errors"
+	"io"
+
+	"github.com/wheelcomplex/qk/internal/protocol"
+)
+
+var errQUICTLSUnsupported = errors.New("CryptoSetup: using crypto/tls requires Go 1.21 or newer")
+
+// NewCryptoSetupQUICTLSServer creates a new TLS CryptoSetup instance for a server, using crypto/tls
+func NewCryptoSetupQUICTLSServer(
+	io.ReadWriter,
+	protocol.ConnectionID,
+	*tls.Config,
+	TLSExtensionHandler,
+	chan<- struct{},
+	uint64,
+	protocol.VersionNumber,
+) (CryptoSetupTLS, error) {
+	return nil, errQUICTLSUnsupported
+}
+
+// NewCryptoSetupQUICTLSClient creates a new TLS CryptoSetup instance for a client, using crypto/tls
+func NewCryptoSetupQUICTLSClient(
+	io.ReadWriter,
+	protocol.ConnectionID,
+	*tls.Config,
+	TLSExtensionHandler,
+	chan<- struct{},
+	uint64,
+	protocol.VersionNumber,
+) (CryptoSetupTLS, error) {
+	return nil, errQUICTLSUnsupported
+}
+
+// NewCryptoSetupV1Server creates a new crypto setup for a QUIC v1 server
+func NewCryptoSetupV1Server(
+	io.ReadWriter, io.ReadWriter, io.ReadWriter,
+	protocol.ConnectionID,
+	*tls.Config,
+	TLSExtensionHandler,
+	chan<- struct{},
+	uint64,
+	QUICTLSWrapper,
+) (CryptoSetupV1, error) {
+	return nil, errQUICTLSUnsupported
+}
+
+// NewCryptoSetupV1Client creates a new crypto setup for a QUIC v1 client
+func NewCryptoSetupV1Client(
+	io.ReadWriter, io.ReadWriter, io.ReadWriter,
+	protocol.ConnectionID,
+	*tls.Config,
+	TLSExtensionHandler,
+	chan<- struct{},
+	uint64,
+	QUICTLSWrapper,
+) (CryptoSetupV1, error) {
+	return nil, errQUICTLSUnsupported
+}
+
+// GetTLSAlert returns the TLS alert of an error returned by the TLS stack, if any.
+func GetTLSAlert(error) (uint8, bool) {
+	return 0, false
+}
+
+// NewTLSAlertError creates the error that the TLS stack returns when sending a TLS alert.
+func NewTLSAlertError(uint8) error {
+	return errQUICTLSUnsupported
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"sync"
+	"sync/atomic"
 
 	"github.com/bifurcation/mint"
 	"github.com/wheelcomplex/qk/internal/crypto"
@@ -23,6 +24,24 @@ type cryptoSetupTLS struct {
 	nullAEAD      crypto.AEAD
 	aead          crypto.AEAD
 
+	// key update state
+	keyPhase int
+	// the AEAD of the previous key phase, used to open reordered packets
+	prevAEAD crypto.AEAD
+	// the AEAD of the next key phase, derived when it is first needed
+	nextAEAD crypto.UpdatableAEAD
+	// the lowest packet number received in the current key phase
+	firstRcvdPacketInKeyPhase protocol.PacketNumber
+	// set when the peer sent a packet in the current key phase
+	keyPhaseConfirmed bool
+	// the number of packets sealed with the keys of the current key phase
+	numPacketsSealed  *uint64
+	keyUpdateInterval uint64
+
+	// the header protection keys are not changed by key updates
+	handshakeHeaderProtector crypto.HeaderProtector
+	headerProtector1RTT      crypto.HeaderProtector
+
 	tls            mintTLS
 	conn           *cryptoStreamConn
 	handshakeEvent chan<- struct{}
@@ -30,12 +49,44 @@ type cryptoSetupTLS struct {
 
 var _ CryptoSetupTLS = &cryptoSetupTLS{}
 
+var errKeyUpdateNotConfirmed = errors.New("CryptoSetup: the peer didn't yet confirm the current key phase")
+
+// sealer1RTT seals packets with the keys of one key phase
+type sealer1RTT struct {
+	aead      crypto.AEAD
+	hp        crypto.HeaderProtector
+	keyPhase  int
+	numSealed *uint64
+}
+
+var _ Sealer = &sealer1RTT{}
+
+func (s *sealer1RTT) Seal(dst, src []byte, packetNumber protocol.PacketNumber, associatedData []byte) []byte {
+	atomic.AddUint64(s.numSealed, 1)
+	return s.aead.Seal(dst, src, packetNumber, associatedData)
+}
+
+func (s *sealer1RTT) Overhead() int {
+	return s.aead.Overhead()
+}
+
+// HeaderProtector returns the HeaderProtector used for the 1-RTT packets.
+func (s *sealer1RTT) HeaderProtector() crypto.HeaderProtector {
+	return s.hp
+}
+
+// KeyPhase returns the key phase that must be sent in the header of packets sealed by this sealer.
+func (s *sealer1RTT) KeyPhase() int {
+	return s.keyPhase
+}
+
 // NewCryptoSetupTLSServer creates a new TLS CryptoSetup instance for a server
 func NewCryptoSetupTLSServer(
 	cryptoStream io.ReadWriter,
 	connID protocol.ConnectionID,
 	config *mint.Config,
 	handshakeEvent chan<- struct{},
+	keyUpdateInterval uint64,
 	version protocol.VersionNumber,
 ) (CryptoSetupTLS, error) {
 	nullAEAD, err := crypto.NewNullAEAD(protocol.PerspectiveServer, connID, version)
@@ -45,12 +96,15 @@ func NewCryptoSetupTLSServer(
 	conn := newCryptoStreamConn(cryptoStream)
 	tls := mint.Server(conn, config)
 	return &cryptoSetupTLS{
-		tls:            tls,
-		conn:           conn,
-		nullAEAD:       nullAEAD,
-		perspective:    protocol.PerspectiveServer,
-		keyDerivation:  crypto.DeriveAESKeys,
-		handshakeEvent: handshakeEvent,
+		tls:                      tls,
+		conn:                     conn,
+		nullAEAD:                 nullAEAD,
+		handshakeHeaderProtector: getHeaderProtector(nullAEAD),
+		perspective:              protocol.PerspectiveServer,
+		keyDerivation:            crypto.DeriveAESKeys,
+		handshakeEvent:           handshakeEvent,
+		numPacketsSealed:         new(uint64),
+		keyUpdateInterval:        keyUpdateInterval,
 	}, nil
 }
 
@@ -60,6 +114,7 @@ func NewCryptoSetupTLSClient(
 	connID protocol.ConnectionID,
 	config *mint.Config,
 	handshakeEvent chan<- struct{},
+	keyUpdateInterval uint64,
 	version protocol.VersionNumber,
 ) (CryptoSetupTLS, error) {
 	nullAEAD, err := crypto.NewNullAEAD(protocol.PerspectiveClient, connID, version)
@@ -69,15 +124,27 @@ func NewCryptoSetupTLSClient(
 	conn := newCryptoStreamConn(cryptoStream)
 	tls := mint.Client(conn, config)
 	return &cryptoSetupTLS{
-		tls:            tls,
-		conn:           conn,
-		perspective:    protocol.PerspectiveClient,
-		nullAEAD:       nullAEAD,
-		keyDerivation:  crypto.DeriveAESKeys,
-		handshakeEvent: handshakeEvent,
+		tls:                      tls,
+		conn:                     conn,
+		perspective:              protocol.PerspectiveClient,
+		nullAEAD:                 nullAEAD,
+		handshakeHeaderProtector: getHeaderProtector(nullAEAD),
+		keyDerivation:            crypto.DeriveAESKeys,
+		handshakeEvent:           handshakeEvent,
+		numPacketsSealed:         new(uint64),
+		keyUpdateInterval:        keyUpdateInterval,
 	}, nil
 }
 
+// getHeaderProtector returns the HeaderProtector used together with an AEAD.
+// It returns nil if the AEAD doesn't use header protection.
+func getHeaderProtector(aead crypto.AEAD) crypto.HeaderProtector {
+	if a, ok := aead.(crypto.HeaderProtectingAEAD); ok {
+		return a.HeaderProtector()
+	}
+	return nil
+}
+
 func (h *cryptoSetupTLS) HandleCryptoStream() error {
 	for {
 		if alert := h.tls.Handshake(); alert != mint.AlertNoAlert {
@@ -96,35 +163,143 @@ func (h *cryptoSetupTLS) HandleCryptoStream() error {
 	if err != nil {
 		return err
 	}
+	h.set1RTTAEAD(aead)
+	return nil
+}
+
+// set1RTTAEAD installs the 1-RTT AEAD and signals that the handshake completed.
+func (h *cryptoSetupTLS) set1RTTAEAD(aead crypto.AEAD) {
 	h.mutex.Lock()
 	h.aead = aead
+	h.headerProtector1RTT = getHeaderProtector(aead)
+	h.firstRcvdPacketInKeyPhase = protocol.MaxPacketNumber
 	h.mutex.Unlock()
 
 	h.handshakeEvent <- struct{}{}
 	close(h.handshakeEvent)
-	return nil
 }
 
 func (h *cryptoSetupTLS) OpenHandshake(dst, src []byte, packetNumber protocol.PacketNumber, associatedData []byte) ([]byte, error) {
 	return h.nullAEAD.Open(dst, src, packetNumber, associatedData)
 }
 
-func (h *cryptoSetupTLS) Open1RTT(dst, src []byte, packetNumber protocol.PacketNumber, associatedData []byte) ([]byte, error) {
-	h.mutex.RLock()
-	defer h.mutex.RUnlock()
+func (h *cryptoSetupTLS) Open1RTT(dst, src []byte, packetNumber protocol.PacketNumber, keyPhase int, associatedData []byte) ([]byte, error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
 
 	if h.aead == nil {
 		return nil, errors.New("no 1-RTT sealer")
 	}
-	return h.aead.Open(dst, src, packetNumber, associatedData)
+	if keyPhase == h.keyPhase {
+		data, err := h.aead.Open(dst, src, packetNumber, associatedData)
+		if err != nil {
+			return nil, err
+		}
+		h.keyPhaseConfirmed = true
+		if packetNumber < h.firstRcvdPacketInKeyPhase {
+			h.firstRcvdPacketInKeyPhase = packetNumber
+		}
+		return data, nil
+	}
+	// A packet sent before the first packet we received in the current key phase
+	// was sent with the keys of the previous key phase.
+	if h.prevAEAD != nil && packetNumber < h.firstRcvdPacketInKeyPhase {
+		return h.prevAEAD.Open(dst, src, packetNumber, associatedData)
+	}
+	// Otherwise, the peer initiated a key update.
+	next, err := h.getNextAEAD()
+	if err != nil {
+		return nil, err
+	}
+	data, err := next.Open(dst, src, packetNumber, associatedData)
+	if err != nil {
+		return nil, err
+	}
+	h.rollKeys()
+	h.keyPhaseConfirmed = true
+	h.firstRcvdPacketInKeyPhase = packetNumber
+	return data, nil
+}
+
+// InitiateKeyUpdate starts using the keys of the next key phase.
+// A key update can only be initiated after the peer confirmed the current key phase,
+// by sending a packet using the current keys.
+func (h *cryptoSetupTLS) InitiateKeyUpdate() error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.aead == nil {
+		return errors.New("CryptoSetup: can't update keys before completing the handshake")
+	}
+	if !h.keyPhaseConfirmed {
+		return errKeyUpdateNotConfirmed
+	}
+	if _, err := h.getNextAEAD(); err != nil {
+		return err
+	}
+	h.rollKeys()
+	return nil
+}
+
+func (h *cryptoSetupTLS) getNextAEAD() (crypto.UpdatableAEAD, error) {
+	if h.nextAEAD != nil {
+		return h.nextAEAD, nil
+	}
+	aead, ok := h.aead.(crypto.UpdatableAEAD)
+	if !ok {
+		return nil, errors.New("CryptoSetup: the 1-RTT AEAD doesn't support key updates")
+	}
+	next, err := aead.Next()
+	if err != nil {
+		return nil, err
+	}
+	h.nextAEAD = next
+	return next, nil
+}
+
+// rollKeys switches to the next key phase.
+// The next AEAD must already have been derived.
+func (h *cryptoSetupTLS) rollKeys() {
+	h.prevAEAD = h.aead
+	h.aead = h.nextAEAD
+	h.nextAEAD = nil
+	h.keyPhase ^= 1
+	h.keyPhaseConfirmed = false
+	h.firstRcvdPacketInKeyPhase = protocol.MaxPacketNumber
+	h.numPacketsSealed = new(uint64)
+}
+
+// maybeUpdateKeys initiates a key update, if the keys of the current key phase
+// were used to seal more than keyUpdateInterval packets.
+func (h *cryptoSetupTLS) maybeUpdateKeys() {
+	if h.keyUpdateInterval == 0 || !h.keyPhaseConfirmed {
+		return
+	}
+	if atomic.LoadUint64(h.numPacketsSealed) < h.keyUpdateInterval {
+		return
+	}
+	if _, err := h.getNextAEAD(); err != nil {
+		return
+	}
+	h.rollKeys()
+}
+
+func (h *cryptoSetupTLS) get1RTTSealer() Sealer {
+	return &sealer1RTT{
+		aead:      h.aead,
+		hp:        h.headerProtector1RTT,
+		keyPhase:  h.keyPhase,
+		numSealed: h.numPacketsSealed,
+	}
 }
 
 func (h *cryptoSetupTLS) GetSealer() (protocol.EncryptionLevel, Sealer) {
-	h.mutex.RLock()
-	defer h.mutex.RUnlock()
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
 
 	if h.aead != nil {
-		return protocol.EncryptionForwardSecure, h.aead
+		h.maybeUpdateKeys()
+		return protocol.EncryptionForwardSecure, h.get1RTTSealer()
 	}
 	return protocol.EncryptionUnencrypted, h.nullAEAD
 }
@@ -141,12 +316,30 @@ func (h *cryptoSetupTLS) GetSealerWithEncryptionLevel(encLevel protocol.Encrypti
 		if h.aead == nil {
 			return nil, errNoSealer
 		}
-		return h.aead, nil
+		return h.get1RTTSealer(), nil
 	default:
 		return nil, errNoSealer
 	}
 }
 
+func (h *cryptoSetupTLS) GetHeaderProtector(encLevel protocol.EncryptionLevel) (crypto.HeaderProtector, error) {
+	errNoHeaderProtector := fmt.Errorf("CryptoSetup: no header protector with encryption level %s", encLevel.String())
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	var hp crypto.HeaderProtector
+	switch encLevel {
+	case protocol.EncryptionUnencrypted:
+		hp = h.handshakeHeaderProtector
+	case protocol.EncryptionForwardSecure:
+		hp = h.headerProtector1RTT
+	}
+	if hp == nil {
+		return nil, errNoHeaderProtector
+	}
+	return hp, nil
+}
+
 func (h *cryptoSetupTLS) GetSealerForCryptoStream() (protocol.EncryptionLevel, Sealer) {
 	return protocol.EncryptionUnencrypted, h.nullAEAD
 }
@@ -31,6 +31,7 @@ var _ = Describe("TLS Crypto Setup", func() {
 			protocol.ConnectionID{},
 			&mint.Config{},
 			handshakeEvent,
+			0,
 			protocol.VersionTLS,
 		)
 		Expect(err).ToNot(HaveOccurred())
@@ -144,12 +145,160 @@ var _ = Describe("TLS Crypto Setup", func() {
 			It("is used for opening", func() {
 				doHandshake()
 				cs.aead.(*mockcrypto.MockAEAD).EXPECT().Open(nil, []byte("encrypted"), protocol.PacketNumber(6), []byte{}).Return([]byte("decrypted"), nil)
-				d, err := cs.Open1RTT(nil, []byte("encrypted"), 6, []byte{})
+				d, err := cs.Open1RTT(nil, []byte("encrypted"), 6, 0, []byte{})
 				Expect(err).ToNot(HaveOccurred())
 				Expect(d).To(Equal([]byte("decrypted")))
 			})
 		})
 
+		Context("key updates", func() {
+			var peer crypto.UpdatableAEAD
+
+			clientSecret := bytes.Repeat([]byte{'c'}, 32)
+			serverSecret := bytes.Repeat([]byte{'s'}, 32)
+
+			BeforeEach(func() {
+				var err error
+				peer, err = crypto.NewUpdatableAEADAESGCM(serverSecret, clientSecret, 16, 12)
+				Expect(err).ToNot(HaveOccurred())
+				cs.tls = NewMockMintTLS(mockCtrl)
+				cs.tls.(*MockMintTLS).EXPECT().Handshake().Return(mint.AlertNoAlert)
+				cs.tls.(*MockMintTLS).EXPECT().ConnectionState().Return(mint.ConnectionState{HandshakeState: mint.StateServerConnected})
+				cs.keyDerivation = func(crypto.TLSExporter, protocol.Perspective) (crypto.AEAD, error) {
+					return crypto.NewUpdatableAEADAESGCM(clientSecret, serverSecret, 16, 12)
+				}
+				Expect(cs.HandleCryptoStream()).To(Succeed())
+			})
+
+			getKeyPhase := func() int {
+				_, sealer := cs.GetSealer()
+				return sealer.(*sealer1RTT).KeyPhase()
+			}
+
+			It("starts with key phase 0", func() {
+				Expect(getKeyPhase()).To(BeZero())
+			})
+
+			It("doesn't initiate a key update before the peer confirmed the key phase", func() {
+				Expect(cs.InitiateKeyUpdate()).To(MatchError(errKeyUpdateNotConfirmed))
+				Expect(getKeyPhase()).To(BeZero())
+			})
+
+			It("errors when initiating a key update before the handshake completes", func() {
+				cs.aead = nil
+				Expect(cs.InitiateKeyUpdate()).To(MatchError("CryptoSetup: can't update keys before completing the handshake"))
+			})
+
+			It("initiates a key update", func() {
+				_, err := cs.Open1RTT(nil, peer.Seal(nil, []byte("foobar"), 10, []byte("aad")), 10, 0, []byte("aad"))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(cs.InitiateKeyUpdate()).To(Succeed())
+				Expect(getKeyPhase()).To(Equal(1))
+				// the peer can only open packets using the keys of the next key phase
+				_, sealer := cs.GetSealer()
+				ciphertext := sealer.Seal(nil, []byte("foobar"), 11, []byte("aad"))
+				_, err = peer.Open(nil, ciphertext, 11, []byte("aad"))
+				Expect(err).To(HaveOccurred())
+				nextPeer, err := peer.Next()
+				Expect(err).ToNot(HaveOccurred())
+				data, err := nextPeer.Open(nil, ciphertext, 11, []byte("aad"))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(data).To(Equal([]byte("foobar")))
+				// a second key update is only allowed after the peer confirmed the new key phase
+				Expect(cs.InitiateKeyUpdate()).To(MatchError(errKeyUpdateNotConfirmed))
+				_, err = cs.Open1RTT(nil, nextPeer.Seal(nil, []byte("foobar"), 12, []byte("aad")), 12, 1, []byte("aad"))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(cs.InitiateKeyUpdate()).To(Succeed())
+				Expect(getKeyPhase()).To(BeZero())
+			})
+
+			It("handles a key update initiated by the peer", func() {
+				nextPeer, err := peer.Next()
+				Expect(err).ToNot(HaveOccurred())
+				data, err := cs.Open1RTT(nil, nextPeer.Seal(nil, []byte("foobar"), 10, []byte("aad")), 10, 1, []byte("aad"))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(data).To(Equal([]byte("foobar")))
+				Expect(getKeyPhase()).To(Equal(1))
+			})
+
+			It("doesn't update the keys when receiving a packet with the wrong key phase that can't be decrypted", func() {
+				_, err := cs.Open1RTT(nil, peer.Seal(nil, []byte("foobar"), 10, []byte("aad")), 10, 1, []byte("aad"))
+				Expect(err).To(MatchError("cipher: message authentication failed"))
+				Expect(getKeyPhase()).To(BeZero())
+			})
+
+			It("opens reordered packets of the previous key phase", func() {
+				nextPeer, err := peer.Next()
+				Expect(err).ToNot(HaveOccurred())
+				_, err = cs.Open1RTT(nil, nextPeer.Seal(nil, []byte("foobar"), 10, []byte("aad")), 10, 1, []byte("aad"))
+				Expect(err).ToNot(HaveOccurred())
+				data, err := cs.Open1RTT(nil, peer.Seal(nil, []byte("reordered"), 9, []byte("aad")), 9, 0, []byte("aad"))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(data).To(Equal([]byte("reordered")))
+				Expect(getKeyPhase()).To(Equal(1))
+			})
+
+			It("automatically updates the keys after sealing the configured number of packets", func() {
+				cs.keyUpdateInterval = 3
+				_, err := cs.Open1RTT(nil, peer.Seal(nil, []byte("foobar"), 10, []byte("aad")), 10, 0, []byte("aad"))
+				Expect(err).ToNot(HaveOccurred())
+				for i := 0; i < 3; i++ {
+					_, sealer := cs.GetSealer()
+					Expect(sealer.(*sealer1RTT).KeyPhase()).To(BeZero())
+					sealer.Seal(nil, []byte("foobar"), protocol.PacketNumber(i), []byte("aad"))
+				}
+				Expect(getKeyPhase()).To(Equal(1))
+			})
+
+			It("doesn't automatically update the keys if the peer didn't confirm the key phase", func() {
+				cs.keyUpdateInterval = 3
+				for i := 0; i < 5; i++ {
+					_, sealer := cs.GetSealer()
+					sealer.Seal(nil, []byte("foobar"), protocol.PacketNumber(i), []byte("aad"))
+				}
+				Expect(getKeyPhase()).To(BeZero())
+			})
+		})
+
+		Context("header protection", func() {
+			It("returns the header protector for handshake packets", func() {
+				hp, err := cs.GetHeaderProtector(protocol.EncryptionUnencrypted)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(hp).ToNot(BeNil())
+			})
+
+			It("errors if the 1-RTT header protector is not yet available", func() {
+				_, err := cs.GetHeaderProtector(protocol.EncryptionForwardSecure)
+				Expect(err).To(MatchError("CryptoSetup: no header protector with encryption level forward-secure"))
+			})
+
+			It("errors if the AEAD doesn't use header protection", func() {
+				doHandshake()
+				_, err := cs.GetHeaderProtector(protocol.EncryptionForwardSecure)
+				Expect(err).To(MatchError("CryptoSetup: no header protector with encryption level forward-secure"))
+			})
+
+			It("uses the same 1-RTT header protector for all key phases", func() {
+				cs.tls = NewMockMintTLS(mockCtrl)
+				cs.tls.(*MockMintTLS).EXPECT().Handshake().Return(mint.AlertNoAlert)
+				cs.tls.(*MockMintTLS).EXPECT().ConnectionState().Return(mint.ConnectionState{HandshakeState: mint.StateServerConnected})
+				cs.keyDerivation = func(crypto.TLSExporter, protocol.Perspective) (crypto.AEAD, error) {
+					return crypto.NewUpdatableAEADAESGCM(bytes.Repeat([]byte{'c'}, 32), bytes.Repeat([]byte{'s'}, 32), 16, 12)
+				}
+				Expect(cs.HandleCryptoStream()).To(Succeed())
+				hp, err := cs.GetHeaderProtector(protocol.EncryptionForwardSecure)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(hp).ToNot(BeNil())
+				_, sealer := cs.GetSealer()
+				Expect(sealer.(*sealer1RTT).HeaderProtector()).To(Equal(hp))
+				cs.keyPhaseConfirmed = true
+				Expect(cs.InitiateKeyUpdate()).To(Succeed())
+				_, sealer = cs.GetSealer()
+				Expect(sealer.(*sealer1RTT).KeyPhase()).To(Equal(1))
+				Expect(sealer.(*sealer1RTT).HeaderProtector()).To(Equal(hp))
+			})
+		})
+
 		Context("forcing encryption levels", func() {
 			It("forces null encryption", func() {
 				doHandshake()
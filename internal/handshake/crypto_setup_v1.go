@@ -0,0 +1,450 @@
+//go:build go1.21
+// +build go1.21
+
+package handshake
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/wheelcomplex/qk/internal/crypto"
+	"github.com/wheelcomplex/qk/internal/protocol"
+	"github.com/wheelcomplex/qk/qerr"
+)
+
+// cryptoSetupV1 is the CryptoSetupV1 used for QUIC v1.
+// It uses crypto/tls's QUIC API, and sends the handshake messages in CRYPTO frames at the encryption level requested by TLS.
+// The handling of the 1-RTT keys (including key updates) is shared with the crypto setup used for the draft versions.
+type cryptoSetupV1 struct {
+	*cryptoSetupTLS
+
+	qconn      QUICTLS
+	extHandler TLSExtensionHandler
+	// the crypto streams for the Initial, the Handshake and the 1-RTT encryption level
+	initialStream   io.ReadWriter
+	handshakeStream io.ReadWriter
+	oneRTTStream    io.ReadWriter
+
+	// the encryption level at which crypto/tls expects the next handshake message
+	readLevel tls.QUICEncryptionLevel
+
+	initialAEAD crypto.AEAD
+	initialHP   crypto.HeaderProtector
+	// the Handshake keys are installed as soon as both the read and the write secret are available
+	handshakeReadSecret, handshakeWriteSecret []byte
+	handshakeAEAD                             crypto.AEAD
+	handshakeHP                               crypto.HeaderProtector
+	droppedInitialKeys                        bool
+	droppedHandshakeKeys                      bool
+
+	suite uint16
+	// the 1-RTT traffic secrets
+	readSecret, writeSecret []byte
+
+	// set when crypto/tls signaled that the handshake completed
+	handshakeDoneRcvd bool
+	handshakeComplete bool
+	connState         ConnectionState
+}
+
+var _ CryptoSetupV1 = &cryptoSetupV1{}
+
+// NewCryptoSetupV1Server creates a new crypto setup for a QUIC v1 server
+func NewCryptoSetupV1Server(
+	initialStream, handshakeStream, oneRTTStream io.ReadWriter,
+	connID protocol.ConnectionID,
+	tlsConf *tls.Config,
+	extHandler TLSExtensionHandler,
+	handshakeEvent chan<- struct{},
+	keyUpdateInterval uint64,
+	wrapTLS QUICTLSWrapper,
+) (CryptoSetupV1, error) {
+	return newCryptoSetupV1(initialStream, handshakeStream, oneRTTStream, connID, tlsConf, extHandler, handshakeEvent, keyUpdateInterval, wrapTLS, protocol.PerspectiveServer)
+}
+
+// NewCryptoSetupV1Client creates a new crypto setup for a QUIC v1 client
+func NewCryptoSetupV1Client(
+	initialStream, handshakeStream, oneRTTStream io.ReadWriter,
+	connID protocol.ConnectionID,
+	tlsConf *tls.Config,
+	extHandler TLSExtensionHandler,
+	handshakeEvent chan<- struct{},
+	keyUpdateInterval uint64,
+	wrapTLS QUICTLSWrapper,
+) (CryptoSetupV1, error) {
+	return newCryptoSetupV1(initialStream, handshakeStream, oneRTTStream, connID, tlsConf, extHandler, handshakeEvent, keyUpdateInterval, wrapTLS, protocol.PerspectiveClient)
+}
+
+func newCryptoSetupV1(
+	initialStream, handshakeStream, oneRTTStream io.ReadWriter,
+	connID protocol.ConnectionID,
+	tlsConf *tls.Config,
+	extHandler TLSExtensionHandler,
+	handshakeEvent chan<- struct{},
+	keyUpdateInterval uint64,
+	wrapTLS QUICTLSWrapper,
+	perspective protocol.Perspective,
+) (CryptoSetupV1, error) {
+	if tlsConf == nil {
+		return nil, errors.New("CryptoSetup: no tls.Config")
+	}
+	initialAEAD, err := crypto.NewInitialAEADV1(connID, perspective)
+	if err != nil {
+		return nil, err
+	}
+	conf := tlsConf.Clone()
+	conf.MinVersion = tls.VersionTLS13
+	var qconn QUICTLS
+	if perspective == protocol.PerspectiveServer {
+		qconn = &quicConn{tls.QUICServer(&tls.QUICConfig{TLSConfig: conf})}
+	} else {
+		qconn = &quicConn{tls.QUICClient(&tls.QUICConfig{TLSConfig: conf})}
+	}
+	if wrapTLS != nil {
+		qconn = wrapTLS(qconn)
+	}
+	return &cryptoSetupV1{
+		cryptoSetupTLS: &cryptoSetupTLS{
+			perspective:       perspective,
+			handshakeEvent:    handshakeEvent,
+			numPacketsSealed:  new(uint64),
+			keyUpdateInterval: keyUpdateInterval,
+		},
+		qconn:           qconn,
+		extHandler:      extHandler,
+		initialStream:   initialStream,
+		handshakeStream: handshakeStream,
+		oneRTTStream:    oneRTTStream,
+		readLevel:       tls.QUICEncryptionLevelInitial,
+		initialAEAD:     initialAEAD,
+		initialHP:       getHeaderProtector(initialAEAD),
+	}, nil
+}
+
+// quicConn wraps a tls.QUICConn, such that it implements the QUICTLS interface
+type quicConn struct {
+	conn *tls.QUICConn
+}
+
+var _ QUICTLS = &quicConn{}
+
+func (c *quicConn) Start() error {
+	return c.conn.Start(context.Background())
+}
+
+func (c *quicConn) HandleData(level int, data []byte) error {
+	return c.conn.HandleData(tls.QUICEncryptionLevel(level), data)
+}
+
+func (c *quicConn) NextEvent() QUICTLSEvent {
+	ev := c.conn.NextEvent()
+	return QUICTLSEvent{Kind: int(ev.Kind), Level: int(ev.Level), Data: ev.Data, Suite: ev.Suite}
+}
+
+func (c *quicConn) SetTransportParameters(params []byte) { c.conn.SetTransportParameters(params) }
+func (c *quicConn) SendSessionTicket() error             { return sendSessionTicket(c.conn) }
+func (c *quicConn) ConnectionState() tls.ConnectionState { return c.conn.ConnectionState() }
+func (c *quicConn) Close() error                         { return c.conn.Close() }
+
+// GetTLSAlert returns the TLS alert of an error returned by the TLS stack, if any.
+func GetTLSAlert(err error) (uint8, bool) {
+	var alert tls.AlertError
+	if errors.As(err, &alert) {
+		return uint8(alert), true
+	}
+	return 0, false
+}
+
+// NewTLSAlertError creates the error that the TLS stack returns when sending a TLS alert.
+func NewTLSAlertError(alert uint8) error {
+	return tls.AlertError(alert)
+}
+
+func (h *cryptoSetupV1) HandleCryptoStream() error {
+	defer h.qconn.Close()
+
+	h.qconn.SetTransportParameters(h.extHandler.TransportParameters())
+	if err := h.qconn.Start(); err != nil {
+		return h.wrapError(err)
+	}
+	if err := h.processEvents(); err != nil {
+		return err
+	}
+	// Read handshake messages until the crypto stream of the current encryption level is closed.
+	// After completion of the handshake, this processes post-handshake messages, e.g. session tickets.
+	for {
+		msg, err := readHandshakeMessage(h.getCryptoStream(h.readLevel))
+		if err != nil {
+			return err
+		}
+		if err := h.qconn.HandleData(int(h.readLevel), msg); err != nil {
+			return h.wrapError(err)
+		}
+		if err := h.processEvents(); err != nil {
+			return err
+		}
+	}
+}
+
+func (h *cryptoSetupV1) getCryptoStream(level tls.QUICEncryptionLevel) io.ReadWriter {
+	switch level {
+	case tls.QUICEncryptionLevelInitial:
+		return h.initialStream
+	case tls.QUICEncryptionLevelHandshake:
+		return h.handshakeStream
+	default:
+		return h.oneRTTStream
+	}
+}
+
+// processEvents handles all events that crypto/tls generated since the last call.
+func (h *cryptoSetupV1) processEvents() error {
+	for {
+		ev := h.qconn.NextEvent()
+		level := tls.QUICEncryptionLevel(ev.Level)
+		switch tls.QUICEventKind(ev.Kind) {
+		case tls.QUICNoEvent:
+			// crypto/tls might provide the 1-RTT read secret after signaling completion of the handshake
+			if h.handshakeDoneRcvd && !h.handshakeComplete {
+				if err := h.handshakeDone(); err != nil {
+					return err
+				}
+				// process the events generated when sending the session ticket
+				continue
+			}
+			return nil
+		case tls.QUICSetReadSecret:
+			h.readLevel = level
+			switch level {
+			case tls.QUICEncryptionLevelHandshake:
+				h.suite = ev.Suite
+				h.handshakeReadSecret = append([]byte{}, ev.Data...)
+				if err := h.maybeInstallHandshakeKeys(); err != nil {
+					return err
+				}
+			case tls.QUICEncryptionLevelApplication:
+				h.suite = ev.Suite
+				h.readSecret = append([]byte{}, ev.Data...)
+			}
+		case tls.QUICSetWriteSecret:
+			switch level {
+			case tls.QUICEncryptionLevelHandshake:
+				h.handshakeWriteSecret = append([]byte{}, ev.Data...)
+				if err := h.maybeInstallHandshakeKeys(); err != nil {
+					return err
+				}
+			case tls.QUICEncryptionLevelApplication:
+				h.writeSecret = append([]byte{}, ev.Data...)
+			}
+		case tls.QUICWriteData:
+			if _, err := h.getCryptoStream(level).Write(ev.Data); err != nil {
+				return err
+			}
+		case tls.QUICTransportParametersRequired:
+			h.qconn.SetTransportParameters(h.extHandler.TransportParameters())
+		case tls.QUICTransportParameters:
+			if err := h.extHandler.ReceivedTransportParameters(ev.Data); err != nil {
+				return err
+			}
+		case tls.QUICHandshakeDone:
+			h.handshakeDoneRcvd = true
+		}
+	}
+}
+
+func (h *cryptoSetupV1) maybeInstallHandshakeKeys() error {
+	if h.handshakeReadSecret == nil || h.handshakeWriteSecret == nil {
+		return nil
+	}
+	aead, err := crypto.NewAEADV1(h.suite, h.handshakeReadSecret, h.handshakeWriteSecret)
+	if err != nil {
+		return err
+	}
+	h.mutex.Lock()
+	h.handshakeAEAD = aead
+	h.handshakeHP = getHeaderProtector(aead)
+	h.mutex.Unlock()
+	h.handshakeReadSecret = nil
+	h.handshakeWriteSecret = nil
+	// Handshake packets that arrived before the keys were available can now be decrypted.
+	select {
+	case h.handshakeEvent <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (h *cryptoSetupV1) handshakeDone() error {
+	if h.readSecret == nil || h.writeSecret == nil {
+		return errors.New("CryptoSetup: handshake completed without 1-RTT secrets")
+	}
+	aead, err := crypto.NewAEADV1(h.suite, h.readSecret, h.writeSecret)
+	if err != nil {
+		return err
+	}
+	tlsState := h.qconn.ConnectionState()
+	h.mutex.Lock()
+	h.handshakeComplete = true
+	h.connState = ConnectionState{
+		HandshakeComplete:  true,
+		ServerName:         tlsState.ServerName,
+		PeerCertificates:   tlsState.PeerCertificates,
+		NegotiatedProtocol: tlsState.NegotiatedProtocol,
+	}
+	h.mutex.Unlock()
+	h.set1RTTAEAD(aead)
+
+	if h.perspective == protocol.PerspectiveServer {
+		// The ticket is sent in a WriteData event.
+		// Errors are ignored: crypto/tls returns an error if session tickets are disabled.
+		_ = h.qconn.SendSessionTicket()
+	}
+	return nil
+}
+
+func (h *cryptoSetupV1) wrapError(err error) error {
+	var alert tls.AlertError
+	if errors.As(err, &alert) {
+		return qerr.Error(qerr.HandshakeFailed, fmt.Sprintf("TLS handshake error: %s (Alert %d)", alert.Error(), uint8(alert)))
+	}
+	return qerr.Error(qerr.HandshakeFailed, fmt.Sprintf("TLS handshake error: %s", err.Error()))
+}
+
+func (h *cryptoSetupV1) OpenInitial(dst, src []byte, packetNumber protocol.PacketNumber, associatedData []byte) ([]byte, error) {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	if h.droppedInitialKeys {
+		return nil, ErrKeysDropped
+	}
+	return h.initialAEAD.Open(dst, src, packetNumber, associatedData)
+}
+
+func (h *cryptoSetupV1) OpenHandshake(dst, src []byte, packetNumber protocol.PacketNumber, associatedData []byte) ([]byte, error) {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	if h.droppedHandshakeKeys {
+		return nil, ErrKeysDropped
+	}
+	if h.handshakeAEAD == nil {
+		return nil, ErrKeysNotYetAvailable
+	}
+	return h.handshakeAEAD.Open(dst, src, packetNumber, associatedData)
+}
+
+func (h *cryptoSetupV1) Open1RTT(dst, src []byte, packetNumber protocol.PacketNumber, keyPhase int, associatedData []byte) ([]byte, error) {
+	h.mutex.RLock()
+	hasKeys := h.aead != nil
+	h.mutex.RUnlock()
+	if !hasKeys {
+		return nil, ErrKeysNotYetAvailable
+	}
+	return h.cryptoSetupTLS.Open1RTT(dst, src, packetNumber, keyPhase, associatedData)
+}
+
+func (h *cryptoSetupV1) DropInitialKeys() {
+	h.mutex.Lock()
+	h.droppedInitialKeys = true
+	h.initialAEAD = nil
+	h.initialHP = nil
+	h.mutex.Unlock()
+}
+
+func (h *cryptoSetupV1) DropHandshakeKeys() {
+	h.mutex.Lock()
+	h.droppedHandshakeKeys = true
+	h.handshakeAEAD = nil
+	h.handshakeHP = nil
+	h.mutex.Unlock()
+}
+
+// GetSealer returns the sealer for the highest encryption level that is available.
+func (h *cryptoSetupV1) GetSealer() (protocol.EncryptionLevel, Sealer) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.aead != nil {
+		h.maybeUpdateKeys()
+		return protocol.EncryptionForwardSecure, h.get1RTTSealer()
+	}
+	if h.handshakeAEAD != nil {
+		return protocol.EncryptionHandshake, h.handshakeAEAD
+	}
+	return protocol.EncryptionInitial, h.initialAEAD
+}
+
+func (h *cryptoSetupV1) GetSealerWithEncryptionLevel(encLevel protocol.EncryptionLevel) (Sealer, error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	switch encLevel {
+	case protocol.EncryptionInitial:
+		if h.droppedInitialKeys {
+			return nil, ErrKeysDropped
+		}
+		return h.initialAEAD, nil
+	case protocol.EncryptionHandshake:
+		if h.droppedHandshakeKeys {
+			return nil, ErrKeysDropped
+		}
+		if h.handshakeAEAD == nil {
+			return nil, ErrKeysNotYetAvailable
+		}
+		return h.handshakeAEAD, nil
+	case protocol.EncryptionForwardSecure:
+		if h.aead == nil {
+			return nil, ErrKeysNotYetAvailable
+		}
+		h.maybeUpdateKeys()
+		return h.get1RTTSealer(), nil
+	default:
+		return nil, fmt.Errorf("CryptoSetup: no sealer with encryption level %s", encLevel)
+	}
+}
+
+// GetSealerForCryptoStream returns the sealer for Initial packets.
+// In QUIC v1, the encryption level of handshake data depends on the crypto stream it is sent on.
+func (h *cryptoSetupV1) GetSealerForCryptoStream() (protocol.EncryptionLevel, Sealer) {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	return protocol.EncryptionInitial, h.initialAEAD
+}
+
+func (h *cryptoSetupV1) GetHeaderProtector(encLevel protocol.EncryptionLevel) (crypto.HeaderProtector, error) {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	var hp crypto.HeaderProtector
+	switch encLevel {
+	case protocol.EncryptionInitial:
+		if h.droppedInitialKeys {
+			return nil, ErrKeysDropped
+		}
+		hp = h.initialHP
+	case protocol.EncryptionHandshake:
+		if h.droppedHandshakeKeys {
+			return nil, ErrKeysDropped
+		}
+		hp = h.handshakeHP
+	case protocol.EncryptionForwardSecure:
+		hp = h.headerProtector1RTT
+	}
+	if hp == nil {
+		return nil, ErrKeysNotYetAvailable
+	}
+	return hp, nil
+}
+
+func (h *cryptoSetupV1) ConnectionState() ConnectionState {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	// crypto/tls blocks ConnectionState calls while the handshake is running
+	if !h.handshakeComplete {
+		return ConnectionState{}
+	}
+	return h.connState
+}
@@ -0,0 +1,299 @@
+//go:build go1.21
+// +build go1.21
+
+package handshake
+
+import (
+	"crypto/tls"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/wheelcomplex/qk/internal/protocol"
+	"github.com/wheelcomplex/qk/internal/utils"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("QUIC v1 Crypto Setup", func() {
+	type cryptoStreams struct {
+		initial, handshake, oneRTT *bufferedPipe
+	}
+
+	var (
+		clientConf, serverConf       *tls.Config
+		clientStreams, serverStreams cryptoStreams
+		clientEvent, serverEvent     chan struct{}
+		clientParams, serverParams   *TransportParameters
+		clientHandler, serverHandler TLSExtensionHandler
+		clientWrapTLS                QUICTLSWrapper
+	)
+
+	connID := protocol.ConnectionID{0xde, 0xad, 0xbe, 0xef, 0xca, 0xfe, 0x13, 0x37}
+
+	newCryptoStreams := func() cryptoStreams {
+		return cryptoStreams{
+			initial:   newBufferedPipe(),
+			handshake: newBufferedPipe(),
+			oneRTT:    newBufferedPipe(),
+		}
+	}
+
+	BeforeEach(func() {
+		cert, pool := generateTestCertificate("quic.clemente.io")
+		serverConf = &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			NextProtos:   []string{"proto"},
+		}
+		clientConf = &tls.Config{
+			ServerName: "quic.clemente.io",
+			RootCAs:    pool,
+			NextProtos: []string{"proto"},
+		}
+		clientStreams = newCryptoStreams()
+		serverStreams = newCryptoStreams()
+		clientEvent = make(chan struct{}, 1)
+		serverEvent = make(chan struct{}, 1)
+		clientParams = &TransportParameters{
+			IdleTimeout:               42 * time.Second,
+			InitialSourceConnectionID: protocol.ConnectionID{1, 2, 3, 4},
+		}
+		serverParams = &TransportParameters{
+			IdleTimeout:                     1337 * time.Second,
+			StatelessResetToken:             make([]byte, 16),
+			OriginalDestinationConnectionID: connID,
+			InitialSourceConnectionID:       protocol.ConnectionID{5, 6, 7, 8},
+		}
+		clientHandler = NewExtensionHandlerClientV1(clientParams, connID, nil, utils.DefaultLogger)
+		serverHandler = NewExtensionHandlerServerV1(serverParams, utils.DefaultLogger)
+		clientWrapTLS = nil
+	})
+
+	closeStreams := func(s cryptoStreams) {
+		s.initial.Close()
+		s.handshake.Close()
+		s.oneRTT.Close()
+	}
+
+	AfterEach(func() {
+		closeStreams(clientStreams)
+		closeStreams(serverStreams)
+	})
+
+	newClient := func() CryptoSetupV1 {
+		cs, err := NewCryptoSetupV1Client(
+			&cryptoStreamEndpoint{Reader: serverStreams.initial, Writer: clientStreams.initial},
+			&cryptoStreamEndpoint{Reader: serverStreams.handshake, Writer: clientStreams.handshake},
+			&cryptoStreamEndpoint{Reader: serverStreams.oneRTT, Writer: clientStreams.oneRTT},
+			connID,
+			clientConf,
+			clientHandler,
+			clientEvent,
+			0,
+			clientWrapTLS,
+		)
+		Expect(err).ToNot(HaveOccurred())
+		return cs
+	}
+
+	newServer := func() CryptoSetupV1 {
+		cs, err := NewCryptoSetupV1Server(
+			&cryptoStreamEndpoint{Reader: clientStreams.initial, Writer: serverStreams.initial},
+			&cryptoStreamEndpoint{Reader: clientStreams.handshake, Writer: serverStreams.handshake},
+			&cryptoStreamEndpoint{Reader: clientStreams.oneRTT, Writer: serverStreams.oneRTT},
+			connID,
+			serverConf,
+			serverHandler,
+			serverEvent,
+			0,
+			nil,
+		)
+		Expect(err).ToNot(HaveOccurred())
+		return cs
+	}
+
+	// waitForHandshake reads all handshake events, until the channel is closed
+	waitForHandshake := func(c chan struct{}) {
+		Eventually(func() bool {
+			select {
+			case _, ok := <-c:
+				return !ok
+			default:
+				return false
+			}
+		}).Should(BeTrue())
+	}
+
+	It("errors without a tls.Config", func() {
+		_, err := NewCryptoSetupV1Client(nil, nil, nil, connID, nil, clientHandler, clientEvent, 0, nil)
+		Expect(err).To(MatchError("CryptoSetup: no tls.Config"))
+	})
+
+	It("derives the Initial keys from the connection ID", func() {
+		client := newClient()
+		server := newServer()
+		encLevel, sealer := client.GetSealer()
+		Expect(encLevel).To(Equal(protocol.EncryptionInitial))
+		sealed := sealer.Seal(nil, []byte("foobar"), 1, []byte("aad"))
+		opened, err := server.OpenInitial(nil, sealed, 1, []byte("aad"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(opened).To(Equal([]byte("foobar")))
+		_, err = server.GetHeaderProtector(protocol.EncryptionInitial)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("doesn't have Handshake and 1-RTT keys before the handshake", func() {
+		client := newClient()
+		_, err := client.OpenHandshake(nil, []byte("foobar"), 1, nil)
+		Expect(err).To(MatchError(ErrKeysNotYetAvailable))
+		_, err = client.Open1RTT(nil, []byte("foobar"), 1, 0, nil)
+		Expect(err).To(MatchError(ErrKeysNotYetAvailable))
+		_, err = client.GetSealerWithEncryptionLevel(protocol.EncryptionHandshake)
+		Expect(err).To(MatchError(ErrKeysNotYetAvailable))
+		_, err = client.GetSealerWithEncryptionLevel(protocol.EncryptionForwardSecure)
+		Expect(err).To(MatchError(ErrKeysNotYetAvailable))
+		_, err = client.GetHeaderProtector(protocol.EncryptionHandshake)
+		Expect(err).To(MatchError(ErrKeysNotYetAvailable))
+	})
+
+	It("drops the Initial keys", func() {
+		client := newClient()
+		client.DropInitialKeys()
+		_, err := client.OpenInitial(nil, []byte("foobar"), 1, nil)
+		Expect(err).To(MatchError(ErrKeysDropped))
+		_, err = client.GetSealerWithEncryptionLevel(protocol.EncryptionInitial)
+		Expect(err).To(MatchError(ErrKeysDropped))
+		_, err = client.GetHeaderProtector(protocol.EncryptionInitial)
+		Expect(err).To(MatchError(ErrKeysDropped))
+	})
+
+	It("handshakes and exchanges transport parameters", func() {
+		client := newClient()
+		server := newServer()
+		clientErrChan := make(chan error, 1)
+		serverErrChan := make(chan error, 1)
+		go func() {
+			defer GinkgoRecover()
+			clientErrChan <- client.HandleCryptoStream()
+		}()
+		go func() {
+			defer GinkgoRecover()
+			serverErrChan <- server.HandleCryptoStream()
+		}()
+
+		var paramsRcvdByClient TransportParameters
+		Eventually(clientHandler.GetPeerParams()).Should(Receive(&paramsRcvdByClient))
+		Expect(paramsRcvdByClient.IdleTimeout).To(Equal(1337 * time.Second))
+		Expect(paramsRcvdByClient.InitialSourceConnectionID).To(Equal(protocol.ConnectionID{5, 6, 7, 8}))
+		var paramsRcvdByServer TransportParameters
+		Eventually(serverHandler.GetPeerParams()).Should(Receive(&paramsRcvdByServer))
+		Expect(paramsRcvdByServer.IdleTimeout).To(Equal(42 * time.Second))
+		Expect(paramsRcvdByServer.InitialSourceConnectionID).To(Equal(protocol.ConnectionID{1, 2, 3, 4}))
+
+		waitForHandshake(clientEvent)
+		waitForHandshake(serverEvent)
+
+		clientState := client.ConnectionState()
+		Expect(clientState.HandshakeComplete).To(BeTrue())
+		Expect(clientState.NegotiatedProtocol).To(Equal("proto"))
+		Expect(server.ConnectionState().ServerName).To(Equal("quic.clemente.io"))
+
+		// the Handshake keys match
+		sealer, err := client.GetSealerWithEncryptionLevel(protocol.EncryptionHandshake)
+		Expect(err).ToNot(HaveOccurred())
+		sealed := sealer.Seal(nil, []byte("foobar"), 5, []byte("aad"))
+		opened, err := server.OpenHandshake(nil, sealed, 5, []byte("aad"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(opened).To(Equal([]byte("foobar")))
+
+		// the 1-RTT keys match
+		encLevel, sealer := client.GetSealer()
+		Expect(encLevel).To(Equal(protocol.EncryptionForwardSecure))
+		sealed = sealer.Seal(nil, []byte("foobar"), 10, []byte("aad"))
+		opened, err = server.Open1RTT(nil, sealed, 10, 0, []byte("aad"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(opened).To(Equal([]byte("foobar")))
+		_, sealer = server.GetSealer()
+		sealed = sealer.Seal(nil, []byte("raboof"), 20, []byte("aad"))
+		opened, err = client.Open1RTT(nil, sealed, 20, 0, []byte("aad"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(opened).To(Equal([]byte("raboof")))
+
+		// after dropping the Handshake keys, the highest encryption level is still 1-RTT
+		server.DropHandshakeKeys()
+		_, err = server.OpenHandshake(nil, sealed, 5, []byte("aad"))
+		Expect(err).To(MatchError(ErrKeysDropped))
+		encLevel, _ = server.GetSealer()
+		Expect(encLevel).To(Equal(protocol.EncryptionForwardSecure))
+
+		// HandleCryptoStream only returns when the crypto stream is closed
+		Consistently(clientErrChan).ShouldNot(Receive())
+		serverStreams.oneRTT.Close()
+		Eventually(clientErrChan).Should(Receive(Equal(io.EOF)))
+		clientStreams.oneRTT.Close()
+		Eventually(serverErrChan).Should(Receive(Equal(io.EOF)))
+	})
+
+	It("uses the wrapped TLS stack", func() {
+		var inputs, events int32
+		clientWrapTLS = func(qconn QUICTLS) QUICTLS {
+			return &countingQUICTLS{QUICTLS: qconn, inputs: &inputs, events: &events}
+		}
+		client := newClient()
+		server := newServer()
+		go client.HandleCryptoStream()
+		go server.HandleCryptoStream()
+		Eventually(clientHandler.GetPeerParams()).Should(Receive())
+		waitForHandshake(clientEvent)
+		Expect(client.ConnectionState().HandshakeComplete).To(BeTrue())
+		// The client handles the ServerHello, and then the EncryptedExtensions, Certificate, CertificateVerify and Finished.
+		Expect(atomic.LoadInt32(&inputs)).To(BeNumerically(">=", 2))
+		Expect(atomic.LoadInt32(&events)).To(BeNumerically(">", atomic.LoadInt32(&inputs)))
+	})
+
+	It("errors if the certificate can't be verified", func() {
+		clientConf.RootCAs = nil
+		client := newClient()
+		server := newServer()
+		clientErrChan := make(chan error, 1)
+		go func() {
+			defer GinkgoRecover()
+			clientErrChan <- client.HandleCryptoStream()
+		}()
+		go func() {
+			defer GinkgoRecover()
+			server.HandleCryptoStream()
+		}()
+		// the client blocks until the transport parameters are read
+		Eventually(clientHandler.GetPeerParams()).Should(Receive())
+		var err error
+		Eventually(clientErrChan).Should(Receive(&err))
+		Expect(err.Error()).To(ContainSubstring("TLS handshake error"))
+	})
+})
+
+type countingQUICTLS struct {
+	QUICTLS
+	inputs, events *int32
+}
+
+func (c *countingQUICTLS) HandleData(level int, data []byte) error {
+	atomic.AddInt32(c.inputs, 1)
+	return c.QUICTLS.HandleData(level, data)
+}
+
+func (c *countingQUICTLS) NextEvent() QUICTLSEvent {
+	atomic.AddInt32(c.events, 1)
+	return c.QUICTLS.NextEvent()
+}
+
+var _ = Describe("TLS alerts", func() {
+	It("gets the alert from an error", func() {
+		alert, ok := GetTLSAlert(NewTLSAlertError(42))
+		Expect(ok).To(BeTrue())
+		Expect(alert).To(BeEquivalentTo(42))
+		_, ok = GetTLSAlert(io.EOF)
+		Expect(ok).To(BeFalse())
+	})
+})
@@ -2,12 +2,20 @@ package handshake
 
 import (
 	"crypto/x509"
+	"errors"
 
 	"github.com/bifurcation/mint"
 	"github.com/wheelcomplex/qk/internal/crypto"
 	"github.com/wheelcomplex/qk/internal/protocol"
 )
 
+var (
+	// ErrKeysNotYetAvailable is returned when an opener or a sealer is requested for an encryption level that is not yet available
+	ErrKeysNotYetAvailable = errors.New("CryptoSetup: keys at this encryption level not yet available")
+	// ErrKeysDropped is returned when an opener or a sealer is requested for an encryption level that was already dropped
+	ErrKeysDropped = errors.New("CryptoSetup: keys were already dropped")
+)
+
 // Sealer seals a packet
 type Sealer interface {
 	Seal(dst, src []byte, packetNumber protocol.PacketNumber, associatedData []byte) []byte
@@ -26,6 +34,11 @@ type TLSExtensionHandler interface {
 	Send(mint.HandshakeType, *mint.ExtensionList) error
 	Receive(mint.HandshakeType, *mint.ExtensionList) error
 	GetPeerParams() <-chan TransportParameters
+	// TransportParameters returns the body of the QUIC TLS extension that we send.
+	// This is used with TLS stacks that handle the extension themselves.
+	TransportParameters() []byte
+	// ReceivedTransportParameters handles the body of the QUIC TLS extension sent by the peer.
+	ReceivedTransportParameters([]byte) error
 }
 
 type baseCryptoSetup interface {
@@ -49,13 +62,27 @@ type CryptoSetupTLS interface {
 	baseCryptoSetup
 
 	OpenHandshake(dst, src []byte, packetNumber protocol.PacketNumber, associatedData []byte) ([]byte, error)
-	Open1RTT(dst, src []byte, packetNumber protocol.PacketNumber, associatedData []byte) ([]byte, error)
+	Open1RTT(dst, src []byte, packetNumber protocol.PacketNumber, keyPhase int, associatedData []byte) ([]byte, error)
+	GetHeaderProtector(protocol.EncryptionLevel) (crypto.HeaderProtector, error)
+	InitiateKeyUpdate() error
+}
+
+// CryptoSetupV1 is the crypto setup used by QUIC v1.
+// It uses separate keys for the Initial, the Handshake and the 1-RTT encryption level.
+// OpenHandshake opens packets sent at the Handshake encryption level.
+type CryptoSetupV1 interface {
+	CryptoSetupTLS
+
+	OpenInitial(dst, src []byte, packetNumber protocol.PacketNumber, associatedData []byte) ([]byte, error)
+	DropInitialKeys()
+	DropHandshakeKeys()
 }
 
 // ConnectionState records basic details about the QUIC connection.
 // Warning: This API should not be considered stable and might change soon.
 type ConnectionState struct {
-	HandshakeComplete bool                // handshake is complete
-	ServerName        string              // server name requested by client, if any (server side only)
-	PeerCertificates  []*x509.Certificate // certificate chain presented by remote peer
+	HandshakeComplete  bool                // handshake is complete
+	ServerName         string              // server name requested by client, if any (server side only)
+	PeerCertificates   []*x509.Certificate // certificate chain presented by remote peer
+	NegotiatedProtocol string              // application protocol negotiated using ALPN, if any
 }
@@ -0,0 +1,29 @@
+package handshake
+
+import "crypto/tls"
+
+// A QUICTLSEvent is an event generated by the TLS stack.
+// It corresponds to a tls.QUICEvent: Kind and Level have the values of a tls.QUICEventKind and a tls.QUICEncryptionLevel.
+type QUICTLSEvent struct {
+	Kind  int
+	Level int
+	Data  []byte
+	Suite uint16
+}
+
+// QUICTLS is the TLS stack used by the crypto setup for QUIC v1.
+// It is implemented by a wrapper around crypto/tls's QUICConn.
+// Wrapping it allows recording the output of the TLS stack, and replaying it.
+type QUICTLS interface {
+	Start() error
+	HandleData(level int, data []byte) error
+	NextEvent() QUICTLSEvent
+	SetTransportParameters([]byte)
+	SendSessionTicket() error
+	ConnectionState() tls.ConnectionState
+	Close() error
+}
+
+// A QUICTLSWrapper wraps the TLS stack used by the crypto setup.
+// It may return a QUICTLS that doesn't use the wrapped one at all.
+type QUICTLSWrapper func(QUICTLS) QUICTLS
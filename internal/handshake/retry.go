@@ -0,0 +1,58 @@
+package handshake
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/subtle"
+	"sync"
+
+	"github.com/wheelcomplex/qk/internal/protocol"
+)
+
+// The key and the nonce used to calculate the Retry Integrity Tag, see RFC 9001, section 5.8
+var (
+	retryKey   = [16]byte{0xbe, 0x0c, 0x69, 0x0b, 0x9f, 0x66, 0x57, 0x5a, 0x1d, 0x76, 0x6b, 0x54, 0xe3, 0x68, 0xc8, 0x4e}
+	retryNonce = [12]byte{0x46, 0x15, 0x99, 0xd3, 0x5d, 0x63, 0x2b, 0xf2, 0x23, 0x98, 0x25, 0xbb}
+)
+
+var (
+	retryAEAD      cipher.AEAD
+	retryAEADMutex sync.Mutex // cipher.AEAD is not safe for concurrent use
+	retryBuf       bytes.Buffer
+)
+
+func init() {
+	block, err := aes.NewCipher(retryKey[:])
+	if err != nil {
+		panic(err)
+	}
+	retryAEAD, err = cipher.NewGCM(block)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// GetRetryIntegrityTag calculates the Retry Integrity Tag of a QUIC v1 Retry packet.
+// retry is the Retry packet, without the tag.
+func GetRetryIntegrityTag(retry []byte, origDestConnID protocol.ConnectionID) []byte {
+	retryAEADMutex.Lock()
+	defer retryAEADMutex.Unlock()
+
+	// the tag is calculated over the Retry Pseudo-Packet
+	retryBuf.Reset()
+	retryBuf.WriteByte(uint8(origDestConnID.Len()))
+	retryBuf.Write(origDestConnID.Bytes())
+	retryBuf.Write(retry)
+	return retryAEAD.Seal(nil, retryNonce[:], nil, retryBuf.Bytes())
+}
+
+// ValidateRetryIntegrityTag checks the Retry Integrity Tag at the end of a QUIC v1 Retry packet.
+func ValidateRetryIntegrityTag(retry []byte, origDestConnID protocol.ConnectionID) bool {
+	if len(retry) < retryAEAD.Overhead() {
+		return false
+	}
+	tagOffset := len(retry) - retryAEAD.Overhead()
+	tag := GetRetryIntegrityTag(retry[:tagOffset], origDestConnID)
+	return subtle.ConstantTimeCompare(tag, retry[tagOffset:]) == 1
+}
@@ -0,0 +1,37 @@
+package handshake
+
+import (
+	"encoding/hex"
+
+	"github.com/wheelcomplex/qk/internal/protocol"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Retry Integrity Tag", func() {
+	// test vector from RFC 9001, Appendix A.4
+	origDestConnID := protocol.ConnectionID{0x83, 0x94, 0xc8, 0xf0, 0x3e, 0x51, 0x57, 0x08}
+	retry, _ := hex.DecodeString("ff000000010008f067a5502a4262b5746f6b656e04a265ba2eff4d829058fb3f0f2496ba")
+
+	It("calculates the tag", func() {
+		tag := GetRetryIntegrityTag(retry[:len(retry)-16], origDestConnID)
+		Expect(tag).To(Equal(retry[len(retry)-16:]))
+	})
+
+	It("validates the tag", func() {
+		Expect(ValidateRetryIntegrityTag(retry, origDestConnID)).To(BeTrue())
+	})
+
+	It("rejects a Retry for a different connection ID", func() {
+		Expect(ValidateRetryIntegrityTag(retry, protocol.ConnectionID{1, 2, 3, 4, 5, 6, 7, 8})).To(BeFalse())
+	})
+
+	It("rejects a modified Retry", func() {
+		modified := make([]byte, len(retry))
+		copy(modified, retry)
+		modified[len(modified)-20] ^= 0x1
+		Expect(ValidateRetryIntegrityTag(modified, origDestConnID)).To(BeFalse())
+		Expect(ValidateRetryIntegrityTag(retry[:10], origDestConnID)).To(BeFalse())
+	})
+})
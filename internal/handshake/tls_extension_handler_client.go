@@ -51,12 +51,16 @@ func (h *extensionHandlerClient) Send(hType mint.HandshakeType, el *mint.Extensi
 	if hType != mint.HandshakeTypeClientHello {
 		return nil
 	}
+	return el.Add(&tlsExtensionBody{data: h.TransportParameters()})
+}
+
+func (h *extensionHandlerClient) TransportParameters() []byte {
 	h.logger.Debugf("Sending Transport Parameters: %s", h.ourParams)
 	chtp := &clientHelloTransportParameters{
 		InitialVersion: h.initialVersion,
 		Parameters:     *h.ourParams,
 	}
-	return el.Add(&tlsExtensionBody{data: chtp.Marshal()})
+	return chtp.Marshal()
 }
 
 func (h *extensionHandlerClient) Receive(hType mint.HandshakeType, el *mint.ExtensionList) error {
@@ -77,9 +81,12 @@ func (h *extensionHandlerClient) Receive(hType mint.HandshakeType, el *mint.Exte
 	if !found {
 		return errors.New("EncryptedExtensions message didn't contain a QUIC extension")
 	}
+	return h.ReceivedTransportParameters(ext.data)
+}
 
+func (h *extensionHandlerClient) ReceivedTransportParameters(data []byte) error {
 	eetp := &encryptedExtensionsTransportParameters{}
-	if err := eetp.Unmarshal(ext.data); err != nil {
+	if err := eetp.Unmarshal(data); err != nil {
 		return err
 	}
 	// check that the negotiated_version is the current version
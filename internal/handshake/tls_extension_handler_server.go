@@ -47,13 +47,17 @@ func (h *extensionHandlerServer) Send(hType mint.HandshakeType, el *mint.Extensi
 	if hType != mint.HandshakeTypeEncryptedExtensions {
 		return nil
 	}
+	return el.Add(&tlsExtensionBody{data: h.TransportParameters()})
+}
+
+func (h *extensionHandlerServer) TransportParameters() []byte {
 	h.logger.Debugf("Sending Transport Parameters: %s", h.ourParams)
 	eetp := &encryptedExtensionsTransportParameters{
 		NegotiatedVersion: h.version,
 		SupportedVersions: protocol.GetGreasedVersions(h.supportedVersions),
 		Parameters:        *h.ourParams,
 	}
-	return el.Add(&tlsExtensionBody{data: eetp.Marshal()})
+	return eetp.Marshal()
 }
 
 func (h *extensionHandlerServer) Receive(hType mint.HandshakeType, el *mint.ExtensionList) error {
@@ -73,8 +77,12 @@ func (h *extensionHandlerServer) Receive(hType mint.HandshakeType, el *mint.Exte
 	if !found {
 		return errors.New("ClientHello didn't contain a QUIC extension")
 	}
+	return h.ReceivedTransportParameters(ext.data)
+}
+
+func (h *extensionHandlerServer) ReceivedTransportParameters(data []byte) error {
 	chtp := &clientHelloTransportParameters{}
-	if err := chtp.Unmarshal(ext.data); err != nil {
+	if err := chtp.Unmarshal(data); err != nil {
 		return err
 	}
 
@@ -0,0 +1,113 @@
+package handshake
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/bifurcation/mint"
+	"github.com/wheelcomplex/qk/internal/protocol"
+	"github.com/wheelcomplex/qk/internal/utils"
+	"github.com/wheelcomplex/qk/qerr"
+)
+
+var errMintNotSupportedV1 = errors.New("QUIC v1 requires crypto/tls")
+
+// extensionHandlerV1 sends and receives the transport parameters for QUIC v1.
+// Since QUIC v1 is only supported with crypto/tls, which adds the TLS extension itself,
+// it only encodes and decodes the body of the extension.
+type extensionHandlerV1 struct {
+	ourParams  *TransportParameters
+	paramsChan chan TransportParameters
+
+	perspective protocol.Perspective
+	// The connection IDs the client expects the server to send in its transport parameters.
+	// Only set for the client.
+	origDestConnID protocol.ConnectionID
+	retrySrcConnID protocol.ConnectionID
+
+	logger utils.Logger
+}
+
+var _ TLSExtensionHandler = &extensionHandlerV1{}
+
+// NewExtensionHandlerServerV1 creates a new extension handler for a QUIC v1 server.
+func NewExtensionHandlerServerV1(params *TransportParameters, logger utils.Logger) TLSExtensionHandler {
+	// The session only reads the transport parameters after the crypto setup processed the ClientHello.
+	return &extensionHandlerV1{
+		ourParams:   params,
+		paramsChan:  make(chan TransportParameters, 1),
+		perspective: protocol.PerspectiveServer,
+		logger:      logger,
+	}
+}
+
+// NewExtensionHandlerClientV1 creates a new extension handler for a QUIC v1 client.
+// The retrySrcConnID is the source connection ID of the Retry packet, or nil if no Retry was received.
+func NewExtensionHandlerClientV1(
+	params *TransportParameters,
+	origDestConnID protocol.ConnectionID,
+	retrySrcConnID protocol.ConnectionID,
+	logger utils.Logger,
+) TLSExtensionHandler {
+	// see NewExtensionHandlerClient for why this channel is unbuffered
+	return &extensionHandlerV1{
+		ourParams:      params,
+		paramsChan:     make(chan TransportParameters),
+		perspective:    protocol.PerspectiveClient,
+		origDestConnID: origDestConnID,
+		retrySrcConnID: retrySrcConnID,
+		logger:         logger,
+	}
+}
+
+// GetExpectedConnectionIDsV1 returns the connection IDs that a QUIC v1 client expects in the server's transport parameters.
+// They are only set for extension handlers created by NewExtensionHandlerClientV1.
+func GetExpectedConnectionIDsV1(h TLSExtensionHandler) (origDestConnID, retrySrcConnID protocol.ConnectionID) {
+	handler, ok := h.(*extensionHandlerV1)
+	if !ok {
+		return nil, nil
+	}
+	return handler.origDestConnID, handler.retrySrcConnID
+}
+
+func (h *extensionHandlerV1) Send(mint.HandshakeType, *mint.ExtensionList) error {
+	return errMintNotSupportedV1
+}
+
+func (h *extensionHandlerV1) Receive(mint.HandshakeType, *mint.ExtensionList) error {
+	return errMintNotSupportedV1
+}
+
+func (h *extensionHandlerV1) TransportParameters() []byte {
+	h.logger.Debugf("Sending Transport Parameters: %s", h.ourParams)
+	b := &bytes.Buffer{}
+	h.ourParams.marshalV1(b, h.perspective)
+	return b.Bytes()
+}
+
+func (h *extensionHandlerV1) ReceivedTransportParameters(data []byte) error {
+	params := &TransportParameters{}
+	if err := params.unmarshalV1(data, h.perspective.Opposite()); err != nil {
+		return qerr.Error(qerr.InvalidNegotiatedValue, err.Error())
+	}
+	if h.perspective == protocol.PerspectiveClient {
+		if !params.OriginalDestinationConnectionID.Equal(h.origDestConnID) {
+			return qerr.Error(qerr.InvalidNegotiatedValue, fmt.Sprintf("expected original_destination_connection_id %s, got %s", h.origDestConnID, params.OriginalDestinationConnectionID))
+		}
+		if (h.retrySrcConnID == nil) != (params.RetrySourceConnectionID == nil) ||
+			!params.RetrySourceConnectionID.Equal(h.retrySrcConnID) {
+			return qerr.Error(qerr.InvalidNegotiatedValue, fmt.Sprintf("expected retry_source_connection_id %s, got %s", h.retrySrcConnID, params.RetrySourceConnectionID))
+		}
+		if len(params.StatelessResetToken) == 0 {
+			return qerr.Error(qerr.InvalidNegotiatedValue, "server didn't sent stateless_reset_token")
+		}
+	}
+	h.logger.Debugf("Received Transport Parameters: %s", params)
+	h.paramsChan <- *params
+	return nil
+}
+
+func (h *extensionHandlerV1) GetPeerParams() <-chan TransportParameters {
+	return h.paramsChan
+}
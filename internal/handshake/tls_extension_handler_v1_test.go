@@ -0,0 +1,111 @@
+package handshake
+
+import (
+	"bytes"
+
+	"github.com/wheelcomplex/qk/internal/protocol"
+	"github.com/wheelcomplex/qk/internal/utils"
+	"github.com/wheelcomplex/qk/qerr"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("TLS Extension Handler, for QUIC v1", func() {
+	var serverParams *TransportParameters
+
+	BeforeEach(func() {
+		serverParams = &TransportParameters{
+			StatelessResetToken:             bytes.Repeat([]byte{42}, 16),
+			OriginalDestinationConnectionID: protocol.ConnectionID{1, 2, 3, 4},
+			InitialSourceConnectionID:       protocol.ConnectionID{5, 6, 7, 8},
+		}
+	})
+
+	getServerExtension := func() []byte {
+		return NewExtensionHandlerServerV1(serverParams, utils.DefaultLogger).TransportParameters()
+	}
+
+	It("doesn't support mint", func() {
+		handler := NewExtensionHandlerServerV1(serverParams, utils.DefaultLogger)
+		Expect(handler.Send(0, nil)).To(MatchError(errMintNotSupportedV1))
+		Expect(handler.Receive(0, nil)).To(MatchError(errMintNotSupportedV1))
+	})
+
+	It("passes the client's parameters to the server", func() {
+		clientParams := &TransportParameters{InitialSourceConnectionID: protocol.ConnectionID{0xc, 0xd}}
+		data := NewExtensionHandlerClientV1(clientParams, nil, nil, utils.DefaultLogger).TransportParameters()
+		handler := NewExtensionHandlerServerV1(serverParams, utils.DefaultLogger)
+		Expect(handler.ReceivedTransportParameters(data)).To(Succeed())
+		var params TransportParameters
+		Expect(handler.GetPeerParams()).To(Receive(&params))
+		Expect(params.InitialSourceConnectionID).To(Equal(protocol.ConnectionID{0xc, 0xd}))
+	})
+
+	It("rejects invalid parameters", func() {
+		handler := NewExtensionHandlerServerV1(serverParams, utils.DefaultLogger)
+		err := handler.ReceivedTransportParameters([]byte{0x4})
+		Expect(err).To(HaveOccurred())
+		Expect(err.(*qerr.QuicError).ErrorCode).To(Equal(qerr.InvalidNegotiatedValue))
+	})
+
+	Context("for the client", func() {
+		receive := func(handler TLSExtensionHandler, data []byte) error {
+			errChan := make(chan error, 1)
+			go func() { errChan <- handler.ReceivedTransportParameters(data) }()
+			select {
+			case <-handler.GetPeerParams():
+				return nil
+			case err := <-errChan:
+				return err
+			}
+		}
+
+		It("accepts the server's parameters", func() {
+			handler := NewExtensionHandlerClientV1(&TransportParameters{}, protocol.ConnectionID{1, 2, 3, 4}, nil, utils.DefaultLogger)
+			Expect(receive(handler, getServerExtension())).To(Succeed())
+		})
+
+		It("errors if the original_destination_connection_id doesn't match", func() {
+			handler := NewExtensionHandlerClientV1(&TransportParameters{}, protocol.ConnectionID{1, 2, 3}, nil, utils.DefaultLogger)
+			Expect(receive(handler, getServerExtension())).To(MatchError("InvalidNegotiatedValue: expected original_destination_connection_id 0x010203, got 0x01020304"))
+		})
+
+		It("accepts a matching retry_source_connection_id", func() {
+			serverParams.RetrySourceConnectionID = protocol.ConnectionID{0xa, 0xb}
+			handler := NewExtensionHandlerClientV1(&TransportParameters{}, protocol.ConnectionID{1, 2, 3, 4}, protocol.ConnectionID{0xa, 0xb}, utils.DefaultLogger)
+			Expect(receive(handler, getServerExtension())).To(Succeed())
+		})
+
+		It("errors if the server didn't send the retry_source_connection_id after a Retry", func() {
+			handler := NewExtensionHandlerClientV1(&TransportParameters{}, protocol.ConnectionID{1, 2, 3, 4}, protocol.ConnectionID{0xa, 0xb}, utils.DefaultLogger)
+			err := receive(handler, getServerExtension())
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("expected retry_source_connection_id"))
+		})
+
+		It("errors if the server sent a retry_source_connection_id without a Retry", func() {
+			serverParams.RetrySourceConnectionID = protocol.ConnectionID{0xa, 0xb}
+			handler := NewExtensionHandlerClientV1(&TransportParameters{}, protocol.ConnectionID{1, 2, 3, 4}, nil, utils.DefaultLogger)
+			err := receive(handler, getServerExtension())
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("expected retry_source_connection_id"))
+		})
+
+		It("returns the expected connection IDs", func() {
+			handler := NewExtensionHandlerClientV1(&TransportParameters{}, protocol.ConnectionID{1, 2, 3, 4}, protocol.ConnectionID{0xa, 0xb}, utils.DefaultLogger)
+			origDestConnID, retrySrcConnID := GetExpectedConnectionIDsV1(handler)
+			Expect(origDestConnID).To(Equal(protocol.ConnectionID{1, 2, 3, 4}))
+			Expect(retrySrcConnID).To(Equal(protocol.ConnectionID{0xa, 0xb}))
+			origDestConnID, retrySrcConnID = GetExpectedConnectionIDsV1(NewExtensionHandlerServerV1(serverParams, utils.DefaultLogger))
+			Expect(origDestConnID).To(BeNil())
+			Expect(retrySrcConnID).To(BeNil())
+		})
+
+		It("errors if the server didn't send a stateless_reset_token", func() {
+			serverParams.StatelessResetToken = nil
+			handler := NewExtensionHandlerClientV1(&TransportParameters{}, protocol.ConnectionID{1, 2, 3, 4}, nil, utils.DefaultLogger)
+			Expect(receive(handler, getServerExtension())).To(MatchError("InvalidNegotiatedValue: server didn't sent stateless_reset_token"))
+		})
+	})
+})
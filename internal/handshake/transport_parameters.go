@@ -30,6 +30,12 @@ type TransportParameters struct {
 	IdleTimeout         time.Duration
 	DisableMigration    bool   // only used for IETF QUIC
 	StatelessResetToken []byte // only used for IETF QUIC
+
+	// The connection IDs used during the handshake, only used for QUIC v1.
+	// They authenticate the connection IDs sent in the (unauthenticated) Long Header.
+	OriginalDestinationConnectionID protocol.ConnectionID // only sent by the server
+	InitialSourceConnectionID       protocol.ConnectionID
+	RetrySourceConnectionID         protocol.ConnectionID // only sent by the server, if it performed a Retry
 }
 
 // readHelloMap reads the transport parameters from the tags sent in a gQUIC handshake message
@@ -0,0 +1,183 @@
+package handshake
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/wheelcomplex/qk/internal/protocol"
+	"github.com/wheelcomplex/qk/internal/utils"
+)
+
+// The transport parameters defined in RFC 9000, section 18.2.
+// Other than for the draft versions, the IDs and the lengths are encoded as varints.
+const (
+	originalDestinationConnectionIDParameterIDV1 uint64 = 0x00
+	maxIdleTimeoutParameterIDV1                  uint64 = 0x01
+	statelessResetTokenParameterIDV1             uint64 = 0x02
+	maxUDPPayloadSizeParameterIDV1               uint64 = 0x03
+	initialMaxDataParameterIDV1                  uint64 = 0x04
+	initialMaxStreamDataBidiLocalParameterIDV1   uint64 = 0x05
+	initialMaxStreamDataBidiRemoteParameterIDV1  uint64 = 0x06
+	initialMaxStreamDataUniParameterIDV1         uint64 = 0x07
+	initialMaxStreamsBidiParameterIDV1           uint64 = 0x08
+	initialMaxStreamsUniParameterIDV1            uint64 = 0x09
+	ackDelayExponentParameterIDV1                uint64 = 0x0a
+	maxAckDelayParameterIDV1                     uint64 = 0x0b
+	disableActiveMigrationParameterIDV1          uint64 = 0x0c
+	preferredAddressParameterIDV1                uint64 = 0x0d
+	activeConnectionIDLimitParameterIDV1         uint64 = 0x0e
+	initialSourceConnectionIDParameterIDV1       uint64 = 0x0f
+	retrySourceConnectionIDParameterIDV1         uint64 = 0x10
+)
+
+// unmarshalV1 parses the transport parameters sent in the QUIC v1 TLS extension.
+// Unknown parameters are ignored.
+func (p *TransportParameters) unmarshalV1(data []byte, sentBy protocol.Perspective) error {
+	r := bytes.NewReader(data)
+	var foundInitialSourceConnectionID bool
+	seen := make(map[uint64]struct{})
+	for r.Len() > 0 {
+		paramID, err := utils.ReadVarInt(r)
+		if err != nil {
+			return err
+		}
+		paramLen, err := utils.ReadVarInt(r)
+		if err != nil {
+			return err
+		}
+		if paramLen > uint64(r.Len()) {
+			return fmt.Errorf("remaining length (%d) smaller than parameter length (%d)", r.Len(), paramLen)
+		}
+		if _, ok := seen[paramID]; ok {
+			return fmt.Errorf("received duplicate transport parameter %#x", paramID)
+		}
+		seen[paramID] = struct{}{}
+		val := make([]byte, paramLen)
+		r.Read(val)
+
+		switch paramID {
+		case originalDestinationConnectionIDParameterIDV1,
+			statelessResetTokenParameterIDV1,
+			preferredAddressParameterIDV1,
+			retrySourceConnectionIDParameterIDV1:
+			if sentBy == protocol.PerspectiveClient {
+				return fmt.Errorf("client sent a server-only transport parameter: %#x", paramID)
+			}
+		}
+
+		switch paramID {
+		case originalDestinationConnectionIDParameterIDV1:
+			p.OriginalDestinationConnectionID = protocol.ConnectionID(val)
+		case initialSourceConnectionIDParameterIDV1:
+			p.InitialSourceConnectionID = protocol.ConnectionID(val)
+			foundInitialSourceConnectionID = true
+		case retrySourceConnectionIDParameterIDV1:
+			p.RetrySourceConnectionID = protocol.ConnectionID(val)
+		case statelessResetTokenParameterIDV1:
+			if len(val) != 16 {
+				return fmt.Errorf("wrong length for stateless_reset_token: %d (expected 16)", len(val))
+			}
+			p.StatelessResetToken = val
+		case disableActiveMigrationParameterIDV1:
+			if len(val) != 0 {
+				return fmt.Errorf("wrong length for disable_active_migration: %d (expected empty)", len(val))
+			}
+			p.DisableMigration = true
+		case maxIdleTimeoutParameterIDV1,
+			maxUDPPayloadSizeParameterIDV1,
+			initialMaxDataParameterIDV1,
+			initialMaxStreamDataBidiLocalParameterIDV1,
+			initialMaxStreamDataBidiRemoteParameterIDV1,
+			initialMaxStreamDataUniParameterIDV1,
+			initialMaxStreamsBidiParameterIDV1,
+			initialMaxStreamsUniParameterIDV1:
+			if err := p.readNumericParameterV1(paramID, val); err != nil {
+				return err
+			}
+		}
+	}
+	// A peer that doesn't send the max_idle_timeout doesn't use an idle timeout.
+	if p.IdleTimeout == 0 {
+		p.IdleTimeout = protocol.DefaultIdleTimeout
+	}
+	if !foundInitialSourceConnectionID {
+		return fmt.Errorf("missing initial_source_connection_id")
+	}
+	if sentBy == protocol.PerspectiveServer && p.OriginalDestinationConnectionID == nil {
+		return fmt.Errorf("missing original_destination_connection_id")
+	}
+	return nil
+}
+
+func (p *TransportParameters) readNumericParameterV1(paramID uint64, val []byte) error {
+	r := bytes.NewReader(val)
+	v, err := utils.ReadVarInt(r)
+	if err != nil || r.Len() != 0 {
+		return fmt.Errorf("invalid value for transport parameter %#x", paramID)
+	}
+	switch paramID {
+	case maxIdleTimeoutParameterIDV1:
+		if v != 0 {
+			p.IdleTimeout = utils.MaxDuration(protocol.MinRemoteIdleTimeout, time.Duration(v)*time.Millisecond)
+		}
+	case maxUDPPayloadSizeParameterIDV1:
+		if v < 1200 {
+			return fmt.Errorf("invalid value for max_udp_payload_size: %d (minimum 1200)", v)
+		}
+		p.MaxPacketSize = protocol.ByteCount(v)
+	case initialMaxDataParameterIDV1:
+		p.ConnectionFlowControlWindow = protocol.ByteCount(v)
+	case initialMaxStreamDataBidiLocalParameterIDV1, initialMaxStreamDataBidiRemoteParameterIDV1:
+		// We only use a single stream flow control window for all streams.
+		// Use the smaller value of the windows for bidirectional streams.
+		if p.StreamFlowControlWindow == 0 || protocol.ByteCount(v) < p.StreamFlowControlWindow {
+			p.StreamFlowControlWindow = protocol.ByteCount(v)
+		}
+	case initialMaxStreamDataUniParameterIDV1:
+		// only relevant for unidirectional streams, which are rarely used
+	case initialMaxStreamsBidiParameterIDV1:
+		p.MaxBidiStreams = uint16(utils.MinUint64(v, math.MaxUint16))
+	case initialMaxStreamsUniParameterIDV1:
+		p.MaxUniStreams = uint16(utils.MinUint64(v, math.MaxUint16))
+	}
+	return nil
+}
+
+// marshalV1 writes the transport parameters for the QUIC v1 TLS extension.
+func (p *TransportParameters) marshalV1(b *bytes.Buffer, pers protocol.Perspective) {
+	if pers == protocol.PerspectiveServer {
+		writeParameterV1(b, originalDestinationConnectionIDParameterIDV1, p.OriginalDestinationConnectionID.Bytes())
+		if p.RetrySourceConnectionID != nil {
+			writeParameterV1(b, retrySourceConnectionIDParameterIDV1, p.RetrySourceConnectionID.Bytes())
+		}
+		if len(p.StatelessResetToken) > 0 {
+			writeParameterV1(b, statelessResetTokenParameterIDV1, p.StatelessResetToken)
+		}
+	}
+	writeParameterV1(b, initialSourceConnectionIDParameterIDV1, p.InitialSourceConnectionID.Bytes())
+	writeNumericParameterV1(b, maxIdleTimeoutParameterIDV1, uint64(p.IdleTimeout/time.Millisecond))
+	writeNumericParameterV1(b, maxUDPPayloadSizeParameterIDV1, uint64(protocol.MaxReceivePacketSize))
+	writeNumericParameterV1(b, initialMaxDataParameterIDV1, uint64(p.ConnectionFlowControlWindow))
+	writeNumericParameterV1(b, initialMaxStreamDataBidiLocalParameterIDV1, uint64(p.StreamFlowControlWindow))
+	writeNumericParameterV1(b, initialMaxStreamDataBidiRemoteParameterIDV1, uint64(p.StreamFlowControlWindow))
+	writeNumericParameterV1(b, initialMaxStreamDataUniParameterIDV1, uint64(p.StreamFlowControlWindow))
+	writeNumericParameterV1(b, initialMaxStreamsBidiParameterIDV1, uint64(p.MaxBidiStreams))
+	writeNumericParameterV1(b, initialMaxStreamsUniParameterIDV1, uint64(p.MaxUniStreams))
+	if p.DisableMigration {
+		writeParameterV1(b, disableActiveMigrationParameterIDV1, nil)
+	}
+}
+
+func writeParameterV1(b *bytes.Buffer, id uint64, val []byte) {
+	utils.WriteVarInt(b, id)
+	utils.WriteVarInt(b, uint64(len(val)))
+	b.Write(val)
+}
+
+func writeNumericParameterV1(b *bytes.Buffer, id uint64, val uint64) {
+	utils.WriteVarInt(b, id)
+	utils.WriteVarInt(b, uint64(utils.VarIntLen(val)))
+	utils.WriteVarInt(b, val)
+}
@@ -0,0 +1,181 @@
+package handshake
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/wheelcomplex/qk/internal/protocol"
+	"github.com/wheelcomplex/qk/internal/utils"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Transport Parameters, for QUIC v1", func() {
+	getServerParams := func() *TransportParameters {
+		return &TransportParameters{
+			StreamFlowControlWindow:         0x1234,
+			ConnectionFlowControlWindow:     0x4321,
+			MaxBidiStreams:                  100,
+			MaxUniStreams:                   10,
+			IdleTimeout:                     42 * time.Second,
+			DisableMigration:                true,
+			StatelessResetToken:             bytes.Repeat([]byte{0xde}, 16),
+			OriginalDestinationConnectionID: protocol.ConnectionID{1, 2, 3, 4},
+			InitialSourceConnectionID:       protocol.ConnectionID{5, 6, 7, 8},
+			RetrySourceConnectionID:         protocol.ConnectionID{9, 10},
+		}
+	}
+
+	It("marshals and unmarshals the server's parameters", func() {
+		b := &bytes.Buffer{}
+		getServerParams().marshalV1(b, protocol.PerspectiveServer)
+		p := &TransportParameters{}
+		Expect(p.unmarshalV1(b.Bytes(), protocol.PerspectiveServer)).To(Succeed())
+		Expect(p.StreamFlowControlWindow).To(Equal(protocol.ByteCount(0x1234)))
+		Expect(p.ConnectionFlowControlWindow).To(Equal(protocol.ByteCount(0x4321)))
+		Expect(p.MaxBidiStreams).To(Equal(uint16(100)))
+		Expect(p.MaxUniStreams).To(Equal(uint16(10)))
+		Expect(p.IdleTimeout).To(Equal(42 * time.Second))
+		Expect(p.DisableMigration).To(BeTrue())
+		Expect(p.MaxPacketSize).To(Equal(protocol.ByteCount(protocol.MaxReceivePacketSize)))
+		Expect(p.StatelessResetToken).To(Equal(bytes.Repeat([]byte{0xde}, 16)))
+		Expect(p.OriginalDestinationConnectionID).To(Equal(protocol.ConnectionID{1, 2, 3, 4}))
+		Expect(p.InitialSourceConnectionID).To(Equal(protocol.ConnectionID{5, 6, 7, 8}))
+		Expect(p.RetrySourceConnectionID).To(Equal(protocol.ConnectionID{9, 10}))
+	})
+
+	It("doesn't send server-only parameters as a client", func() {
+		b := &bytes.Buffer{}
+		getServerParams().marshalV1(b, protocol.PerspectiveClient)
+		p := &TransportParameters{}
+		Expect(p.unmarshalV1(b.Bytes(), protocol.PerspectiveClient)).To(Succeed())
+		Expect(p.OriginalDestinationConnectionID).To(BeNil())
+		Expect(p.RetrySourceConnectionID).To(BeNil())
+		Expect(p.StatelessResetToken).To(BeEmpty())
+		Expect(p.InitialSourceConnectionID).To(Equal(protocol.ConnectionID{5, 6, 7, 8}))
+	})
+
+	It("marshals zero-length connection IDs", func() {
+		params := getServerParams()
+		params.InitialSourceConnectionID = protocol.ConnectionID{}
+		b := &bytes.Buffer{}
+		params.marshalV1(b, protocol.PerspectiveClient)
+		p := &TransportParameters{}
+		Expect(p.unmarshalV1(b.Bytes(), protocol.PerspectiveClient)).To(Succeed())
+		Expect(p.InitialSourceConnectionID).To(BeEmpty())
+	})
+
+	It("uses the smaller window of the bidirectional stream windows", func() {
+		b := &bytes.Buffer{}
+		writeParameterV1(b, initialSourceConnectionIDParameterIDV1, nil)
+		writeNumericParameterV1(b, initialMaxStreamDataBidiLocalParameterIDV1, 2000)
+		writeNumericParameterV1(b, initialMaxStreamDataBidiRemoteParameterIDV1, 1000)
+		p := &TransportParameters{}
+		Expect(p.unmarshalV1(b.Bytes(), protocol.PerspectiveClient)).To(Succeed())
+		Expect(p.StreamFlowControlWindow).To(Equal(protocol.ByteCount(1000)))
+	})
+
+	It("uses the default idle timeout if the peer didn't send one", func() {
+		b := &bytes.Buffer{}
+		writeParameterV1(b, initialSourceConnectionIDParameterIDV1, nil)
+		p := &TransportParameters{}
+		Expect(p.unmarshalV1(b.Bytes(), protocol.PerspectiveClient)).To(Succeed())
+		Expect(p.IdleTimeout).To(Equal(protocol.DefaultIdleTimeout))
+	})
+
+	It("doesn't allow idle timeouts below the minimum remote idle timeout", func() {
+		b := &bytes.Buffer{}
+		writeParameterV1(b, initialSourceConnectionIDParameterIDV1, nil)
+		writeNumericParameterV1(b, maxIdleTimeoutParameterIDV1, 1)
+		p := &TransportParameters{}
+		Expect(p.unmarshalV1(b.Bytes(), protocol.PerspectiveClient)).To(Succeed())
+		Expect(p.IdleTimeout).To(Equal(protocol.MinRemoteIdleTimeout))
+	})
+
+	It("caps the number of streams", func() {
+		b := &bytes.Buffer{}
+		writeParameterV1(b, initialSourceConnectionIDParameterIDV1, nil)
+		writeNumericParameterV1(b, initialMaxStreamsBidiParameterIDV1, 1<<40)
+		p := &TransportParameters{}
+		Expect(p.unmarshalV1(b.Bytes(), protocol.PerspectiveClient)).To(Succeed())
+		Expect(p.MaxBidiStreams).To(Equal(uint16(0xffff)))
+	})
+
+	It("ignores unknown parameters", func() {
+		b := &bytes.Buffer{}
+		writeParameterV1(b, initialSourceConnectionIDParameterIDV1, nil)
+		writeParameterV1(b, 0x1337, []byte("foobar"))
+		p := &TransportParameters{}
+		Expect(p.unmarshalV1(b.Bytes(), protocol.PerspectiveClient)).To(Succeed())
+	})
+
+	It("errors if the initial_source_connection_id is missing", func() {
+		b := &bytes.Buffer{}
+		writeNumericParameterV1(b, initialMaxDataParameterIDV1, 1000)
+		p := &TransportParameters{}
+		Expect(p.unmarshalV1(b.Bytes(), protocol.PerspectiveClient)).To(MatchError("missing initial_source_connection_id"))
+	})
+
+	It("errors if the server doesn't send the original_destination_connection_id", func() {
+		b := &bytes.Buffer{}
+		writeParameterV1(b, initialSourceConnectionIDParameterIDV1, nil)
+		p := &TransportParameters{}
+		Expect(p.unmarshalV1(b.Bytes(), protocol.PerspectiveServer)).To(MatchError("missing original_destination_connection_id"))
+	})
+
+	It("errors if the client sends a server-only parameter", func() {
+		b := &bytes.Buffer{}
+		writeParameterV1(b, initialSourceConnectionIDParameterIDV1, nil)
+		writeParameterV1(b, statelessResetTokenParameterIDV1, make([]byte, 16))
+		p := &TransportParameters{}
+		Expect(p.unmarshalV1(b.Bytes(), protocol.PerspectiveClient)).To(MatchError("client sent a server-only transport parameter: 0x2"))
+	})
+
+	It("errors on duplicate parameters", func() {
+		b := &bytes.Buffer{}
+		writeParameterV1(b, initialSourceConnectionIDParameterIDV1, nil)
+		writeParameterV1(b, initialSourceConnectionIDParameterIDV1, nil)
+		p := &TransportParameters{}
+		Expect(p.unmarshalV1(b.Bytes(), protocol.PerspectiveClient)).To(MatchError("received duplicate transport parameter 0xf"))
+	})
+
+	It("errors if a parameter exceeds the remaining length", func() {
+		b := &bytes.Buffer{}
+		utils.WriteVarInt(b, uint64(initialMaxDataParameterIDV1))
+		utils.WriteVarInt(b, 8)
+		b.Write([]byte{1, 2})
+		p := &TransportParameters{}
+		Expect(p.unmarshalV1(b.Bytes(), protocol.PerspectiveClient)).To(MatchError("remaining length (2) smaller than parameter length (8)"))
+	})
+
+	It("errors if a numeric parameter has the wrong length", func() {
+		b := &bytes.Buffer{}
+		utils.WriteVarInt(b, uint64(initialMaxDataParameterIDV1))
+		utils.WriteVarInt(b, 2)
+		b.Write([]byte{1, 2}) // 1 is a 1 byte varint
+		p := &TransportParameters{}
+		Expect(p.unmarshalV1(b.Bytes(), protocol.PerspectiveClient)).To(MatchError("invalid value for transport parameter 0x4"))
+	})
+
+	It("errors if the stateless_reset_token has the wrong length", func() {
+		b := &bytes.Buffer{}
+		writeParameterV1(b, statelessResetTokenParameterIDV1, make([]byte, 15))
+		p := &TransportParameters{}
+		Expect(p.unmarshalV1(b.Bytes(), protocol.PerspectiveServer)).To(MatchError("wrong length for stateless_reset_token: 15 (expected 16)"))
+	})
+
+	It("errors if the disable_active_migration isn't empty", func() {
+		b := &bytes.Buffer{}
+		writeParameterV1(b, disableActiveMigrationParameterIDV1, []byte{0})
+		p := &TransportParameters{}
+		Expect(p.unmarshalV1(b.Bytes(), protocol.PerspectiveServer)).To(MatchError("wrong length for disable_active_migration: 1 (expected empty)"))
+	})
+
+	It("rejects max_udp_payload_sizes smaller than 1200 bytes", func() {
+		b := &bytes.Buffer{}
+		writeNumericParameterV1(b, maxUDPPayloadSizeParameterIDV1, 1199)
+		p := &TransportParameters{}
+		Expect(p.unmarshalV1(b.Bytes(), protocol.PerspectiveServer)).To(MatchError("invalid value for max_udp_payload_size: 1199 (minimum 1200)"))
+	})
+})
@@ -7,3 +7,5 @@ package mocks
 //go:generate sh -c "../mockgen_internal.sh mocks congestion.go github.com/wheelcomplex/qk/internal/congestion SendAlgorithm"
 //go:generate sh -c "../mockgen_internal.sh mocks connection_flow_controller.go github.com/wheelcomplex/qk/internal/flowcontrol ConnectionFlowController"
 //go:generate sh -c "../mockgen_internal.sh mockcrypto crypto/aead.go github.com/wheelcomplex/qk/internal/crypto AEAD"
+//go:generate sh -c "../mockgen_internal.sh mockcrypto crypto/header_protector.go github.com/wheelcomplex/qk/internal/crypto HeaderProtector"
+//go:generate sh -c "mockgen -package mockquic -destination quic/session.go github.com/wheelcomplex/qk Session"
@@ -59,6 +59,18 @@ func (mr *MockTLSExtensionHandlerMockRecorder) Receive(arg0, arg1 interface{}) *
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Receive", reflect.TypeOf((*MockTLSExtensionHandler)(nil).Receive), arg0, arg1)
 }
 
+// ReceivedTransportParameters mocks base method
+func (m *MockTLSExtensionHandler) ReceivedTransportParameters(arg0 []byte) error {
+	ret := m.ctrl.Call(m, "ReceivedTransportParameters", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ReceivedTransportParameters indicates an expected call of ReceivedTransportParameters
+func (mr *MockTLSExtensionHandlerMockRecorder) ReceivedTransportParameters(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReceivedTransportParameters", reflect.TypeOf((*MockTLSExtensionHandler)(nil).ReceivedTransportParameters), arg0)
+}
+
 // Send mocks base method
 func (m *MockTLSExtensionHandler) Send(arg0 mint.HandshakeType, arg1 *mint.ExtensionList) error {
 	ret := m.ctrl.Call(m, "Send", arg0, arg1)
@@ -70,3 +82,15 @@ func (m *MockTLSExtensionHandler) Send(arg0 mint.HandshakeType, arg1 *mint.Exten
 func (mr *MockTLSExtensionHandlerMockRecorder) Send(arg0, arg1 interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Send", reflect.TypeOf((*MockTLSExtensionHandler)(nil).Send), arg0, arg1)
 }
+
+// TransportParameters mocks base method
+func (m *MockTLSExtensionHandler) TransportParameters() []byte {
+	ret := m.ctrl.Call(m, "TransportParameters")
+	ret0, _ := ret[0].([]byte)
+	return ret0
+}
+
+// TransportParameters indicates an expected call of TransportParameters
+func (mr *MockTLSExtensionHandlerMockRecorder) TransportParameters() *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TransportParameters", reflect.TypeOf((*MockTLSExtensionHandler)(nil).TransportParameters))
+}
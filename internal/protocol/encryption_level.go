@@ -9,6 +9,10 @@ const (
 	EncryptionUnspecified EncryptionLevel = iota
 	// EncryptionUnencrypted is not encrypted
 	EncryptionUnencrypted
+	// EncryptionInitial is used for Initial packets (QUIC v1)
+	EncryptionInitial
+	// EncryptionHandshake is used for Handshake packets (QUIC v1)
+	EncryptionHandshake
 	// EncryptionSecure is encrypted, but not forward secure
 	EncryptionSecure
 	// EncryptionForwardSecure is forward secure
@@ -19,6 +23,10 @@ func (e EncryptionLevel) String() string {
 	switch e {
 	case EncryptionUnencrypted:
 		return "unencrypted"
+	case EncryptionInitial:
+		return "Initial"
+	case EncryptionHandshake:
+		return "Handshake"
 	case EncryptionSecure:
 		return "encrypted (not forward-secure)"
 	case EncryptionForwardSecure:
@@ -9,6 +9,8 @@ var _ = Describe("Encryption Level", func() {
 	It("has the correct string representation", func() {
 		Expect(EncryptionUnspecified.String()).To(Equal("unknown"))
 		Expect(EncryptionUnencrypted.String()).To(Equal("unencrypted"))
+		Expect(EncryptionInitial.String()).To(Equal("Initial"))
+		Expect(EncryptionHandshake.String()).To(Equal("Handshake"))
 		Expect(EncryptionSecure.String()).To(Equal("encrypted (not forward-secure)"))
 		Expect(EncryptionForwardSecure.String()).To(Equal("forward-secure"))
 	})
@@ -2,11 +2,15 @@ package protocol
 
 import (
 	"fmt"
+	"math"
 )
 
 // A PacketNumber in QUIC
 type PacketNumber uint64
 
+// MaxPacketNumber is the largest value a PacketNumber can take
+const MaxPacketNumber PacketNumber = math.MaxUint64
+
 // PacketNumberLen is the length of the packet number in bytes
 type PacketNumberLen uint8
 
@@ -17,6 +21,8 @@ const (
 	PacketNumberLen1 PacketNumberLen = 1
 	// PacketNumberLen2 is a packet number length of 2 bytes
 	PacketNumberLen2 PacketNumberLen = 2
+	// PacketNumberLen3 is a packet number length of 3 bytes (only used by QUIC v1)
+	PacketNumberLen3 PacketNumberLen = 3
 	// PacketNumberLen4 is a packet number length of 4 bytes
 	PacketNumberLen4 PacketNumberLen = 4
 	// PacketNumberLen6 is a packet number length of 6 bytes
@@ -103,6 +103,10 @@ const MaxNonRetransmittableAcks = 19
 // prevents DoS attacks against the streamFrameSorter
 const MaxStreamFrameSorterGaps = 1000
 
+// MaxCryptoStreamOffset is the maximum offset allowed on any of the crypto streams used in QUIC v1.
+// This limits the size of the ClientHello and the certificates that can be received.
+const MaxCryptoStreamOffset = 16 * (1 << 10)
+
 // CryptoMaxParams is the upper limit for the number of parameters in a crypto message.
 // Value taken from Chrome.
 const CryptoMaxParams = 128
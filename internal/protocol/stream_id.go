@@ -3,16 +3,24 @@ package protocol
 // A StreamID in QUIC
 type StreamID uint64
 
+// InvalidStreamID is a stream ID that is never used by any stream.
+// Stream IDs are encoded as varints, so the largest stream ID is 2^62-1.
+const InvalidStreamID StreamID = 1 << 62
+
 // MaxBidiStreamID is the highest stream ID that the peer is allowed to open,
 // when it is allowed to open numStreams bidirectional streams.
 // It is only valid for IETF QUIC.
-func MaxBidiStreamID(numStreams int, pers Perspective) StreamID {
+// For QUIC v1, the return value for 0 streams is ambiguous, since stream 0 is a regular stream.
+func MaxBidiStreamID(numStreams int, pers Perspective, version VersionNumber) StreamID {
 	if numStreams == 0 {
 		return 0
 	}
 	var first StreamID
 	if pers == PerspectiveClient {
 		first = 1
+	} else if version.UsesCryptoFrames() {
+		// stream 0 is not used by the crypto stream
+		first = 0
 	} else {
 		first = 4
 	}
@@ -22,7 +30,7 @@ func MaxBidiStreamID(numStreams int, pers Perspective) StreamID {
 // MaxUniStreamID is the highest stream ID that the peer is allowed to open,
 // when it is allowed to open numStreams unidirectional streams.
 // It is only valid for IETF QUIC.
-func MaxUniStreamID(numStreams int, pers Perspective) StreamID {
+func MaxUniStreamID(numStreams int, pers Perspective, version VersionNumber) StreamID {
 	if numStreams == 0 {
 		return 0
 	}
@@ -34,3 +42,13 @@ func MaxUniStreamID(numStreams int, pers Perspective) StreamID {
 	}
 	return first + 4*StreamID(numStreams-1)
 }
+
+// StreamType encodes if this is a unidirectional or bidirectional stream
+type StreamType uint8
+
+const (
+	// StreamTypeUni is a unidirectional stream
+	StreamTypeUni StreamType = iota
+	// StreamTypeBidi is a bidirectional stream
+	StreamTypeBidi
+)
@@ -8,35 +8,46 @@ import (
 var _ = Describe("Stream ID", func() {
 	Context("bidirectional streams", func() {
 		It("doesn't allow any", func() {
-			Expect(MaxBidiStreamID(0, PerspectiveClient)).To(Equal(StreamID(0)))
-			Expect(MaxBidiStreamID(0, PerspectiveServer)).To(Equal(StreamID(0)))
+			Expect(MaxBidiStreamID(0, PerspectiveClient, VersionTLS)).To(Equal(StreamID(0)))
+			Expect(MaxBidiStreamID(0, PerspectiveServer, VersionTLS)).To(Equal(StreamID(0)))
 		})
 
 		It("allows one", func() {
-			Expect(MaxBidiStreamID(1, PerspectiveClient)).To(Equal(StreamID(1)))
-			Expect(MaxBidiStreamID(1, PerspectiveServer)).To(Equal(StreamID(4)))
+			Expect(MaxBidiStreamID(1, PerspectiveClient, VersionTLS)).To(Equal(StreamID(1)))
+			Expect(MaxBidiStreamID(1, PerspectiveServer, VersionTLS)).To(Equal(StreamID(4)))
 		})
 
 		It("allows many", func() {
-			Expect(MaxBidiStreamID(100, PerspectiveClient)).To(Equal(StreamID(397)))
-			Expect(MaxBidiStreamID(100, PerspectiveServer)).To(Equal(StreamID(400)))
+			Expect(MaxBidiStreamID(100, PerspectiveClient, VersionTLS)).To(Equal(StreamID(397)))
+			Expect(MaxBidiStreamID(100, PerspectiveServer, VersionTLS)).To(Equal(StreamID(400)))
+		})
+
+		It("uses stream 0 for QUIC v1", func() {
+			Expect(MaxBidiStreamID(1, PerspectiveClient, Version1)).To(Equal(StreamID(1)))
+			Expect(MaxBidiStreamID(1, PerspectiveServer, Version1)).To(Equal(StreamID(0)))
+			Expect(MaxBidiStreamID(100, PerspectiveServer, Version1)).To(Equal(StreamID(396)))
 		})
 	})
 
 	Context("unidirectional streams", func() {
 		It("doesn't allow any", func() {
-			Expect(MaxUniStreamID(0, PerspectiveClient)).To(Equal(StreamID(0)))
-			Expect(MaxUniStreamID(0, PerspectiveServer)).To(Equal(StreamID(0)))
+			Expect(MaxUniStreamID(0, PerspectiveClient, VersionTLS)).To(Equal(StreamID(0)))
+			Expect(MaxUniStreamID(0, PerspectiveServer, VersionTLS)).To(Equal(StreamID(0)))
 		})
 
 		It("allows one", func() {
-			Expect(MaxUniStreamID(1, PerspectiveClient)).To(Equal(StreamID(3)))
-			Expect(MaxUniStreamID(1, PerspectiveServer)).To(Equal(StreamID(2)))
+			Expect(MaxUniStreamID(1, PerspectiveClient, VersionTLS)).To(Equal(StreamID(3)))
+			Expect(MaxUniStreamID(1, PerspectiveServer, VersionTLS)).To(Equal(StreamID(2)))
 		})
 
 		It("allows many", func() {
-			Expect(MaxUniStreamID(100, PerspectiveClient)).To(Equal(StreamID(399)))
-			Expect(MaxUniStreamID(100, PerspectiveServer)).To(Equal(StreamID(398)))
+			Expect(MaxUniStreamID(100, PerspectiveClient, VersionTLS)).To(Equal(StreamID(399)))
+			Expect(MaxUniStreamID(100, PerspectiveServer, VersionTLS)).To(Equal(StreamID(398)))
+		})
+
+		It("uses the same stream IDs for QUIC v1", func() {
+			Expect(MaxUniStreamID(1, PerspectiveClient, Version1)).To(Equal(StreamID(3)))
+			Expect(MaxUniStreamID(1, PerspectiveServer, Version1)).To(Equal(StreamID(2)))
 		})
 	})
 })
@@ -22,7 +22,8 @@ const (
 	Version43       VersionNumber = gquicVersion0 + 4*0x100 + 0x3
 	Version44       VersionNumber = gquicVersion0 + 4*0x100 + 0x4
 	VersionTLS      VersionNumber = 101
-	VersionWhatever VersionNumber = 0 // for when the version doesn't matter
+	Version1        VersionNumber = 0x1 // QUIC v1, RFC 9000
+	VersionWhatever VersionNumber = 0   // for when the version doesn't matter
 	VersionUnknown  VersionNumber = math.MaxUint32
 )
 
@@ -36,7 +37,7 @@ var SupportedVersions = []VersionNumber{
 
 // IsValidVersion says if the version is known to quic-go
 func IsValidVersion(v VersionNumber) bool {
-	return v == VersionTLS || IsSupportedVersion(SupportedVersions, v)
+	return v == VersionTLS || v == Version1 || IsSupportedVersion(SupportedVersions, v)
 }
 
 // UsesTLS says if this QUIC version uses TLS 1.3 for the handshake
@@ -52,6 +53,8 @@ func (vn VersionNumber) String() string {
 		return "unknown"
 	case VersionTLS:
 		return "TLS dev version (WIP)"
+	case Version1:
+		return "QUIC v1"
 	default:
 		if vn.isGQUIC() {
 			return fmt.Sprintf("gQUIC %d", vn.toGQUICVersion())
@@ -68,11 +71,16 @@ func (vn VersionNumber) ToAltSvc() string {
 	return fmt.Sprintf("%d", vn)
 }
 
-// CryptoStreamID gets the Stream ID of the crypto stream
+// CryptoStreamID gets the Stream ID of the crypto stream.
+// Versions that send handshake data in CRYPTO frames don't use a crypto stream.
+// For these versions, the InvalidStreamID is returned.
 func (vn VersionNumber) CryptoStreamID() StreamID {
 	if vn.isGQUIC() {
 		return 1
 	}
+	if vn.UsesCryptoFrames() {
+		return InvalidStreamID
+	}
 	return 0
 }
 
@@ -86,6 +94,33 @@ func (vn VersionNumber) UsesIETFHeaderFormat() bool {
 	return !vn.isGQUIC() || vn >= Version44
 }
 
+// UsesV1HeaderFormat tells if this version uses the Long and Short Header defined in RFC 9000
+func (vn VersionNumber) UsesV1HeaderFormat() bool {
+	return vn == Version1
+}
+
+// UsesLegacyLongHeaderInvariants tells if the Long Header of this version encodes the lengths of both connection IDs in a single byte.
+// This is the case for the IETF draft versions and gQUIC 44.
+// All other versions, including unknown ones, use the version-independent properties defined in RFC 8999.
+func (vn VersionNumber) UsesLegacyLongHeaderInvariants() bool {
+	return vn == VersionTLS || vn == Version44
+}
+
+// UsesV1FrameFormat tells if this version uses the frame types defined in RFC 9000
+func (vn VersionNumber) UsesV1FrameFormat() bool {
+	return vn == Version1
+}
+
+// UsesCryptoFrames tells if this version sends handshake data in CRYPTO frames, instead of on the crypto stream
+func (vn VersionNumber) UsesCryptoFrames() bool {
+	return vn == Version1
+}
+
+// UsesPacketNumberSpaces tells if this version uses separate packet number spaces for Initial, Handshake and 1-RTT packets
+func (vn VersionNumber) UsesPacketNumberSpaces() bool {
+	return vn == Version1
+}
+
 // UsesLengthInHeader tells if this version uses the Length field in the IETF header
 func (vn VersionNumber) UsesLengthInHeader() bool {
 	return !vn.isGQUIC()
@@ -103,11 +138,14 @@ func (vn VersionNumber) UsesStopWaitingFrames() bool {
 
 // UsesVarintPacketNumbers tells if this version uses 7/14/30 bit packet numbers
 func (vn VersionNumber) UsesVarintPacketNumbers() bool {
-	return !vn.isGQUIC()
+	return !vn.isGQUIC() && !vn.UsesV1HeaderFormat()
 }
 
 // StreamContributesToConnectionFlowControl says if a stream contributes to connection-level flow control
 func (vn VersionNumber) StreamContributesToConnectionFlowControl(id StreamID) bool {
+	if vn.UsesCryptoFrames() {
+		return true
+	}
 	if id == vn.CryptoStreamID() {
 		return false
 	}
@@ -22,6 +22,7 @@ var _ = Describe("Version", func() {
 		Expect(IsValidVersion(Version43)).To(BeTrue())
 		Expect(IsValidVersion(Version44)).To(BeTrue())
 		Expect(IsValidVersion(VersionTLS)).To(BeTrue())
+		Expect(IsValidVersion(Version1)).To(BeTrue())
 		Expect(IsValidVersion(VersionWhatever)).To(BeFalse())
 		Expect(IsValidVersion(VersionUnknown)).To(BeFalse())
 		Expect(IsValidVersion(1234)).To(BeFalse())
@@ -32,6 +33,7 @@ var _ = Describe("Version", func() {
 		Expect(Version43.UsesTLS()).To(BeFalse())
 		Expect(Version44.UsesTLS()).To(BeFalse())
 		Expect(VersionTLS.UsesTLS()).To(BeTrue())
+		Expect(Version1.UsesTLS()).To(BeTrue())
 	})
 
 	It("versions don't have reserved version numbers", func() {
@@ -39,11 +41,13 @@ var _ = Describe("Version", func() {
 		Expect(isReservedVersion(Version43)).To(BeFalse())
 		Expect(isReservedVersion(Version44)).To(BeFalse())
 		Expect(isReservedVersion(VersionTLS)).To(BeFalse())
+		Expect(isReservedVersion(Version1)).To(BeFalse())
 	})
 
 	It("has the right string representation", func() {
 		Expect(Version39.String()).To(Equal("gQUIC 39"))
 		Expect(VersionTLS.String()).To(ContainSubstring("TLS"))
+		Expect(Version1.String()).To(Equal("QUIC v1"))
 		Expect(VersionWhatever.String()).To(Equal("whatever"))
 		Expect(VersionUnknown.String()).To(Equal("unknown"))
 		// check with unsupported version numbers from the wiki
@@ -70,6 +74,7 @@ var _ = Describe("Version", func() {
 		Expect(Version43.CryptoStreamID()).To(Equal(StreamID(1)))
 		Expect(Version44.CryptoStreamID()).To(Equal(StreamID(1)))
 		Expect(VersionTLS.CryptoStreamID()).To(Equal(StreamID(0)))
+		Expect(Version1.CryptoStreamID()).To(Equal(InvalidStreamID))
 	})
 
 	It("tells if a version uses the IETF frame types", func() {
@@ -77,6 +82,32 @@ var _ = Describe("Version", func() {
 		Expect(Version43.UsesIETFFrameFormat()).To(BeFalse())
 		Expect(Version44.UsesIETFFrameFormat()).To(BeFalse())
 		Expect(VersionTLS.UsesIETFFrameFormat()).To(BeTrue())
+		Expect(Version1.UsesIETFFrameFormat()).To(BeTrue())
+	})
+
+	It("tells if a version uses the RFC 9000 header and frame format", func() {
+		for _, v := range []VersionNumber{Version39, Version43, Version44, VersionTLS} {
+			Expect(v.UsesV1HeaderFormat()).To(BeFalse())
+			Expect(v.UsesV1FrameFormat()).To(BeFalse())
+		}
+		Expect(Version1.UsesV1HeaderFormat()).To(BeTrue())
+		Expect(Version1.UsesV1FrameFormat()).To(BeTrue())
+	})
+
+	It("tells if a version uses the legacy Long Header invariants", func() {
+		Expect(Version44.UsesLegacyLongHeaderInvariants()).To(BeTrue())
+		Expect(VersionTLS.UsesLegacyLongHeaderInvariants()).To(BeTrue())
+		Expect(Version1.UsesLegacyLongHeaderInvariants()).To(BeFalse())
+		Expect(VersionNumber(0x1234567).UsesLegacyLongHeaderInvariants()).To(BeFalse())
+	})
+
+	It("tells if a version uses CRYPTO frames and packet number spaces", func() {
+		for _, v := range []VersionNumber{Version39, Version43, Version44, VersionTLS} {
+			Expect(v.UsesCryptoFrames()).To(BeFalse())
+			Expect(v.UsesPacketNumberSpaces()).To(BeFalse())
+		}
+		Expect(Version1.UsesCryptoFrames()).To(BeTrue())
+		Expect(Version1.UsesPacketNumberSpaces()).To(BeTrue())
 	})
 
 	It("tells if a version uses the IETF header format", func() {
@@ -84,6 +115,7 @@ var _ = Describe("Version", func() {
 		Expect(Version43.UsesIETFHeaderFormat()).To(BeFalse())
 		Expect(Version44.UsesIETFHeaderFormat()).To(BeTrue())
 		Expect(VersionTLS.UsesIETFHeaderFormat()).To(BeTrue())
+		Expect(Version1.UsesIETFHeaderFormat()).To(BeTrue())
 	})
 
 	It("tells if a version uses varint packet numbers", func() {
@@ -91,6 +123,7 @@ var _ = Describe("Version", func() {
 		Expect(Version43.UsesVarintPacketNumbers()).To(BeFalse())
 		Expect(Version44.UsesVarintPacketNumbers()).To(BeFalse())
 		Expect(VersionTLS.UsesVarintPacketNumbers()).To(BeTrue())
+		Expect(Version1.UsesVarintPacketNumbers()).To(BeFalse())
 	})
 
 	It("tells if a version uses the Length field in the IETF header", func() {
@@ -128,6 +161,11 @@ var _ = Describe("Version", func() {
 		Expect(VersionTLS.StreamContributesToConnectionFlowControl(3)).To(BeTrue())
 	})
 
+	It("says if a stream contributes to connection-level flowcontrol, for QUIC v1", func() {
+		Expect(Version1.StreamContributesToConnectionFlowControl(0)).To(BeTrue())
+		Expect(Version1.StreamContributesToConnectionFlowControl(1)).To(BeTrue())
+	})
+
 	It("recognizes supported versions", func() {
 		Expect(IsSupportedVersion(SupportedVersions, 0)).To(BeFalse())
 		Expect(IsSupportedVersion(SupportedVersions, SupportedVersions[0])).To(BeTrue())
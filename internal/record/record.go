@@ -0,0 +1,576 @@
+// Package record implements the format used to record QUIC sessions, such that they can be replayed.
+//
+// A recording is a sequence of records. Every record belongs to a session, and has a timestamp.
+// It contains the datagrams sent and received by the session, and everything that the session chose randomly:
+// the connection IDs, the skipped packet numbers, and the output of the TLS stack.
+// Records of multiple sessions can be interleaved.
+//
+// Every record is encoded as a type byte, followed by the length of the rest of the record (as a QUIC varint),
+// the number of the session (varint), the time in nanoseconds since the Unix epoch (uint64), and the body of the record.
+// Readers skip records of unknown types.
+package record
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/wheelcomplex/qk/internal/protocol"
+	"github.com/wheelcomplex/qk/internal/utils"
+)
+
+type recordType uint8
+
+const (
+	typeSessionStarted recordType = 1 + iota
+	typeDatagram
+	typeSkippedPacketNumber
+	typeTLSInput
+	typeTLSEvent
+	typeTLSConnectionState
+)
+
+// maxRecordLen is the maximum length of a record.
+// It prevents allocating huge buffers when reading a corrupted recording.
+const maxRecordLen = 1 << 20
+
+// An Event is the content of a record
+type Event interface {
+	recordType() recordType
+	write(*bytes.Buffer)
+	parse(*bytes.Reader) error
+}
+
+// A Record is an entry of a recording
+type Record struct {
+	// Session is the number of the session that the record belongs to
+	Session uint64
+	Time    time.Time
+	Event   Event
+}
+
+// SessionStarted is the first record of every session
+type SessionStarted struct {
+	Perspective protocol.Perspective
+	Version     protocol.VersionNumber
+	// InitialConnID is the connection ID used to derive the Initial keys
+	InitialConnID protocol.ConnectionID
+	DestConnID    protocol.ConnectionID
+	SrcConnID     protocol.ConnectionID
+	// OrigDestConnID and RetrySrcConnID are the connection IDs that the client expects in the server's transport parameters.
+	// They are only set for the client.
+	OrigDestConnID protocol.ConnectionID
+	RetrySrcConnID protocol.ConnectionID
+	Token          []byte
+	LocalAddr      string
+	RemoteAddr     string
+}
+
+// A Datagram is a datagram sent or received by the session
+type Datagram struct {
+	Sent       bool
+	RemoteAddr string
+	Data       []byte
+}
+
+// A SkippedPacketNumber is a packet number that the session skipped
+type SkippedPacketNumber struct {
+	EncryptionLevel protocol.EncryptionLevel
+	PacketNumber    protocol.PacketNumber
+}
+
+// A TLSInput is a handshake message passed to the TLS stack, and the error the TLS stack returned when handling it
+type TLSInput struct {
+	Level int
+	Data  []byte
+	// Alert is the TLS alert, if the TLS stack returned an alert
+	Alert uint8
+	Error string
+}
+
+// A TLSEvent is an event generated by the TLS stack.
+// Kind and Level are the values of a tls.QUICEventKind and a tls.QUICEncryptionLevel.
+type TLSEvent struct {
+	Kind  int
+	Level int
+	Suite uint16
+	Data  []byte
+}
+
+// A TLSConnectionState is the state of the TLS connection at the end of the handshake
+type TLSConnectionState struct {
+	ServerName         string
+	NegotiatedProtocol string
+	// PeerCertificates are the DER-encoded certificates sent by the peer
+	PeerCertificates [][]byte
+}
+
+var (
+	_ Event = &SessionStarted{}
+	_ Event = &Datagram{}
+	_ Event = &SkippedPacketNumber{}
+	_ Event = &TLSInput{}
+	_ Event = &TLSEvent{}
+	_ Event = &TLSConnectionState{}
+)
+
+// writerLocks holds a lock for every io.Writer that Writers write to,
+// such that records of different sessions don't get mixed up when they share an io.Writer.
+// The lock is removed once all Writers using the io.Writer are closed.
+var writerLocks = struct {
+	sync.Mutex
+	m map[io.Writer]*writerLock
+}{m: make(map[io.Writer]*writerLock)}
+
+type writerLock struct {
+	sync.Mutex
+	refs int
+}
+
+func acquireWriterLock(w io.Writer) *writerLock {
+	// Values of types that are not comparable can't be used as a map key.
+	// Since they can't be shared either, they don't need a shared lock.
+	if !reflect.TypeOf(w).Comparable() {
+		return &writerLock{}
+	}
+	writerLocks.Lock()
+	defer writerLocks.Unlock()
+	l, ok := writerLocks.m[w]
+	if !ok {
+		l = &writerLock{}
+		writerLocks.m[w] = l
+	}
+	l.refs++
+	return l
+}
+
+func releaseWriterLock(w io.Writer) {
+	if !reflect.TypeOf(w).Comparable() {
+		return
+	}
+	writerLocks.Lock()
+	defer writerLocks.Unlock()
+	l := writerLocks.m[w]
+	l.refs--
+	if l.refs == 0 {
+		delete(writerLocks.m, w)
+	}
+}
+
+// A Writer writes the records of a session
+type Writer struct {
+	w       io.Writer
+	lock    *writerLock
+	session uint64
+
+	mutex  sync.Mutex
+	closed bool
+	err    error
+}
+
+// NewWriter creates a Writer for a new session.
+// Every session is identified by a random 62 bit number (the maximum value of a varint),
+// which allows writing the recordings of different processes to the same file.
+// The Writer must be closed when the session ends.
+func NewWriter(w io.Writer) (*Writer, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	return &Writer{
+		w:       w,
+		lock:    acquireWriterLock(w),
+		session: binary.BigEndian.Uint64(b) & (1<<62 - 1),
+	}, nil
+}
+
+// Session returns the number of the session
+func (w *Writer) Session() uint64 {
+	return w.session
+}
+
+// Write writes a record.
+// Every record is written using a single Write call on the underlying io.Writer.
+// After the first error, no further records are written, and all calls return that error.
+// Records written after Close are dropped.
+func (w *Writer) Write(t time.Time, ev Event) error {
+	body := &bytes.Buffer{}
+	utils.WriteVarInt(body, w.session)
+	utils.BigEndian.WriteUint64(body, uint64(t.UnixNano()))
+	ev.write(body)
+	b := &bytes.Buffer{}
+	b.WriteByte(byte(ev.recordType()))
+	utils.WriteVarInt(b, uint64(body.Len()))
+	b.Write(body.Bytes())
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return nil
+	}
+	if w.err != nil {
+		return w.err
+	}
+	w.lock.Lock()
+	_, err := w.w.Write(b.Bytes())
+	w.lock.Unlock()
+	w.err = err
+	return err
+}
+
+// Close closes the Writer.
+// It doesn't close the underlying io.Writer.
+func (w *Writer) Close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	releaseWriterLock(w.w)
+	return nil
+}
+
+// A Reader reads records
+type Reader struct {
+	r *bufio.Reader
+}
+
+// NewReader creates a new Reader
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r)}
+}
+
+// Read reads the next record.
+// It returns io.EOF at the end of the recording, and io.ErrUnexpectedEOF if the last record is truncated.
+func (r *Reader) Read() (*Record, error) {
+	for {
+		t, err := r.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		l, err := utils.ReadVarInt(r.r)
+		if err != nil {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if l > maxRecordLen {
+			return nil, fmt.Errorf("record: record too long (%d bytes)", l)
+		}
+		data := make([]byte, l)
+		if _, err := io.ReadFull(r.r, data); err != nil {
+			return nil, io.ErrUnexpectedEOF
+		}
+		var ev Event
+		switch recordType(t) {
+		case typeSessionStarted:
+			ev = &SessionStarted{}
+		case typeDatagram:
+			ev = &Datagram{}
+		case typeSkippedPacketNumber:
+			ev = &SkippedPacketNumber{}
+		case typeTLSInput:
+			ev = &TLSInput{}
+		case typeTLSEvent:
+			ev = &TLSEvent{}
+		case typeTLSConnectionState:
+			ev = &TLSConnectionState{}
+		default:
+			continue
+		}
+		rec, err := parseRecord(data, ev)
+		if err != nil {
+			return nil, fmt.Errorf("record: error parsing record of type %d: %s", t, err)
+		}
+		return rec, nil
+	}
+}
+
+// ReadSession reads the records of the n-th session of a recording, counting from 0.
+// Sessions are counted in the order of their SessionStarted records.
+// The first record returned is the SessionStarted record.
+func ReadSession(r io.Reader, n int) ([]*Record, error) {
+	reader := NewReader(r)
+	var count int
+	var records []*Record
+	for {
+		rec, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if records == nil {
+			if _, ok := rec.Event.(*SessionStarted); !ok {
+				continue
+			}
+			if count < n {
+				count++
+				continue
+			}
+			records = []*Record{rec}
+			continue
+		}
+		if rec.Session == records[0].Session {
+			records = append(records, rec)
+		}
+	}
+	if records == nil {
+		return nil, fmt.Errorf("record: recording contains only %d sessions", count)
+	}
+	return records, nil
+}
+
+func parseRecord(data []byte, ev Event) (*Record, error) {
+	b := bytes.NewReader(data)
+	session, err := utils.ReadVarInt(b)
+	if err != nil {
+		return nil, err
+	}
+	t, err := utils.BigEndian.ReadUint64(b)
+	if err != nil {
+		return nil, err
+	}
+	if err := ev.parse(b); err != nil {
+		return nil, err
+	}
+	if b.Len() > 0 {
+		return nil, errors.New("unexpected data after the record")
+	}
+	return &Record{
+		Session: session,
+		Time:    time.Unix(0, int64(t)),
+		Event:   ev,
+	}, nil
+}
+
+func (*SessionStarted) recordType() recordType { return typeSessionStarted }
+
+func (e *SessionStarted) write(b *bytes.Buffer) {
+	b.WriteByte(byte(e.Perspective))
+	utils.BigEndian.WriteUint32(b, uint32(e.Version))
+	writeBytes(b, e.InitialConnID)
+	writeBytes(b, e.DestConnID)
+	writeBytes(b, e.SrcConnID)
+	writeBytes(b, e.OrigDestConnID)
+	writeBytes(b, e.RetrySrcConnID)
+	writeBytes(b, e.Token)
+	writeString(b, e.LocalAddr)
+	writeString(b, e.RemoteAddr)
+}
+
+func (e *SessionStarted) parse(b *bytes.Reader) error {
+	p, err := b.ReadByte()
+	if err != nil {
+		return err
+	}
+	e.Perspective = protocol.Perspective(p)
+	v, err := utils.BigEndian.ReadUint32(b)
+	if err != nil {
+		return err
+	}
+	e.Version = protocol.VersionNumber(v)
+	for _, connID := range []*protocol.ConnectionID{&e.InitialConnID, &e.DestConnID, &e.SrcConnID, &e.OrigDestConnID, &e.RetrySrcConnID} {
+		data, err := readBytes(b)
+		if err != nil {
+			return err
+		}
+		*connID = data
+	}
+	if e.Token, err = readBytes(b); err != nil {
+		return err
+	}
+	if e.LocalAddr, err = readString(b); err != nil {
+		return err
+	}
+	e.RemoteAddr, err = readString(b)
+	return err
+}
+
+func (*Datagram) recordType() recordType { return typeDatagram }
+
+func (e *Datagram) write(b *bytes.Buffer) {
+	if e.Sent {
+		b.WriteByte(1)
+	} else {
+		b.WriteByte(0)
+	}
+	writeString(b, e.RemoteAddr)
+	writeBytes(b, e.Data)
+}
+
+func (e *Datagram) parse(b *bytes.Reader) error {
+	sent, err := b.ReadByte()
+	if err != nil {
+		return err
+	}
+	e.Sent = sent == 1
+	if e.RemoteAddr, err = readString(b); err != nil {
+		return err
+	}
+	e.Data, err = readBytes(b)
+	return err
+}
+
+func (*SkippedPacketNumber) recordType() recordType { return typeSkippedPacketNumber }
+
+func (e *SkippedPacketNumber) write(b *bytes.Buffer) {
+	b.WriteByte(byte(e.EncryptionLevel))
+	utils.WriteVarInt(b, uint64(e.PacketNumber))
+}
+
+func (e *SkippedPacketNumber) parse(b *bytes.Reader) error {
+	encLevel, err := b.ReadByte()
+	if err != nil {
+		return err
+	}
+	e.EncryptionLevel = protocol.EncryptionLevel(encLevel)
+	pn, err := utils.ReadVarInt(b)
+	if err != nil {
+		return err
+	}
+	e.PacketNumber = protocol.PacketNumber(pn)
+	return nil
+}
+
+func (*TLSInput) recordType() recordType { return typeTLSInput }
+
+func (e *TLSInput) write(b *bytes.Buffer) {
+	b.WriteByte(byte(e.Level))
+	writeBytes(b, e.Data)
+	b.WriteByte(e.Alert)
+	writeString(b, e.Error)
+}
+
+func (e *TLSInput) parse(b *bytes.Reader) error {
+	level, err := b.ReadByte()
+	if err != nil {
+		return err
+	}
+	e.Level = int(level)
+	if e.Data, err = readBytes(b); err != nil {
+		return err
+	}
+	if e.Alert, err = b.ReadByte(); err != nil {
+		return err
+	}
+	e.Error, err = readString(b)
+	return err
+}
+
+func (*TLSEvent) recordType() recordType { return typeTLSEvent }
+
+func (e *TLSEvent) write(b *bytes.Buffer) {
+	utils.WriteVarInt(b, uint64(e.Kind))
+	b.WriteByte(byte(e.Level))
+	utils.BigEndian.WriteUint16(b, e.Suite)
+	writeBytes(b, e.Data)
+}
+
+func (e *TLSEvent) parse(b *bytes.Reader) error {
+	kind, err := utils.ReadVarInt(b)
+	if err != nil {
+		return err
+	}
+	e.Kind = int(kind)
+	level, err := b.ReadByte()
+	if err != nil {
+		return err
+	}
+	e.Level = int(level)
+	if e.Suite, err = utils.BigEndian.ReadUint16(b); err != nil {
+		return err
+	}
+	e.Data, err = readBytes(b)
+	return err
+}
+
+func (*TLSConnectionState) recordType() recordType { return typeTLSConnectionState }
+
+func (e *TLSConnectionState) write(b *bytes.Buffer) {
+	writeString(b, e.ServerName)
+	writeString(b, e.NegotiatedProtocol)
+	utils.WriteVarInt(b, uint64(len(e.PeerCertificates)))
+	for _, cert := range e.PeerCertificates {
+		writeBytes(b, cert)
+	}
+}
+
+func (e *TLSConnectionState) parse(b *bytes.Reader) error {
+	var err error
+	if e.ServerName, err = readString(b); err != nil {
+		return err
+	}
+	if e.NegotiatedProtocol, err = readString(b); err != nil {
+		return err
+	}
+	n, err := utils.ReadVarInt(b)
+	if err != nil {
+		return err
+	}
+	if n > uint64(b.Len()) {
+		return io.EOF
+	}
+	e.PeerCertificates = make([][]byte, 0, n)
+	for i := uint64(0); i < n; i++ {
+		cert, err := readBytes(b)
+		if err != nil {
+			return err
+		}
+		e.PeerCertificates = append(e.PeerCertificates, cert)
+	}
+	return nil
+}
+
+// writeBytes writes a byte slice.
+// The length is encoded as a varint, increased by one, such that nil slices (encoded as 0) can be distinguished from empty slices.
+func writeBytes(b *bytes.Buffer, data []byte) {
+	if data == nil {
+		utils.WriteVarInt(b, 0)
+		return
+	}
+	utils.WriteVarInt(b, uint64(len(data))+1)
+	b.Write(data)
+}
+
+func readBytes(b *bytes.Reader) ([]byte, error) {
+	l, err := utils.ReadVarInt(b)
+	if err != nil {
+		return nil, err
+	}
+	if l == 0 {
+		return nil, nil
+	}
+	l--
+	if l > uint64(b.Len()) {
+		return nil, io.EOF
+	}
+	data := make([]byte, l)
+	b.Read(data)
+	return data, nil
+}
+
+func writeString(b *bytes.Buffer, s string) {
+	utils.WriteVarInt(b, uint64(len(s)))
+	b.WriteString(s)
+}
+
+func readString(b *bytes.Reader) (string, error) {
+	l, err := utils.ReadVarInt(b)
+	if err != nil {
+		return "", err
+	}
+	if l > uint64(b.Len()) {
+		return "", io.EOF
+	}
+	data := make([]byte, l)
+	b.Read(data)
+	return string(data), nil
+}
@@ -0,0 +1,267 @@
+package record
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/wheelcomplex/qk/internal/protocol"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type errorWriter struct {
+	n int
+}
+
+func (w *errorWriter) Write(p []byte) (int, error) {
+	w.n++
+	return 0, errors.New("write failed")
+}
+
+// A blockingWriter blocks all writes until unblock is closed
+type blockingWriter struct {
+	unblock chan struct{}
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	<-w.unblock
+	return len(p), nil
+}
+
+var _ = Describe("Recording", func() {
+	now := time.Unix(1234, 5678)
+
+	events := []Event{
+		&SessionStarted{
+			Perspective:    protocol.PerspectiveClient,
+			Version:        protocol.Version1,
+			InitialConnID:  protocol.ConnectionID{1, 2, 3, 4, 5, 6, 7, 8},
+			DestConnID:     protocol.ConnectionID{1, 2, 3, 4, 5, 6, 7, 8},
+			SrcConnID:      protocol.ConnectionID{},
+			OrigDestConnID: protocol.ConnectionID{8, 7, 6, 5, 4, 3, 2, 1},
+			RetrySrcConnID: protocol.ConnectionID{1, 2, 3, 4, 5, 6, 7, 8},
+			Token:          []byte("token"),
+			LocalAddr:      "127.0.0.1:1234",
+			RemoteAddr:     "127.0.0.1:4321",
+		},
+		&Datagram{RemoteAddr: "127.0.0.1:4321", Data: []byte("foobar")},
+		&Datagram{Sent: true, RemoteAddr: "127.0.0.1:4321", Data: []byte("raboof")},
+		&SkippedPacketNumber{EncryptionLevel: protocol.EncryptionHandshake, PacketNumber: 0x1337},
+		&TLSInput{Level: 2, Data: []byte("message")},
+		&TLSInput{Level: 2, Data: []byte("message"), Alert: 42, Error: "bad certificate"},
+		&TLSEvent{Kind: 1, Level: 3, Suite: 0x1301, Data: []byte("secret")},
+		&TLSEvent{},
+		&TLSConnectionState{
+			ServerName:         "quic.clemente.io",
+			NegotiatedProtocol: "h3",
+			PeerCertificates:   [][]byte{[]byte("cert1"), []byte("cert2")},
+		},
+	}
+
+	It("writes and reads records", func() {
+		buf := &bytes.Buffer{}
+		w, err := NewWriter(buf)
+		Expect(err).ToNot(HaveOccurred())
+		for i, ev := range events {
+			Expect(w.Write(now.Add(time.Duration(i)*time.Millisecond), ev)).To(Succeed())
+		}
+		r := NewReader(buf)
+		for i, ev := range events {
+			rec, err := r.Read()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(rec.Session).To(Equal(w.Session()))
+			Expect(rec.Time).To(Equal(now.Add(time.Duration(i) * time.Millisecond)))
+			Expect(rec.Event).To(Equal(ev))
+		}
+		_, err = r.Read()
+		Expect(err).To(Equal(io.EOF))
+	})
+
+	It("distinguishes nil from empty slices", func() {
+		buf := &bytes.Buffer{}
+		w, err := NewWriter(buf)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(w.Write(now, &SessionStarted{SrcConnID: protocol.ConnectionID{}})).To(Succeed())
+		rec, err := NewReader(buf).Read()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(rec.Event.(*SessionStarted).SrcConnID).ToNot(BeNil())
+		Expect(rec.Event.(*SessionStarted).SrcConnID).To(BeEmpty())
+		Expect(rec.Event.(*SessionStarted).RetrySrcConnID).To(BeNil())
+	})
+
+	It("uses a different number for every session", func() {
+		w1, err := NewWriter(&bytes.Buffer{})
+		Expect(err).ToNot(HaveOccurred())
+		w2, err := NewWriter(&bytes.Buffer{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(w1.Session()).ToNot(Equal(w2.Session()))
+	})
+
+	It("stops writing after an error", func() {
+		ew := &errorWriter{}
+		w, err := NewWriter(ew)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(w.Write(now, &TLSEvent{})).To(MatchError("write failed"))
+		Expect(w.Write(now, &TLSEvent{})).To(MatchError("write failed"))
+		Expect(ew.n).To(Equal(1))
+	})
+
+	It("skips records of unknown types", func() {
+		buf := &bytes.Buffer{}
+		buf.Write([]byte{0x42, 3, 1, 2, 3})
+		w, err := NewWriter(buf)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(w.Write(now, &TLSEvent{Kind: 1})).To(Succeed())
+		rec, err := NewReader(buf).Read()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(rec.Event).To(Equal(&TLSEvent{Kind: 1}))
+	})
+
+	It("errors on truncated records", func() {
+		buf := &bytes.Buffer{}
+		w, err := NewWriter(buf)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(w.Write(now, &Datagram{Data: []byte("foobar")})).To(Succeed())
+		data := buf.Bytes()
+		for i := 1; i < len(data); i++ {
+			_, err := NewReader(bytes.NewReader(data[:i])).Read()
+			Expect(err).To(Equal(io.ErrUnexpectedEOF))
+		}
+	})
+
+	It("errors on invalid records", func() {
+		buf := &bytes.Buffer{}
+		w, err := NewWriter(buf)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(w.Write(now, &Datagram{Data: []byte("foobar")})).To(Succeed())
+		data := buf.Bytes()
+		data[len(data)-7] = 0x10 // claim that the datagram is longer than the record
+		_, err = NewReader(bytes.NewReader(data)).Read()
+		Expect(err).To(MatchError("record: error parsing record of type 2: EOF"))
+	})
+
+	It("doesn't mix up records written concurrently", func() {
+		buf := &bytes.Buffer{}
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+				w, err := NewWriter(buf)
+				Expect(err).ToNot(HaveOccurred())
+				defer w.Close()
+				for j := 0; j < 100; j++ {
+					Expect(w.Write(now, &Datagram{Data: bytes.Repeat([]byte{'a'}, j)})).To(Succeed())
+				}
+			}()
+		}
+		wg.Wait()
+		r := NewReader(buf)
+		counts := make(map[uint64]int)
+		for {
+			rec, err := r.Read()
+			if err == io.EOF {
+				break
+			}
+			Expect(err).ToNot(HaveOccurred())
+			Expect(rec.Event.(*Datagram).Data).To(HaveLen(counts[rec.Session]))
+			counts[rec.Session]++
+		}
+		Expect(counts).To(HaveLen(10))
+	})
+
+	It("doesn't block Writers that write to a different io.Writer", func() {
+		bw := &blockingWriter{unblock: make(chan struct{})}
+		w1, err := NewWriter(bw)
+		Expect(err).ToNot(HaveOccurred())
+		defer w1.Close()
+		done := make(chan struct{})
+		go func() {
+			defer GinkgoRecover()
+			Expect(w1.Write(now, &TLSEvent{})).To(Succeed())
+			close(done)
+		}()
+		Consistently(done).ShouldNot(BeClosed())
+		buf := &bytes.Buffer{}
+		w2, err := NewWriter(buf)
+		Expect(err).ToNot(HaveOccurred())
+		defer w2.Close()
+		Expect(w2.Write(now, &TLSEvent{})).To(Succeed())
+		Expect(buf.Len()).ToNot(BeZero())
+		close(bw.unblock)
+		Eventually(done).Should(BeClosed())
+	})
+
+	It("removes the lock of an io.Writer when all Writers are closed", func() {
+		buf := &bytes.Buffer{}
+		w1, err := NewWriter(buf)
+		Expect(err).ToNot(HaveOccurred())
+		w2, err := NewWriter(buf)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(w1.lock).To(BeIdenticalTo(w2.lock))
+		hasLock := func() bool {
+			writerLocks.Lock()
+			defer writerLocks.Unlock()
+			_, ok := writerLocks.m[buf]
+			return ok
+		}
+		Expect(w1.Close()).To(Succeed())
+		Expect(w1.Close()).To(Succeed())
+		Expect(hasLock()).To(BeTrue())
+		Expect(w2.Close()).To(Succeed())
+		Expect(hasLock()).To(BeFalse())
+	})
+
+	It("drops records written after Close", func() {
+		buf := &bytes.Buffer{}
+		w, err := NewWriter(buf)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(w.Close()).To(Succeed())
+		Expect(w.Write(now, &TLSEvent{})).To(Succeed())
+		Expect(buf.Len()).To(BeZero())
+	})
+
+	Context("reading sessions", func() {
+		var buf *bytes.Buffer
+
+		BeforeEach(func() {
+			buf = &bytes.Buffer{}
+			w1, err := NewWriter(buf)
+			Expect(err).ToNot(HaveOccurred())
+			w2, err := NewWriter(buf)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(w1.Write(now, &SessionStarted{Perspective: protocol.PerspectiveClient})).To(Succeed())
+			Expect(w1.Write(now, &Datagram{Data: []byte("foo")})).To(Succeed())
+			Expect(w2.Write(now, &SessionStarted{Perspective: protocol.PerspectiveServer})).To(Succeed())
+			Expect(w1.Write(now, &Datagram{Data: []byte("bar")})).To(Succeed())
+			Expect(w2.Write(now, &Datagram{Data: []byte("foobar")})).To(Succeed())
+		})
+
+		It("reads the first session", func() {
+			records, err := ReadSession(buf, 0)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(records).To(HaveLen(3))
+			Expect(records[0].Event).To(Equal(&SessionStarted{Perspective: protocol.PerspectiveClient}))
+			Expect(records[1].Event).To(Equal(&Datagram{Data: []byte("foo")}))
+			Expect(records[2].Event).To(Equal(&Datagram{Data: []byte("bar")}))
+		})
+
+		It("reads the second session", func() {
+			records, err := ReadSession(buf, 1)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(records).To(HaveLen(2))
+			Expect(records[0].Event).To(Equal(&SessionStarted{Perspective: protocol.PerspectiveServer}))
+			Expect(records[1].Event).To(Equal(&Datagram{Data: []byte("foobar")}))
+		})
+
+		It("errors if the session doesn't exist", func() {
+			_, err := ReadSession(buf, 2)
+			Expect(err).To(MatchError("record: recording contains only 2 sessions"))
+		})
+	})
+})
@@ -0,0 +1,36 @@
+package utils
+
+import "time"
+
+// A Clock provides the current time, and timers.
+// It allows running sessions in virtual time, see VirtualClock.
+type Clock interface {
+	Now() time.Time
+	// NewTimer creates a new timer that fires after the duration d
+	NewTimer(d time.Duration) ClockTimer
+}
+
+// A ClockTimer is a timer created by a Clock.
+// It behaves like a time.Timer.
+type ClockTimer interface {
+	Chan() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// DefaultClock is the Clock using the system time.
+var DefaultClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+func (systemClock) NewTimer(d time.Duration) ClockTimer {
+	return &systemTimer{time.NewTimer(d)}
+}
+
+type systemTimer struct {
+	*time.Timer
+}
+
+func (t *systemTimer) Chan() <-chan time.Time { return t.C }
@@ -0,0 +1,223 @@
+package utils
+
+import (
+	"runtime"
+	"sync"
+	"time"
+)
+
+// A VirtualClock is a Clock whose time only advances when Advance or AdvanceToNextTimer is called.
+// Timers fire in the order of their deadlines, while the time of the clock is set to the deadline of the timer.
+// Timers with the same deadline fire in the order they were set.
+type VirtualClock struct {
+	mutex  sync.Mutex
+	now    time.Time
+	timers []*virtualTimer // the active timers
+	seq    uint64
+	// activity is incremented every time the clock is used.
+	// It allows AutoAdvance to detect that all users of the clock are idle.
+	activity uint64
+}
+
+var _ Clock = &VirtualClock{}
+
+// NewVirtualClock creates a new VirtualClock, starting at the time start
+func NewVirtualClock(start time.Time) *VirtualClock {
+	return &VirtualClock{now: start}
+}
+
+// Now returns the current time of the clock
+func (c *VirtualClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.activity++
+	return c.now
+}
+
+// NewTimer creates a new timer.
+// Timers that are set to a time that is not in the future fire immediately.
+func (c *VirtualClock) NewTimer(d time.Duration) ClockTimer {
+	t := &virtualTimer{clock: c, c: make(chan time.Time, 1)}
+	t.Reset(d)
+	return t
+}
+
+// AfterFunc calls f after the duration d.
+// It returns a ClockTimer that can be used to cancel the call. The channel of this timer is nil.
+// Unlike time.AfterFunc, f is called by the goroutine advancing the clock, before the clock advances any further,
+// so f must not block. If d is not positive, f is called in its own goroutine.
+func (c *VirtualClock) AfterFunc(d time.Duration, f func()) ClockTimer {
+	t := &virtualTimer{clock: c, f: f}
+	t.Reset(d)
+	return t
+}
+
+// Advance advances the clock by the duration d, and fires all timers that expire in the meantime
+func (c *VirtualClock) Advance(d time.Duration) {
+	c.mutex.Lock()
+	target := c.now.Add(d)
+	c.mutex.Unlock()
+	for c.fireNextTimer(target) {
+	}
+	c.mutex.Lock()
+	if target.After(c.now) {
+		c.now = target
+	}
+	c.mutex.Unlock()
+}
+
+// AdvanceToNextTimer advances the clock to the deadline of the next timer, and fires it.
+// It returns false if no timer is active.
+func (c *VirtualClock) AdvanceToNextTimer() bool {
+	return c.fireNextTimer(time.Time{})
+}
+
+// fireNextTimer fires the timer with the earliest deadline, if this deadline is not after the limit.
+// If the limit is zero, the timer is fired independent of its deadline.
+func (c *VirtualClock) fireNextTimer(limit time.Time) bool {
+	c.mutex.Lock()
+	if len(c.timers) == 0 {
+		c.mutex.Unlock()
+		return false
+	}
+	next := 0
+	for i, t := range c.timers {
+		if t.deadline.Before(c.timers[next].deadline) ||
+			(t.deadline.Equal(c.timers[next].deadline) && t.seq < c.timers[next].seq) {
+			next = i
+		}
+	}
+	t := c.timers[next]
+	if !limit.IsZero() && t.deadline.After(limit) {
+		c.mutex.Unlock()
+		return false
+	}
+	c.timers = append(c.timers[:next], c.timers[next+1:]...)
+	t.active = false
+	if t.deadline.After(c.now) {
+		c.now = t.deadline
+	}
+	now := c.now
+	c.mutex.Unlock()
+
+	if t.f != nil {
+		t.f()
+	} else {
+		t.send(now)
+	}
+	return true
+}
+
+// AutoAdvance advances the clock to the deadline of the next timer whenever the clock wasn't used
+// for the (real) duration idle, i.e. when all goroutines using the clock are waiting for a timer.
+// This way, timeouts and round trip times don't depend on the speed of the machine.
+// It returns a function that stops advancing the clock.
+func (c *VirtualClock) AutoAdvance(idle time.Duration) (stop func()) {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		// Polling is used instead of a time.Ticker, since the resolution of the system timers is too coarse
+		// for the short idle times that are needed when many timers fire in short succession.
+		last := c.getActivity()
+		idleSince := time.Now()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			runtime.Gosched()
+			if a := c.getActivity(); a != last {
+				last = a
+				idleSince = time.Now()
+				continue
+			}
+			if time.Since(idleSince) < idle {
+				continue
+			}
+			c.AdvanceToNextTimer()
+			last = c.getActivity()
+			idleSince = time.Now()
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+func (c *VirtualClock) getActivity() uint64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.activity
+}
+
+type virtualTimer struct {
+	clock *VirtualClock
+
+	c chan time.Time
+	f func()
+
+	deadline time.Time
+	seq      uint64
+	active   bool
+}
+
+func (t *virtualTimer) Chan() <-chan time.Time { return t.c }
+
+func (t *virtualTimer) Stop() bool {
+	c := t.clock
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.activity++
+	return t.stop()
+}
+
+// stop removes the timer from the active timers.
+// It must be called with the mutex of the clock held.
+func (t *virtualTimer) stop() bool {
+	if !t.active {
+		return false
+	}
+	t.active = false
+	timers := t.clock.timers
+	for i, timer := range timers {
+		if timer == t {
+			t.clock.timers = append(timers[:i], timers[i+1:]...)
+			break
+		}
+	}
+	return true
+}
+
+func (t *virtualTimer) Reset(d time.Duration) bool {
+	c := t.clock
+	c.mutex.Lock()
+	c.activity++
+	wasActive := t.stop()
+	t.deadline = c.now.Add(d)
+	if d <= 0 {
+		now := c.now
+		c.mutex.Unlock()
+		if t.f != nil {
+			go t.f()
+		} else {
+			t.send(now)
+		}
+		return wasActive
+	}
+	c.seq++
+	t.seq = c.seq
+	t.active = true
+	c.timers = append(c.timers, t)
+	c.mutex.Unlock()
+	return wasActive
+}
+
+func (t *virtualTimer) send(now time.Time) {
+	select {
+	case t.c <- now:
+	default:
+	}
+}
@@ -0,0 +1,111 @@
+package utils
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Virtual Clock", func() {
+	var (
+		clock *VirtualClock
+		start time.Time
+	)
+
+	BeforeEach(func() {
+		start = time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+		clock = NewVirtualClock(start)
+	})
+
+	It("only advances when told to", func() {
+		Expect(clock.Now()).To(Equal(start))
+		time.Sleep(time.Millisecond)
+		Expect(clock.Now()).To(Equal(start))
+		clock.Advance(time.Hour)
+		Expect(clock.Now()).To(Equal(start.Add(time.Hour)))
+	})
+
+	It("fires timers", func() {
+		t := clock.NewTimer(time.Second)
+		clock.Advance(time.Second - 1)
+		Expect(t.Chan()).ToNot(Receive())
+		clock.Advance(1)
+		Expect(t.Chan()).To(Receive(Equal(start.Add(time.Second))))
+	})
+
+	It("fires timers in the order of their deadlines, at their deadlines", func() {
+		t1 := clock.NewTimer(2 * time.Second)
+		t2 := clock.NewTimer(time.Second)
+		var fired []time.Time
+		clock.AfterFunc(1500*time.Millisecond, func() {
+			fired = append(fired, clock.Now())
+			Expect(t2.Chan()).To(Receive(Equal(start.Add(time.Second))))
+			Expect(t1.Chan()).ToNot(Receive())
+		})
+		clock.Advance(time.Hour)
+		Expect(fired).To(Equal([]time.Time{start.Add(1500 * time.Millisecond)}))
+		Expect(t1.Chan()).To(Receive(Equal(start.Add(2 * time.Second))))
+		Expect(clock.Now()).To(Equal(start.Add(time.Hour)))
+	})
+
+	It("fires timers with the same deadline in the order they were set", func() {
+		var fired []int
+		for i := 0; i < 5; i++ {
+			i := i
+			clock.AfterFunc(time.Second, func() { fired = append(fired, i) })
+		}
+		clock.Advance(time.Second)
+		Expect(fired).To(Equal([]int{0, 1, 2, 3, 4}))
+	})
+
+	It("immediately fires timers that are not set in the future", func() {
+		t := clock.NewTimer(0)
+		Expect(t.Chan()).To(Receive(Equal(start)))
+		called := make(chan struct{})
+		clock.AfterFunc(-time.Second, func() { close(called) })
+		Eventually(called).Should(BeClosed())
+	})
+
+	It("stops timers", func() {
+		t := clock.NewTimer(time.Second)
+		Expect(t.Stop()).To(BeTrue())
+		Expect(t.Stop()).To(BeFalse())
+		clock.Advance(time.Hour)
+		Expect(t.Chan()).ToNot(Receive())
+	})
+
+	It("resets timers", func() {
+		t := clock.NewTimer(time.Second)
+		Expect(t.Reset(2 * time.Second)).To(BeTrue())
+		clock.Advance(time.Second)
+		Expect(t.Chan()).ToNot(Receive())
+		clock.Advance(time.Second)
+		Expect(t.Chan()).To(Receive())
+		Expect(t.Reset(time.Second)).To(BeFalse())
+		clock.Advance(time.Second)
+		Expect(t.Chan()).To(Receive(Equal(start.Add(3 * time.Second))))
+	})
+
+	It("advances to the next timer", func() {
+		Expect(clock.AdvanceToNextTimer()).To(BeFalse())
+		t := clock.NewTimer(time.Minute)
+		Expect(clock.AdvanceToNextTimer()).To(BeTrue())
+		Expect(clock.Now()).To(Equal(start.Add(time.Minute)))
+		Expect(t.Chan()).To(Receive())
+	})
+
+	It("automatically advances when idle", func() {
+		stop := clock.AutoAdvance(100 * time.Microsecond)
+		defer stop()
+		t := NewTimerWithClock(clock)
+		Eventually(t.Chan()).Should(Receive())
+		t.SetRead()
+		for i := 0; i < 100; i++ {
+			t.Reset(clock.Now().Add(time.Hour))
+			Eventually(t.Chan()).Should(Receive())
+			t.SetRead()
+		}
+		Expect(clock.Now()).To(Equal(start.Add(100 * time.Hour)))
+	})
+})
@@ -31,6 +31,9 @@ func (f *BlockedFrame) Write(b *bytes.Buffer, version protocol.VersionNumber) er
 		return (&blockedFrameLegacy{}).Write(b, version)
 	}
 	typeByte := uint8(0x08)
+	if version.UsesV1FrameFormat() {
+		typeByte = 0x14 // DATA_BLOCKED
+	}
 	b.WriteByte(typeByte)
 	utils.WriteVarInt(b, uint64(f.Offset))
 	return nil
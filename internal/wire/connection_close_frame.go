@@ -13,19 +13,47 @@ import (
 
 // A ConnectionCloseFrame in QUIC
 type ConnectionCloseFrame struct {
-	ErrorCode    qerr.ErrorCode
+	// IsApplicationError is only used in QUIC v1, which uses a different frame type for application errors.
+	IsApplicationError bool
+	// In QUIC v1, transport error codes are converted to and from the error codes defined in RFC 9000.
+	// Application error codes are sent unchanged.
+	ErrorCode qerr.ErrorCode
+	// FrameType is the type of the frame that triggered the error.
+	// It is only sent for transport errors in QUIC v1.
+	FrameType    uint64
 	ReasonPhrase string
 }
 
 // parseConnectionCloseFrame reads a CONNECTION_CLOSE frame
 func parseConnectionCloseFrame(r *bytes.Reader, version protocol.VersionNumber) (*ConnectionCloseFrame, error) {
-	if _, err := r.ReadByte(); err != nil { // read the TypeByte
+	typeByte, err := r.ReadByte()
+	if err != nil {
 		return nil, err
 	}
 
+	f := &ConnectionCloseFrame{}
 	var errorCode qerr.ErrorCode
 	var reasonPhraseLen uint64
-	if version.UsesIETFFrameFormat() {
+	if version.UsesV1FrameFormat() {
+		f.IsApplicationError = typeByte == 0x1d
+		ec, err := utils.ReadVarInt(r)
+		if err != nil {
+			return nil, err
+		}
+		if f.IsApplicationError {
+			errorCode = qerr.ErrorCode(ec)
+		} else {
+			errorCode = qerr.FromTransportErrorCodeV1(ec)
+			f.FrameType, err = utils.ReadVarInt(r)
+			if err != nil {
+				return nil, err
+			}
+		}
+		reasonPhraseLen, err = utils.ReadVarInt(r)
+		if err != nil {
+			return nil, err
+		}
+	} else if version.UsesIETFFrameFormat() {
 		ec, err := utils.BigEndian.ReadUint16(r)
 		if err != nil {
 			return nil, err
@@ -61,14 +89,20 @@ func parseConnectionCloseFrame(r *bytes.Reader, version protocol.VersionNumber)
 		return nil, err
 	}
 
-	return &ConnectionCloseFrame{
-		ErrorCode:    errorCode,
-		ReasonPhrase: string(reasonPhrase),
-	}, nil
+	f.ErrorCode = errorCode
+	f.ReasonPhrase = string(reasonPhrase)
+	return f, nil
 }
 
 // Length of a written frame
 func (f *ConnectionCloseFrame) Length(version protocol.VersionNumber) protocol.ByteCount {
+	if version.UsesV1FrameFormat() {
+		length := 1 + utils.VarIntLen(f.v1ErrorCode()) + utils.VarIntLen(uint64(len(f.ReasonPhrase))) + protocol.ByteCount(len(f.ReasonPhrase))
+		if !f.IsApplicationError {
+			length += utils.VarIntLen(f.FrameType)
+		}
+		return length
+	}
 	if version.UsesIETFFrameFormat() {
 		return 1 + 2 + utils.VarIntLen(uint64(len(f.ReasonPhrase))) + protocol.ByteCount(len(f.ReasonPhrase))
 	}
@@ -77,12 +111,26 @@ func (f *ConnectionCloseFrame) Length(version protocol.VersionNumber) protocol.B
 
 // Write writes an CONNECTION_CLOSE frame.
 func (f *ConnectionCloseFrame) Write(b *bytes.Buffer, version protocol.VersionNumber) error {
-	b.WriteByte(0x02)
-
 	if len(f.ReasonPhrase) > math.MaxUint16 {
 		return errors.New("ConnectionFrame: ReasonPhrase too long")
 	}
 
+	if version.UsesV1FrameFormat() {
+		if f.IsApplicationError {
+			b.WriteByte(0x1d)
+		} else {
+			b.WriteByte(0x1c)
+		}
+		utils.WriteVarInt(b, f.v1ErrorCode())
+		if !f.IsApplicationError {
+			utils.WriteVarInt(b, f.FrameType)
+		}
+		utils.WriteVarInt(b, uint64(len(f.ReasonPhrase)))
+		b.WriteString(f.ReasonPhrase)
+		return nil
+	}
+
+	b.WriteByte(0x02)
 	if version.UsesIETFFrameFormat() {
 		utils.BigEndian.WriteUint16(b, uint16(f.ErrorCode))
 		utils.WriteVarInt(b, uint64(len(f.ReasonPhrase)))
@@ -94,3 +142,10 @@ func (f *ConnectionCloseFrame) Write(b *bytes.Buffer, version protocol.VersionNu
 
 	return nil
 }
+
+func (f *ConnectionCloseFrame) v1ErrorCode() uint64 {
+	if f.IsApplicationError {
+		return uint64(f.ErrorCode)
+	}
+	return qerr.ToTransportErrorCodeV1(f.ErrorCode)
+}
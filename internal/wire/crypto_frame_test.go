@@ -0,0 +1,99 @@
+package wire
+
+import (
+	"bytes"
+
+	"github.com/wheelcomplex/qk/internal/protocol"
+	"github.com/wheelcomplex/qk/internal/utils"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CRYPTO frame", func() {
+	Context("parsing", func() {
+		It("parses", func() {
+			data := []byte{0x6}
+			data = append(data, encodeVarInt(0xdecafbad)...) // offset
+			data = append(data, encodeVarInt(6)...)          // length
+			data = append(data, []byte("foobar")...)
+			r := bytes.NewReader(data)
+			frame, err := parseCryptoFrame(r, versionV1Frames)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(frame.Offset).To(Equal(protocol.ByteCount(0xdecafbad)))
+			Expect(frame.Data).To(Equal([]byte("foobar")))
+			Expect(r.Len()).To(BeZero())
+		})
+
+		It("rejects frames that overflow the maximum offset", func() {
+			data := []byte{0x6}
+			data = append(data, encodeVarInt(uint64(protocol.MaxByteCount-5))...) // offset
+			data = append(data, encodeVarInt(6)...)                               // length
+			data = append(data, []byte("foobar")...)
+			_, err := parseCryptoFrame(bytes.NewReader(data), versionV1Frames)
+			Expect(err).To(MatchError("InvalidFrameData: data overflows maximum offset"))
+		})
+
+		It("errors on EOFs", func() {
+			data := []byte{0x6}
+			data = append(data, encodeVarInt(0xdecafbad)...) // offset
+			data = append(data, encodeVarInt(6)...)          // data length
+			data = append(data, []byte("foobar")...)
+			_, err := parseCryptoFrame(bytes.NewReader(data), versionV1Frames)
+			Expect(err).NotTo(HaveOccurred())
+			for i := range data {
+				_, err := parseCryptoFrame(bytes.NewReader(data[0:i]), versionV1Frames)
+				Expect(err).To(HaveOccurred())
+			}
+		})
+	})
+
+	Context("writing", func() {
+		It("writes a frame", func() {
+			f := &CryptoFrame{
+				Offset: 0x123456,
+				Data:   []byte("foobar"),
+			}
+			b := &bytes.Buffer{}
+			err := f.Write(b, versionV1Frames)
+			Expect(err).ToNot(HaveOccurred())
+			expected := []byte{0x6}
+			expected = append(expected, encodeVarInt(0x123456)...) // offset
+			expected = append(expected, encodeVarInt(6)...)        // length
+			expected = append(expected, []byte("foobar")...)
+			Expect(b.Bytes()).To(Equal(expected))
+			Expect(f.Length(versionV1Frames)).To(BeEquivalentTo(b.Len()))
+		})
+	})
+
+	Context("max data length", func() {
+		It("always returns a data length such that the resulting frame has the right size", func() {
+			const maxSize = 3000
+			data := make([]byte, maxSize)
+			f := &CryptoFrame{Offset: 0xdeadbeef}
+			b := &bytes.Buffer{}
+			for i := 1; i < 3000; i++ {
+				b.Reset()
+				f.Data = nil
+				maxDataLen := f.MaxDataLen(protocol.ByteCount(i))
+				if maxDataLen == 0 { // 0 means that no valid CRYTPO frame can be written
+					// check that writing a minimal size CRYPTO frame (i.e. with 1 byte data) is actually larger than the desired size
+					f.Data = []byte{0}
+					Expect(f.Write(b, versionV1Frames)).To(Succeed())
+					Expect(b.Len()).To(BeNumerically(">", i))
+					continue
+				}
+				f.Data = data[:int(maxDataLen)]
+				Expect(f.Write(b, versionV1Frames)).To(Succeed())
+				// There's *one* pathological case, where a data length of x can be encoded into 1 byte
+				// but a data lengths of x+1 needs 2 bytes
+				// In that case, it's impossible to create a CRYPTO frame of the desired size
+				if b.Len() == i-1 {
+					Expect(utils.VarIntLen(uint64(maxDataLen))).To(BeEquivalentTo(1))
+					continue
+				}
+				Expect(b.Len()).To(Equal(i))
+			}
+		})
+	})
+})
@@ -356,4 +356,107 @@ var _ = Describe("Frame parsing", func() {
 			}
 		})
 	})
+
+	Context("for QUIC v1 frames", func() {
+		It("unpacks all frame types", func() {
+			frames := []Frame{
+				&PingFrame{},
+				&AckFrame{AckRanges: []AckRange{{Smallest: 1, Largest: 0x13}}},
+				&RstStreamFrame{StreamID: 0xdeadbeef, ByteOffset: 0xdecafbad1234, ErrorCode: 0x1337},
+				&StopSendingFrame{StreamID: 0x42, ErrorCode: 0x1337},
+				&CryptoFrame{Offset: 0x1337, Data: []byte("foobar")},
+				&NewTokenFrame{Token: []byte("foobar")},
+				&StreamFrame{StreamID: 0x42, Offset: 0x1337, FinBit: true, DataLenPresent: true, Data: []byte("foobar")},
+				&MaxDataFrame{ByteOffset: 0xcafe},
+				&MaxStreamDataFrame{StreamID: 0xdeadbeef, ByteOffset: 0xdecafbad},
+				&MaxStreamsFrame{Type: protocol.StreamTypeBidi, MaxStreams: 0x1337},
+				&MaxStreamsFrame{Type: protocol.StreamTypeUni, MaxStreams: 0x1337},
+				&BlockedFrame{Offset: 0x1234},
+				&StreamBlockedFrame{StreamID: 0xdeadbeef, Offset: 0xdead},
+				&StreamsBlockedFrame{Type: protocol.StreamTypeBidi, StreamLimit: 0x1234567},
+				&StreamsBlockedFrame{Type: protocol.StreamTypeUni, StreamLimit: 0x1234567},
+				&NewConnectionIDFrame{SequenceNumber: 0x1337, ConnectionID: protocol.ConnectionID{1, 2, 3, 4, 5, 6, 7, 8}},
+				&RetireConnectionIDFrame{SequenceNumber: 0x1337},
+				&PathChallengeFrame{Data: [8]byte{1, 2, 3, 4, 5, 6, 7, 8}},
+				&PathResponseFrame{Data: [8]byte{1, 2, 3, 4, 5, 6, 7, 8}},
+				&ConnectionCloseFrame{ErrorCode: qerr.FlowControlReceivedTooMuchData, FrameType: 0x10, ReasonPhrase: "foo"},
+				&ConnectionCloseFrame{IsApplicationError: true, ErrorCode: 0x1337, ReasonPhrase: "bar"},
+				&HandshakeDoneFrame{},
+			}
+			for _, f := range frames {
+				buf := &bytes.Buffer{}
+				Expect(f.Write(buf, versionV1Frames)).To(Succeed())
+				Expect(f.Length(versionV1Frames)).To(BeEquivalentTo(buf.Len()))
+				r := bytes.NewReader(buf.Bytes())
+				frame, err := ParseNextFrame(r, nil, versionV1Frames)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(r.Len()).To(BeZero())
+				if ack, ok := f.(*AckFrame); ok {
+					Expect(frame.(*AckFrame).AckRanges).To(Equal(ack.AckRanges))
+					continue
+				}
+				Expect(frame).To(Equal(f))
+			}
+		})
+
+		It("uses the frame types defined in RFC 9000", func() {
+			for f, t := range map[Frame]byte{
+				&PingFrame{}:                                    0x01,
+				&RstStreamFrame{}:                               0x04,
+				&StopSendingFrame{}:                             0x05,
+				&StreamFrame{Data: []byte("f")}:                 0x08,
+				&MaxDataFrame{}:                                 0x10,
+				&MaxStreamDataFrame{}:                           0x11,
+				&BlockedFrame{}:                                 0x14,
+				&StreamBlockedFrame{}:                           0x15,
+				&PathChallengeFrame{}:                           0x1a,
+				&PathResponseFrame{}:                            0x1b,
+				&ConnectionCloseFrame{}:                         0x1c,
+				&ConnectionCloseFrame{IsApplicationError: true}: 0x1d,
+			} {
+				buf := &bytes.Buffer{}
+				Expect(f.Write(buf, versionV1Frames)).To(Succeed())
+				Expect(buf.Bytes()[0]).To(Equal(t))
+			}
+		})
+
+		It("converts transport error codes", func() {
+			f := &ConnectionCloseFrame{ErrorCode: qerr.InvalidStreamID}
+			buf := &bytes.Buffer{}
+			Expect(f.Write(buf, versionV1Frames)).To(Succeed())
+			Expect(buf.Bytes()[1]).To(BeEquivalentTo(0x5)) // STREAM_STATE_ERROR
+		})
+
+		It("errors on invalid type", func() {
+			_, err := ParseNextFrame(bytes.NewReader([]byte{0x42}), nil, versionV1Frames)
+			Expect(err).To(MatchError("InvalidFrameData: unknown type byte 0x42"))
+		})
+
+		It("errors on invalid frames", func() {
+			for b, e := range map[byte]qerr.ErrorCode{
+				0x02: qerr.InvalidAckData,
+				0x03: qerr.InvalidAckData,
+				0x04: qerr.InvalidRstStreamData,
+				0x05: qerr.InvalidFrameData,
+				0x06: qerr.InvalidFrameData,
+				0x07: qerr.InvalidFrameData,
+				0x08: qerr.InvalidStreamData,
+				0x10: qerr.InvalidWindowUpdateData,
+				0x11: qerr.InvalidWindowUpdateData,
+				0x12: qerr.InvalidFrameData,
+				0x14: qerr.InvalidBlockedData,
+				0x15: qerr.InvalidBlockedData,
+				0x16: qerr.InvalidFrameData,
+				0x18: qerr.InvalidFrameData,
+				0x19: qerr.InvalidFrameData,
+				0x1a: qerr.InvalidFrameData,
+				0x1b: qerr.InvalidFrameData,
+				0x1c: qerr.InvalidConnectionCloseData,
+			} {
+				_, err := ParseNextFrame(bytes.NewReader([]byte{b}), nil, versionV1Frames)
+				Expect(err).To(HaveOccurred())
+				Expect(err.(*qerr.QuicError).ErrorCode).To(Equal(e))
+			}
+		})
+	})
 })
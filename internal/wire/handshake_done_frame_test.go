@@ -0,0 +1,25 @@
+package wire
+
+import (
+	"bytes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("HANDSHAKE_DONE frame", func() {
+	It("parses", func() {
+		b := bytes.NewReader([]byte{0x1e})
+		_, err := parseHandshakeDoneFrame(b, versionV1Frames)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(b.Len()).To(BeZero())
+	})
+
+	It("writes", func() {
+		b := &bytes.Buffer{}
+		frame := HandshakeDoneFrame{}
+		Expect(frame.Write(b, versionV1Frames)).To(Succeed())
+		Expect(b.Bytes()).To(Equal([]byte{0x1e}))
+		Expect(frame.Length(versionV1Frames)).To(BeEquivalentTo(1))
+	})
+})
@@ -37,9 +37,10 @@ type Header struct {
 	// only needed for the IETF Header
 	Type         protocol.PacketType
 	IsLongHeader bool
-	KeyPhase     int
-	PayloadLen   protocol.ByteCount
-	Token        []byte
+	KeyPhase     int // the key phase bit of the Short Header, either 0 or 1
+	// For QUIC v1, the length includes the packet number.
+	PayloadLen protocol.ByteCount
+	Token      []byte
 }
 
 var errInvalidPacketNumberLen = errors.New("invalid packet number length")
@@ -50,6 +51,12 @@ func (h *Header) Write(b *bytes.Buffer, pers protocol.Perspective, ver protocol.
 		h.IsPublicHeader = true // save that this is a Public Header, so we can log it correctly later
 		return h.writePublicHeader(b, pers, ver)
 	}
+	if ver.UsesV1HeaderFormat() {
+		if h.IsLongHeader {
+			return h.writeLongHeaderV1(b)
+		}
+		return h.writeShortHeaderV1(b)
+	}
 	// write an IETF QUIC header
 	if h.IsLongHeader {
 		return h.writeLongHeader(b, ver)
@@ -57,7 +64,6 @@ func (h *Header) Write(b *bytes.Buffer, pers protocol.Perspective, ver protocol.
 	return h.writeShortHeader(b, ver)
 }
 
-// TODO: add support for the key phase
 func (h *Header) writeLongHeader(b *bytes.Buffer, v protocol.VersionNumber) error {
 	b.WriteByte(byte(0x80 | h.Type))
 	utils.BigEndian.WriteUint32(b, uint32(h.Version))
@@ -106,6 +112,9 @@ func (h *Header) writeLongHeader(b *bytes.Buffer, v protocol.VersionNumber) erro
 }
 
 func (h *Header) writeShortHeader(b *bytes.Buffer, v protocol.VersionNumber) error {
+	if h.KeyPhase != 0 && h.KeyPhase != 1 {
+		return fmt.Errorf("invalid key phase: %d", h.KeyPhase)
+	}
 	typeByte := byte(0x30)
 	typeByte |= byte(h.KeyPhase << 6)
 	if !v.UsesVarintPacketNumbers() {
@@ -203,6 +212,9 @@ func (h *Header) GetLength(v protocol.VersionNumber) (protocol.ByteCount, error)
 	if !v.UsesIETFHeaderFormat() {
 		return h.getPublicHeaderLength()
 	}
+	if v.UsesV1HeaderFormat() {
+		return h.getHeaderLengthV1()
+	}
 	return h.getHeaderLength(v)
 }
 
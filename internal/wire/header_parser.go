@@ -18,6 +18,8 @@ type InvariantHeader struct {
 	DestConnectionID protocol.ConnectionID
 
 	typeByte byte
+	// set for Long Headers that use the version-independent properties defined in RFC 8999
+	usesRFC8999 bool
 }
 
 // ParseInvariantHeader parses the version independent part of the header
@@ -36,11 +38,13 @@ func ParseInvariantHeader(b *bytes.Reader, shortHeaderConnIDLen int) (*Invariant
 		// In the IETF Short Header:
 		// * 0x8 it is the gQUIC Demultiplexing bit, and always 0.
 		// * 0x20 and 0x10 are always 1.
+		// The QUIC v1 Short Header sets 0x40.
+		// In the Public Header, this bit is always 0.
 		var connIDLen int
 		if typeByte&0x8 > 0 { // Public Header containing a connection ID
 			connIDLen = 8
 		}
-		if typeByte&0x38 == 0x30 { // Short Header
+		if typeByte&0x38 == 0x30 || typeByte&0x40 > 0 { // Short Header
 			connIDLen = shortHeaderConnIDLen
 		}
 		if connIDLen > 0 {
@@ -57,6 +61,17 @@ func ParseInvariantHeader(b *bytes.Reader, shortHeaderConnIDLen int) (*Invariant
 		return nil, err
 	}
 	h.Version = protocol.VersionNumber(v)
+	if h.Version == 0 {
+		h.usesRFC8999 = isRFC8999VersionNegotiationPacket(b)
+	} else {
+		h.usesRFC8999 = !h.Version.UsesLegacyLongHeaderInvariants()
+	}
+	if h.usesRFC8999 {
+		if err := h.parseRFC8999ConnectionIDs(b); err != nil {
+			return nil, err
+		}
+		return h, nil
+	}
 	connIDLenByte, err := b.ReadByte()
 	if err != nil {
 		return nil, err
@@ -79,8 +94,19 @@ func (iv *InvariantHeader) Parse(b *bytes.Reader, sentBy protocol.Perspective, v
 		if iv.Version == 0 { // Version Negotiation Packet
 			return iv.parseVersionNegotiationPacket(b)
 		}
+		if iv.usesRFC8999 {
+			if !iv.Version.UsesV1HeaderFormat() {
+				// We don't know how to parse the rest of the header.
+				// This is enough information to send a Version Negotiation Packet.
+				return iv.toHeader(), nil
+			}
+			return iv.parseLongHeaderV1(b)
+		}
 		return iv.parseLongHeader(b, sentBy, ver)
 	}
+	if ver.UsesV1HeaderFormat() {
+		return iv.parseShortHeaderV1(b)
+	}
 	// The Public Header never uses 6 byte packet numbers.
 	// Therefore, the third and fourth bit will never be 11.
 	// For the Short Header, the third and fourth bit are always 11.
@@ -76,7 +76,7 @@ var _ = Describe("Header Parsing", func() {
 				srcConnID := protocol.ConnectionID{0xde, 0xad, 0xbe, 0xef}
 				data := []byte{
 					0x80 ^ uint8(protocol.PacketTypeInitial),
-					0x1, 0x2, 0x3, 0x4, // version number
+					0x0, 0x0, 0x0, 0x65, // version number (VersionTLS)
 					0x61, // connection ID lengths
 				}
 				data = append(data, destConnID...)
@@ -103,7 +103,7 @@ var _ = Describe("Header Parsing", func() {
 				Expect(hdr.PayloadLen).To(Equal(protocol.ByteCount(0x1337)))
 				Expect(hdr.PacketNumber).To(Equal(protocol.PacketNumber(0xbeef)))
 				Expect(hdr.PacketNumberLen).To(Equal(protocol.PacketNumberLen4))
-				Expect(hdr.Version).To(Equal(protocol.VersionNumber(0x1020304)))
+				Expect(hdr.Version).To(Equal(protocol.VersionTLS))
 				Expect(hdr.IsVersionNegotiation).To(BeFalse())
 				Expect(b.Len()).To(BeZero())
 			})
@@ -111,7 +111,7 @@ var _ = Describe("Header Parsing", func() {
 			It("parses a Long Header without a destination connection ID", func() {
 				data := []byte{
 					0x80 ^ uint8(protocol.PacketTypeInitial),
-					0x1, 0x2, 0x3, 0x4, // version number
+					0x0, 0x0, 0x0, 0x65, // version number (VersionTLS)
 					0x01,                   // connection ID lengths
 					0xde, 0xad, 0xbe, 0xef, // source connection ID
 				}
@@ -127,7 +127,7 @@ var _ = Describe("Header Parsing", func() {
 			It("parses a Long Header without a source connection ID", func() {
 				data := []byte{
 					0x80 ^ uint8(protocol.PacketTypeInitial),
-					0x1, 0x2, 0x3, 0x4, // version number
+					0x0, 0x0, 0x0, 0x65, // version number (VersionTLS)
 					0x70,                          // connection ID lengths
 					1, 2, 3, 4, 5, 6, 7, 8, 9, 10, // source connection ID
 				}
@@ -143,7 +143,7 @@ var _ = Describe("Header Parsing", func() {
 			It("parses a Long Header with a 2 byte packet number", func() {
 				data := []byte{
 					0x80 ^ uint8(protocol.PacketTypeInitial),
-					0x1, 0x2, 0x3, 0x4, // version number
+					0x0, 0x0, 0x0, 0x65, // version number (VersionTLS)
 					0x0, // connection ID lengths
 				}
 				data = append(data, encodeVarInt(0)...)    // token length
@@ -162,7 +162,7 @@ var _ = Describe("Header Parsing", func() {
 			It("parses a Retry packet", func() {
 				data := []byte{
 					0x80 ^ uint8(protocol.PacketTypeRetry),
-					0x1, 0x2, 0x3, 0x4, // version number
+					0x0, 0x0, 0x0, 0x65, // version number (VersionTLS)
 					0x0,                           // connection ID lengths
 					0x97,                          // Orig Destination Connection ID length
 					1, 2, 3, 4, 5, 6, 7, 8, 9, 10, // source connection ID
@@ -185,7 +185,7 @@ var _ = Describe("Header Parsing", func() {
 					IsLongHeader:    true,
 					Type:            42,
 					SrcConnectionID: srcConnID,
-					Version:         0x10203040,
+					Version:         protocol.VersionTLS,
 					PacketNumber:    1,
 					PacketNumberLen: protocol.PacketNumberLen1,
 				}).Write(buf, protocol.PerspectiveClient, protocol.VersionTLS)
@@ -200,7 +200,7 @@ var _ = Describe("Header Parsing", func() {
 			It("errors if the token length is too large", func() {
 				data := []byte{
 					0x80 ^ uint8(protocol.PacketTypeInitial),
-					0x1, 0x2, 0x3, 0x4, // version number
+					0x0, 0x0, 0x0, 0x65, // version number (VersionTLS)
 					0x0, // connection ID lengths
 				}
 				data = append(data, encodeVarInt(4)...)                           // token length: 4 bytes (1 byte too long)
@@ -217,7 +217,7 @@ var _ = Describe("Header Parsing", func() {
 			It("errors on EOF, when parsing the invariant header", func() {
 				data := []byte{
 					0x80 ^ uint8(protocol.PacketTypeInitial),
-					0x1, 0x2, 0x3, 0x4, // version number
+					0x0, 0x0, 0x0, 0x65, // version number (VersionTLS)
 					0x55,                                           // connection ID lengths
 					0xde, 0xad, 0xbe, 0xef, 0xca, 0xfe, 0x13, 0x37, // destination connection ID
 					0xde, 0xad, 0xbe, 0xef, 0xca, 0xfe, 0x13, 0x37, // source connection ID
@@ -231,7 +231,7 @@ var _ = Describe("Header Parsing", func() {
 			It("errors on EOF, when parsing the header", func() {
 				data := []byte{
 					0x80 ^ uint8(protocol.PacketTypeInitial),
-					0x1, 0x2, 0x3, 0x4, // version number
+					0x0, 0x0, 0x0, 0x65, // version number (VersionTLS)
 					0x0, // connection ID lengths
 				}
 				iHdrLen := len(data)
@@ -249,7 +249,7 @@ var _ = Describe("Header Parsing", func() {
 			It("errors on EOF, for a Retry packet", func() {
 				data := []byte{
 					0x80 ^ uint8(protocol.PacketTypeRetry),
-					0x1, 0x2, 0x3, 0x4, // version number
+					0x0, 0x0, 0x0, 0x65, // version number (VersionTLS)
 					0x0, // connection ID lengths
 				}
 				iHdrLen := len(data)
@@ -390,7 +390,7 @@ var _ = Describe("Header Parsing", func() {
 				srcConnID := protocol.ConnectionID{8, 7, 6, 5, 4, 3, 2, 1}
 				data := []byte{
 					0x80 ^ uint8(protocol.PacketTypeInitial),
-					0x1, 0x2, 0x3, 0x4, // version
+					'Q', '0', '4', '4', // version
 					0x55, // connection ID lengths
 				}
 				data = append(data, destConnID...)
@@ -400,7 +400,7 @@ var _ = Describe("Header Parsing", func() {
 				iHdr, err := ParseInvariantHeader(b, 0)
 				Expect(err).ToNot(HaveOccurred())
 				Expect(iHdr.IsLongHeader).To(BeTrue())
-				Expect(iHdr.Version).To(Equal(protocol.VersionNumber(0x1020304)))
+				Expect(iHdr.Version).To(Equal(protocol.Version44))
 				Expect(iHdr.DestConnectionID).To(Equal(destConnID))
 				Expect(iHdr.SrcConnectionID).To(Equal(srcConnID))
 				hdr, err := iHdr.Parse(b, protocol.PerspectiveServer, protocol.Version44)
@@ -416,7 +416,7 @@ var _ = Describe("Header Parsing", func() {
 				divNonce := bytes.Repeat([]byte{'f'}, 32)
 				data := []byte{
 					0x80 ^ uint8(protocol.PacketType0RTT),
-					0x1, 0x2, 0x3, 0x4, // version
+					'Q', '0', '4', '4', // version
 					0x5, // connection ID lengths
 				}
 				data = append(data, srcConnID...)
@@ -426,7 +426,7 @@ var _ = Describe("Header Parsing", func() {
 				iHdr, err := ParseInvariantHeader(b, 0)
 				Expect(err).ToNot(HaveOccurred())
 				Expect(iHdr.IsLongHeader).To(BeTrue())
-				Expect(iHdr.Version).To(Equal(protocol.VersionNumber(0x1020304)))
+				Expect(iHdr.Version).To(Equal(protocol.Version44))
 				Expect(iHdr.SrcConnectionID).To(Equal(srcConnID))
 				hdr, err := iHdr.Parse(b, protocol.PerspectiveServer, protocol.Version44)
 				Expect(err).ToNot(HaveOccurred())
@@ -440,7 +440,7 @@ var _ = Describe("Header Parsing", func() {
 			It("errors on EOF, for Long Headers containing a Diversification Nonce", func() {
 				data := []byte{
 					0x80 ^ uint8(protocol.PacketType0RTT),
-					0x1, 0x2, 0x3, 0x4, // version
+					'Q', '0', '4', '4', // version
 					0x5,
 					0xde, 0xad, 0xbe, 0xef, 0xca, 0xfe, 0x13, 0x37, // connection ID
 				}
@@ -214,6 +214,15 @@ var _ = Describe("Header", func() {
 						0x42, // packet number
 					}))
 				})
+
+				It("errors when given an invalid Key Phase", func() {
+					err := (&Header{
+						KeyPhase:        2,
+						PacketNumberLen: protocol.PacketNumberLen1,
+						PacketNumber:    0x42,
+					}).Write(buf, protocol.PerspectiveClient, versionIETFHeader)
+					Expect(err).To(MatchError("invalid key phase: 2"))
+				})
 			})
 		})
 
@@ -0,0 +1,251 @@
+package wire
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/wheelcomplex/qk/internal/protocol"
+	"github.com/wheelcomplex/qk/internal/utils"
+	"github.com/wheelcomplex/qk/qerr"
+)
+
+// RetryIntegrityTagLen is the length of the Retry Integrity Tag, see RFC 9001, section 5.8
+const RetryIntegrityTagLen = 16
+
+// maxConnectionIDLenV1 is the maximum length of a connection ID in QUIC v1
+const maxConnectionIDLenV1 = 20
+
+// the 2 bit packet type of the QUIC v1 Long Header
+const (
+	longHeaderTypeInitialV1   = 0x0
+	longHeaderType0RTTV1      = 0x1
+	longHeaderTypeHandshakeV1 = 0x2
+	longHeaderTypeRetryV1     = 0x3
+)
+
+func (iv *InvariantHeader) parseRFC8999ConnectionIDs(b *bytes.Reader) error {
+	dcil, err := b.ReadByte()
+	if err != nil {
+		return err
+	}
+	iv.DestConnectionID, err = protocol.ReadConnectionID(b, int(dcil))
+	if err != nil {
+		return err
+	}
+	scil, err := b.ReadByte()
+	if err != nil {
+		return err
+	}
+	iv.SrcConnectionID, err = protocol.ReadConnectionID(b, int(scil))
+	return err
+}
+
+// isRFC8999VersionNegotiationPacket determines the format of a Version Negotiation Packet.
+// The Version Negotiation Packet doesn't contain any version, so the format can only be guessed from its contents.
+// It is assumed to use the format defined in RFC 8999 if it can be parsed as such,
+// and the connection IDs have a length that is valid in QUIC v1.
+// The reader is reset to its original position.
+func isRFC8999VersionNegotiationPacket(b *bytes.Reader) bool {
+	startLen := b.Len()
+	defer func() { b.Seek(int64(b.Len()-startLen), io.SeekCurrent) }()
+
+	for i := 0; i < 2; i++ {
+		l, err := b.ReadByte()
+		if err != nil || l > maxConnectionIDLenV1 || int(l) > b.Len() {
+			return false
+		}
+		b.Seek(int64(l), io.SeekCurrent)
+	}
+	return b.Len() > 0 && b.Len()%4 == 0
+}
+
+func (iv *InvariantHeader) parseLongHeaderV1(b *bytes.Reader) (*Header, error) {
+	h := iv.toHeader()
+	if iv.typeByte&0x40 == 0 {
+		return nil, qerr.Error(qerr.InvalidPacketHeader, "fixed bit not set")
+	}
+	if iv.DestConnectionID.Len() > maxConnectionIDLenV1 || iv.SrcConnectionID.Len() > maxConnectionIDLenV1 {
+		return nil, qerr.Error(qerr.InvalidPacketHeader, "connection ID too long")
+	}
+	switch (iv.typeByte & 0x30) >> 4 {
+	case longHeaderTypeInitialV1:
+		h.Type = protocol.PacketTypeInitial
+	case longHeaderType0RTTV1:
+		h.Type = protocol.PacketType0RTT
+	case longHeaderTypeHandshakeV1:
+		h.Type = protocol.PacketTypeHandshake
+	case longHeaderTypeRetryV1:
+		h.Type = protocol.PacketTypeRetry
+	}
+
+	if h.Type == protocol.PacketTypeRetry {
+		// the Retry Token takes up the rest of the packet, except for the Retry Integrity Tag
+		tokenLen := b.Len() - RetryIntegrityTagLen
+		if tokenLen <= 0 {
+			return nil, io.EOF
+		}
+		h.Token = make([]byte, tokenLen)
+		if _, err := io.ReadFull(b, h.Token); err != nil {
+			return nil, err
+		}
+		// read the Retry Integrity Tag
+		// It is validated by the client, using the raw packet.
+		b.Seek(RetryIntegrityTagLen, io.SeekCurrent)
+		return h, nil
+	}
+
+	if h.Type == protocol.PacketTypeInitial {
+		tokenLen, err := utils.ReadVarInt(b)
+		if err != nil {
+			return nil, err
+		}
+		if tokenLen > uint64(b.Len()) {
+			return nil, io.EOF
+		}
+		h.Token = make([]byte, tokenLen)
+		if _, err := io.ReadFull(b, h.Token); err != nil {
+			return nil, err
+		}
+	}
+	// The Length field includes the packet number.
+	// The packet number (and its length) are protected, and are read after removing header protection.
+	pl, err := utils.ReadVarInt(b)
+	if err != nil {
+		return nil, err
+	}
+	h.PayloadLen = protocol.ByteCount(pl)
+	return h, nil
+}
+
+func (iv *InvariantHeader) parseShortHeaderV1(b *bytes.Reader) (*Header, error) {
+	if iv.typeByte&0x40 == 0 {
+		return nil, qerr.Error(qerr.InvalidPacketHeader, "fixed bit not set")
+	}
+	// The packet number and the key phase bit are protected.
+	// They are read after removing header protection.
+	return iv.toHeader(), nil
+}
+
+func (h *Header) writeLongHeaderV1(b *bytes.Buffer) error {
+	var packetType byte
+	switch h.Type {
+	case protocol.PacketTypeInitial:
+		packetType = longHeaderTypeInitialV1
+	case protocol.PacketType0RTT:
+		packetType = longHeaderType0RTTV1
+	case protocol.PacketTypeHandshake:
+		packetType = longHeaderTypeHandshakeV1
+	case protocol.PacketTypeRetry:
+		packetType = longHeaderTypeRetryV1
+	default:
+		return fmt.Errorf("invalid packet type: %s", h.Type)
+	}
+	if h.DestConnectionID.Len() > maxConnectionIDLenV1 || h.SrcConnectionID.Len() > maxConnectionIDLenV1 {
+		return errors.New("connection ID too long")
+	}
+	typeByte := 0xc0 | packetType<<4
+	if h.Type == protocol.PacketTypeRetry {
+		// the lower 4 bits are unused, and set to a random value
+		r := make([]byte, 1)
+		_, _ = rand.Read(r) // it's safe to ignore the error here
+		typeByte |= r[0] & 0xf
+	} else {
+		pnLenBits, err := encodePacketNumberLenV1(h.PacketNumberLen)
+		if err != nil {
+			return err
+		}
+		typeByte |= pnLenBits
+	}
+	b.WriteByte(typeByte)
+	utils.BigEndian.WriteUint32(b, uint32(h.Version))
+	b.WriteByte(uint8(h.DestConnectionID.Len()))
+	b.Write(h.DestConnectionID.Bytes())
+	b.WriteByte(uint8(h.SrcConnectionID.Len()))
+	b.Write(h.SrcConnectionID.Bytes())
+
+	switch h.Type {
+	case protocol.PacketTypeRetry:
+		// The Retry Integrity Tag is appended by the caller.
+		b.Write(h.Token)
+		return nil
+	case protocol.PacketTypeInitial:
+		utils.WriteVarInt(b, uint64(len(h.Token)))
+		b.Write(h.Token)
+	}
+	// the Length field includes the packet number
+	utils.WriteVarInt(b, uint64(h.PayloadLen))
+	return writePacketNumberV1(b, h.PacketNumber, h.PacketNumberLen)
+}
+
+func (h *Header) writeShortHeaderV1(b *bytes.Buffer) error {
+	if h.KeyPhase != 0 && h.KeyPhase != 1 {
+		return fmt.Errorf("invalid key phase: %d", h.KeyPhase)
+	}
+	pnLenBits, err := encodePacketNumberLenV1(h.PacketNumberLen)
+	if err != nil {
+		return err
+	}
+	b.WriteByte(0x40 | byte(h.KeyPhase<<2) | pnLenBits)
+	b.Write(h.DestConnectionID.Bytes())
+	return writePacketNumberV1(b, h.PacketNumber, h.PacketNumberLen)
+}
+
+func (h *Header) getHeaderLengthV1() (protocol.ByteCount, error) {
+	if _, err := encodePacketNumberLenV1(h.PacketNumberLen); err != nil {
+		return 0, err
+	}
+	if !h.IsLongHeader {
+		return 1 /* type byte */ + protocol.ByteCount(h.DestConnectionID.Len()) + protocol.ByteCount(h.PacketNumberLen), nil
+	}
+	length := 1 /* type byte */ + 4 /* version */ + 1 /* dest conn ID len */ + protocol.ByteCount(h.DestConnectionID.Len()) + 1 /* src conn ID len */ + protocol.ByteCount(h.SrcConnectionID.Len())
+	if h.Type == protocol.PacketTypeInitial {
+		length += utils.VarIntLen(uint64(len(h.Token))) + protocol.ByteCount(len(h.Token))
+	}
+	length += utils.VarIntLen(uint64(h.PayloadLen)) + protocol.ByteCount(h.PacketNumberLen)
+	return length, nil
+}
+
+func encodePacketNumberLenV1(pnLen protocol.PacketNumberLen) (byte, error) {
+	if pnLen < protocol.PacketNumberLen1 || pnLen > protocol.PacketNumberLen4 {
+		return 0, errInvalidPacketNumberLen
+	}
+	return byte(pnLen - 1), nil
+}
+
+func writePacketNumberV1(b *bytes.Buffer, pn protocol.PacketNumber, pnLen protocol.PacketNumberLen) error {
+	switch pnLen {
+	case protocol.PacketNumberLen1:
+		b.WriteByte(uint8(pn))
+	case protocol.PacketNumberLen2:
+		utils.BigEndian.WriteUint16(b, uint16(pn))
+	case protocol.PacketNumberLen3:
+		utils.BigEndian.WriteUint24(b, uint32(pn))
+	case protocol.PacketNumberLen4:
+		utils.BigEndian.WriteUint32(b, uint32(pn))
+	default:
+		return errInvalidPacketNumberLen
+	}
+	return nil
+}
+
+// ComposeVersionNegotiationV1 composes a Version Negotiation Packet, as defined in RFC 8999
+func ComposeVersionNegotiationV1(destConnID, srcConnID protocol.ConnectionID, versions []protocol.VersionNumber) []byte {
+	greasedVersions := protocol.GetGreasedVersions(versions)
+	expectedLen := 1 /* type byte */ + 4 /* version field */ + 1 + destConnID.Len() + 1 + srcConnID.Len() + len(greasedVersions)*4
+	buf := bytes.NewBuffer(make([]byte, 0, expectedLen))
+	r := make([]byte, 1)
+	_, _ = rand.Read(r) // ignore the error here. It is not critical to have perfect random here.
+	buf.WriteByte(r[0] | 0x80)
+	utils.BigEndian.WriteUint32(buf, 0) // version 0
+	buf.WriteByte(uint8(destConnID.Len()))
+	buf.Write(destConnID)
+	buf.WriteByte(uint8(srcConnID.Len()))
+	buf.Write(srcConnID)
+	for _, v := range greasedVersions {
+		utils.BigEndian.WriteUint32(buf, uint32(v))
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,275 @@
+package wire
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/wheelcomplex/qk/internal/protocol"
+	"github.com/wheelcomplex/qk/qerr"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("QUIC v1 Header", func() {
+	const versionV1 = protocol.Version1
+
+	parse := func(data []byte, shortHeaderConnIDLen int) (*Header, *bytes.Reader, error) {
+		b := bytes.NewReader(data)
+		iHdr, err := ParseInvariantHeader(b, shortHeaderConnIDLen)
+		if err != nil {
+			return nil, nil, err
+		}
+		hdr, err := iHdr.Parse(b, protocol.PerspectiveClient, versionV1)
+		return hdr, b, err
+	}
+
+	Context("parsing", func() {
+		It("parses an Initial packet", func() {
+			data := []byte{
+				0xc0 | 0x3,         // Initial, 4 byte packet number
+				0x0, 0x0, 0x0, 0x1, // version
+				0x4, 0xde, 0xad, 0xbe, 0xef, // destination connection ID
+				0x2, 0x13, 0x37, // source connection ID
+			}
+			data = append(data, encodeVarInt(6)...) // token length
+			data = append(data, []byte("foobar")...)
+			data = append(data, encodeVarInt(0x123)...) // length
+			data = append(data, []byte{0x1, 0x2, 0x3, 0x4}...)
+			hdr, b, err := parse(data, 0)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(hdr.IsLongHeader).To(BeTrue())
+			Expect(hdr.Type).To(Equal(protocol.PacketTypeInitial))
+			Expect(hdr.Version).To(Equal(versionV1))
+			Expect(hdr.DestConnectionID).To(Equal(protocol.ConnectionID{0xde, 0xad, 0xbe, 0xef}))
+			Expect(hdr.SrcConnectionID).To(Equal(protocol.ConnectionID{0x13, 0x37}))
+			Expect(hdr.Token).To(Equal([]byte("foobar")))
+			Expect(hdr.PayloadLen).To(Equal(protocol.ByteCount(0x123)))
+			// the packet number is header protected, and not parsed
+			Expect(b.Len()).To(Equal(4))
+		})
+
+		It("parses a Handshake packet with 20 byte connection IDs", func() {
+			connID := protocol.ConnectionID(bytes.Repeat([]byte{0x42}, 20))
+			data := []byte{0xe0, 0x0, 0x0, 0x0, 0x1}
+			data = append(data, 20)
+			data = append(data, connID...)
+			data = append(data, 0)
+			data = append(data, encodeVarInt(1000)...)
+			hdr, b, err := parse(data, 0)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(hdr.Type).To(Equal(protocol.PacketTypeHandshake))
+			Expect(hdr.DestConnectionID).To(Equal(connID))
+			Expect(hdr.SrcConnectionID).To(BeEmpty())
+			Expect(hdr.Token).To(BeEmpty())
+			Expect(hdr.PayloadLen).To(Equal(protocol.ByteCount(1000)))
+			Expect(b.Len()).To(BeZero())
+		})
+
+		It("parses a Retry packet", func() {
+			data := []byte{0xf5, 0x0, 0x0, 0x0, 0x1, 0x0, 0x4, 1, 2, 3, 4}
+			data = append(data, []byte("token")...)
+			data = append(data, bytes.Repeat([]byte{'t'}, RetryIntegrityTagLen)...)
+			hdr, b, err := parse(data, 0)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(hdr.Type).To(Equal(protocol.PacketTypeRetry))
+			Expect(hdr.SrcConnectionID).To(Equal(protocol.ConnectionID{1, 2, 3, 4}))
+			Expect(hdr.Token).To(Equal([]byte("token")))
+			Expect(b.Len()).To(BeZero())
+		})
+
+		It("errors on Retry packets that are too short", func() {
+			data := []byte{0xf5, 0x0, 0x0, 0x0, 0x1, 0x0, 0x4, 1, 2, 3, 4}
+			data = append(data, bytes.Repeat([]byte{'t'}, RetryIntegrityTagLen)...)
+			_, _, err := parse(data, 0)
+			Expect(err).To(MatchError(io.EOF))
+		})
+
+		It("errors if the fixed bit is not set", func() {
+			data := []byte{0x80, 0x0, 0x0, 0x0, 0x1, 0x0, 0x0}
+			data = append(data, encodeVarInt(0)...)
+			data = append(data, encodeVarInt(10)...)
+			_, _, err := parse(data, 0)
+			Expect(err).To(MatchError(qerr.Error(qerr.InvalidPacketHeader, "fixed bit not set")))
+		})
+
+		It("errors if the connection ID is too long", func() {
+			data := []byte{0xe0, 0x0, 0x0, 0x0, 0x1, 21}
+			data = append(data, make([]byte, 21)...)
+			data = append(data, 0)
+			data = append(data, encodeVarInt(10)...)
+			_, _, err := parse(data, 0)
+			Expect(err).To(MatchError(qerr.Error(qerr.InvalidPacketHeader, "connection ID too long")))
+		})
+
+		It("errors on EOF", func() {
+			data := []byte{0xc0, 0x0, 0x0, 0x0, 0x1, 0x2, 0x1, 0x2, 0x1, 0x3}
+			data = append(data, encodeVarInt(3)...)
+			data = append(data, []byte("foo")...)
+			data = append(data, encodeVarInt(1337)...)
+			for i := 0; i < len(data); i++ {
+				_, _, err := parse(data[:i], 0)
+				Expect(err).To(Equal(io.EOF))
+			}
+		})
+
+		It("parses a Short Header", func() {
+			data := []byte{0x40 | 0x4 | 0x1, 0xde, 0xad, 0xbe, 0xef, 0x13, 0x37}
+			hdr, b, err := parse(data, 4)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(hdr.IsLongHeader).To(BeFalse())
+			Expect(hdr.DestConnectionID).To(Equal(protocol.ConnectionID{0xde, 0xad, 0xbe, 0xef}))
+			// the packet number and the key phase are header protected, and not parsed
+			Expect(b.Len()).To(Equal(2))
+		})
+
+		It("errors on Short Headers without the fixed bit", func() {
+			_, _, err := parse([]byte{0x0, 0xde, 0xad, 0xbe, 0xef, 0x13}, 4)
+			Expect(err).To(MatchError(qerr.Error(qerr.InvalidPacketHeader, "fixed bit not set")))
+		})
+
+		It("parses the invariant header of unknown versions", func() {
+			data := []byte{0xff, 0x13, 0x37, 0x42, 0x42, 0x3, 1, 2, 3, 0x5, 5, 4, 3, 2, 1}
+			data = append(data, []byte("unknown content")...)
+			b := bytes.NewReader(data)
+			iHdr, err := ParseInvariantHeader(b, 0)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(iHdr.Version).To(Equal(protocol.VersionNumber(0x13374242)))
+			Expect(iHdr.DestConnectionID).To(Equal(protocol.ConnectionID{1, 2, 3}))
+			Expect(iHdr.SrcConnectionID).To(Equal(protocol.ConnectionID{5, 4, 3, 2, 1}))
+			hdr, err := iHdr.Parse(b, protocol.PerspectiveClient, versionV1)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(hdr.Version).To(Equal(protocol.VersionNumber(0x13374242)))
+			Expect(hdr.Type).To(BeZero())
+		})
+	})
+
+	Context("Version Negotiation Packets", func() {
+		It("writes and parses", func() {
+			destConnID := protocol.ConnectionID{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20}
+			srcConnID := protocol.ConnectionID{0xde, 0xca, 0xfb, 0xad}
+			versions := []protocol.VersionNumber{versionV1, 0x22334455}
+			data := ComposeVersionNegotiationV1(destConnID, srcConnID, versions)
+			Expect(data[0] & 0x80).ToNot(BeZero())
+			hdr, _, err := parse(data, 0)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(hdr.IsVersionNegotiation).To(BeTrue())
+			Expect(hdr.DestConnectionID).To(Equal(destConnID))
+			Expect(hdr.SrcConnectionID).To(Equal(srcConnID))
+			// the supported versions should include one reserved version number
+			Expect(hdr.SupportedVersions).To(HaveLen(len(versions) + 1))
+			for _, v := range versions {
+				Expect(hdr.SupportedVersions).To(ContainElement(v))
+			}
+		})
+
+		It("parses Version Negotiation Packets sent by draft versions", func() {
+			connID := protocol.ConnectionID{1, 2, 3, 4, 5, 6, 7, 8}
+			data, err := ComposeVersionNegotiation(connID, connID, []protocol.VersionNumber{protocol.VersionTLS})
+			Expect(err).ToNot(HaveOccurred())
+			hdr, _, err := parse(data, 0)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(hdr.IsVersionNegotiation).To(BeTrue())
+			Expect(hdr.DestConnectionID).To(Equal(connID))
+			Expect(hdr.SrcConnectionID).To(Equal(connID))
+			Expect(hdr.SupportedVersions).To(ContainElement(protocol.VersionTLS))
+		})
+	})
+
+	Context("writing", func() {
+		var buf *bytes.Buffer
+
+		BeforeEach(func() {
+			buf = &bytes.Buffer{}
+		})
+
+		It("writes an Initial packet", func() {
+			hdr := &Header{
+				IsLongHeader:     true,
+				Type:             protocol.PacketTypeInitial,
+				Version:          versionV1,
+				DestConnectionID: protocol.ConnectionID{0xde, 0xad, 0xbe, 0xef},
+				SrcConnectionID:  protocol.ConnectionID{0x13, 0x37},
+				Token:            []byte("foobar"),
+				PayloadLen:       0x1337,
+				PacketNumber:     0xbeef,
+				PacketNumberLen:  protocol.PacketNumberLen2,
+			}
+			Expect(hdr.Write(buf, protocol.PerspectiveClient, versionV1)).To(Succeed())
+			expected := []byte{0xc1, 0x0, 0x0, 0x0, 0x1, 0x4, 0xde, 0xad, 0xbe, 0xef, 0x2, 0x13, 0x37, 0x6}
+			expected = append(expected, []byte("foobar")...)
+			expected = append(expected, encodeVarInt(0x1337)...)
+			expected = append(expected, []byte{0xbe, 0xef}...)
+			Expect(buf.Bytes()).To(Equal(expected))
+			Expect(hdr.GetLength(versionV1)).To(BeEquivalentTo(len(expected)))
+		})
+
+		It("writes a Handshake packet, and parses it", func() {
+			hdr := &Header{
+				IsLongHeader:     true,
+				Type:             protocol.PacketTypeHandshake,
+				Version:          versionV1,
+				DestConnectionID: protocol.ConnectionID{1, 2, 3, 4, 5, 6, 7, 8},
+				SrcConnectionID:  protocol.ConnectionID{8, 7, 6, 5, 4, 3, 2, 1},
+				PayloadLen:       100,
+				PacketNumber:     0x123456,
+				PacketNumberLen:  protocol.PacketNumberLen3,
+			}
+			Expect(hdr.Write(buf, protocol.PerspectiveServer, versionV1)).To(Succeed())
+			Expect(buf.Bytes()[0]).To(Equal(byte(0xe2)))
+			Expect(hdr.GetLength(versionV1)).To(BeEquivalentTo(buf.Len()))
+			parsed, b, err := parse(buf.Bytes(), 0)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(parsed.Type).To(Equal(protocol.PacketTypeHandshake))
+			Expect(parsed.DestConnectionID).To(Equal(hdr.DestConnectionID))
+			Expect(parsed.SrcConnectionID).To(Equal(hdr.SrcConnectionID))
+			Expect(parsed.PayloadLen).To(Equal(hdr.PayloadLen))
+			Expect(b.Len()).To(Equal(3))
+		})
+
+		It("writes a Retry packet", func() {
+			hdr := &Header{
+				IsLongHeader:     true,
+				Type:             protocol.PacketTypeRetry,
+				Version:          versionV1,
+				DestConnectionID: protocol.ConnectionID{1, 2, 3, 4},
+				SrcConnectionID:  protocol.ConnectionID{4, 3, 2, 1},
+				Token:            []byte("token"),
+			}
+			Expect(hdr.Write(buf, protocol.PerspectiveServer, versionV1)).To(Succeed())
+			Expect(buf.Bytes()[0] & 0xf0).To(Equal(byte(0xf0)))
+			Expect(buf.Bytes()[1:]).To(Equal([]byte{0x0, 0x0, 0x0, 0x1, 0x4, 1, 2, 3, 4, 0x4, 4, 3, 2, 1, 't', 'o', 'k', 'e', 'n'}))
+		})
+
+		It("refuses to write connection IDs longer than 20 bytes", func() {
+			err := (&Header{
+				IsLongHeader:     true,
+				Type:             protocol.PacketTypeHandshake,
+				Version:          versionV1,
+				DestConnectionID: make(protocol.ConnectionID, 21),
+				PacketNumberLen:  protocol.PacketNumberLen1,
+			}).Write(buf, protocol.PerspectiveServer, versionV1)
+			Expect(err).To(MatchError("connection ID too long"))
+		})
+
+		It("writes a Short Header", func() {
+			hdr := &Header{
+				DestConnectionID: protocol.ConnectionID{0xde, 0xad, 0xbe, 0xef},
+				KeyPhase:         1,
+				PacketNumber:     0xdecafbad,
+				PacketNumberLen:  protocol.PacketNumberLen4,
+			}
+			Expect(hdr.Write(buf, protocol.PerspectiveServer, versionV1)).To(Succeed())
+			Expect(buf.Bytes()).To(Equal([]byte{0x47, 0xde, 0xad, 0xbe, 0xef, 0xde, 0xca, 0xfb, 0xad}))
+			Expect(hdr.GetLength(versionV1)).To(BeEquivalentTo(buf.Len()))
+		})
+
+		It("refuses to write invalid packet number lengths", func() {
+			err := (&Header{
+				DestConnectionID: protocol.ConnectionID{0xde, 0xad, 0xbe, 0xef},
+				PacketNumberLen:  protocol.PacketNumberLen6,
+			}).Write(buf, protocol.PerspectiveServer, versionV1)
+			Expect(err).To(MatchError(errInvalidPacketNumberLen))
+		})
+	})
+})
@@ -56,6 +56,15 @@ var _ = Describe("Frame logging", func() {
 		Expect(buf.Bytes()).To(ContainSubstring("\t<- &wire.StreamFrame{StreamID: 42, FinBit: false, Offset: 0x1337, Data length: 0x100, Offset + Data length: 0x1437}\n"))
 	})
 
+	It("logs CRYPTO frames", func() {
+		frame := &CryptoFrame{
+			Offset: 0x42,
+			Data:   bytes.Repeat([]byte{'f'}, 0x100),
+		}
+		LogFrame(logger, frame, true)
+		Expect(buf.Bytes()).To(ContainSubstring("\t-> &wire.CryptoFrame{Offset: 0x42, Data length: 0x100, Offset + Data length: 0x142}\n"))
+	})
+
 	It("logs ACK frames without missing packets", func() {
 		frame := &AckFrame{
 			AckRanges: []AckRange{{Smallest: 0x42, Largest: 0x1337}},
@@ -37,7 +37,11 @@ func (f *MaxDataFrame) Write(b *bytes.Buffer, version protocol.VersionNumber) er
 			ByteOffset: f.ByteOffset,
 		}).Write(b, version)
 	}
-	b.WriteByte(0x4)
+	typeByte := uint8(0x4)
+	if version.UsesV1FrameFormat() {
+		typeByte = 0x10
+	}
+	b.WriteByte(typeByte)
 	utils.WriteVarInt(b, uint64(f.ByteOffset))
 	return nil
 }
@@ -44,7 +44,11 @@ func (f *MaxStreamDataFrame) Write(b *bytes.Buffer, version protocol.VersionNumb
 			ByteOffset: f.ByteOffset,
 		}).Write(b, version)
 	}
-	b.WriteByte(0x5)
+	typeByte := uint8(0x5)
+	if version.UsesV1FrameFormat() {
+		typeByte = 0x11
+	}
+	b.WriteByte(typeByte)
 	utils.WriteVarInt(b, uint64(f.StreamID))
 	utils.WriteVarInt(b, uint64(f.ByteOffset))
 	return nil
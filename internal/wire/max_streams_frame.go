@@ -0,0 +1,54 @@
+package wire
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/wheelcomplex/qk/internal/protocol"
+	"github.com/wheelcomplex/qk/internal/utils"
+)
+
+// maxStreamCount is the maximum number of streams of one type that a peer can open, see RFC 9000, section 4.6
+const maxStreamCount = 1 << 60
+
+// A MaxStreamsFrame is a MAX_STREAMS frame.
+// It replaces the MAX_STREAM_ID frame in QUIC v1.
+type MaxStreamsFrame struct {
+	Type       protocol.StreamType
+	MaxStreams uint64
+}
+
+func parseMaxStreamsFrame(r *bytes.Reader, _ protocol.VersionNumber) (*MaxStreamsFrame, error) {
+	typeByte, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	f := &MaxStreamsFrame{Type: protocol.StreamTypeBidi}
+	if typeByte == 0x13 {
+		f.Type = protocol.StreamTypeUni
+	}
+	f.MaxStreams, err = utils.ReadVarInt(r)
+	if err != nil {
+		return nil, err
+	}
+	if f.MaxStreams > maxStreamCount {
+		return nil, fmt.Errorf("%d exceeds the maximum stream count", f.MaxStreams)
+	}
+	return f, nil
+}
+
+func (f *MaxStreamsFrame) Write(b *bytes.Buffer, _ protocol.VersionNumber) error {
+	switch f.Type {
+	case protocol.StreamTypeBidi:
+		b.WriteByte(0x12)
+	case protocol.StreamTypeUni:
+		b.WriteByte(0x13)
+	}
+	utils.WriteVarInt(b, f.MaxStreams)
+	return nil
+}
+
+// Length of a written frame
+func (f *MaxStreamsFrame) Length(protocol.VersionNumber) protocol.ByteCount {
+	return 1 + utils.VarIntLen(f.MaxStreams)
+}
@@ -0,0 +1,80 @@
+package wire
+
+import (
+	"bytes"
+
+	"github.com/wheelcomplex/qk/internal/protocol"
+	"github.com/wheelcomplex/qk/internal/utils"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("MAX_STREAMS frame", func() {
+	Context("parsing", func() {
+		It("accepts a frame for a bidirectional stream", func() {
+			data := []byte{0x12}
+			data = append(data, encodeVarInt(0xdecaf)...)
+			b := bytes.NewReader(data)
+			f, err := parseMaxStreamsFrame(b, versionV1Frames)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(f.Type).To(Equal(protocol.StreamTypeBidi))
+			Expect(f.MaxStreams).To(BeEquivalentTo(0xdecaf))
+			Expect(b.Len()).To(BeZero())
+		})
+
+		It("accepts a frame for a unidirectional stream", func() {
+			data := []byte{0x13}
+			data = append(data, encodeVarInt(0xdecaf)...)
+			b := bytes.NewReader(data)
+			f, err := parseMaxStreamsFrame(b, versionV1Frames)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(f.Type).To(Equal(protocol.StreamTypeUni))
+			Expect(f.MaxStreams).To(BeEquivalentTo(0xdecaf))
+			Expect(b.Len()).To(BeZero())
+		})
+
+		It("errors when the stream count is too large", func() {
+			data := []byte{0x12}
+			data = append(data, encodeVarInt(1<<60+1)...)
+			_, err := parseMaxStreamsFrame(bytes.NewReader(data), versionV1Frames)
+			Expect(err).To(MatchError("1152921504606846977 exceeds the maximum stream count"))
+		})
+
+		It("errors on EOFs", func() {
+			data := []byte{0x12}
+			data = append(data, encodeVarInt(0xdeadbeefcafe13)...)
+			_, err := parseMaxStreamsFrame(bytes.NewReader(data), versionV1Frames)
+			Expect(err).NotTo(HaveOccurred())
+			for i := range data {
+				_, err := parseMaxStreamsFrame(bytes.NewReader(data[0:i]), versionV1Frames)
+				Expect(err).To(HaveOccurred())
+			}
+		})
+	})
+
+	Context("writing", func() {
+		It("writes a frame for bidirectional streams", func() {
+			b := &bytes.Buffer{}
+			frame := MaxStreamsFrame{Type: protocol.StreamTypeBidi, MaxStreams: 0xdeadbeef}
+			Expect(frame.Write(b, versionV1Frames)).To(Succeed())
+			expected := []byte{0x12}
+			expected = append(expected, encodeVarInt(0xdeadbeef)...)
+			Expect(b.Bytes()).To(Equal(expected))
+		})
+
+		It("writes a frame for unidirectional streams", func() {
+			b := &bytes.Buffer{}
+			frame := MaxStreamsFrame{Type: protocol.StreamTypeUni, MaxStreams: 0xdecafbad}
+			Expect(frame.Write(b, versionV1Frames)).To(Succeed())
+			expected := []byte{0x13}
+			expected = append(expected, encodeVarInt(0xdecafbad)...)
+			Expect(b.Bytes()).To(Equal(expected))
+		})
+
+		It("has the correct min length", func() {
+			frame := MaxStreamsFrame{MaxStreams: 0x1337}
+			Expect(frame.Length(versionV1Frames)).To(Equal(1 + utils.VarIntLen(0x1337)))
+		})
+	})
+})
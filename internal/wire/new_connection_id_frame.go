@@ -0,0 +1,79 @@
+package wire
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/wheelcomplex/qk/internal/protocol"
+	"github.com/wheelcomplex/qk/internal/utils"
+)
+
+// A NewConnectionIDFrame is a NEW_CONNECTION_ID frame
+type NewConnectionIDFrame struct {
+	SequenceNumber      uint64
+	RetirePriorTo       uint64
+	ConnectionID        protocol.ConnectionID
+	StatelessResetToken [16]byte
+}
+
+func parseNewConnectionIDFrame(r *bytes.Reader, _ protocol.VersionNumber) (*NewConnectionIDFrame, error) {
+	if _, err := r.ReadByte(); err != nil {
+		return nil, err
+	}
+
+	seq, err := utils.ReadVarInt(r)
+	if err != nil {
+		return nil, err
+	}
+	ret, err := utils.ReadVarInt(r)
+	if err != nil {
+		return nil, err
+	}
+	if ret > seq {
+		return nil, fmt.Errorf("Retire Prior To value (%d) larger than Sequence Number (%d)", ret, seq)
+	}
+	connIDLen, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if connIDLen == 0 || connIDLen > maxConnectionIDLenV1 {
+		return nil, fmt.Errorf("invalid connection ID length: %d", connIDLen)
+	}
+	connID, err := protocol.ReadConnectionID(r, int(connIDLen))
+	if err != nil {
+		return nil, err
+	}
+	frame := &NewConnectionIDFrame{
+		SequenceNumber: seq,
+		RetirePriorTo:  ret,
+		ConnectionID:   connID,
+	}
+	if _, err := io.ReadFull(r, frame.StatelessResetToken[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+	return frame, nil
+}
+
+func (f *NewConnectionIDFrame) Write(b *bytes.Buffer, _ protocol.VersionNumber) error {
+	connIDLen := f.ConnectionID.Len()
+	if connIDLen == 0 || connIDLen > maxConnectionIDLenV1 {
+		return errors.New("NewConnectionIDFrame: invalid connection ID length")
+	}
+	b.WriteByte(0x18)
+	utils.WriteVarInt(b, f.SequenceNumber)
+	utils.WriteVarInt(b, f.RetirePriorTo)
+	b.WriteByte(uint8(connIDLen))
+	b.Write(f.ConnectionID.Bytes())
+	b.Write(f.StatelessResetToken[:])
+	return nil
+}
+
+// Length of a written frame
+func (f *NewConnectionIDFrame) Length(_ protocol.VersionNumber) protocol.ByteCount {
+	return 1 + utils.VarIntLen(f.SequenceNumber) + utils.VarIntLen(f.RetirePriorTo) + 1 /* connection ID length */ + protocol.ByteCount(f.ConnectionID.Len()) + 16
+}
@@ -0,0 +1,96 @@
+package wire
+
+import (
+	"bytes"
+
+	"github.com/wheelcomplex/qk/internal/protocol"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("NEW_CONNECTION_ID frame", func() {
+	Context("parsing", func() {
+		It("accepts a sample frame", func() {
+			data := []byte{0x18}
+			data = append(data, encodeVarInt(0xdeadbeef)...)              // sequence number
+			data = append(data, encodeVarInt(0xcafe)...)                  // retire prior to
+			data = append(data, 10)                                       // connection ID length
+			data = append(data, []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}...) // connection ID
+			data = append(data, []byte("deadbeefdecafbad")...)            // stateless reset token
+			b := bytes.NewReader(data)
+			frame, err := parseNewConnectionIDFrame(b, versionV1Frames)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(frame.SequenceNumber).To(Equal(uint64(0xdeadbeef)))
+			Expect(frame.RetirePriorTo).To(Equal(uint64(0xcafe)))
+			Expect(frame.ConnectionID).To(Equal(protocol.ConnectionID{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}))
+			Expect(string(frame.StatelessResetToken[:])).To(Equal("deadbeefdecafbad"))
+			Expect(b.Len()).To(BeZero())
+		})
+
+		It("errors when the Retire Prior To value is larger than the Sequence Number", func() {
+			data := []byte{0x18}
+			data = append(data, encodeVarInt(1000)...) // sequence number
+			data = append(data, encodeVarInt(1001)...) // retire prior to
+			data = append(data, 3)
+			data = append(data, []byte{1, 2, 3}...)
+			data = append(data, []byte("deadbeefdecafbad")...) // stateless reset token
+			_, err := parseNewConnectionIDFrame(bytes.NewReader(data), versionV1Frames)
+			Expect(err).To(MatchError("Retire Prior To value (1001) larger than Sequence Number (1000)"))
+		})
+
+		It("errors when the connection ID has an invalid length", func() {
+			data := []byte{0x18}
+			data = append(data, encodeVarInt(0xdeadbeef)...)                                                          // sequence number
+			data = append(data, encodeVarInt(0xcafe)...)                                                              // retire prior to
+			data = append(data, 21)                                                                                   // connection ID length
+			data = append(data, []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20, 21}...) // connection ID
+			data = append(data, []byte("deadbeefdecafbad")...)                                                        // stateless reset token
+			_, err := parseNewConnectionIDFrame(bytes.NewReader(data), versionV1Frames)
+			Expect(err).To(MatchError("invalid connection ID length: 21"))
+		})
+
+		It("errors on EOFs", func() {
+			data := []byte{0x18}
+			data = append(data, encodeVarInt(0xdeadbeef)...)              // sequence number
+			data = append(data, encodeVarInt(0xcafe)...)                  // retire prior to
+			data = append(data, 10)                                       // connection ID length
+			data = append(data, []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}...) // connection ID
+			data = append(data, []byte("deadbeefdecafbad")...)            // stateless reset token
+			_, err := parseNewConnectionIDFrame(bytes.NewReader(data), versionV1Frames)
+			Expect(err).NotTo(HaveOccurred())
+			for i := range data {
+				_, err := parseNewConnectionIDFrame(bytes.NewReader(data[0:i]), versionV1Frames)
+				Expect(err).To(HaveOccurred())
+			}
+		})
+	})
+
+	Context("writing", func() {
+		It("writes a sample frame", func() {
+			token := [16]byte{}
+			copy(token[:], []byte("deadbeefdecafbad"))
+			frame := &NewConnectionIDFrame{
+				SequenceNumber:      0x1337,
+				RetirePriorTo:       0x42,
+				ConnectionID:        protocol.ConnectionID{1, 2, 3, 4, 5, 6},
+				StatelessResetToken: token,
+			}
+			b := &bytes.Buffer{}
+			Expect(frame.Write(b, versionV1Frames)).To(Succeed())
+			expected := []byte{0x18}
+			expected = append(expected, encodeVarInt(0x1337)...)
+			expected = append(expected, encodeVarInt(0x42)...)
+			expected = append(expected, 6)
+			expected = append(expected, []byte{1, 2, 3, 4, 5, 6}...)
+			expected = append(expected, token[:]...)
+			Expect(b.Bytes()).To(Equal(expected))
+			Expect(frame.Length(versionV1Frames)).To(BeEquivalentTo(b.Len()))
+		})
+
+		It("refuses to write an empty connection ID", func() {
+			frame := &NewConnectionIDFrame{SequenceNumber: 1}
+			Expect(frame.Write(&bytes.Buffer{}, versionV1Frames)).To(MatchError("NewConnectionIDFrame: invalid connection ID length"))
+		})
+	})
+})
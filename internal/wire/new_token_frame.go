@@ -0,0 +1,48 @@
+package wire
+
+import (
+	"bytes"
+	"errors"
+	"io"
+
+	"github.com/wheelcomplex/qk/internal/protocol"
+	"github.com/wheelcomplex/qk/internal/utils"
+)
+
+// A NewTokenFrame is a NEW_TOKEN frame
+type NewTokenFrame struct {
+	Token []byte
+}
+
+func parseNewTokenFrame(r *bytes.Reader, _ protocol.VersionNumber) (*NewTokenFrame, error) {
+	if _, err := r.ReadByte(); err != nil {
+		return nil, err
+	}
+	tokenLen, err := utils.ReadVarInt(r)
+	if err != nil {
+		return nil, err
+	}
+	if uint64(r.Len()) < tokenLen {
+		return nil, io.EOF
+	}
+	if tokenLen == 0 {
+		return nil, errors.New("token must not be empty")
+	}
+	token := make([]byte, int(tokenLen))
+	if _, err := io.ReadFull(r, token); err != nil {
+		return nil, err
+	}
+	return &NewTokenFrame{Token: token}, nil
+}
+
+func (f *NewTokenFrame) Write(b *bytes.Buffer, _ protocol.VersionNumber) error {
+	b.WriteByte(0x07)
+	utils.WriteVarInt(b, uint64(len(f.Token)))
+	b.Write(f.Token)
+	return nil
+}
+
+// Length of a written frame
+func (f *NewTokenFrame) Length(_ protocol.VersionNumber) protocol.ByteCount {
+	return 1 + utils.VarIntLen(uint64(len(f.Token))) + protocol.ByteCount(len(f.Token))
+}
@@ -0,0 +1,58 @@
+package wire
+
+import (
+	"bytes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("NEW_TOKEN frame", func() {
+	Context("parsing", func() {
+		It("accepts a sample frame", func() {
+			token := "foobar"
+			data := []byte{0x07}
+			data = append(data, encodeVarInt(uint64(len(token)))...)
+			data = append(data, token...)
+			b := bytes.NewReader(data)
+			f, err := parseNewTokenFrame(b, versionV1Frames)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(f.Token)).To(Equal(token))
+			Expect(b.Len()).To(BeZero())
+		})
+
+		It("rejects empty tokens", func() {
+			data := []byte{0x07}
+			data = append(data, encodeVarInt(0)...)
+			_, err := parseNewTokenFrame(bytes.NewReader(data), versionV1Frames)
+			Expect(err).To(MatchError("token must not be empty"))
+		})
+
+		It("errors on EOFs", func() {
+			token := "Lorem ipsum dolor sit amet, consectetur adipiscing elit"
+			data := []byte{0x07}
+			data = append(data, encodeVarInt(uint64(len(token)))...)
+			data = append(data, token...)
+			_, err := parseNewTokenFrame(bytes.NewReader(data), versionV1Frames)
+			Expect(err).NotTo(HaveOccurred())
+			for i := range data {
+				_, err := parseNewTokenFrame(bytes.NewReader(data[0:i]), versionV1Frames)
+				Expect(err).To(HaveOccurred())
+			}
+		})
+	})
+
+	Context("writing", func() {
+		It("writes a sample frame", func() {
+			token := "Lorem ipsum dolor sit amet, consectetur adipiscing elit"
+			f := &NewTokenFrame{Token: []byte(token)}
+			b := &bytes.Buffer{}
+			Expect(f.Write(b, versionV1Frames)).To(Succeed())
+			expected := []byte{0x07}
+			expected = append(expected, encodeVarInt(uint64(len(token)))...)
+			expected = append(expected, token...)
+			Expect(b.Bytes()).To(Equal(expected))
+			Expect(f.Length(versionV1Frames)).To(BeEquivalentTo(b.Len()))
+		})
+	})
+})
@@ -26,8 +26,11 @@ func parsePathChallengeFrame(r *bytes.Reader, version protocol.VersionNumber) (*
 	return frame, nil
 }
 
-func (f *PathChallengeFrame) Write(b *bytes.Buffer, _ protocol.VersionNumber) error {
+func (f *PathChallengeFrame) Write(b *bytes.Buffer, version protocol.VersionNumber) error {
 	typeByte := uint8(0x0e)
+	if version.UsesV1FrameFormat() {
+		typeByte = 0x1a
+	}
 	b.WriteByte(typeByte)
 	b.Write(f.Data[:])
 	return nil
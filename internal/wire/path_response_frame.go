@@ -26,8 +26,11 @@ func parsePathResponseFrame(r *bytes.Reader, version protocol.VersionNumber) (*P
 	return frame, nil
 }
 
-func (f *PathResponseFrame) Write(b *bytes.Buffer, _ protocol.VersionNumber) error {
+func (f *PathResponseFrame) Write(b *bytes.Buffer, version protocol.VersionNumber) error {
 	typeByte := uint8(0x0f)
+	if version.UsesV1FrameFormat() {
+		typeByte = 0x1b
+	}
 	b.WriteByte(typeByte)
 	b.Write(f.Data[:])
 	return nil
@@ -23,6 +23,9 @@ func parsePingFrame(r *bytes.Reader, version protocol.VersionNumber) (*PingFrame
 
 func (f *PingFrame) Write(b *bytes.Buffer, version protocol.VersionNumber) error {
 	typeByte := uint8(0x07)
+	if version.UsesV1FrameFormat() {
+		typeByte = 0x01
+	}
 	b.WriteByte(typeByte)
 	return nil
 }
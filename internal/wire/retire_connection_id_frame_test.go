@@ -0,0 +1,45 @@
+package wire
+
+import (
+	"bytes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RETIRE_CONNECTION_ID frame", func() {
+	Context("parsing", func() {
+		It("accepts a sample frame", func() {
+			data := []byte{0x19}
+			data = append(data, encodeVarInt(0xdeadbeef)...) // sequence number
+			b := bytes.NewReader(data)
+			frame, err := parseRetireConnectionIDFrame(b, versionV1Frames)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(frame.SequenceNumber).To(Equal(uint64(0xdeadbeef)))
+			Expect(b.Len()).To(BeZero())
+		})
+
+		It("errors on EOFs", func() {
+			data := []byte{0x19}
+			data = append(data, encodeVarInt(0xdeadbeef)...) // sequence number
+			_, err := parseRetireConnectionIDFrame(bytes.NewReader(data), versionV1Frames)
+			Expect(err).NotTo(HaveOccurred())
+			for i := range data {
+				_, err := parseRetireConnectionIDFrame(bytes.NewReader(data[0:i]), versionV1Frames)
+				Expect(err).To(HaveOccurred())
+			}
+		})
+	})
+
+	Context("writing", func() {
+		It("writes a sample frame", func() {
+			frame := &RetireConnectionIDFrame{SequenceNumber: 0x1337}
+			b := &bytes.Buffer{}
+			Expect(frame.Write(b, versionV1Frames)).To(Succeed())
+			expected := []byte{0x19}
+			expected = append(expected, encodeVarInt(0x1337)...)
+			Expect(b.Bytes()).To(Equal(expected))
+			Expect(frame.Length(versionV1Frames)).To(BeEquivalentTo(b.Len()))
+		})
+	})
+})
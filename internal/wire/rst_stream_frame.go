@@ -10,8 +10,8 @@ import (
 // A RstStreamFrame is a RST_STREAM frame in QUIC
 type RstStreamFrame struct {
 	StreamID protocol.StreamID
-	// The error code is a uint32 in gQUIC, but a uint16 in IETF QUIC.
-	// protocol.ApplicaitonErrorCode is a uint16, so larger values in gQUIC frames will be truncated.
+	// The error code is a uint32 in gQUIC, a uint16 in IETF QUIC, and a varint in QUIC v1.
+	// protocol.ApplicaitonErrorCode is a uint16, so larger values in gQUIC and QUIC v1 frames will be truncated.
 	ErrorCode  protocol.ApplicationErrorCode
 	ByteOffset protocol.ByteCount
 }
@@ -31,9 +31,17 @@ func parseRstStreamFrame(r *bytes.Reader, version protocol.VersionNumber) (*RstS
 			return nil, err
 		}
 		streamID = protocol.StreamID(sid)
-		errorCode, err = utils.BigEndian.ReadUint16(r)
-		if err != nil {
-			return nil, err
+		if version.UsesV1FrameFormat() {
+			ec, err := utils.ReadVarInt(r)
+			if err != nil {
+				return nil, err
+			}
+			errorCode = uint16(ec)
+		} else {
+			errorCode, err = utils.BigEndian.ReadUint16(r)
+			if err != nil {
+				return nil, err
+			}
 		}
 		bo, err := utils.ReadVarInt(r)
 		if err != nil {
@@ -67,6 +75,13 @@ func parseRstStreamFrame(r *bytes.Reader, version protocol.VersionNumber) (*RstS
 
 //Write writes a RST_STREAM frame
 func (f *RstStreamFrame) Write(b *bytes.Buffer, version protocol.VersionNumber) error {
+	if version.UsesV1FrameFormat() {
+		b.WriteByte(0x04)
+		utils.WriteVarInt(b, uint64(f.StreamID))
+		utils.WriteVarInt(b, uint64(f.ErrorCode))
+		utils.WriteVarInt(b, uint64(f.ByteOffset))
+		return nil
+	}
 	b.WriteByte(0x01)
 	if version.UsesIETFFrameFormat() {
 		utils.WriteVarInt(b, uint64(f.StreamID))
@@ -82,6 +97,9 @@ func (f *RstStreamFrame) Write(b *bytes.Buffer, version protocol.VersionNumber)
 
 // Length of a written frame
 func (f *RstStreamFrame) Length(version protocol.VersionNumber) protocol.ByteCount {
+	if version.UsesV1FrameFormat() {
+		return 1 + utils.VarIntLen(uint64(f.StreamID)) + utils.VarIntLen(uint64(f.ErrorCode)) + utils.VarIntLen(uint64(f.ByteOffset))
+	}
 	if version.UsesIETFFrameFormat() {
 		return 1 + utils.VarIntLen(uint64(f.StreamID)) + 2 + utils.VarIntLen(uint64(f.ByteOffset))
 	}
@@ -14,7 +14,7 @@ type StopSendingFrame struct {
 }
 
 // parseStopSendingFrame parses a STOP_SENDING frame
-func parseStopSendingFrame(r *bytes.Reader, _ protocol.VersionNumber) (*StopSendingFrame, error) {
+func parseStopSendingFrame(r *bytes.Reader, version protocol.VersionNumber) (*StopSendingFrame, error) {
 	if _, err := r.ReadByte(); err != nil { // read the TypeByte
 		return nil, err
 	}
@@ -23,9 +23,19 @@ func parseStopSendingFrame(r *bytes.Reader, _ protocol.VersionNumber) (*StopSend
 	if err != nil {
 		return nil, err
 	}
-	errorCode, err := utils.BigEndian.ReadUint16(r)
-	if err != nil {
-		return nil, err
+	var errorCode uint16
+	if version.UsesV1FrameFormat() {
+		// error codes larger than a uint16 are truncated
+		ec, err := utils.ReadVarInt(r)
+		if err != nil {
+			return nil, err
+		}
+		errorCode = uint16(ec)
+	} else {
+		errorCode, err = utils.BigEndian.ReadUint16(r)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	return &StopSendingFrame{
@@ -35,11 +45,20 @@ func parseStopSendingFrame(r *bytes.Reader, _ protocol.VersionNumber) (*StopSend
 }
 
 // Length of a written frame
-func (f *StopSendingFrame) Length(_ protocol.VersionNumber) protocol.ByteCount {
+func (f *StopSendingFrame) Length(version protocol.VersionNumber) protocol.ByteCount {
+	if version.UsesV1FrameFormat() {
+		return 1 + utils.VarIntLen(uint64(f.StreamID)) + utils.VarIntLen(uint64(f.ErrorCode))
+	}
 	return 1 + utils.VarIntLen(uint64(f.StreamID)) + 2
 }
 
-func (f *StopSendingFrame) Write(b *bytes.Buffer, _ protocol.VersionNumber) error {
+func (f *StopSendingFrame) Write(b *bytes.Buffer, version protocol.VersionNumber) error {
+	if version.UsesV1FrameFormat() {
+		b.WriteByte(0x05)
+		utils.WriteVarInt(b, uint64(f.StreamID))
+		utils.WriteVarInt(b, uint64(f.ErrorCode))
+		return nil
+	}
 	b.WriteByte(0x0c)
 	utils.WriteVarInt(b, uint64(f.StreamID))
 	utils.BigEndian.WriteUint16(b, uint16(f.ErrorCode))
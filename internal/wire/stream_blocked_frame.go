@@ -37,7 +37,11 @@ func (f *StreamBlockedFrame) Write(b *bytes.Buffer, version protocol.VersionNumb
 	if !version.UsesIETFFrameFormat() {
 		return (&blockedFrameLegacy{StreamID: f.StreamID}).Write(b, version)
 	}
-	b.WriteByte(0x09)
+	typeByte := uint8(0x09)
+	if version.UsesV1FrameFormat() {
+		typeByte = 0x15 // STREAM_DATA_BLOCKED
+	}
+	b.WriteByte(typeByte)
 	utils.WriteVarInt(b, uint64(f.StreamID))
 	utils.WriteVarInt(b, uint64(f.Offset))
 	return nil
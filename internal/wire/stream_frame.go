@@ -90,6 +90,9 @@ func (f *StreamFrame) Write(b *bytes.Buffer, version protocol.VersionNumber) err
 	}
 
 	typeByte := byte(0x10)
+	if version.UsesV1FrameFormat() {
+		typeByte = 0x08
+	}
 	if f.FinBit {
 		typeByte ^= 0x1
 	}
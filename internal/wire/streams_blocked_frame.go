@@ -0,0 +1,51 @@
+package wire
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/wheelcomplex/qk/internal/protocol"
+	"github.com/wheelcomplex/qk/internal/utils"
+)
+
+// A StreamsBlockedFrame is a STREAMS_BLOCKED frame.
+// It replaces the STREAM_ID_BLOCKED frame in QUIC v1.
+type StreamsBlockedFrame struct {
+	Type        protocol.StreamType
+	StreamLimit uint64
+}
+
+func parseStreamsBlockedFrame(r *bytes.Reader, _ protocol.VersionNumber) (*StreamsBlockedFrame, error) {
+	typeByte, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	f := &StreamsBlockedFrame{Type: protocol.StreamTypeBidi}
+	if typeByte == 0x17 {
+		f.Type = protocol.StreamTypeUni
+	}
+	f.StreamLimit, err = utils.ReadVarInt(r)
+	if err != nil {
+		return nil, err
+	}
+	if f.StreamLimit > maxStreamCount {
+		return nil, fmt.Errorf("%d exceeds the maximum stream count", f.StreamLimit)
+	}
+	return f, nil
+}
+
+func (f *StreamsBlockedFrame) Write(b *bytes.Buffer, _ protocol.VersionNumber) error {
+	switch f.Type {
+	case protocol.StreamTypeBidi:
+		b.WriteByte(0x16)
+	case protocol.StreamTypeUni:
+		b.WriteByte(0x17)
+	}
+	utils.WriteVarInt(b, f.StreamLimit)
+	return nil
+}
+
+// Length of a written frame
+func (f *StreamsBlockedFrame) Length(_ protocol.VersionNumber) protocol.ByteCount {
+	return 1 + utils.VarIntLen(f.StreamLimit)
+}
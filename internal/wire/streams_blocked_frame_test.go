@@ -0,0 +1,80 @@
+package wire
+
+import (
+	"bytes"
+
+	"github.com/wheelcomplex/qk/internal/protocol"
+	"github.com/wheelcomplex/qk/internal/utils"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("STREAMS_BLOCKED frame", func() {
+	Context("parsing", func() {
+		It("accepts a frame for bidirectional streams", func() {
+			expected := []byte{0x16}
+			expected = append(expected, encodeVarInt(0x1337)...)
+			b := bytes.NewReader(expected)
+			f, err := parseStreamsBlockedFrame(b, versionV1Frames)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(f.Type).To(Equal(protocol.StreamTypeBidi))
+			Expect(f.StreamLimit).To(BeEquivalentTo(0x1337))
+			Expect(b.Len()).To(BeZero())
+		})
+
+		It("accepts a frame for unidirectional streams", func() {
+			expected := []byte{0x17}
+			expected = append(expected, encodeVarInt(0x7331)...)
+			b := bytes.NewReader(expected)
+			f, err := parseStreamsBlockedFrame(b, versionV1Frames)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(f.Type).To(Equal(protocol.StreamTypeUni))
+			Expect(f.StreamLimit).To(BeEquivalentTo(0x7331))
+			Expect(b.Len()).To(BeZero())
+		})
+
+		It("errors when the stream limit is too large", func() {
+			data := []byte{0x16}
+			data = append(data, encodeVarInt(1<<60+1)...)
+			_, err := parseStreamsBlockedFrame(bytes.NewReader(data), versionV1Frames)
+			Expect(err).To(MatchError("1152921504606846977 exceeds the maximum stream count"))
+		})
+
+		It("errors on EOFs", func() {
+			data := []byte{0x16}
+			data = append(data, encodeVarInt(0x12345678)...)
+			_, err := parseStreamsBlockedFrame(bytes.NewReader(data), versionV1Frames)
+			Expect(err).ToNot(HaveOccurred())
+			for i := range data {
+				_, err := parseStreamsBlockedFrame(bytes.NewReader(data[:i]), versionV1Frames)
+				Expect(err).To(HaveOccurred())
+			}
+		})
+	})
+
+	Context("writing", func() {
+		It("writes a frame for bidirectional streams", func() {
+			b := &bytes.Buffer{}
+			f := StreamsBlockedFrame{Type: protocol.StreamTypeBidi, StreamLimit: 0xdeadbeefcafe}
+			Expect(f.Write(b, versionV1Frames)).To(Succeed())
+			expected := []byte{0x16}
+			expected = append(expected, encodeVarInt(0xdeadbeefcafe)...)
+			Expect(b.Bytes()).To(Equal(expected))
+		})
+
+		It("writes a frame for unidirectional streams", func() {
+			b := &bytes.Buffer{}
+			f := StreamsBlockedFrame{Type: protocol.StreamTypeUni, StreamLimit: 0xdeadbeefcafe}
+			Expect(f.Write(b, versionV1Frames)).To(Succeed())
+			expected := []byte{0x17}
+			expected = append(expected, encodeVarInt(0xdeadbeefcafe)...)
+			Expect(b.Bytes()).To(Equal(expected))
+		})
+
+		It("has the correct min length", func() {
+			frame := StreamsBlockedFrame{StreamLimit: 0x123456}
+			Expect(frame.Length(versionV1Frames)).To(Equal(protocol.ByteCount(1) + utils.VarIntLen(0x123456)))
+		})
+	})
+})
@@ -25,6 +25,8 @@ const (
 	versionPublicHeader = protocol.Version43
 	// a QUIC version that the IETF QUIC Header
 	versionIETFHeader = protocol.VersionTLS
+	// a QUIC version that uses the frame types defined in RFC 9000
+	versionV1Frames = protocol.Version1
 )
 
 func encodeVarInt(i uint64) []byte {
@@ -36,4 +38,6 @@ func encodeVarInt(i uint64) []byte {
 var _ = BeforeSuite(func() {
 	Expect(versionBigEndian.UsesIETFFrameFormat()).To(BeFalse())
 	Expect(versionIETFFrames.UsesIETFFrameFormat()).To(BeTrue())
+	Expect(versionIETFFrames.UsesV1FrameFormat()).To(BeFalse())
+	Expect(versionV1Frames.UsesV1FrameFormat()).To(BeTrue())
 })
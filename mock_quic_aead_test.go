@@ -8,6 +8,7 @@ import (
 	reflect "reflect"
 
 	gomock "github.com/golang/mock/gomock"
+	crypto "github.com/wheelcomplex/qk/internal/crypto"
 	protocol "github.com/wheelcomplex/qk/internal/protocol"
 )
 
@@ -34,17 +35,30 @@ func (m *MockQuicAEAD) EXPECT() *MockQuicAEADMockRecorder {
 	return m.recorder
 }
 
+// GetHeaderProtector mocks base method
+func (m *MockQuicAEAD) GetHeaderProtector(arg0 protocol.EncryptionLevel) (crypto.HeaderProtector, error) {
+	ret := m.ctrl.Call(m, "GetHeaderProtector", arg0)
+	ret0, _ := ret[0].(crypto.HeaderProtector)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetHeaderProtector indicates an expected call of GetHeaderProtector
+func (mr *MockQuicAEADMockRecorder) GetHeaderProtector(arg0 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetHeaderProtector", reflect.TypeOf((*MockQuicAEAD)(nil).GetHeaderProtector), arg0)
+}
+
 // Open1RTT mocks base method
-func (m *MockQuicAEAD) Open1RTT(arg0, arg1 []byte, arg2 protocol.PacketNumber, arg3 []byte) ([]byte, error) {
-	ret := m.ctrl.Call(m, "Open1RTT", arg0, arg1, arg2, arg3)
+func (m *MockQuicAEAD) Open1RTT(arg0, arg1 []byte, arg2 protocol.PacketNumber, arg3 int, arg4 []byte) ([]byte, error) {
+	ret := m.ctrl.Call(m, "Open1RTT", arg0, arg1, arg2, arg3, arg4)
 	ret0, _ := ret[0].([]byte)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // Open1RTT indicates an expected call of Open1RTT
-func (mr *MockQuicAEADMockRecorder) Open1RTT(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Open1RTT", reflect.TypeOf((*MockQuicAEAD)(nil).Open1RTT), arg0, arg1, arg2, arg3)
+func (mr *MockQuicAEADMockRecorder) Open1RTT(arg0, arg1, arg2, arg3, arg4 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Open1RTT", reflect.TypeOf((*MockQuicAEAD)(nil).Open1RTT), arg0, arg1, arg2, arg3, arg4)
 }
 
 // OpenHandshake mocks base method
@@ -11,6 +11,7 @@ package quic
 //go:generate sh -c "./mockgen_private.sh quic mock_unpacker_test.go github.com/wheelcomplex/qk unpacker"
 //go:generate sh -c "./mockgen_private.sh quic mock_quic_aead_test.go github.com/wheelcomplex/qk quicAEAD"
 //go:generate sh -c "./mockgen_private.sh quic mock_gquic_aead_test.go github.com/wheelcomplex/qk gQUICAEAD"
+//go:generate sh -c "./mockgen_private.sh quic mock_quic_aead_v1_test.go github.com/wheelcomplex/qk quicAEADV1"
 //go:generate sh -c "./mockgen_private.sh quic mock_session_runner_test.go github.com/wheelcomplex/qk sessionRunner"
 //go:generate sh -c "./mockgen_private.sh quic mock_quic_session_test.go github.com/wheelcomplex/qk quicSession"
 //go:generate sh -c "./mockgen_private.sh quic mock_packet_handler_test.go github.com/wheelcomplex/qk packetHandler"
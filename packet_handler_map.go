@@ -12,6 +12,12 @@ import (
 	"github.com/wheelcomplex/qk/internal/wire"
 )
 
+// A datagramRecorder records the datagrams received for a packetHandler.
+// It is implemented by packetHandlers of sessions that might be recorded.
+type datagramRecorder interface {
+	recordDatagram(remoteAddr net.Addr, data []byte)
+}
+
 // The packetHandlerMap stores packetHandlers, identified by connection ID.
 // It is used:
 // * by the server to store sessions
@@ -139,8 +145,6 @@ func (h *packetHandlerMap) listen() {
 }
 
 func (h *packetHandlerMap) handlePacket(addr net.Addr, data []byte) error {
-	rcvTime := time.Now()
-
 	r := bytes.NewReader(data)
 	iHdr, err := wire.ParseInvariantHeader(r, h.connIDLen)
 	// drop the packet if we can't parse the header
@@ -168,31 +172,52 @@ func (h *packetHandlerMap) handlePacket(addr net.Addr, data []byte) error {
 		sentBy = protocol.PerspectiveClient
 		version = iHdr.Version
 	} else {
+		// Record the whole datagram, since the packet might be rejected, or the datagram might contain coalesced packets.
+		if r, ok := handler.(datagramRecorder); ok {
+			r.recordDatagram(addr, data)
+		}
 		sentBy = handler.GetPerspective().Opposite()
 		version = handler.GetVersion()
 		handlePacket = handler.handlePacket
 	}
 
+	hdr, packetData, err := parsePacket(iHdr, r, data, sentBy, version)
+	if err != nil {
+		return err
+	}
+
+	handlePacket(&receivedPacket{
+		remoteAddr: addr,
+		header:     hdr,
+		data:       packetData,
+		datagram:   data,
+	})
+	return nil
+}
+
+// parsePacket parses the header of a packet, after the invariant header was parsed from r.
+// The reader r reads the packet data.
+// It returns the header and the payload of the packet.
+func parsePacket(
+	iHdr *wire.InvariantHeader,
+	r *bytes.Reader,
+	data []byte,
+	sentBy protocol.Perspective,
+	version protocol.VersionNumber,
+) (*wire.Header, []byte, error) {
 	hdr, err := iHdr.Parse(r, sentBy, version)
 	if err != nil {
-		return fmt.Errorf("error parsing header: %s", err)
+		return nil, nil, fmt.Errorf("error parsing header: %s", err)
 	}
 	hdr.Raw = data[:len(data)-r.Len()]
 	packetData := data[len(data)-r.Len():]
 
 	if hdr.IsLongHeader && hdr.Version.UsesLengthInHeader() {
 		if protocol.ByteCount(len(packetData)) < hdr.PayloadLen {
-			return fmt.Errorf("packet payload (%d bytes) is smaller than the expected payload length (%d bytes)", len(packetData), hdr.PayloadLen)
+			return nil, nil, fmt.Errorf("packet payload (%d bytes) is smaller than the expected payload length (%d bytes)", len(packetData), hdr.PayloadLen)
 		}
 		packetData = packetData[:int(hdr.PayloadLen)]
 		// TODO(#1312): implement parsing of compound packets
 	}
-
-	handlePacket(&receivedPacket{
-		remoteAddr: addr,
-		header:     hdr,
-		data:       packetData,
-		rcvTime:    rcvTime,
-	})
-	return nil
+	return hdr, packetData, nil
 }
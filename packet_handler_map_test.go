@@ -3,6 +3,7 @@ package quic
 import (
 	"bytes"
 	"errors"
+	"net"
 	"time"
 
 	"github.com/golang/mock/gomock"
@@ -80,7 +81,8 @@ var _ = Describe("Packet Handler Map", func() {
 		})
 
 		It("drops unparseable packets", func() {
-			err := handler.handlePacket(nil, []byte("invalid"))
+			// a Public Header with a truncated connection ID
+			err := handler.handlePacket(nil, []byte{0x8, 0xde, 0xad})
 			Expect(err).To(HaveOccurred())
 			Expect(err.Error()).To(ContainSubstring("error parsing invariant header:"))
 		})
@@ -157,6 +159,34 @@ var _ = Describe("Packet Handler Map", func() {
 			Expect(err).ToNot(HaveOccurred())
 		})
 
+		It("records whole datagrams for packet handlers that record them", func() {
+			connID := protocol.ConnectionID{1, 2, 3, 4, 5, 6, 7, 8}
+			packetHandler := &recordingPacketHandler{MockPacketHandler: NewMockPacketHandler(mockCtrl)}
+			packetHandler.EXPECT().GetVersion().Return(versionIETFFrames).Times(2)
+			packetHandler.EXPECT().GetPerspective().Return(protocol.PerspectiveClient).Times(2)
+			handler.Add(connID, packetHandler)
+			hdr := &wire.Header{
+				IsLongHeader:     true,
+				Type:             protocol.PacketTypeHandshake,
+				PayloadLen:       456,
+				DestConnectionID: connID,
+				PacketNumberLen:  protocol.PacketNumberLen1,
+				Version:          versionIETFFrames,
+			}
+			buf := &bytes.Buffer{}
+			Expect(hdr.Write(buf, protocol.PerspectiveServer, versionIETFFrames)).To(Succeed())
+			// a packet that is cut at the Payload Length
+			coalesced := append(append([]byte{}, buf.Bytes()...), bytes.Repeat([]byte{0}, 500)...)
+			packetHandler.EXPECT().handlePacket(gomock.Any()).Do(func(p *receivedPacket) {
+				Expect(p.datagram).To(Equal(coalesced))
+			})
+			Expect(handler.handlePacket(nil, coalesced)).To(Succeed())
+			// a packet that is rejected, since it is smaller than the Payload Length
+			short := append(append([]byte{}, buf.Bytes()...), bytes.Repeat([]byte{0}, 100)...)
+			Expect(handler.handlePacket(nil, short)).ToNot(Succeed())
+			Expect(packetHandler.datagrams).To(Equal([][]byte{coalesced, short}))
+		})
+
 		It("closes the packet handlers when reading from the conn fails", func() {
 			done := make(chan struct{})
 			packetHandler := NewMockPacketHandler(mockCtrl)
@@ -204,3 +234,15 @@ var _ = Describe("Packet Handler Map", func() {
 		})
 	})
 })
+
+// A recordingPacketHandler is a packetHandler that records the datagrams it receives
+type recordingPacketHandler struct {
+	*MockPacketHandler
+	datagrams [][]byte
+}
+
+var _ datagramRecorder = &recordingPacketHandler{}
+
+func (h *recordingPacketHandler) recordDatagram(_ net.Addr, data []byte) {
+	h.datagrams = append(h.datagrams, data)
+}
@@ -15,6 +15,10 @@ type packetNumberGenerator struct {
 
 	next       protocol.PacketNumber
 	nextToSkip protocol.PacketNumber
+
+	// chooseSkip is called with the randomly chosen packet number to skip, and returns the packet number that is skipped.
+	// It is used to record and to replay sessions.
+	chooseSkip func(protocol.PacketNumber) protocol.PacketNumber
 }
 
 func newPacketNumberGenerator(initial, averagePeriod protocol.PacketNumber) *packetNumberGenerator {
@@ -51,6 +55,9 @@ func (p *packetNumberGenerator) generateNewSkip() error {
 	skip := protocol.PacketNumber(num) * (p.averagePeriod - 1) / (math.MaxUint16 / 2)
 	// make sure that there are never two consecutive packet numbers that are skipped
 	p.nextToSkip = p.next + 2 + skip
+	if p.chooseSkip != nil {
+		p.nextToSkip = p.chooseSkip(p.nextToSkip)
+	}
 
 	return nil
 }
@@ -61,6 +61,18 @@ var _ = Describe("Packet Number Generator", func() {
 		Expect(average).To(BeNumerically("==", protocol.PacketNumber(200), 4))
 	})
 
+	It("lets chooseSkip change the packet number to skip", func() {
+		var chosen []protocol.PacketNumber
+		png.chooseSkip = func(pn protocol.PacketNumber) protocol.PacketNumber {
+			chosen = append(chosen, pn)
+			return 5
+		}
+		png.generateNewSkip()
+		Expect(chosen).To(HaveLen(1))
+		Expect(chosen[0]).To(BeNumerically(">=", 3))
+		Expect(png.nextToSkip).To(Equal(protocol.PacketNumber(5)))
+	})
+
 	It("uses random numbers", func() {
 		var smallest uint16 = math.MaxUint16
 		var largest uint16
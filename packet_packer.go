@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/wheelcomplex/qk/internal/ackhandler"
+	"github.com/wheelcomplex/qk/internal/crypto"
 	"github.com/wheelcomplex/qk/internal/handshake"
 	"github.com/wheelcomplex/qk/internal/protocol"
 	"github.com/wheelcomplex/qk/internal/utils"
@@ -22,14 +23,14 @@ type packedPacket struct {
 	encryptionLevel protocol.EncryptionLevel
 }
 
-func (p *packedPacket) ToAckHandlerPacket() *ackhandler.Packet {
+func (p *packedPacket) ToAckHandlerPacket(sendTime time.Time) *ackhandler.Packet {
 	return &ackhandler.Packet{
 		PacketNumber:    p.header.PacketNumber,
 		PacketType:      p.header.Type,
 		Frames:          p.frames,
 		Length:          protocol.ByteCount(len(p.raw)),
 		EncryptionLevel: p.encryptionLevel,
-		SendTime:        time.Now(),
+		SendTime:        sendTime,
 	}
 }
 
@@ -39,6 +40,18 @@ type sealingManager interface {
 	GetSealerWithEncryptionLevel(protocol.EncryptionLevel) (handshake.Sealer, error)
 }
 
+// A keyPhaseSealer is a sealer for 1-RTT packets, that knows the key phase of the keys it uses
+type keyPhaseSealer interface {
+	handshake.Sealer
+	KeyPhase() int
+}
+
+// A headerProtectingSealer is a sealer that is used together with header protection
+type headerProtectingSealer interface {
+	handshake.Sealer
+	HeaderProtector() crypto.HeaderProtector
+}
+
 type streamFrameSource interface {
 	HasCryptoStreamData() bool
 	PopCryptoStreamFrame(protocol.ByteCount) *wire.StreamFrame
@@ -57,8 +70,15 @@ type packetPacker struct {
 	divNonce []byte
 
 	packetNumberGenerator *packetNumberGenerator
-	getPacketNumberLen    func(protocol.PacketNumber) protocol.PacketNumberLen
-	streams               streamFrameSource
+	// The Initial and Handshake packet number spaces are only used in QUIC v1.
+	initialPacketNumberGenerator   *packetNumberGenerator
+	handshakePacketNumberGenerator *packetNumberGenerator
+	getPacketNumberLen             func(protocol.PacketNumber, protocol.EncryptionLevel) protocol.PacketNumberLen
+	streams                        streamFrameSource
+	// In QUIC v1, handshake data is sent in CRYPTO frames,
+	// and Initial and Handshake packets are acknowledged in their own packet number space.
+	cryptoFrames cryptoFrameSource
+	acks         ackFrameSource
 
 	controlFrameMutex sync.Mutex
 	controlFrames     []wire.Frame
@@ -75,7 +95,7 @@ func newPacketPacker(
 	destConnID protocol.ConnectionID,
 	srcConnID protocol.ConnectionID,
 	initialPacketNumber protocol.PacketNumber,
-	getPacketNumberLen func(protocol.PacketNumber) protocol.PacketNumberLen,
+	getPacketNumberLen func(protocol.PacketNumber, protocol.EncryptionLevel) protocol.PacketNumberLen,
 	remoteAddr net.Addr, // only used for determining the max packet size
 	token []byte,
 	divNonce []byte,
@@ -97,7 +117,7 @@ func newPacketPacker(
 			maxPacketSize = protocol.MaxPacketSizeIPv4
 		}
 	}
-	return &packetPacker{
+	p := &packetPacker{
 		cryptoSetup:           cryptoSetup,
 		divNonce:              divNonce,
 		token:                 token,
@@ -110,6 +130,37 @@ func newPacketPacker(
 		packetNumberGenerator: newPacketNumberGenerator(initialPacketNumber, protocol.SkipPacketAveragePeriodLength),
 		maxPacketSize:         maxPacketSize,
 	}
+	if version.UsesPacketNumberSpaces() {
+		p.initialPacketNumberGenerator = newPacketNumberGenerator(0, protocol.SkipPacketAveragePeriodLength)
+		p.handshakePacketNumberGenerator = newPacketNumberGenerator(0, protocol.SkipPacketAveragePeriodLength)
+	}
+	return p
+}
+
+// getPacketNumberGenerator returns the packet number generator for the packet number space of an encryption level
+func (p *packetPacker) getPacketNumberGenerator(encLevel protocol.EncryptionLevel) *packetNumberGenerator {
+	if p.version.UsesPacketNumberSpaces() {
+		switch encLevel {
+		case protocol.EncryptionInitial:
+			return p.initialPacketNumberGenerator
+		case protocol.EncryptionHandshake:
+			return p.handshakePacketNumberGenerator
+		}
+	}
+	return p.packetNumberGenerator
+}
+
+// SetChooseSkip sets the function that chooses the packet numbers that are skipped, see packetNumberGenerator.chooseSkip.
+// It is called with the encryption level of the packet number space.
+func (p *packetPacker) SetChooseSkip(chooseSkip func(protocol.EncryptionLevel, protocol.PacketNumber) protocol.PacketNumber) {
+	setChooseSkip := func(g *packetNumberGenerator, encLevel protocol.EncryptionLevel) {
+		g.chooseSkip = func(pn protocol.PacketNumber) protocol.PacketNumber { return chooseSkip(encLevel, pn) }
+	}
+	if p.version.UsesPacketNumberSpaces() {
+		setChooseSkip(p.initialPacketNumberGenerator, protocol.EncryptionInitial)
+		setChooseSkip(p.handshakePacketNumberGenerator, protocol.EncryptionHandshake)
+	}
+	setChooseSkip(p.packetNumberGenerator, protocol.EncryptionForwardSecure)
 }
 
 // PackConnectionClose packs a packet that ONLY contains a ConnectionCloseFrame
@@ -117,7 +168,7 @@ func (p *packetPacker) PackConnectionClose(ccf *wire.ConnectionCloseFrame) (*pac
 	frames := []wire.Frame{ccf}
 	encLevel, sealer := p.cryptoSetup.GetSealer()
 	header := p.getHeader(encLevel)
-	raw, err := p.writeAndSealPacket(header, frames, sealer)
+	raw, err := p.writeAndSealPacket(header, frames, encLevel, sealer)
 	return &packedPacket{
 		header:          header,
 		raw:             raw,
@@ -127,6 +178,9 @@ func (p *packetPacker) PackConnectionClose(ccf *wire.ConnectionCloseFrame) (*pac
 }
 
 func (p *packetPacker) PackAckPacket() (*packedPacket, error) {
+	if p.version.UsesPacketNumberSpaces() {
+		return p.packAckPacketV1()
+	}
 	if p.ackFrame == nil {
 		return nil, errors.New("packet packer BUG: no ack frame queued")
 	}
@@ -140,7 +194,7 @@ func (p *packetPacker) PackAckPacket() (*packedPacket, error) {
 		p.stopWaiting = nil
 	}
 	p.ackFrame = nil
-	raw, err := p.writeAndSealPacket(header, frames, sealer)
+	raw, err := p.writeAndSealPacket(header, frames, encLevel, sealer)
 	return &packedPacket{
 		header:          header,
 		raw:             raw,
@@ -238,7 +292,7 @@ func (p *packetPacker) PackRetransmission(packet *ackhandler.Packet) ([]*packedP
 		if sf, ok := frames[len(frames)-1].(*wire.StreamFrame); ok {
 			sf.DataLenPresent = false
 		}
-		raw, err := p.writeAndSealPacket(header, frames, sealer)
+		raw, err := p.writeAndSealPacket(header, frames, encLevel, sealer)
 		if err != nil {
 			return nil, err
 		}
@@ -278,7 +332,7 @@ func (p *packetPacker) packHandshakeRetransmission(packet *ackhandler.Packet) (*
 	} else {
 		frames = packet.Frames
 	}
-	raw, err := p.writeAndSealPacket(header, frames, sealer)
+	raw, err := p.writeAndSealPacket(header, frames, packet.EncryptionLevel, sealer)
 	return &packedPacket{
 		header:          header,
 		raw:             raw,
@@ -290,6 +344,9 @@ func (p *packetPacker) packHandshakeRetransmission(packet *ackhandler.Packet) (*
 // PackPacket packs a new packet
 // the other controlFrames are sent in the next packet, but might be queued and sent in the next packet if the packet would overflow MaxPacketSize otherwise
 func (p *packetPacker) PackPacket() (*packedPacket, error) {
+	if p.version.UsesCryptoFrames() {
+		return p.packPacketV1()
+	}
 	hasCryptoStreamFrame := p.streams.HasCryptoStreamData()
 	// if this is the first packet to be send, make sure it contains stream data
 	if !p.hasSentPacket && !hasCryptoStreamFrame {
@@ -300,7 +357,11 @@ func (p *packetPacker) PackPacket() (*packedPacket, error) {
 	}
 
 	encLevel, sealer := p.cryptoSetup.GetSealer()
+	return p.packPacketWithSealer(encLevel, sealer)
+}
 
+// packPacketWithSealer packs a packet containing ACK, control and STREAM frames
+func (p *packetPacker) packPacketWithSealer(encLevel protocol.EncryptionLevel, sealer handshake.Sealer) (*packedPacket, error) {
 	header := p.getHeader(encLevel)
 	headerLength, err := header.GetLength(p.version)
 	if err != nil {
@@ -341,7 +402,7 @@ func (p *packetPacker) PackPacket() (*packedPacket, error) {
 	p.stopWaiting = nil
 	p.ackFrame = nil
 
-	raw, err := p.writeAndSealPacket(header, payloadFrames, sealer)
+	raw, err := p.writeAndSealPacket(header, payloadFrames, encLevel, sealer)
 	if err != nil {
 		return nil, err
 	}
@@ -364,7 +425,7 @@ func (p *packetPacker) packCryptoPacket() (*packedPacket, error) {
 	sf := p.streams.PopCryptoStreamFrame(maxLen)
 	sf.DataLenPresent = false
 	frames := []wire.Frame{sf}
-	raw, err := p.writeAndSealPacket(header, frames, sealer)
+	raw, err := p.writeAndSealPacket(header, frames, encLevel, sealer)
 	if err != nil {
 		return nil, err
 	}
@@ -451,8 +512,8 @@ func (p *packetPacker) QueueControlFrame(frame wire.Frame) {
 }
 
 func (p *packetPacker) getHeader(encLevel protocol.EncryptionLevel) *wire.Header {
-	pnum := p.packetNumberGenerator.Peek()
-	packetNumberLen := p.getPacketNumberLen(pnum)
+	pnum := p.getPacketNumberGenerator(encLevel).Peek()
+	packetNumberLen := p.getPacketNumberLen(pnum, encLevel)
 
 	header := &wire.Header{
 		PacketNumber:    pnum,
@@ -463,13 +524,23 @@ func (p *packetPacker) getHeader(encLevel protocol.EncryptionLevel) *wire.Header
 	if p.version.UsesIETFHeaderFormat() && encLevel != protocol.EncryptionForwardSecure {
 		header.IsLongHeader = true
 		header.SrcConnectionID = p.srcConnID
-		if !p.version.UsesVarintPacketNumbers() {
+		if !p.version.UsesVarintPacketNumbers() && !p.version.UsesV1HeaderFormat() {
 			header.PacketNumberLen = protocol.PacketNumberLen4
 		}
 		// Set the payload len to maximum size.
 		// Since it is encoded as a varint, this guarantees us that the header will end up at most as big as GetLength() returns.
 		header.PayloadLen = p.maxPacketSize
-		if !p.hasSentPacket && p.perspective == protocol.PerspectiveClient {
+		if p.version.UsesPacketNumberSpaces() {
+			// In QUIC v1, the packet type is determined by the encryption level.
+			// Only the client sends a token.
+			header.Type = protocol.PacketTypeHandshake
+			if encLevel == protocol.EncryptionInitial {
+				header.Type = protocol.PacketTypeInitial
+				if p.perspective == protocol.PerspectiveClient {
+					header.Token = p.token
+				}
+			}
+		} else if !p.hasSentPacket && p.perspective == protocol.PerspectiveClient {
 			header.Type = protocol.PacketTypeInitial
 			header.Token = p.token
 		} else {
@@ -495,22 +566,51 @@ func (p *packetPacker) getHeader(encLevel protocol.EncryptionLevel) *wire.Header
 func (p *packetPacker) writeAndSealPacket(
 	header *wire.Header,
 	payloadFrames []wire.Frame,
+	encLevel protocol.EncryptionLevel,
 	sealer handshake.Sealer,
 ) ([]byte, error) {
 	raw := *getPacketBuffer()
 	buffer := bytes.NewBuffer(raw[:0])
 
+	if !header.IsLongHeader {
+		if s, ok := sealer.(keyPhaseSealer); ok {
+			header.KeyPhase = s.KeyPhase()
+		}
+	}
+
+	var hp crypto.HeaderProtector
+	if s, ok := sealer.(headerProtectingSealer); ok {
+		hp = s.HeaderProtector()
+	}
+
+	var framesLen protocol.ByteCount
+	for _, frame := range payloadFrames {
+		framesLen += frame.Length(p.version)
+	}
+	// For header protection, the ciphertext is sampled at a fixed offset from the packet number.
+	// Small packets need to be padded.
+	var paddingLen protocol.ByteCount
+	if hp != nil {
+		minLen := protocol.ByteCount(crypto.HeaderProtectionSampleOffset+crypto.HeaderProtectionSampleLen-sealer.Overhead()) - protocol.ByteCount(header.PacketNumberLen)
+		if framesLen < minLen {
+			paddingLen = minLen - framesLen
+		}
+	}
+
 	// the payload length is only needed for Long Headers
 	if header.IsLongHeader {
 		if header.Type == protocol.PacketTypeInitial {
 			headerLen, _ := header.GetLength(p.version)
-			header.PayloadLen = protocol.ByteCount(protocol.MinInitialPacketSize) - headerLen
+			header.PayloadLen = utils.MaxByteCount(
+				protocol.ByteCount(protocol.MinInitialPacketSize)-headerLen,
+				framesLen+protocol.ByteCount(sealer.Overhead()),
+			)
 		} else {
-			payloadLen := protocol.ByteCount(sealer.Overhead())
-			for _, frame := range payloadFrames {
-				payloadLen += frame.Length(p.version)
-			}
-			header.PayloadLen = payloadLen
+			header.PayloadLen = framesLen + paddingLen + protocol.ByteCount(sealer.Overhead())
+		}
+		// In QUIC v1, the length includes the packet number.
+		if p.version.UsesV1HeaderFormat() {
+			header.PayloadLen += protocol.ByteCount(header.PacketNumberLen)
 		}
 	}
 
@@ -538,6 +638,8 @@ func (p *packetPacker) writeAndSealPacket(
 		if paddingLen > 0 {
 			buffer.Write(bytes.Repeat([]byte{0}, paddingLen))
 		}
+	} else if paddingLen > 0 {
+		buffer.Write(bytes.Repeat([]byte{0}, int(paddingLen)))
 	}
 
 	if size := protocol.ByteCount(buffer.Len() + sealer.Overhead()); size > p.maxPacketSize {
@@ -548,7 +650,13 @@ func (p *packetPacker) writeAndSealPacket(
 	_ = sealer.Seal(raw[payloadStartIndex:payloadStartIndex], raw[payloadStartIndex:], header.PacketNumber, raw[:payloadStartIndex])
 	raw = raw[0 : buffer.Len()+sealer.Overhead()]
 
-	num := p.packetNumberGenerator.Pop()
+	if hp != nil {
+		pnOffset := payloadStartIndex - int(header.PacketNumberLen)
+		sampleOffset := pnOffset + crypto.HeaderProtectionSampleOffset
+		hp.EncryptHeader(raw[sampleOffset:sampleOffset+crypto.HeaderProtectionSampleLen], &raw[0], raw[pnOffset:payloadStartIndex])
+	}
+
+	num := p.getPacketNumberGenerator(encLevel).Pop()
 	if num != header.PacketNumber {
 		return nil, errors.New("packetPacker BUG: Peeked and Popped packet numbers do not match")
 	}
@@ -6,6 +6,7 @@ import (
 
 	"github.com/golang/mock/gomock"
 	"github.com/wheelcomplex/qk/internal/ackhandler"
+	"github.com/wheelcomplex/qk/internal/crypto"
 	"github.com/wheelcomplex/qk/internal/handshake"
 	"github.com/wheelcomplex/qk/internal/protocol"
 	"github.com/wheelcomplex/qk/internal/wire"
@@ -23,6 +24,20 @@ func (s *mockSealer) Overhead() int { return 12 }
 
 var _ handshake.Sealer = &mockSealer{}
 
+type mockKeyPhaseSealer struct {
+	mockSealer
+	keyPhase int
+}
+
+func (s *mockKeyPhaseSealer) KeyPhase() int { return s.keyPhase }
+
+type mockHeaderProtectingSealer struct {
+	mockKeyPhaseSealer
+	hp crypto.HeaderProtector
+}
+
+func (s *mockHeaderProtectingSealer) HeaderProtector() crypto.HeaderProtector { return s.hp }
+
 type mockCryptoSetup struct {
 	handleErr          error
 	encLevelSeal       protocol.EncryptionLevel
@@ -85,7 +100,9 @@ var _ = Describe("Packet packer", func() {
 			protocol.ConnectionID{1, 2, 3, 4, 5, 6, 7, 8},
 			protocol.ConnectionID{1, 2, 3, 4, 5, 6, 7, 8},
 			1,
-			func(protocol.PacketNumber) protocol.PacketNumberLen { return protocol.PacketNumberLen2 },
+			func(protocol.PacketNumber, protocol.EncryptionLevel) protocol.PacketNumberLen {
+				return protocol.PacketNumberLen2
+			},
 			&net.TCPAddr{},
 			token, // token
 			divNonce,
@@ -314,6 +331,97 @@ var _ = Describe("Packet packer", func() {
 				Expect(h.IsLongHeader).To(BeFalse())
 				Expect(h.PacketNumberLen).To(BeNumerically(">", 0))
 			})
+
+			It("sets the key phase used by the sealer", func() {
+				h := packer.getHeader(protocol.EncryptionForwardSecure)
+				raw, err := packer.writeAndSealPacket(h, []wire.Frame{&wire.PingFrame{}}, protocol.EncryptionForwardSecure, &mockKeyPhaseSealer{keyPhase: 1})
+				Expect(err).ToNot(HaveOccurred())
+				r := bytes.NewReader(raw)
+				iHdr, err := wire.ParseInvariantHeader(r, packer.destConnID.Len())
+				Expect(err).ToNot(HaveOccurred())
+				hdr, err := iHdr.Parse(r, protocol.PerspectiveServer, versionIETFHeader)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(hdr.KeyPhase).To(Equal(1))
+			})
+
+			Context("header protection", func() {
+				var sealer *mockHeaderProtectingSealer
+				var peerHP crypto.HeaderProtector
+
+				BeforeEach(func() {
+					hp, err := crypto.NewAESHeaderProtector(bytes.Repeat([]byte{'s'}, 16), bytes.Repeat([]byte{'c'}, 16))
+					Expect(err).ToNot(HaveOccurred())
+					peerHP, err = crypto.NewAESHeaderProtector(bytes.Repeat([]byte{'c'}, 16), bytes.Repeat([]byte{'s'}, 16))
+					Expect(err).ToNot(HaveOccurred())
+					sealer = &mockHeaderProtectingSealer{
+						mockKeyPhaseSealer: mockKeyPhaseSealer{keyPhase: 1},
+						hp:                 hp,
+					}
+				})
+
+				// parseAndUnprotect parses the header, and removes header protection
+				parseAndUnprotect := func(raw []byte) (*wire.Header, []byte) {
+					r := bytes.NewReader(raw)
+					iHdr, err := wire.ParseInvariantHeader(r, packer.destConnID.Len())
+					Expect(err).ToNot(HaveOccurred())
+					hdr, err := iHdr.Parse(r, protocol.PerspectiveClient, versionIETFHeader)
+					Expect(err).ToNot(HaveOccurred())
+					pnOffset := len(raw) - r.Len() - int(hdr.PacketNumberLen)
+					sampleOffset := pnOffset + crypto.HeaderProtectionSampleOffset
+					Expect(len(raw)).To(BeNumerically(">=", sampleOffset+crypto.HeaderProtectionSampleLen))
+					unprotected := make([]byte, len(raw))
+					copy(unprotected, raw)
+					peerHP.DecryptHeader(raw[sampleOffset:sampleOffset+crypto.HeaderProtectionSampleLen], &unprotected[0], unprotected[pnOffset:len(raw)-r.Len()])
+					r = bytes.NewReader(unprotected)
+					iHdr, err = wire.ParseInvariantHeader(r, packer.destConnID.Len())
+					Expect(err).ToNot(HaveOccurred())
+					hdr, err = iHdr.Parse(r, protocol.PerspectiveClient, versionIETFHeader)
+					Expect(err).ToNot(HaveOccurred())
+					return hdr, unprotected
+				}
+
+				It("protects the packet number and the key phase", func() {
+					h := packer.getHeader(protocol.EncryptionForwardSecure)
+					h.PacketNumberLen = protocol.PacketNumberLen2
+					raw, err := packer.writeAndSealPacket(h, []wire.Frame{&wire.MaxDataFrame{ByteOffset: 0x42}, &wire.PingFrame{}}, protocol.EncryptionForwardSecure, sealer)
+					Expect(err).ToNot(HaveOccurred())
+					hdr, unprotected := parseAndUnprotect(raw)
+					Expect(unprotected).ToNot(Equal(raw))
+					Expect(hdr.PacketNumber).To(Equal(h.PacketNumber))
+					Expect(hdr.KeyPhase).To(Equal(1))
+				})
+
+				It("pads small packets, such that the ciphertext can be sampled", func() {
+					h := packer.getHeader(protocol.EncryptionForwardSecure)
+					h.PacketNumberLen = protocol.PacketNumberLen1
+					raw, err := packer.writeAndSealPacket(h, []wire.Frame{&wire.PingFrame{}}, protocol.EncryptionForwardSecure, sealer)
+					Expect(err).ToNot(HaveOccurred())
+					hdr, unprotected := parseAndUnprotect(raw)
+					Expect(hdr.PacketNumber).To(Equal(h.PacketNumber))
+					hdrLen, err := hdr.GetLength(versionIETFHeader)
+					Expect(err).ToNot(HaveOccurred())
+					// the mockSealer doesn't encrypt the payload
+					r := bytes.NewReader(unprotected[hdrLen : len(unprotected)-sealer.Overhead()])
+					Expect(r.Len()).To(BeNumerically(">", 1))
+					frame, err := wire.ParseNextFrame(r, hdr, versionIETFHeader)
+					Expect(err).ToNot(HaveOccurred())
+					Expect(frame).To(Equal(&wire.PingFrame{}))
+					frame, err = wire.ParseNextFrame(r, hdr, versionIETFHeader)
+					Expect(err).ToNot(HaveOccurred())
+					Expect(frame).To(BeNil())
+				})
+
+				It("pads small Long Header packets and sets the payload length", func() {
+					packer.version = versionIETFFrames
+					h := packer.getHeader(protocol.EncryptionUnencrypted)
+					h.Type = protocol.PacketTypeHandshake
+					h.PacketNumberLen = protocol.PacketNumberLen1
+					raw, err := packer.writeAndSealPacket(h, []wire.Frame{&wire.PingFrame{}}, protocol.EncryptionUnencrypted, sealer)
+					Expect(err).ToNot(HaveOccurred())
+					_, unprotected := parseAndUnprotect(raw)
+					checkPayloadLen(unprotected)
+				})
+			})
 		})
 	})
 
@@ -488,6 +596,17 @@ var _ = Describe("Packet packer", func() {
 		Expect(packer.packetNumberGenerator.Peek()).To(Equal(protocol.PacketNumber(2)))
 	})
 
+	It("sets the function choosing the packet numbers to skip", func() {
+		var encLevels []protocol.EncryptionLevel
+		packer.SetChooseSkip(func(encLevel protocol.EncryptionLevel, pn protocol.PacketNumber) protocol.PacketNumber {
+			encLevels = append(encLevels, encLevel)
+			return 42
+		})
+		packer.packetNumberGenerator.generateNewSkip()
+		Expect(packer.packetNumberGenerator.nextToSkip).To(Equal(protocol.PacketNumber(42)))
+		Expect(encLevels).To(Equal([]protocol.EncryptionLevel{protocol.EncryptionForwardSecure}))
+	})
+
 	Context("making ACK packets retransmittable", func() {
 		sendMaxNumNonRetransmittableAcks := func() {
 			mockStreamFramer.EXPECT().HasCryptoStreamData().Times(protocol.MaxNonRetransmittableAcks)
@@ -0,0 +1,156 @@
+package quic
+
+import (
+	"net"
+
+	"github.com/wheelcomplex/qk/internal/protocol"
+	"github.com/wheelcomplex/qk/internal/wire"
+)
+
+type cryptoFrameSource interface {
+	HasData(protocol.EncryptionLevel) bool
+	PopCryptoFrame(protocol.EncryptionLevel, protocol.ByteCount) *wire.CryptoFrame
+}
+
+type ackFrameSource interface {
+	GetAckFrameWithEncryptionLevel(protocol.EncryptionLevel) *wire.AckFrame
+}
+
+// newPacketPackerV1 creates a packet packer for QUIC v1
+func newPacketPackerV1(
+	destConnID protocol.ConnectionID,
+	srcConnID protocol.ConnectionID,
+	getPacketNumberLen func(protocol.PacketNumber, protocol.EncryptionLevel) protocol.PacketNumberLen,
+	remoteAddr net.Addr, // only used for determining the max packet size
+	token []byte,
+	cryptoSetup sealingManager,
+	cryptoFrames cryptoFrameSource,
+	acks ackFrameSource,
+	streamFramer streamFrameSource,
+	perspective protocol.Perspective,
+	version protocol.VersionNumber,
+) *packetPacker {
+	p := newPacketPacker(
+		destConnID,
+		srcConnID,
+		0, // packet numbers start at 0 in every packet number space
+		getPacketNumberLen,
+		remoteAddr,
+		token,
+		nil, // no diversification nonce
+		cryptoSetup,
+		streamFramer,
+		perspective,
+		version,
+	)
+	p.cryptoFrames = cryptoFrames
+	p.acks = acks
+	return p
+}
+
+// packPacketV1 packs the next packet for QUIC v1.
+// Packets carrying Initial and Handshake data are packed first.
+// All other frames are only sent in 1-RTT packets.
+func (p *packetPacker) packPacketV1() (*packedPacket, error) {
+	for _, encLevel := range []protocol.EncryptionLevel{protocol.EncryptionInitial, protocol.EncryptionHandshake} {
+		packet, err := p.maybePackHandshakePacketV1(encLevel, false)
+		if err != nil || packet != nil {
+			return packet, err
+		}
+	}
+
+	encLevel, sealer := p.cryptoSetup.GetSealer()
+	if encLevel != protocol.EncryptionForwardSecure {
+		return nil, nil
+	}
+	// post-handshake messages (e.g. session tickets) are sent in CRYPTO frames in 1-RTT packets
+	for p.cryptoFrames.HasData(protocol.EncryptionForwardSecure) {
+		f := p.cryptoFrames.PopCryptoFrame(protocol.EncryptionForwardSecure, p.maxPacketSize/2)
+		if f == nil {
+			break
+		}
+		p.QueueControlFrame(f)
+	}
+	return p.packPacketWithSealer(encLevel, sealer)
+}
+
+// packAckPacketV1 packs a packet that only contains an ACK frame.
+// ACKs for Initial and Handshake packets are sent first.
+func (p *packetPacker) packAckPacketV1() (*packedPacket, error) {
+	for _, encLevel := range []protocol.EncryptionLevel{protocol.EncryptionInitial, protocol.EncryptionHandshake} {
+		packet, err := p.maybePackHandshakePacketV1(encLevel, true)
+		if err != nil || packet != nil {
+			return packet, err
+		}
+	}
+	if p.ackFrame == nil {
+		return nil, nil
+	}
+	encLevel, sealer := p.cryptoSetup.GetSealer()
+	if encLevel != protocol.EncryptionForwardSecure {
+		return nil, nil
+	}
+	header := p.getHeader(encLevel)
+	frames := []wire.Frame{p.ackFrame}
+	p.ackFrame = nil
+	raw, err := p.writeAndSealPacket(header, frames, encLevel, sealer)
+	if err != nil {
+		return nil, err
+	}
+	return &packedPacket{
+		header:          header,
+		raw:             raw,
+		frames:          frames,
+		encryptionLevel: encLevel,
+	}, nil
+}
+
+// maybePackHandshakePacketV1 packs an Initial or a Handshake packet,
+// containing an ACK for the packet number space, and (unless onlyAck is set) CRYPTO frames.
+// It returns nil if there's nothing to send at this encryption level, or if the keys are not available.
+func (p *packetPacker) maybePackHandshakePacketV1(encLevel protocol.EncryptionLevel, onlyAck bool) (*packedPacket, error) {
+	sealer, err := p.cryptoSetup.GetSealerWithEncryptionLevel(encLevel)
+	if err != nil || sealer == nil {
+		return nil, nil
+	}
+	hasData := !onlyAck && p.cryptoFrames.HasData(encLevel)
+	ack := p.acks.GetAckFrameWithEncryptionLevel(encLevel)
+	if ack == nil && !hasData {
+		return nil, nil
+	}
+
+	header := p.getHeader(encLevel)
+	headerLength, err := header.GetLength(p.version)
+	if err != nil {
+		return nil, err
+	}
+	maxSize := p.maxPacketSize - protocol.ByteCount(sealer.Overhead()) - headerLength
+	if encLevel == protocol.EncryptionInitial {
+		// Initial packets are padded to the minimum size, so there's no need to use a bigger packet
+		maxSize = protocol.MinInitialPacketSize - protocol.ByteCount(sealer.Overhead()) - headerLength
+	}
+	var frames []wire.Frame
+	var payloadLength protocol.ByteCount
+	if ack != nil {
+		frames = append(frames, ack)
+		payloadLength += ack.Length(p.version)
+	}
+	if hasData {
+		if f := p.cryptoFrames.PopCryptoFrame(encLevel, maxSize-payloadLength); f != nil {
+			frames = append(frames, f)
+		}
+	}
+	if len(frames) == 0 {
+		return nil, nil
+	}
+	raw, err := p.writeAndSealPacket(header, frames, encLevel, sealer)
+	if err != nil {
+		return nil, err
+	}
+	return &packedPacket{
+		header:          header,
+		raw:             raw,
+		frames:          frames,
+		encryptionLevel: encLevel,
+	}, nil
+}
@@ -0,0 +1,337 @@
+package quic
+
+import (
+	"bytes"
+	"errors"
+	"net"
+
+	"github.com/golang/mock/gomock"
+	"github.com/wheelcomplex/qk/internal/crypto"
+	"github.com/wheelcomplex/qk/internal/handshake"
+	"github.com/wheelcomplex/qk/internal/protocol"
+	"github.com/wheelcomplex/qk/internal/wire"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// mockSealingManagerV1 returns the sealers of the encryption levels whose keys are available
+type mockSealingManagerV1 struct {
+	sealers map[protocol.EncryptionLevel]handshake.Sealer
+}
+
+var _ sealingManager = &mockSealingManagerV1{}
+
+func (m *mockSealingManagerV1) GetSealer() (protocol.EncryptionLevel, handshake.Sealer) {
+	for _, encLevel := range []protocol.EncryptionLevel{protocol.EncryptionForwardSecure, protocol.EncryptionHandshake} {
+		if sealer, ok := m.sealers[encLevel]; ok {
+			return encLevel, sealer
+		}
+	}
+	return protocol.EncryptionInitial, m.sealers[protocol.EncryptionInitial]
+}
+
+func (m *mockSealingManagerV1) GetSealerForCryptoStream() (protocol.EncryptionLevel, handshake.Sealer) {
+	panic("not implemented")
+}
+
+func (m *mockSealingManagerV1) GetSealerWithEncryptionLevel(encLevel protocol.EncryptionLevel) (handshake.Sealer, error) {
+	if sealer, ok := m.sealers[encLevel]; ok {
+		return sealer, nil
+	}
+	return nil, errors.New("no sealer")
+}
+
+// mockAckFrameSource returns every queued ACK frame once
+type mockAckFrameSource map[protocol.EncryptionLevel]*wire.AckFrame
+
+func (m mockAckFrameSource) GetAckFrameWithEncryptionLevel(encLevel protocol.EncryptionLevel) *wire.AckFrame {
+	ack := m[encLevel]
+	delete(m, encLevel)
+	return ack
+}
+
+var _ = Describe("Packet packer (for QUIC v1)", func() {
+	var (
+		packer           *packetPacker
+		cryptoSetup      *mockSealingManagerV1
+		cryptoStreams    *cryptoStreamManager
+		acks             mockAckFrameSource
+		mockStreamFramer *MockStreamFrameSource
+		destConnID       = protocol.ConnectionID{1, 2, 3, 4, 5, 6, 7, 8}
+		srcConnID        = protocol.ConnectionID{8, 7, 6, 5, 4, 3, 2, 1}
+		token            = []byte("token")
+	)
+
+	newPacker := func(pers protocol.Perspective) *packetPacker {
+		return newPacketPackerV1(
+			destConnID,
+			srcConnID,
+			func(protocol.PacketNumber, protocol.EncryptionLevel) protocol.PacketNumberLen {
+				return protocol.PacketNumberLen2
+			},
+			&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1337},
+			token,
+			cryptoSetup,
+			cryptoStreams,
+			acks,
+			mockStreamFramer,
+			pers,
+			protocol.Version1,
+		)
+	}
+
+	ackFrame := func(largest protocol.PacketNumber) *wire.AckFrame {
+		return &wire.AckFrame{AckRanges: []wire.AckRange{{Smallest: 0, Largest: largest}}}
+	}
+
+	BeforeEach(func() {
+		cryptoSetup = &mockSealingManagerV1{sealers: map[protocol.EncryptionLevel]handshake.Sealer{
+			protocol.EncryptionInitial: &mockSealer{},
+		}}
+		cryptoStreams = newCryptoStreamManager(func() {})
+		acks = make(mockAckFrameSource)
+		mockStreamFramer = NewMockStreamFrameSource(mockCtrl)
+		packer = newPacker(protocol.PerspectiveClient)
+	})
+
+	Context("choosing the encryption level", func() {
+		It("doesn't pack anything if there's no data", func() {
+			p, err := packer.PackPacket()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(p).To(BeNil())
+		})
+
+		It("packs Initial packets", func() {
+			cryptoStreams.initialStream.Write([]byte("ClientHello"))
+			p, err := packer.PackPacket()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(p.encryptionLevel).To(Equal(protocol.EncryptionInitial))
+			Expect(p.header.IsLongHeader).To(BeTrue())
+			Expect(p.header.Type).To(Equal(protocol.PacketTypeInitial))
+			Expect(p.header.Token).To(Equal(token))
+			Expect(p.header.SrcConnectionID).To(Equal(srcConnID))
+			Expect(p.header.DestConnectionID).To(Equal(destConnID))
+			Expect(p.frames).To(Equal([]wire.Frame{&wire.CryptoFrame{Data: []byte("ClientHello")}}))
+			// Initial packets are padded
+			Expect(p.raw).To(HaveLen(protocol.MinInitialPacketSize))
+		})
+
+		It("doesn't send a token in Initial packets sent by the server", func() {
+			packer = newPacker(protocol.PerspectiveServer)
+			cryptoStreams.initialStream.Write([]byte("ServerHello"))
+			p, err := packer.PackPacket()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(p.header.Type).To(Equal(protocol.PacketTypeInitial))
+			Expect(p.header.Token).To(BeEmpty())
+		})
+
+		It("packs Initial data before Handshake data, and Handshake data before 1-RTT data", func() {
+			cryptoSetup.sealers[protocol.EncryptionHandshake] = &mockSealer{}
+			cryptoSetup.sealers[protocol.EncryptionForwardSecure] = &mockSealer{}
+			cryptoStreams.handshakeStream.Write([]byte("Finished"))
+			cryptoStreams.initialStream.Write([]byte("ServerHello"))
+			p, err := packer.PackPacket()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(p.encryptionLevel).To(Equal(protocol.EncryptionInitial))
+			Expect(p.frames).To(Equal([]wire.Frame{&wire.CryptoFrame{Data: []byte("ServerHello")}}))
+			p, err = packer.PackPacket()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(p.encryptionLevel).To(Equal(protocol.EncryptionHandshake))
+			Expect(p.header.IsLongHeader).To(BeTrue())
+			Expect(p.header.Type).To(Equal(protocol.PacketTypeHandshake))
+			Expect(p.header.Token).To(BeEmpty())
+			Expect(p.frames).To(Equal([]wire.Frame{&wire.CryptoFrame{Data: []byte("Finished")}}))
+			f := &wire.StreamFrame{StreamID: 4, Data: []byte("foobar")}
+			mockStreamFramer.EXPECT().PopStreamFrames(gomock.Any()).Return([]*wire.StreamFrame{f})
+			p, err = packer.PackPacket()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(p.encryptionLevel).To(Equal(protocol.EncryptionForwardSecure))
+			Expect(p.header.IsLongHeader).To(BeFalse())
+			Expect(p.frames).To(Equal([]wire.Frame{f}))
+		})
+
+		It("skips encryption levels whose keys are not available", func() {
+			delete(cryptoSetup.sealers, protocol.EncryptionInitial)
+			cryptoSetup.sealers[protocol.EncryptionHandshake] = &mockSealer{}
+			cryptoStreams.initialStream.Write([]byte("ClientHello"))
+			cryptoStreams.handshakeStream.Write([]byte("Finished"))
+			acks[protocol.EncryptionInitial] = ackFrame(1)
+			p, err := packer.PackPacket()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(p.encryptionLevel).To(Equal(protocol.EncryptionHandshake))
+			Expect(p.frames).To(Equal([]wire.Frame{&wire.CryptoFrame{Data: []byte("Finished")}}))
+		})
+
+		It("doesn't pack 1-RTT packets before the 1-RTT keys are available", func() {
+			cryptoSetup.sealers[protocol.EncryptionHandshake] = &mockSealer{}
+			packer.QueueControlFrame(&wire.PingFrame{})
+			p, err := packer.PackPacket()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(p).To(BeNil())
+		})
+
+		It("sends post-handshake CRYPTO frames in 1-RTT packets", func() {
+			cryptoSetup.sealers[protocol.EncryptionForwardSecure] = &mockSealer{}
+			cryptoStreams.oneRTTStream.Write([]byte("NewSessionTicket"))
+			mockStreamFramer.EXPECT().PopStreamFrames(gomock.Any())
+			p, err := packer.PackPacket()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(p.encryptionLevel).To(Equal(protocol.EncryptionForwardSecure))
+			Expect(p.frames).To(Equal([]wire.Frame{&wire.CryptoFrame{Data: []byte("NewSessionTicket")}}))
+		})
+
+		It("limits the size of CRYPTO frames", func() {
+			cryptoSetup.sealers[protocol.EncryptionHandshake] = &mockSealer{}
+			cryptoStreams.initialStream.Write(bytes.Repeat([]byte{'i'}, 2000))
+			cryptoStreams.handshakeStream.Write(bytes.Repeat([]byte{'h'}, 2000))
+			var initialData, handshakeData int
+			for {
+				p, err := packer.PackPacket()
+				Expect(err).ToNot(HaveOccurred())
+				if p == nil {
+					break
+				}
+				if p.encryptionLevel == protocol.EncryptionInitial {
+					Expect(p.raw).To(HaveLen(protocol.MinInitialPacketSize))
+					initialData += len(p.frames[0].(*wire.CryptoFrame).Data)
+				} else {
+					Expect(len(p.raw)).To(BeNumerically("<=", packer.maxPacketSize))
+					handshakeData += len(p.frames[0].(*wire.CryptoFrame).Data)
+				}
+			}
+			Expect(initialData).To(Equal(2000))
+			Expect(handshakeData).To(Equal(2000))
+		})
+	})
+
+	Context("packet number spaces", func() {
+		It("bundles the ACK with the CRYPTO frame of the same packet number space", func() {
+			cryptoSetup.sealers[protocol.EncryptionHandshake] = &mockSealer{}
+			initialAck := ackFrame(3)
+			handshakeAck := ackFrame(5)
+			acks[protocol.EncryptionInitial] = initialAck
+			acks[protocol.EncryptionHandshake] = handshakeAck
+			cryptoStreams.handshakeStream.Write([]byte("Finished"))
+			p, err := packer.PackPacket()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(p.encryptionLevel).To(Equal(protocol.EncryptionInitial))
+			Expect(p.frames).To(Equal([]wire.Frame{initialAck}))
+			p, err = packer.PackPacket()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(p.encryptionLevel).To(Equal(protocol.EncryptionHandshake))
+			Expect(p.frames).To(Equal([]wire.Frame{handshakeAck, &wire.CryptoFrame{Data: []byte("Finished")}}))
+		})
+
+		It("uses separate packet numbers for every packet number space", func() {
+			cryptoSetup.sealers[protocol.EncryptionHandshake] = &mockSealer{}
+			cryptoSetup.sealers[protocol.EncryptionForwardSecure] = &mockSealer{}
+			var pns []protocol.PacketNumber
+			for _, str := range []*cryptoStreamV1{cryptoStreams.initialStream, cryptoStreams.initialStream, cryptoStreams.handshakeStream, cryptoStreams.oneRTTStream} {
+				str.Write([]byte("foobar"))
+				if str == cryptoStreams.oneRTTStream {
+					mockStreamFramer.EXPECT().PopStreamFrames(gomock.Any())
+				}
+				p, err := packer.PackPacket()
+				Expect(err).ToNot(HaveOccurred())
+				pns = append(pns, p.header.PacketNumber)
+			}
+			Expect(pns).To(Equal([]protocol.PacketNumber{0, 1, 0, 0}))
+		})
+
+		It("packs ACK-only packets for every packet number space", func() {
+			cryptoSetup.sealers[protocol.EncryptionHandshake] = &mockSealer{}
+			cryptoSetup.sealers[protocol.EncryptionForwardSecure] = &mockSealer{}
+			// CRYPTO frames are not sent in ACK-only packets
+			cryptoStreams.initialStream.Write([]byte("ClientHello"))
+			initialAck := ackFrame(1)
+			handshakeAck := ackFrame(2)
+			oneRTTAck := ackFrame(3)
+			acks[protocol.EncryptionInitial] = initialAck
+			acks[protocol.EncryptionHandshake] = handshakeAck
+			packer.QueueControlFrame(oneRTTAck)
+			p, err := packer.PackAckPacket()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(p.encryptionLevel).To(Equal(protocol.EncryptionInitial))
+			Expect(p.frames).To(Equal([]wire.Frame{initialAck}))
+			Expect(p.raw).To(HaveLen(protocol.MinInitialPacketSize))
+			p, err = packer.PackAckPacket()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(p.encryptionLevel).To(Equal(protocol.EncryptionHandshake))
+			Expect(p.frames).To(Equal([]wire.Frame{handshakeAck}))
+			p, err = packer.PackAckPacket()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(p.encryptionLevel).To(Equal(protocol.EncryptionForwardSecure))
+			Expect(p.header.IsLongHeader).To(BeFalse())
+			Expect(p.frames).To(Equal([]wire.Frame{oneRTTAck}))
+			p, err = packer.PackAckPacket()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(p).To(BeNil())
+			Expect(cryptoStreams.HasData(protocol.EncryptionInitial)).To(BeTrue())
+		})
+
+		It("doesn't pack 1-RTT ACK-only packets before the 1-RTT keys are available", func() {
+			packer.QueueControlFrame(ackFrame(1))
+			p, err := packer.PackAckPacket()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(p).To(BeNil())
+		})
+	})
+
+	Context("header protection", func() {
+		var sealerHP, openerHP crypto.HeaderProtector
+
+		BeforeEach(func() {
+			var err error
+			sealerHP, err = crypto.NewAESHeaderProtector(bytes.Repeat([]byte{'s'}, 16), bytes.Repeat([]byte{'c'}, 16))
+			Expect(err).ToNot(HaveOccurred())
+			openerHP, err = crypto.NewAESHeaderProtector(bytes.Repeat([]byte{'c'}, 16), bytes.Repeat([]byte{'s'}, 16))
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		// unprotect removes header protection from a packet, and returns the first byte and the packet number
+		unprotect := func(raw []byte, pnOffset int) (byte, []byte) {
+			firstByte := raw[0]
+			pn := append([]byte{}, raw[pnOffset:pnOffset+2]...)
+			sample := raw[pnOffset+crypto.HeaderProtectionSampleOffset : pnOffset+crypto.HeaderProtectionSampleOffset+crypto.HeaderProtectionSampleLen]
+			openerHP.DecryptHeader(sample, &firstByte, pn)
+			return firstByte, pn
+		}
+
+		It("protects the header of 1-RTT packets, and sets the key phase", func() {
+			cryptoSetup.sealers[protocol.EncryptionForwardSecure] = &mockHeaderProtectingSealer{
+				mockKeyPhaseSealer: mockKeyPhaseSealer{keyPhase: 1},
+				hp:                 sealerHP,
+			}
+			packer.QueueControlFrame(&wire.PingFrame{})
+			mockStreamFramer.EXPECT().PopStreamFrames(gomock.Any())
+			p, err := packer.PackPacket()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(p.header.KeyPhase).To(Equal(1))
+			// 1 byte flags, 8 bytes connection ID
+			pnOffset := 1 + destConnID.Len()
+			// small packets are padded, so that the ciphertext can be sampled
+			Expect(len(p.raw)).To(BeNumerically(">=", pnOffset+crypto.HeaderProtectionSampleOffset+crypto.HeaderProtectionSampleLen))
+			firstByte, pn := unprotect(p.raw, pnOffset)
+			Expect(firstByte & 0x4).To(Equal(byte(0x4))) // key phase
+			Expect(firstByte & 0x3).To(Equal(byte(0x1))) // packet number length
+			Expect(firstByte & 0x18).To(BeZero())        // reserved bits
+			Expect(pn).To(Equal([]byte{0, 0}))
+		})
+
+		It("protects the header of Handshake packets", func() {
+			cryptoSetup.sealers[protocol.EncryptionHandshake] = &mockHeaderProtectingSealer{hp: sealerHP}
+			cryptoStreams.handshakeStream.Write([]byte("Finished"))
+			p, err := packer.PackPacket()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(p.encryptionLevel).To(Equal(protocol.EncryptionHandshake))
+			hdrLen, err := p.header.GetLength(protocol.Version1)
+			Expect(err).ToNot(HaveOccurred())
+			pnOffset := int(hdrLen) - 2
+			firstByte, pn := unprotect(p.raw, pnOffset)
+			Expect(firstByte & 0x3).To(Equal(byte(0x1)))
+			Expect(firstByte & 0xc).To(BeZero())
+			Expect(pn).To(Equal([]byte{0, 0}))
+		})
+	})
+})
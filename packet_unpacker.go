@@ -2,8 +2,11 @@ package quic
 
 import (
 	"bytes"
+	"errors"
 
+	"github.com/wheelcomplex/qk/internal/crypto"
 	"github.com/wheelcomplex/qk/internal/protocol"
+	"github.com/wheelcomplex/qk/internal/utils"
 	"github.com/wheelcomplex/qk/internal/wire"
 	"github.com/wheelcomplex/qk/qerr"
 )
@@ -19,7 +22,8 @@ type gQUICAEAD interface {
 
 type quicAEAD interface {
 	OpenHandshake(dst, src []byte, packetNumber protocol.PacketNumber, associatedData []byte) ([]byte, error)
-	Open1RTT(dst, src []byte, packetNumber protocol.PacketNumber, associatedData []byte) ([]byte, error)
+	Open1RTT(dst, src []byte, packetNumber protocol.PacketNumber, keyPhase int, associatedData []byte) ([]byte, error)
+	GetHeaderProtector(protocol.EncryptionLevel) (crypto.HeaderProtector, error)
 }
 
 type packetUnpackerBase struct {
@@ -84,6 +88,9 @@ func (u *packetUnpackerGQUIC) Unpack(headerBinary []byte, hdr *wire.Header, data
 type packetUnpacker struct {
 	packetUnpackerBase
 	aead quicAEAD
+
+	// The packet number is protected, and can only be inferred after removing header protection.
+	largestRcvdPacketNumber protocol.PacketNumber
 }
 
 var _ unpacker = &packetUnpacker{}
@@ -100,20 +107,27 @@ func (u *packetUnpacker) Unpack(headerBinary []byte, hdr *wire.Header, data []by
 	buf = buf[:0]
 	defer putPacketBuffer(&buf)
 
+	encryptionLevel := protocol.EncryptionForwardSecure
+	if hdr.IsLongHeader {
+		encryptionLevel = protocol.EncryptionUnencrypted
+	}
+	headerBinary, err := u.removeHeaderProtection(headerBinary, hdr, data, encryptionLevel)
+	if err != nil {
+		return nil, qerr.Error(qerr.DecryptionFailure, err.Error())
+	}
+
 	var decrypted []byte
-	var encryptionLevel protocol.EncryptionLevel
-	var err error
 	if hdr.IsLongHeader {
 		decrypted, err = u.aead.OpenHandshake(buf, data, hdr.PacketNumber, headerBinary)
-		encryptionLevel = protocol.EncryptionUnencrypted
 	} else {
-		decrypted, err = u.aead.Open1RTT(buf, data, hdr.PacketNumber, headerBinary)
-		encryptionLevel = protocol.EncryptionForwardSecure
+		decrypted, err = u.aead.Open1RTT(buf, data, hdr.PacketNumber, hdr.KeyPhase, headerBinary)
 	}
 	if err != nil {
 		// Wrap err in quicError so that public reset is sent by session
 		return nil, qerr.Error(qerr.DecryptionFailure, err.Error())
 	}
+	// Only do this after decrypting, so we are sure the packet is not attacker-controlled
+	u.largestRcvdPacketNumber = utils.MaxPacketNumber(u.largestRcvdPacketNumber, hdr.PacketNumber)
 
 	fs, err := u.parseFrames(decrypted, hdr)
 	if err != nil {
@@ -125,3 +139,40 @@ func (u *packetUnpacker) Unpack(headerBinary []byte, hdr *wire.Header, data []by
 		frames:          fs,
 	}, nil
 }
+
+// removeHeaderProtection removes header protection from a copy of the header.
+// The packet itself is not modified, since it might be queued to be decrypted later.
+// It sets the packet number and the key phase, and returns the unprotected header.
+func (u *packetUnpacker) removeHeaderProtection(
+	headerBinary []byte,
+	hdr *wire.Header,
+	data []byte,
+	encLevel protocol.EncryptionLevel,
+) ([]byte, error) {
+	hp, err := u.aead.GetHeaderProtector(encLevel)
+	if err != nil {
+		return nil, err
+	}
+	pnLen := int(hdr.PacketNumberLen)
+	// the sample is taken at a fixed offset from the beginning of the packet number
+	sampleOffset := crypto.HeaderProtectionSampleOffset - pnLen
+	if len(data) < sampleOffset+crypto.HeaderProtectionSampleLen {
+		return nil, errors.New("packet too small to sample the ciphertext")
+	}
+	sample := data[sampleOffset : sampleOffset+crypto.HeaderProtectionSampleLen]
+
+	header := make([]byte, len(headerBinary))
+	copy(header, headerBinary)
+	pnOffset := len(header) - pnLen
+	hp.DecryptHeader(sample, &header[0], header[pnOffset:])
+
+	pn, _, err := utils.ReadVarIntPacketNumber(bytes.NewReader(header[pnOffset:]))
+	if err != nil {
+		return nil, err
+	}
+	hdr.PacketNumber = protocol.InferPacketNumber(hdr.PacketNumberLen, u.largestRcvdPacketNumber, pn, u.version)
+	if !hdr.IsLongHeader {
+		hdr.KeyPhase = int(header[0]&0x40) >> 6
+	}
+	return header, nil
+}
@@ -2,8 +2,11 @@ package quic
 
 import (
 	"bytes"
+	"errors"
 
 	"github.com/golang/mock/gomock"
+	"github.com/wheelcomplex/qk/internal/crypto"
+	"github.com/wheelcomplex/qk/internal/mocks/crypto"
 	"github.com/wheelcomplex/qk/internal/protocol"
 	"github.com/wheelcomplex/qk/internal/wire"
 	"github.com/wheelcomplex/qk/qerr"
@@ -59,29 +62,39 @@ var _ = Describe("Packet Unpacker (for IETF QUIC)", func() {
 		unpacker *packetUnpacker
 		hdr      *wire.Header
 		aead     *MockQuicAEAD
+		hp       *mockcrypto.MockHeaderProtector
+		data     []byte
 	)
 
 	BeforeEach(func() {
 		aead = NewMockQuicAEAD(mockCtrl)
+		hp = mockcrypto.NewMockHeaderProtector(mockCtrl)
 		hdr = &wire.Header{
-			PacketNumber:    10,
 			PacketNumberLen: 1,
-			Raw:             []byte{0x04, 0x4c, 0x01},
+			Raw:             []byte{0x30, 0xde, 0xad, 0xbe, 0xef, 0x0a},
 		}
+		// the packet must be long enough to sample the ciphertext for header protection
+		data = make([]byte, 20)
 		unpacker = newPacketUnpacker(aead, versionIETFFrames).(*packetUnpacker)
 	})
 
+	expectHeaderProtection := func(encLevel protocol.EncryptionLevel) {
+		aead.EXPECT().GetHeaderProtector(encLevel).Return(hp, nil)
+		hp.EXPECT().DecryptHeader(gomock.Any(), gomock.Any(), gomock.Any())
+	}
+
 	It("errors if the packet doesn't contain any payload", func() {
-		data := []byte("foobar")
-		aead.EXPECT().Open1RTT(gomock.Any(), []byte("foobar"), hdr.PacketNumber, hdr.Raw).Return([]byte{}, nil)
+		expectHeaderProtection(protocol.EncryptionForwardSecure)
+		aead.EXPECT().Open1RTT(gomock.Any(), data, protocol.PacketNumber(10), 0, hdr.Raw).Return([]byte{}, nil)
 		_, err := unpacker.Unpack(hdr.Raw, hdr, data)
 		Expect(err).To(MatchError(qerr.MissingPayload))
 	})
 
 	It("opens handshake packets", func() {
 		hdr.IsLongHeader = true
-		aead.EXPECT().OpenHandshake(gomock.Any(), gomock.Any(), hdr.PacketNumber, hdr.Raw).Return([]byte{0}, nil)
-		packet, err := unpacker.Unpack(hdr.Raw, hdr, nil)
+		expectHeaderProtection(protocol.EncryptionUnencrypted)
+		aead.EXPECT().OpenHandshake(gomock.Any(), gomock.Any(), protocol.PacketNumber(10), hdr.Raw).Return([]byte{0}, nil)
+		packet, err := unpacker.Unpack(hdr.Raw, hdr, data)
 		Expect(err).ToNot(HaveOccurred())
 		Expect(packet.encryptionLevel).To(Equal(protocol.EncryptionUnencrypted))
 	})
@@ -90,9 +103,83 @@ var _ = Describe("Packet Unpacker (for IETF QUIC)", func() {
 		buf := &bytes.Buffer{}
 		(&wire.PingFrame{}).Write(buf, versionIETFFrames)
 		(&wire.BlockedFrame{}).Write(buf, versionIETFFrames)
-		aead.EXPECT().Open1RTT(gomock.Any(), gomock.Any(), hdr.PacketNumber, hdr.Raw).Return(buf.Bytes(), nil)
-		packet, err := unpacker.Unpack(hdr.Raw, hdr, nil)
+		expectHeaderProtection(protocol.EncryptionForwardSecure)
+		aead.EXPECT().Open1RTT(gomock.Any(), gomock.Any(), protocol.PacketNumber(10), 0, hdr.Raw).Return(buf.Bytes(), nil)
+		packet, err := unpacker.Unpack(hdr.Raw, hdr, data)
 		Expect(err).ToNot(HaveOccurred())
 		Expect(packet.frames).To(Equal([]wire.Frame{&wire.PingFrame{}, &wire.BlockedFrame{}}))
 	})
+
+	It("passes the key phase to the AEAD", func() {
+		hdr.Raw[0] |= 0x40
+		expectHeaderProtection(protocol.EncryptionForwardSecure)
+		aead.EXPECT().Open1RTT(gomock.Any(), gomock.Any(), protocol.PacketNumber(10), 1, hdr.Raw).Return([]byte{0}, nil)
+		packet, err := unpacker.Unpack(hdr.Raw, hdr, data)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(packet.encryptionLevel).To(Equal(protocol.EncryptionForwardSecure))
+	})
+
+	Context("header protection", func() {
+		It("removes header protection", func() {
+			var client crypto.HeaderProtector
+			server, err := crypto.NewAESHeaderProtector(bytes.Repeat([]byte{'c'}, 16), bytes.Repeat([]byte{'s'}, 16))
+			Expect(err).ToNot(HaveOccurred())
+			client, err = crypto.NewAESHeaderProtector(bytes.Repeat([]byte{'s'}, 16), bytes.Repeat([]byte{'c'}, 16))
+			Expect(err).ToNot(HaveOccurred())
+			hdr.Raw = []byte{0x70, 0xde, 0xad, 0xbe, 0xef, 0x81, 0x37}
+			hdr.PacketNumberLen = 2
+			data = []byte("ciphertext, to be sampled for header protection")
+			protected := make([]byte, len(hdr.Raw))
+			copy(protected, hdr.Raw)
+			client.EncryptHeader(data[2:18], &protected[0], protected[5:])
+			Expect(protected).ToNot(Equal(hdr.Raw))
+
+			aead.EXPECT().GetHeaderProtector(protocol.EncryptionForwardSecure).Return(server, nil)
+			aead.EXPECT().Open1RTT(gomock.Any(), data, protocol.PacketNumber(0x137), 1, hdr.Raw).Return([]byte{0}, nil)
+			_, err = unpacker.Unpack(protected, hdr, data)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(hdr.PacketNumber).To(Equal(protocol.PacketNumber(0x137)))
+			Expect(hdr.KeyPhase).To(Equal(1))
+		})
+
+		It("doesn't modify the packet", func() {
+			raw := []byte{0x30, 0xde, 0xad, 0xbe, 0xef, 0x0a}
+			hdr.Raw = []byte{0x30, 0xde, 0xad, 0xbe, 0xef, 0x0a}
+			aead.EXPECT().GetHeaderProtector(protocol.EncryptionForwardSecure).Return(hp, nil)
+			hp.EXPECT().DecryptHeader(gomock.Any(), gomock.Any(), gomock.Any()).Do(func(_ []byte, firstByte *byte, pn []byte) {
+				*firstByte ^= 0x40
+				pn[0] ^= 0x01
+			})
+			aead.EXPECT().Open1RTT(gomock.Any(), gomock.Any(), protocol.PacketNumber(11), 1, []byte{0x70, 0xde, 0xad, 0xbe, 0xef, 0x0b}).Return(nil, errors.New("decryption failed"))
+			_, err := unpacker.Unpack(hdr.Raw, hdr, data)
+			Expect(err).To(MatchError(qerr.Error(qerr.DecryptionFailure, "decryption failed")))
+			Expect(hdr.Raw).To(Equal(raw))
+		})
+
+		It("infers the packet number using the largest packet number received", func() {
+			expectHeaderProtection(protocol.EncryptionForwardSecure)
+			aead.EXPECT().Open1RTT(gomock.Any(), gomock.Any(), protocol.PacketNumber(0x7f), 0, gomock.Any()).Return([]byte{0}, nil)
+			hdr.Raw = []byte{0x30, 0xde, 0xad, 0xbe, 0xef, 0x7f}
+			_, err := unpacker.Unpack(hdr.Raw, hdr, data)
+			Expect(err).ToNot(HaveOccurred())
+			expectHeaderProtection(protocol.EncryptionForwardSecure)
+			aead.EXPECT().Open1RTT(gomock.Any(), gomock.Any(), protocol.PacketNumber(0x80), 0, gomock.Any()).Return([]byte{0}, nil)
+			hdr.Raw = []byte{0x30, 0xde, 0xad, 0xbe, 0xef, 0x00}
+			_, err = unpacker.Unpack(hdr.Raw, hdr, data)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(hdr.PacketNumber).To(Equal(protocol.PacketNumber(0x80)))
+		})
+
+		It("errors if the header protector is not yet available", func() {
+			aead.EXPECT().GetHeaderProtector(protocol.EncryptionForwardSecure).Return(nil, errors.New("no header protector"))
+			_, err := unpacker.Unpack(hdr.Raw, hdr, data)
+			Expect(err).To(MatchError(qerr.Error(qerr.DecryptionFailure, "no header protector")))
+		})
+
+		It("errors if the packet is too small to sample the ciphertext", func() {
+			aead.EXPECT().GetHeaderProtector(protocol.EncryptionForwardSecure).Return(hp, nil)
+			_, err := unpacker.Unpack(hdr.Raw, hdr, data[:18])
+			Expect(err).To(MatchError(qerr.Error(qerr.DecryptionFailure, "packet too small to sample the ciphertext")))
+		})
+	})
 })
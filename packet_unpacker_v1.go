@@ -0,0 +1,149 @@
+package quic
+
+import (
+	"github.com/wheelcomplex/qk/internal/crypto"
+	"github.com/wheelcomplex/qk/internal/handshake"
+	"github.com/wheelcomplex/qk/internal/protocol"
+	"github.com/wheelcomplex/qk/internal/utils"
+	"github.com/wheelcomplex/qk/internal/wire"
+	"github.com/wheelcomplex/qk/qerr"
+)
+
+type quicAEADV1 interface {
+	OpenInitial(dst, src []byte, packetNumber protocol.PacketNumber, associatedData []byte) ([]byte, error)
+	OpenHandshake(dst, src []byte, packetNumber protocol.PacketNumber, associatedData []byte) ([]byte, error)
+	Open1RTT(dst, src []byte, packetNumber protocol.PacketNumber, keyPhase int, associatedData []byte) ([]byte, error)
+	GetHeaderProtector(protocol.EncryptionLevel) (crypto.HeaderProtector, error)
+}
+
+// The packetUnpackerV1 unpacks QUIC v1 packets.
+// In QUIC v1, the length of the packet number is protected as well,
+// so the packet number is read after removing header protection.
+type packetUnpackerV1 struct {
+	packetUnpackerBase
+	aead quicAEADV1
+
+	// every packet number space has its own largest received packet number
+	largestRcvdInitialPacketNumber   protocol.PacketNumber
+	largestRcvdHandshakePacketNumber protocol.PacketNumber
+	largestRcvd1RTTPacketNumber      protocol.PacketNumber
+}
+
+var _ unpacker = &packetUnpackerV1{}
+
+func newPacketUnpackerV1(aead quicAEADV1, version protocol.VersionNumber) unpacker {
+	return &packetUnpackerV1{
+		packetUnpackerBase: packetUnpackerBase{version: version},
+		aead:               aead,
+	}
+}
+
+// Unpack unpacks a QUIC v1 packet.
+// headerBinary is the header up to (but not including) the packet number,
+// data starts with the (protected) packet number.
+func (u *packetUnpackerV1) Unpack(headerBinary []byte, hdr *wire.Header, data []byte) (*unpackedPacket, error) {
+	var encLevel protocol.EncryptionLevel
+	var largestRcvd *protocol.PacketNumber
+	switch {
+	case !hdr.IsLongHeader:
+		encLevel = protocol.EncryptionForwardSecure
+		largestRcvd = &u.largestRcvd1RTTPacketNumber
+	case hdr.Type == protocol.PacketTypeInitial:
+		encLevel = protocol.EncryptionInitial
+		largestRcvd = &u.largestRcvdInitialPacketNumber
+	case hdr.Type == protocol.PacketTypeHandshake:
+		encLevel = protocol.EncryptionHandshake
+		largestRcvd = &u.largestRcvdHandshakePacketNumber
+	default:
+		// 0-RTT is not supported, and Retry packets are handled by the client
+		return nil, qerr.Error(qerr.DecryptionFailure, "unexpected packet type: "+hdr.Type.String())
+	}
+
+	headerBinary, payload, err := u.removeHeaderProtection(headerBinary, hdr, data, encLevel, *largestRcvd)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := *getPacketBuffer()
+	buf = buf[:0]
+	defer putPacketBuffer(&buf)
+
+	var decrypted []byte
+	switch encLevel {
+	case protocol.EncryptionInitial:
+		decrypted, err = u.aead.OpenInitial(buf, payload, hdr.PacketNumber, headerBinary)
+	case protocol.EncryptionHandshake:
+		decrypted, err = u.aead.OpenHandshake(buf, payload, hdr.PacketNumber, headerBinary)
+	default:
+		decrypted, err = u.aead.Open1RTT(buf, payload, hdr.PacketNumber, hdr.KeyPhase, headerBinary)
+	}
+	if err != nil {
+		return nil, u.wrapError(err)
+	}
+	// Only check the reserved bits after decrypting.
+	// Otherwise, an attacker could use them to close the connection.
+	if (hdr.IsLongHeader && headerBinary[0]&0xc != 0) || (!hdr.IsLongHeader && headerBinary[0]&0x18 != 0) {
+		return nil, qerr.Error(qerr.InvalidPacketHeader, "reserved bits set")
+	}
+	// Only do this after decrypting, so we are sure the packet is not attacker-controlled
+	*largestRcvd = utils.MaxPacketNumber(*largestRcvd, hdr.PacketNumber)
+
+	fs, err := u.parseFrames(decrypted, hdr)
+	if err != nil {
+		return nil, err
+	}
+	return &unpackedPacket{
+		encryptionLevel: encLevel,
+		frames:          fs,
+	}, nil
+}
+
+// removeHeaderProtection removes header protection from a copy of the header.
+// The packet itself is not modified, since it might be queued to be decrypted later.
+// It sets the packet number, the packet number length and the key phase.
+// It returns the unprotected header (including the packet number) and the payload.
+func (u *packetUnpackerV1) removeHeaderProtection(
+	headerBinary []byte,
+	hdr *wire.Header,
+	data []byte,
+	encLevel protocol.EncryptionLevel,
+	largestRcvd protocol.PacketNumber,
+) ([]byte, []byte, error) {
+	hp, err := u.aead.GetHeaderProtector(encLevel)
+	if err != nil {
+		return nil, nil, u.wrapError(err)
+	}
+	// the sample is taken at a fixed offset from the beginning of the packet number
+	if len(data) < crypto.HeaderProtectionSampleOffset+crypto.HeaderProtectionSampleLen {
+		return nil, nil, qerr.Error(qerr.DecryptionFailure, "packet too small to sample the ciphertext")
+	}
+	sample := data[crypto.HeaderProtectionSampleOffset : crypto.HeaderProtectionSampleOffset+crypto.HeaderProtectionSampleLen]
+
+	pnOffset := len(headerBinary)
+	header := make([]byte, pnOffset+4)
+	copy(header, headerBinary)
+	copy(header[pnOffset:], data[:4])
+	hp.DecryptHeader(sample, &header[0], header[pnOffset:])
+
+	pnLen := protocol.PacketNumberLen(header[0]&0x3) + 1
+	header = header[:pnOffset+int(pnLen)]
+	var pn protocol.PacketNumber
+	for _, b := range header[pnOffset:] {
+		pn = pn<<8 | protocol.PacketNumber(b)
+	}
+	hdr.PacketNumberLen = pnLen
+	hdr.PacketNumber = protocol.InferPacketNumber(pnLen, largestRcvd, pn, u.version)
+	if !hdr.IsLongHeader {
+		hdr.KeyPhase = int(header[0]&0x4) >> 2
+	}
+	return header, data[pnLen:], nil
+}
+
+func (u *packetUnpackerV1) wrapError(err error) error {
+	// Packets for encryption levels whose keys were already dropped are ignored by the session.
+	if err == handshake.ErrKeysDropped {
+		return err
+	}
+	// Wrap err in quicError so that the session queues the packet, or drops it
+	return qerr.Error(qerr.DecryptionFailure, err.Error())
+}
@@ -0,0 +1,305 @@
+package quic
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/golang/mock/gomock"
+	"github.com/wheelcomplex/qk/internal/crypto"
+	"github.com/wheelcomplex/qk/internal/handshake"
+	"github.com/wheelcomplex/qk/internal/mocks/crypto"
+	"github.com/wheelcomplex/qk/internal/protocol"
+	"github.com/wheelcomplex/qk/internal/wire"
+	"github.com/wheelcomplex/qk/qerr"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Packet Unpacker (for QUIC v1)", func() {
+	var (
+		unpacker *packetUnpackerV1
+		aead     *MockQuicAEADV1
+		hp       *mockcrypto.MockHeaderProtector
+		data     []byte
+	)
+
+	// The header protector mock doesn't modify the header,
+	// so the first byte and the packet number are passed unprotected.
+	shortHeader := func(firstByte byte) ([]byte, *wire.Header) {
+		return []byte{firstByte, 0xde, 0xad, 0xbe, 0xef}, &wire.Header{}
+	}
+	longHeader := func(firstByte byte, t protocol.PacketType) ([]byte, *wire.Header) {
+		return []byte{firstByte, 0, 0, 0, 1, 0, 0, 0x1}, &wire.Header{IsLongHeader: true, Type: t}
+	}
+
+	expectHeaderProtection := func(encLevel protocol.EncryptionLevel) {
+		aead.EXPECT().GetHeaderProtector(encLevel).Return(hp, nil)
+		hp.EXPECT().DecryptHeader(gomock.Any(), gomock.Any(), gomock.Any())
+	}
+
+	// packet returns the data of a packet with the given (unprotected) packet number,
+	// which is long enough to sample the ciphertext for header protection
+	packet := func(pn ...byte) []byte {
+		return append(pn, make([]byte, 20)...)
+	}
+
+	BeforeEach(func() {
+		aead = NewMockQuicAEADV1(mockCtrl)
+		hp = mockcrypto.NewMockHeaderProtector(mockCtrl)
+		data = packet(0x2a)
+		unpacker = newPacketUnpackerV1(aead, protocol.Version1).(*packetUnpackerV1)
+	})
+
+	Context("choosing the encryption level", func() {
+		It("opens Initial packets", func() {
+			raw, hdr := longHeader(0xc0, protocol.PacketTypeInitial)
+			expectHeaderProtection(protocol.EncryptionInitial)
+			aead.EXPECT().OpenInitial(gomock.Any(), data[1:], protocol.PacketNumber(0x2a), append(raw, 0x2a)).Return([]byte{0}, nil)
+			packet, err := unpacker.Unpack(raw, hdr, data)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(packet.encryptionLevel).To(Equal(protocol.EncryptionInitial))
+		})
+
+		It("opens Handshake packets", func() {
+			raw, hdr := longHeader(0xe0, protocol.PacketTypeHandshake)
+			expectHeaderProtection(protocol.EncryptionHandshake)
+			aead.EXPECT().OpenHandshake(gomock.Any(), data[1:], protocol.PacketNumber(0x2a), append(raw, 0x2a)).Return([]byte{0}, nil)
+			packet, err := unpacker.Unpack(raw, hdr, data)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(packet.encryptionLevel).To(Equal(protocol.EncryptionHandshake))
+		})
+
+		It("opens 1-RTT packets", func() {
+			raw, hdr := shortHeader(0x40)
+			expectHeaderProtection(protocol.EncryptionForwardSecure)
+			aead.EXPECT().Open1RTT(gomock.Any(), data[1:], protocol.PacketNumber(0x2a), 0, append(raw, 0x2a)).Return([]byte{0}, nil)
+			packet, err := unpacker.Unpack(raw, hdr, data)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(packet.encryptionLevel).To(Equal(protocol.EncryptionForwardSecure))
+		})
+
+		It("errors on 0-RTT packets", func() {
+			raw, hdr := longHeader(0xd0, protocol.PacketType0RTT)
+			_, err := unpacker.Unpack(raw, hdr, data)
+			Expect(err).To(MatchError(qerr.Error(qerr.DecryptionFailure, "unexpected packet type: 0-RTT Protected")))
+		})
+	})
+
+	It("unpacks the frames", func() {
+		buf := &bytes.Buffer{}
+		(&wire.PingFrame{}).Write(buf, protocol.Version1)
+		(&wire.CryptoFrame{Offset: 0x42, Data: []byte("foobar")}).Write(buf, protocol.Version1)
+		raw, hdr := longHeader(0xe0, protocol.PacketTypeHandshake)
+		expectHeaderProtection(protocol.EncryptionHandshake)
+		aead.EXPECT().OpenHandshake(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(buf.Bytes(), nil)
+		packet, err := unpacker.Unpack(raw, hdr, data)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(packet.frames).To(Equal([]wire.Frame{
+			&wire.PingFrame{},
+			&wire.CryptoFrame{Offset: 0x42, Data: []byte("foobar")},
+		}))
+	})
+
+	It("errors if the packet doesn't contain any payload", func() {
+		raw, hdr := shortHeader(0x40)
+		expectHeaderProtection(protocol.EncryptionForwardSecure)
+		aead.EXPECT().Open1RTT(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return([]byte{}, nil)
+		_, err := unpacker.Unpack(raw, hdr, data)
+		Expect(err).To(MatchError(qerr.MissingPayload))
+	})
+
+	Context("header protection", func() {
+		It("removes header protection", func() {
+			sealer, err := crypto.NewAESHeaderProtector(bytes.Repeat([]byte{'s'}, 16), bytes.Repeat([]byte{'c'}, 16))
+			Expect(err).ToNot(HaveOccurred())
+			opener, err := crypto.NewAESHeaderProtector(bytes.Repeat([]byte{'c'}, 16), bytes.Repeat([]byte{'s'}, 16))
+			Expect(err).ToNot(HaveOccurred())
+			// short header with key phase 1 and a 2 byte packet number
+			raw := []byte{0x40 | 0x4 | 0x1, 0xde, 0xad, 0xbe, 0xef}
+			data = append([]byte{0x13, 0x37}, []byte("ciphertext, to be sampled for header protection")...)
+			protectedRaw := append([]byte{}, raw...)
+			protectedData := append([]byte{}, data...)
+			sample := data[crypto.HeaderProtectionSampleOffset : crypto.HeaderProtectionSampleOffset+crypto.HeaderProtectionSampleLen]
+			sealer.EncryptHeader(sample, &protectedRaw[0], protectedData[:2])
+			Expect(protectedData[:2]).ToNot(Equal(data[:2]))
+
+			hdr := &wire.Header{}
+			aead.EXPECT().GetHeaderProtector(protocol.EncryptionForwardSecure).Return(opener, nil)
+			aead.EXPECT().Open1RTT(gomock.Any(), data[2:], protocol.PacketNumber(0x1337), 1, append(raw, 0x13, 0x37)).Return([]byte{0}, nil)
+			_, err = unpacker.Unpack(protectedRaw, hdr, protectedData)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(hdr.PacketNumber).To(Equal(protocol.PacketNumber(0x1337)))
+			Expect(hdr.PacketNumberLen).To(Equal(protocol.PacketNumberLen2))
+			Expect(hdr.KeyPhase).To(Equal(1))
+		})
+
+		It("doesn't modify the packet", func() {
+			raw, hdr := shortHeader(0x40)
+			origRaw := append([]byte{}, raw...)
+			origData := append([]byte{}, data...)
+			aead.EXPECT().GetHeaderProtector(protocol.EncryptionForwardSecure).Return(hp, nil)
+			hp.EXPECT().DecryptHeader(gomock.Any(), gomock.Any(), gomock.Any()).Do(func(_ []byte, firstByte *byte, pn []byte) {
+				*firstByte ^= 0x4
+				pn[0] ^= 0x1
+			})
+			aead.EXPECT().Open1RTT(gomock.Any(), gomock.Any(), protocol.PacketNumber(0x2b), 1, gomock.Any()).Return(nil, errors.New("decryption failed"))
+			_, err := unpacker.Unpack(raw, hdr, data)
+			Expect(err).To(MatchError(qerr.Error(qerr.DecryptionFailure, "decryption failed")))
+			Expect(raw).To(Equal(origRaw))
+			Expect(data).To(Equal(origData))
+		})
+
+		It("errors if the header protector is not yet available", func() {
+			raw, hdr := longHeader(0xe0, protocol.PacketTypeHandshake)
+			aead.EXPECT().GetHeaderProtector(protocol.EncryptionHandshake).Return(nil, errors.New("no header protector"))
+			_, err := unpacker.Unpack(raw, hdr, data)
+			Expect(err).To(MatchError(qerr.Error(qerr.DecryptionFailure, "no header protector")))
+		})
+
+		It("doesn't wrap the error if the keys were already dropped", func() {
+			raw, hdr := longHeader(0xc0, protocol.PacketTypeInitial)
+			aead.EXPECT().GetHeaderProtector(protocol.EncryptionInitial).Return(nil, handshake.ErrKeysDropped)
+			_, err := unpacker.Unpack(raw, hdr, data)
+			Expect(err).To(MatchError(handshake.ErrKeysDropped))
+		})
+
+		It("errors if the packet is too small to sample the ciphertext", func() {
+			raw, hdr := shortHeader(0x40)
+			aead.EXPECT().GetHeaderProtector(protocol.EncryptionForwardSecure).Return(hp, nil)
+			_, err := unpacker.Unpack(raw, hdr, data[:crypto.HeaderProtectionSampleOffset+crypto.HeaderProtectionSampleLen-1])
+			Expect(err).To(MatchError(qerr.Error(qerr.DecryptionFailure, "packet too small to sample the ciphertext")))
+		})
+	})
+
+	Context("packet numbers", func() {
+		It("reads the packet number length", func() {
+			for pnLen := protocol.PacketNumberLen(1); pnLen <= 4; pnLen++ {
+				raw, hdr := shortHeader(0x40 | byte(pnLen-1))
+				data = packet(bytes.Repeat([]byte{0x1}, int(pnLen))...)
+				expectHeaderProtection(protocol.EncryptionForwardSecure)
+				aead.EXPECT().Open1RTT(gomock.Any(), data[pnLen:], gomock.Any(), 0, append(raw, data[:pnLen]...)).Return([]byte{0}, nil)
+				_, err := unpacker.Unpack(raw, hdr, data)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(hdr.PacketNumberLen).To(Equal(pnLen))
+			}
+		})
+
+		It("infers the packet number using the largest packet number received in the packet number space", func() {
+			unpack1RTT := func(pn byte) protocol.PacketNumber {
+				raw, hdr := shortHeader(0x40)
+				expectHeaderProtection(protocol.EncryptionForwardSecure)
+				aead.EXPECT().Open1RTT(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return([]byte{0}, nil)
+				_, err := unpacker.Unpack(raw, hdr, packet(pn))
+				ExpectWithOffset(1, err).ToNot(HaveOccurred())
+				return hdr.PacketNumber
+			}
+			unpackHandshake := func(pn byte) protocol.PacketNumber {
+				raw, hdr := longHeader(0xe0, protocol.PacketTypeHandshake)
+				expectHeaderProtection(protocol.EncryptionHandshake)
+				aead.EXPECT().OpenHandshake(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return([]byte{0}, nil)
+				_, err := unpacker.Unpack(raw, hdr, packet(pn))
+				ExpectWithOffset(1, err).ToNot(HaveOccurred())
+				return hdr.PacketNumber
+			}
+			unpackInitial := func(pn byte) protocol.PacketNumber {
+				raw, hdr := longHeader(0xc0, protocol.PacketTypeInitial)
+				expectHeaderProtection(protocol.EncryptionInitial)
+				aead.EXPECT().OpenInitial(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return([]byte{0}, nil)
+				_, err := unpacker.Unpack(raw, hdr, packet(pn))
+				ExpectWithOffset(1, err).ToNot(HaveOccurred())
+				return hdr.PacketNumber
+			}
+			Expect(unpack1RTT(0xff)).To(Equal(protocol.PacketNumber(0xff)))
+			Expect(unpack1RTT(0x00)).To(Equal(protocol.PacketNumber(0x100)))
+			// the Initial and the Handshake packet number space are not affected
+			Expect(unpackInitial(0x00)).To(Equal(protocol.PacketNumber(0)))
+			Expect(unpackHandshake(0xfe)).To(Equal(protocol.PacketNumber(0xfe)))
+			Expect(unpackHandshake(0x01)).To(Equal(protocol.PacketNumber(0x101)))
+			Expect(unpackInitial(0x01)).To(Equal(protocol.PacketNumber(1)))
+			Expect(unpack1RTT(0x01)).To(Equal(protocol.PacketNumber(0x101)))
+		})
+
+		It("only uses packets that could be decrypted to infer the packet number", func() {
+			raw, hdr := shortHeader(0x40)
+			expectHeaderProtection(protocol.EncryptionForwardSecure)
+			aead.EXPECT().Open1RTT(gomock.Any(), gomock.Any(), protocol.PacketNumber(0xff), gomock.Any(), gomock.Any()).Return(nil, errors.New("decryption failed"))
+			_, err := unpacker.Unpack(raw, hdr, packet(0xff))
+			Expect(err).To(HaveOccurred())
+			raw, hdr = shortHeader(0x40)
+			expectHeaderProtection(protocol.EncryptionForwardSecure)
+			aead.EXPECT().Open1RTT(gomock.Any(), gomock.Any(), protocol.PacketNumber(0), gomock.Any(), gomock.Any()).Return([]byte{0}, nil)
+			_, err = unpacker.Unpack(raw, hdr, packet(0x00))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(hdr.PacketNumber).To(BeZero())
+		})
+	})
+
+	Context("key phase", func() {
+		It("passes the key phase to the AEAD", func() {
+			raw, hdr := shortHeader(0x40 | 0x4)
+			expectHeaderProtection(protocol.EncryptionForwardSecure)
+			aead.EXPECT().Open1RTT(gomock.Any(), gomock.Any(), gomock.Any(), 1, gomock.Any()).Return([]byte{0}, nil)
+			_, err := unpacker.Unpack(raw, hdr, data)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(hdr.KeyPhase).To(Equal(1))
+			raw, hdr = shortHeader(0x40)
+			expectHeaderProtection(protocol.EncryptionForwardSecure)
+			aead.EXPECT().Open1RTT(gomock.Any(), gomock.Any(), gomock.Any(), 0, gomock.Any()).Return([]byte{0}, nil)
+			_, err = unpacker.Unpack(raw, hdr, data)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(hdr.KeyPhase).To(BeZero())
+		})
+
+		It("doesn't read the key phase from long headers", func() {
+			// for long headers, this bit is one of the reserved bits
+			raw, hdr := longHeader(0xe0|0x4, protocol.PacketTypeHandshake)
+			expectHeaderProtection(protocol.EncryptionHandshake)
+			aead.EXPECT().OpenHandshake(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return([]byte{0}, nil)
+			_, err := unpacker.Unpack(raw, hdr, data)
+			Expect(err).To(MatchError(qerr.Error(qerr.InvalidPacketHeader, "reserved bits set")))
+			Expect(hdr.KeyPhase).To(BeZero())
+		})
+	})
+
+	Context("reserved bits", func() {
+		It("errors if the reserved bits of a short header are set", func() {
+			for _, b := range []byte{0x8, 0x10} {
+				raw, hdr := shortHeader(0x40 | b)
+				expectHeaderProtection(protocol.EncryptionForwardSecure)
+				aead.EXPECT().Open1RTT(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return([]byte{0}, nil)
+				_, err := unpacker.Unpack(raw, hdr, data)
+				Expect(err).To(MatchError(qerr.Error(qerr.InvalidPacketHeader, "reserved bits set")))
+			}
+		})
+
+		It("errors if the reserved bits of a long header are set", func() {
+			for _, b := range []byte{0x4, 0x8} {
+				raw, hdr := longHeader(0xc0|b, protocol.PacketTypeInitial)
+				expectHeaderProtection(protocol.EncryptionInitial)
+				aead.EXPECT().OpenInitial(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return([]byte{0}, nil)
+				_, err := unpacker.Unpack(raw, hdr, data)
+				Expect(err).To(MatchError(qerr.Error(qerr.InvalidPacketHeader, "reserved bits set")))
+			}
+		})
+
+		It("checks the reserved bits after removing header protection", func() {
+			raw, hdr := shortHeader(0x40 | 0x18)
+			aead.EXPECT().GetHeaderProtector(protocol.EncryptionForwardSecure).Return(hp, nil)
+			hp.EXPECT().DecryptHeader(gomock.Any(), gomock.Any(), gomock.Any()).Do(func(_ []byte, firstByte *byte, _ []byte) {
+				*firstByte &^= 0x18
+			})
+			aead.EXPECT().Open1RTT(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return([]byte{0}, nil)
+			_, err := unpacker.Unpack(raw, hdr, data)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("doesn't check the reserved bits if decryption fails", func() {
+			raw, hdr := shortHeader(0x40 | 0x18)
+			expectHeaderProtection(protocol.EncryptionForwardSecure)
+			aead.EXPECT().Open1RTT(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, errors.New("decryption failed"))
+			_, err := unpacker.Unpack(raw, hdr, data)
+			Expect(err).To(MatchError(qerr.Error(qerr.DecryptionFailure, "decryption failed")))
+		})
+	})
+})
@@ -0,0 +1,82 @@
+package qerr
+
+// The transport error codes defined in RFC 9000, section 20.1.
+// They are used on the wire in CONNECTION_CLOSE frames sent in QUIC v1.
+const (
+	v1NoError                 = 0x0
+	v1InternalError           = 0x1
+	v1ConnectionRefused       = 0x2
+	v1FlowControlError        = 0x3
+	v1StreamLimitError        = 0x4
+	v1StreamStateError        = 0x5
+	v1FinalSizeError          = 0x6
+	v1FrameEncodingError      = 0x7
+	v1TransportParameterError = 0x8
+	v1ProtocolViolation       = 0xa
+	v1CryptoBufferExceeded    = 0xd
+	// v1CryptoError is the first error code used for TLS alerts.
+	// The TLS alert is added to this value.
+	v1CryptoError = 0x100
+)
+
+// the TLS alert sent when the handshake fails, see RFC 8446, section 6
+const tlsAlertHandshakeFailure = 40
+
+// ToTransportErrorCodeV1 converts an error code to the closest matching transport error code defined in RFC 9000.
+// Error codes that don't have a corresponding QUIC v1 error code are converted to INTERNAL_ERROR.
+func ToTransportErrorCodeV1(e ErrorCode) uint64 {
+	switch e {
+	case PeerGoingAway, NetworkIdleTimeout:
+		return v1NoError
+	case ConnectionCancelled:
+		return v1ConnectionRefused
+	case FlowControlReceivedTooMuchData, FlowControlSentTooMuchData, FlowControlInvalidWindow:
+		return v1FlowControlError
+	case TooManyOpenStreams, TooManyAvailableStreams:
+		return v1StreamLimitError
+	case InvalidStreamID:
+		return v1StreamStateError
+	case StreamDataAfterTermination:
+		return v1FinalSizeError
+	case InvalidFrameData, InvalidStreamData, InvalidRstStreamData, InvalidConnectionCloseData,
+		InvalidWindowUpdateData, InvalidBlockedData, InvalidAckData, EmptyStreamFrameNoFin:
+		return v1FrameEncodingError
+	case InvalidNegotiatedValue:
+		return v1TransportParameterError
+	case InvalidPacketHeader, MissingPayload, OverlappingStreamData, UnencryptedStreamData, CryptoEncryptionLevelIncorrect:
+		return v1ProtocolViolation
+	case HandshakeFailed:
+		return v1CryptoError + tlsAlertHandshakeFailure
+	}
+	return v1InternalError
+}
+
+// FromTransportErrorCodeV1 converts a transport error code defined in RFC 9000 to an error code.
+func FromTransportErrorCodeV1(code uint64) ErrorCode {
+	switch code {
+	case v1NoError:
+		return PeerGoingAway
+	case v1InternalError:
+		return InternalError
+	case v1ConnectionRefused:
+		return ConnectionCancelled
+	case v1FlowControlError:
+		return FlowControlReceivedTooMuchData
+	case v1StreamLimitError:
+		return TooManyOpenStreams
+	case v1StreamStateError:
+		return InvalidStreamID
+	case v1FinalSizeError:
+		return StreamDataAfterTermination
+	case v1FrameEncodingError:
+		return InvalidFrameData
+	case v1TransportParameterError:
+		return InvalidNegotiatedValue
+	case v1ProtocolViolation, v1CryptoBufferExceeded:
+		return InvalidPacketHeader
+	}
+	if code >= v1CryptoError && code < v1CryptoError+0x100 {
+		return HandshakeFailed
+	}
+	return InternalError
+}
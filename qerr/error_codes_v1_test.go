@@ -0,0 +1,31 @@
+package qerr
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("QUIC v1 error codes", func() {
+	It("converts error codes to transport error codes", func() {
+		Expect(ToTransportErrorCodeV1(PeerGoingAway)).To(BeEquivalentTo(0x0))
+		Expect(ToTransportErrorCodeV1(InternalError)).To(BeEquivalentTo(0x1))
+		Expect(ToTransportErrorCodeV1(FlowControlReceivedTooMuchData)).To(BeEquivalentTo(0x3))
+		Expect(ToTransportErrorCodeV1(TooManyOpenStreams)).To(BeEquivalentTo(0x4))
+		Expect(ToTransportErrorCodeV1(InvalidStreamID)).To(BeEquivalentTo(0x5))
+		Expect(ToTransportErrorCodeV1(InvalidAckData)).To(BeEquivalentTo(0x7))
+		Expect(ToTransportErrorCodeV1(InvalidPacketHeader)).To(BeEquivalentTo(0xa))
+		Expect(ToTransportErrorCodeV1(HandshakeFailed)).To(BeEquivalentTo(0x128))
+	})
+
+	It("uses INTERNAL_ERROR for error codes without a corresponding transport error code", func() {
+		Expect(ToTransportErrorCodeV1(CryptoTooManyRejects)).To(BeEquivalentTo(0x1))
+	})
+
+	It("converts transport error codes to error codes", func() {
+		for _, c := range []ErrorCode{PeerGoingAway, InternalError, FlowControlReceivedTooMuchData, TooManyOpenStreams, InvalidStreamID, InvalidFrameData, InvalidPacketHeader, HandshakeFailed} {
+			Expect(FromTransportErrorCodeV1(ToTransportErrorCodeV1(c))).To(Equal(c))
+		}
+		Expect(FromTransportErrorCodeV1(0x12a)).To(Equal(HandshakeFailed))
+		Expect(FromTransportErrorCodeV1(0x1337)).To(Equal(InternalError))
+	})
+})
@@ -13,8 +13,9 @@ func (e ErrorCode) Error() string {
 
 // A QuicError consists of an error code plus a error reason
 type QuicError struct {
-	ErrorCode    ErrorCode
-	ErrorMessage string
+	ErrorCode          ErrorCode
+	ErrorMessage       string
+	isApplicationError bool
 }
 
 // Error creates a new QuicError instance
@@ -25,6 +26,21 @@ func Error(errorCode ErrorCode, errorMessage string) *QuicError {
 	}
 }
 
+// ApplicationError creates a new QuicError instance for an application error
+func ApplicationError(errorCode ErrorCode, errorMessage string) *QuicError {
+	return &QuicError{
+		ErrorCode:          errorCode,
+		ErrorMessage:       errorMessage,
+		isApplicationError: true,
+	}
+}
+
+// IsApplicationError says if this error was caused by the application.
+// In QUIC v1, application errors are sent in a different CONNECTION_CLOSE frame than transport errors.
+func (e *QuicError) IsApplicationError() bool {
+	return e.isApplicationError
+}
+
 func (e *QuicError) Error() string {
 	return fmt.Sprintf("%s: %s", e.ErrorCode.String(), e.ErrorMessage)
 }
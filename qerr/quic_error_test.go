@@ -13,6 +13,11 @@ var _ = Describe("Quic error", func() {
 			err := Error(DecryptionFailure, "foobar")
 			Expect(err.Error()).To(Equal("DecryptionFailure: foobar"))
 		})
+
+		It("says if it is an application error", func() {
+			Expect(Error(DecryptionFailure, "foobar").IsApplicationError()).To(BeFalse())
+			Expect(ApplicationError(0x42, "foobar").IsApplicationError()).To(BeTrue())
+		})
 	})
 
 	Context("ErrorCode", func() {
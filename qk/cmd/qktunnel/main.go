@@ -0,0 +1,250 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	quic "github.com/wheelcomplex/qk"
+	"github.com/wheelcomplex/qk/internal/utils"
+	"github.com/wheelcomplex/qk/qk"
+	"github.com/wheelcomplex/qk/qk/tunnel"
+)
+
+const usage = `qktunnel forwards TCP and UDP ports over QUIC.
+
+Run the remote side:
+
+	qktunnel -mode server -listen :4433 -cert server.pem -key server-key.pem -ca clients.pem
+
+Run the local side, forwarding local ports to targets reachable from the remote side:
+
+	qktunnel -mode client -server remote.example.com:4433 -cert client.pem -key client-key.pem -ca server-ca.pem \
+		-forward tcp/127.0.0.1:5432=db.internal:5432 -forward udp/127.0.0.1:5353=10.0.0.2:53
+
+The tunnel uses QUIC v1 (RFC 9000). If -ca is set on the server, clients must present a certificate signed by one of
+the CAs, and if -ca is set on the client, the certificate of the server is verified using these CAs.
+The server refuses to start unless clients are authenticated using -ca, or the targets are restricted using -allow.
+Without either, anyone could use it to connect to any host reachable from the server; -insecure-open-relay allows that.
+
+Flags:
+`
+
+// A forward is a local address that is forwarded to a target
+type forward struct {
+	network   string
+	localAddr string
+	target    string
+}
+
+// forwardList is a flag.Value for the -forward flag, which can be used multiple times
+type forwardList []forward
+
+func (l *forwardList) String() string {
+	s := make([]string, len(*l))
+	for i, f := range *l {
+		s[i] = fmt.Sprintf("%s/%s=%s", f.network, f.localAddr, f.target)
+	}
+	return strings.Join(s, ",")
+}
+
+// Set parses a forward in the format network/localAddr=target, e.g. tcp/127.0.0.1:8080=example.com:80
+func (l *forwardList) Set(s string) error {
+	i := strings.Index(s, "/")
+	j := strings.Index(s, "=")
+	if i < 0 || j < i {
+		return errors.New("expected network/localAddr=target")
+	}
+	f := forward{network: s[:i], localAddr: s[i+1 : j], target: s[j+1:]}
+	if f.network != "tcp" && f.network != "udp" {
+		return fmt.Errorf("unknown network %q", f.network)
+	}
+	*l = append(*l, f)
+	return nil
+}
+
+func main() {
+	var forwards forwardList
+	mode := flag.String("mode", "client", "server or client")
+	listenAddr := flag.String("listen", ":4433", "the UDP address that the server listens on")
+	serverAddr := flag.String("server", "", "the address of the server")
+	flag.Var(&forwards, "forward", "a forward in the format network/localAddr=target, e.g. tcp/127.0.0.1:8080=example.com:80 (can be repeated)")
+	allow := flag.String("allow", "", "comma-separated list of targets that the server forwards to (all targets if empty, which makes the server an open relay to the clients it accepts)")
+	openRelay := flag.Bool("insecure-open-relay", false, "allow the server to run without -ca and -allow, forwarding any client to any target")
+	certFile := flag.String("cert", "", "certificate file")
+	keyFile := flag.String("key", "", "key file")
+	caFile := flag.String("ca", "", "file with the CA certificates used to verify the peer")
+	serverName := flag.String("server-name", "", "the name used to verify the certificate of the server (the host of -server if empty)")
+	insecure := flag.Bool("insecure", false, "don't verify the certificate of the server")
+	statsInterval := flag.Duration("stats", time.Minute, "the interval for logging the statistics of every tunnel (disabled if zero)")
+	verbose := flag.Bool("v", false, "verbose")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *verbose {
+		utils.DefaultLogger.SetLogLevel(utils.LogLevelDebug)
+	}
+	tlsConf, err := loadTLSConfig(*certFile, *keyFile, *caFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	quicConf := &quic.Config{
+		Versions:     []quic.VersionNumber{quic.VersionQUIC1},
+		UseCryptoTLS: true,
+		KeepAlive:    true,
+	}
+
+	switch *mode {
+	case "server":
+		if tlsConf.ClientCAs = tlsConf.RootCAs; tlsConf.ClientCAs != nil {
+			tlsConf.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+		tlsConf.RootCAs = nil
+		if tlsConf.ClientCAs == nil && *allow == "" && !*openRelay {
+			log.Fatal("without -ca or -allow, the server is an open relay: anyone could connect to any host reachable from it (use -insecure-open-relay to allow this)")
+		}
+		log.Fatal(runServer(*listenAddr, tlsConf, quicConf, *allow))
+	case "client":
+		if *serverAddr == "" {
+			log.Fatal("missing -server")
+		}
+		if len(forwards) == 0 {
+			log.Fatal("missing -forward")
+		}
+		tlsConf.ServerName = *serverName
+		tlsConf.InsecureSkipVerify = *insecure
+		if err := runClient(*serverAddr, tlsConf, quicConf, forwards, *statsInterval); err != nil {
+			log.Fatal(err)
+		}
+	default:
+		log.Fatalf("invalid mode: %s", *mode)
+	}
+}
+
+// loadTLSConfig loads the certificate, and the CA certificates into the RootCAs
+func loadTLSConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	tlsConf := &tls.Config{}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConf.Certificates = []tls.Certificate{cert}
+	}
+	if caFile != "" {
+		data, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+		tlsConf.RootCAs = pool
+	}
+	return tlsConf, nil
+}
+
+func runServer(addr string, tlsConf *tls.Config, quicConf *quic.Config, allow string) error {
+	if len(tlsConf.Certificates) == 0 {
+		return errors.New("the server needs a certificate (-cert and -key)")
+	}
+	qkServer := &qk.Server{
+		TLSConfig:  tlsConf,
+		QuicConfig: quicConf,
+		SessionState: func(sess quic.Session, state qk.SessionState) {
+			log.Printf("Session from %s: %s", sess.RemoteAddr(), state)
+		},
+	}
+	server := tunnel.NewServer(qkServer)
+	if allow != "" {
+		allowed := make(map[string]bool)
+		for _, target := range strings.Split(allow, ",") {
+			allowed[strings.TrimSpace(target)] = true
+		}
+		server.Authorize = func(sess quic.Session, network, target string) error {
+			if !allowed[target] {
+				log.Printf("Refusing to forward %s to %s for %s", network, target, sess.RemoteAddr())
+				return errors.New("target not allowed")
+			}
+			return nil
+		}
+	}
+	log.Printf("Listening on %s", addr)
+	return qkServer.ListenAndServe(addr)
+}
+
+func runClient(serverAddr string, tlsConf *tls.Config, quicConf *quic.Config, forwards []forward, statsInterval time.Duration) error {
+	qkClient := &qk.Client{TLSConfig: tlsConf, QuicConfig: quicConf}
+	defer qkClient.Close()
+	client := tunnel.NewClient(qkClient, serverAddr)
+
+	tunnels := make([]*tunnel.Tunnel, 0, len(forwards))
+	for _, f := range forwards {
+		var tun *tunnel.Tunnel
+		var err error
+		if f.network == "tcp" {
+			tun, err = client.ListenTCP(f.localAddr, f.target)
+		} else {
+			tun, err = client.ListenUDP(f.localAddr, f.target)
+		}
+		if err != nil {
+			return err
+		}
+		defer tun.Close()
+		log.Printf("Forwarding %s/%s to %s via %s", f.network, tun.Addr(), f.target, serverAddr)
+		tunnels = append(tunnels, tun)
+	}
+
+	var ticker <-chan time.Time
+	if statsInterval > 0 {
+		t := time.NewTicker(statsInterval)
+		defer t.Stop()
+		ticker = t.C
+	}
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	done := make(chan *tunnel.Tunnel, len(tunnels))
+	for _, tun := range tunnels {
+		go func(tun *tunnel.Tunnel) {
+			<-tun.Done()
+			done <- tun
+		}(tun)
+	}
+	for {
+		select {
+		case <-ticker:
+			for _, tun := range tunnels {
+				logStats(tun)
+			}
+		case tun := <-done:
+			return fmt.Errorf("forwarding %s/%s failed: %s", tun.Network(), tun.Addr(), tun.Err())
+		case <-interrupt:
+			for _, tun := range tunnels {
+				logStats(tun)
+			}
+			return nil
+		}
+	}
+}
+
+func logStats(tun *tunnel.Tunnel) {
+	s := tun.Stats()
+	msg := fmt.Sprintf("%s/%s -> %s: %d active, %d total, %d failed connections, %d bytes sent, %d bytes received",
+		tun.Network(), tun.Addr(), tun.Target(), s.ActiveConns, s.TotalConns, s.FailedConns, s.BytesSent, s.BytesReceived)
+	if tun.Network() == "udp" {
+		msg += fmt.Sprintf(", %d datagrams sent, %d received, %d dropped", s.DatagramsSent, s.DatagramsReceived, s.DatagramsDropped)
+	}
+	log.Print(msg)
+}
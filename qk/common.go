@@ -0,0 +1,134 @@
+// Package qk provides a high-level API on top of QUIC sessions and streams.
+//
+// A Server accepts QUIC sessions, and dispatches every stream opened by a client to the Handler registered for the route of the stream.
+// The Client keeps a pool of sessions per address, and opens routed streams on them.
+// The route is sent in a stream header at the beginning of every stream, before any application data.
+//
+// Conn and Listener adapt QUIC streams to net.Conn and net.Listener, for libraries that only work with those interfaces.
+//
+// The H2Server serves an http.Handler over TCP and QUIC at the same time, and the H2Client sends requests over the faster of the two.
+package qk
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	quic "github.com/wheelcomplex/qk"
+	"github.com/wheelcomplex/qk/internal/utils"
+)
+
+// MaxRouteLength is the maximum length of a route
+const MaxRouteLength = 1024
+
+// The error codes used to reset streams
+const (
+	// ErrorCodeNoError is used when the stream is closed without an error
+	ErrorCodeNoError quic.ErrorCode = 0x0
+	// ErrorCodeUnknownRoute is used when no handler is registered for the route of the stream
+	ErrorCodeUnknownRoute quic.ErrorCode = 0x1
+	// ErrorCodeInvalidHeader is used when the stream header couldn't be read
+	ErrorCodeInvalidHeader quic.ErrorCode = 0x2
+	// ErrorCodeShuttingDown is used for streams opened after the server started shutting down
+	ErrorCodeShuttingDown quic.ErrorCode = 0x3
+	// ErrorCodeInternalError is used when the handler panicked
+	ErrorCodeInternalError quic.ErrorCode = 0x4
+)
+
+// A Stream is a QUIC stream with a route
+type Stream struct {
+	quic.Stream
+
+	route   string
+	session quic.Session
+}
+
+func newStream(str quic.Stream, route string, sess quic.Session) *Stream {
+	return &Stream{Stream: str, route: route, session: sess}
+}
+
+// Route returns the route of the stream
+func (s *Stream) Route() string {
+	return s.route
+}
+
+// Session returns the QUIC session that the stream belongs to
+func (s *Stream) Session() quic.Session {
+	return s.session
+}
+
+// A Handler handles streams opened by the peer.
+// When ServeStream returns, the stream is closed for writing.
+type Handler interface {
+	ServeStream(*Stream)
+}
+
+// The HandlerFunc type is an adapter to allow the use of ordinary functions as stream handlers.
+type HandlerFunc func(*Stream)
+
+// ServeStream calls f(str).
+func (f HandlerFunc) ServeStream(str *Stream) {
+	f(str)
+}
+
+var errRouteTooLong = fmt.Errorf("qk: route longer than %d bytes", MaxRouteLength)
+
+// writeStreamHeader writes the stream header.
+// It consists of the length of the route, encoded as a QUIC varint, followed by the route.
+func writeStreamHeader(w io.Writer, route string) error {
+	if len(route) > MaxRouteLength {
+		return errRouteTooLong
+	}
+	b := &bytes.Buffer{}
+	utils.WriteVarInt(b, uint64(len(route)))
+	b.WriteString(route)
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// readStreamHeader reads the stream header, and returns the route
+func readStreamHeader(r io.Reader) (string, error) {
+	br := &byteReader{Reader: r}
+	l, err := utils.ReadVarInt(br)
+	if err != nil {
+		if err == io.EOF {
+			return "", io.ErrUnexpectedEOF
+		}
+		return "", err
+	}
+	if l > MaxRouteLength {
+		return "", errRouteTooLong
+	}
+	route := make([]byte, l)
+	if _, err := io.ReadFull(r, route); err != nil {
+		if err == io.EOF {
+			return "", io.ErrUnexpectedEOF
+		}
+		return "", err
+	}
+	return string(route), nil
+}
+
+// byteReader implements io.ByteReader for a stream, without reading ahead.
+type byteReader struct {
+	io.Reader
+	buf [1]byte
+}
+
+func (r *byteReader) ReadByte() (byte, error) {
+	n, err := r.Reader.Read(r.buf[:])
+	if n == 1 {
+		return r.buf[0], nil
+	}
+	if err == nil {
+		err = io.ErrNoProgress
+	}
+	return 0, err
+}
+
+// ErrServerClosed is returned by the Server's Serve and ListenAndServe methods after a call to Close or Shutdown.
+var ErrServerClosed = errors.New("qk: Server closed")
+
+// ErrClientClosed is returned by the Client after a call to Close.
+var ErrClientClosed = errors.New("qk: Client closed")
@@ -0,0 +1,70 @@
+package qk
+
+import (
+	"bytes"
+	"io"
+	"strings"
+
+	"github.com/wheelcomplex/qk/internal/utils"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Stream header", func() {
+	It("writes and reads the header", func() {
+		b := &bytes.Buffer{}
+		Expect(writeStreamHeader(b, "echo")).To(Succeed())
+		Expect(b.Bytes()).To(Equal([]byte{4, 'e', 'c', 'h', 'o'}))
+		b.WriteString("payload")
+		route, err := readStreamHeader(b)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(route).To(Equal("echo"))
+		Expect(b.String()).To(Equal("payload"))
+	})
+
+	It("writes and reads an empty route", func() {
+		b := &bytes.Buffer{}
+		Expect(writeStreamHeader(b, "")).To(Succeed())
+		route, err := readStreamHeader(b)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(route).To(BeEmpty())
+	})
+
+	It("writes and reads long routes", func() {
+		b := &bytes.Buffer{}
+		r := strings.Repeat("a", MaxRouteLength)
+		Expect(writeStreamHeader(b, r)).To(Succeed())
+		route, err := readStreamHeader(b)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(route).To(Equal(r))
+	})
+
+	It("refuses to write routes that are too long", func() {
+		b := &bytes.Buffer{}
+		Expect(writeStreamHeader(b, strings.Repeat("a", MaxRouteLength+1))).To(MatchError(errRouteTooLong))
+		Expect(b.Len()).To(BeZero())
+	})
+
+	It("errors when reading routes that are too long", func() {
+		b := &bytes.Buffer{}
+		utils.WriteVarInt(b, MaxRouteLength+1)
+		_, err := readStreamHeader(b)
+		Expect(err).To(MatchError(errRouteTooLong))
+	})
+
+	It("errors if the stream ends before the header was read", func() {
+		_, err := readStreamHeader(&bytes.Buffer{})
+		Expect(err).To(Equal(io.ErrUnexpectedEOF))
+		_, err = readStreamHeader(bytes.NewReader([]byte{4, 'e', 'c'}))
+		Expect(err).To(Equal(io.ErrUnexpectedEOF))
+	})
+})
+
+var _ = Describe("Session states", func() {
+	It("has a string representation", func() {
+		Expect(StateNew.String()).To(Equal("new"))
+		Expect(StateClosed.String()).To(Equal("closed"))
+		Expect(SessionState(42).String()).To(Equal("unknown session state: 42"))
+	})
+})
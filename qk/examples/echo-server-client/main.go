@@ -0,0 +1,102 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+
+	"github.com/wheelcomplex/qk/internal/utils"
+	"github.com/wheelcomplex/qk/qk"
+	"github.com/wheelcomplex/qk/qk/examples/internal/bench"
+)
+
+// An echo server and client using qk.
+// By default, the server is started, and the client sends a message to it, waits for the echo, and exits.
+// Use -mode server or -mode client to run only one of them.
+func main() {
+	mode := flag.String("mode", "both", "server, client or both")
+	addr := flag.String("addr", "localhost:4242", "the address to listen on / connect to")
+	message := flag.String("message", "foobar", "the message sent by the client")
+	certFile := flag.String("cert", "", "certificate file (a self-signed certificate is generated if empty)")
+	keyFile := flag.String("key", "", "key file")
+	insecure := flag.Bool("insecure", true, "don't verify the certificate of the server")
+	verbose := flag.Bool("v", false, "verbose")
+	flag.Parse()
+
+	if *verbose {
+		utils.DefaultLogger.SetLogLevel(utils.LogLevelDebug)
+	}
+
+	switch *mode {
+	case "server":
+		log.Fatal(runServer(*addr, *certFile, *keyFile, nil))
+	case "client":
+		if err := runClient(*addr, *message, *insecure); err != nil {
+			log.Fatal(err)
+		}
+	case "both":
+		ready := make(chan net.Addr, 1)
+		go func() { log.Fatal(runServer(*addr, *certFile, *keyFile, ready)) }()
+		if err := runClient((<-ready).String(), *message, *insecure); err != nil {
+			log.Fatal(err)
+		}
+	default:
+		log.Fatalf("invalid mode: %s", *mode)
+	}
+}
+
+// runServer runs a server that echoes all data on streams with the route "echo".
+// The address of the server is sent on ready once it is listening.
+func runServer(addr, certFile, keyFile string, ready chan<- net.Addr) error {
+	tlsConf, err := bench.ServerTLSConfig(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	server := &qk.Server{TLSConfig: tlsConf}
+	server.HandleFunc("echo", func(str *qk.Stream) {
+		data, err := ioutil.ReadAll(str)
+		if err != nil {
+			log.Printf("Server: reading from stream %d failed: %s", str.StreamID(), err)
+			return
+		}
+		fmt.Printf("Server: Got '%s'\n", data)
+		if _, err := str.Write(data); err != nil {
+			log.Printf("Server: writing to stream %d failed: %s", str.StreamID(), err)
+		}
+	})
+	log.Printf("Server: listening on %s", conn.LocalAddr())
+	if ready != nil {
+		ready <- conn.LocalAddr()
+	}
+	return server.Serve(conn)
+}
+
+// runClient sends the message to the server, and waits for the echo
+func runClient(addr, message string, insecure bool) error {
+	client := &qk.Client{TLSConfig: bench.ClientTLSConfig(insecure)}
+	defer client.Close()
+
+	str, err := client.OpenStream(addr, "echo")
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Client: Sending '%s'\n", message)
+	if _, err := str.Write([]byte(message)); err != nil {
+		return err
+	}
+	if err := str.Close(); err != nil {
+		return err
+	}
+	data, err := ioutil.ReadAll(str)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Client: Got '%s'\n", data)
+	return nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	quic "github.com/wheelcomplex/qk"
+	"github.com/wheelcomplex/qk/h2quic"
+	"github.com/wheelcomplex/qk/internal/utils"
+	"github.com/wheelcomplex/qk/qk"
+	"github.com/wheelcomplex/qk/qk/examples/internal/bench"
+)
+
+// An HTTP client that fetches the URLs given as arguments.
+// By default, requests are sent over QUIC using the h2quic.RoundTripper.
+// With -race, the qk.H2Client races QUIC and TCP, and uses the faster connection.
+func main() {
+	race := flag.Bool("race", false, "race QUIC and TCP using the qk.H2Client")
+	printBody := flag.Bool("body", false, "print the response bodies")
+	v1 := flag.Bool("v1", false, "use QUIC v1 (RFC 9000) instead of gQUIC")
+	insecure := flag.Bool("insecure", false, "don't verify the certificate of the server")
+	verbose := flag.Bool("v", false, "verbose")
+	flag.Parse()
+	urls := flag.Args()
+
+	if *verbose {
+		utils.DefaultLogger.SetLogLevel(utils.LogLevelDebug)
+	}
+	if len(urls) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: h2client [flags] url...")
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+	quicConf := &quic.Config{}
+	if *v1 {
+		quicConf.Versions = []quic.VersionNumber{quic.VersionQUIC1}
+	}
+
+	var rt interface {
+		http.RoundTripper
+		io.Closer
+	}
+	if *race {
+		rt = &qk.H2Client{TLSClientConfig: bench.ClientTLSConfig(*insecure), QuicConfig: quicConf}
+	} else {
+		rt = &h2quic.RoundTripper{TLSClientConfig: bench.ClientTLSConfig(*insecure), QuicConfig: quicConf}
+	}
+	defer rt.Close()
+	client := &http.Client{Transport: rt}
+
+	var failed bool
+	var mutex sync.Mutex // serializes the output
+	var wg sync.WaitGroup
+	for _, url := range urls {
+		wg.Add(1)
+		go func(url string) {
+			defer wg.Done()
+			start := time.Now()
+			body, rsp, err := get(client, url)
+			mutex.Lock()
+			defer mutex.Unlock()
+			if err != nil {
+				log.Printf("GET %s failed: %s", url, err)
+				failed = true
+				return
+			}
+			fmt.Printf("GET %s: %s, %s, %d bytes in %s\n", url, rsp.Proto, rsp.Status, len(body), time.Since(start).Round(time.Millisecond))
+			if *printBody {
+				os.Stdout.Write(body)
+				fmt.Println()
+			}
+		}(url)
+	}
+	wg.Wait()
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func get(client *http.Client, url string) ([]byte, *http.Response, error) {
+	rsp, err := client.Get(url)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rsp.Body.Close()
+	body, err := ioutil.ReadAll(rsp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	return body, rsp, nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+	"log"
+
+	quic "github.com/wheelcomplex/qk"
+	"github.com/wheelcomplex/qk/internal/utils"
+	"github.com/wheelcomplex/qk/qk"
+	"github.com/wheelcomplex/qk/qk/examples/internal/bench"
+)
+
+// An HTTP server that serves requests over TCP and QUIC at the same time, using the qk.H2Server.
+// Responses sent over TCP advertise QUIC using the Alt-Svc header field.
+// It serves the /echo and /data handlers, and the files in the www directory.
+func main() {
+	addr := flag.String("addr", "localhost:6121", "the TCP and UDP address to listen on")
+	www := flag.String("www", "", "the directory to serve files from")
+	v1 := flag.Bool("v1", false, "accept QUIC v1 (RFC 9000) in addition to gQUIC")
+	certFile := flag.String("cert", "", "certificate file (a self-signed certificate is generated if empty)")
+	keyFile := flag.String("key", "", "key file")
+	verbose := flag.Bool("v", false, "verbose")
+	flag.Parse()
+
+	if *verbose {
+		utils.DefaultLogger.SetLogLevel(utils.LogLevelDebug)
+	}
+	server := &qk.H2Server{
+		Addr:    *addr,
+		Handler: bench.Handler(*www),
+	}
+	if *certFile != "" || *keyFile != "" {
+		// The certificate is reloaded from the files by ReloadCertificates.
+		server.CertFile = *certFile
+		server.KeyFile = *keyFile
+	} else {
+		tlsConf, err := bench.ServerTLSConfig("", "")
+		if err != nil {
+			log.Fatal(err)
+		}
+		server.TLSConfig = tlsConf
+	}
+	if *v1 {
+		server.QuicConfig = &quic.Config{
+			Versions: []quic.VersionNumber{quic.VersionGQUIC44, quic.VersionGQUIC43, quic.VersionGQUIC39, quic.VersionQUIC1},
+		}
+	}
+	log.Printf("Listening on %s (TCP and UDP)", *addr)
+	log.Fatal(server.ListenAndServe())
+}
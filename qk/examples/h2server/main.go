@@ -0,0 +1,46 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+
+	quic "github.com/wheelcomplex/qk"
+	"github.com/wheelcomplex/qk/h2quic"
+	"github.com/wheelcomplex/qk/internal/utils"
+	"github.com/wheelcomplex/qk/qk/examples/internal/bench"
+)
+
+// An HTTP server that serves requests over QUIC only, using the h2quic.Server.
+// It serves the /echo and /data handlers, and the files in the www directory.
+func main() {
+	addr := flag.String("addr", "localhost:6121", "the UDP address to listen on")
+	www := flag.String("www", "", "the directory to serve files from")
+	v1 := flag.Bool("v1", false, "accept QUIC v1 (RFC 9000) in addition to gQUIC")
+	certFile := flag.String("cert", "", "certificate file (a self-signed certificate is generated if empty)")
+	keyFile := flag.String("key", "", "key file")
+	verbose := flag.Bool("v", false, "verbose")
+	flag.Parse()
+
+	if *verbose {
+		utils.DefaultLogger.SetLogLevel(utils.LogLevelDebug)
+	}
+	tlsConf, err := bench.ServerTLSConfig(*certFile, *keyFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	quicConf := &quic.Config{}
+	if *v1 {
+		quicConf.Versions = []quic.VersionNumber{quic.VersionGQUIC44, quic.VersionGQUIC43, quic.VersionGQUIC39, quic.VersionQUIC1}
+	}
+	server := &h2quic.Server{
+		Server: &http.Server{
+			Addr:      *addr,
+			Handler:   bench.Handler(*www),
+			TLSConfig: tlsConf,
+		},
+		QuicConfig: quicConf,
+	}
+	log.Printf("Listening on %s", *addr)
+	log.Fatal(server.ListenAndServe())
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"os"
+	"sync"
+	"time"
+
+	quic "github.com/wheelcomplex/qk"
+	"github.com/wheelcomplex/qk/h2quic"
+	"github.com/wheelcomplex/qk/internal/utils"
+	"github.com/wheelcomplex/qk/qk/examples/internal/bench"
+)
+
+// A load generator for h2quic.
+// The client uses a number of h2quic.RoundTrippers, each using a single QUIC connection,
+// and sends a number of concurrent requests on every connection.
+// Every request uploads a payload to the /echo handler, and reads the echoed response body.
+// It reports the throughput, the handshake times and the request latencies.
+func main() {
+	mode := flag.String("mode", "both", "server, client or both")
+	addr := flag.String("addr", "localhost:6122", "the address to listen on / connect to")
+	sessions := flag.Int("sessions", 4, "number of QUIC connections")
+	streams := flag.Int("streams", 10, "number of concurrent requests per connection")
+	requests := flag.Int("requests", 100, "number of sequential requests per stream")
+	size := flag.Int("size", 1024, "request body size in bytes")
+	jsonOutput := flag.Bool("json", false, "print the report as JSON")
+	v1 := flag.Bool("v1", false, "use QUIC v1 (RFC 9000) instead of gQUIC")
+	certFile := flag.String("cert", "", "certificate file (a self-signed certificate is generated if empty)")
+	keyFile := flag.String("key", "", "key file")
+	insecure := flag.Bool("insecure", true, "don't verify the certificate of the server")
+	verbose := flag.Bool("v", false, "verbose")
+	flag.Parse()
+
+	if *verbose {
+		utils.DefaultLogger.SetLogLevel(utils.LogLevelDebug)
+	}
+	quicConf := &quic.Config{}
+	if *v1 {
+		quicConf.Versions = []quic.VersionNumber{quic.VersionQUIC1}
+	}
+	c := &stressClient{
+		tlsConf:  bench.ClientTLSConfig(*insecure),
+		quicConf: quicConf,
+		sessions: *sessions,
+		streams:  *streams,
+		requests: *requests,
+		size:     *size,
+	}
+
+	switch *mode {
+	case "server":
+		log.Fatal(runServer(*addr, *certFile, *keyFile, quicConf, nil))
+	case "client":
+		c.addr = *addr
+	case "both":
+		ready := make(chan net.Addr, 1)
+		go func() { log.Fatal(runServer(*addr, *certFile, *keyFile, quicConf, ready)) }()
+		c.addr = (<-ready).String()
+	default:
+		log.Fatalf("invalid mode: %s", *mode)
+	}
+
+	rep := c.run()
+	var err error
+	if *jsonOutput {
+		err = rep.WriteJSON(os.Stdout)
+	} else {
+		err = rep.WriteText(os.Stdout)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if rep.Errors > 0 {
+		os.Exit(1)
+	}
+}
+
+// runServer runs an h2quic.Server.
+// The address of the server is sent on ready once it is listening.
+func runServer(addr, certFile, keyFile string, quicConf *quic.Config, ready chan<- net.Addr) error {
+	tlsConf, err := bench.ServerTLSConfig(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	server := &h2quic.Server{
+		Server:     &http.Server{Handler: bench.Handler(""), TLSConfig: tlsConf},
+		QuicConfig: quicConf,
+	}
+	log.Printf("Server: listening on %s", conn.LocalAddr())
+	if ready != nil {
+		ready <- conn.LocalAddr()
+	}
+	return server.Serve(conn)
+}
+
+type stressClient struct {
+	addr     string
+	tlsConf  *tls.Config
+	quicConf *quic.Config
+
+	sessions int
+	streams  int
+	requests int
+	size     int
+}
+
+func (c *stressClient) run() *bench.Report {
+	rec := bench.NewRecorder()
+	payload := make([]byte, c.size)
+	for i := range payload {
+		payload[i] = byte(i)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < c.sessions; i++ {
+		rt := &h2quic.RoundTripper{
+			TLSClientConfig: c.tlsConf,
+			QuicConfig:      c.quicConf,
+			MaxConnsPerHost: 1,
+		}
+		defer rt.Close()
+		client := &http.Client{Transport: rt}
+		for j := 0; j < c.streams; j++ {
+			wg.Add(1)
+			go func(client *http.Client) {
+				defer wg.Done()
+				for k := 0; k < c.requests; k++ {
+					if err := c.request(client, payload, rec); err != nil {
+						rec.RecordError(err)
+						return
+					}
+				}
+			}(client)
+		}
+	}
+	wg.Wait()
+	return rec.Report("h2stress", c.sessions, c.sessions*c.streams)
+}
+
+// request uploads the payload to the /echo handler, and reads the response
+func (c *stressClient) request(client *http.Client, payload []byte, rec *bench.Recorder) error {
+	var handshakeStart time.Time
+	trace := &httptrace.ClientTrace{
+		TLSHandshakeStart: func() { handshakeStart = time.Now() },
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			if err == nil {
+				rec.RecordHandshake(time.Since(handshakeStart))
+			}
+		},
+	}
+	req, err := http.NewRequest(http.MethodPost, "https://"+c.addr+"/echo", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+
+	start := time.Now()
+	rsp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status: %s", rsp.Status)
+	}
+	n, err := io.Copy(ioutil.Discard, rsp.Body)
+	if err != nil {
+		return err
+	}
+	if n != int64(len(payload)) {
+		return fmt.Errorf("received %d bytes, expected %d", n, len(payload))
+	}
+	rec.RecordRequest(time.Since(start), len(payload)+int(n))
+	return nil
+}
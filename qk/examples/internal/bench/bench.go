@@ -0,0 +1,186 @@
+// Package bench contains the helpers shared by the qk example commands:
+// recording handshake times and request latencies, reporting them as text or JSON,
+// and setting up TLS configurations for the examples.
+package bench
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// A Recorder records the results of a load test.
+// It is safe for concurrent use.
+type Recorder struct {
+	mutex sync.Mutex
+
+	start      time.Time
+	handshakes []time.Duration
+	latencies  []time.Duration
+	bytes      int64
+	errors     int
+	firstErr   error
+}
+
+// NewRecorder creates a new Recorder.
+// The duration of the load test is measured from the time NewRecorder is called.
+func NewRecorder() *Recorder {
+	return &Recorder{start: time.Now()}
+}
+
+// RecordHandshake records the duration of a handshake.
+func (r *Recorder) RecordHandshake(d time.Duration) {
+	r.mutex.Lock()
+	r.handshakes = append(r.handshakes, d)
+	r.mutex.Unlock()
+}
+
+// RecordRequest records a successful request, and the number of payload bytes sent and received.
+func (r *Recorder) RecordRequest(latency time.Duration, bytes int) {
+	r.mutex.Lock()
+	r.latencies = append(r.latencies, latency)
+	r.bytes += int64(bytes)
+	r.mutex.Unlock()
+}
+
+// RecordError records a failed handshake or request.
+func (r *Recorder) RecordError(err error) {
+	r.mutex.Lock()
+	r.errors++
+	if r.firstErr == nil {
+		r.firstErr = err
+	}
+	r.mutex.Unlock()
+}
+
+// Report summarizes the results recorded so far.
+func (r *Recorder) Report(name string, sessions, streams int) *Report {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	duration := time.Since(r.start)
+	rep := &Report{
+		Name:      name,
+		Duration:  duration,
+		Sessions:  sessions,
+		Streams:   streams,
+		Requests:  len(r.latencies),
+		Errors:    r.errors,
+		Bytes:     r.bytes,
+		Handshake: NewStats(r.handshakes),
+		Latency:   NewStats(r.latencies),
+	}
+	if r.firstErr != nil {
+		rep.FirstError = r.firstErr.Error()
+	}
+	if seconds := duration.Seconds(); seconds > 0 {
+		rep.Throughput = float64(r.bytes) / seconds
+		rep.RequestRate = float64(len(r.latencies)) / seconds
+	}
+	return rep
+}
+
+// Stats summarizes a distribution of durations.
+type Stats struct {
+	Count int           `json:"count"`
+	Min   time.Duration `json:"min_ns"`
+	Mean  time.Duration `json:"mean_ns"`
+	P50   time.Duration `json:"p50_ns"`
+	P90   time.Duration `json:"p90_ns"`
+	P99   time.Duration `json:"p99_ns"`
+	P999  time.Duration `json:"p999_ns"`
+	Max   time.Duration `json:"max_ns"`
+}
+
+// NewStats calculates the statistics of the durations.
+func NewStats(durations []time.Duration) Stats {
+	if len(durations) == 0 {
+		return Stats{}
+	}
+	sorted := make([]time.Duration, len(durations))
+	copy(sorted, durations)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	var sum time.Duration
+	for _, d := range sorted {
+		sum += d
+	}
+	return Stats{
+		Count: len(sorted),
+		Min:   sorted[0],
+		Mean:  sum / time.Duration(len(sorted)),
+		P50:   percentile(sorted, 500),
+		P90:   percentile(sorted, 900),
+		P99:   percentile(sorted, 990),
+		P999:  percentile(sorted, 999),
+		Max:   sorted[len(sorted)-1],
+	}
+}
+
+// percentile returns the percentile of the sorted durations, given in per mille, using the nearest-rank method
+func percentile(sorted []time.Duration, permille int) time.Duration {
+	rank := (permille*len(sorted) + 999) / 1000
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// A Report is the result of a load test.
+type Report struct {
+	Name        string        `json:"name"`
+	Duration    time.Duration `json:"duration_ns"`
+	Sessions    int           `json:"sessions"`
+	Streams     int           `json:"streams"`
+	Requests    int           `json:"requests"`
+	Errors      int           `json:"errors"`
+	FirstError  string        `json:"first_error,omitempty"`
+	Bytes       int64         `json:"bytes"`
+	Throughput  float64       `json:"throughput_bytes_per_second"`
+	RequestRate float64       `json:"requests_per_second"`
+	Handshake   Stats         `json:"handshake"`
+	Latency     Stats         `json:"latency"`
+}
+
+// WriteJSON writes the report as JSON.
+func (r *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// WriteText writes the report in a human-readable format.
+func (r *Report) WriteText(w io.Writer) error {
+	_, err := fmt.Fprintf(w, `%s: %d sessions, %d streams in %s
+  requests:   %d (%.1f/s), %d errors
+  throughput: %.2f MB/s (%d bytes)
+  handshake:  %s
+  latency:    %s
+`,
+		r.Name, r.Sessions, r.Streams, r.Duration.Round(time.Millisecond),
+		r.Requests, r.RequestRate, r.Errors,
+		r.Throughput/1e6, r.Bytes,
+		r.Handshake, r.Latency,
+	)
+	if err != nil || r.FirstError == "" {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "  first error: %s\n", r.FirstError)
+	return err
+}
+
+func (s Stats) String() string {
+	if s.Count == 0 {
+		return "n/a"
+	}
+	return fmt.Sprintf("min %s, mean %s, p50 %s, p90 %s, p99 %s, p99.9 %s, max %s (%d samples)",
+		round(s.Min), round(s.Mean), round(s.P50), round(s.P90), round(s.P99), round(s.P999), round(s.Max), s.Count,
+	)
+}
+
+func round(d time.Duration) time.Duration {
+	return d.Round(time.Microsecond)
+}
@@ -0,0 +1,132 @@
+package bench
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Recorder", func() {
+	It("calculates statistics", func() {
+		var durations []time.Duration
+		for i := 1000; i > 0; i-- {
+			durations = append(durations, time.Duration(i)*time.Millisecond)
+		}
+		s := NewStats(durations)
+		Expect(s.Count).To(Equal(1000))
+		Expect(s.Min).To(Equal(time.Millisecond))
+		Expect(s.Max).To(Equal(1000 * time.Millisecond))
+		Expect(s.Mean).To(Equal(500500 * time.Microsecond))
+		Expect(s.P50).To(Equal(500 * time.Millisecond))
+		Expect(s.P90).To(Equal(900 * time.Millisecond))
+		Expect(s.P99).To(Equal(990 * time.Millisecond))
+		Expect(s.P999).To(Equal(999 * time.Millisecond))
+		// the input is not modified
+		Expect(durations[0]).To(Equal(1000 * time.Millisecond))
+	})
+
+	It("calculates statistics for a single sample", func() {
+		s := NewStats([]time.Duration{time.Second})
+		Expect(s.P50).To(Equal(time.Second))
+		Expect(s.P999).To(Equal(time.Second))
+	})
+
+	It("returns empty statistics without samples", func() {
+		Expect(NewStats(nil)).To(BeZero())
+		Expect(NewStats(nil).String()).To(Equal("n/a"))
+	})
+
+	It("reports the recorded results", func() {
+		r := NewRecorder()
+		r.RecordHandshake(10 * time.Millisecond)
+		r.RecordRequest(time.Millisecond, 100)
+		r.RecordRequest(2*time.Millisecond, 200)
+		r.RecordError(errors.New("foo"))
+		r.RecordError(errors.New("bar"))
+		rep := r.Report("test", 1, 2)
+		Expect(rep.Name).To(Equal("test"))
+		Expect(rep.Sessions).To(Equal(1))
+		Expect(rep.Streams).To(Equal(2))
+		Expect(rep.Requests).To(Equal(2))
+		Expect(rep.Errors).To(Equal(2))
+		Expect(rep.FirstError).To(Equal("foo"))
+		Expect(rep.Bytes).To(BeEquivalentTo(300))
+		Expect(rep.Handshake.Count).To(Equal(1))
+		Expect(rep.Latency.Max).To(Equal(2 * time.Millisecond))
+		Expect(rep.Throughput).To(BeNumerically(">", 0))
+		Expect(rep.RequestRate).To(BeNumerically(">", 0))
+	})
+
+	It("writes reports as JSON", func() {
+		r := NewRecorder()
+		r.RecordRequest(time.Millisecond, 100)
+		b := &bytes.Buffer{}
+		Expect(r.Report("test", 1, 1).WriteJSON(b)).To(Succeed())
+		var rep map[string]interface{}
+		Expect(json.Unmarshal(b.Bytes(), &rep)).To(Succeed())
+		Expect(rep).To(HaveKeyWithValue("name", "test"))
+		Expect(rep).To(HaveKeyWithValue("requests", BeEquivalentTo(1)))
+		Expect(rep).To(HaveKey("latency"))
+		Expect(rep).ToNot(HaveKey("first_error"))
+	})
+
+	It("writes reports as text", func() {
+		r := NewRecorder()
+		r.RecordRequest(time.Millisecond, 100)
+		r.RecordError(errors.New("foobar"))
+		b := &bytes.Buffer{}
+		Expect(r.Report("test", 1, 1).WriteText(b)).To(Succeed())
+		Expect(b.String()).To(ContainSubstring("test: 1 sessions, 1 streams"))
+		Expect(b.String()).To(ContainSubstring("p99 1ms"))
+		Expect(b.String()).To(ContainSubstring("first error: foobar"))
+	})
+})
+
+var _ = Describe("TLS configuration", func() {
+	It("generates a self-signed certificate", func() {
+		conf, err := ServerTLSConfig("", "")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(conf.Certificates).To(HaveLen(1))
+	})
+
+	It("errors if the certificate can't be loaded", func() {
+		_, err := ServerTLSConfig("/does/not/exist.pem", "/does/not/exist.key")
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("Handler", func() {
+	It("echoes the request body", func() {
+		w := httptest.NewRecorder()
+		Handler("").ServeHTTP(w, httptest.NewRequest("POST", "/echo", strings.NewReader("foobar")))
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Body.String()).To(Equal("foobar"))
+	})
+
+	It("sends the requested amount of data", func() {
+		w := httptest.NewRecorder()
+		Handler("").ServeHTTP(w, httptest.NewRequest("GET", "/data?size=100000", nil))
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Body.Len()).To(Equal(100000))
+		Expect(w.Header().Get("Content-Length")).To(Equal("100000"))
+	})
+
+	It("rejects invalid sizes", func() {
+		w := httptest.NewRecorder()
+		Handler("").ServeHTTP(w, httptest.NewRequest("GET", "/data?size=foo", nil))
+		Expect(w.Code).To(Equal(http.StatusBadRequest))
+	})
+
+	It("doesn't serve files without a www directory", func() {
+		w := httptest.NewRecorder()
+		Handler("").ServeHTTP(w, httptest.NewRequest("GET", "/index.html", nil))
+		Expect(w.Code).To(Equal(http.StatusNotFound))
+	})
+})
@@ -0,0 +1,44 @@
+package bench
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// maxDataSize is the largest response that the /data handler sends
+const maxDataSize = 1 << 30
+
+// Handler returns the http.Handler used by the HTTP example servers.
+//   - /echo responds with the request body.
+//   - /data?size=N responds with N bytes.
+//   - All other paths are served from the www directory, if it is not empty.
+func Handler(www string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/echo", func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(w, r.Body)
+	})
+	mux.HandleFunc("/data", func(w http.ResponseWriter, r *http.Request) {
+		size, err := strconv.Atoi(r.URL.Query().Get("size"))
+		if err != nil || size < 0 || size > maxDataSize {
+			http.Error(w, "invalid size", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(size))
+		buf := make([]byte, 32*1024)
+		for size > 0 {
+			n := len(buf)
+			if size < n {
+				n = size
+			}
+			if _, err := w.Write(buf[:n]); err != nil {
+				return
+			}
+			size -= n
+		}
+	})
+	if www != "" {
+		mux.Handle("/", http.FileServer(http.Dir(www)))
+	}
+	return mux
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	quic "github.com/wheelcomplex/qk"
+	"github.com/wheelcomplex/qk/internal/utils"
+	"github.com/wheelcomplex/qk/qk"
+	"github.com/wheelcomplex/qk/qk/examples/internal/bench"
+)
+
+// A load generator for qk.
+// The client opens a number of sessions, and a number of concurrent streams on every session.
+// On every stream, it sends a payload a number of times, and waits for the server to echo it.
+// It reports the throughput, the handshake times and the round trip latencies.
+func main() {
+	mode := flag.String("mode", "both", "server, client or both")
+	addr := flag.String("addr", "localhost:4243", "the address to listen on / connect to")
+	sessions := flag.Int("sessions", 4, "number of sessions")
+	streams := flag.Int("streams", 10, "number of concurrent streams per session")
+	requests := flag.Int("requests", 100, "number of round trips per stream")
+	size := flag.Int("size", 1024, "payload size in bytes")
+	jsonOutput := flag.Bool("json", false, "print the report as JSON")
+	v1 := flag.Bool("v1", false, "use QUIC v1 (RFC 9000) instead of gQUIC")
+	certFile := flag.String("cert", "", "certificate file (a self-signed certificate is generated if empty)")
+	keyFile := flag.String("key", "", "key file")
+	insecure := flag.Bool("insecure", true, "don't verify the certificate of the server")
+	verbose := flag.Bool("v", false, "verbose")
+	flag.Parse()
+
+	if *verbose {
+		utils.DefaultLogger.SetLogLevel(utils.LogLevelDebug)
+	}
+	quicConf := &quic.Config{}
+	if *v1 {
+		quicConf.Versions = []quic.VersionNumber{quic.VersionQUIC1}
+	}
+	c := &stressClient{
+		tlsConf:  bench.ClientTLSConfig(*insecure),
+		quicConf: quicConf,
+		sessions: *sessions,
+		streams:  *streams,
+		requests: *requests,
+		size:     *size,
+	}
+
+	switch *mode {
+	case "server":
+		log.Fatal(runServer(*addr, *certFile, *keyFile, quicConf, nil))
+	case "client":
+		c.addr = *addr
+	case "both":
+		ready := make(chan net.Addr, 1)
+		go func() { log.Fatal(runServer(*addr, *certFile, *keyFile, quicConf, ready)) }()
+		c.addr = (<-ready).String()
+	default:
+		log.Fatalf("invalid mode: %s", *mode)
+	}
+
+	rep := c.run()
+	var err error
+	if *jsonOutput {
+		err = rep.WriteJSON(os.Stdout)
+	} else {
+		err = rep.WriteText(os.Stdout)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	if rep.Errors > 0 {
+		os.Exit(1)
+	}
+}
+
+// runServer runs a server that echoes all data on streams with the route "echo".
+// The address of the server is sent on ready once it is listening.
+func runServer(addr, certFile, keyFile string, quicConf *quic.Config, ready chan<- net.Addr) error {
+	tlsConf, err := bench.ServerTLSConfig(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	server := &qk.Server{TLSConfig: tlsConf, QuicConfig: quicConf}
+	server.HandleFunc("echo", func(str *qk.Stream) {
+		io.Copy(str, str)
+	})
+	log.Printf("Server: listening on %s", conn.LocalAddr())
+	if ready != nil {
+		ready <- conn.LocalAddr()
+	}
+	return server.Serve(conn)
+}
+
+type stressClient struct {
+	addr     string
+	tlsConf  *tls.Config
+	quicConf *quic.Config
+
+	sessions int
+	streams  int
+	requests int
+	size     int
+}
+
+func (c *stressClient) run() *bench.Report {
+	rec := bench.NewRecorder()
+	payload := make([]byte, c.size)
+	for i := range payload {
+		payload[i] = byte(i)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < c.sessions; i++ {
+		// Every client keeps a single session to the server.
+		client := &qk.Client{
+			TLSConfig:  c.tlsConf,
+			QuicConfig: c.quicConf,
+			Dial: func(addr string, tlsConf *tls.Config, conf *quic.Config) (quic.Session, error) {
+				start := time.Now()
+				sess, err := quic.DialAddr(addr, tlsConf, conf)
+				if err == nil {
+					rec.RecordHandshake(time.Since(start))
+				}
+				return sess, err
+			},
+		}
+		defer client.Close()
+		for j := 0; j < c.streams; j++ {
+			wg.Add(1)
+			go func(client *qk.Client) {
+				defer wg.Done()
+				if err := c.runStream(client, payload, rec); err != nil {
+					rec.RecordError(err)
+				}
+			}(client)
+		}
+	}
+	wg.Wait()
+	return rec.Report("stress", c.sessions, c.sessions*c.streams)
+}
+
+// runStream opens a stream, and sends the payload the configured number of times, waiting for the echo every time
+func (c *stressClient) runStream(client *qk.Client, payload []byte, rec *bench.Recorder) error {
+	str, err := client.OpenStream(c.addr, "echo")
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, len(payload))
+	for i := 0; i < c.requests; i++ {
+		start := time.Now()
+		if _, err := str.Write(payload); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(str, buf); err != nil {
+			return err
+		}
+		rec.RecordRequest(time.Since(start), 2*len(payload))
+	}
+	if err := str.Close(); err != nil {
+		return err
+	}
+	if n, err := io.Copy(ioutil.Discard, str); err != nil || n > 0 {
+		return fmt.Errorf("unexpected end of stream %d: read %d bytes (%v)", str.StreamID(), n, err)
+	}
+	return nil
+}
@@ -0,0 +1,395 @@
+package qk
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	quic "github.com/wheelcomplex/qk"
+	"github.com/wheelcomplex/qk/h2quic"
+)
+
+var quicDialAddrContext = quic.DialAddrContext
+
+// defaultWinnerTTL is the default value of H2Client.WinnerTTL
+const defaultWinnerTTL = 10 * time.Minute
+
+// The transports used by the H2Client
+const (
+	transportQUIC = "quic"
+	transportTCP  = "tcp"
+)
+
+// An H2Client is an http.RoundTripper that sends requests over QUIC or TCP.
+//
+// For the first request to an origin, a QUIC session and a TCP connection are dialed in parallel.
+// The request is sent over the transport that completes its handshake first, and the other connection is closed.
+// The winner is remembered for the origin for WinnerTTL, and used for all requests sent to the origin during that time.
+// If a request sent over QUIC fails, it is retried over TCP (if the request body can be rewound),
+// and TCP is used for the origin until the winner expires.
+//
+// Requests with a scheme other than https are always sent over TCP.
+type H2Client struct {
+	// TLSClientConfig is the TLS configuration used for TCP connections and QUIC sessions.
+	// If nil, the default configuration is used.
+	TLSClientConfig *tls.Config
+
+	// QuicConfig is the quic.Config used for dialing QUIC sessions.
+	// If nil, reasonable default values are used.
+	QuicConfig *quic.Config
+
+	// DisableCompression prevents the client from requesting compression with an "Accept-Encoding: gzip" header.
+	DisableCompression bool
+
+	// TCPDialDelay delays dialing the TCP connection when racing QUIC and TCP, giving QUIC a head start.
+	TCPDialDelay time.Duration
+
+	// WinnerTTL is the amount of time the winner of a race is remembered for an origin.
+	// If zero, 10 minutes are used.
+	WinnerTTL time.Duration
+
+	initOnce sync.Once
+	quic     *h2quic.RoundTripper
+	tcp      *http.Transport
+
+	mutex       sync.Mutex
+	winners     map[string]*raceWinner // indexed by origin
+	races       map[string]*race       // races that are currently running, indexed by origin
+	quicSession map[string]quic.Session
+	tcpConn     map[string]net.Conn
+}
+
+type raceWinner struct {
+	transport string
+	expires   time.Time
+}
+
+type race struct {
+	done      chan struct{}
+	transport string
+	err       error
+	canceled  bool // the race failed because the context of the request that started it was canceled
+}
+
+var _ http.RoundTripper = &H2Client{}
+
+func (c *H2Client) init() {
+	c.quic = &h2quic.RoundTripper{
+		DisableCompression: c.DisableCompression,
+		TLSClientConfig:    c.TLSClientConfig,
+		QuicConfig:         c.quicConfig(),
+		Dial:               c.dialQUIC,
+	}
+	c.tcp = &http.Transport{
+		DialTLSContext:      c.dialTCP,
+		TLSClientConfig:     c.TLSClientConfig,
+		DisableCompression:  c.DisableCompression,
+		ForceAttemptHTTP2:   true,
+		MaxIdleConns:        100,
+		IdleConnTimeout:     90 * time.Second,
+		TLSHandshakeTimeout: 10 * time.Second,
+	}
+	c.winners = make(map[string]*raceWinner)
+	c.races = make(map[string]*race)
+	c.quicSession = make(map[string]quic.Session)
+	c.tcpConn = make(map[string]net.Conn)
+}
+
+func (c *H2Client) quicConfig() *quic.Config {
+	if c.QuicConfig != nil {
+		return c.QuicConfig
+	}
+	// the same values that h2quic uses by default
+	return &quic.Config{
+		RequestConnectionIDOmission: true,
+		KeepAlive:                   true,
+	}
+}
+
+func (c *H2Client) winnerTTL() time.Duration {
+	if c.WinnerTTL == 0 {
+		return defaultWinnerTTL
+	}
+	return c.WinnerTTL
+}
+
+// RoundTrip sends the request over the transport that won the race for the origin.
+func (c *H2Client) RoundTrip(req *http.Request) (*http.Response, error) {
+	c.initOnce.Do(c.init)
+
+	if req.URL == nil || req.URL.Scheme != "https" {
+		return c.tcp.RoundTrip(req)
+	}
+	origin := originAddr(req.URL.Host)
+	transport, err := c.getTransport(req.Context(), origin)
+	if err != nil {
+		closeRequestBody(req)
+		return nil, err
+	}
+	if transport == transportTCP {
+		rsp, err := c.tcp.RoundTrip(req)
+		c.discardConns(origin)
+		if err != nil && req.Context().Err() == nil {
+			c.forgetWinner(origin)
+		}
+		return rsp, err
+	}
+
+	rsp, err := c.quic.RoundTrip(req)
+	c.discardConns(origin)
+	if err == nil || req.Context().Err() != nil {
+		return rsp, err
+	}
+	c.setWinner(origin, transportTCP)
+	req, ok := rewindBody(req)
+	if !ok {
+		return nil, err
+	}
+	return c.tcp.RoundTrip(req)
+}
+
+// getTransport returns the transport to use for the origin.
+// If there's no winner for the origin, QUIC and TCP are raced.
+func (c *H2Client) getTransport(ctx context.Context, origin string) (string, error) {
+	for {
+		c.mutex.Lock()
+		if w, ok := c.winners[origin]; ok {
+			if time.Now().Before(w.expires) {
+				c.mutex.Unlock()
+				return w.transport, nil
+			}
+			delete(c.winners, origin)
+		}
+		if r, ok := c.races[origin]; ok {
+			c.mutex.Unlock()
+			select {
+			case <-r.done:
+			case <-ctx.Done():
+				return "", ctx.Err()
+			}
+			if r.canceled {
+				// The request that started the race was canceled. Start a new race.
+				continue
+			}
+			return r.transport, r.err
+		}
+		r := &race{done: make(chan struct{})}
+		c.races[origin] = r
+		c.mutex.Unlock()
+
+		r.transport, r.err = c.race(ctx, origin)
+		r.canceled = r.err != nil && ctx.Err() != nil
+
+		c.mutex.Lock()
+		delete(c.races, origin)
+		if r.err == nil {
+			c.winners[origin] = &raceWinner{transport: r.transport, expires: time.Now().Add(c.winnerTTL())}
+		}
+		c.mutex.Unlock()
+		close(r.done)
+		return r.transport, r.err
+	}
+}
+
+type dialResult struct {
+	transport string
+	session   quic.Session
+	conn      net.Conn
+	err       error
+}
+
+// race dials a QUIC session and a TCP connection to the origin.
+// The connection that's established first is handed to its transport by dialQUIC or dialTCP.
+// The other connection is closed.
+func (c *H2Client) race(ctx context.Context, origin string) (string, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	results := make(chan dialResult, 2)
+	go func() {
+		sess, err := quicDialAddrContext(ctx, origin, c.TLSClientConfig, c.quicConfig())
+		results <- dialResult{transport: transportQUIC, session: sess, err: err}
+	}()
+	go func() {
+		if c.TCPDialDelay > 0 {
+			timer := time.NewTimer(c.TCPDialDelay)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				results <- dialResult{transport: transportTCP, err: ctx.Err()}
+				return
+			}
+		}
+		conn, err := c.dialTLS(ctx, origin)
+		results <- dialResult{transport: transportTCP, conn: conn, err: err}
+	}()
+
+	var errs []dialResult
+	for i := 0; i < 2; i++ {
+		res := <-results
+		if res.err != nil {
+			errs = append(errs, res)
+			continue
+		}
+		cancel()
+		c.mutex.Lock()
+		if res.transport == transportQUIC {
+			c.quicSession[origin] = res.session
+		} else {
+			c.tcpConn[origin] = res.conn
+		}
+		c.mutex.Unlock()
+		if i == 0 {
+			// close the loser, if it manages to establish a connection anyway
+			go func() {
+				if res := <-results; res.err == nil {
+					res.close()
+				}
+			}()
+		}
+		return res.transport, nil
+	}
+	cancel()
+	if errs[0].transport == transportTCP {
+		errs[0], errs[1] = errs[1], errs[0]
+	}
+	return "", fmt.Errorf("qk: dialing %s failed (QUIC: %s, TCP: %s)", origin, errs[0].err, errs[1].err)
+}
+
+func (r *dialResult) close() {
+	if r.session != nil {
+		r.session.Close()
+	}
+	if r.conn != nil {
+		r.conn.Close()
+	}
+}
+
+// dialQUIC is used by the QUIC transport.
+// It returns the session established by the race, if there is one.
+func (c *H2Client) dialQUIC(_, addr string, tlsConf *tls.Config, config *quic.Config) (quic.Session, error) {
+	c.mutex.Lock()
+	sess, ok := c.quicSession[addr]
+	delete(c.quicSession, addr)
+	c.mutex.Unlock()
+	if ok {
+		return sess, nil
+	}
+	return quicDialAddrContext(context.Background(), addr, tlsConf, config)
+}
+
+// dialTCP is used by the TCP transport.
+// It returns the connection established by the race, if there is one.
+func (c *H2Client) dialTCP(ctx context.Context, _, addr string) (net.Conn, error) {
+	c.mutex.Lock()
+	conn, ok := c.tcpConn[addr]
+	delete(c.tcpConn, addr)
+	c.mutex.Unlock()
+	if ok {
+		return conn, nil
+	}
+	return c.dialTLS(ctx, addr)
+}
+
+func (c *H2Client) dialTLS(ctx context.Context, addr string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	var conf *tls.Config
+	if c.TLSClientConfig == nil {
+		conf = &tls.Config{}
+	} else {
+		conf = c.TLSClientConfig.Clone()
+	}
+	if conf.ServerName == "" {
+		conf.ServerName = host
+	}
+	if len(conf.NextProtos) == 0 {
+		conf.NextProtos = []string{"h2", "http/1.1"}
+	}
+	dialer := &tls.Dialer{Config: conf}
+	return dialer.DialContext(ctx, "tcp", addr)
+}
+
+// discardConns closes the connections established by a race that weren't used by the transport,
+// e.g. because the transport already had a connection to the origin.
+func (c *H2Client) discardConns(origin string) {
+	c.mutex.Lock()
+	sess := c.quicSession[origin]
+	delete(c.quicSession, origin)
+	conn := c.tcpConn[origin]
+	delete(c.tcpConn, origin)
+	c.mutex.Unlock()
+	if sess != nil {
+		sess.Close()
+	}
+	if conn != nil {
+		conn.Close()
+	}
+}
+
+func (c *H2Client) setWinner(origin, transport string) {
+	c.mutex.Lock()
+	c.winners[origin] = &raceWinner{transport: transport, expires: time.Now().Add(c.winnerTTL())}
+	c.mutex.Unlock()
+}
+
+func (c *H2Client) forgetWinner(origin string) {
+	c.mutex.Lock()
+	delete(c.winners, origin)
+	c.mutex.Unlock()
+}
+
+// CloseIdleConnections closes the idle QUIC sessions and TCP connections.
+func (c *H2Client) CloseIdleConnections() {
+	c.initOnce.Do(c.init)
+	c.tcp.CloseIdleConnections()
+	c.quic.CloseIdleConnections()
+}
+
+// Close closes all QUIC sessions, and the idle TCP connections.
+func (c *H2Client) Close() error {
+	c.initOnce.Do(c.init)
+	c.tcp.CloseIdleConnections()
+	return c.quic.Close()
+}
+
+// originAddr returns the address of an https origin, i.e. host:port
+func originAddr(authority string) string {
+	if _, _, err := net.SplitHostPort(authority); err == nil {
+		return authority
+	}
+	// IPv6 address literal, without a port
+	if len(authority) > 1 && authority[0] == '[' && authority[len(authority)-1] == ']' {
+		return authority + ":443"
+	}
+	return net.JoinHostPort(authority, "443")
+}
+
+func closeRequestBody(req *http.Request) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+}
+
+// rewindBody returns a request that can be sent again.
+// This is only possible if the request doesn't have a body, or if the body can be obtained again.
+func rewindBody(req *http.Request) (*http.Request, bool) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return req, true
+	}
+	if req.GetBody == nil {
+		return nil, false
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, false
+	}
+	newReq := *req
+	newReq.Body = body
+	return &newReq, true
+}
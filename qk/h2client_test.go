@@ -0,0 +1,196 @@
+package qk
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	quic "github.com/wheelcomplex/qk"
+	"github.com/wheelcomplex/qk/internal/testdata"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("H2Client", func() {
+	var (
+		client *H2Client
+		closer func()
+	)
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Write([]byte(r.URL.Path))
+		w.Write(body)
+	})
+
+	// startH2Server starts an H2Server, with TCP and UDP listening on the same port
+	startH2Server := func() int {
+		for {
+			ln, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).ToNot(HaveOccurred())
+			port := ln.Addr().(*net.TCPAddr).Port
+			conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port})
+			if err != nil {
+				ln.Close()
+				continue
+			}
+			server := &H2Server{TLSConfig: testdata.GetTLSConfig(), Handler: handler}
+			go server.Serve(ln, conn)
+			closer = func() { server.Close() }
+			return port
+		}
+	}
+
+	startTCPServer := func() int {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+		server := &http.Server{Handler: handler, TLSConfig: testdata.GetTLSConfig()}
+		go server.ServeTLS(ln, "", "")
+		closer = func() { server.Close() }
+		return ln.Addr().(*net.TCPAddr).Port
+	}
+
+	BeforeEach(func() {
+		closer = func() {}
+		client = &H2Client{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+			QuicConfig:      &quic.Config{HandshakeTimeout: 500 * time.Millisecond},
+		}
+	})
+
+	AfterEach(func() {
+		Expect(client.Close()).To(Succeed())
+		closer()
+	})
+
+	get := func(url string) (*http.Response, error) {
+		rsp, err := (&http.Client{Transport: client}).Get(url)
+		if err != nil {
+			return nil, err
+		}
+		defer rsp.Body.Close()
+		body, err := ioutil.ReadAll(rsp.Body)
+		ExpectWithOffset(1, err).ToNot(HaveOccurred())
+		ExpectWithOffset(1, string(body)).To(Equal("/hello"))
+		return rsp, nil
+	}
+
+	winner := func(origin string) string {
+		client.mutex.Lock()
+		defer client.mutex.Unlock()
+		if w, ok := client.winners[origin]; ok {
+			return w.transport
+		}
+		return ""
+	}
+
+	It("uses QUIC if it wins the race", func() {
+		port := startH2Server()
+		client.TCPDialDelay = time.Second
+		origin := fmt.Sprintf("localhost:%d", port)
+		rsp, err := get("https://" + origin + "/hello")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(rsp.TLS).To(BeNil())
+		Expect(winner(origin)).To(Equal(transportQUIC))
+		// the winner is used for subsequent requests
+		rsp, err = get("https://" + origin + "/hello")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(rsp.TLS).To(BeNil())
+	})
+
+	It("uses TCP if it wins the race", func() {
+		port := startTCPServer()
+		origin := fmt.Sprintf("localhost:%d", port)
+		rsp, err := get("https://" + origin + "/hello")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(rsp.TLS).ToNot(BeNil())
+		Expect(rsp.ProtoMajor).To(Equal(2))
+		Expect(winner(origin)).To(Equal(transportTCP))
+	})
+
+	It("races again when the winner expired", func() {
+		port := startTCPServer()
+		client.WinnerTTL = time.Nanosecond
+		origin := fmt.Sprintf("localhost:%d", port)
+		_, err := get("https://" + origin + "/hello")
+		Expect(err).ToNot(HaveOccurred())
+		client.mutex.Lock()
+		Expect(client.winners[origin].expires).To(BeTemporally("<=", time.Now()))
+		client.mutex.Unlock()
+		_, err = get("https://" + origin + "/hello")
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("falls back to TCP if a request sent over QUIC fails", func() {
+		port := startTCPServer()
+		origin := fmt.Sprintf("localhost:%d", port)
+		client.initOnce.Do(client.init)
+		client.setWinner(origin, transportQUIC)
+		req, err := http.NewRequest("POST", "https://"+origin+"/hello", strings.NewReader("foobar"))
+		Expect(err).ToNot(HaveOccurred())
+		rsp, err := client.RoundTrip(req)
+		Expect(err).ToNot(HaveOccurred())
+		defer rsp.Body.Close()
+		body, err := ioutil.ReadAll(rsp.Body)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(body)).To(Equal("/hellofoobar"))
+		Expect(rsp.TLS).ToNot(BeNil())
+		Expect(winner(origin)).To(Equal(transportTCP))
+	})
+
+	It("errors if both QUIC and TCP fail", func() {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+		port := ln.Addr().(*net.TCPAddr).Port
+		Expect(ln.Close()).To(Succeed())
+		origin := fmt.Sprintf("localhost:%d", port)
+		_, err = get("https://" + origin + "/hello")
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("QUIC: "))
+		Expect(err.Error()).To(ContainSubstring("TCP: "))
+		Expect(winner(origin)).To(BeEmpty())
+	})
+
+	It("sends http requests over TCP", func() {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+		server := &http.Server{Handler: handler}
+		go server.Serve(ln)
+		closer = func() { server.Close() }
+		rsp, err := get(fmt.Sprintf("http://localhost:%d/hello", ln.Addr().(*net.TCPAddr).Port))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(rsp.ProtoMajor).To(Equal(1))
+	})
+
+	It("waits for the race for concurrent requests", func() {
+		port := startH2Server()
+		client.TCPDialDelay = time.Second
+		origin := fmt.Sprintf("localhost:%d", port)
+		errChan := make(chan error, 5)
+		for i := 0; i < 5; i++ {
+			go func() {
+				defer GinkgoRecover()
+				_, err := get("https://" + origin + "/hello")
+				errChan <- err
+			}()
+		}
+		for i := 0; i < 5; i++ {
+			Eventually(errChan, 5*time.Second).Should(Receive(BeNil()))
+		}
+		Expect(winner(origin)).To(Equal(transportQUIC))
+	})
+
+	Context("origin addresses", func() {
+		It("adds the default port", func() {
+			Expect(originAddr("example.com")).To(Equal("example.com:443"))
+			Expect(originAddr("example.com:8443")).To(Equal("example.com:8443"))
+			Expect(originAddr("[::1]")).To(Equal("[::1]:443"))
+			Expect(originAddr("[::1]:8443")).To(Equal("[::1]:8443"))
+		})
+	})
+})
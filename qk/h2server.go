@@ -0,0 +1,330 @@
+package qk
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+
+	quic "github.com/wheelcomplex/qk"
+	"github.com/wheelcomplex/qk/h2quic"
+)
+
+var (
+	errNoCertificates         = errors.New("qk: no certificates configured")
+	errNoCertificateFiles     = errors.New("qk: no certificate files configured")
+	errCertificatesNotManaged = errors.New("qk: certificates are provided by TLSConfig.GetCertificate")
+)
+
+// An H2Server serves an http.Handler over TCP (HTTP/1.1 and HTTP/2) and QUIC at the same time.
+// The Alt-Svc header field announcing the QUIC server is added to every response.
+//
+// The certificate is either loaded from CertFile and KeyFile, or taken from TLSConfig.
+// It can be replaced while the server is running, using ReloadCertificates or SetCertificates.
+// New TCP connections and QUIC sessions use the new certificate right away,
+// with the exception of the TLS-based QUIC versions using mint (i.e. unless the QuicConfig sets UseCryptoTLS),
+// which use the certificates that were configured when Serve was called.
+type H2Server struct {
+	// Addr is the TCP and UDP address to listen on. If empty, ":https" is used.
+	Addr string
+
+	// Handler is the handler for requests received over TCP and QUIC.
+	// If nil, http.DefaultServeMux is used.
+	Handler http.Handler
+
+	// TLSConfig is the TLS configuration used for TCP connections and QUIC sessions.
+	// If CertFile and KeyFile are set, the certificates of the TLSConfig are ignored.
+	TLSConfig *tls.Config
+
+	// CertFile and KeyFile are the files the certificate is loaded from.
+	CertFile string
+	KeyFile  string
+
+	// QuicConfig is the quic.Config used for accepting QUIC sessions.
+	// If nil, reasonable default values are used.
+	QuicConfig *quic.Config
+
+	// HTTPServer optionally provides the settings of the http.Server, e.g. timeouts, ErrorLog and the ConnState hook.
+	// Its Addr, Handler and TLSConfig are ignored.
+	HTTPServer *http.Server
+
+	certs certStore
+
+	mutex      sync.Mutex
+	tcpServer  *http.Server
+	quicServer *h2quic.Server
+	tcpAddr    net.Addr
+	udpAddr    net.Addr
+	closed     bool
+}
+
+// ListenAndServe listens on the TCP and UDP address s.Addr, and serves requests received over both.
+// It returns when one of the two servers returns an error, after closing the other one.
+func (s *H2Server) ListenAndServe() error {
+	addr := s.Addr
+	if addr == "" {
+		addr = ":https"
+	}
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return err
+	}
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return err
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	return s.Serve(ln, conn)
+}
+
+// Serve serves requests received on the TCP listener and on the UDP connection.
+// The listener and the connection are closed when Serve returns.
+// It returns when one of the two servers returns an error, after closing the other one.
+// After Close, the returned error is ErrServerClosed.
+func (s *H2Server) Serve(ln net.Listener, conn net.PacketConn) error {
+	tlsConf, quicTLSConf, err := s.tlsConfig()
+	if err != nil {
+		ln.Close()
+		conn.Close()
+		return err
+	}
+
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		ln.Close()
+		conn.Close()
+		return ErrServerClosed
+	}
+	if s.tcpServer != nil {
+		s.mutex.Unlock()
+		ln.Close()
+		conn.Close()
+		return errors.New("qk: Serve may only be called once")
+	}
+	handler := s.Handler
+	if handler == nil {
+		handler = http.DefaultServeMux
+	}
+	// The QUIC server uses the address of the UDP connection to generate the Alt-Svc header field.
+	quicServer := &h2quic.Server{
+		Server:     s.newHTTPServer(conn.LocalAddr().String(), quicTLSConf),
+		QuicConfig: s.QuicConfig,
+	}
+	handler = altSvcHandler(quicServer, handler)
+	quicServer.Handler = handler
+	tcpServer := s.newHTTPServer(ln.Addr().String(), tlsConf)
+	tcpServer.Handler = handler
+	s.quicServer = quicServer
+	s.tcpServer = tcpServer
+	s.tcpAddr = ln.Addr()
+	s.udpAddr = conn.LocalAddr()
+	s.mutex.Unlock()
+
+	tcpErr := make(chan error, 1)
+	quicErr := make(chan error, 1)
+	go func() {
+		tcpErr <- tcpServer.ServeTLS(ln, "", "")
+	}()
+	go func() {
+		quicErr <- quicServer.Serve(conn)
+	}()
+
+	select {
+	case err = <-tcpErr:
+		quicServer.Close()
+		<-quicErr
+	case err = <-quicErr:
+		tcpServer.Close()
+		<-tcpErr
+	}
+	conn.Close()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return ErrServerClosed
+	}
+	return err
+}
+
+func altSvcHandler(quicServer *h2quic.Server, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		quicServer.SetQuicHeaders(w.Header())
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// newHTTPServer creates an http.Server with the settings of s.HTTPServer
+func (s *H2Server) newHTTPServer(addr string, tlsConf *tls.Config) *http.Server {
+	srv := &http.Server{
+		Addr:      addr,
+		TLSConfig: tlsConf,
+	}
+	if t := s.HTTPServer; t != nil {
+		srv.ReadTimeout = t.ReadTimeout
+		srv.ReadHeaderTimeout = t.ReadHeaderTimeout
+		srv.WriteTimeout = t.WriteTimeout
+		srv.IdleTimeout = t.IdleTimeout
+		srv.MaxHeaderBytes = t.MaxHeaderBytes
+		srv.ConnState = t.ConnState
+		srv.ErrorLog = t.ErrorLog
+		srv.BaseContext = t.BaseContext
+		srv.ConnContext = t.ConnContext
+	}
+	return srv
+}
+
+// tlsConfig returns the tls.Config used by the TCP server, and the one used by the QUIC server.
+// Unless the TLSConfig has a GetCertificate callback, the certificates are served from the certStore, so that they can be replaced.
+func (s *H2Server) tlsConfig() (*tls.Config, *tls.Config, error) {
+	var conf *tls.Config
+	if s.TLSConfig == nil {
+		conf = &tls.Config{}
+	} else {
+		conf = s.TLSConfig.Clone()
+	}
+	if s.CertFile != "" || s.KeyFile != "" {
+		if s.certs.empty() {
+			if err := s.ReloadCertificates(); err != nil {
+				return nil, nil, err
+			}
+		}
+	} else {
+		if conf.GetCertificate != nil {
+			return conf, conf, nil
+		}
+		if s.certs.empty() {
+			if len(conf.Certificates) == 0 {
+				return nil, nil, errNoCertificates
+			}
+			s.certs.set(conf.Certificates)
+		}
+	}
+	// crypto/tls only calls GetCertificate if the client sent a server name, or if there are no Certificates.
+	conf.Certificates = nil
+	conf.GetCertificate = s.certs.getCertificate
+	quicConf := conf
+	if s.QuicConfig == nil || !s.QuicConfig.UseCryptoTLS {
+		// mint only uses the Certificates, and converts them when the QUIC server is started.
+		quicConf = conf.Clone()
+		quicConf.Certificates = s.certs.all()
+	}
+	return conf, quicConf, nil
+}
+
+// ReloadCertificates loads the certificate from CertFile and KeyFile.
+// If loading fails, the server continues using the previous certificate.
+func (s *H2Server) ReloadCertificates() error {
+	if s.CertFile == "" || s.KeyFile == "" {
+		return errNoCertificateFiles
+	}
+	cert, err := tls.LoadX509KeyPair(s.CertFile, s.KeyFile)
+	if err != nil {
+		return err
+	}
+	s.certs.set([]tls.Certificate{cert})
+	return nil
+}
+
+// SetCertificates replaces the certificates used by the server.
+// It returns an error if the certificates are provided by a GetCertificate callback of the TLSConfig.
+func (s *H2Server) SetCertificates(certs []tls.Certificate) error {
+	if len(certs) == 0 {
+		return errNoCertificates
+	}
+	if s.CertFile == "" && s.KeyFile == "" && s.TLSConfig != nil && s.TLSConfig.GetCertificate != nil {
+		return errCertificatesNotManaged
+	}
+	s.certs.set(certs)
+	return nil
+}
+
+// TCPAddr returns the address of the TCP listener.
+// It returns nil if the server is not serving yet.
+func (s *H2Server) TCPAddr() net.Addr {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.tcpAddr
+}
+
+// UDPAddr returns the address of the UDP connection used by the QUIC server.
+// It returns nil if the server is not serving yet.
+func (s *H2Server) UDPAddr() net.Addr {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.udpAddr
+}
+
+// Close closes the TCP and the QUIC server immediately.
+func (s *H2Server) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	if s.tcpServer == nil {
+		return nil
+	}
+	err := s.tcpServer.Close()
+	if qerr := s.quicServer.Close(); err == nil {
+		err = qerr
+	}
+	return err
+}
+
+// A certStore holds the certificates of the H2Server.
+// It is safe for concurrent use.
+type certStore struct {
+	mutex sync.RWMutex
+	certs []tls.Certificate
+}
+
+func (s *certStore) set(certs []tls.Certificate) {
+	certs = append([]tls.Certificate(nil), certs...)
+	for i := range certs {
+		if certs[i].Leaf == nil && len(certs[i].Certificate) > 0 {
+			certs[i].Leaf, _ = x509.ParseCertificate(certs[i].Certificate[0])
+		}
+	}
+	s.mutex.Lock()
+	s.certs = certs
+	s.mutex.Unlock()
+}
+
+func (s *certStore) empty() bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return len(s.certs) == 0
+}
+
+func (s *certStore) all() []tls.Certificate {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.certs
+}
+
+// getCertificate returns the first certificate that is valid for the server name.
+// If there's no such certificate, the first certificate is returned.
+func (s *certStore) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if len(s.certs) == 0 {
+		return nil, errNoCertificates
+	}
+	if hello != nil && hello.ServerName != "" {
+		for i := range s.certs {
+			if leaf := s.certs[i].Leaf; leaf != nil && leaf.VerifyHostname(hello.ServerName) == nil {
+				return &s.certs[i], nil
+			}
+		}
+	}
+	return &s.certs[0], nil
+}
@@ -0,0 +1,249 @@
+package qk
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	quic "github.com/wheelcomplex/qk"
+	"github.com/wheelcomplex/qk/h2quic"
+	"github.com/wheelcomplex/qk/internal/testdata"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// generateCertificate generates a self-signed certificate for localhost
+func generateCertificate(serial int64) (tls.Certificate, []byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).ToNot(HaveOccurred())
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	Expect(err).ToNot(HaveOccurred())
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	Expect(err).ToNot(HaveOccurred())
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	Expect(err).ToNot(HaveOccurred())
+	return cert, certPEM, keyPEM
+}
+
+var _ = Describe("H2Server", func() {
+	var (
+		server    *H2Server
+		serveErr  chan error
+		tcpURL    string
+		tcpClient *http.Client
+	)
+
+	startServer := func() {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		Expect(err).ToNot(HaveOccurred())
+		errChan := make(chan error, 1)
+		serveErr = errChan
+		go func() { errChan <- server.Serve(ln, conn) }()
+		Eventually(server.TCPAddr).ShouldNot(BeNil())
+		tcpURL = fmt.Sprintf("https://localhost:%d/hello", ln.Addr().(*net.TCPAddr).Port)
+	}
+
+	BeforeEach(func() {
+		server = &H2Server{
+			TLSConfig: testdata.GetTLSConfig(),
+			Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("hello " + r.Proto))
+			}),
+		}
+		tcpClient = &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+				ForceAttemptHTTP2: true,
+				DisableKeepAlives: true,
+			},
+		}
+	})
+
+	AfterEach(func() {
+		Expect(server.Close()).To(Succeed())
+	})
+
+	get := func(client *http.Client, url string) (*http.Response, string) {
+		rsp, err := client.Get(url)
+		ExpectWithOffset(1, err).ToNot(HaveOccurred())
+		defer rsp.Body.Close()
+		body, err := ioutil.ReadAll(rsp.Body)
+		ExpectWithOffset(1, err).ToNot(HaveOccurred())
+		return rsp, string(body)
+	}
+
+	It("errors without certificates", func() {
+		server.TLSConfig = nil
+		server.Addr = "localhost:0"
+		Expect(server.ListenAndServe()).To(MatchError(errNoCertificates))
+	})
+
+	It("serves requests over TCP, and sets the Alt-Svc header", func() {
+		startServer()
+		rsp, body := get(tcpClient, tcpURL)
+		Expect(rsp.StatusCode).To(Equal(200))
+		Expect(rsp.ProtoMajor).To(Equal(2))
+		Expect(body).To(Equal("hello HTTP/2.0"))
+		port := server.UDPAddr().(*net.UDPAddr).Port
+		Expect(rsp.Header.Get("Alt-Svc")).To(ContainSubstring(fmt.Sprintf(`quic=":%d"`, port)))
+	})
+
+	It("serves requests over QUIC", func() {
+		startServer()
+		rt := &h2quic.RoundTripper{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+		defer rt.Close()
+		url := fmt.Sprintf("https://localhost:%d/hello", server.UDPAddr().(*net.UDPAddr).Port)
+		rsp, body := get(&http.Client{Transport: rt}, url)
+		Expect(rsp.StatusCode).To(Equal(200))
+		Expect(body).To(Equal("hello HTTP/2.0"))
+		Expect(rsp.Header.Get("Alt-Svc")).ToNot(BeEmpty())
+	})
+
+	It("returns ErrServerClosed after Close", func() {
+		startServer()
+		Expect(server.Close()).To(Succeed())
+		Eventually(serveErr).Should(Receive(Equal(ErrServerClosed)))
+	})
+
+	It("errors when Serve is called twice", func() {
+		startServer()
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(server.Serve(ln, conn)).To(MatchError("qk: Serve may only be called once"))
+	})
+
+	Context("certificates", func() {
+		serial := func() int64 {
+			rsp, _ := get(tcpClient, tcpURL)
+			return rsp.TLS.PeerCertificates[0].SerialNumber.Int64()
+		}
+
+		It("loads and reloads the certificate from files", func() {
+			dir, err := ioutil.TempDir("", "qk")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(dir)
+			writeCert := func(serial int64) {
+				_, certPEM, keyPEM := generateCertificate(serial)
+				Expect(ioutil.WriteFile(filepath.Join(dir, "cert.pem"), certPEM, 0600)).To(Succeed())
+				Expect(ioutil.WriteFile(filepath.Join(dir, "key.pem"), keyPEM, 0600)).To(Succeed())
+			}
+			writeCert(1)
+			server.TLSConfig = nil
+			server.CertFile = filepath.Join(dir, "cert.pem")
+			server.KeyFile = filepath.Join(dir, "key.pem")
+			startServer()
+			Expect(serial()).To(BeEquivalentTo(1))
+			writeCert(2)
+			Expect(server.ReloadCertificates()).To(Succeed())
+			Expect(serial()).To(BeEquivalentTo(2))
+		})
+
+		It("keeps the certificate if reloading fails", func() {
+			certFile, keyFile := testdata.GetCertificatePaths()
+			server.CertFile = certFile
+			server.KeyFile = keyFile
+			startServer()
+			server.KeyFile = "/does/not/exist"
+			Expect(server.ReloadCertificates()).ToNot(Succeed())
+			rsp, _ := get(tcpClient, tcpURL)
+			Expect(rsp.TLS.PeerCertificates[0].Subject.CommonName).To(Equal("quic.clemente.io"))
+		})
+
+		It("errors when reloading without certificate files", func() {
+			Expect(server.ReloadCertificates()).To(MatchError(errNoCertificateFiles))
+		})
+
+		It("replaces the certificates", func() {
+			cert, _, _ := generateCertificate(3)
+			server.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+			startServer()
+			Expect(serial()).To(BeEquivalentTo(3))
+			cert, _, _ = generateCertificate(4)
+			Expect(server.SetCertificates([]tls.Certificate{cert})).To(Succeed())
+			Expect(serial()).To(BeEquivalentTo(4))
+		})
+
+		It("uses the replaced certificates for clients that don't send a server name", func() {
+			cert, _, _ := generateCertificate(7)
+			server.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+			server.QuicConfig = &quic.Config{
+				Versions:     []quic.VersionNumber{quic.VersionQUIC1},
+				UseCryptoTLS: true,
+			}
+			startServer()
+			cert, _, _ = generateCertificate(8)
+			Expect(server.SetCertificates([]tls.Certificate{cert})).To(Succeed())
+			// When dialing an IP address, the client doesn't send a server name.
+			conn, err := tls.Dial("tcp", server.TCPAddr().String(), &tls.Config{InsecureSkipVerify: true})
+			Expect(err).ToNot(HaveOccurred())
+			defer conn.Close()
+			Expect(conn.ConnectionState().ServerName).To(BeEmpty())
+			Expect(conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64()).To(BeEquivalentTo(8))
+			sess, err := quic.DialAddr(
+				server.UDPAddr().String(),
+				&tls.Config{InsecureSkipVerify: true},
+				&quic.Config{Versions: []quic.VersionNumber{quic.VersionQUIC1}, UseCryptoTLS: true},
+			)
+			Expect(err).ToNot(HaveOccurred())
+			defer sess.Close()
+			Expect(sess.ConnectionState().PeerCertificates[0].SerialNumber.Int64()).To(BeEquivalentTo(8))
+		})
+
+		It("uses the GetCertificate callback of the tls.Config", func() {
+			cert, _, _ := generateCertificate(5)
+			server.TLSConfig = &tls.Config{
+				GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) { return &cert, nil },
+			}
+			startServer()
+			Expect(serial()).To(BeEquivalentTo(5))
+			Expect(server.SetCertificates([]tls.Certificate{cert})).To(MatchError(errCertificatesNotManaged))
+		})
+	})
+
+	Context("certStore", func() {
+		It("selects the certificate by server name", func() {
+			localhost, _, _ := generateCertificate(6)
+			store := &certStore{}
+			store.set([]tls.Certificate{testdata.GetCertificate(), localhost})
+			cert, err := store.getCertificate(&tls.ClientHelloInfo{ServerName: "localhost"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cert.Leaf.SerialNumber.Int64()).To(BeEquivalentTo(6))
+			cert, err = store.getCertificate(&tls.ClientHelloInfo{ServerName: "example.com"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cert.Leaf.Subject.CommonName).To(Equal("quic.clemente.io"))
+		})
+
+		It("errors when it is empty", func() {
+			_, err := (&certStore{}).getCertificate(&tls.ClientHelloInfo{})
+			Expect(err).To(MatchError(errNoCertificates))
+		})
+	})
+})
@@ -0,0 +1,360 @@
+package qk
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	quic "github.com/wheelcomplex/qk"
+)
+
+// sessionLingerTimeout is the time that a session owned by a Conn is kept open after the Conn was closed,
+// such that the peer can receive the data that was written before closing.
+// The session is closed earlier if the peer closes it.
+var sessionLingerTimeout = 3 * time.Second
+
+var errUnexpectedRoute = errors.New("qk: unexpected route in the stream header of a Conn")
+
+// A Conn is a net.Conn that reads from and writes to a QUIC stream.
+//
+// Every stream used by a Conn starts with a stream header with an empty route,
+// such that the peer can accept the stream before any application data was sent.
+//
+// Closing a Conn closes the write direction of the stream, and cancels the read direction.
+// CloseWrite and CloseRead can be used to close a single direction.
+// If the Conn was returned by a Listener created with NewListener, or by DialAddr, it owns the session,
+// and the session is closed shortly after the Conn.
+type Conn struct {
+	str         quic.Stream
+	sess        quic.Session
+	ownsSession bool
+
+	mutex       sync.Mutex
+	closed      bool
+	writeClosed bool
+}
+
+var _ net.Conn = &Conn{}
+
+func newConn(str quic.Stream, sess quic.Session, ownsSession bool) *Conn {
+	return &Conn{str: str, sess: sess, ownsSession: ownsSession}
+}
+
+// DialAddr establishes a new QUIC session to the server at addr, and returns a Conn for a new stream on it.
+// Closing the Conn closes the session.
+func DialAddr(addr string, tlsConf *tls.Config, config *quic.Config) (*Conn, error) {
+	return DialAddrContext(context.Background(), addr, tlsConf, config)
+}
+
+// DialAddrContext is like DialAddr, using the provided context for establishing the session.
+func DialAddrContext(ctx context.Context, addr string, tlsConf *tls.Config, config *quic.Config) (*Conn, error) {
+	sess, err := quicDialAddrContext(ctx, addr, tlsConf, config)
+	if err != nil {
+		return nil, err
+	}
+	str, err := openConnStream(sess)
+	if err != nil {
+		sess.Close()
+		return nil, err
+	}
+	return newConn(str, sess, true), nil
+}
+
+// OpenConn opens a new stream on the session, and returns a Conn for it.
+// The peer accepts the Conn using a Listener created with NewSessionListener.
+// It blocks until the peer's stream limit allows opening a new stream.
+// Closing the Conn doesn't close the session.
+func OpenConn(sess quic.Session) (*Conn, error) {
+	str, err := openConnStream(sess)
+	if err != nil {
+		return nil, err
+	}
+	return newConn(str, sess, false), nil
+}
+
+func openConnStream(sess quic.Session) (quic.Stream, error) {
+	str, err := sess.OpenStreamSync()
+	if err != nil {
+		return nil, err
+	}
+	if err := writeStreamHeader(str, ""); err != nil {
+		str.CancelWrite(ErrorCodeInvalidHeader)
+		str.CancelRead(ErrorCodeInvalidHeader)
+		return nil, err
+	}
+	return str, nil
+}
+
+// Read reads data from the stream.
+// It returns io.EOF once the peer closed the write direction of the stream.
+func (c *Conn) Read(b []byte) (int, error) {
+	n, err := c.str.Read(b)
+	if err != nil && err != io.EOF && c.isClosed() {
+		err = net.ErrClosed
+	}
+	return n, err
+}
+
+// Write writes data to the stream.
+func (c *Conn) Write(b []byte) (int, error) {
+	n, err := c.str.Write(b)
+	if err != nil && c.isClosed() {
+		err = net.ErrClosed
+	}
+	return n, err
+}
+
+func (c *Conn) isClosed() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.closed
+}
+
+// CloseWrite closes the write direction of the stream.
+// The peer reads io.EOF after it received all data.
+func (c *Conn) CloseWrite() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.writeClosed {
+		return nil
+	}
+	c.writeClosed = true
+	return c.str.Close()
+}
+
+// CloseRead cancels the read direction of the stream.
+// Data sent by the peer is discarded.
+func (c *Conn) CloseRead() error {
+	return c.str.CancelRead(ErrorCodeNoError)
+}
+
+// Close closes both directions of the stream.
+// Data that was already written is still delivered to the peer.
+func (c *Conn) Close() error {
+	c.mutex.Lock()
+	if c.closed {
+		c.mutex.Unlock()
+		return nil
+	}
+	c.closed = true
+	closeWrite := !c.writeClosed
+	c.writeClosed = true
+	c.mutex.Unlock()
+
+	var err error
+	if closeWrite {
+		err = c.str.Close()
+	}
+	c.str.CancelRead(ErrorCodeNoError)
+	if c.ownsSession {
+		go lingerAndCloseSession(c.sess)
+	}
+	return err
+}
+
+// lingerAndCloseSession closes the session after sessionLingerTimeout, unless the peer closes it first.
+func lingerAndCloseSession(sess quic.Session) {
+	timer := time.NewTimer(sessionLingerTimeout)
+	defer timer.Stop()
+	select {
+	case <-sess.Context().Done():
+	case <-timer.C:
+		sess.Close()
+	}
+}
+
+// LocalAddr returns the local address of the session.
+func (c *Conn) LocalAddr() net.Addr {
+	return c.sess.LocalAddr()
+}
+
+// RemoteAddr returns the address of the peer.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.sess.RemoteAddr()
+}
+
+// SetDeadline sets the read and write deadlines of the stream.
+func (c *Conn) SetDeadline(t time.Time) error {
+	return c.str.SetDeadline(t)
+}
+
+// SetReadDeadline sets the read deadline of the stream.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.str.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the write deadline of the stream.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return c.str.SetWriteDeadline(t)
+}
+
+// Stream returns the QUIC stream of the Conn
+func (c *Conn) Stream() quic.Stream {
+	return c.str
+}
+
+// Session returns the QUIC session that the stream belongs to
+func (c *Conn) Session() quic.Session {
+	return c.sess
+}
+
+// A Listener is a net.Listener that accepts Conns.
+// It is created by NewListener, which returns a Conn for the first stream of every session,
+// or by NewSessionListener, which returns a Conn for every stream of a single session.
+type Listener struct {
+	ln   quic.Listener // nil for a Listener created by NewSessionListener
+	sess quic.Session  // nil for a Listener created by NewListener
+
+	conns     chan *Conn
+	closeOnce sync.Once
+	closed    chan struct{}
+	closeErr  error
+}
+
+var _ net.Listener = &Listener{}
+
+// ListenAddr creates a QUIC listener on the UDP network address addr, and returns a Listener for it.
+func ListenAddr(addr string, tlsConf *tls.Config, config *quic.Config) (*Listener, error) {
+	ln, err := quicListenAddr(addr, tlsConf, config)
+	if err != nil {
+		return nil, err
+	}
+	return NewListener(ln), nil
+}
+
+// NewListener returns a Listener that accepts sessions from ln.
+// Accept returns a Conn for the first stream opened by the peer on every session.
+// The Conn owns the session: closing the Conn closes the session.
+// Further streams opened by the peer are reset with ErrorCodeUnknownRoute.
+// Closing the Listener closes ln, and with it all sessions.
+func NewListener(ln quic.Listener) *Listener {
+	l := newListener()
+	l.ln = ln
+	go l.acceptSessions()
+	return l
+}
+
+// NewSessionListener returns a Listener that accepts streams opened by the peer on sess.
+// Accept returns a Conn for every stream opened by the peer using OpenConn.
+// Closing the Listener closes the session.
+func NewSessionListener(sess quic.Session) *Listener {
+	l := newListener()
+	l.sess = sess
+	go l.acceptStreams(sess, false)
+	return l
+}
+
+func newListener() *Listener {
+	return &Listener{
+		conns:  make(chan *Conn),
+		closed: make(chan struct{}),
+	}
+}
+
+func (l *Listener) acceptSessions() {
+	for {
+		sess, err := l.ln.Accept()
+		if err != nil {
+			l.closeWithError(err)
+			return
+		}
+		go l.acceptStreams(sess, true)
+	}
+}
+
+// acceptStreams accepts the streams opened by the peer on sess.
+// If firstOnly is set, only the first stream is passed to Accept.
+func (l *Listener) acceptStreams(sess quic.Session, firstOnly bool) {
+	var accepted bool
+	for {
+		str, err := sess.AcceptStream()
+		if err != nil {
+			if !firstOnly {
+				l.closeWithError(err)
+			}
+			return
+		}
+		if firstOnly && accepted {
+			str.CancelRead(ErrorCodeUnknownRoute)
+			str.CancelWrite(ErrorCodeUnknownRoute)
+			continue
+		}
+		accepted = true
+		if firstOnly {
+			// Reading the stream header of the first stream blocks accepting further streams.
+			// This is fine, since they will be reset anyway.
+			l.handleStream(sess, str, true)
+			continue
+		}
+		go l.handleStream(sess, str, false)
+	}
+}
+
+func (l *Listener) handleStream(sess quic.Session, str quic.Stream, ownsSession bool) {
+	str.SetReadDeadline(time.Now().Add(defaultStreamHeaderTimeout))
+	route, err := readStreamHeader(str)
+	if err == nil && route != "" {
+		err = errUnexpectedRoute
+	}
+	if err != nil {
+		str.CancelRead(ErrorCodeInvalidHeader)
+		str.CancelWrite(ErrorCodeInvalidHeader)
+		if ownsSession {
+			sess.CloseWithError(ErrorCodeInvalidHeader, err)
+		}
+		return
+	}
+	str.SetReadDeadline(time.Time{})
+
+	conn := newConn(str, sess, ownsSession)
+	select {
+	case l.conns <- conn:
+	case <-l.closed:
+		str.CancelRead(ErrorCodeShuttingDown)
+		str.CancelWrite(ErrorCodeShuttingDown)
+		if ownsSession {
+			sess.Close()
+		}
+	}
+}
+
+// Accept waits for and returns the next Conn.
+// After the Listener was closed, it returns net.ErrClosed.
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, l.closeErr
+	}
+}
+
+// Close closes the Listener.
+// This closes the QUIC listener or the session that the Listener was created for,
+// which aborts the streams of all Conns that were accepted from it.
+func (l *Listener) Close() error {
+	l.closeWithError(net.ErrClosed)
+	if l.ln != nil {
+		return l.ln.Close()
+	}
+	return l.sess.Close()
+}
+
+func (l *Listener) closeWithError(err error) {
+	l.closeOnce.Do(func() {
+		l.closeErr = err
+		close(l.closed)
+	})
+}
+
+// Addr returns the local address of the Listener.
+func (l *Listener) Addr() net.Addr {
+	if l.ln != nil {
+		return l.ln.Addr()
+	}
+	return l.sess.LocalAddr()
+}
@@ -0,0 +1,229 @@
+package qk
+
+import (
+	"crypto/tls"
+	"io"
+	"io/ioutil"
+	"net"
+	"time"
+
+	quic "github.com/wheelcomplex/qk"
+	"github.com/wheelcomplex/qk/internal/testdata"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("net.Conn adapters", func() {
+	clientTLSConf := &tls.Config{InsecureSkipVerify: true}
+
+	Context("Listener", func() {
+		var (
+			ln   *Listener
+			addr string
+		)
+
+		BeforeEach(func() {
+			var err error
+			ln, err = ListenAddr("localhost:0", testdata.GetTLSConfig(), nil)
+			Expect(err).ToNot(HaveOccurred())
+			addr = ln.Addr().String()
+		})
+
+		AfterEach(func() {
+			ln.Close()
+		})
+
+		accept := func() net.Conn {
+			conn, err := ln.Accept()
+			ExpectWithOffset(1, err).ToNot(HaveOccurred())
+			return conn
+		}
+
+		It("accepts a Conn for every dialed session", func() {
+			done := make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
+				defer close(done)
+				conn := accept()
+				data, err := ioutil.ReadAll(conn)
+				Expect(err).ToNot(HaveOccurred())
+				_, err = conn.Write(data)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(conn.Close()).To(Succeed())
+			}()
+			conn, err := DialAddr(addr, clientTLSConf, nil)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = conn.Write([]byte("foobar"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(conn.CloseWrite()).To(Succeed())
+			data, err := ioutil.ReadAll(conn)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(data).To(Equal([]byte("foobar")))
+			Eventually(done).Should(BeClosed())
+			Expect(conn.Close()).To(Succeed())
+		})
+
+		It("accepts Conns before the client sends any data", func() {
+			go func() {
+				defer GinkgoRecover()
+				conn := accept()
+				_, err := conn.Write([]byte("hello"))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(conn.Close()).To(Succeed())
+			}()
+			conn, err := DialAddr(addr, clientTLSConf, nil)
+			Expect(err).ToNot(HaveOccurred())
+			defer conn.Close()
+			data, err := ioutil.ReadAll(conn)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(data).To(Equal([]byte("hello")))
+		})
+
+		It("uses the addresses of the session", func() {
+			serverConn := make(chan net.Conn, 1)
+			go func() {
+				defer GinkgoRecover()
+				serverConn <- accept()
+			}()
+			conn, err := DialAddr(addr, clientTLSConf, nil)
+			Expect(err).ToNot(HaveOccurred())
+			defer conn.Close()
+			var sconn net.Conn
+			Eventually(serverConn).Should(Receive(&sconn))
+			defer sconn.Close()
+			Expect(conn.RemoteAddr().String()).To(Equal(sconn.LocalAddr().String()))
+			Expect(sconn.RemoteAddr().(*net.UDPAddr).Port).To(Equal(conn.LocalAddr().(*net.UDPAddr).Port))
+		})
+
+		It("times out reads", func() {
+			go ln.Accept()
+			conn, err := DialAddr(addr, clientTLSConf, nil)
+			Expect(err).ToNot(HaveOccurred())
+			defer conn.Close()
+			Expect(conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))).To(Succeed())
+			_, err = conn.Read(make([]byte, 10))
+			Expect(err).To(HaveOccurred())
+			nerr, ok := err.(net.Error)
+			Expect(ok).To(BeTrue())
+			Expect(nerr.Timeout()).To(BeTrue())
+		})
+
+		It("returns net.ErrClosed when reading from a closed Conn", func() {
+			go ln.Accept()
+			conn, err := DialAddr(addr, clientTLSConf, nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(conn.Close()).To(Succeed())
+			_, err = conn.Read(make([]byte, 10))
+			Expect(err).To(MatchError(net.ErrClosed))
+			_, err = conn.Write([]byte("foobar"))
+			Expect(err).To(MatchError(net.ErrClosed))
+		})
+
+		It("closes the session after the Conn was closed", func() {
+			origLingerTimeout := sessionLingerTimeout
+			sessionLingerTimeout = 100 * time.Millisecond
+			defer func() { sessionLingerTimeout = origLingerTimeout }()
+
+			serverConn := make(chan net.Conn, 1)
+			go func() {
+				defer GinkgoRecover()
+				serverConn <- accept()
+			}()
+			conn, err := DialAddr(addr, clientTLSConf, nil)
+			Expect(err).ToNot(HaveOccurred())
+			var sconn net.Conn
+			Eventually(serverConn).Should(Receive(&sconn))
+			Expect(conn.Close()).To(Succeed())
+			Eventually(conn.Session().Context().Done()).Should(BeClosed())
+			Eventually(sconn.(*Conn).Session().Context().Done()).Should(BeClosed())
+		})
+
+		It("returns net.ErrClosed after the Listener was closed", func() {
+			errChan := make(chan error, 1)
+			go func() {
+				_, err := ln.Accept()
+				errChan <- err
+			}()
+			Consistently(errChan).ShouldNot(Receive())
+			Expect(ln.Close()).To(Succeed())
+			Eventually(errChan).Should(Receive(MatchError(net.ErrClosed)))
+		})
+	})
+
+	Context("Listener for a session", func() {
+		var (
+			quicLn     quic.Listener
+			clientSess quic.Session
+			serverSess quic.Session
+			ln         *Listener
+		)
+
+		BeforeEach(func() {
+			var err error
+			quicLn, err = quic.ListenAddr("localhost:0", testdata.GetTLSConfig(), nil)
+			Expect(err).ToNot(HaveOccurred())
+			sessChan := make(chan quic.Session, 1)
+			go func() {
+				defer GinkgoRecover()
+				sess, err := quicLn.Accept()
+				Expect(err).ToNot(HaveOccurred())
+				sessChan <- sess
+			}()
+			clientSess, err = quic.DialAddr(quicLn.Addr().String(), clientTLSConf, nil)
+			Expect(err).ToNot(HaveOccurred())
+			Eventually(sessChan).Should(Receive(&serverSess))
+			ln = NewSessionListener(serverSess)
+		})
+
+		AfterEach(func() {
+			ln.Close()
+			clientSess.Close()
+			quicLn.Close()
+		})
+
+		It("accepts a Conn for every stream", func() {
+			go func() {
+				defer GinkgoRecover()
+				for {
+					conn, err := ln.Accept()
+					if err != nil {
+						return
+					}
+					go func() {
+						defer GinkgoRecover()
+						defer conn.Close()
+						_, err := io.Copy(conn, conn)
+						Expect(err).ToNot(HaveOccurred())
+					}()
+				}
+			}()
+			for _, msg := range []string{"foo", "bar", "baz"} {
+				conn, err := OpenConn(clientSess)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(conn.Session()).To(Equal(clientSess))
+				_, err = conn.Write([]byte(msg))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(conn.CloseWrite()).To(Succeed())
+				data, err := ioutil.ReadAll(conn)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(string(data)).To(Equal(msg))
+				Expect(conn.Close()).To(Succeed())
+			}
+			Expect(clientSess.Context().Err()).ToNot(HaveOccurred())
+		})
+
+		It("uses the address of the session", func() {
+			Expect(ln.Addr()).To(Equal(serverSess.LocalAddr()))
+		})
+
+		It("resets streams that don't start with an empty route", func() {
+			str, err := clientSess.OpenStreamSync()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(writeStreamHeader(str, "foo")).To(Succeed())
+			_, err = str.Read([]byte{0})
+			Expect(err).To(HaveOccurred())
+			Expect(err.(quic.StreamError).ErrorCode()).To(Equal(ErrorCodeInvalidHeader))
+		})
+	})
+})
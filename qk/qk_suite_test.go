@@ -0,0 +1,13 @@
+package qk
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestQk(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "qk Suite")
+}
@@ -0,0 +1,200 @@
+package qk
+
+import (
+	"crypto/tls"
+	"sync"
+
+	quic "github.com/wheelcomplex/qk"
+)
+
+var quicDialAddr = quic.DialAddr
+
+// A Client opens routed streams to servers.
+// It keeps a pool of QUIC sessions per address. New streams are opened on the first session of the pool that allows
+// opening a new stream, starting with the session following the one used for the previous stream.
+// A new session is only dialed when the stream limits of all sessions of the pool are reached, up to MaxSessionsPerAddr.
+// Closed sessions are removed from the pool.
+type Client struct {
+	// TLSConfig is the TLS configuration used for dialing new sessions.
+	// If nil, the default configuration is used.
+	TLSConfig *tls.Config
+
+	// QuicConfig is the quic.Config used for dialing new sessions.
+	// If nil, reasonable default values are used.
+	QuicConfig *quic.Config
+
+	// MaxSessionsPerAddr limits the number of sessions per address.
+	// If zero, a single session is used per address.
+	MaxSessionsPerAddr int
+
+	// Dial specifies an optional dial function for creating QUIC sessions.
+	// If Dial is nil, quic.DialAddr is used.
+	Dial func(addr string, tlsConf *tls.Config, config *quic.Config) (quic.Session, error)
+
+	mutex  sync.Mutex
+	pools  map[string]*sessionPool
+	closed bool
+}
+
+// A sessionPool is the pool of sessions to a single address
+type sessionPool struct {
+	sessions []quic.Session
+	next     int           // the index of the session to try first for the next stream
+	dialing  chan struct{} // non-nil while a session is being dialed, closed when dialing is done
+}
+
+// OpenStream opens a new stream to the server at addr, and sends the stream header for the route.
+// If the stream limits of all sessions to the server are reached, a new session is dialed.
+// If the pool is already full, it blocks until the peer's stream limit allows opening a new stream.
+func (c *Client) OpenStream(addr, route string) (*Stream, error) {
+	if len(route) > MaxRouteLength {
+		return nil, errRouteTooLong
+	}
+	for {
+		sess, str, isNew, err := c.openStream(addr)
+		if err != nil {
+			return nil, err
+		}
+		if str == nil {
+			str, err = sess.OpenStreamSync()
+			if err != nil {
+				if sess.Context().Err() != nil && !isNew {
+					// The session was closed. It is removed from the pool, and a new session is dialed.
+					continue
+				}
+				return nil, err
+			}
+		}
+		if err := writeStreamHeader(str, route); err != nil {
+			str.CancelWrite(ErrorCodeInvalidHeader)
+			str.CancelRead(ErrorCodeInvalidHeader)
+			return nil, err
+		}
+		return newStream(str, route, sess), nil
+	}
+}
+
+// openStream opens a stream on a session of the pool of addr.
+// If no session allows opening a new stream, it dials a new session if the pool has less than MaxSessionsPerAddr sessions,
+// and no other session is currently being dialed.
+// If the stream is nil, the caller has to open it on the returned session, blocking until the stream limit allows it.
+func (c *Client) openStream(addr string) (sess quic.Session, str quic.Stream, isNew bool, err error) {
+	c.mutex.Lock()
+	for {
+		if c.closed {
+			c.mutex.Unlock()
+			return nil, nil, false, ErrClientClosed
+		}
+		if c.pools == nil {
+			c.pools = make(map[string]*sessionPool)
+		}
+		pool, ok := c.pools[addr]
+		if !ok {
+			pool = &sessionPool{}
+			c.pools[addr] = pool
+		}
+		pool.removeClosedSessions()
+		if sess, str := pool.tryOpenStream(); str != nil {
+			c.mutex.Unlock()
+			return sess, str, false, nil
+		}
+		if pool.dialing == nil && len(pool.sessions) < c.maxSessionsPerAddr() {
+			break
+		}
+		if pool.dialing == nil {
+			sess := pool.sessions[pool.next%len(pool.sessions)]
+			pool.next++
+			c.mutex.Unlock()
+			return sess, nil, false, nil
+		}
+		// wait for the session that's currently being dialed
+		dialing := pool.dialing
+		c.mutex.Unlock()
+		<-dialing
+		c.mutex.Lock()
+	}
+
+	pool := c.pools[addr]
+	dialing := make(chan struct{})
+	pool.dialing = dialing
+	c.mutex.Unlock()
+
+	sess, err = c.dial(addr)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	pool.dialing = nil
+	close(dialing)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	if c.closed {
+		sess.Close()
+		return nil, nil, false, ErrClientClosed
+	}
+	pool.sessions = append(pool.sessions, sess)
+	return sess, nil, true, nil
+}
+
+func (c *Client) dial(addr string) (quic.Session, error) {
+	if c.Dial != nil {
+		return c.Dial(addr, c.TLSConfig, c.QuicConfig)
+	}
+	return quicDialAddr(addr, c.TLSConfig, c.QuicConfig)
+}
+
+func (c *Client) maxSessionsPerAddr() int {
+	if c.MaxSessionsPerAddr <= 0 {
+		return 1
+	}
+	return c.MaxSessionsPerAddr
+}
+
+// tryOpenStream opens a stream on the first session that allows opening a new stream, without blocking.
+// It returns a nil stream if the stream limits of all sessions are reached.
+func (p *sessionPool) tryOpenStream() (quic.Session, quic.Stream) {
+	for i := range p.sessions {
+		sess := p.sessions[(p.next+i)%len(p.sessions)]
+		// Errors other than qerr.TooManyOpenStreams mean that the session was closed.
+		// It is removed from the pool the next time a stream is opened.
+		if str, err := sess.OpenStream(); err == nil {
+			p.next += i + 1
+			return sess, str
+		}
+	}
+	return nil, nil
+}
+
+func (p *sessionPool) removeClosedSessions() {
+	open := p.sessions[:0]
+	for _, sess := range p.sessions {
+		if sess.Context().Err() == nil {
+			open = append(open, sess)
+		}
+	}
+	for i := len(open); i < len(p.sessions); i++ {
+		p.sessions[i] = nil
+	}
+	p.sessions = open
+}
+
+// Close closes all sessions of the client.
+// Streams that are still open are aborted.
+func (c *Client) Close() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	var err error
+	for _, pool := range c.pools {
+		for _, sess := range pool.sessions {
+			if cerr := sess.Close(); err == nil {
+				err = cerr
+			}
+		}
+	}
+	c.pools = nil
+	return err
+}
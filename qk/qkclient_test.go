@@ -0,0 +1,194 @@
+package qk
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	quic "github.com/wheelcomplex/qk"
+	"github.com/wheelcomplex/qk/internal/testdata"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Client", func() {
+	var (
+		server *Server
+		client *Client
+		addr   string
+		dials  int32
+	)
+
+	BeforeEach(func() {
+		server = &Server{TLSConfig: testdata.GetTLSConfig()}
+		server.HandleFunc("addr", func(str *Stream) {
+			// send the address of the client, to identify the session
+			str.Write([]byte(str.Session().RemoteAddr().String()))
+		})
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		Expect(err).ToNot(HaveOccurred())
+		go server.Serve(conn)
+		addr = fmt.Sprintf("localhost:%d", conn.LocalAddr().(*net.UDPAddr).Port)
+		atomic.StoreInt32(&dials, 0)
+		client = &Client{
+			TLSConfig: &tls.Config{InsecureSkipVerify: true},
+			Dial: func(addr string, tlsConf *tls.Config, conf *quic.Config) (quic.Session, error) {
+				atomic.AddInt32(&dials, 1)
+				return quic.DialAddr(addr, tlsConf, conf)
+			},
+		}
+	})
+
+	AfterEach(func() {
+		Expect(client.Close()).To(Succeed())
+		Expect(server.Close()).To(Succeed())
+	})
+
+	// remoteAddr opens a stream, and returns the address of the session as seen by the server
+	remoteAddr := func() string {
+		str, err := client.OpenStream(addr, "addr")
+		ExpectWithOffset(1, err).ToNot(HaveOccurred())
+		data, err := ioutil.ReadAll(str)
+		ExpectWithOffset(1, err).ToNot(HaveOccurred())
+		return string(data)
+	}
+
+	It("uses a single session per address by default", func() {
+		a1 := remoteAddr()
+		a2 := remoteAddr()
+		Expect(a1).To(Equal(a2))
+		Expect(atomic.LoadInt32(&dials)).To(BeEquivalentTo(1))
+	})
+
+	It("dials a single session for concurrent streams", func() {
+		var wg sync.WaitGroup
+		for i := 0; i < 5; i++ {
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+				remoteAddr()
+			}()
+		}
+		wg.Wait()
+		Expect(atomic.LoadInt32(&dials)).To(BeEquivalentTo(1))
+	})
+
+	It("doesn't dial another session while the session allows opening new streams", func() {
+		client.MaxSessionsPerAddr = 2
+		a := remoteAddr()
+		for i := 0; i < 5; i++ {
+			Expect(remoteAddr()).To(Equal(a))
+		}
+		Expect(atomic.LoadInt32(&dials)).To(BeEquivalentTo(1))
+	})
+
+	Context("with a small stream limit", func() {
+		// openStream opens a stream, and returns the address of the session as seen by the server.
+		// The stream is kept open by the client.
+		openStream := func() (*Stream, string) {
+			str, err := client.OpenStream(addr, "addr")
+			ExpectWithOffset(1, err).ToNot(HaveOccurred())
+			data, err := ioutil.ReadAll(str)
+			ExpectWithOffset(1, err).ToNot(HaveOccurred())
+			return str, string(data)
+		}
+
+		BeforeEach(func() {
+			Expect(server.Close()).To(Succeed())
+			server = &Server{
+				TLSConfig:  testdata.GetTLSConfig(),
+				QuicConfig: &quic.Config{MaxIncomingStreams: 2},
+			}
+			server.HandleFunc("addr", func(str *Stream) {
+				str.Write([]byte(str.Session().RemoteAddr().String()))
+			})
+			conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+			Expect(err).ToNot(HaveOccurred())
+			go server.Serve(conn)
+			addr = fmt.Sprintf("localhost:%d", conn.LocalAddr().(*net.UDPAddr).Port)
+			client.MaxSessionsPerAddr = 2
+		})
+
+		It("dials a new session when the stream limits of all sessions are reached", func() {
+			_, a1 := openStream()
+			_, a2 := openStream()
+			Expect(a2).To(Equal(a1))
+			Expect(atomic.LoadInt32(&dials)).To(BeEquivalentTo(1))
+			_, a3 := openStream()
+			Expect(a3).ToNot(Equal(a1))
+			_, a4 := openStream()
+			Expect(a4).To(Equal(a3))
+			Expect(atomic.LoadInt32(&dials)).To(BeEquivalentTo(2))
+		})
+
+		It("opens streams on the session that allows it", func() {
+			str1, a1 := openStream()
+			openStream()
+			_, a3 := openStream()
+			Expect(a3).ToNot(Equal(a1))
+			// close the first stream, so that the first session allows opening a new stream
+			Expect(str1.Close()).To(Succeed())
+			// The stream limit of the second session is not reached yet, so streams might be opened on it first.
+			Eventually(func() string { _, a := openStream(); return a }).Should(Equal(a1))
+			Expect(atomic.LoadInt32(&dials)).To(BeEquivalentTo(2))
+		})
+
+		It("blocks when the stream limits of all sessions are reached, and the pool is full", func() {
+			str1, _ := openStream()
+			for i := 0; i < 3; i++ {
+				openStream()
+			}
+			done := make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
+				defer close(done)
+				openStream()
+			}()
+			Consistently(done).ShouldNot(BeClosed())
+			Expect(str1.Close()).To(Succeed())
+			Eventually(done).Should(BeClosed())
+			Expect(atomic.LoadInt32(&dials)).To(BeEquivalentTo(2))
+		})
+	})
+
+	It("dials a new session when the session was closed", func() {
+		str, err := client.OpenStream(addr, "addr")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(str.Session().Close()).To(Succeed())
+		a := remoteAddr()
+		Expect(a).ToNot(BeEmpty())
+		Expect(atomic.LoadInt32(&dials)).To(BeEquivalentTo(2))
+	})
+
+	It("returns dial errors", func() {
+		testErr := errors.New("dial error")
+		client.Dial = func(string, *tls.Config, *quic.Config) (quic.Session, error) { return nil, testErr }
+		_, err := client.OpenStream(addr, "addr")
+		Expect(err).To(MatchError(testErr))
+		// the next call dials again
+		_, err = client.OpenStream(addr, "addr")
+		Expect(err).To(MatchError(testErr))
+	})
+
+	It("refuses routes that are too long", func() {
+		_, err := client.OpenStream(addr, strings.Repeat("a", MaxRouteLength+1))
+		Expect(err).To(MatchError(errRouteTooLong))
+		Expect(atomic.LoadInt32(&dials)).To(BeZero())
+	})
+
+	It("closes the sessions", func() {
+		str, err := client.OpenStream(addr, "addr")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(client.Close()).To(Succeed())
+		Expect(str.Session().Context().Done()).To(BeClosed())
+		_, err = client.OpenStream(addr, "addr")
+		Expect(err).To(MatchError(ErrClientClosed))
+	})
+})
@@ -0,0 +1,289 @@
+package qk
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"runtime"
+	"sync"
+	"time"
+
+	quic "github.com/wheelcomplex/qk"
+	"github.com/wheelcomplex/qk/internal/utils"
+)
+
+var (
+	quicListen     = quic.Listen
+	quicListenAddr = quic.ListenAddr
+)
+
+// defaultStreamHeaderTimeout is the default value of Server.StreamHeaderTimeout
+const defaultStreamHeaderTimeout = 10 * time.Second
+
+// A SessionState is the state of a session of the Server.
+// It is passed to the Server.SessionState hook.
+type SessionState int
+
+const (
+	// StateNew is used for a session that was accepted
+	StateNew SessionState = iota
+	// StateClosed is used for a session that was closed
+	StateClosed
+)
+
+func (s SessionState) String() string {
+	switch s {
+	case StateNew:
+		return "new"
+	case StateClosed:
+		return "closed"
+	default:
+		return fmt.Sprintf("unknown session state: %d", int(s))
+	}
+}
+
+// A Server accepts QUIC sessions, and dispatches every stream opened by the client to the Handler registered for its route.
+// Streams with a route that no handler is registered for are reset with ErrorCodeUnknownRoute.
+type Server struct {
+	// TLSConfig is the TLS configuration used for the QUIC handshake. It must contain a certificate.
+	TLSConfig *tls.Config
+
+	// QuicConfig is the quic.Config used for accepting sessions.
+	// If nil, reasonable default values are used.
+	QuicConfig *quic.Config
+
+	// StreamHeaderTimeout is the amount of time allowed to read the stream header of a new stream.
+	// If zero, a timeout of 10 seconds is used.
+	StreamHeaderTimeout time.Duration
+
+	// SessionState specifies an optional callback function that is called when a session changes state.
+	SessionState func(quic.Session, SessionState)
+
+	mutex        sync.Mutex
+	handlers     map[string]Handler
+	listener     quic.Listener
+	closed       bool
+	shuttingDown bool
+	streams      sync.WaitGroup // streams that are being handled
+
+	logger utils.Logger
+}
+
+// Handle registers the handler for the given route.
+// If a handler already exists for route, Handle panics.
+func (s *Server) Handle(route string, handler Handler) {
+	if len(route) > MaxRouteLength {
+		panic(errRouteTooLong)
+	}
+	if handler == nil {
+		panic("qk: nil handler")
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.handlers == nil {
+		s.handlers = make(map[string]Handler)
+	}
+	if _, ok := s.handlers[route]; ok {
+		panic("qk: multiple registrations for route " + route)
+	}
+	s.handlers[route] = handler
+}
+
+// HandleFunc registers the handler function for the given route.
+func (s *Server) HandleFunc(route string, handler func(*Stream)) {
+	if handler == nil {
+		panic("qk: nil handler")
+	}
+	s.Handle(route, HandlerFunc(handler))
+}
+
+// ListenAndServe listens on the UDP network address addr and serves incoming sessions.
+func (s *Server) ListenAndServe(addr string) error {
+	return s.serveImpl(func(tlsConf *tls.Config, conf *quic.Config) (quic.Listener, error) {
+		return quicListenAddr(addr, tlsConf, conf)
+	})
+}
+
+// Serve serves incoming sessions on an existing UDP connection.
+func (s *Server) Serve(conn net.PacketConn) error {
+	return s.serveImpl(func(tlsConf *tls.Config, conf *quic.Config) (quic.Listener, error) {
+		return quicListen(conn, tlsConf, conf)
+	})
+}
+
+func (s *Server) serveImpl(listen func(*tls.Config, *quic.Config) (quic.Listener, error)) error {
+	if s.TLSConfig == nil {
+		return errors.New("qk: no tls.Config")
+	}
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return ErrServerClosed
+	}
+	if s.listener != nil {
+		s.mutex.Unlock()
+		return errors.New("qk: ListenAndServe may only be called once")
+	}
+	s.logger = utils.DefaultLogger.WithPrefix("qk server")
+	ln, err := listen(s.TLSConfig, s.QuicConfig)
+	if err != nil {
+		s.mutex.Unlock()
+		return err
+	}
+	s.listener = ln
+	s.mutex.Unlock()
+
+	for {
+		sess, err := ln.Accept()
+		if err != nil {
+			s.mutex.Lock()
+			closed := s.closed
+			s.mutex.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+		go s.handleSession(sess)
+	}
+}
+
+// Addr returns the local network address that the server is listening on.
+// It returns nil if the server is not listening yet.
+func (s *Server) Addr() net.Addr {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+func (s *Server) handleSession(sess quic.Session) {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		sess.Close()
+		return
+	}
+	s.mutex.Unlock()
+	s.logger.Debugf("Accepted session from %s", sess.RemoteAddr())
+	if s.SessionState != nil {
+		s.SessionState(sess, StateNew)
+	}
+
+	for {
+		str, err := sess.AcceptStream()
+		if err != nil {
+			break
+		}
+		s.mutex.Lock()
+		if s.shuttingDown {
+			s.mutex.Unlock()
+			str.CancelRead(ErrorCodeShuttingDown)
+			str.CancelWrite(ErrorCodeShuttingDown)
+			continue
+		}
+		s.streams.Add(1)
+		s.mutex.Unlock()
+		go func() {
+			defer s.streams.Done()
+			s.handleStream(sess, str)
+		}()
+	}
+
+	s.logger.Debugf("Session from %s closed", sess.RemoteAddr())
+	if s.SessionState != nil {
+		s.SessionState(sess, StateClosed)
+	}
+}
+
+func (s *Server) handleStream(sess quic.Session, str quic.Stream) {
+	timeout := s.StreamHeaderTimeout
+	if timeout == 0 {
+		timeout = defaultStreamHeaderTimeout
+	}
+	str.SetReadDeadline(time.Now().Add(timeout))
+	route, err := readStreamHeader(str)
+	if err != nil {
+		s.logger.Debugf("Reading the header of stream %d failed: %s", str.StreamID(), err)
+		str.CancelRead(ErrorCodeInvalidHeader)
+		str.CancelWrite(ErrorCodeInvalidHeader)
+		return
+	}
+	str.SetReadDeadline(time.Time{})
+
+	s.mutex.Lock()
+	handler, ok := s.handlers[route]
+	s.mutex.Unlock()
+	if !ok {
+		s.logger.Debugf("No handler for route %q (stream %d)", route, str.StreamID())
+		str.CancelRead(ErrorCodeUnknownRoute)
+		str.CancelWrite(ErrorCodeUnknownRoute)
+		return
+	}
+
+	panicked := true
+	defer func() {
+		if !panicked {
+			str.Close()
+			return
+		}
+		if p := recover(); p != nil {
+			const size = 64 << 10
+			buf := make([]byte, size)
+			buf = buf[:runtime.Stack(buf, false)]
+			s.logger.Errorf("qk: panic serving route %q for %s: %v\n%s", route, sess.RemoteAddr(), p, buf)
+		}
+		str.CancelRead(ErrorCodeInternalError)
+		str.CancelWrite(ErrorCodeInternalError)
+	}()
+	handler.ServeStream(newStream(str, route, sess))
+	panicked = false
+}
+
+// Close closes the server immediately.
+// All sessions are closed, which aborts the streams that are still being handled.
+func (s *Server) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	if s.listener == nil {
+		return nil
+	}
+	// Closing the listener closes all sessions.
+	return s.listener.Close()
+}
+
+// Shutdown gracefully shuts down the server.
+// Streams opened by clients after Shutdown was called are reset with ErrorCodeShuttingDown.
+// Shutdown waits for the handlers of all running streams to return, or for the context to be done, before closing the server.
+// It returns the context's error if the context expired before all handlers returned.
+// Closing a session discards the data that the peer didn't receive yet, so handlers that need to make sure that
+// the peer received the response should wait for the peer to close the stream before returning.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mutex.Lock()
+	s.shuttingDown = true
+	s.mutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.streams.Wait()
+		close(done)
+	}()
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if cerr := s.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,204 @@
+package qk
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"sync/atomic"
+	"time"
+
+	quic "github.com/wheelcomplex/qk"
+	"github.com/wheelcomplex/qk/internal/testdata"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Server", func() {
+	var (
+		server   *Server
+		client   *Client
+		addr     string
+		serveErr chan error
+	)
+
+	startServer := func() {
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		Expect(err).ToNot(HaveOccurred())
+		errChan := make(chan error, 1)
+		serveErr = errChan
+		go func() { errChan <- server.Serve(conn) }()
+		Eventually(server.Addr).ShouldNot(BeNil())
+		addr = fmt.Sprintf("localhost:%d", conn.LocalAddr().(*net.UDPAddr).Port)
+	}
+
+	BeforeEach(func() {
+		server = &Server{TLSConfig: testdata.GetTLSConfig()}
+		server.HandleFunc("echo", func(str *Stream) {
+			defer GinkgoRecover()
+			data, err := ioutil.ReadAll(str)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = str.Write(data)
+			Expect(err).ToNot(HaveOccurred())
+		})
+		client = &Client{TLSConfig: &tls.Config{InsecureSkipVerify: true}}
+	})
+
+	AfterEach(func() {
+		Expect(client.Close()).To(Succeed())
+		Expect(server.Close()).To(Succeed())
+	})
+
+	expectStreamError := func(err error, code quic.ErrorCode) {
+		ExpectWithOffset(1, err).To(HaveOccurred())
+		serr, ok := err.(quic.StreamError)
+		ExpectWithOffset(1, ok).To(BeTrue())
+		ExpectWithOffset(1, serr.ErrorCode()).To(Equal(code))
+	}
+
+	It("errors without a tls.Config", func() {
+		server.TLSConfig = nil
+		Expect(server.ListenAndServe("localhost:0")).To(MatchError("qk: no tls.Config"))
+	})
+
+	It("panics when a route is registered twice", func() {
+		Expect(func() { server.HandleFunc("echo", func(*Stream) {}) }).To(Panic())
+	})
+
+	It("panics when registering a nil handler", func() {
+		Expect(func() { server.Handle("foo", nil) }).To(Panic())
+	})
+
+	It("dispatches streams to the handler", func() {
+		startServer()
+		str, err := client.OpenStream(addr, "echo")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(str.Route()).To(Equal("echo"))
+		_, err = str.Write([]byte("foobar"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(str.Close()).To(Succeed())
+		data, err := ioutil.ReadAll(str)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(data).To(Equal([]byte("foobar")))
+	})
+
+	It("passes the route and the session to the handler", func() {
+		streams := make(chan *Stream, 1)
+		server.HandleFunc("foo", func(str *Stream) { streams <- str })
+		startServer()
+		str, err := client.OpenStream(addr, "foo")
+		Expect(err).ToNot(HaveOccurred())
+		str.Write([]byte("x")) // make sure that the stream is opened on the server side
+		var serverStr *Stream
+		Eventually(streams).Should(Receive(&serverStr))
+		Expect(serverStr.Route()).To(Equal("foo"))
+		Expect(serverStr.Session().RemoteAddr().(*net.UDPAddr).Port).To(Equal(str.Session().LocalAddr().(*net.UDPAddr).Port))
+	})
+
+	It("resets streams with an unknown route", func() {
+		startServer()
+		str, err := client.OpenStream(addr, "unknown")
+		Expect(err).ToNot(HaveOccurred())
+		_, err = ioutil.ReadAll(str)
+		expectStreamError(err, ErrorCodeUnknownRoute)
+	})
+
+	It("resets streams if the handler panics", func() {
+		server.HandleFunc("panic", func(*Stream) { panic("foobar") })
+		startServer()
+		str, err := client.OpenStream(addr, "panic")
+		Expect(err).ToNot(HaveOccurred())
+		_, err = ioutil.ReadAll(str)
+		expectStreamError(err, ErrorCodeInternalError)
+	})
+
+	It("calls the SessionState hook", func() {
+		states := make(chan SessionState, 2)
+		server.SessionState = func(_ quic.Session, state SessionState) { states <- state }
+		startServer()
+		str, err := client.OpenStream(addr, "echo")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(str.Close()).To(Succeed())
+		Eventually(states).Should(Receive(Equal(StateNew)))
+		Expect(client.Close()).To(Succeed())
+		Eventually(states).Should(Receive(Equal(StateClosed)))
+	})
+
+	It("returns ErrServerClosed after Close", func() {
+		startServer()
+		Expect(server.Close()).To(Succeed())
+		Eventually(serveErr).Should(Receive(Equal(ErrServerClosed)))
+		Expect(server.ListenAndServe("localhost:0")).To(MatchError(ErrServerClosed))
+	})
+
+	Context("shutting down", func() {
+		It("waits for running handlers", func() {
+			var handled int32
+			started := make(chan struct{}, 3)
+			server.HandleFunc("slow", func(str *Stream) {
+				started <- struct{}{}
+				time.Sleep(100 * time.Millisecond)
+				atomic.AddInt32(&handled, 1)
+			})
+			startServer()
+			for i := 0; i < 3; i++ {
+				str, err := client.OpenStream(addr, "slow")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(str.Close()).To(Succeed())
+			}
+			for i := 0; i < 3; i++ {
+				Eventually(started).Should(Receive())
+			}
+			Expect(server.Shutdown(context.Background())).To(Succeed())
+			Expect(atomic.LoadInt32(&handled)).To(BeEquivalentTo(3))
+			Eventually(serveErr).Should(Receive(Equal(ErrServerClosed)))
+		})
+
+		It("returns the context's error if the handlers don't return in time", func() {
+			started := make(chan struct{})
+			block := make(chan struct{})
+			defer close(block)
+			server.HandleFunc("block", func(*Stream) {
+				close(started)
+				<-block
+			})
+			startServer()
+			str, err := client.OpenStream(addr, "block")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(str.Close()).To(Succeed())
+			Eventually(started).Should(BeClosed())
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			Expect(server.Shutdown(ctx)).To(MatchError(context.DeadlineExceeded))
+		})
+
+		It("rejects new streams", func() {
+			started := make(chan struct{})
+			block := make(chan struct{})
+			server.HandleFunc("block", func(*Stream) {
+				close(started)
+				<-block
+			})
+			startServer()
+			str, err := client.OpenStream(addr, "block")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(str.Close()).To(Succeed())
+			Eventually(started).Should(BeClosed())
+			shutdownErr := make(chan error, 1)
+			go func() { shutdownErr <- server.Shutdown(context.Background()) }()
+			Eventually(func() bool {
+				server.mutex.Lock()
+				defer server.mutex.Unlock()
+				return server.shuttingDown
+			}).Should(BeTrue())
+			str, err = client.OpenStream(addr, "echo")
+			Expect(err).ToNot(HaveOccurred())
+			_, err = ioutil.ReadAll(str)
+			expectStreamError(err, ErrorCodeShuttingDown)
+			close(block)
+			Eventually(shutdownErr).Should(Receive(BeNil()))
+		})
+	})
+})
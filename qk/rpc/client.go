@@ -0,0 +1,271 @@
+package rpc
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	quic "github.com/wheelcomplex/qk"
+	"github.com/wheelcomplex/qk/qk"
+)
+
+// A Client makes calls using a qk.Client.
+// Every call is made on a new stream.
+type Client struct {
+	// Codec is used to encode requests and decode responses.
+	// If nil, GobCodec is used.
+	Codec Codec
+
+	// MaxMessageSize limits the size of request and response messages.
+	// If zero, DefaultMaxMessageSize is used.
+	MaxMessageSize int
+
+	client *qk.Client
+}
+
+// NewClient creates a Client that opens streams using c.
+func NewClient(c *qk.Client) *Client {
+	return &Client{client: c}
+}
+
+// Call makes a unary call to method at the server at addr, and decodes the response into resp.
+// If the handler returned an error, Call returns an *Error.
+// If the context is cancelled or its deadline expires, Call returns the context's error.
+func (c *Client) Call(ctx context.Context, addr, method string, req, resp interface{}) error {
+	cs, err := c.call(ctx, addr, method, req)
+	if err != nil {
+		return err
+	}
+	defer cs.Close()
+	if err := cs.Recv(resp); err != nil {
+		if err == io.EOF {
+			return ErrNoResponse
+		}
+		return err
+	}
+	// Wait for the end of the response, so that closing the ClientStream doesn't reset the stream.
+	if _, _, err := readFrame(cs.br, cs.maxSize); err == io.EOF {
+		cs.finish()
+	}
+	return nil
+}
+
+// CallStream makes a server-streaming call to method at the server at addr.
+// The response messages are received using the returned ClientStream.
+// The ClientStream must be closed when it is not used any more.
+func (c *Client) CallStream(ctx context.Context, addr, method string, req interface{}) (*ClientStream, error) {
+	return c.call(ctx, addr, method, req)
+}
+
+func (c *Client) call(ctx context.Context, addr, method string, req interface{}) (*ClientStream, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	data, err := c.codec().Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > c.maxMessageSize() {
+		return nil, ErrMessageTooLarge
+	}
+	str, err := c.openStream(ctx, addr, method)
+	if err != nil {
+		return nil, err
+	}
+	cs := &ClientStream{
+		ctx:     ctx,
+		str:     str,
+		br:      bufio.NewReader(str),
+		codec:   c.codec(),
+		maxSize: c.maxMessageSize(),
+		done:    make(chan struct{}),
+	}
+	var timeout time.Duration
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+		if timeout <= 0 {
+			cs.cancel(ErrorCodeDeadlineExceeded)
+			return nil, context.DeadlineExceeded
+		}
+		str.SetDeadline(deadline)
+	}
+	go cs.watchContext()
+
+	b := &bytes.Buffer{}
+	writeRequestHeader(b, timeout)
+	writeFrame(b, frameTypeMessage, data)
+	if _, err := str.Write(b.Bytes()); err != nil {
+		cs.Close()
+		return nil, cs.mapError(err)
+	}
+	if cs.isFinished() {
+		return nil, cs.mapError(ctx.Err())
+	}
+	return cs, nil
+}
+
+// openStream opens a stream for the call.
+// It returns early if the context is done while the session is dialed, or while waiting for the stream limit.
+func (c *Client) openStream(ctx context.Context, addr, method string) (*qk.Stream, error) {
+	type result struct {
+		str *qk.Stream
+		err error
+	}
+	resChan := make(chan result, 1)
+	go func() {
+		str, err := c.client.OpenStream(addr, method)
+		resChan <- result{str: str, err: err}
+	}()
+	select {
+	case res := <-resChan:
+		return res.str, res.err
+	case <-ctx.Done():
+		go func() {
+			if res := <-resChan; res.err == nil {
+				res.str.CancelWrite(ErrorCodeCanceled)
+				res.str.CancelRead(ErrorCodeCanceled)
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
+
+func (c *Client) codec() Codec {
+	if c.Codec == nil {
+		return GobCodec{}
+	}
+	return c.Codec
+}
+
+func (c *Client) maxMessageSize() int {
+	if c.MaxMessageSize <= 0 {
+		return DefaultMaxMessageSize
+	}
+	return c.MaxMessageSize
+}
+
+// A ClientStream receives the response messages of a call.
+type ClientStream struct {
+	ctx     context.Context
+	str     *qk.Stream
+	br      *bufio.Reader
+	codec   Codec
+	maxSize int
+
+	err error // the error returned by Recv after the response ended
+
+	mutex    sync.Mutex
+	finished bool          // the response ended, or the call was cancelled
+	done     chan struct{} // closed when finished is set
+}
+
+// Recv receives the next response message, and decodes it into m.
+// It returns io.EOF after the last message.
+// If the handler returned an error, it returns an *Error.
+func (s *ClientStream) Recv(m interface{}) error {
+	if s.err != nil {
+		return s.err
+	}
+	frameType, payload, err := readFrame(s.br, s.maxSize)
+	if err != nil {
+		if err == io.EOF {
+			s.finish()
+			s.err = io.EOF
+			return s.err
+		}
+		s.err = s.mapError(err)
+		code := ErrorCodeCanceled
+		if err == ErrMessageTooLarge {
+			code = ErrorCodeInvalidMessage
+		} else if s.err == context.DeadlineExceeded {
+			code = ErrorCodeDeadlineExceeded
+		}
+		s.cancel(code)
+		return s.err
+	}
+	if frameType == frameTypeError {
+		s.finish()
+		s.err = &Error{Message: string(payload)}
+		return s.err
+	}
+	return s.codec.Unmarshal(payload, m)
+}
+
+// Close cancels the call, unless the response was already received completely.
+func (s *ClientStream) Close() error {
+	s.cancel(ErrorCodeCanceled)
+	return nil
+}
+
+func (s *ClientStream) watchContext() {
+	select {
+	case <-s.ctx.Done():
+		code := ErrorCodeCanceled
+		if s.ctx.Err() == context.DeadlineExceeded {
+			code = ErrorCodeDeadlineExceeded
+		}
+		s.cancel(code)
+	case <-s.done:
+	}
+}
+
+// isFinished says if the response ended, or the call was cancelled
+func (s *ClientStream) isFinished() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.finished
+}
+
+// cancel resets the stream, unless the response already ended.
+// The write side is kept open until the call ends, since gQUIC only informs the server
+// about the cancellation if the client resets the write side.
+func (s *ClientStream) cancel(code quic.ErrorCode) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.finished {
+		return
+	}
+	s.finished = true
+	close(s.done)
+	s.str.CancelWrite(code)
+	s.str.CancelRead(code)
+}
+
+// finish marks the response as ended, and closes the stream for writing
+func (s *ClientStream) finish() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.finished {
+		return
+	}
+	s.finished = true
+	close(s.done)
+	s.str.Close()
+}
+
+// mapError translates errors that occurred on the stream into the errors returned to the caller
+func (s *ClientStream) mapError(err error) error {
+	if ctxErr := s.ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+		if _, ok := s.ctx.Deadline(); ok {
+			return context.DeadlineExceeded
+		}
+	}
+	if serr, ok := err.(quic.StreamError); ok {
+		switch serr.ErrorCode() {
+		case qk.ErrorCodeUnknownRoute:
+			return ErrUnknownMethod
+		case ErrorCodeDeadlineExceeded:
+			return context.DeadlineExceeded
+		case ErrorCodeCanceled:
+			return context.Canceled
+		}
+	}
+	return err
+}
@@ -0,0 +1,241 @@
+package rpc
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	quic "github.com/wheelcomplex/qk"
+	"github.com/wheelcomplex/qk/internal/testdata"
+	"github.com/wheelcomplex/qk/qk"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Client", func() {
+	for _, v := range []struct {
+		name string
+		conf *quic.Config
+	}{
+		{name: "using the default versions", conf: nil},
+		{name: "using QUIC v1", conf: &quic.Config{Versions: []quic.VersionNumber{quic.VersionQUIC1}}},
+	} {
+		quicConf := v.conf
+
+		Context(v.name, func() {
+			var (
+				qkServer   *qk.Server
+				server     *Server
+				client     *Client
+				addr       string
+				handlerCtx chan context.Context
+			)
+
+			BeforeEach(func() {
+				qkServer = &qk.Server{TLSConfig: testdata.GetTLSConfig(), QuicConfig: quicConf}
+				server = NewServer(qkServer)
+				handlerCtx = make(chan context.Context, 1)
+				server.HandleUnary("echo", func(ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+					var m testMessage
+					if err := dec(&m); err != nil {
+						return nil, err
+					}
+					return &m, nil
+				})
+				server.HandleUnary("large", func(ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+					var m testMessage
+					if err := dec(&m); err != nil {
+						return nil, err
+					}
+					return &testMessage{Name: strings.Repeat("a", m.Count)}, nil
+				})
+				server.HandleUnary("fail", func(context.Context, func(interface{}) error) (interface{}, error) {
+					return nil, errors.New("handler failed")
+				})
+				server.HandleUnary("block", func(ctx context.Context, _ func(interface{}) error) (interface{}, error) {
+					handlerCtx <- ctx
+					<-ctx.Done()
+					return nil, ctx.Err()
+				})
+				server.HandleServerStream("count", func(ctx context.Context, dec func(interface{}) error, stream *ServerStream) error {
+					var m testMessage
+					if err := dec(&m); err != nil {
+						return err
+					}
+					for i := 0; i < m.Count; i++ {
+						if err := stream.Send(&testMessage{Name: m.Name, Count: i}); err != nil {
+							return err
+						}
+					}
+					if m.Name == "fail" {
+						return errors.New("counting failed")
+					}
+					return nil
+				})
+				conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+				Expect(err).ToNot(HaveOccurred())
+				go qkServer.Serve(conn)
+				addr = fmt.Sprintf("localhost:%d", conn.LocalAddr().(*net.UDPAddr).Port)
+				client = NewClient(&qk.Client{
+					TLSConfig:  &tls.Config{InsecureSkipVerify: true},
+					QuicConfig: quicConf,
+				})
+			})
+
+			AfterEach(func() {
+				Expect(client.client.Close()).To(Succeed())
+				Expect(qkServer.Close()).To(Succeed())
+			})
+
+			Context("unary calls", func() {
+				It("makes a call", func() {
+					var resp testMessage
+					err := client.Call(context.Background(), addr, "echo", &testMessage{Name: "foo", Count: 42}, &resp)
+					Expect(err).ToNot(HaveOccurred())
+					Expect(resp).To(Equal(testMessage{Name: "foo", Count: 42}))
+				})
+
+				It("makes concurrent calls", func() {
+					errChan := make(chan error, 10)
+					for i := 0; i < 10; i++ {
+						go func(i int) {
+							var resp testMessage
+							err := client.Call(context.Background(), addr, "echo", &testMessage{Count: i}, &resp)
+							if err == nil && resp.Count != i {
+								err = fmt.Errorf("unexpected response: %d", resp.Count)
+							}
+							errChan <- err
+						}(i)
+					}
+					for i := 0; i < 10; i++ {
+						Eventually(errChan).Should(Receive(BeNil()))
+					}
+				})
+
+				It("returns the error of the handler", func() {
+					var resp testMessage
+					err := client.Call(context.Background(), addr, "fail", &testMessage{}, &resp)
+					Expect(err).To(Equal(&Error{Message: "handler failed"}))
+				})
+
+				It("returns ErrUnknownMethod for unknown methods", func() {
+					var resp testMessage
+					err := client.Call(context.Background(), addr, "foobar", &testMessage{}, &resp)
+					Expect(err).To(MatchError(ErrUnknownMethod))
+				})
+
+				It("uses the codec", func() {
+					server.Codec = JSONCodec{}
+					client.Codec = JSONCodec{}
+					var resp testMessage
+					err := client.Call(context.Background(), addr, "echo", &testMessage{Name: "json"}, &resp)
+					Expect(err).ToNot(HaveOccurred())
+					Expect(resp.Name).To(Equal("json"))
+				})
+
+				It("refuses to send requests larger than the maximum message size", func() {
+					client.MaxMessageSize = 10
+					var resp testMessage
+					err := client.Call(context.Background(), addr, "echo", &testMessage{Name: "foobar"}, &resp)
+					Expect(err).To(MatchError(ErrMessageTooLarge))
+				})
+
+				It("resets the stream when the server sends a response that is too large", func() {
+					client.MaxMessageSize = 1000
+					var resp testMessage
+					err := client.Call(context.Background(), addr, "large", &testMessage{Count: 500}, &resp)
+					Expect(err).ToNot(HaveOccurred())
+					Expect(resp.Name).To(HaveLen(500))
+					err = client.Call(context.Background(), addr, "large", &testMessage{Count: 2000}, &resp)
+					Expect(err).To(MatchError(ErrMessageTooLarge))
+				})
+			})
+
+			Context("server-streaming calls", func() {
+				It("receives all messages", func() {
+					str, err := client.CallStream(context.Background(), addr, "count", &testMessage{Name: "foo", Count: 5})
+					Expect(err).ToNot(HaveOccurred())
+					defer str.Close()
+					for i := 0; i < 5; i++ {
+						var m testMessage
+						Expect(str.Recv(&m)).To(Succeed())
+						Expect(m).To(Equal(testMessage{Name: "foo", Count: i}))
+					}
+					var m testMessage
+					Expect(str.Recv(&m)).To(Equal(io.EOF))
+					Expect(str.Recv(&m)).To(Equal(io.EOF))
+				})
+
+				It("returns the error of the handler after the messages", func() {
+					str, err := client.CallStream(context.Background(), addr, "count", &testMessage{Name: "fail", Count: 2})
+					Expect(err).ToNot(HaveOccurred())
+					defer str.Close()
+					var m testMessage
+					Expect(str.Recv(&m)).To(Succeed())
+					Expect(str.Recv(&m)).To(Succeed())
+					Expect(str.Recv(&m)).To(Equal(&Error{Message: "counting failed"}))
+				})
+			})
+
+			Context("deadlines and cancellation", func() {
+				It("sends the deadline to the server", func() {
+					ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+					defer cancel()
+					deadline, _ := ctx.Deadline()
+					errChan := make(chan error, 1)
+					go func() {
+						var resp testMessage
+						errChan <- client.Call(ctx, addr, "block", &testMessage{}, &resp)
+					}()
+					var hctx context.Context
+					Eventually(handlerCtx).Should(Receive(&hctx))
+					serverDeadline, ok := hctx.Deadline()
+					Expect(ok).To(BeTrue())
+					Expect(serverDeadline).To(BeTemporally("~", deadline, 50*time.Millisecond))
+					Eventually(errChan).Should(Receive(Equal(context.DeadlineExceeded)))
+					Eventually(hctx.Done()).Should(BeClosed())
+					Expect(hctx.Err()).To(Equal(context.DeadlineExceeded))
+				})
+
+				It("cancels the handler when the call is cancelled", func() {
+					ctx, cancel := context.WithCancel(context.Background())
+					errChan := make(chan error, 1)
+					go func() {
+						var resp testMessage
+						errChan <- client.Call(ctx, addr, "block", &testMessage{}, &resp)
+					}()
+					var hctx context.Context
+					Eventually(handlerCtx).Should(Receive(&hctx))
+					_, ok := hctx.Deadline()
+					Expect(ok).To(BeFalse())
+					Consistently(hctx.Done()).ShouldNot(BeClosed())
+					cancel()
+					Eventually(errChan).Should(Receive(Equal(context.Canceled)))
+					Eventually(hctx.Done()).Should(BeClosed())
+				})
+
+				It("cancels the handler when a ClientStream is closed", func() {
+					str, err := client.CallStream(context.Background(), addr, "block", &testMessage{})
+					Expect(err).ToNot(HaveOccurred())
+					var hctx context.Context
+					Eventually(handlerCtx).Should(Receive(&hctx))
+					Expect(str.Close()).To(Succeed())
+					Eventually(hctx.Done()).Should(BeClosed())
+				})
+
+				It("doesn't make calls with a context that is already done", func() {
+					ctx, cancel := context.WithCancel(context.Background())
+					cancel()
+					var resp testMessage
+					Expect(client.Call(ctx, addr, "echo", &testMessage{}, &resp)).To(Equal(context.Canceled))
+				})
+			})
+		})
+	}
+})
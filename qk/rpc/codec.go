@@ -0,0 +1,77 @@
+package rpc
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+)
+
+// A Codec encodes and decodes the messages of a call.
+// Client and server have to use the same Codec.
+type Codec interface {
+	// Marshal returns the encoding of v.
+	Marshal(v interface{}) ([]byte, error)
+	// Unmarshal parses the encoded data, and stores the result in the value pointed to by v.
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// GobCodec encodes messages using encoding/gob.
+// Every message is encoded independently, so it includes the type information.
+type GobCodec struct{}
+
+var _ Codec = GobCodec{}
+
+// Marshal encodes v using gob.
+func (GobCodec) Marshal(v interface{}) ([]byte, error) {
+	b := &bytes.Buffer{}
+	if err := gob.NewEncoder(b).Encode(v); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// Unmarshal decodes gob data into v.
+func (GobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// JSONCodec encodes messages using encoding/json.
+type JSONCodec struct{}
+
+var _ Codec = JSONCodec{}
+
+// Marshal encodes v using JSON.
+func (JSONCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// Unmarshal decodes JSON data into v.
+func (JSONCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// BinaryCodec encodes messages that implement encoding.BinaryMarshaler and encoding.BinaryUnmarshaler.
+// This is the interface implemented by protobuf-like generated message types.
+type BinaryCodec struct{}
+
+var _ Codec = BinaryCodec{}
+
+// Marshal calls v.MarshalBinary.
+func (BinaryCodec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(encoding.BinaryMarshaler)
+	if !ok {
+		return nil, fmt.Errorf("rpc: %T doesn't implement encoding.BinaryMarshaler", v)
+	}
+	return m.MarshalBinary()
+}
+
+// Unmarshal calls v.UnmarshalBinary.
+func (BinaryCodec) Unmarshal(data []byte, v interface{}) error {
+	u, ok := v.(encoding.BinaryUnmarshaler)
+	if !ok {
+		return fmt.Errorf("rpc: %T doesn't implement encoding.BinaryUnmarshaler", v)
+	}
+	return u.UnmarshalBinary(data)
+}
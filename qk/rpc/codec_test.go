@@ -0,0 +1,75 @@
+package rpc
+
+import (
+	"errors"
+	"fmt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type testMessage struct {
+	Name  string
+	Count int
+}
+
+// binaryMessage is a message that encodes itself, like protobuf generated types
+type binaryMessage struct {
+	data string
+}
+
+func (m *binaryMessage) MarshalBinary() ([]byte, error) {
+	if m.data == "" {
+		return nil, errors.New("empty message")
+	}
+	return []byte(m.data), nil
+}
+
+func (m *binaryMessage) UnmarshalBinary(data []byte) error {
+	m.data = string(data)
+	return nil
+}
+
+var _ = Describe("Codecs", func() {
+	for _, c := range []Codec{GobCodec{}, JSONCodec{}} {
+		codec := c
+
+		Context(fmt.Sprintf("%T", codec), func() {
+			It("encodes and decodes messages", func() {
+				data, err := codec.Marshal(&testMessage{Name: "foo", Count: 42})
+				Expect(err).ToNot(HaveOccurred())
+				var m testMessage
+				Expect(codec.Unmarshal(data, &m)).To(Succeed())
+				Expect(m).To(Equal(testMessage{Name: "foo", Count: 42}))
+			})
+
+			It("errors when decoding invalid data", func() {
+				var m testMessage
+				Expect(codec.Unmarshal([]byte("foobar"), &m)).ToNot(Succeed())
+			})
+		})
+	}
+
+	Context("BinaryCodec", func() {
+		It("uses the methods of the message", func() {
+			data, err := BinaryCodec{}.Marshal(&binaryMessage{data: "foobar"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(data).To(Equal([]byte("foobar")))
+			m := &binaryMessage{}
+			Expect(BinaryCodec{}.Unmarshal(data, m)).To(Succeed())
+			Expect(m.data).To(Equal("foobar"))
+		})
+
+		It("returns errors from the message", func() {
+			_, err := BinaryCodec{}.Marshal(&binaryMessage{})
+			Expect(err).To(MatchError("empty message"))
+		})
+
+		It("errors if the message doesn't implement the interfaces", func() {
+			_, err := BinaryCodec{}.Marshal("foobar")
+			Expect(err).To(MatchError("rpc: string doesn't implement encoding.BinaryMarshaler"))
+			var s string
+			Expect(BinaryCodec{}.Unmarshal([]byte("foobar"), &s)).To(MatchError("rpc: *string doesn't implement encoding.BinaryUnmarshaler"))
+		})
+	})
+})
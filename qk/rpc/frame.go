@@ -0,0 +1,103 @@
+package rpc
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"github.com/wheelcomplex/qk/internal/utils"
+)
+
+// The frame types
+const (
+	// frameTypeMessage carries a message encoded by the codec
+	frameTypeMessage = 0x0
+	// frameTypeError carries the error returned by the handler
+	frameTypeError = 0x1
+)
+
+// DefaultMaxMessageSize is the default maximum size of a message
+const DefaultMaxMessageSize = 4 << 20
+
+// ErrMessageTooLarge is returned when a message exceeds the maximum message size.
+var ErrMessageTooLarge = errors.New("rpc: message too large")
+
+var errUnknownFrameType = errors.New("rpc: unknown frame type")
+
+// writeFrame writes a frame.
+// It consists of the frame type and the length of the payload, both encoded as QUIC varints, followed by the payload.
+func writeFrame(w io.Writer, frameType uint64, payload []byte) error {
+	b := &bytes.Buffer{}
+	utils.WriteVarInt(b, frameType)
+	utils.WriteVarInt(b, uint64(len(payload)))
+	b.Write(payload)
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// readFrame reads a frame.
+// It returns io.EOF if the stream ended before the frame, and io.ErrUnexpectedEOF if it ended within the frame.
+func readFrame(r *bufio.Reader, maxSize int) (uint64, []byte, error) {
+	frameType, err := utils.ReadVarInt(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	if frameType != frameTypeMessage && frameType != frameTypeError {
+		return 0, nil, fmt.Errorf("%s: %d", errUnknownFrameType, frameType)
+	}
+	l, err := utils.ReadVarInt(r)
+	if err != nil {
+		if err == io.EOF {
+			return 0, nil, io.ErrUnexpectedEOF
+		}
+		return 0, nil, err
+	}
+	if l > uint64(maxSize) {
+		return 0, nil, ErrMessageTooLarge
+	}
+	payload := make([]byte, l)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		if err == io.EOF {
+			return 0, nil, io.ErrUnexpectedEOF
+		}
+		return 0, nil, err
+	}
+	return frameType, payload, nil
+}
+
+// writeRequestHeader writes the request header.
+// It consists of the timeout of the call in microseconds, encoded as a QUIC varint.
+// A value of 0 means that the call doesn't time out.
+func writeRequestHeader(w io.Writer, timeout time.Duration) error {
+	b := &bytes.Buffer{}
+	if timeout < 0 {
+		timeout = 0
+	}
+	us := uint64(timeout / time.Microsecond)
+	if timeout > 0 && us == 0 {
+		us = 1
+	}
+	utils.WriteVarInt(b, us)
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// readRequestHeader reads the request header, and returns the timeout of the call
+func readRequestHeader(r *bufio.Reader) (time.Duration, error) {
+	us, err := utils.ReadVarInt(r)
+	if err != nil {
+		if err == io.EOF {
+			return 0, io.ErrUnexpectedEOF
+		}
+		return 0, err
+	}
+	if us > math.MaxInt64/uint64(time.Microsecond) {
+		// The timeout can't be represented as a time.Duration, so the call effectively doesn't time out.
+		return 0, nil
+	}
+	return time.Duration(us) * time.Microsecond, nil
+}
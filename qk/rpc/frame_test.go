@@ -0,0 +1,102 @@
+package rpc
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"time"
+
+	"github.com/wheelcomplex/qk/internal/utils"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Frames", func() {
+	Context("frames", func() {
+		It("writes and reads frames", func() {
+			b := &bytes.Buffer{}
+			Expect(writeFrame(b, frameTypeMessage, []byte("foobar"))).To(Succeed())
+			Expect(writeFrame(b, frameTypeError, []byte("error"))).To(Succeed())
+			r := bufio.NewReader(b)
+			frameType, payload, err := readFrame(r, 100)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(frameType).To(BeEquivalentTo(frameTypeMessage))
+			Expect(payload).To(Equal([]byte("foobar")))
+			frameType, payload, err = readFrame(r, 100)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(frameType).To(BeEquivalentTo(frameTypeError))
+			Expect(payload).To(Equal([]byte("error")))
+			_, _, err = readFrame(r, 100)
+			Expect(err).To(Equal(io.EOF))
+		})
+
+		It("reads empty messages", func() {
+			b := &bytes.Buffer{}
+			Expect(writeFrame(b, frameTypeMessage, nil)).To(Succeed())
+			_, payload, err := readFrame(bufio.NewReader(b), 100)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(payload).To(BeEmpty())
+		})
+
+		It("errors on messages that are too large", func() {
+			b := &bytes.Buffer{}
+			Expect(writeFrame(b, frameTypeMessage, make([]byte, 101))).To(Succeed())
+			_, _, err := readFrame(bufio.NewReader(b), 100)
+			Expect(err).To(MatchError(ErrMessageTooLarge))
+		})
+
+		It("errors on unknown frame types", func() {
+			b := &bytes.Buffer{}
+			Expect(writeFrame(b, 0x42, []byte("foobar"))).To(Succeed())
+			_, _, err := readFrame(bufio.NewReader(b), 100)
+			Expect(err).To(MatchError("rpc: unknown frame type: 66"))
+		})
+
+		It("errors if the stream ends within a frame", func() {
+			b := &bytes.Buffer{}
+			Expect(writeFrame(b, frameTypeMessage, []byte("foobar"))).To(Succeed())
+			for i := 1; i < b.Len(); i++ {
+				_, _, err := readFrame(bufio.NewReader(bytes.NewReader(b.Bytes()[:i])), 100)
+				Expect(err).To(Equal(io.ErrUnexpectedEOF))
+			}
+		})
+	})
+
+	Context("request header", func() {
+		It("writes and reads the timeout", func() {
+			b := &bytes.Buffer{}
+			Expect(writeRequestHeader(b, 1337*time.Millisecond)).To(Succeed())
+			timeout, err := readRequestHeader(bufio.NewReader(b))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(timeout).To(Equal(1337 * time.Millisecond))
+		})
+
+		It("rounds up timeouts smaller than a microsecond", func() {
+			b := &bytes.Buffer{}
+			Expect(writeRequestHeader(b, time.Nanosecond)).To(Succeed())
+			timeout, err := readRequestHeader(bufio.NewReader(b))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(timeout).To(Equal(time.Microsecond))
+		})
+
+		It("writes a zero timeout if the call doesn't time out", func() {
+			b := &bytes.Buffer{}
+			Expect(writeRequestHeader(b, 0)).To(Succeed())
+			Expect(b.Bytes()).To(Equal([]byte{0}))
+		})
+
+		It("ignores timeouts that can't be represented as a time.Duration", func() {
+			b := &bytes.Buffer{}
+			utils.WriteVarInt(b, 1<<62-1)
+			timeout, err := readRequestHeader(bufio.NewReader(b))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(timeout).To(BeZero())
+		})
+
+		It("errors if the stream ends before the request header", func() {
+			_, err := readRequestHeader(bufio.NewReader(&bytes.Buffer{}))
+			Expect(err).To(Equal(io.ErrUnexpectedEOF))
+		})
+	})
+})
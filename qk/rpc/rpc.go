@@ -0,0 +1,49 @@
+// Package rpc implements remote procedure calls on top of the qk package.
+//
+// Every call uses its own QUIC stream, so that a slow call doesn't block other calls.
+// The method name is used as the route of the stream, and the Server registers a qk.Handler for every method.
+//
+// The client starts every stream with a request header containing the timeout of the call,
+// followed by the request message. It keeps the stream open for writing until the response ended,
+// so that it can reset the stream when the call is cancelled.
+// The server responds with a sequence of frames: unary calls are answered by a single message,
+// server-streaming calls by any number of messages.
+// If the handler returns an error, it is sent in an error frame at the end of the response.
+// Messages are encoded by a Codec, and framed by a length prefix.
+//
+// Cancelling the context of a call resets the stream with ErrorCodeCanceled, which cancels the context of the handler.
+// The deadline of the context is applied to the stream, and sent to the server.
+package rpc
+
+import (
+	"errors"
+
+	quic "github.com/wheelcomplex/qk"
+)
+
+// The error codes used to reset streams.
+// They don't overlap with the error codes used by the qk package.
+const (
+	// ErrorCodeCanceled is used when the call was cancelled
+	ErrorCodeCanceled quic.ErrorCode = 0x10
+	// ErrorCodeDeadlineExceeded is used when the deadline of the call expired
+	ErrorCodeDeadlineExceeded quic.ErrorCode = 0x11
+	// ErrorCodeInvalidMessage is used when a message couldn't be read or decoded
+	ErrorCodeInvalidMessage quic.ErrorCode = 0x12
+)
+
+// ErrUnknownMethod is returned by the Client if the server doesn't implement the method.
+var ErrUnknownMethod = errors.New("rpc: unknown method")
+
+// ErrNoResponse is returned by Client.Call if the server closed the stream without sending a response.
+var ErrNoResponse = errors.New("rpc: no response")
+
+// An Error is an error returned by the handler of a call.
+// It is returned by the Client with the error message sent by the server.
+type Error struct {
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
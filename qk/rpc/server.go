@@ -0,0 +1,191 @@
+package rpc
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"time"
+
+	quic "github.com/wheelcomplex/qk"
+	"github.com/wheelcomplex/qk/qk"
+)
+
+var errUnexpectedFrame = errors.New("rpc: expected a request message")
+
+// A UnaryHandler handles a unary call.
+// dec decodes the request message into the value passed to it.
+// The returned response is sent to the client, unless the error is non-nil.
+type UnaryHandler func(ctx context.Context, dec func(interface{}) error) (interface{}, error)
+
+// A ServerStreamHandler handles a server-streaming call.
+// dec decodes the request message into the value passed to it.
+// Response messages are sent using the ServerStream. The response ends when the handler returns.
+type ServerStreamHandler func(ctx context.Context, dec func(interface{}) error, stream *ServerStream) error
+
+// A ServerStream sends the response messages of a server-streaming call.
+type ServerStream struct {
+	ctx  context.Context
+	send func(interface{}) error
+}
+
+// Send sends a response message.
+func (s *ServerStream) Send(m interface{}) error {
+	return s.send(m)
+}
+
+// Context returns the context of the call.
+func (s *ServerStream) Context() context.Context {
+	return s.ctx
+}
+
+// A Server serves calls on a qk.Server.
+// Every method is registered as a route of the qk.Server.
+type Server struct {
+	// Codec is used to decode requests and encode responses.
+	// If nil, GobCodec is used.
+	Codec Codec
+
+	// MaxMessageSize limits the size of request and response messages.
+	// If zero, DefaultMaxMessageSize is used.
+	MaxMessageSize int
+
+	server *qk.Server
+}
+
+// NewServer creates a Server that registers its methods on srv.
+func NewServer(srv *qk.Server) *Server {
+	return &Server{server: srv}
+}
+
+// HandleUnary registers the handler for a unary method.
+// If a handler already exists for the method, HandleUnary panics.
+func (s *Server) HandleUnary(method string, handler UnaryHandler) {
+	if handler == nil {
+		panic("rpc: nil handler")
+	}
+	s.server.HandleFunc(method, func(str *qk.Stream) {
+		s.serve(str, func(ctx context.Context, dec func(interface{}) error, send func(interface{}) error) error {
+			resp, err := handler(ctx, dec)
+			if err != nil {
+				return err
+			}
+			return send(resp)
+		})
+	})
+}
+
+// HandleServerStream registers the handler for a server-streaming method.
+// If a handler already exists for the method, HandleServerStream panics.
+func (s *Server) HandleServerStream(method string, handler ServerStreamHandler) {
+	if handler == nil {
+		panic("rpc: nil handler")
+	}
+	s.server.HandleFunc(method, func(str *qk.Stream) {
+		s.serve(str, func(ctx context.Context, dec func(interface{}) error, send func(interface{}) error) error {
+			return handler(ctx, dec, &ServerStream{ctx: ctx, send: send})
+		})
+	})
+}
+
+func (s *Server) serve(
+	str *qk.Stream,
+	handle func(ctx context.Context, dec func(interface{}) error, send func(interface{}) error) error,
+) {
+	codec := s.codec()
+	maxSize := s.maxMessageSize()
+
+	br := bufio.NewReader(str)
+	timeout, err := readRequestHeader(br)
+	var frameType uint64
+	var payload []byte
+	if err == nil {
+		frameType, payload, err = readFrame(br, maxSize)
+		if err == nil && frameType != frameTypeMessage {
+			err = errUnexpectedFrame
+		}
+	}
+	if err != nil {
+		str.CancelRead(ErrorCodeInvalidMessage)
+		str.CancelWrite(ErrorCodeInvalidMessage)
+		return
+	}
+
+	// The context is cancelled when the session is closed, or when the deadline expires.
+	ctx := str.Session().Context()
+	var cancel context.CancelFunc
+	if timeout > 0 {
+		deadline := time.Now().Add(timeout)
+		str.SetDeadline(deadline)
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	defer cancel()
+	// The client sends nothing after the request, until it either closes the stream after the response,
+	// or resets it when the call is cancelled.
+	go func() {
+		_, err := br.ReadByte()
+		if err == io.EOF {
+			return
+		}
+		if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+			return
+		}
+		// The deadline of the handler expires at about the same time, so that it reports context.DeadlineExceeded.
+		if serr, ok := err.(quic.StreamError); ok && serr.ErrorCode() == ErrorCodeDeadlineExceeded && timeout > 0 {
+			return
+		}
+		cancel()
+	}()
+
+	dec := func(v interface{}) error {
+		return codec.Unmarshal(payload, v)
+	}
+	send := func(m interface{}) error {
+		data, err := codec.Marshal(m)
+		if err != nil {
+			return err
+		}
+		if len(data) > maxSize {
+			return ErrMessageTooLarge
+		}
+		if err := writeFrame(str, frameTypeMessage, data); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		return nil
+	}
+
+	err = handle(ctx, dec, send)
+	if ctx.Err() != nil {
+		code := ErrorCodeCanceled
+		if ctx.Err() == context.DeadlineExceeded {
+			code = ErrorCodeDeadlineExceeded
+		}
+		str.CancelRead(code)
+		str.CancelWrite(code)
+		return
+	}
+	if err != nil {
+		writeFrame(str, frameTypeError, []byte(err.Error()))
+	}
+	// The qk.Server closes the stream when we return.
+}
+
+func (s *Server) codec() Codec {
+	if s.Codec == nil {
+		return GobCodec{}
+	}
+	return s.Codec
+}
+
+func (s *Server) maxMessageSize() int {
+	if s.MaxMessageSize <= 0 {
+		return DefaultMaxMessageSize
+	}
+	return s.MaxMessageSize
+}
@@ -0,0 +1,139 @@
+package rpc
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net"
+
+	quic "github.com/wheelcomplex/qk"
+	"github.com/wheelcomplex/qk/internal/testdata"
+	"github.com/wheelcomplex/qk/qk"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Server", func() {
+	var (
+		qkServer *qk.Server
+		server   *Server
+		qkClient *qk.Client
+		addr     string
+		called   chan struct{}
+	)
+
+	BeforeEach(func() {
+		qkServer = &qk.Server{TLSConfig: testdata.GetTLSConfig()}
+		server = NewServer(qkServer)
+		called = make(chan struct{}, 1)
+		server.HandleUnary("echo", func(ctx context.Context, dec func(interface{}) error) (interface{}, error) {
+			called <- struct{}{}
+			var m testMessage
+			if err := dec(&m); err != nil {
+				return nil, err
+			}
+			return &m, nil
+		})
+		server.HandleServerStream("large", func(ctx context.Context, _ func(interface{}) error, stream *ServerStream) error {
+			return stream.Send(&testMessage{Name: string(make([]byte, 2000))})
+		})
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		Expect(err).ToNot(HaveOccurred())
+		go qkServer.Serve(conn)
+		addr = fmt.Sprintf("localhost:%d", conn.LocalAddr().(*net.UDPAddr).Port)
+		qkClient = &qk.Client{TLSConfig: &tls.Config{InsecureSkipVerify: true}}
+	})
+
+	AfterEach(func() {
+		Expect(qkClient.Close()).To(Succeed())
+		Expect(qkServer.Close()).To(Succeed())
+	})
+
+	expectStreamError := func(err error, code quic.ErrorCode) {
+		ExpectWithOffset(1, err).To(HaveOccurred())
+		serr, ok := err.(quic.StreamError)
+		ExpectWithOffset(1, ok).To(BeTrue())
+		ExpectWithOffset(1, serr.ErrorCode()).To(Equal(code))
+	}
+
+	It("panics when registering a nil handler", func() {
+		Expect(func() { server.HandleUnary("foo", nil) }).To(Panic())
+		Expect(func() { server.HandleServerStream("foo", nil) }).To(Panic())
+	})
+
+	It("panics when a method is registered twice", func() {
+		Expect(func() {
+			server.HandleServerStream("echo", func(context.Context, func(interface{}) error, *ServerStream) error { return nil })
+		}).To(Panic())
+	})
+
+	It("responds with a message frame", func() {
+		data, err := GobCodec{}.Marshal(&testMessage{Name: "foo"})
+		Expect(err).ToNot(HaveOccurred())
+		str, err := qkClient.OpenStream(addr, "echo")
+		Expect(err).ToNot(HaveOccurred())
+		b := &bytes.Buffer{}
+		Expect(writeRequestHeader(b, 0)).To(Succeed())
+		Expect(writeFrame(b, frameTypeMessage, data)).To(Succeed())
+		_, err = str.Write(b.Bytes())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(str.Close()).To(Succeed())
+		response, err := ioutil.ReadAll(str)
+		Expect(err).ToNot(HaveOccurred())
+		frameType, payload, err := readFrame(bufio.NewReader(bytes.NewReader(response)), 1000)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(frameType).To(BeEquivalentTo(frameTypeMessage))
+		Expect(payload).To(Equal(data))
+	})
+
+	It("resets streams without a request message", func() {
+		str, err := qkClient.OpenStream(addr, "echo")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(writeRequestHeader(str, 0)).To(Succeed())
+		Expect(str.Close()).To(Succeed())
+		_, err = ioutil.ReadAll(str)
+		expectStreamError(err, ErrorCodeInvalidMessage)
+		Consistently(called).ShouldNot(Receive())
+	})
+
+	It("resets streams that start with an error frame", func() {
+		str, err := qkClient.OpenStream(addr, "echo")
+		Expect(err).ToNot(HaveOccurred())
+		b := &bytes.Buffer{}
+		Expect(writeRequestHeader(b, 0)).To(Succeed())
+		Expect(writeFrame(b, frameTypeError, []byte("foobar"))).To(Succeed())
+		_, err = str.Write(b.Bytes())
+		Expect(err).ToNot(HaveOccurred())
+		_, err = ioutil.ReadAll(str)
+		expectStreamError(err, ErrorCodeInvalidMessage)
+		Consistently(called).ShouldNot(Receive())
+	})
+
+	It("resets streams with request messages larger than the maximum message size", func() {
+		server.MaxMessageSize = 10
+		str, err := qkClient.OpenStream(addr, "echo")
+		Expect(err).ToNot(HaveOccurred())
+		b := &bytes.Buffer{}
+		Expect(writeRequestHeader(b, 0)).To(Succeed())
+		Expect(writeFrame(b, frameTypeMessage, make([]byte, 11))).To(Succeed())
+		_, err = str.Write(b.Bytes())
+		Expect(err).ToNot(HaveOccurred())
+		_, err = ioutil.ReadAll(str)
+		expectStreamError(err, ErrorCodeInvalidMessage)
+		Consistently(called).ShouldNot(Receive())
+	})
+
+	It("refuses to send response messages larger than the maximum message size", func() {
+		server.MaxMessageSize = 1000
+		client := NewClient(qkClient)
+		str, err := client.CallStream(context.Background(), addr, "large", &testMessage{})
+		Expect(err).ToNot(HaveOccurred())
+		defer str.Close()
+		var m testMessage
+		Expect(str.Recv(&m)).To(MatchError(ErrMessageTooLarge.Error()))
+	})
+})
@@ -0,0 +1,539 @@
+package tunnel
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	quic "github.com/wheelcomplex/qk"
+	"github.com/wheelcomplex/qk/qk"
+)
+
+// The default values of the Client's fields
+const (
+	defaultMinBackoff     = 100 * time.Millisecond
+	defaultMaxBackoff     = 30 * time.Second
+	defaultConnectTimeout = 30 * time.Second
+	defaultUDPIdleTimeout = time.Minute
+)
+
+// udpQueueSize is the number of datagrams that are queued for a UDP flow before datagrams are dropped
+const udpQueueSize = 64
+
+// A Client is the local side of tunnels.
+// It forwards TCP connections and UDP flows to the Server at a single address, using a qk.Client.
+//
+// If opening a stream fails, because the session to the Server can't be dialed, the Client waits before the next attempt.
+// The delay starts at MinBackoff, and doubles with every failed attempt, up to MaxBackoff.
+// It is shared by all tunnels of the Client, and reset once a stream was opened.
+type Client struct {
+	// MinBackoff is the delay after the first failed attempt to open a stream.
+	// If zero, a delay of 100ms is used.
+	MinBackoff time.Duration
+
+	// MaxBackoff is the maximum delay between attempts to open a stream.
+	// If zero, a delay of 30 seconds is used.
+	MaxBackoff time.Duration
+
+	// ConnectTimeout is the maximum amount of time that a new TCP connection or UDP flow waits for its stream to be opened.
+	// The connection is closed, or the datagrams of the flow are dropped, if the stream can't be opened in time.
+	// If zero, a timeout of 30 seconds is used.
+	ConnectTimeout time.Duration
+
+	// UDPIdleTimeout is the amount of time after which a UDP flow without any datagrams is closed.
+	// If zero, a timeout of 1 minute is used.
+	UDPIdleTimeout time.Duration
+
+	client *qk.Client
+	addr   string
+
+	mutex       sync.Mutex
+	backoff     time.Duration // the current delay, zero if the last attempt succeeded
+	lastFailure time.Time
+	nextAttempt time.Time
+}
+
+// NewClient creates a Client that forwards to the Server at addr, opening streams using c.
+func NewClient(c *qk.Client, addr string) *Client {
+	return &Client{client: c, addr: addr}
+}
+
+// ListenTCP listens on the TCP network address localAddr, and forwards all accepted connections to target.
+func (c *Client) ListenTCP(localAddr, target string) (*Tunnel, error) {
+	ln, err := net.Listen("tcp", localAddr)
+	if err != nil {
+		return nil, err
+	}
+	return c.ServeTCP(ln, target), nil
+}
+
+// ServeTCP forwards all connections accepted from ln to target.
+// Closing the Tunnel closes ln.
+func (c *Client) ServeTCP(ln net.Listener, target string) *Tunnel {
+	t := newTunnel(c, "tcp", target)
+	t.ln = ln
+	go t.acceptConns()
+	return t
+}
+
+// ListenUDP listens on the UDP network address localAddr, and forwards the datagrams of every local address to target.
+func (c *Client) ListenUDP(localAddr, target string) (*Tunnel, error) {
+	conn, err := net.ListenPacket("udp", localAddr)
+	if err != nil {
+		return nil, err
+	}
+	return c.ServeUDP(conn, target), nil
+}
+
+// ServeUDP forwards the datagrams received on conn to target.
+// The datagrams of every local address are carried on their own stream, and the responses are sent back to that address.
+// Closing the Tunnel closes conn.
+func (c *Client) ServeUDP(conn net.PacketConn, target string) *Tunnel {
+	t := newTunnel(c, "udp", target)
+	t.pconn = conn
+	go t.readDatagrams()
+	return t
+}
+
+// openStream opens a stream on the route, and writes the request header.
+// Failed attempts are retried after the backoff delay, until the context is done.
+func (c *Client) openStream(ctx context.Context, route, target string) (quic.Stream, error) {
+	if len(target) > maxTargetLength {
+		return nil, errTargetTooLong
+	}
+	for {
+		if err := c.waitForBackoff(ctx); err != nil {
+			return nil, err
+		}
+		start := time.Now()
+		str, err := c.tryOpenStream(ctx, route, target)
+		if err == nil {
+			c.onAttempt(start, true)
+			return str, nil
+		}
+		if err == qk.ErrClientClosed || ctx.Err() != nil {
+			return nil, err
+		}
+		c.onAttempt(start, false)
+	}
+}
+
+// tryOpenStream makes a single attempt to open a stream.
+// It returns early if the context is done while the session is dialed, or while waiting for the stream limit.
+func (c *Client) tryOpenStream(ctx context.Context, route, target string) (quic.Stream, error) {
+	type result struct {
+		str *qk.Stream
+		err error
+	}
+	resChan := make(chan result, 1)
+	go func() {
+		str, err := c.client.OpenStream(c.addr, route)
+		if err == nil {
+			if err = writeRequestHeader(str, target); err != nil {
+				str.CancelWrite(ErrorCodeInvalidRequest)
+				str.CancelRead(ErrorCodeInvalidRequest)
+			}
+		}
+		resChan <- result{str: str, err: err}
+	}()
+	select {
+	case res := <-resChan:
+		if res.err != nil {
+			return nil, res.err
+		}
+		return res.str, nil
+	case <-ctx.Done():
+		go func() {
+			if res := <-resChan; res.err == nil {
+				res.str.CancelWrite(ErrorCodeConnectionReset)
+				res.str.CancelRead(ErrorCodeConnectionReset)
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
+
+func (c *Client) waitForBackoff(ctx context.Context) error {
+	c.mutex.Lock()
+	wait := time.Until(c.nextAttempt)
+	c.mutex.Unlock()
+	if wait <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// onAttempt updates the backoff delay after an attempt to open a stream that was started at start
+func (c *Client) onAttempt(start time.Time, success bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if success {
+		c.backoff = 0
+		c.nextAttempt = time.Time{}
+		return
+	}
+	// Concurrent attempts usually fail for the same reason.
+	// Only the first of them increases the delay.
+	if start.Before(c.lastFailure) {
+		return
+	}
+	if c.backoff == 0 {
+		c.backoff = c.minBackoff()
+	} else {
+		c.backoff *= 2
+	}
+	if max := c.maxBackoff(); c.backoff > max {
+		c.backoff = max
+	}
+	c.lastFailure = time.Now()
+	c.nextAttempt = c.lastFailure.Add(c.backoff)
+}
+
+func (c *Client) minBackoff() time.Duration {
+	if c.MinBackoff <= 0 {
+		return defaultMinBackoff
+	}
+	return c.MinBackoff
+}
+
+func (c *Client) maxBackoff() time.Duration {
+	if c.MaxBackoff <= 0 {
+		return defaultMaxBackoff
+	}
+	return c.MaxBackoff
+}
+
+func (c *Client) connectTimeout() time.Duration {
+	if c.ConnectTimeout <= 0 {
+		return defaultConnectTimeout
+	}
+	return c.ConnectTimeout
+}
+
+func (c *Client) udpIdleTimeout() time.Duration {
+	if c.UDPIdleTimeout <= 0 {
+		return defaultUDPIdleTimeout
+	}
+	return c.UDPIdleTimeout
+}
+
+// Stats are the statistics of a Tunnel.
+// For a UDP tunnel, every flow counts as a connection.
+type Stats struct {
+	// ActiveConns is the number of connections that are currently forwarded
+	ActiveConns int64
+	// TotalConns is the number of connections that were accepted
+	TotalConns int64
+	// FailedConns is the number of connections that couldn't be forwarded,
+	// because no stream could be opened, or the Server rejected the stream or failed to dial the target.
+	FailedConns int64
+	// BytesSent is the number of bytes sent to the target
+	BytesSent int64
+	// BytesReceived is the number of bytes received from the target
+	BytesReceived int64
+	// DatagramsSent is the number of datagrams sent to the target
+	DatagramsSent int64
+	// DatagramsReceived is the number of datagrams received from the target
+	DatagramsReceived int64
+	// DatagramsDropped is the number of datagrams that were dropped, because the stream of their flow couldn't be opened in time
+	DatagramsDropped int64
+}
+
+// A Tunnel forwards the connections accepted on a local address to a target.
+type Tunnel struct {
+	stats Stats // accessed atomically, first in the struct to be 64-bit aligned
+
+	client  *Client
+	network string
+	target  string
+
+	ln    net.Listener   // nil for a UDP tunnel
+	pconn net.PacketConn // nil for a TCP tunnel
+
+	ctx    context.Context // cancelled when the Tunnel is closed
+	cancel context.CancelFunc
+
+	mutex sync.Mutex
+	conns map[net.Conn]struct{}
+	flows map[string]*udpFlow
+
+	closeOnce sync.Once
+	closed    chan struct{}
+	closeErr  error
+}
+
+func newTunnel(c *Client, network, target string) *Tunnel {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Tunnel{
+		client:  c,
+		network: network,
+		target:  target,
+		ctx:     ctx,
+		cancel:  cancel,
+		conns:   make(map[net.Conn]struct{}),
+		flows:   make(map[string]*udpFlow),
+		closed:  make(chan struct{}),
+	}
+}
+
+// Addr returns the local address of the Tunnel.
+func (t *Tunnel) Addr() net.Addr {
+	if t.ln != nil {
+		return t.ln.Addr()
+	}
+	return t.pconn.LocalAddr()
+}
+
+// Network returns the network of the Tunnel, "tcp" or "udp".
+func (t *Tunnel) Network() string {
+	return t.network
+}
+
+// Target returns the address that the Server forwards to.
+func (t *Tunnel) Target() string {
+	return t.target
+}
+
+// Stats returns the current statistics of the Tunnel.
+func (t *Tunnel) Stats() Stats {
+	return Stats{
+		ActiveConns:       atomic.LoadInt64(&t.stats.ActiveConns),
+		TotalConns:        atomic.LoadInt64(&t.stats.TotalConns),
+		FailedConns:       atomic.LoadInt64(&t.stats.FailedConns),
+		BytesSent:         atomic.LoadInt64(&t.stats.BytesSent),
+		BytesReceived:     atomic.LoadInt64(&t.stats.BytesReceived),
+		DatagramsSent:     atomic.LoadInt64(&t.stats.DatagramsSent),
+		DatagramsReceived: atomic.LoadInt64(&t.stats.DatagramsReceived),
+		DatagramsDropped:  atomic.LoadInt64(&t.stats.DatagramsDropped),
+	}
+}
+
+// Done returns a channel that is closed when the Tunnel stops forwarding,
+// either because it was closed, or because the local listener failed.
+func (t *Tunnel) Done() <-chan struct{} {
+	return t.closed
+}
+
+// Err returns the reason why the Tunnel stopped forwarding.
+// It returns ErrTunnelClosed after a call to Close, and nil if the Tunnel is still running.
+func (t *Tunnel) Err() error {
+	select {
+	case <-t.closed:
+		return t.closeErr
+	default:
+		return nil
+	}
+}
+
+// Close closes the local listener, and aborts all connections that are currently forwarded.
+func (t *Tunnel) Close() error {
+	t.closeWithError(ErrTunnelClosed)
+	return nil
+}
+
+func (t *Tunnel) closeWithError(err error) {
+	t.closeOnce.Do(func() {
+		t.closeErr = err
+		t.cancel()
+		if t.ln != nil {
+			t.ln.Close()
+		} else {
+			t.pconn.Close()
+		}
+		t.mutex.Lock()
+		for conn := range t.conns {
+			abort(conn)
+		}
+		t.mutex.Unlock()
+		close(t.closed)
+	})
+}
+
+func (t *Tunnel) acceptConns() {
+	for {
+		conn, err := t.ln.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				time.Sleep(5 * time.Millisecond)
+				continue
+			}
+			t.closeWithError(err)
+			return
+		}
+		t.mutex.Lock()
+		if t.ctx.Err() != nil {
+			t.mutex.Unlock()
+			conn.Close()
+			return
+		}
+		t.conns[conn] = struct{}{}
+		t.mutex.Unlock()
+		go t.handleConn(conn)
+	}
+}
+
+func (t *Tunnel) handleConn(conn net.Conn) {
+	atomic.AddInt64(&t.stats.TotalConns, 1)
+	atomic.AddInt64(&t.stats.ActiveConns, 1)
+	defer func() {
+		t.mutex.Lock()
+		delete(t.conns, conn)
+		t.mutex.Unlock()
+		atomic.AddInt64(&t.stats.ActiveConns, -1)
+	}()
+
+	ctx, cancel := context.WithTimeout(t.ctx, t.client.connectTimeout())
+	str, err := t.client.openStream(ctx, RouteTCP, t.target)
+	cancel()
+	if err != nil {
+		atomic.AddInt64(&t.stats.FailedConns, 1)
+		abort(conn)
+		return
+	}
+	if err := pipe(conn, str, &t.stats.BytesSent, &t.stats.BytesReceived); isRejected(err) {
+		atomic.AddInt64(&t.stats.FailedConns, 1)
+	}
+}
+
+// isRejected says if the error was caused by the Server resetting the stream before forwarding it
+func isRejected(err error) bool {
+	serr, ok := err.(quic.StreamError)
+	if !ok {
+		return false
+	}
+	switch serr.ErrorCode() {
+	case ErrorCodeDialFailed, ErrorCodeForbidden, ErrorCodeInvalidRequest, qk.ErrorCodeUnknownRoute:
+		return true
+	default:
+		return false
+	}
+}
+
+// A udpFlow carries the datagrams exchanged with a single local address
+type udpFlow struct {
+	addr         net.Addr
+	queue        chan []byte // datagrams that are waiting to be sent on the stream
+	lastActivity int64       // accessed atomically, in nanoseconds since the Unix epoch
+}
+
+func (f *udpFlow) touch() {
+	atomic.StoreInt64(&f.lastActivity, time.Now().UnixNano())
+}
+
+func (f *udpFlow) idleFor() time.Duration {
+	return time.Since(time.Unix(0, atomic.LoadInt64(&f.lastActivity)))
+}
+
+func (t *Tunnel) readDatagrams() {
+	b := make([]byte, MaxDatagramSize)
+	for {
+		n, addr, err := t.pconn.ReadFrom(b)
+		if err != nil {
+			t.closeWithError(err)
+			return
+		}
+		p := make([]byte, n)
+		copy(p, b[:n])
+
+		key := addr.String()
+		t.mutex.Lock()
+		f, ok := t.flows[key]
+		if !ok {
+			f = &udpFlow{addr: addr, queue: make(chan []byte, udpQueueSize)}
+			f.touch()
+			t.flows[key] = f
+			go t.runFlow(key, f)
+		}
+		select {
+		case f.queue <- p:
+		default:
+			atomic.AddInt64(&t.stats.DatagramsDropped, 1)
+		}
+		t.mutex.Unlock()
+	}
+}
+
+// runFlow opens the stream for the flow, and carries the datagrams until the flow is idle, or the stream fails.
+func (t *Tunnel) runFlow(key string, f *udpFlow) {
+	atomic.AddInt64(&t.stats.TotalConns, 1)
+	atomic.AddInt64(&t.stats.ActiveConns, 1)
+	defer func() {
+		t.mutex.Lock()
+		delete(t.flows, key)
+		atomic.AddInt64(&t.stats.DatagramsDropped, int64(len(f.queue)))
+		t.mutex.Unlock()
+		atomic.AddInt64(&t.stats.ActiveConns, -1)
+	}()
+
+	ctx, cancel := context.WithTimeout(t.ctx, t.client.connectTimeout())
+	str, err := t.client.openStream(ctx, RouteUDP, t.target)
+	cancel()
+	if err != nil {
+		atomic.AddInt64(&t.stats.FailedConns, 1)
+		return
+	}
+
+	recvErr := make(chan error, 1)
+	go func() {
+		br := bufio.NewReader(str)
+		for {
+			p, err := readDatagram(br)
+			if err != nil {
+				recvErr <- err
+				return
+			}
+			f.touch()
+			atomic.AddInt64(&t.stats.DatagramsReceived, 1)
+			atomic.AddInt64(&t.stats.BytesReceived, int64(len(p)))
+			// Errors are ignored, as with any UDP socket: the datagram is lost.
+			t.pconn.WriteTo(p, f.addr)
+		}
+	}()
+
+	reset := func(code quic.ErrorCode) {
+		str.CancelWrite(code)
+		str.CancelRead(code)
+	}
+	idleTimeout := t.client.udpIdleTimeout()
+	timer := time.NewTimer(idleTimeout)
+	defer timer.Stop()
+	for {
+		select {
+		case p := <-f.queue:
+			if err := writeDatagram(str, p); err != nil {
+				reset(ErrorCodeConnectionReset)
+				return
+			}
+			f.touch()
+			atomic.AddInt64(&t.stats.DatagramsSent, 1)
+			atomic.AddInt64(&t.stats.BytesSent, int64(len(p)))
+		case err := <-recvErr:
+			if isRejected(err) {
+				atomic.AddInt64(&t.stats.FailedConns, 1)
+			}
+			reset(ErrorCodeConnectionReset)
+			return
+		case <-timer.C:
+			if idle := f.idleFor(); idle < idleTimeout {
+				timer.Reset(idleTimeout - idle)
+				continue
+			}
+			str.Close()
+			str.CancelRead(qk.ErrorCodeNoError)
+			return
+		case <-t.ctx.Done():
+			reset(ErrorCodeConnectionReset)
+			return
+		}
+	}
+}
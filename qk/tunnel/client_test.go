@@ -0,0 +1,339 @@
+package tunnel
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"time"
+
+	quic "github.com/wheelcomplex/qk"
+	"github.com/wheelcomplex/qk/internal/testdata"
+	"github.com/wheelcomplex/qk/qk"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// runTCPEchoServer runs a TCP server that echoes all data, and closes the connection after the client closed it for writing
+func runTCPEchoServer() net.Listener {
+	ln, err := net.Listen("tcp", "localhost:0")
+	ExpectWithOffset(1, err).ToNot(HaveOccurred())
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return ln
+}
+
+// runUDPEchoServer runs a UDP server that echoes all datagrams
+func runUDPEchoServer() net.PacketConn {
+	conn, err := net.ListenPacket("udp", "localhost:0")
+	ExpectWithOffset(1, err).ToNot(HaveOccurred())
+	go func() {
+		b := make([]byte, MaxDatagramSize)
+		for {
+			n, addr, err := conn.ReadFrom(b)
+			if err != nil {
+				return
+			}
+			conn.WriteTo(b[:n], addr)
+		}
+	}()
+	return conn
+}
+
+var _ = Describe("Client", func() {
+	var (
+		qkServer *qk.Server
+		qkClient *qk.Client
+		client   *Client
+		udpConn  net.PacketConn
+	)
+
+	startServer := func(conn net.PacketConn) {
+		qkServer = &qk.Server{TLSConfig: testdata.GetTLSConfig()}
+		NewServer(qkServer)
+		go qkServer.Serve(conn)
+	}
+
+	BeforeEach(func() {
+		var err error
+		udpConn, err = net.ListenPacket("udp", "localhost:0")
+		Expect(err).ToNot(HaveOccurred())
+		startServer(udpConn)
+		qkClient = &qk.Client{TLSConfig: &tls.Config{InsecureSkipVerify: true}}
+		client = NewClient(qkClient, udpConn.LocalAddr().String())
+	})
+
+	AfterEach(func() {
+		Expect(qkClient.Close()).To(Succeed())
+		Expect(qkServer.Close()).To(Succeed())
+		udpConn.Close()
+	})
+
+	Context("TCP", func() {
+		var target net.Listener
+
+		BeforeEach(func() {
+			target = runTCPEchoServer()
+		})
+
+		AfterEach(func() {
+			target.Close()
+		})
+
+		It("forwards TCP connections", func() {
+			tun, err := client.ListenTCP("localhost:0", target.Addr().String())
+			Expect(err).ToNot(HaveOccurred())
+			defer tun.Close()
+			Expect(tun.Network()).To(Equal("tcp"))
+			Expect(tun.Target()).To(Equal(target.Addr().String()))
+
+			for i := 0; i < 3; i++ {
+				conn, err := net.Dial("tcp", tun.Addr().String())
+				Expect(err).ToNot(HaveOccurred())
+				msg := fmt.Sprintf("message %d", i)
+				_, err = conn.Write([]byte(msg))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(conn.(*net.TCPConn).CloseWrite()).To(Succeed())
+				data, err := ioutil.ReadAll(conn)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(string(data)).To(Equal(msg))
+				conn.Close()
+			}
+			Eventually(func() int64 { return tun.Stats().ActiveConns }).Should(BeZero())
+			stats := tun.Stats()
+			Expect(stats.TotalConns).To(BeEquivalentTo(3))
+			Expect(stats.FailedConns).To(BeZero())
+			Expect(stats.BytesSent).To(BeEquivalentTo(3 * len("message 0")))
+			Expect(stats.BytesReceived).To(BeEquivalentTo(3 * len("message 0")))
+		})
+
+		It("forwards concurrent connections with a lot of data", func() {
+			tun, err := client.ListenTCP("localhost:0", target.Addr().String())
+			Expect(err).ToNot(HaveOccurred())
+			defer tun.Close()
+
+			data := make([]byte, 1<<20)
+			for i := range data {
+				data[i] = byte(i)
+			}
+			const num = 5
+			done := make(chan struct{}, num)
+			for i := 0; i < num; i++ {
+				go func() {
+					defer GinkgoRecover()
+					conn, err := net.Dial("tcp", tun.Addr().String())
+					Expect(err).ToNot(HaveOccurred())
+					defer conn.Close()
+					go func() {
+						defer GinkgoRecover()
+						_, err := conn.Write(data)
+						Expect(err).ToNot(HaveOccurred())
+						Expect(conn.(*net.TCPConn).CloseWrite()).To(Succeed())
+					}()
+					echo, err := ioutil.ReadAll(conn)
+					Expect(err).ToNot(HaveOccurred())
+					Expect(echo).To(Equal(data))
+					done <- struct{}{}
+				}()
+			}
+			for i := 0; i < num; i++ {
+				Eventually(done, 10*time.Second).Should(Receive())
+			}
+			Eventually(func() int64 { return tun.Stats().BytesReceived }).Should(BeEquivalentTo(num * len(data)))
+		})
+
+		It("closes the connection if the target can't be dialed", func() {
+			ln, err := net.Listen("tcp", "localhost:0")
+			Expect(err).ToNot(HaveOccurred())
+			addr := ln.Addr().String()
+			Expect(ln.Close()).To(Succeed())
+
+			tun, err := client.ListenTCP("localhost:0", addr)
+			Expect(err).ToNot(HaveOccurred())
+			defer tun.Close()
+			conn, err := net.Dial("tcp", tun.Addr().String())
+			Expect(err).ToNot(HaveOccurred())
+			defer conn.Close()
+			_, err = ioutil.ReadAll(conn)
+			Expect(err).To(HaveOccurred())
+			Eventually(func() int64 { return tun.Stats().FailedConns }).Should(BeEquivalentTo(1))
+		})
+
+		It("aborts the connections when the Tunnel is closed", func() {
+			tun, err := client.ListenTCP("localhost:0", target.Addr().String())
+			Expect(err).ToNot(HaveOccurred())
+			conn, err := net.Dial("tcp", tun.Addr().String())
+			Expect(err).ToNot(HaveOccurred())
+			defer conn.Close()
+			_, err = conn.Write([]byte("foobar"))
+			Expect(err).ToNot(HaveOccurred())
+			buf := make([]byte, 6)
+			_, err = io.ReadFull(conn, buf)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(tun.Close()).To(Succeed())
+			Eventually(tun.Done()).Should(BeClosed())
+			Expect(tun.Err()).To(MatchError(ErrTunnelClosed))
+			_, err = conn.Read(buf)
+			Expect(err).To(HaveOccurred())
+			_, err = net.Dial("tcp", tun.Addr().String())
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("redials the session after the server restarted", func() {
+			client.MinBackoff = 10 * time.Millisecond
+			tun, err := client.ListenTCP("localhost:0", target.Addr().String())
+			Expect(err).ToNot(HaveOccurred())
+			defer tun.Close()
+
+			roundTrip := func() error {
+				conn, err := net.Dial("tcp", tun.Addr().String())
+				if err != nil {
+					return err
+				}
+				defer conn.Close()
+				if _, err := conn.Write([]byte("foobar")); err != nil {
+					return err
+				}
+				conn.(*net.TCPConn).CloseWrite()
+				data, err := ioutil.ReadAll(conn)
+				if err != nil {
+					return err
+				}
+				if string(data) != "foobar" {
+					return errors.New("unexpected echo")
+				}
+				return nil
+			}
+			Expect(roundTrip()).To(Succeed())
+
+			addr := udpConn.LocalAddr().String()
+			Expect(qkServer.Close()).To(Succeed())
+			Expect(udpConn.Close()).To(Succeed())
+			udpConn, err = net.ListenPacket("udp", addr)
+			Expect(err).ToNot(HaveOccurred())
+			startServer(udpConn)
+			Eventually(roundTrip, 5*time.Second).Should(Succeed())
+		})
+	})
+
+	Context("UDP", func() {
+		var target net.PacketConn
+
+		BeforeEach(func() {
+			target = runUDPEchoServer()
+		})
+
+		AfterEach(func() {
+			target.Close()
+		})
+
+		It("forwards UDP flows", func() {
+			tun, err := client.ListenUDP("localhost:0", target.LocalAddr().String())
+			Expect(err).ToNot(HaveOccurred())
+			defer tun.Close()
+			Expect(tun.Network()).To(Equal("udp"))
+
+			for i := 0; i < 2; i++ {
+				conn, err := net.Dial("udp", tun.Addr().String())
+				Expect(err).ToNot(HaveOccurred())
+				defer conn.Close()
+				for j := 0; j < 3; j++ {
+					msg := fmt.Sprintf("datagram %d/%d", i, j)
+					_, err = conn.Write([]byte(msg))
+					Expect(err).ToNot(HaveOccurred())
+					Expect(conn.SetReadDeadline(time.Now().Add(2 * time.Second))).To(Succeed())
+					b := make([]byte, 100)
+					n, err := conn.Read(b)
+					Expect(err).ToNot(HaveOccurred())
+					Expect(string(b[:n])).To(Equal(msg))
+				}
+			}
+			stats := tun.Stats()
+			Expect(stats.TotalConns).To(BeEquivalentTo(2))
+			Expect(stats.ActiveConns).To(BeEquivalentTo(2))
+			Expect(stats.DatagramsSent).To(BeEquivalentTo(6))
+			Expect(stats.DatagramsReceived).To(BeEquivalentTo(6))
+			Expect(stats.BytesSent).To(BeEquivalentTo(6 * len("datagram 0/0")))
+		})
+
+		It("closes idle flows", func() {
+			client.UDPIdleTimeout = 100 * time.Millisecond
+			tun, err := client.ListenUDP("localhost:0", target.LocalAddr().String())
+			Expect(err).ToNot(HaveOccurred())
+			defer tun.Close()
+
+			conn, err := net.Dial("udp", tun.Addr().String())
+			Expect(err).ToNot(HaveOccurred())
+			defer conn.Close()
+			sendAndReceive := func() {
+				_, err := conn.Write([]byte("foobar"))
+				ExpectWithOffset(1, err).ToNot(HaveOccurred())
+				Expect(conn.SetReadDeadline(time.Now().Add(2 * time.Second))).To(Succeed())
+				b := make([]byte, 100)
+				n, err := conn.Read(b)
+				ExpectWithOffset(1, err).ToNot(HaveOccurred())
+				ExpectWithOffset(1, string(b[:n])).To(Equal("foobar"))
+			}
+			sendAndReceive()
+			Expect(tun.Stats().ActiveConns).To(BeEquivalentTo(1))
+			Eventually(func() int64 { return tun.Stats().ActiveConns }).Should(BeZero())
+			// A new flow is started for the next datagram.
+			sendAndReceive()
+			Expect(tun.Stats().TotalConns).To(BeEquivalentTo(2))
+		})
+	})
+
+	Context("backoff", func() {
+		It("increases the delay after failed attempts", func() {
+			client.MinBackoff = 10 * time.Millisecond
+			client.MaxBackoff = 50 * time.Millisecond
+			client.onAttempt(time.Now(), false)
+			Expect(client.backoff).To(Equal(10 * time.Millisecond))
+			client.onAttempt(time.Now(), false)
+			Expect(client.backoff).To(Equal(20 * time.Millisecond))
+			client.onAttempt(time.Now(), false)
+			Expect(client.backoff).To(Equal(40 * time.Millisecond))
+			client.onAttempt(time.Now(), false)
+			Expect(client.backoff).To(Equal(50 * time.Millisecond))
+			Expect(client.nextAttempt).To(BeTemporally("~", time.Now().Add(50*time.Millisecond), 10*time.Millisecond))
+			client.onAttempt(time.Now(), true)
+			Expect(client.backoff).To(BeZero())
+			Expect(client.nextAttempt).To(BeZero())
+		})
+
+		It("increases the delay only once for concurrent attempts", func() {
+			client.MinBackoff = 10 * time.Millisecond
+			start := time.Now()
+			time.Sleep(time.Millisecond)
+			client.onAttempt(start, false)
+			client.onAttempt(start, false)
+			Expect(client.backoff).To(Equal(10 * time.Millisecond))
+		})
+
+		It("gives up when the context is done", func() {
+			qkServer.Close()
+			client.MinBackoff = 10 * time.Millisecond
+			qkClient.QuicConfig = &quic.Config{HandshakeTimeout: 50 * time.Millisecond}
+			ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+			defer cancel()
+			_, err := client.openStream(ctx, RouteTCP, "localhost:1")
+			Expect(err).To(MatchError(context.DeadlineExceeded))
+			Expect(client.backoff).ToNot(BeZero())
+		})
+	})
+})
@@ -0,0 +1,185 @@
+package tunnel
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"syscall"
+	"time"
+
+	quic "github.com/wheelcomplex/qk"
+	"github.com/wheelcomplex/qk/qk"
+)
+
+// defaultDialTimeout is the default value of Server.DialTimeout
+const defaultDialTimeout = 10 * time.Second
+
+var errNoClientCertificate = errors.New("tunnel: no client certificate")
+
+// A Server is the remote side of tunnels.
+// It serves the streams opened by Clients on a qk.Server, and forwards them to their targets.
+type Server struct {
+	// Authorize specifies an optional function that decides if a stream on sess may be forwarded to the target.
+	// The network is "tcp" or "udp".
+	// If it returns an error, the stream is reset with ErrorCodeForbidden.
+	// If nil, all targets are allowed: unless the qk.Server requires client certificates,
+	// any peer can then use the Server as an open relay to every host it can reach.
+	Authorize func(sess quic.Session, network, target string) error
+
+	// DialTimeout is the maximum amount of time a dial to a target will wait for a connect to complete.
+	// If zero, a timeout of 10 seconds is used.
+	DialTimeout time.Duration
+
+	// Dial specifies an optional dial function for connecting to targets.
+	// If Dial is nil, a net.Dialer is used.
+	Dial func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	server *qk.Server
+}
+
+// NewServer creates a Server that registers the routes RouteTCP and RouteUDP on srv.
+// If a handler already exists for one of the routes, NewServer panics.
+func NewServer(srv *qk.Server) *Server {
+	s := &Server{server: srv}
+	srv.HandleFunc(RouteTCP, s.serveTCP)
+	srv.HandleFunc(RouteUDP, s.serveUDP)
+	return s
+}
+
+func (s *Server) serveTCP(str *qk.Stream) {
+	br, conn, ok := s.dialTarget(str, "tcp")
+	if !ok {
+		return
+	}
+	// The request header was read using a bufio.Reader, which might have read ahead.
+	pipe(conn, &bufferedStream{Stream: str, r: br}, new(int64), new(int64))
+}
+
+func (s *Server) serveUDP(str *qk.Stream) {
+	br, conn, ok := s.dialTarget(str, "udp")
+	if !ok {
+		return
+	}
+	defer conn.Close()
+
+	errChan := make(chan error, 2)
+	go func() {
+		for {
+			p, err := readDatagram(br)
+			if err != nil {
+				if err == io.EOF {
+					err = nil
+				}
+				errChan <- err
+				return
+			}
+			// Errors are ignored, as with any UDP socket: the datagram is lost.
+			conn.Write(p)
+		}
+	}()
+	go func() {
+		b := make([]byte, MaxDatagramSize)
+		for {
+			n, err := conn.Read(b)
+			if err != nil {
+				// A datagram that was sent to a closed port is answered by an ICMP message,
+				// which makes the next read fail.
+				if errors.Is(err, syscall.ECONNREFUSED) {
+					continue
+				}
+				errChan <- err
+				return
+			}
+			if err := writeDatagram(str, b[:n]); err != nil {
+				errChan <- err
+				return
+			}
+		}
+	}()
+	// The flow ends when the client closes the stream, or when either side fails.
+	err := <-errChan
+	code := ErrorCodeConnectionReset
+	if err == nil {
+		code = qk.ErrorCodeNoError
+	} else if err == errDatagramTooLarge {
+		code = ErrorCodeInvalidRequest
+	}
+	str.CancelRead(code)
+	if err == nil {
+		str.Close()
+	} else {
+		str.CancelWrite(code)
+	}
+}
+
+// dialTarget reads the request header of the stream, and dials its target.
+// On failure, the stream is reset, and ok is false.
+func (s *Server) dialTarget(str *qk.Stream, network string) (*bufio.Reader, net.Conn, bool) {
+	reset := func(code quic.ErrorCode) {
+		str.CancelRead(code)
+		str.CancelWrite(code)
+	}
+	br := bufio.NewReader(str)
+	target, err := readRequestHeader(br)
+	if err != nil {
+		reset(ErrorCodeInvalidRequest)
+		return nil, nil, false
+	}
+	if err := s.authorize(str.Session(), network, target); err != nil {
+		reset(ErrorCodeForbidden)
+		return nil, nil, false
+	}
+	ctx, cancel := context.WithTimeout(str.Session().Context(), s.dialTimeout())
+	defer cancel()
+	conn, err := s.dial(ctx, network, target)
+	if err != nil {
+		reset(ErrorCodeDialFailed)
+		return nil, nil, false
+	}
+	return br, conn, true
+}
+
+func (s *Server) authorize(sess quic.Session, network, target string) error {
+	if tlsConf := s.server.TLSConfig; tlsConf != nil && requiresClientCert(tlsConf.ClientAuth) {
+		// gQUIC doesn't support client certificates, so the handshake succeeds without one.
+		if len(sess.ConnectionState().PeerCertificates) == 0 {
+			return errNoClientCertificate
+		}
+	}
+	if s.Authorize != nil {
+		return s.Authorize(sess, network, target)
+	}
+	return nil
+}
+
+func requiresClientCert(auth tls.ClientAuthType) bool {
+	return auth == tls.RequireAnyClientCert || auth == tls.RequireAndVerifyClientCert
+}
+
+func (s *Server) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	if s.Dial != nil {
+		return s.Dial(ctx, network, addr)
+	}
+	var d net.Dialer
+	return d.DialContext(ctx, network, addr)
+}
+
+func (s *Server) dialTimeout() time.Duration {
+	if s.DialTimeout <= 0 {
+		return defaultDialTimeout
+	}
+	return s.DialTimeout
+}
+
+// A bufferedStream is a stream that reads from a bufio.Reader
+type bufferedStream struct {
+	quic.Stream
+	r io.Reader
+}
+
+func (s *bufferedStream) Read(p []byte) (int, error) {
+	return s.r.Read(p)
+}
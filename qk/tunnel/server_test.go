@@ -0,0 +1,185 @@
+package tunnel
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"time"
+
+	quic "github.com/wheelcomplex/qk"
+	"github.com/wheelcomplex/qk/internal/testdata"
+	"github.com/wheelcomplex/qk/qk"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// generateClientCertificate generates a self-signed client certificate
+func generateClientCertificate() (tls.Certificate, *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ExpectWithOffset(1, err).ToNot(HaveOccurred())
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "tunnel client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	ExpectWithOffset(1, err).ToNot(HaveOccurred())
+	cert, err := x509.ParseCertificate(der)
+	ExpectWithOffset(1, err).ToNot(HaveOccurred())
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, cert
+}
+
+var _ = Describe("Server", func() {
+	var (
+		qkServer *qk.Server
+		server   *Server
+		qkClient *qk.Client
+		conn     net.PacketConn
+		addr     string
+		target   net.Listener
+	)
+
+	BeforeEach(func() {
+		qkServer = &qk.Server{TLSConfig: testdata.GetTLSConfig()}
+		server = NewServer(qkServer)
+		var err error
+		conn, err = net.ListenPacket("udp", "localhost:0")
+		Expect(err).ToNot(HaveOccurred())
+		addr = conn.LocalAddr().String()
+		qkClient = &qk.Client{TLSConfig: &tls.Config{InsecureSkipVerify: true}}
+		target = runTCPEchoServer()
+	})
+
+	JustBeforeEach(func() {
+		go qkServer.Serve(conn)
+	})
+
+	AfterEach(func() {
+		Expect(qkClient.Close()).To(Succeed())
+		Expect(qkServer.Close()).To(Succeed())
+		conn.Close()
+		target.Close()
+	})
+
+	// expectReset opens a stream with the given request header, and checks the error code that the stream is reset with
+	expectReset := func(route, target string, code quic.ErrorCode) {
+		str, err := qkClient.OpenStream(addr, route)
+		ExpectWithOffset(1, err).ToNot(HaveOccurred())
+		ExpectWithOffset(1, writeRequestHeader(str, target)).To(Succeed())
+		_, err = str.Read([]byte{0})
+		ExpectWithOffset(1, err).To(HaveOccurred())
+		serr, ok := err.(quic.StreamError)
+		ExpectWithOffset(1, ok).To(BeTrue())
+		ExpectWithOffset(1, serr.ErrorCode()).To(Equal(code))
+	}
+
+	It("forwards streams to the target", func() {
+		str, err := qkClient.OpenStream(addr, RouteTCP)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(writeRequestHeader(str, target.Addr().String())).To(Succeed())
+		_, err = str.Write([]byte("foobar"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(str.Close()).To(Succeed())
+		data, err := ioutil.ReadAll(str)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(data).To(Equal([]byte("foobar")))
+	})
+
+	It("resets streams with an invalid request header", func() {
+		str, err := qkClient.OpenStream(addr, RouteUDP)
+		Expect(err).ToNot(HaveOccurred())
+		b := bufio.NewWriter(str)
+		b.Write([]byte{0x40 | 0x3f, 0xff}) // a target length of 16383
+		b.Flush()
+		_, err = str.Read([]byte{0})
+		Expect(err).To(HaveOccurred())
+		Expect(err.(quic.StreamError).ErrorCode()).To(Equal(ErrorCodeInvalidRequest))
+	})
+
+	It("resets streams if the target can't be dialed", func() {
+		server.Dial = func(_ context.Context, network, addr string) (net.Conn, error) {
+			Expect(network).To(Equal("tcp"))
+			Expect(addr).To(Equal("foo.invalid:1234"))
+			return nil, errors.New("dial failed")
+		}
+		expectReset(RouteTCP, "foo.invalid:1234", ErrorCodeDialFailed)
+	})
+
+	It("resets streams to targets that are not authorized", func() {
+		server.Authorize = func(sess quic.Session, network, target string) error {
+			Expect(sess).ToNot(BeNil())
+			if network == "udp" {
+				return errors.New("forbidden")
+			}
+			return nil
+		}
+		expectReset(RouteUDP, "localhost:53", ErrorCodeForbidden)
+	})
+
+	Context("mutual authentication", func() {
+		var clientCert tls.Certificate
+
+		BeforeEach(func() {
+			var cert *x509.Certificate
+			clientCert, cert = generateClientCertificate()
+			pool := x509.NewCertPool()
+			pool.AddCert(cert)
+			qkServer.TLSConfig.ClientAuth = tls.RequireAndVerifyClientCert
+			qkServer.TLSConfig.ClientCAs = pool
+			// mint doesn't support verifying client certificates
+			qkServer.QuicConfig = &quic.Config{
+				Versions:     []quic.VersionNumber{quic.VersionQUIC1, quic.VersionGQUIC43},
+				UseCryptoTLS: true,
+			}
+		})
+
+		It("forwards streams of clients with a certificate", func() {
+			qkClient.TLSConfig.Certificates = []tls.Certificate{clientCert}
+			qkClient.QuicConfig = &quic.Config{Versions: []quic.VersionNumber{quic.VersionQUIC1}}
+			tun, err := NewClient(qkClient, addr).ListenTCP("localhost:0", target.Addr().String())
+			Expect(err).ToNot(HaveOccurred())
+			defer tun.Close()
+			conn, err := net.Dial("tcp", tun.Addr().String())
+			Expect(err).ToNot(HaveOccurred())
+			defer conn.Close()
+			_, err = conn.Write([]byte("foobar"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(conn.(*net.TCPConn).CloseWrite()).To(Succeed())
+			data, err := ioutil.ReadAll(conn)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(data).To(Equal([]byte("foobar")))
+		})
+
+		It("rejects clients without a certificate", func() {
+			qkClient.QuicConfig = &quic.Config{Versions: []quic.VersionNumber{quic.VersionQUIC1}}
+			// With TLS 1.3, the client completes the handshake before the server verifies its certificate.
+			// The server then closes the session.
+			str, err := qkClient.OpenStream(addr, RouteTCP)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(writeRequestHeader(str, target.Addr().String())).To(Succeed())
+			_, err = str.Read([]byte{0})
+			Expect(err).To(HaveOccurred())
+			Eventually(str.Session().Context().Done()).Should(BeClosed())
+		})
+
+		It("resets streams of gQUIC sessions, which can't use client certificates", func() {
+			expectReset(RouteTCP, target.Addr().String(), ErrorCodeForbidden)
+		})
+	})
+})
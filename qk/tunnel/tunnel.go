@@ -0,0 +1,196 @@
+// Package tunnel forwards TCP connections and UDP flows over QUIC, using the qk package.
+//
+// The Client runs on the local side. It listens on local TCP and UDP ports, and carries every accepted
+// TCP connection, and every UDP flow (the datagrams exchanged with a single local address), on its own QUIC stream
+// to the Server on the remote side. The Server dials the target of the tunnel, and copies data in both directions.
+//
+// Every stream starts with a request header containing the target address, on the route RouteTCP or RouteUDP.
+// A TCP connection is carried as a plain byte stream: closing the write direction of the TCP connection closes the
+// stream for writing, and a TCP connection that is aborted resets the stream with ErrorCodeConnectionReset.
+// UDP datagrams are framed by a length prefix.
+//
+// The Client dials the session to the Server when needed, and redials it with exponential backoff when it fails.
+// Peers are authenticated by the TLS configurations of the qk.Client and qk.Server.
+// If the tls.Config of the Server requires a client certificate, streams on sessions without a client certificate
+// are reset with ErrorCodeForbidden. Client certificates are only supported by the IETF QUIC versions,
+// and verifying them (tls.RequireAndVerifyClientCert) requires the UseCryptoTLS option of the server's quic.Config.
+package tunnel
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync/atomic"
+
+	quic "github.com/wheelcomplex/qk"
+	"github.com/wheelcomplex/qk/internal/utils"
+)
+
+// The routes of the streams carrying TCP connections and UDP flows
+const (
+	RouteTCP = "tunnel/tcp"
+	RouteUDP = "tunnel/udp"
+)
+
+// The error codes used to reset streams.
+// They don't overlap with the error codes used by the qk package.
+const (
+	// ErrorCodeConnectionReset is used when a TCP connection was aborted, or a UDP socket failed
+	ErrorCodeConnectionReset quic.ErrorCode = 0x20
+	// ErrorCodeDialFailed is used when the Server couldn't dial the target
+	ErrorCodeDialFailed quic.ErrorCode = 0x21
+	// ErrorCodeForbidden is used when the Server doesn't allow forwarding to the target
+	ErrorCodeForbidden quic.ErrorCode = 0x22
+	// ErrorCodeInvalidRequest is used when the request header or a datagram couldn't be read
+	ErrorCodeInvalidRequest quic.ErrorCode = 0x23
+)
+
+// maxTargetLength is the maximum length of a target address
+const maxTargetLength = 1024
+
+// MaxDatagramSize is the maximum size of a UDP datagram carried by the tunnel
+const MaxDatagramSize = 65535
+
+var (
+	errTargetTooLong    = fmt.Errorf("tunnel: target longer than %d bytes", maxTargetLength)
+	errDatagramTooLarge = fmt.Errorf("tunnel: datagram larger than %d bytes", MaxDatagramSize)
+)
+
+// ErrTunnelClosed is returned by Tunnel.Err after a call to Close.
+var ErrTunnelClosed = errors.New("tunnel: Tunnel closed")
+
+// writeRequestHeader writes the request header.
+// It consists of the length of the target address, encoded as a QUIC varint, followed by the target address.
+func writeRequestHeader(w io.Writer, target string) error {
+	if len(target) > maxTargetLength {
+		return errTargetTooLong
+	}
+	b := &bytes.Buffer{}
+	utils.WriteVarInt(b, uint64(len(target)))
+	b.WriteString(target)
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// readRequestHeader reads the request header, and returns the target address
+func readRequestHeader(r *bufio.Reader) (string, error) {
+	l, err := utils.ReadVarInt(r)
+	if err != nil {
+		if err == io.EOF {
+			return "", io.ErrUnexpectedEOF
+		}
+		return "", err
+	}
+	if l > maxTargetLength {
+		return "", errTargetTooLong
+	}
+	target := make([]byte, l)
+	if _, err := io.ReadFull(r, target); err != nil {
+		if err == io.EOF {
+			return "", io.ErrUnexpectedEOF
+		}
+		return "", err
+	}
+	return string(target), nil
+}
+
+// writeDatagram writes a datagram.
+// It consists of the length of the datagram, encoded as a QUIC varint, followed by the datagram.
+func writeDatagram(w io.Writer, p []byte) error {
+	if len(p) > MaxDatagramSize {
+		return errDatagramTooLarge
+	}
+	b := &bytes.Buffer{}
+	utils.WriteVarInt(b, uint64(len(p)))
+	b.Write(p)
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// readDatagram reads a datagram.
+// It returns io.EOF if the stream ended before the datagram, and io.ErrUnexpectedEOF if it ended within the datagram.
+func readDatagram(r *bufio.Reader) ([]byte, error) {
+	l, err := utils.ReadVarInt(r)
+	if err != nil {
+		return nil, err
+	}
+	if l > MaxDatagramSize {
+		return nil, errDatagramTooLarge
+	}
+	p := make([]byte, l)
+	if _, err := io.ReadFull(r, p); err != nil {
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return p, nil
+}
+
+// pipe copies data between a TCP connection and a stream in both directions.
+// When one direction ends, the corresponding direction of the other side is closed.
+// If copying fails in either direction, the stream is reset and the TCP connection is aborted.
+// It returns when both directions ended, and closes the TCP connection.
+func pipe(conn net.Conn, str quic.Stream, sent, received *int64) error {
+	errChan := make(chan error, 2)
+	go func() {
+		_, err := io.Copy(&countingWriter{Writer: str, n: sent}, conn)
+		if err == nil {
+			err = str.Close()
+		}
+		errChan <- err
+	}()
+	go func() {
+		_, err := io.Copy(&countingWriter{Writer: conn, n: received}, str)
+		if err == nil {
+			err = closeWrite(conn)
+		}
+		errChan <- err
+	}()
+
+	var firstErr error
+	for i := 0; i < 2; i++ {
+		if err := <-errChan; err != nil && firstErr == nil {
+			firstErr = err
+			str.CancelRead(ErrorCodeConnectionReset)
+			str.CancelWrite(ErrorCodeConnectionReset)
+			abort(conn)
+		}
+	}
+	if firstErr == nil {
+		conn.Close()
+	}
+	return firstErr
+}
+
+// closeWrite closes the write direction of the connection, if it supports half-closing it
+func closeWrite(conn net.Conn) error {
+	if c, ok := conn.(interface{ CloseWrite() error }); ok {
+		return c.CloseWrite()
+	}
+	return nil
+}
+
+// abort closes the connection.
+// For a TCP connection, unsent data is discarded, and a RST is sent to the peer.
+func abort(conn net.Conn) {
+	if c, ok := conn.(*net.TCPConn); ok {
+		c.SetLinger(0)
+	}
+	conn.Close()
+}
+
+// A countingWriter counts the bytes written to the underlying writer
+type countingWriter struct {
+	io.Writer
+	n *int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	atomic.AddInt64(w.n, int64(n))
+	return n, err
+}
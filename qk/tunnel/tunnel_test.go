@@ -0,0 +1,109 @@
+package tunnel
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+
+	"github.com/wheelcomplex/qk/internal/utils"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Framing", func() {
+	Context("request header", func() {
+		It("writes and reads the request header", func() {
+			b := &bytes.Buffer{}
+			Expect(writeRequestHeader(b, "example.com:443")).To(Succeed())
+			b.WriteString("foobar")
+			r := bufio.NewReader(b)
+			target, err := readRequestHeader(r)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(target).To(Equal("example.com:443"))
+			data, err := ioutil.ReadAll(r)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(data).To(Equal([]byte("foobar")))
+		})
+
+		It("refuses to write targets that are too long", func() {
+			Expect(writeRequestHeader(&bytes.Buffer{}, string(make([]byte, maxTargetLength+1)))).To(MatchError(errTargetTooLong))
+		})
+
+		It("errors on targets that are too long", func() {
+			b := &bytes.Buffer{}
+			utils.WriteVarInt(b, maxTargetLength+1)
+			_, err := readRequestHeader(bufio.NewReader(b))
+			Expect(err).To(MatchError(errTargetTooLong))
+		})
+
+		It("errors on incomplete headers", func() {
+			b := &bytes.Buffer{}
+			Expect(writeRequestHeader(b, "localhost:80")).To(Succeed())
+			data := b.Bytes()
+			for i := 0; i < len(data); i++ {
+				_, err := readRequestHeader(bufio.NewReader(bytes.NewReader(data[:i])))
+				Expect(err).To(MatchError(io.ErrUnexpectedEOF))
+			}
+		})
+	})
+
+	Context("datagrams", func() {
+		It("writes and reads datagrams", func() {
+			b := &bytes.Buffer{}
+			Expect(writeDatagram(b, []byte("foo"))).To(Succeed())
+			Expect(writeDatagram(b, nil)).To(Succeed())
+			Expect(writeDatagram(b, make([]byte, MaxDatagramSize))).To(Succeed())
+			r := bufio.NewReader(b)
+			p, err := readDatagram(r)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(p).To(Equal([]byte("foo")))
+			p, err = readDatagram(r)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(p).To(BeEmpty())
+			p, err = readDatagram(r)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(p).To(HaveLen(MaxDatagramSize))
+			_, err = readDatagram(r)
+			Expect(err).To(Equal(io.EOF))
+		})
+
+		It("refuses to write datagrams that are too large", func() {
+			Expect(writeDatagram(&bytes.Buffer{}, make([]byte, MaxDatagramSize+1))).To(MatchError(errDatagramTooLarge))
+		})
+
+		It("errors on datagrams that are too large", func() {
+			b := &bytes.Buffer{}
+			utils.WriteVarInt(b, MaxDatagramSize+1)
+			_, err := readDatagram(bufio.NewReader(b))
+			Expect(err).To(MatchError(errDatagramTooLarge))
+		})
+
+		It("errors if the stream ends within a datagram", func() {
+			b := &bytes.Buffer{}
+			Expect(writeDatagram(b, []byte("foobar"))).To(Succeed())
+			_, err := readDatagram(bufio.NewReader(bytes.NewReader(b.Bytes()[:4])))
+			Expect(err).To(MatchError(io.ErrUnexpectedEOF))
+		})
+	})
+
+	Context("TCP half-close", func() {
+		It("closes the write direction of TCP connections", func() {
+			ln, err := net.Listen("tcp", "localhost:0")
+			Expect(err).ToNot(HaveOccurred())
+			defer ln.Close()
+			conn, err := net.Dial("tcp", ln.Addr().String())
+			Expect(err).ToNot(HaveOccurred())
+			defer conn.Close()
+			sconn, err := ln.Accept()
+			Expect(err).ToNot(HaveOccurred())
+			defer sconn.Close()
+			Expect(closeWrite(conn)).To(Succeed())
+			data, err := ioutil.ReadAll(sconn)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(data).To(BeEmpty())
+		})
+	})
+})
@@ -0,0 +1,163 @@
+package quic
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/wheelcomplex/qk/internal/handshake"
+	"github.com/wheelcomplex/qk/internal/protocol"
+	"github.com/wheelcomplex/qk/internal/record"
+	"github.com/wheelcomplex/qk/internal/utils"
+)
+
+// A sessionHook is used to record and to replay a QUIC v1 session.
+// It observes the datagrams sent and received by the session,
+// and it can replace the TLS stack and the choice of the packet numbers that are skipped.
+type sessionHook interface {
+	wrapConn(connection) connection
+	wrapTLS(handshake.QUICTLS) handshake.QUICTLS
+	chooseSkip(protocol.EncryptionLevel, protocol.PacketNumber) protocol.PacketNumber
+	receivedDatagram(remoteAddr net.Addr, data []byte)
+	sessionClosed()
+}
+
+// validateRecordWriter checks that the RecordWriter is only used with QUIC v1,
+// since only QUIC v1 sessions can be recorded.
+func validateRecordWriter(config *Config) error {
+	if config.RecordWriter == nil {
+		return nil
+	}
+	for _, v := range config.Versions {
+		if !v.UsesCryptoFrames() {
+			return fmt.Errorf("the RecordWriter can only be used with QUIC v1, but the Versions contain %s", v)
+		}
+	}
+	return nil
+}
+
+// The sessionRecorder records a session to the RecordWriter of the Config.
+type sessionRecorder struct {
+	w      *record.Writer
+	clock  utils.Clock
+	logger utils.Logger
+
+	errOnce sync.Once
+}
+
+var _ sessionHook = &sessionRecorder{}
+
+func newSessionRecorder(w io.Writer, clock utils.Clock, logger utils.Logger, started *record.SessionStarted) (*sessionRecorder, error) {
+	rw, err := record.NewWriter(w)
+	if err != nil {
+		return nil, err
+	}
+	r := &sessionRecorder{
+		w:      rw,
+		clock:  clock,
+		logger: logger,
+	}
+	r.record(clock.Now(), started)
+	return r, nil
+}
+
+// record writes a record.
+// Since the recording shouldn't break the session, errors are only logged.
+func (r *sessionRecorder) record(t time.Time, ev record.Event) {
+	if err := r.w.Write(t, ev); err != nil {
+		r.errOnce.Do(func() {
+			r.logger.Errorf("Recording the session failed: %s", err)
+		})
+	}
+}
+
+func (r *sessionRecorder) wrapConn(c connection) connection {
+	return &recordingConn{connection: c, recorder: r}
+}
+
+func (r *sessionRecorder) wrapTLS(qconn handshake.QUICTLS) handshake.QUICTLS {
+	return &recordingQUICTLS{QUICTLS: qconn, recorder: r}
+}
+
+func (r *sessionRecorder) chooseSkip(encLevel protocol.EncryptionLevel, pn protocol.PacketNumber) protocol.PacketNumber {
+	r.record(r.clock.Now(), &record.SkippedPacketNumber{EncryptionLevel: encLevel, PacketNumber: pn})
+	return pn
+}
+
+// receivedDatagram records a received datagram, before it is parsed.
+// It is called by the packetHandlerMap, so that datagrams containing coalesced packets,
+// and packets that are rejected before they are passed to the session, are recorded as well.
+func (r *sessionRecorder) receivedDatagram(remoteAddr net.Addr, data []byte) {
+	r.record(r.clock.Now(), &record.Datagram{RemoteAddr: addrString(remoteAddr), Data: data})
+}
+
+func (r *sessionRecorder) sessionClosed() {
+	r.w.Close()
+}
+
+// The recordingConn records all datagrams sent on a connection.
+type recordingConn struct {
+	connection
+	recorder *sessionRecorder
+}
+
+func (c *recordingConn) Write(p []byte) error {
+	c.recorder.record(c.recorder.clock.Now(), &record.Datagram{
+		Sent:       true,
+		RemoteAddr: addrString(c.RemoteAddr()),
+		Data:       p,
+	})
+	return c.connection.Write(p)
+}
+
+// The recordingQUICTLS records the input and the output of the TLS stack.
+type recordingQUICTLS struct {
+	handshake.QUICTLS
+	recorder *sessionRecorder
+}
+
+func (t *recordingQUICTLS) HandleData(level int, data []byte) error {
+	err := t.QUICTLS.HandleData(level, data)
+	input := &record.TLSInput{Level: level, Data: data}
+	if err != nil {
+		input.Error = err.Error()
+		input.Alert, _ = handshake.GetTLSAlert(err)
+	}
+	t.recorder.record(t.recorder.clock.Now(), input)
+	return err
+}
+
+func (t *recordingQUICTLS) NextEvent() handshake.QUICTLSEvent {
+	ev := t.QUICTLS.NextEvent()
+	t.recorder.record(t.recorder.clock.Now(), &record.TLSEvent{
+		Kind:  ev.Kind,
+		Level: ev.Level,
+		Suite: ev.Suite,
+		Data:  ev.Data,
+	})
+	return ev
+}
+
+func (t *recordingQUICTLS) ConnectionState() tls.ConnectionState {
+	state := t.QUICTLS.ConnectionState()
+	certs := make([][]byte, len(state.PeerCertificates))
+	for i, cert := range state.PeerCertificates {
+		certs[i] = cert.Raw
+	}
+	t.recorder.record(t.recorder.clock.Now(), &record.TLSConnectionState{
+		ServerName:         state.ServerName,
+		NegotiatedProtocol: state.NegotiatedProtocol,
+		PeerCertificates:   certs,
+	})
+	return state
+}
+
+func addrString(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	return addr.String()
+}
@@ -0,0 +1,189 @@
+package quic
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/wheelcomplex/qk/internal/handshake"
+	"github.com/wheelcomplex/qk/internal/protocol"
+	"github.com/wheelcomplex/qk/internal/record"
+	"github.com/wheelcomplex/qk/internal/utils"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// fakeQUICTLS is a TLS stack that returns preset events
+type fakeQUICTLS struct {
+	events    []handshake.QUICTLSEvent
+	handleErr error
+	state     tls.ConnectionState
+	closed    bool
+}
+
+var _ handshake.QUICTLS = &fakeQUICTLS{}
+
+func (t *fakeQUICTLS) Start() error                         { return nil }
+func (t *fakeQUICTLS) HandleData(int, []byte) error         { return t.handleErr }
+func (t *fakeQUICTLS) SetTransportParameters([]byte)        {}
+func (t *fakeQUICTLS) SendSessionTicket() error             { return nil }
+func (t *fakeQUICTLS) ConnectionState() tls.ConnectionState { return t.state }
+func (t *fakeQUICTLS) Close() error                         { t.closed = true; return nil }
+func (t *fakeQUICTLS) NextEvent() (ev handshake.QUICTLSEvent) {
+	if len(t.events) == 0 {
+		return
+	}
+	ev = t.events[0]
+	t.events = t.events[1:]
+	return
+}
+
+var _ = Describe("Session Recorder", func() {
+	var (
+		buf      *bytes.Buffer
+		clock    *utils.VirtualClock
+		recorder *sessionRecorder
+		started  *record.SessionStarted
+	)
+
+	remoteAddr := &net.UDPAddr{IP: net.IPv4(192, 168, 13, 37), Port: 1234}
+
+	readRecords := func() []*record.Record {
+		r := record.NewReader(bytes.NewReader(buf.Bytes()))
+		var records []*record.Record
+		for {
+			rec, err := r.Read()
+			if err != nil {
+				return records
+			}
+			records = append(records, rec)
+		}
+	}
+
+	BeforeEach(func() {
+		buf = &bytes.Buffer{}
+		clock = utils.NewVirtualClock(time.Unix(1000, 0))
+		started = &record.SessionStarted{
+			Perspective:   protocol.PerspectiveServer,
+			Version:       protocol.Version1,
+			InitialConnID: protocol.ConnectionID{1, 2, 3, 4, 5, 6, 7, 8},
+			RemoteAddr:    remoteAddr.String(),
+		}
+		var err error
+		recorder, err = newSessionRecorder(buf, clock, utils.DefaultLogger, started)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("records the start of the session", func() {
+		records := readRecords()
+		Expect(records).To(HaveLen(1))
+		Expect(records[0].Time).To(Equal(time.Unix(1000, 0)))
+		Expect(records[0].Event).To(Equal(started))
+	})
+
+	It("records sent datagrams", func() {
+		mconn := newMockConnection()
+		mconn.remoteAddr = remoteAddr
+		conn := recorder.wrapConn(mconn)
+		clock.Advance(time.Second)
+		Expect(conn.Write([]byte("foobar"))).To(Succeed())
+		Expect(mconn.written).To(Receive(Equal([]byte("foobar"))))
+		records := readRecords()
+		Expect(records).To(HaveLen(2))
+		Expect(records[1].Time).To(Equal(time.Unix(1001, 0)))
+		Expect(records[1].Event).To(Equal(&record.Datagram{
+			Sent:       true,
+			RemoteAddr: remoteAddr.String(),
+			Data:       []byte("foobar"),
+		}))
+	})
+
+	It("records received datagrams", func() {
+		clock.Advance(2 * time.Second)
+		recorder.receivedDatagram(remoteAddr, []byte("headerpayload"))
+		records := readRecords()
+		Expect(records).To(HaveLen(2))
+		Expect(records[1].Time).To(Equal(time.Unix(1002, 0)))
+		Expect(records[1].Event).To(Equal(&record.Datagram{
+			RemoteAddr: remoteAddr.String(),
+			Data:       []byte("headerpayload"),
+		}))
+	})
+
+	It("stops recording when the session is closed", func() {
+		recorder.sessionClosed()
+		recorder.receivedDatagram(remoteAddr, []byte("foobar"))
+		Expect(readRecords()).To(HaveLen(1))
+	})
+
+	It("records skipped packet numbers", func() {
+		Expect(recorder.chooseSkip(protocol.EncryptionHandshake, 42)).To(Equal(protocol.PacketNumber(42)))
+		records := readRecords()
+		Expect(records).To(HaveLen(2))
+		Expect(records[1].Event).To(Equal(&record.SkippedPacketNumber{
+			EncryptionLevel: protocol.EncryptionHandshake,
+			PacketNumber:    42,
+		}))
+	})
+
+	It("records the input and the output of the TLS stack", func() {
+		fake := &fakeQUICTLS{
+			events:    []handshake.QUICTLSEvent{{Kind: 1, Level: 2, Suite: 0x1301, Data: []byte("secret")}},
+			handleErr: handshake.NewTLSAlertError(42),
+			state:     tls.ConnectionState{ServerName: "quic.clemente.io", NegotiatedProtocol: "h3"},
+		}
+		qconn := recorder.wrapTLS(fake)
+		err := qconn.HandleData(2, []byte("message"))
+		Expect(err).To(HaveOccurred())
+		Expect(qconn.NextEvent()).To(Equal(handshake.QUICTLSEvent{Kind: 1, Level: 2, Suite: 0x1301, Data: []byte("secret")}))
+		Expect(qconn.NextEvent()).To(Equal(handshake.QUICTLSEvent{}))
+		Expect(qconn.ConnectionState().ServerName).To(Equal("quic.clemente.io"))
+		Expect(qconn.Close()).To(Succeed())
+		Expect(fake.closed).To(BeTrue())
+		records := readRecords()
+		Expect(records).To(HaveLen(5))
+		Expect(records[1].Event).To(Equal(&record.TLSInput{Level: 2, Data: []byte("message"), Alert: 42, Error: err.Error()}))
+		Expect(records[2].Event).To(Equal(&record.TLSEvent{Kind: 1, Level: 2, Suite: 0x1301, Data: []byte("secret")}))
+		Expect(records[3].Event).To(Equal(&record.TLSEvent{}))
+		Expect(records[4].Event).To(Equal(&record.TLSConnectionState{
+			ServerName:         "quic.clemente.io",
+			NegotiatedProtocol: "h3",
+			PeerCertificates:   [][]byte{},
+		}))
+	})
+
+	It("doesn't break the session when recording fails", func() {
+		recorder.w, _ = record.NewWriter(&errorWriter{})
+		mconn := newMockConnection()
+		Expect(recorder.wrapConn(mconn).Write([]byte("foobar"))).To(Succeed())
+		Expect(mconn.written).To(Receive())
+	})
+})
+
+var _ = Describe("Validating the RecordWriter", func() {
+	It("accepts a Config without a RecordWriter", func() {
+		Expect(validateRecordWriter(&Config{Versions: []protocol.VersionNumber{protocol.Version44}})).To(Succeed())
+	})
+
+	It("accepts QUIC v1", func() {
+		Expect(validateRecordWriter(&Config{
+			Versions:     []protocol.VersionNumber{protocol.Version1},
+			RecordWriter: &bytes.Buffer{},
+		})).To(Succeed())
+	})
+
+	It("refuses other versions", func() {
+		err := validateRecordWriter(&Config{
+			Versions:     []protocol.VersionNumber{protocol.Version1, protocol.Version44},
+			RecordWriter: &bytes.Buffer{},
+		})
+		Expect(err).To(MatchError("the RecordWriter can only be used with QUIC v1, but the Versions contain gQUIC 44"))
+	})
+})
+
+type errorWriter struct{}
+
+func (errorWriter) Write([]byte) (int, error) { return 0, errors.New("write failed") }
@@ -0,0 +1,402 @@
+package quic
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/wheelcomplex/qk/internal/handshake"
+	"github.com/wheelcomplex/qk/internal/protocol"
+	"github.com/wheelcomplex/qk/internal/record"
+	"github.com/wheelcomplex/qk/internal/utils"
+	"github.com/wheelcomplex/qk/internal/wire"
+)
+
+// replayIdleTime is the (real) time the clock waits for the replayed session to become idle, before advancing it
+const replayIdleTime = 50 * time.Microsecond
+
+// A ReplayedDatagram is a datagram sent by a recorded or by a replayed session.
+type ReplayedDatagram struct {
+	Time       time.Time
+	RemoteAddr string
+	Data       []byte
+}
+
+// A Replay replays a QUIC v1 session that was recorded using the RecordWriter of the Config.
+//
+// The replayed session receives the recorded datagrams at the times they were received by the recorded session.
+// It runs in virtual time, starting at the time the recorded session was created.
+// Instead of running a TLS handshake, it uses the output of the TLS stack of the recorded session,
+// and it skips the same packet numbers.
+// As long as the replayed session behaves like the recorded one, it therefore derives the same keys,
+// and is able to process the recorded datagrams.
+// The datagrams sent by the replayed session are not sent anywhere, but they can be compared to the recorded ones.
+type Replay struct {
+	clock   *utils.VirtualClock
+	session quicSession
+	conn    *replayConn
+	logger  utils.Logger
+	// serverSession filters the packets like the server does, if a server session is replayed
+	serverSession packetHandler
+
+	perspective protocol.Perspective
+	version     protocol.VersionNumber
+	srcConnID   protocol.ConnectionID
+
+	received     []*record.Record
+	recordedSent []ReplayedDatagram
+}
+
+// NewReplay reads a recording, and creates a session replaying the n-th session of the recording, counting from 0.
+// The config should be the one that was used by the recorded session. Its Clock and RecordWriter are ignored.
+func NewReplay(r io.Reader, n int, config *Config) (*Replay, error) {
+	records, err := record.ReadSession(r, n)
+	if err != nil {
+		return nil, err
+	}
+	started := records[0].Event.(*record.SessionStarted)
+	if !started.Version.UsesCryptoFrames() {
+		return nil, fmt.Errorf("replay: can't replay sessions using %s", started.Version)
+	}
+
+	clock := utils.NewVirtualClock(records[0].Time)
+	var conf *Config
+	if started.Perspective == protocol.PerspectiveServer {
+		conf = populateServerConfig(config)
+	} else {
+		conf = populateClientConfig(config, false)
+	}
+	conf.Clock = clock
+	conf.RecordWriter = nil
+
+	replay := &Replay{
+		clock:       clock,
+		perspective: started.Perspective,
+		version:     started.Version,
+		srcConnID:   started.SrcConnID,
+	}
+	hook := &replayHook{skips: make(map[protocol.EncryptionLevel][]protocol.PacketNumber)}
+	for _, rec := range records[1:] {
+		switch ev := rec.Event.(type) {
+		case *record.Datagram:
+			if ev.Sent {
+				replay.recordedSent = append(replay.recordedSent, ReplayedDatagram{
+					Time:       rec.Time,
+					RemoteAddr: ev.RemoteAddr,
+					Data:       ev.Data,
+				})
+			} else {
+				replay.received = append(replay.received, rec)
+			}
+		case *record.SkippedPacketNumber:
+			hook.skips[ev.EncryptionLevel] = append(hook.skips[ev.EncryptionLevel], ev.PacketNumber)
+		case *record.TLSInput, *record.TLSEvent:
+			hook.tls = append(hook.tls, rec.Event)
+		case *record.TLSConnectionState:
+			hook.connState = ev
+		}
+	}
+
+	logger := utils.DefaultLogger.WithPrefix("replay")
+	replay.logger = logger
+	var extHandler handshake.TLSExtensionHandler
+	if started.Perspective == protocol.PerspectiveServer {
+		extHandler = handshake.NewExtensionHandlerServerV1(&handshake.TransportParameters{}, logger)
+	} else {
+		extHandler = handshake.NewExtensionHandlerClientV1(&handshake.TransportParameters{}, started.OrigDestConnID, started.RetrySrcConnID, logger)
+	}
+	replay.conn = &replayConn{
+		clock:      clock,
+		localAddr:  parseReplayAddr(started.LocalAddr),
+		remoteAddr: parseReplayAddr(started.RemoteAddr),
+	}
+	runner := &runner{
+		onHandshakeCompleteImpl: func(Session) {},
+		removeConnectionIDImpl:  func(protocol.ConnectionID) {},
+	}
+	replay.session, err = newSessionV1(
+		replay.conn,
+		runner,
+		started.InitialConnID,
+		started.DestConnID,
+		started.SrcConnID,
+		started.Token,
+		conf,
+		&tls.Config{},
+		extHandler,
+		started.Perspective,
+		logger,
+		started.Version,
+		hook,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if started.Perspective == protocol.PerspectiveServer {
+		replay.serverSession = newServerSession(replay.session, conf, logger)
+	}
+	return replay, nil
+}
+
+// Session returns the replayed session.
+// It can be used like the application used the recorded session, e.g. to accept streams and read from them.
+func (r *Replay) Session() Session {
+	return r.session
+}
+
+// Run replays the session.
+// It returns when the session is closed, with the error that closed the session.
+// After the last recorded datagram, the session keeps running in virtual time until it is closed,
+// e.g. by the application, or by the idle timeout.
+func (r *Replay) Run() error {
+	stop := r.clock.AutoAdvance(replayIdleTime)
+	defer stop()
+	r.scheduleNext(0)
+	return r.session.run()
+}
+
+// scheduleNext schedules the delivery of the i-th received datagram.
+// Every datagram schedules the next one, so that datagrams received at the same time are delivered in order.
+func (r *Replay) scheduleNext(i int) {
+	if i >= len(r.received) {
+		return
+	}
+	rec := r.received[i]
+	r.clock.AfterFunc(rec.Time.Sub(r.clock.Now()), func() {
+		r.deliver(rec, i == 0)
+		r.scheduleNext(i + 1)
+	})
+}
+
+// deliver passes a recorded datagram to the session.
+// The recording contains all datagrams received for the session, so the datagram is processed
+// like the packetHandlerMap, and the server or the client would have processed it.
+// The first datagram of a server session created the session, and is passed to it directly.
+func (r *Replay) deliver(rec *record.Record, first bool) {
+	datagram := rec.Event.(*record.Datagram)
+	if len(datagram.Data) > int(protocol.MaxReceivePacketSize) {
+		r.session.destroy(fmt.Errorf("replay: datagram too large (%d bytes)", len(datagram.Data)))
+		return
+	}
+	// The session returns the packet to the buffer pool after processing it.
+	data := (*getPacketBuffer())[:len(datagram.Data)]
+	copy(data, datagram.Data)
+	rd := bytes.NewReader(data)
+	iHdr, err := wire.ParseInvariantHeader(rd, len(r.srcConnID))
+	if err != nil {
+		r.logger.Debugf("Dropping recorded datagram: error parsing invariant header: %s", err)
+		return
+	}
+	hdr, packetData, err := parsePacket(iHdr, rd, data, r.perspective.Opposite(), r.version)
+	if err != nil {
+		r.logger.Debugf("Dropping recorded datagram: %s", err)
+		return
+	}
+	p := &receivedPacket{
+		remoteAddr: parseReplayAddr(datagram.RemoteAddr),
+		header:     hdr,
+		data:       packetData,
+	}
+	if r.serverSession != nil {
+		if first {
+			r.session.handlePacket(p)
+		} else {
+			r.serverSession.handlePacket(p)
+		}
+		return
+	}
+	// Version Negotiation and Retry packets are handled by the client, and not by the session.
+	if hdr.IsVersionNegotiation || (hdr.IsLongHeader && hdr.Type == protocol.PacketTypeRetry) {
+		r.logger.Debugf("Dropping recorded Version Negotiation or Retry packet")
+		return
+	}
+	if !hdr.DestConnectionID.Equal(r.srcConnID) {
+		r.logger.Debugf("Dropping recorded packet with an unexpected connection ID (%s, expected %s)", hdr.DestConnectionID, r.srcConnID)
+		return
+	}
+	r.session.handlePacket(p)
+}
+
+// Sent returns the datagrams sent by the replayed session.
+func (r *Replay) Sent() []ReplayedDatagram {
+	return r.conn.getSent()
+}
+
+// RecordedSent returns the datagrams sent by the recorded session.
+func (r *Replay) RecordedSent() []ReplayedDatagram {
+	return r.recordedSent
+}
+
+// The replayHook replaces the TLS stack and the choice of skipped packet numbers by the recording.
+type replayHook struct {
+	tls       []record.Event
+	connState *record.TLSConnectionState
+
+	mutex sync.Mutex
+	skips map[protocol.EncryptionLevel][]protocol.PacketNumber
+}
+
+var _ sessionHook = &replayHook{}
+
+func (h *replayHook) wrapConn(c connection) connection { return c }
+
+func (h *replayHook) wrapTLS(qconn handshake.QUICTLS) handshake.QUICTLS {
+	return &replayedQUICTLS{
+		wrapped:   qconn,
+		records:   h.tls,
+		connState: h.connState,
+	}
+}
+
+func (h *replayHook) chooseSkip(encLevel protocol.EncryptionLevel, pn protocol.PacketNumber) protocol.PacketNumber {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	skips := h.skips[encLevel]
+	if len(skips) == 0 {
+		return pn
+	}
+	h.skips[encLevel] = skips[1:]
+	return skips[0]
+}
+
+func (h *replayHook) receivedDatagram(net.Addr, []byte) {}
+
+func (h *replayHook) sessionClosed() {}
+
+// The replayedQUICTLS replays the output of the TLS stack of the recorded session.
+// It returns an error if it is passed different handshake messages than the recorded TLS stack.
+type replayedQUICTLS struct {
+	wrapped   handshake.QUICTLS
+	records   []record.Event
+	connState *record.TLSConnectionState
+}
+
+var _ handshake.QUICTLS = &replayedQUICTLS{}
+
+func (t *replayedQUICTLS) Start() error { return nil }
+
+func (t *replayedQUICTLS) HandleData(level int, data []byte) error {
+	if len(t.records) == 0 {
+		return fmt.Errorf("replay: the TLS stack received more data at level %d than during the recording", level)
+	}
+	input, ok := t.records[0].(*record.TLSInput)
+	if !ok || input.Level != level || !bytes.Equal(input.Data, data) {
+		return fmt.Errorf("replay: the TLS stack received different data at level %d than during the recording", level)
+	}
+	t.records = t.records[1:]
+	if input.Error == "" {
+		return nil
+	}
+	if input.Alert != 0 {
+		return handshake.NewTLSAlertError(input.Alert)
+	}
+	return errors.New(input.Error)
+}
+
+func (t *replayedQUICTLS) NextEvent() handshake.QUICTLSEvent {
+	if len(t.records) == 0 {
+		return handshake.QUICTLSEvent{}
+	}
+	ev, ok := t.records[0].(*record.TLSEvent)
+	if !ok {
+		return handshake.QUICTLSEvent{}
+	}
+	t.records = t.records[1:]
+	return handshake.QUICTLSEvent{
+		Kind:  ev.Kind,
+		Level: ev.Level,
+		Data:  ev.Data,
+		Suite: ev.Suite,
+	}
+}
+
+func (t *replayedQUICTLS) SetTransportParameters([]byte) {}
+
+func (t *replayedQUICTLS) SendSessionTicket() error { return nil }
+
+func (t *replayedQUICTLS) ConnectionState() tls.ConnectionState {
+	var state tls.ConnectionState
+	if t.connState == nil {
+		return state
+	}
+	state.HandshakeComplete = true
+	state.ServerName = t.connState.ServerName
+	state.NegotiatedProtocol = t.connState.NegotiatedProtocol
+	for _, der := range t.connState.PeerCertificates {
+		if cert, err := x509.ParseCertificate(der); err == nil {
+			state.PeerCertificates = append(state.PeerCertificates, cert)
+		}
+	}
+	return state
+}
+
+func (t *replayedQUICTLS) Close() error { return t.wrapped.Close() }
+
+// The replayConn is the connection of the replayed session.
+// It keeps the datagrams sent by the session.
+type replayConn struct {
+	clock     utils.Clock
+	localAddr net.Addr
+
+	mutex      sync.Mutex
+	remoteAddr net.Addr
+	sent       []ReplayedDatagram
+}
+
+var _ connection = &replayConn{}
+
+func (c *replayConn) Write(p []byte) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.sent = append(c.sent, ReplayedDatagram{
+		Time:       c.clock.Now(),
+		RemoteAddr: addrString(c.remoteAddr),
+		Data:       append([]byte{}, p...),
+	})
+	return nil
+}
+
+func (c *replayConn) Read([]byte) (int, net.Addr, error) {
+	return 0, nil, errors.New("replay: reading from the connection is not supported")
+}
+
+func (c *replayConn) Close() error { return nil }
+
+func (c *replayConn) LocalAddr() net.Addr { return c.localAddr }
+
+func (c *replayConn) RemoteAddr() net.Addr {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.remoteAddr
+}
+
+func (c *replayConn) SetCurrentRemoteAddr(addr net.Addr) {
+	c.mutex.Lock()
+	c.remoteAddr = addr
+	c.mutex.Unlock()
+}
+
+func (c *replayConn) getSent() []ReplayedDatagram {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return append([]ReplayedDatagram{}, c.sent...)
+}
+
+// A replayAddr is a recorded address that is not a UDP address
+type replayAddr string
+
+func (a replayAddr) Network() string { return "udp" }
+func (a replayAddr) String() string  { return string(a) }
+
+func parseReplayAddr(s string) net.Addr {
+	if addr, err := net.ResolveUDPAddr("udp", s); err == nil {
+		return addr
+	}
+	return replayAddr(s)
+}
@@ -0,0 +1,127 @@
+package quic
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/wheelcomplex/qk/internal/handshake"
+	"github.com/wheelcomplex/qk/internal/protocol"
+	"github.com/wheelcomplex/qk/internal/record"
+	"github.com/wheelcomplex/qk/internal/utils"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Replay", func() {
+	It("errors when the recording doesn't contain the session", func() {
+		_, err := NewReplay(&bytes.Buffer{}, 0, nil)
+		Expect(err).To(MatchError("record: recording contains only 0 sessions"))
+	})
+
+	It("refuses to replay gQUIC sessions", func() {
+		buf := &bytes.Buffer{}
+		w, err := record.NewWriter(buf)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(w.Write(time.Now(), &record.SessionStarted{Version: protocol.Version39})).To(Succeed())
+		_, err = NewReplay(buf, 0, nil)
+		Expect(err).To(MatchError("replay: can't replay sessions using gQUIC 39"))
+	})
+
+	Context("replaying the TLS stack", func() {
+		var (
+			wrapped *fakeQUICTLS
+			qconn   handshake.QUICTLS
+		)
+
+		BeforeEach(func() {
+			wrapped = &fakeQUICTLS{}
+			hook := &replayHook{
+				tls: []record.Event{
+					&record.TLSEvent{Kind: 5, Level: 0, Data: []byte("ClientHello")},
+					&record.TLSEvent{},
+					&record.TLSInput{Level: 0, Data: []byte("ServerHello")},
+					&record.TLSEvent{Kind: 1, Level: 2, Suite: 0x1301, Data: []byte("secret")},
+					&record.TLSEvent{},
+					&record.TLSInput{Level: 2, Data: []byte("Certificate"), Alert: 42, Error: "bad certificate"},
+				},
+				connState: &record.TLSConnectionState{ServerName: "quic.clemente.io", NegotiatedProtocol: "h3"},
+			}
+			qconn = hook.wrapTLS(wrapped)
+		})
+
+		It("returns the recorded events", func() {
+			Expect(qconn.Start()).To(Succeed())
+			Expect(qconn.NextEvent()).To(Equal(handshake.QUICTLSEvent{Kind: 5, Level: 0, Data: []byte("ClientHello")}))
+			Expect(qconn.NextEvent()).To(Equal(handshake.QUICTLSEvent{}))
+			// no more events until the TLS stack is passed the next message
+			Expect(qconn.NextEvent()).To(Equal(handshake.QUICTLSEvent{}))
+			Expect(qconn.HandleData(0, []byte("ServerHello"))).To(Succeed())
+			Expect(qconn.NextEvent()).To(Equal(handshake.QUICTLSEvent{Kind: 1, Level: 2, Suite: 0x1301, Data: []byte("secret")}))
+			Expect(qconn.NextEvent()).To(Equal(handshake.QUICTLSEvent{}))
+		})
+
+		It("returns the recorded errors", func() {
+			qconn.NextEvent()
+			qconn.NextEvent()
+			Expect(qconn.HandleData(0, []byte("ServerHello"))).To(Succeed())
+			qconn.NextEvent()
+			qconn.NextEvent()
+			err := qconn.HandleData(2, []byte("Certificate"))
+			Expect(err).To(HaveOccurred())
+			alert, ok := handshake.GetTLSAlert(err)
+			Expect(ok).To(BeTrue())
+			Expect(alert).To(BeEquivalentTo(42))
+		})
+
+		It("errors when it is passed different data than during the recording", func() {
+			qconn.NextEvent()
+			qconn.NextEvent()
+			Expect(qconn.HandleData(0, []byte("foobar"))).To(MatchError("replay: the TLS stack received different data at level 0 than during the recording"))
+		})
+
+		It("errors when it is passed more data than during the recording", func() {
+			replayed := qconn.(*replayedQUICTLS)
+			replayed.records = nil
+			Expect(qconn.HandleData(3, []byte("foobar"))).To(MatchError("replay: the TLS stack received more data at level 3 than during the recording"))
+		})
+
+		It("returns the recorded connection state", func() {
+			state := qconn.ConnectionState()
+			Expect(state.HandshakeComplete).To(BeTrue())
+			Expect(state.ServerName).To(Equal("quic.clemente.io"))
+			Expect(state.NegotiatedProtocol).To(Equal("h3"))
+		})
+
+		It("closes the wrapped TLS stack", func() {
+			Expect(qconn.Close()).To(Succeed())
+			Expect(wrapped.closed).To(BeTrue())
+		})
+	})
+
+	It("skips the recorded packet numbers", func() {
+		hook := &replayHook{skips: map[protocol.EncryptionLevel][]protocol.PacketNumber{
+			protocol.EncryptionForwardSecure: {10, 20},
+		}}
+		Expect(hook.chooseSkip(protocol.EncryptionForwardSecure, 11)).To(Equal(protocol.PacketNumber(10)))
+		Expect(hook.chooseSkip(protocol.EncryptionInitial, 3)).To(Equal(protocol.PacketNumber(3)))
+		Expect(hook.chooseSkip(protocol.EncryptionForwardSecure, 21)).To(Equal(protocol.PacketNumber(20)))
+		// the recording ends, use the packet number chosen by the session
+		Expect(hook.chooseSkip(protocol.EncryptionForwardSecure, 42)).To(Equal(protocol.PacketNumber(42)))
+	})
+
+	It("keeps the datagrams sent by the replayed session", func() {
+		conn := &replayConn{
+			clock:      utils.NewVirtualClock(time.Unix(1000, 0)),
+			remoteAddr: parseReplayAddr("192.168.13.37:1234"),
+		}
+		data := []byte("foobar")
+		Expect(conn.Write(data)).To(Succeed())
+		data[0] = 'F'
+		Expect(conn.getSent()).To(Equal([]ReplayedDatagram{{
+			Time:       time.Unix(1000, 0),
+			RemoteAddr: "192.168.13.37:1234",
+			Data:       []byte("foobar"),
+		}}))
+	})
+})
@@ -133,6 +133,9 @@ func listen(conn net.PacketConn, tlsConf *tls.Config, config *Config) (*server,
 		return nil, err
 	}
 	config = populateServerConfig(config)
+	if err := validateRecordWriter(config); err != nil {
+		return nil, err
+	}
 
 	var supportsTLS bool
 	for _, v := range config.Versions {
@@ -274,6 +277,10 @@ func populateServerConfig(config *Config) *Config {
 			connIDLen = protocol.ConnectionIDLenGQUIC
 		}
 	}
+	clock := config.Clock
+	if clock == nil {
+		clock = utils.DefaultClock
+	}
 
 	return &Config{
 		Versions:                              versions,
@@ -281,6 +288,10 @@ func populateServerConfig(config *Config) *Config {
 		IdleTimeout:                           idleTimeout,
 		AcceptCookie:                          vsa,
 		KeepAlive:                             config.KeepAlive,
+		KeyUpdateInterval:                     config.KeyUpdateInterval,
+		UseCryptoTLS:                          config.UseCryptoTLS,
+		Clock:                                 clock,
+		RecordWriter:                          config.RecordWriter,
 		MaxReceiveStreamFlowControlWindow:     maxReceiveStreamFlowControlWindow,
 		MaxReceiveConnectionFlowControlWindow: maxReceiveConnectionFlowControlWindow,
 		MaxIncomingStreams:                    maxIncomingStreams,
@@ -408,6 +419,8 @@ func (s *server) sendVersionNegotiationPacket(p *receivedPacket) error {
 	var data []byte
 	if hdr.IsPublicHeader {
 		data = wire.ComposeGQUICVersionNegotiation(hdr.DestConnectionID, s.config.Versions)
+	} else if !hdr.Version.UsesLegacyLongHeaderInvariants() {
+		data = wire.ComposeVersionNegotiationV1(hdr.SrcConnectionID, hdr.DestConnectionID, s.config.Versions)
 	} else {
 		var err error
 		data, err = wire.ComposeVersionNegotiation(hdr.SrcConnectionID, hdr.DestConnectionID, s.config.Versions)
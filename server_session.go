@@ -2,6 +2,7 @@ package quic
 
 import (
 	"fmt"
+	"net"
 
 	"github.com/wheelcomplex/qk/internal/protocol"
 	"github.com/wheelcomplex/qk/internal/utils"
@@ -58,6 +59,12 @@ func (s *serverSession) handlePacketImpl(p *receivedPacket) error {
 	return nil
 }
 
+func (s *serverSession) recordDatagram(remoteAddr net.Addr, data []byte) {
+	if r, ok := s.quicSession.(datagramRecorder); ok {
+		r.recordDatagram(remoteAddr, data)
+	}
+}
+
 func (s *serverSession) GetPerspective() protocol.Perspective {
 	return protocol.PerspectiveServer
 }
@@ -99,6 +99,17 @@ var _ = Describe("Server", func() {
 			Expect(c.MaxIncomingStreams).To(Equal(1234))
 			Expect(c.MaxIncomingUniStreams).To(BeZero())
 		})
+
+		It("uses the system clock by default", func() {
+			c := populateServerConfig(&Config{})
+			Expect(c.Clock).To(Equal(utils.DefaultClock))
+		})
+
+		It("uses the RecordWriter from the Config", func() {
+			buf := &bytes.Buffer{}
+			c := populateServerConfig(&Config{RecordWriter: buf})
+			Expect(c.RecordWriter).To(BeIdenticalTo(buf))
+		})
 	})
 
 	Context("with mock session", func() {
@@ -410,11 +421,13 @@ var _ = Describe("Server", func() {
 		supportedVersions := []protocol.VersionNumber{protocol.VersionTLS, protocol.Version39}
 		acceptCookie := func(_ net.Addr, _ *Cookie) bool { return true }
 		config := Config{
-			Versions:         supportedVersions,
-			AcceptCookie:     acceptCookie,
-			HandshakeTimeout: 1337 * time.Hour,
-			IdleTimeout:      42 * time.Minute,
-			KeepAlive:        true,
+			Versions:          supportedVersions,
+			AcceptCookie:      acceptCookie,
+			HandshakeTimeout:  1337 * time.Hour,
+			IdleTimeout:       42 * time.Minute,
+			KeepAlive:         true,
+			KeyUpdateInterval: 1000,
+			UseCryptoTLS:      true,
 		}
 		ln, err := Listen(conn, &tls.Config{}, &config)
 		Expect(err).ToNot(HaveOccurred())
@@ -426,6 +439,8 @@ var _ = Describe("Server", func() {
 		Expect(server.config.IdleTimeout).To(Equal(42 * time.Minute))
 		Expect(reflect.ValueOf(server.config.AcceptCookie)).To(Equal(reflect.ValueOf(acceptCookie)))
 		Expect(server.config.KeepAlive).To(BeTrue())
+		Expect(server.config.KeyUpdateInterval).To(BeEquivalentTo(1000))
+		Expect(server.config.UseCryptoTLS).To(BeTrue())
 	})
 
 	It("errors when the Config contains an invalid version", func() {
@@ -434,6 +449,11 @@ var _ = Describe("Server", func() {
 		Expect(err).To(MatchError("0x1234 is not a valid QUIC version"))
 	})
 
+	It("errors when the RecordWriter is used with versions other than QUIC v1", func() {
+		_, err := Listen(conn, &tls.Config{}, &Config{RecordWriter: &bytes.Buffer{}})
+		Expect(err).To(MatchError(ContainSubstring("the RecordWriter can only be used with QUIC v1")))
+	})
+
 	It("fills in default values if options are not set in the Config", func() {
 		ln, err := Listen(conn, &tls.Config{}, &Config{})
 		Expect(err).ToNot(HaveOccurred())
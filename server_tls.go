@@ -21,11 +21,12 @@ type tlsSession struct {
 type serverTLS struct {
 	conn            net.PacketConn
 	config          *Config
+	tlsConf         *tls.Config
 	mintConf        *mint.Config
 	params          *handshake.TransportParameters
 	cookieGenerator *handshake.CookieGenerator
 
-	newSession func(connection, sessionRunner, protocol.ConnectionID, protocol.ConnectionID, protocol.ConnectionID, protocol.PacketNumber, *Config, *mint.Config, *handshake.TransportParameters, utils.Logger, protocol.VersionNumber) (quicSession, error)
+	newSession func(connection, sessionRunner, protocol.ConnectionID, protocol.ConnectionID, protocol.ConnectionID, protocol.PacketNumber, *Config, *tls.Config, *mint.Config, handshake.TLSExtensionHandler, *handshake.TransportParameters, utils.Logger, protocol.VersionNumber) (quicSession, error)
 
 	sessionRunner sessionRunner
 	sessionChan   chan<- tlsSession
@@ -54,15 +55,20 @@ func newServerTLS(
 		// TODO(#855): generate a real token
 		StatelessResetToken: bytes.Repeat([]byte{42}, 16),
 	}
-	mconf, err := tlsToMintConfig(tlsConf, protocol.PerspectiveServer)
-	if err != nil {
-		return nil, nil, err
+	// mint is only used if crypto/tls wasn't selected
+	var mconf *mint.Config
+	if !config.UseCryptoTLS {
+		mconf, err = tlsToMintConfig(tlsConf, protocol.PerspectiveServer)
+		if err != nil {
+			return nil, nil, err
+		}
 	}
 
 	sessionChan := make(chan tlsSession)
 	s := &serverTLS{
 		conn:            conn,
 		config:          config,
+		tlsConf:         tlsConf,
 		mintConf:        mconf,
 		sessionRunner:   runner,
 		sessionChan:     sessionChan,
@@ -114,10 +120,6 @@ func (s *serverTLS) handleInitialImpl(p *receivedPacket) (quicSession, protocol.
 		return nil, nil, s.sendRetry(p.remoteAddr, hdr)
 	}
 
-	extHandler := handshake.NewExtensionHandlerServer(s.params, s.config.Versions, hdr.Version, s.logger)
-	mconf := s.mintConf.Clone()
-	mconf.ExtensionHandler = extHandler
-
 	// A server is allowed to perform multiple Retries.
 	// It doesn't make much sense, but it's something that our API allows.
 	// In that case it must use a source connection ID of at least 8 bytes.
@@ -125,6 +127,18 @@ func (s *serverTLS) handleInitialImpl(p *receivedPacket) (quicSession, protocol.
 	if err != nil {
 		return nil, nil, err
 	}
+
+	var extHandler handshake.TLSExtensionHandler
+	if hdr.Version.UsesV1HeaderFormat() {
+		extHandler = handshake.NewExtensionHandlerServerV1(s.getParamsV1(hdr, cookie, connID), s.logger)
+	} else {
+		extHandler = handshake.NewExtensionHandlerServer(s.params, s.config.Versions, hdr.Version, s.logger)
+	}
+	var mconf *mint.Config
+	if s.mintConf != nil {
+		mconf = s.mintConf.Clone()
+		mconf.ExtensionHandler = extHandler
+	}
 	s.logger.Debugf("Changing connection ID to %s.", connID)
 	sess, err := s.newSession(
 		&conn{pconn: s.conn, currentAddr: p.remoteAddr},
@@ -134,7 +148,9 @@ func (s *serverTLS) handleInitialImpl(p *receivedPacket) (quicSession, protocol.
 		connID,
 		1,
 		s.config,
+		s.tlsConf,
 		mconf,
+		extHandler,
 		s.params,
 		s.logger,
 		hdr.Version,
@@ -142,12 +158,32 @@ func (s *serverTLS) handleInitialImpl(p *receivedPacket) (quicSession, protocol.
 	if err != nil {
 		return nil, nil, err
 	}
+	// The first datagram was received before the session existed, so the packetHandlerMap couldn't record it.
+	if r, ok := sess.(datagramRecorder); ok && p.datagram != nil {
+		r.recordDatagram(p.remoteAddr, p.datagram)
+	}
 	go sess.run()
 	sess.handlePacket(p)
 	return sess, connID, nil
 }
 
+// getParamsV1 returns the transport parameters sent on a QUIC v1 connection.
+// In QUIC v1, the server authenticates the connection IDs used during the handshake in its transport parameters.
+func (s *serverTLS) getParamsV1(hdr *wire.Header, cookie *handshake.Cookie, connID protocol.ConnectionID) *handshake.TransportParameters {
+	params := *s.params
+	params.InitialSourceConnectionID = connID
+	params.OriginalDestinationConnectionID = hdr.DestConnectionID
+	if cookie != nil && cookie.OriginalDestConnectionID != nil {
+		params.OriginalDestinationConnectionID = cookie.OriginalDestConnectionID
+		params.RetrySourceConnectionID = hdr.DestConnectionID
+	}
+	return &params
+}
+
 func (s *serverTLS) sendRetry(remoteAddr net.Addr, hdr *wire.Header) error {
+	if hdr.Version.UsesV1HeaderFormat() {
+		return s.sendRetryV1(remoteAddr, hdr)
+	}
 	token, err := s.cookieGenerator.NewToken(remoteAddr)
 	if err != nil {
 		return err
@@ -176,3 +212,36 @@ func (s *serverTLS) sendRetry(remoteAddr net.Addr, hdr *wire.Header) error {
 	}
 	return nil
 }
+
+// sendRetryV1 sends a QUIC v1 Retry packet.
+// The original destination connection ID is not sent in the packet.
+// Instead, it is used to calculate the Retry Integrity Tag, and saved in the token.
+func (s *serverTLS) sendRetryV1(remoteAddr net.Addr, hdr *wire.Header) error {
+	token, err := s.cookieGenerator.NewRetryToken(remoteAddr, hdr.DestConnectionID)
+	if err != nil {
+		return err
+	}
+	connID, err := protocol.GenerateConnectionIDForInitial()
+	if err != nil {
+		return err
+	}
+	replyHdr := &wire.Header{
+		IsLongHeader:     true,
+		Type:             protocol.PacketTypeRetry,
+		Version:          hdr.Version,
+		SrcConnectionID:  connID,
+		DestConnectionID: hdr.SrcConnectionID,
+		Token:            token,
+	}
+	s.logger.Debugf("Changing connection ID to %s.\n-> Sending Retry", connID)
+	replyHdr.Log(s.logger)
+	buf := &bytes.Buffer{}
+	if err := replyHdr.Write(buf, protocol.PerspectiveServer, hdr.Version); err != nil {
+		return err
+	}
+	buf.Write(handshake.GetRetryIntegrityTag(buf.Bytes(), hdr.DestConnectionID))
+	if _, err := s.conn.WriteTo(buf.Bytes(), remoteAddr); err != nil {
+		s.logger.Debugf("Error sending Retry: %s", err)
+	}
+	return nil
+}
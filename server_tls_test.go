@@ -2,6 +2,7 @@ package quic
 
 import (
 	"bytes"
+	"crypto/tls"
 	"net"
 
 	"github.com/bifurcation/mint"
@@ -86,6 +87,40 @@ var _ = Describe("Stateless TLS handling", func() {
 		Expect(sessionChan).ToNot(Receive())
 	})
 
+	It("doesn't create a mint config when using crypto/tls", func() {
+		tlsConf := testdata.GetTLSConfig()
+		config := &Config{
+			Versions:     []protocol.VersionNumber{protocol.VersionTLS},
+			UseCryptoTLS: true,
+			AcceptCookie: func(_ net.Addr, _ *handshake.Cookie) bool { return true },
+		}
+		var err error
+		server, sessionChan, err = newServerTLS(conn, config, nil, tlsConf, utils.DefaultLogger)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(server.mintConf).To(BeNil())
+		hdr := &wire.Header{
+			Type:             protocol.PacketTypeInitial,
+			SrcConnectionID:  protocol.ConnectionID{5, 4, 3, 2, 1},
+			DestConnectionID: protocol.ConnectionID{1, 2, 3, 4, 5, 6, 7, 8, 9, 10},
+			Version:          protocol.VersionTLS,
+		}
+		p := &receivedPacket{
+			header: hdr,
+			data:   bytes.Repeat([]byte{0}, protocol.MinInitialPacketSize),
+		}
+		server.newSession = func(_ connection, _ sessionRunner, _ protocol.ConnectionID, _ protocol.ConnectionID, _ protocol.ConnectionID, _ protocol.PacketNumber, _ *Config, tlsConfP *tls.Config, mintConf *mint.Config, extHandler handshake.TLSExtensionHandler, _ *handshake.TransportParameters, _ utils.Logger, _ protocol.VersionNumber) (quicSession, error) {
+			Expect(tlsConfP).To(Equal(tlsConf))
+			Expect(mintConf).To(BeNil())
+			Expect(extHandler).ToNot(BeNil())
+			sess := NewMockQuicSession(mockCtrl)
+			sess.EXPECT().handlePacket(p)
+			sess.EXPECT().run()
+			return sess, nil
+		}
+		go server.HandleInitial(p)
+		Eventually(sessionChan).Should(Receive())
+	})
+
 	It("creates a session, if no Cookie is required", func() {
 		server.config.AcceptCookie = func(_ net.Addr, _ *handshake.Cookie) bool { return true }
 		hdr := &wire.Header{
@@ -99,7 +134,7 @@ var _ = Describe("Stateless TLS handling", func() {
 			data:   bytes.Repeat([]byte{0}, protocol.MinInitialPacketSize),
 		}
 		run := make(chan struct{})
-		server.newSession = func(connection, sessionRunner, protocol.ConnectionID, protocol.ConnectionID, protocol.ConnectionID, protocol.PacketNumber, *Config, *mint.Config, *handshake.TransportParameters, utils.Logger, protocol.VersionNumber) (quicSession, error) {
+		server.newSession = func(connection, sessionRunner, protocol.ConnectionID, protocol.ConnectionID, protocol.ConnectionID, protocol.PacketNumber, *Config, *tls.Config, *mint.Config, handshake.TLSExtensionHandler, *handshake.TransportParameters, utils.Logger, protocol.VersionNumber) (quicSession, error) {
 			sess := NewMockQuicSession(mockCtrl)
 			sess.EXPECT().handlePacket(p)
 			sess.EXPECT().run().Do(func() { close(run) })
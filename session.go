@@ -42,6 +42,7 @@ type streamManager interface {
 	DeleteStream(protocol.StreamID) error
 	UpdateLimits(*handshake.TransportParameters)
 	HandleMaxStreamIDFrame(*wire.MaxStreamIDFrame) error
+	HandleMaxStreamsFrame(*wire.MaxStreamsFrame) error
 	CloseWithError(error)
 }
 
@@ -54,11 +55,18 @@ type divNonceSetter interface {
 	SetDiversificationNonce([]byte) error
 }
 
+type keyUpdater interface {
+	InitiateKeyUpdate() error
+}
+
 type receivedPacket struct {
 	remoteAddr net.Addr
 	header     *wire.Header
 	data       []byte
 	rcvTime    time.Time
+	// datagram is the datagram that contained the packet.
+	// It is used to record the first packet of a session, which is received before the session is created.
+	datagram []byte
 }
 
 var (
@@ -89,6 +97,7 @@ type session struct {
 	cryptoStream cryptoStream
 
 	rttStats *congestion.RTTStats
+	clock    utils.Clock
 
 	sentPacketHandler     ackhandler.SentPacketHandler
 	receivedPacketHandler ackhandler.ReceivedPacketHandler
@@ -101,6 +110,16 @@ type session struct {
 
 	cryptoStreamHandler cryptoStreamHandler
 
+	// only set for QUIC v1
+	// The hook is only set if the session is recorded or replayed.
+	hook                    sessionHook
+	cryptoSetupV1           handshake.CryptoSetupV1
+	cryptoStreams           *cryptoStreamManager
+	receivedPacketHandlerV1 ackhandler.ReceivedPacketHandlerV1
+	droppedInitialKeys      bool
+	droppedHandshakeKeys    bool
+	handshakeConfirmed      bool
+
 	receivedPackets  chan *receivedPacket
 	sendingScheduled chan struct{}
 	// closeChan is used to notify the run loop that it should terminate.
@@ -303,11 +322,16 @@ func newTLSServerSession(
 	srcConnID protocol.ConnectionID,
 	initialPacketNumber protocol.PacketNumber,
 	config *Config,
+	tlsConf *tls.Config,
 	mintConf *mint.Config,
+	extHandler handshake.TLSExtensionHandler,
 	peerParams *handshake.TransportParameters,
 	logger utils.Logger,
 	v protocol.VersionNumber,
 ) (quicSession, error) {
+	if v.UsesCryptoFrames() {
+		return newSessionV1(conn, runner, origConnID, destConnID, srcConnID, nil, config, tlsConf, extHandler, protocol.PerspectiveServer, logger, v, nil)
+	}
 	handshakeEvent := make(chan struct{}, 1)
 	s := &session{
 		conn:           conn,
@@ -321,13 +345,28 @@ func newTLSServerSession(
 		logger:         logger,
 	}
 	s.preSetup()
-	cs, err := handshake.NewCryptoSetupTLSServer(
-		s.cryptoStream,
-		origConnID,
-		mintConf,
-		handshakeEvent,
-		v,
-	)
+	var cs handshake.CryptoSetupTLS
+	var err error
+	if s.config.UseCryptoTLS {
+		cs, err = handshake.NewCryptoSetupQUICTLSServer(
+			s.cryptoStream,
+			origConnID,
+			tlsConf,
+			extHandler,
+			handshakeEvent,
+			s.config.KeyUpdateInterval,
+			v,
+		)
+	} else {
+		cs, err = handshake.NewCryptoSetupTLSServer(
+			s.cryptoStream,
+			origConnID,
+			mintConf,
+			handshakeEvent,
+			s.config.KeyUpdateInterval,
+			v,
+		)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -364,12 +403,16 @@ var newTLSClientSession = func(
 	destConnID protocol.ConnectionID,
 	srcConnID protocol.ConnectionID,
 	conf *Config,
+	tlsConf *tls.Config,
 	mintConf *mint.Config,
-	paramsChan <-chan handshake.TransportParameters,
+	extHandler handshake.TLSExtensionHandler,
 	initialPacketNumber protocol.PacketNumber,
 	logger utils.Logger,
 	v protocol.VersionNumber,
 ) (quicSession, error) {
+	if v.UsesCryptoFrames() {
+		return newSessionV1(conn, runner, destConnID, destConnID, srcConnID, token, conf, tlsConf, extHandler, protocol.PerspectiveClient, logger, v, nil)
+	}
 	handshakeEvent := make(chan struct{}, 1)
 	s := &session{
 		conn:           conn,
@@ -380,17 +423,32 @@ var newTLSClientSession = func(
 		perspective:    protocol.PerspectiveClient,
 		version:        v,
 		handshakeEvent: handshakeEvent,
-		paramsChan:     paramsChan,
+		paramsChan:     extHandler.GetPeerParams(),
 		logger:         logger,
 	}
 	s.preSetup()
-	cs, err := handshake.NewCryptoSetupTLSClient(
-		s.cryptoStream,
-		s.destConnID,
-		mintConf,
-		handshakeEvent,
-		v,
-	)
+	var cs handshake.CryptoSetupTLS
+	var err error
+	if s.config.UseCryptoTLS {
+		cs, err = handshake.NewCryptoSetupQUICTLSClient(
+			s.cryptoStream,
+			s.destConnID,
+			tlsConf,
+			extHandler,
+			handshakeEvent,
+			s.config.KeyUpdateInterval,
+			v,
+		)
+	} else {
+		cs, err = handshake.NewCryptoSetupTLSClient(
+			s.cryptoStream,
+			s.destConnID,
+			mintConf,
+			handshakeEvent,
+			s.config.KeyUpdateInterval,
+			v,
+		)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -416,12 +474,14 @@ var newTLSClientSession = func(
 
 func (s *session) preSetup() {
 	s.rttStats = &congestion.RTTStats{}
-	s.sentPacketHandler = ackhandler.NewSentPacketHandler(s.rttStats, s.logger, s.version)
+	s.clock = s.config.Clock
+	s.sentPacketHandler = ackhandler.NewSentPacketHandler(s.rttStats, s.clock, s.logger, s.version)
 	s.connFlowController = flowcontrol.NewConnectionFlowController(
 		protocol.ReceiveConnectionFlowControlWindow,
 		protocol.ByteCount(s.config.MaxReceiveConnectionFlowControlWindow),
 		s.onHasConnectionWindowUpdate,
 		s.rttStats,
+		s.clock,
 		s.logger,
 	)
 	s.cryptoStream = s.newCryptoStream()
@@ -434,12 +494,16 @@ func (s *session) postSetup() error {
 	s.undecryptablePackets = make([]*receivedPacket, 0, protocol.MaxUndecryptablePackets)
 	s.ctx, s.ctxCancel = context.WithCancel(context.Background())
 
-	s.timer = utils.NewTimer()
-	now := time.Now()
+	s.timer = utils.NewTimerWithClock(s.clock)
+	now := s.clock.Now()
 	s.lastNetworkActivityTime = now
 	s.sessionCreationTime = now
 
-	s.receivedPacketHandler = ackhandler.NewReceivedPacketHandler(s.rttStats, s.logger, s.version)
+	if s.receivedPacketHandlerV1 != nil {
+		s.receivedPacketHandler = s.receivedPacketHandlerV1
+	} else {
+		s.receivedPacketHandler = ackhandler.NewReceivedPacketHandler(s.rttStats, s.clock, s.logger, s.version)
+	}
 	s.windowUpdateQueue = newWindowUpdateQueue(s.streamsMap, s.cryptoStream, s.connFlowController, s.packer.QueueControlFrame)
 	return nil
 }
@@ -501,8 +565,8 @@ runLoop:
 			s.handleHandshakeEvent(!ok)
 		}
 
-		now := time.Now()
-		if timeout := s.sentPacketHandler.GetAlarmTimeout(); !timeout.IsZero() && timeout.Before(now) {
+		now := s.clock.Now()
+		if timeout := s.sentPacketHandler.GetAlarmTimeout(); !timeout.IsZero() && !timeout.After(now) {
 			// This could cause packets to be retransmitted.
 			// Check it before trying to send packets.
 			if err := s.sentPacketHandler.OnAlarm(); err != nil {
@@ -514,7 +578,7 @@ runLoop:
 		if s.pacingDeadline.IsZero() { // the timer didn't have a pacing deadline set
 			pacingDeadline = s.sentPacketHandler.TimeUntilSend()
 		}
-		if s.config.KeepAlive && !s.keepAlivePingSent && s.handshakeComplete && time.Since(s.lastNetworkActivityTime) >= s.peerParams.IdleTimeout/2 {
+		if s.config.KeepAlive && !s.keepAlivePingSent && s.handshakeComplete && now.Sub(s.lastNetworkActivityTime) >= s.peerParams.IdleTimeout/2 {
 			// send a PING frame since there is no activity in the session
 			s.logger.Debugf("Sending a keep-alive ping to keep the connection alive.")
 			s.packer.QueueControlFrame(&wire.PingFrame{})
@@ -531,7 +595,7 @@ runLoop:
 			s.closeLocal(err)
 		}
 
-		if !s.receivedTooManyUndecrytablePacketsTime.IsZero() && s.receivedTooManyUndecrytablePacketsTime.Add(protocol.PublicResetTimeout).Before(now) && len(s.undecryptablePackets) != 0 {
+		if !s.receivedTooManyUndecrytablePacketsTime.IsZero() && !s.receivedTooManyUndecrytablePacketsTime.Add(protocol.PublicResetTimeout).After(now) && len(s.undecryptablePackets) != 0 {
 			s.closeLocal(qerr.Error(qerr.DecryptionFailure, "too many undecryptable packets received"))
 		}
 		if !s.handshakeComplete && now.Sub(s.sessionCreationTime) >= s.config.HandshakeTimeout {
@@ -547,6 +611,9 @@ runLoop:
 	}
 	s.logger.Infof("Connection %s closed.", s.srcConnID)
 	s.sessionRunner.removeConnectionID(s.srcConnID)
+	if s.hook != nil {
+		s.hook.sessionClosed()
+	}
 	return closeErr.err
 }
 
@@ -558,6 +625,20 @@ func (s *session) ConnectionState() ConnectionState {
 	return s.cryptoStreamHandler.ConnectionState()
 }
 
+func (s *session) InitiateKeyUpdate() error {
+	ku, ok := s.cryptoStreamHandler.(keyUpdater)
+	if !ok {
+		return errors.New("key updates are only supported for IETF QUIC")
+	}
+	if err := ku.InitiateKeyUpdate(); err != nil {
+		return err
+	}
+	s.logger.Debugf("Initiated a key update.")
+	// make sure the peer learns about the new keys
+	s.queueControlFrame(&wire.PingFrame{})
+	return nil
+}
+
 func (s *session) maybeResetTimer() {
 	var deadline time.Time
 	if s.config.KeepAlive && s.handshakeComplete && !s.keepAlivePingSent {
@@ -595,6 +676,10 @@ func (s *session) handleHandshakeEvent(completed bool) {
 	s.handshakeEvent = nil // prevent this case from ever being selected again
 	s.sessionRunner.onHandshakeComplete(s)
 
+	if s.version.UsesCryptoFrames() {
+		s.handleHandshakeCompleteV1()
+		return
+	}
 	// In gQUIC, the server completes the handshake first (after sending the SHLO).
 	// In TLS 1.3, the client completes the handshake first (after sending the CFIN).
 	// We need to make sure they learn about the peer completing the handshake,
@@ -627,18 +712,26 @@ func (s *session) handlePacketImpl(p *receivedPacket) error {
 
 	if p.rcvTime.IsZero() {
 		// To simplify testing
-		p.rcvTime = time.Now()
+		p.rcvTime = s.clock.Now()
 	}
 
 	// Calculate packet number
-	hdr.PacketNumber = protocol.InferPacketNumber(
-		hdr.PacketNumberLen,
-		s.largestRcvdPacketNumber,
-		hdr.PacketNumber,
-		s.version,
-	)
+	// For IETF QUIC, the packet number is protected.
+	// It is inferred by the unpacker, after removing header protection.
+	if !s.version.UsesTLS() {
+		hdr.PacketNumber = protocol.InferPacketNumber(
+			hdr.PacketNumberLen,
+			s.largestRcvdPacketNumber,
+			hdr.PacketNumber,
+			s.version,
+		)
+	}
 
 	packet, err := s.unpacker.Unpack(hdr.Raw, hdr, p.data)
+	if err == handshake.ErrKeysDropped {
+		s.logger.Debugf("Dropping %s packet, keys were already dropped.", hdr.Type)
+		return nil
+	}
 	if s.logger.Debug() {
 		if err != nil {
 			s.logger.Debugf("<- Reading packet 0x%x (%d bytes) for connection %s", hdr.PacketNumber, len(p.data)+len(hdr.Raw), hdr.DestConnectionID)
@@ -666,7 +759,10 @@ func (s *session) handlePacketImpl(p *receivedPacket) error {
 	// In gQUIC, the server completes the handshake first (after sending the SHLO).
 	// In TLS 1.3, the client completes the handshake first (after sending the CFIN).
 	// We know that the peer completed the handshake as soon as we receive a forward-secure packet.
-	if (!s.version.UsesTLS() && s.perspective == protocol.PerspectiveServer) ||
+	// In QUIC v1, the handshake is confirmed by the HANDSHAKE_DONE frame.
+	if s.version.UsesPacketNumberSpaces() {
+		s.handlePacketEncryptionLevelV1(packet.encryptionLevel)
+	} else if (!s.version.UsesTLS() && s.perspective == protocol.PerspectiveServer) ||
 		(s.version.UsesTLS() && s.perspective == protocol.PerspectiveClient) {
 		if !s.receivedFirstForwardSecurePacket && packet.encryptionLevel == protocol.EncryptionForwardSecure {
 			s.receivedFirstForwardSecurePacket = true
@@ -682,7 +778,11 @@ func (s *session) handlePacketImpl(p *receivedPacket) error {
 	// The session will be closed and recreated as soon as the crypto setup processed the HRR.
 	if hdr.Type != protocol.PacketTypeRetry {
 		isRetransmittable := ackhandler.HasRetransmittableFrames(packet.frames)
-		if err := s.receivedPacketHandler.ReceivedPacket(hdr.PacketNumber, p.rcvTime, isRetransmittable); err != nil {
+		if s.receivedPacketHandlerV1 != nil {
+			if err := s.receivedPacketHandlerV1.ReceivedPacketWithEncryptionLevel(hdr.PacketNumber, packet.encryptionLevel, p.rcvTime, isRetransmittable); err != nil {
+				return err
+			}
+		} else if err := s.receivedPacketHandler.ReceivedPacket(hdr.PacketNumber, p.rcvTime, isRetransmittable); err != nil {
 			return err
 		}
 	}
@@ -694,13 +794,24 @@ func (s *session) handleFrames(fs []wire.Frame, encLevel protocol.EncryptionLeve
 	for _, ff := range fs {
 		var err error
 		wire.LogFrame(s.logger, ff, false)
+		if s.version.UsesCryptoFrames() {
+			if err := checkFrameEncryptionLevelV1(ff, encLevel); err != nil {
+				return err
+			}
+		}
 		switch frame := ff.(type) {
 		case *wire.StreamFrame:
 			err = s.handleStreamFrame(frame, encLevel)
 		case *wire.AckFrame:
 			err = s.handleAckFrame(frame, encLevel)
+		case *wire.CryptoFrame:
+			err = s.cryptoStreams.HandleCryptoFrame(frame, encLevel)
 		case *wire.ConnectionCloseFrame:
-			s.closeRemote(qerr.Error(frame.ErrorCode, frame.ReasonPhrase))
+			if frame.IsApplicationError {
+				s.closeRemote(qerr.ApplicationError(frame.ErrorCode, frame.ReasonPhrase))
+			} else {
+				s.closeRemote(qerr.Error(frame.ErrorCode, frame.ReasonPhrase))
+			}
 		case *wire.GoawayFrame:
 			err = errors.New("unimplemented: handling GOAWAY frames")
 		case *wire.StopWaitingFrame: // ignore STOP_WAITINGs
@@ -712,6 +823,14 @@ func (s *session) handleFrames(fs []wire.Frame, encLevel protocol.EncryptionLeve
 			err = s.handleMaxStreamDataFrame(frame)
 		case *wire.MaxStreamIDFrame:
 			err = s.handleMaxStreamIDFrame(frame)
+		case *wire.MaxStreamsFrame:
+			err = s.streamsMap.HandleMaxStreamsFrame(frame)
+		case *wire.StreamsBlockedFrame:
+		case *wire.HandshakeDoneFrame:
+			err = s.handleHandshakeDoneFrame()
+		case *wire.NewTokenFrame:
+		case *wire.NewConnectionIDFrame:
+		case *wire.RetireConnectionIDFrame:
 		case *wire.BlockedFrame:
 		case *wire.StreamBlockedFrame:
 		case *wire.StreamIDBlockedFrame:
@@ -734,8 +853,16 @@ func (s *session) handleFrames(fs []wire.Frame, encLevel protocol.EncryptionLeve
 	return nil
 }
 
+// recordDatagram is called with every datagram received for the session, before it is parsed
+func (s *session) recordDatagram(remoteAddr net.Addr, data []byte) {
+	if s.hook != nil {
+		s.hook.receivedDatagram(remoteAddr, data)
+	}
+}
+
 // handlePacket is called by the server with a new packet
 func (s *session) handlePacket(p *receivedPacket) {
+	p.rcvTime = s.clock.Now()
 	// Discard packets once the amount of queued packets is larger than
 	// the channel size, protocol.MaxSessionUnprocessedPackets
 	select {
@@ -829,7 +956,10 @@ func (s *session) handleAckFrame(frame *wire.AckFrame, encLevel protocol.Encrypt
 	if err := s.sentPacketHandler.ReceivedAck(frame, s.lastRcvdPacketNumber, encLevel, s.lastNetworkActivityTime); err != nil {
 		return err
 	}
-	s.receivedPacketHandler.IgnoreBelow(s.sentPacketHandler.GetLowestPacketNotConfirmedAcked())
+	// In QUIC v1, the lowest packet not confirmed acked refers to the 1-RTT packet number space.
+	if !s.version.UsesPacketNumberSpaces() || encLevel == protocol.EncryptionForwardSecure {
+		s.receivedPacketHandler.IgnoreBelow(s.sentPacketHandler.GetLowestPacketNotConfirmedAcked())
+	}
 	return nil
 }
 
@@ -862,7 +992,11 @@ func (s *session) Close() error {
 }
 
 func (s *session) CloseWithError(code protocol.ApplicationErrorCode, e error) error {
-	s.closeLocal(qerr.Error(qerr.ErrorCode(code), e.Error()))
+	if s.version.UsesV1FrameFormat() {
+		s.closeLocal(qerr.ApplicationError(qerr.ErrorCode(code), e.Error()))
+	} else {
+		s.closeLocal(qerr.Error(qerr.ErrorCode(code), e.Error()))
+	}
 	<-s.ctx.Done()
 	return nil
 }
@@ -885,6 +1019,9 @@ func (s *session) handleCloseError(closeErr closeError) error {
 	}
 
 	s.cryptoStream.closeForShutdown(quicErr)
+	if s.cryptoStreams != nil {
+		s.cryptoStreams.closeForShutdown(quicErr)
+	}
 	s.streamsMap.CloseWithError(quicErr)
 
 	if !closeErr.sendClose {
@@ -896,14 +1033,21 @@ func (s *session) handleCloseError(closeErr closeError) error {
 		return nil
 	}
 
-	if quicErr.ErrorCode == qerr.DecryptionFailure ||
-		quicErr == handshake.ErrNSTPExperiment {
+	// QUIC v1 doesn't have a Public Reset
+	if !s.version.UsesV1HeaderFormat() && (quicErr.ErrorCode == qerr.DecryptionFailure ||
+		quicErr == handshake.ErrNSTPExperiment) {
 		return s.sendPublicReset(s.lastRcvdPacketNumber)
 	}
 	return s.sendConnectionClose(quicErr)
 }
 
 func (s *session) processTransportParameters(params *handshake.TransportParameters) {
+	if s.version.UsesV1HeaderFormat() {
+		if err := s.processTransportParametersV1(params); err != nil {
+			s.closeLocal(err)
+			return
+		}
+	}
 	s.peerParams = params
 	s.streamsMap.UpdateLimits(params)
 	if params.OmitConnectionID {
@@ -978,6 +1122,9 @@ sendLoop:
 }
 
 func (s *session) maybeSendAckOnlyPacket() error {
+	if s.version.UsesPacketNumberSpaces() {
+		return s.maybeSendAckOnlyPacketV1()
+	}
 	ack := s.receivedPacketHandler.GetAckFrame()
 	if ack == nil {
 		return nil
@@ -993,7 +1140,7 @@ func (s *session) maybeSendAckOnlyPacket() error {
 	if err != nil {
 		return err
 	}
-	s.sentPacketHandler.SentPacket(packet.ToAckHandlerPacket())
+	s.sentPacketHandler.SentPacket(packet.ToAckHandlerPacket(s.clock.Now()))
 	return s.sendPackedPacket(packet)
 }
 
@@ -1010,7 +1157,8 @@ func (s *session) maybeSendRetransmission() (bool, error) {
 		// Don't retransmit Initial packets if we already received a response.
 		// An Initial might have been retransmitted multiple times before we receive a response.
 		// As soon as we receive one response, we don't need to send any more Initials.
-		if s.receivedFirstPacket && retransmitPacket.PacketType == protocol.PacketTypeInitial {
+		// In QUIC v1, Initial packets are dropped together with the Initial keys.
+		if !s.version.UsesPacketNumberSpaces() && s.receivedFirstPacket && retransmitPacket.PacketType == protocol.PacketTypeInitial {
 			s.logger.Debugf("Skipping retransmission of packet %d. Already received a response to an Initial.", retransmitPacket.PacketNumber)
 			continue
 		}
@@ -1032,7 +1180,7 @@ func (s *session) maybeSendRetransmission() (bool, error) {
 	}
 	ackhandlerPackets := make([]*ackhandler.Packet, len(packets))
 	for i, packet := range packets {
-		ackhandlerPackets[i] = packet.ToAckHandlerPacket()
+		ackhandlerPackets[i] = packet.ToAckHandlerPacket(s.clock.Now())
 	}
 	s.sentPacketHandler.SentPacketsAsRetransmission(ackhandlerPackets, retransmitPacket.PacketNumber)
 	for _, packet := range packets {
@@ -1059,7 +1207,7 @@ func (s *session) sendProbePacket() error {
 	}
 	ackhandlerPackets := make([]*ackhandler.Packet, len(packets))
 	for i, packet := range packets {
-		ackhandlerPackets[i] = packet.ToAckHandlerPacket()
+		ackhandlerPackets[i] = packet.ToAckHandlerPacket(s.clock.Now())
 	}
 	s.sentPacketHandler.SentPacketsAsRetransmission(ackhandlerPackets, p.PacketNumber)
 	for _, packet := range packets {
@@ -1089,7 +1237,10 @@ func (s *session) sendPacket() (bool, error) {
 	if err != nil || packet == nil {
 		return false, err
 	}
-	s.sentPacketHandler.SentPacket(packet.ToAckHandlerPacket())
+	s.sentPacketHandler.SentPacket(packet.ToAckHandlerPacket(s.clock.Now()))
+	if s.version.UsesPacketNumberSpaces() {
+		s.onPacketSentV1(packet)
+	}
 	if err := s.sendPackedPacket(packet); err != nil {
 		return false, err
 	}
@@ -1104,8 +1255,9 @@ func (s *session) sendPackedPacket(packet *packedPacket) error {
 
 func (s *session) sendConnectionClose(quicErr *qerr.QuicError) error {
 	packet, err := s.packer.PackConnectionClose(&wire.ConnectionCloseFrame{
-		ErrorCode:    quicErr.ErrorCode,
-		ReasonPhrase: quicErr.ErrorMessage,
+		IsApplicationError: quicErr.IsApplicationError(),
+		ErrorCode:          quicErr.ErrorCode,
+		ReasonPhrase:       quicErr.ErrorMessage,
 	})
 	if err != nil {
 		return err
@@ -1186,6 +1338,7 @@ func (s *session) newFlowController(id protocol.StreamID) flowcontrol.StreamFlow
 		initialSendWindow,
 		s.onHasStreamWindowUpdate,
 		s.rttStats,
+		s.clock,
 		s.logger,
 	)
 }
@@ -1201,6 +1354,7 @@ func (s *session) newCryptoStream() cryptoStream {
 		0,
 		s.onHasStreamWindowUpdate,
 		s.rttStats,
+		s.clock,
 		s.logger,
 	)
 	return newCryptoStream(s, flowController, s.version)
@@ -1227,7 +1381,7 @@ func (s *session) tryQueueingUndecryptablePacket(p *receivedPacket) {
 	if len(s.undecryptablePackets)+1 > protocol.MaxUndecryptablePackets {
 		// if this is the first time the undecryptablePackets runs full, start the timer to send a Public Reset
 		if s.receivedTooManyUndecrytablePacketsTime.IsZero() {
-			s.receivedTooManyUndecrytablePacketsTime = time.Now()
+			s.receivedTooManyUndecrytablePacketsTime = s.clock.Now()
 			s.maybeResetTimer()
 		}
 		s.logger.Infof("Dropping undecrytable packet 0x%x (undecryptable packet queue full)", p.header.PacketNumber)
@@ -65,6 +65,21 @@ func areSessionsRunning() bool {
 	return strings.Contains(b.String(), "quic-go.(*session).run")
 }
 
+type mockKeyUpdatingCryptoSetup struct {
+	mockCryptoSetup
+
+	keyUpdateErr  error
+	numKeyUpdates int
+}
+
+func (m *mockKeyUpdatingCryptoSetup) InitiateKeyUpdate() error {
+	if m.keyUpdateErr != nil {
+		return m.keyUpdateErr
+	}
+	m.numKeyUpdates++
+	return nil
+}
+
 var _ = Describe("Session", func() {
 	var (
 		sess          *session
@@ -502,6 +517,27 @@ var _ = Describe("Session", func() {
 		Expect(str).To(Equal(mstr))
 	})
 
+	Context("key updates", func() {
+		It("doesn't update keys when using gQUIC", func() {
+			Expect(sess.InitiateKeyUpdate()).To(MatchError("key updates are only supported for IETF QUIC"))
+		})
+
+		It("initiates a key update", func() {
+			cs := &mockKeyUpdatingCryptoSetup{}
+			sess.cryptoStreamHandler = cs
+			Expect(sess.InitiateKeyUpdate()).To(Succeed())
+			Expect(cs.numKeyUpdates).To(Equal(1))
+			Expect(sess.packer.controlFrames).To(Equal([]wire.Frame{&wire.PingFrame{}}))
+		})
+
+		It("returns errors that occur when initiating a key update", func() {
+			testErr := errors.New("key update failed")
+			sess.cryptoStreamHandler = &mockKeyUpdatingCryptoSetup{keyUpdateErr: testErr}
+			Expect(sess.InitiateKeyUpdate()).To(MatchError(testErr))
+			Expect(sess.packer.controlFrames).To(BeEmpty())
+		})
+	})
+
 	Context("closing", func() {
 		BeforeEach(func() {
 			Eventually(areSessionsRunning).Should(BeFalse())
@@ -760,7 +796,7 @@ var _ = Describe("Session", func() {
 			unpacker.EXPECT().Unpack(gomock.Any(), gomock.Any(), gomock.Any()).Return(&unpackedPacket{}, nil)
 			sess.unpacker = unpacker
 			sph := mockackhandler.NewMockSentPacketHandler(mockCtrl)
-			sph.EXPECT().GetPacketNumberLen(gomock.Any()).Return(protocol.PacketNumberLen2).AnyTimes()
+			sph.EXPECT().GetPacketNumberLen(gomock.Any(), gomock.Any()).Return(protocol.PacketNumberLen2).AnyTimes()
 			sph.EXPECT().DequeuePacketForRetransmission().Return(&ackhandler.Packet{
 				PacketNumber: 10,
 				PacketType:   protocol.PacketTypeInitial,
@@ -784,7 +820,7 @@ var _ = Describe("Session", func() {
 		It("sends a retransmission and a regular packet in the same run", func() {
 			sess.windowUpdateQueue.callback(&wire.MaxDataFrame{})
 			sph := mockackhandler.NewMockSentPacketHandler(mockCtrl)
-			sph.EXPECT().GetPacketNumberLen(gomock.Any()).Return(protocol.PacketNumberLen2).AnyTimes()
+			sph.EXPECT().GetPacketNumberLen(gomock.Any(), gomock.Any()).Return(protocol.PacketNumberLen2).AnyTimes()
 			sph.EXPECT().DequeuePacketForRetransmission().Return(&ackhandler.Packet{
 				PacketNumber: 10,
 				PacketType:   protocol.PacketTypeHandshake,
@@ -816,7 +852,7 @@ var _ = Describe("Session", func() {
 			f := &wire.MaxDataFrame{ByteOffset: 1337}
 			sph := mockackhandler.NewMockSentPacketHandler(mockCtrl)
 			sph.EXPECT().TimeUntilSend()
-			sph.EXPECT().GetPacketNumberLen(gomock.Any()).Return(protocol.PacketNumberLen2).AnyTimes()
+			sph.EXPECT().GetPacketNumberLen(gomock.Any(), gomock.Any()).Return(protocol.PacketNumberLen2).AnyTimes()
 			sph.EXPECT().SendMode().Return(ackhandler.SendTLP)
 			sph.EXPECT().ShouldSendNumPackets().Return(1)
 			sph.EXPECT().DequeueProbePacket().Return(&ackhandler.Packet{
@@ -850,7 +886,7 @@ var _ = Describe("Session", func() {
 		BeforeEach(func() {
 			sph = mockackhandler.NewMockSentPacketHandler(mockCtrl)
 			sph.EXPECT().GetAlarmTimeout().AnyTimes()
-			sph.EXPECT().GetPacketNumberLen(gomock.Any()).Return(protocol.PacketNumberLen2).AnyTimes()
+			sph.EXPECT().GetPacketNumberLen(gomock.Any(), gomock.Any()).Return(protocol.PacketNumberLen2).AnyTimes()
 			sph.EXPECT().DequeuePacketForRetransmission().AnyTimes()
 			sess.sentPacketHandler = sph
 			sess.packer.hasSentPacket = true
@@ -990,7 +1026,7 @@ var _ = Describe("Session", func() {
 		It("sends ACK only packets", func() {
 			swf := &wire.StopWaitingFrame{LeastUnacked: 10}
 			sph := mockackhandler.NewMockSentPacketHandler(mockCtrl)
-			sph.EXPECT().GetPacketNumberLen(gomock.Any()).Return(protocol.PacketNumberLen2).AnyTimes()
+			sph.EXPECT().GetPacketNumberLen(gomock.Any(), gomock.Any()).Return(protocol.PacketNumberLen2).AnyTimes()
 			sph.EXPECT().GetAlarmTimeout().AnyTimes()
 			sph.EXPECT().SendMode().Return(ackhandler.SendAck)
 			sph.EXPECT().ShouldSendNumPackets().Return(1000)
@@ -1024,7 +1060,7 @@ var _ = Describe("Session", func() {
 			sess.version = versionIETFFrames
 			done := make(chan struct{})
 			sph := mockackhandler.NewMockSentPacketHandler(mockCtrl)
-			sph.EXPECT().GetPacketNumberLen(gomock.Any()).Return(protocol.PacketNumberLen2).AnyTimes()
+			sph.EXPECT().GetPacketNumberLen(gomock.Any(), gomock.Any()).Return(protocol.PacketNumberLen2).AnyTimes()
 			sph.EXPECT().GetAlarmTimeout().AnyTimes()
 			sph.EXPECT().SendMode().Return(ackhandler.SendAck)
 			sph.EXPECT().ShouldSendNumPackets().Return(1000)
@@ -1059,7 +1095,7 @@ var _ = Describe("Session", func() {
 			sess.packer.packetNumberGenerator.next = 0x1337 + 10
 			sess.packer.hasSentPacket = true // make sure this is not the first packet the packer sends
 			sph = mockackhandler.NewMockSentPacketHandler(mockCtrl)
-			sph.EXPECT().GetPacketNumberLen(gomock.Any()).Return(protocol.PacketNumberLen2).AnyTimes()
+			sph.EXPECT().GetPacketNumberLen(gomock.Any(), gomock.Any()).Return(protocol.PacketNumberLen2).AnyTimes()
 			sess.sentPacketHandler = sph
 			sess.packer.cryptoSetup = &mockCryptoSetup{encLevelSeal: protocol.EncryptionForwardSecure}
 		})
@@ -1204,7 +1240,7 @@ var _ = Describe("Session", func() {
 			sph.EXPECT().TimeUntilSend().AnyTimes()
 			sph.EXPECT().SendMode().Return(ackhandler.SendAny).AnyTimes()
 			sph.EXPECT().ShouldSendNumPackets().AnyTimes().Return(1)
-			sph.EXPECT().GetPacketNumberLen(gomock.Any()).Return(protocol.PacketNumberLen2).AnyTimes()
+			sph.EXPECT().GetPacketNumberLen(gomock.Any(), gomock.Any()).Return(protocol.PacketNumberLen2).AnyTimes()
 			sph.EXPECT().SentPacket(gomock.Any())
 			sess.sentPacketHandler = sph
 
@@ -1352,6 +1388,23 @@ var _ = Describe("Session", func() {
 			Eventually(sess.Context().Done()).Should(BeClosed())
 		})
 
+		It("sends a Public Reset when the timeout expires exactly now", func() {
+			clock := utils.NewVirtualClock(time.Now())
+			sess.clock = clock
+			sess.timer = utils.NewTimerWithClock(clock)
+			sessionRunner.EXPECT().removeConnectionID(gomock.Any())
+			go func() {
+				defer GinkgoRecover()
+				sess.run()
+			}()
+			sendUndecryptablePackets()
+			Eventually(func() time.Time { return sess.receivedTooManyUndecrytablePacketsTime }).Should(Equal(clock.Now()))
+			clock.Advance(protocol.PublicResetTimeout)
+			Eventually(mconn.written).Should(HaveLen(1))
+			Expect(mconn.written).To(Receive(ContainSubstring("PRST")))
+			Eventually(sess.Context().Done()).Should(BeClosed())
+		})
+
 		It("doesn't send a Public Reset if decrypting them succeeded during the timeout", func() {
 			go func() {
 				defer GinkgoRecover()
@@ -1567,6 +1620,28 @@ var _ = Describe("Session", func() {
 			Expect(mconn.written).To(Receive(ContainSubstring("No recent network activity.")))
 		})
 
+		It("fires the loss detection alarm when it expires exactly now", func() {
+			clock := utils.NewVirtualClock(time.Now())
+			sess.clock = clock
+			sess.timer = utils.NewTimerWithClock(clock)
+			testErr := errors.New("alarm fired")
+			sph := mockackhandler.NewMockSentPacketHandler(mockCtrl)
+			sph.EXPECT().GetAlarmTimeout().Return(clock.Now()).AnyTimes()
+			sph.EXPECT().OnAlarm().Return(testErr)
+			sph.EXPECT().TimeUntilSend().AnyTimes()
+			sph.EXPECT().SendMode().Return(ackhandler.SendNone).AnyTimes()
+			sess.sentPacketHandler = sph
+			sessionRunner.EXPECT().removeConnectionID(gomock.Any())
+			done := make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
+				err := sess.run()
+				Expect(err).To(MatchError(testErr))
+				close(done)
+			}()
+			Eventually(done).Should(BeClosed())
+		})
+
 		It("times out due to non-completed handshake", func() {
 			sessionRunner.EXPECT().removeConnectionID(gomock.Any())
 			sess.sessionCreationTime = time.Now().Add(-protocol.DefaultHandshakeTimeout).Add(-time.Second)
@@ -0,0 +1,234 @@
+package quic
+
+import (
+	"crypto/tls"
+
+	"github.com/wheelcomplex/qk/internal/ackhandler"
+	"github.com/wheelcomplex/qk/internal/handshake"
+	"github.com/wheelcomplex/qk/internal/protocol"
+	"github.com/wheelcomplex/qk/internal/record"
+	"github.com/wheelcomplex/qk/internal/utils"
+	"github.com/wheelcomplex/qk/internal/wire"
+	"github.com/wheelcomplex/qk/qerr"
+)
+
+// newSessionV1 creates a new session for QUIC v1.
+// QUIC v1 is only supported with crypto/tls.
+// The initialConnID is the destination connection ID of the first Initial packet, which is used to derive the Initial keys.
+func newSessionV1(
+	conn connection,
+	runner sessionRunner,
+	initialConnID protocol.ConnectionID,
+	destConnID protocol.ConnectionID,
+	srcConnID protocol.ConnectionID,
+	token []byte,
+	conf *Config,
+	tlsConf *tls.Config,
+	extHandler handshake.TLSExtensionHandler,
+	pers protocol.Perspective,
+	logger utils.Logger,
+	v protocol.VersionNumber,
+	hook sessionHook,
+) (quicSession, error) {
+	if hook == nil && conf.RecordWriter != nil {
+		origDestConnID, retrySrcConnID := handshake.GetExpectedConnectionIDsV1(extHandler)
+		recorder, err := newSessionRecorder(conf.RecordWriter, conf.Clock, logger, &record.SessionStarted{
+			Perspective:    pers,
+			Version:        v,
+			InitialConnID:  initialConnID,
+			DestConnID:     destConnID,
+			SrcConnID:      srcConnID,
+			OrigDestConnID: origDestConnID,
+			RetrySrcConnID: retrySrcConnID,
+			Token:          token,
+			LocalAddr:      addrString(conn.LocalAddr()),
+			RemoteAddr:     addrString(conn.RemoteAddr()),
+		})
+		if err != nil {
+			return nil, err
+		}
+		hook = recorder
+	}
+	var wrapTLS handshake.QUICTLSWrapper
+	if hook != nil {
+		conn = hook.wrapConn(conn)
+		wrapTLS = hook.wrapTLS
+	}
+	handshakeEvent := make(chan struct{}, 1)
+	s := &session{
+		conn:           conn,
+		hook:           hook,
+		sessionRunner:  runner,
+		config:         conf,
+		srcConnID:      srcConnID,
+		destConnID:     destConnID,
+		perspective:    pers,
+		version:        v,
+		handshakeEvent: handshakeEvent,
+		paramsChan:     extHandler.GetPeerParams(),
+		logger:         logger,
+	}
+	s.preSetup()
+	s.cryptoStreams = newCryptoStreamManager(s.scheduleSending)
+	var cs handshake.CryptoSetupV1
+	var err error
+	if pers == protocol.PerspectiveServer {
+		cs, err = handshake.NewCryptoSetupV1Server(
+			s.cryptoStreams.initialStream,
+			s.cryptoStreams.handshakeStream,
+			s.cryptoStreams.oneRTTStream,
+			initialConnID,
+			tlsConf,
+			extHandler,
+			handshakeEvent,
+			s.config.KeyUpdateInterval,
+			wrapTLS,
+		)
+	} else {
+		cs, err = handshake.NewCryptoSetupV1Client(
+			s.cryptoStreams.initialStream,
+			s.cryptoStreams.handshakeStream,
+			s.cryptoStreams.oneRTTStream,
+			initialConnID,
+			tlsConf,
+			extHandler,
+			handshakeEvent,
+			s.config.KeyUpdateInterval,
+			wrapTLS,
+		)
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.cryptoStreamHandler = cs
+	s.cryptoSetupV1 = cs
+	s.unpacker = newPacketUnpackerV1(cs, s.version)
+	s.streamsMap = newStreamsMap(s, s.newFlowController, s.config.MaxIncomingStreams, s.config.MaxIncomingUniStreams, s.perspective, s.version)
+	s.streamFramer = newStreamFramer(s.cryptoStream, s.streamsMap, s.version)
+	s.receivedPacketHandlerV1 = ackhandler.NewReceivedPacketHandlerV1(s.rttStats, s.clock, s.logger, s.version)
+	s.packer = newPacketPackerV1(
+		s.destConnID,
+		s.srcConnID,
+		s.sentPacketHandler.GetPacketNumberLen,
+		s.RemoteAddr(),
+		token,
+		cs,
+		s.cryptoStreams,
+		s.receivedPacketHandlerV1,
+		s.streamFramer,
+		s.perspective,
+		s.version,
+	)
+	if hook != nil {
+		s.packer.SetChooseSkip(hook.chooseSkip)
+	}
+	return s, s.postSetup()
+}
+
+// dropInitialKeys discards the Initial keys, and all state associated with the Initial packet number space.
+// The client drops them when it first sends a Handshake packet, the server when it first receives one.
+func (s *session) dropInitialKeys() {
+	if s.droppedInitialKeys {
+		return
+	}
+	s.droppedInitialKeys = true
+	s.logger.Debugf("Dropping Initial keys.")
+	s.cryptoSetupV1.DropInitialKeys()
+	s.sentPacketHandler.DropPackets(protocol.EncryptionInitial)
+	s.receivedPacketHandlerV1.DropPackets(protocol.EncryptionInitial)
+}
+
+// dropHandshakeKeys discards the Handshake keys, and all state associated with the Handshake packet number space.
+// This happens as soon as the handshake is confirmed.
+func (s *session) dropHandshakeKeys() {
+	if s.droppedHandshakeKeys {
+		return
+	}
+	s.droppedHandshakeKeys = true
+	s.logger.Debugf("Dropping Handshake keys.")
+	s.cryptoSetupV1.DropHandshakeKeys()
+	s.sentPacketHandler.DropPackets(protocol.EncryptionHandshake)
+	s.receivedPacketHandlerV1.DropPackets(protocol.EncryptionHandshake)
+}
+
+// confirmHandshakeV1 is called when the handshake is confirmed.
+// For the server, this is the case when the handshake completes.
+// The client confirms the handshake when it receives a HANDSHAKE_DONE frame.
+func (s *session) confirmHandshakeV1() {
+	if s.handshakeConfirmed {
+		return
+	}
+	s.handshakeConfirmed = true
+	s.logger.Debugf("Handshake confirmed.")
+	s.dropInitialKeys()
+	s.dropHandshakeKeys()
+	s.sentPacketHandler.SetHandshakeComplete()
+}
+
+// handleHandshakeCompleteV1 is called when the handshake completes in QUIC v1.
+// The server sends a HANDSHAKE_DONE frame, which confirms the handshake for the client.
+func (s *session) handleHandshakeCompleteV1() {
+	if s.perspective == protocol.PerspectiveServer {
+		s.queueControlFrame(&wire.HandshakeDoneFrame{})
+		s.confirmHandshakeV1()
+	}
+	// packets might have been queued while waiting for the 1-RTT keys
+	s.tryDecryptingQueuedPackets()
+}
+
+// handlePacketEncryptionLevelV1 performs the actions triggered by receiving a packet at a given encryption level.
+func (s *session) handlePacketEncryptionLevelV1(encLevel protocol.EncryptionLevel) {
+	if s.perspective == protocol.PerspectiveServer && encLevel == protocol.EncryptionHandshake {
+		s.dropInitialKeys()
+	}
+}
+
+// checkFrameEncryptionLevelV1 checks that a frame is allowed in a packet of the given encryption level.
+// Initial and Handshake packets can only contain CRYPTO, ACK, PING and CONNECTION_CLOSE frames.
+func checkFrameEncryptionLevelV1(f wire.Frame, encLevel protocol.EncryptionLevel) error {
+	if encLevel == protocol.EncryptionForwardSecure {
+		return nil
+	}
+	switch f.(type) {
+	case *wire.CryptoFrame, *wire.AckFrame, *wire.PingFrame, *wire.ConnectionCloseFrame:
+		return nil
+	}
+	return qerr.Error(qerr.CryptoEncryptionLevelIncorrect, "frame not allowed at "+encLevel.String())
+}
+
+func (s *session) handleHandshakeDoneFrame() error {
+	if s.perspective == protocol.PerspectiveServer {
+		return qerr.Error(qerr.InvalidFrameData, "received a HANDSHAKE_DONE frame")
+	}
+	s.confirmHandshakeV1()
+	return nil
+}
+
+func (s *session) maybeSendAckOnlyPacketV1() error {
+	if ack := s.receivedPacketHandler.GetAckFrame(); ack != nil {
+		s.packer.QueueControlFrame(ack)
+	}
+	packet, err := s.packer.PackAckPacket()
+	if err != nil || packet == nil {
+		return err
+	}
+	s.sentPacketHandler.SentPacket(packet.ToAckHandlerPacket(s.clock.Now()))
+	s.onPacketSentV1(packet)
+	return s.sendPackedPacket(packet)
+}
+
+// onPacketSentV1 is called for every packet sent in QUIC v1.
+// The client drops the Initial keys when it first sends a Handshake packet.
+func (s *session) onPacketSentV1(packet *packedPacket) {
+	if s.perspective == protocol.PerspectiveClient && packet.encryptionLevel == protocol.EncryptionHandshake {
+		s.dropInitialKeys()
+	}
+}
+
+// processTransportParametersV1 checks the connection IDs the peer sent in its transport parameters.
+func (s *session) processTransportParametersV1(params *handshake.TransportParameters) error {
+	if !params.InitialSourceConnectionID.Equal(s.destConnID) {
+		return qerr.Error(qerr.InvalidNegotiatedValue, "initial_source_connection_id doesn't match the source connection ID")
+	}
+	return nil
+}
@@ -2,6 +2,7 @@ package quic
 
 import (
 	"fmt"
+	"sync/atomic"
 
 	"github.com/wheelcomplex/qk/internal/flowcontrol"
 	"github.com/wheelcomplex/qk/internal/handshake"
@@ -20,6 +21,7 @@ const (
 
 type streamsMap struct {
 	perspective protocol.Perspective
+	version     protocol.VersionNumber
 
 	sender            streamSender
 	newFlowController func(protocol.StreamID) flowcontrol.StreamFlowController
@@ -28,6 +30,11 @@ type streamsMap struct {
 	outgoingUniStreams  *outgoingUniStreamsMap
 	incomingBidiStreams *incomingBidiStreamsMap
 	incomingUniStreams  *incomingUniStreamsMap
+
+	// The number of streams we're allowed to open, only used for QUIC v1.
+	// They are accessed atomically, since they are read when opening a stream.
+	numOutgoingBidiStreams uint64
+	numOutgoingUniStreams  uint64
 }
 
 var _ streamManager = &streamsMap{}
@@ -42,17 +49,23 @@ func newStreamsMap(
 ) streamManager {
 	m := &streamsMap{
 		perspective:       perspective,
+		version:           version,
 		newFlowController: newFlowController,
 		sender:            sender,
 	}
 	var firstOutgoingBidiStream, firstOutgoingUniStream, firstIncomingBidiStream, firstIncomingUniStream protocol.StreamID
+	// In QUIC v1, handshake data is sent in CRYPTO frames, so the client can use stream 0.
+	var firstClientBidiStream protocol.StreamID = 4 // the crypto stream is handled separately
+	if version.UsesCryptoFrames() {
+		firstClientBidiStream = 0
+	}
 	if perspective == protocol.PerspectiveServer {
 		firstOutgoingBidiStream = 1
-		firstIncomingBidiStream = 4 // the crypto stream is handled separately
+		firstIncomingBidiStream = firstClientBidiStream
 		firstOutgoingUniStream = 3
 		firstIncomingUniStream = 2
 	} else {
-		firstOutgoingBidiStream = 4 // the crypto stream is handled separately
+		firstOutgoingBidiStream = firstClientBidiStream
 		firstIncomingBidiStream = 1
 		firstOutgoingUniStream = 2
 		firstIncomingUniStream = 3
@@ -69,30 +82,59 @@ func newStreamsMap(
 	m.outgoingBidiStreams = newOutgoingBidiStreamsMap(
 		firstOutgoingBidiStream,
 		newBidiStream,
-		sender.queueControlFrame,
+		m.getQueueControlFrame(protocol.StreamTypeBidi),
 	)
 	m.incomingBidiStreams = newIncomingBidiStreamsMap(
 		firstIncomingBidiStream,
-		protocol.MaxBidiStreamID(maxIncomingStreams, perspective),
+		protocol.MaxBidiStreamID(maxIncomingStreams, perspective, version),
 		maxIncomingStreams,
-		sender.queueControlFrame,
+		m.getQueueControlFrame(protocol.StreamTypeBidi),
 		newBidiStream,
 	)
 	m.outgoingUniStreams = newOutgoingUniStreamsMap(
 		firstOutgoingUniStream,
 		newUniSendStream,
-		sender.queueControlFrame,
+		m.getQueueControlFrame(protocol.StreamTypeUni),
 	)
 	m.incomingUniStreams = newIncomingUniStreamsMap(
 		firstIncomingUniStream,
-		protocol.MaxUniStreamID(maxIncomingUniStreams, perspective),
+		protocol.MaxUniStreamID(maxIncomingUniStreams, perspective, version),
 		maxIncomingUniStreams,
-		sender.queueControlFrame,
+		m.getQueueControlFrame(protocol.StreamTypeUni),
 		newUniReceiveStream,
 	)
 	return m
 }
 
+// getQueueControlFrame returns the function used by the streams maps to queue control frames.
+// QUIC v1 limits the number of streams, instead of the highest stream ID.
+// The MAX_STREAM_ID and STREAM_ID_BLOCKED frames are converted to MAX_STREAMS and STREAMS_BLOCKED frames.
+func (m *streamsMap) getQueueControlFrame(t protocol.StreamType) func(wire.Frame) {
+	if !m.version.UsesV1FrameFormat() {
+		return m.sender.queueControlFrame
+	}
+	numOutgoingStreams := &m.numOutgoingBidiStreams
+	if t == protocol.StreamTypeUni {
+		numOutgoingStreams = &m.numOutgoingUniStreams
+	}
+	return func(f wire.Frame) {
+		switch frame := f.(type) {
+		case *wire.MaxStreamIDFrame:
+			m.sender.queueControlFrame(&wire.MaxStreamsFrame{
+				Type:       t,
+				MaxStreams: uint64(frame.StreamID/4) + 1,
+			})
+		case *wire.StreamIDBlockedFrame:
+			m.sender.queueControlFrame(&wire.StreamsBlockedFrame{
+				Type:        t,
+				StreamLimit: atomic.LoadUint64(numOutgoingStreams),
+			})
+		default:
+			m.sender.queueControlFrame(f)
+		}
+	}
+}
+
 func (m *streamsMap) getStreamType(id protocol.StreamID) streamType {
 	if m.perspective == protocol.PerspectiveServer {
 		switch id % 4 {
@@ -205,15 +247,44 @@ func (m *streamsMap) HandleMaxStreamIDFrame(f *wire.MaxStreamIDFrame) error {
 	}
 }
 
+func (m *streamsMap) HandleMaxStreamsFrame(f *wire.MaxStreamsFrame) error {
+	m.setMaxStreams(f.Type, f.MaxStreams)
+	return nil
+}
+
 func (m *streamsMap) UpdateLimits(p *handshake.TransportParameters) {
+	if m.version.UsesV1FrameFormat() {
+		m.setMaxStreams(protocol.StreamTypeBidi, uint64(p.MaxBidiStreams))
+		m.setMaxStreams(protocol.StreamTypeUni, uint64(p.MaxUniStreams))
+		return
+	}
 	// Max{Uni,Bidi}StreamID returns the highest stream ID that the peer is allowed to open.
 	// Invert the perspective to determine the value that we are allowed to open.
-	peerPers := protocol.PerspectiveServer
-	if m.perspective == protocol.PerspectiveServer {
-		peerPers = protocol.PerspectiveClient
+	peerPers := m.perspective.Opposite()
+	m.outgoingBidiStreams.SetMaxStream(protocol.MaxBidiStreamID(int(p.MaxBidiStreams), peerPers, m.version))
+	m.outgoingUniStreams.SetMaxStream(protocol.MaxUniStreamID(int(p.MaxUniStreams), peerPers, m.version))
+}
+
+// setMaxStreams sets the number of streams of a stream type that we're allowed to open.
+// It is only used for QUIC v1.
+func (m *streamsMap) setMaxStreams(t protocol.StreamType, num uint64) {
+	// In QUIC v1, the stream ID for 0 streams would be the same as for 1 stream.
+	// Since we start with a limit of 0 streams, there's nothing to do.
+	if num == 0 {
+		return
+	}
+	peerPers := m.perspective.Opposite()
+	if t == protocol.StreamTypeUni {
+		if num > atomic.LoadUint64(&m.numOutgoingUniStreams) {
+			atomic.StoreUint64(&m.numOutgoingUniStreams, num)
+		}
+		m.outgoingUniStreams.SetMaxStream(protocol.MaxUniStreamID(int(num), peerPers, m.version))
+		return
+	}
+	if num > atomic.LoadUint64(&m.numOutgoingBidiStreams) {
+		atomic.StoreUint64(&m.numOutgoingBidiStreams, num)
 	}
-	m.outgoingBidiStreams.SetMaxStream(protocol.MaxBidiStreamID(int(p.MaxBidiStreams), peerPers))
-	m.outgoingUniStreams.SetMaxStream(protocol.MaxUniStreamID(int(p.MaxUniStreams), peerPers))
+	m.outgoingBidiStreams.SetMaxStream(protocol.MaxBidiStreamID(int(num), peerPers, m.version))
 }
 
 func (m *streamsMap) CloseWithError(err error) {
@@ -18,10 +18,10 @@ type incomingUniStreamsMap struct {
 
 	streams map[protocol.StreamID]receiveStreamI
 
-	nextStream    protocol.StreamID // the next stream that will be returned by AcceptStream()
-	highestStream protocol.StreamID // the highest stream that the peer openend
-	maxStream     protocol.StreamID // the highest stream that the peer is allowed to open
-	maxNumStreams int               // maximum number of streams
+	nextStream       protocol.StreamID // the next stream that will be returned by AcceptStream()
+	nextStreamToOpen protocol.StreamID // the next stream that the peer will open
+	maxStream        protocol.StreamID // the highest stream that the peer is allowed to open
+	maxNumStreams    int               // maximum number of streams
 
 	newStream        func(protocol.StreamID) receiveStreamI
 	queueMaxStreamID func(*wire.MaxStreamIDFrame)
@@ -39,6 +39,7 @@ func newIncomingUniStreamsMap(
 	m := &incomingUniStreamsMap{
 		streams:          make(map[protocol.StreamID]receiveStreamI),
 		nextStream:       nextStream,
+		nextStreamToOpen: nextStream,
 		maxStream:        initialMaxStreamID,
 		maxNumStreams:    maxNumStreams,
 		newStream:        newStream,
@@ -70,14 +71,16 @@ func (m *incomingUniStreamsMap) AcceptStream() (receiveStreamI, error) {
 
 func (m *incomingUniStreamsMap) GetOrOpenStream(id protocol.StreamID) (receiveStreamI, error) {
 	m.mutex.RLock()
-	if id > m.maxStream {
+	// If no streams are allowed, the maxStream can't be used to determine the limit,
+	// since in QUIC v1, the first stream has the stream ID 0.
+	if id > m.maxStream || m.maxNumStreams == 0 {
 		m.mutex.RUnlock()
 		return nil, fmt.Errorf("peer tried to open stream %d (current limit: %d)", id, m.maxStream)
 	}
-	// if the id is smaller than the highest we accepted
+	// if the id is smaller than the next stream the peer will open
 	// * this stream exists in the map, and we can return it, or
 	// * this stream was already closed, then we can return the nil
-	if id <= m.highestStream {
+	if id < m.nextStreamToOpen {
 		s := m.streams[id]
 		m.mutex.RUnlock()
 		return s, nil
@@ -87,18 +90,12 @@ func (m *incomingUniStreamsMap) GetOrOpenStream(id protocol.StreamID) (receiveSt
 	m.mutex.Lock()
 	// no need to check the two error conditions from above again
 	// * maxStream can only increase, so if the id was valid before, it definitely is valid now
-	// * highestStream is only modified by this function
-	var start protocol.StreamID
-	if m.highestStream == 0 {
-		start = m.nextStream
-	} else {
-		start = m.highestStream + 4
-	}
-	for newID := start; newID <= id; newID += 4 {
+	// * nextStreamToOpen is only modified by this function
+	for newID := m.nextStreamToOpen; newID <= id; newID += 4 {
 		m.streams[newID] = m.newStream(newID)
 		m.cond.Signal()
 	}
-	m.highestStream = id
+	m.nextStreamToOpen = id + 4
 	s := m.streams[id]
 	m.mutex.Unlock()
 	return s, nil
@@ -114,7 +111,8 @@ func (m *incomingUniStreamsMap) DeleteStream(id protocol.StreamID) error {
 	delete(m.streams, id)
 	// queue a MAX_STREAM_ID frame, giving the peer the option to open a new stream
 	if numNewStreams := m.maxNumStreams - len(m.streams); numNewStreams > 0 {
-		m.maxStream = m.highestStream + protocol.StreamID(numNewStreams*4)
+		// the peer already opened all streams up to nextStreamToOpen - 4
+		m.maxStream = m.nextStreamToOpen + protocol.StreamID((numNewStreams-1)*4)
 		m.queueMaxStreamID(&wire.MaxStreamIDFrame{StreamID: m.maxStream})
 	}
 	return nil
@@ -275,3 +275,8 @@ func (m *streamsMapLegacy) UpdateLimits(params *handshake.TransportParameters) {
 func (m *streamsMapLegacy) HandleMaxStreamIDFrame(f *wire.MaxStreamIDFrame) error {
 	return errors.New("gQUIC doesn't have MAX_STREAM_ID frames")
 }
+
+// should never be called, since MAX_STREAMS frames can only be unpacked for QUIC v1
+func (m *streamsMapLegacy) HandleMaxStreamsFrame(f *wire.MaxStreamsFrame) error {
+	return errors.New("gQUIC doesn't have MAX_STREAMS frames")
+}
@@ -21,6 +21,7 @@ type outgoingBidiStreamsMap struct {
 
 	nextStream     protocol.StreamID // stream ID of the stream returned by OpenStream(Sync)
 	maxStream      protocol.StreamID // the maximum stream ID we're allowed to open
+	maxStreamSet   bool              // was the maximum stream ID set, needed since the first stream ID can be 0 in QUIC v1
 	highestBlocked protocol.StreamID // the highest stream ID that we queued a STREAM_ID_BLOCKED frame for
 
 	newStream            func(protocol.StreamID) streamI
@@ -71,7 +72,7 @@ func (m *outgoingBidiStreamsMap) openStreamImpl() (streamI, error) {
 	if m.closeErr != nil {
 		return nil, m.closeErr
 	}
-	if m.nextStream > m.maxStream {
+	if !m.maxStreamSet || m.nextStream > m.maxStream {
 		if m.maxStream == 0 || m.highestBlocked < m.maxStream {
 			m.queueStreamIDBlocked(&wire.StreamIDBlockedFrame{StreamID: m.maxStream})
 			m.highestBlocked = m.maxStream
@@ -108,8 +109,9 @@ func (m *outgoingBidiStreamsMap) DeleteStream(id protocol.StreamID) error {
 
 func (m *outgoingBidiStreamsMap) SetMaxStream(id protocol.StreamID) {
 	m.mutex.Lock()
-	if id > m.maxStream {
+	if !m.maxStreamSet || id > m.maxStream {
 		m.maxStream = id
+		m.maxStreamSet = true
 		m.cond.Broadcast()
 	}
 	m.mutex.Unlock()
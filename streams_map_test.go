@@ -333,7 +333,7 @@ var _ = Describe("Streams Map (for IETF QUIC)", func() {
 					_, err := m.GetOrOpenReceiveStream(ids.firstIncomingBidiStream + 4*10)
 					Expect(err).ToNot(HaveOccurred())
 					mockSender.EXPECT().queueControlFrame(&wire.MaxStreamIDFrame{
-						StreamID: protocol.MaxBidiStreamID(maxBidiStreams, perspective) + 4,
+						StreamID: protocol.MaxBidiStreamID(maxBidiStreams, perspective, versionIETFFrames) + 4,
 					})
 					Expect(m.DeleteStream(ids.firstIncomingBidiStream)).To(Succeed())
 				})
@@ -342,7 +342,7 @@ var _ = Describe("Streams Map (for IETF QUIC)", func() {
 					_, err := m.GetOrOpenReceiveStream(ids.firstIncomingUniStream + 4*10)
 					Expect(err).ToNot(HaveOccurred())
 					mockSender.EXPECT().queueControlFrame(&wire.MaxStreamIDFrame{
-						StreamID: protocol.MaxUniStreamID(maxUniStreams, perspective) + 4,
+						StreamID: protocol.MaxUniStreamID(maxUniStreams, perspective, versionIETFFrames) + 4,
 					})
 					Expect(m.DeleteStream(ids.firstIncomingUniStream)).To(Succeed())
 				})
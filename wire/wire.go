@@ -0,0 +1,179 @@
+// Package wire exposes the parsers for the QUIC wire format used by qk.
+//
+// It is meant for tools that inspect captured packets, like cmd/qkdump.
+// Sessions don't use this package, they use the internal parsers directly.
+// Packets are parsed in two steps: ParseInvariantHeader parses the version independent part of the header,
+// and InvariantHeader.Parse the rest of it, once the version and the sender of the packet are known.
+// The payload has to be decrypted before its frames can be parsed.
+package wire
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/wheelcomplex/qk/internal/handshake"
+	"github.com/wheelcomplex/qk/internal/protocol"
+	"github.com/wheelcomplex/qk/internal/utils"
+	"github.com/wheelcomplex/qk/internal/wire"
+)
+
+// A VersionNumber is a QUIC version number.
+type VersionNumber = protocol.VersionNumber
+
+// A Perspective determines if a packet was sent by the client or by the server.
+type Perspective = protocol.Perspective
+
+// The perspectives
+const (
+	PerspectiveServer = protocol.PerspectiveServer
+	PerspectiveClient = protocol.PerspectiveClient
+)
+
+// A ConnectionID is a QUIC connection ID.
+type ConnectionID = protocol.ConnectionID
+
+// A PacketNumber is a QUIC packet number.
+type PacketNumber = protocol.PacketNumber
+
+// A PacketType is the type of a Long Header packet.
+type PacketType = protocol.PacketType
+
+// The InvariantHeader is the version independent part of the header.
+type InvariantHeader = wire.InvariantHeader
+
+// The Header is the header of a QUIC packet.
+type Header = wire.Header
+
+// A Frame is a QUIC frame.
+type Frame = wire.Frame
+
+// The frames
+type (
+	AckFrame                = wire.AckFrame
+	AckRange                = wire.AckRange
+	BlockedFrame            = wire.BlockedFrame
+	ConnectionCloseFrame    = wire.ConnectionCloseFrame
+	CryptoFrame             = wire.CryptoFrame
+	GoawayFrame             = wire.GoawayFrame
+	HandshakeDoneFrame      = wire.HandshakeDoneFrame
+	MaxDataFrame            = wire.MaxDataFrame
+	MaxStreamDataFrame      = wire.MaxStreamDataFrame
+	MaxStreamIDFrame        = wire.MaxStreamIDFrame
+	MaxStreamsFrame         = wire.MaxStreamsFrame
+	NewConnectionIDFrame    = wire.NewConnectionIDFrame
+	NewTokenFrame           = wire.NewTokenFrame
+	PathChallengeFrame      = wire.PathChallengeFrame
+	PathResponseFrame       = wire.PathResponseFrame
+	PingFrame               = wire.PingFrame
+	RetireConnectionIDFrame = wire.RetireConnectionIDFrame
+	RstStreamFrame          = wire.RstStreamFrame
+	StopSendingFrame        = wire.StopSendingFrame
+	StopWaitingFrame        = wire.StopWaitingFrame
+	StreamBlockedFrame      = wire.StreamBlockedFrame
+	StreamFrame             = wire.StreamFrame
+	StreamIDBlockedFrame    = wire.StreamIDBlockedFrame
+	StreamsBlockedFrame     = wire.StreamsBlockedFrame
+)
+
+// A HandshakeMessage is a message of the gQUIC crypto handshake, e.g. a CHLO.
+type HandshakeMessage = handshake.HandshakeMessage
+
+// ParseInvariantHeader parses the version independent part of the header.
+// shortHeaderConnIDLen is the length of the connection ID used in Short Header packets,
+// which can't be determined from the packet itself.
+func ParseInvariantHeader(b *bytes.Reader, shortHeaderConnIDLen int) (*InvariantHeader, error) {
+	return wire.ParseInvariantHeader(b, shortHeaderConnIDLen)
+}
+
+// ParseHeader parses the header of a packet sent by sentBy, using the version v.
+// It sets the Raw field of the header.
+// For versions that use header protection, the packet number is not set.
+// It can only be read after removing header protection.
+func ParseHeader(data []byte, sentBy Perspective, v VersionNumber, shortHeaderConnIDLen int) (*Header, error) {
+	r := bytes.NewReader(data)
+	iHdr, err := ParseInvariantHeader(r, shortHeaderConnIDLen)
+	if err != nil {
+		return nil, err
+	}
+	hdr, err := iHdr.Parse(r, sentBy, v)
+	if err != nil {
+		return nil, err
+	}
+	hdr.Raw = data[:len(data)-r.Len()]
+	return hdr, nil
+}
+
+// ParseNextFrame parses the next frame of a decrypted payload.
+// It returns a nil frame if there are no more frames to parse.
+func ParseNextFrame(r *bytes.Reader, hdr *Header, v VersionNumber) (Frame, error) {
+	return wire.ParseNextFrame(r, hdr, v)
+}
+
+// ParseFrames parses all frames of a decrypted payload.
+// Frames that were parsed before an error occurred are returned together with the error.
+func ParseFrames(data []byte, hdr *Header, v VersionNumber) ([]Frame, error) {
+	r := bytes.NewReader(data)
+	var fs []Frame
+	for {
+		frame, err := ParseNextFrame(r, hdr, v)
+		if err != nil {
+			return fs, err
+		}
+		if frame == nil {
+			return fs, nil
+		}
+		fs = append(fs, frame)
+	}
+}
+
+// ParseHandshakeMessage parses a message of the gQUIC crypto handshake.
+// It returns io.EOF or io.ErrUnexpectedEOF if the message is incomplete.
+func ParseHandshakeMessage(r io.Reader) (HandshakeMessage, error) {
+	return handshake.ParseHandshakeMessage(r)
+}
+
+// FormatHeader formats a header the same way qk logs it.
+func FormatHeader(hdr *Header) string {
+	l := &stringLogger{}
+	hdr.Log(l)
+	return l.String()
+}
+
+// FormatFrame formats a frame the same way qk logs it.
+// sentByClient determines the arrow used: -> for frames sent by the client, <- for frames sent by the server.
+func FormatFrame(f Frame, sentByClient bool) string {
+	l := &stringLogger{}
+	wire.LogFrame(l, f, sentByClient)
+	return l.String()
+}
+
+// The stringLogger collects the debug messages logged by the internal wire package.
+// The leading tab used for indentation in the session's logs is removed.
+type stringLogger struct {
+	buf bytes.Buffer
+}
+
+var _ utils.Logger = &stringLogger{}
+
+func (l *stringLogger) SetLogLevel(utils.LogLevel)     {}
+func (l *stringLogger) SetLogTimeFormat(string)        {}
+func (l *stringLogger) WithPrefix(string) utils.Logger { return l }
+func (l *stringLogger) Debug() bool                    { return true }
+func (l *stringLogger) Errorf(string, ...interface{})  {}
+func (l *stringLogger) Infof(string, ...interface{})   {}
+func (l *stringLogger) Debugf(format string, args ...interface{}) {
+	if l.buf.Len() > 0 {
+		l.buf.WriteByte('\n')
+	}
+	l.buf.WriteString(trimTab(fmt.Sprintf(format, args...)))
+}
+
+func (l *stringLogger) String() string { return l.buf.String() }
+
+func trimTab(s string) string {
+	if len(s) > 0 && s[0] == '\t' {
+		return s[1:]
+	}
+	return s
+}
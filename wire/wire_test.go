@@ -0,0 +1,92 @@
+package wire
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/wheelcomplex/qk/internal/handshake"
+	"github.com/wheelcomplex/qk/internal/protocol"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Wire", func() {
+	It("parses a gQUIC Public Header", func() {
+		hdr := &Header{
+			IsPublicHeader:   true,
+			VersionFlag:      true,
+			Version:          protocol.Version43,
+			DestConnectionID: ConnectionID{1, 2, 3, 4, 5, 6, 7, 8},
+			PacketNumber:     0x42,
+			PacketNumberLen:  protocol.PacketNumberLen2,
+		}
+		b := &bytes.Buffer{}
+		Expect(hdr.Write(b, PerspectiveClient, protocol.Version43)).To(Succeed())
+		hdrLen := b.Len()
+		b.Write([]byte("payload"))
+		parsed, err := ParseHeader(b.Bytes(), PerspectiveClient, protocol.VersionWhatever, 0)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(parsed.IsPublicHeader).To(BeTrue())
+		Expect(parsed.Version).To(Equal(protocol.Version43))
+		Expect(parsed.DestConnectionID).To(Equal(hdr.DestConnectionID))
+		Expect(parsed.PacketNumber).To(Equal(PacketNumber(0x42)))
+		Expect(parsed.Raw).To(Equal(b.Bytes()[:hdrLen]))
+		Expect(FormatHeader(parsed)).To(HavePrefix("Public Header{ConnectionID: 0x0102030405060708, PacketNumber: 0x42"))
+	})
+
+	It("parses a QUIC v1 Short Header", func() {
+		data := []byte{0x40, 0xde, 0xad, 0xbe, 0xef, 0x1, 0x2}
+		parsed, err := ParseHeader(data, PerspectiveServer, protocol.Version1, 4)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(parsed.IsLongHeader).To(BeFalse())
+		Expect(parsed.DestConnectionID).To(Equal(ConnectionID{0xde, 0xad, 0xbe, 0xef}))
+		Expect(parsed.Raw).To(Equal(data[:5]))
+	})
+
+	It("errors on invalid headers", func() {
+		_, err := ParseHeader([]byte{0xc0, 0, 0, 0, 1}, PerspectiveClient, protocol.Version1, 0)
+		Expect(err).To(MatchError(io.EOF))
+	})
+
+	It("parses frames", func() {
+		b := &bytes.Buffer{}
+		frames := []Frame{
+			&PingFrame{},
+			&MaxDataFrame{ByteOffset: 0x1337},
+			&StreamFrame{StreamID: 4, Offset: 0x100, DataLenPresent: true, Data: []byte("foobar")},
+		}
+		for _, f := range frames {
+			Expect(f.Write(b, protocol.Version1)).To(Succeed())
+		}
+		b.Write([]byte{0, 0, 0}) // padding
+		fs, err := ParseFrames(b.Bytes(), &Header{}, protocol.Version1)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(fs).To(Equal(frames))
+		Expect(FormatFrame(fs[2], true)).To(Equal("-> &wire.StreamFrame{StreamID: 4, FinBit: false, Offset: 0x100, Data length: 0x6, Offset + Data length: 0x106}"))
+		Expect(FormatFrame(fs[0], false)).To(Equal("<- &wire.PingFrame{}"))
+	})
+
+	It("returns the frames parsed before an error", func() {
+		b := &bytes.Buffer{}
+		Expect((&PingFrame{}).Write(b, protocol.Version1)).To(Succeed())
+		b.WriteByte(0x42) // invalid frame type
+		fs, err := ParseFrames(b.Bytes(), &Header{}, protocol.Version1)
+		Expect(err).To(HaveOccurred())
+		Expect(fs).To(Equal([]Frame{&PingFrame{}}))
+	})
+
+	It("parses handshake messages", func() {
+		msg := HandshakeMessage{
+			Tag:  handshake.TagCHLO,
+			Data: map[handshake.Tag][]byte{handshake.TagSNI: []byte("quic.clemente.io")},
+		}
+		b := &bytes.Buffer{}
+		msg.Write(b)
+		parsed, err := ParseHandshakeMessage(bytes.NewReader(b.Bytes()))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(parsed).To(Equal(msg))
+		_, err = ParseHandshakeMessage(bytes.NewReader(b.Bytes()[:b.Len()-1]))
+		Expect(err).To(MatchError(io.ErrUnexpectedEOF))
+	})
+})